@@ -10,6 +10,7 @@ import (
 
 	"github.com/YannKr/downloadonce/internal/app"
 	"github.com/YannKr/downloadonce/internal/config"
+	"github.com/YannKr/downloadonce/internal/logging"
 )
 
 // version is set at build time via -ldflags "-X main.version=v1.2.3".
@@ -22,6 +23,7 @@ func main() {
 	}
 
 	cfg := config.Load()
+	cfg.Version = version
 
 	level := slog.LevelInfo
 	switch cfg.LogLevel {
@@ -32,7 +34,7 @@ func main() {
 	case "error":
 		level = slog.LevelError
 	}
-	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level})))
+	slog.SetDefault(slog.New(logging.NewHandler(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level}))))
 	slog.Info("downloadonce", "version", version)
 
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
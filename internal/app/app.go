@@ -3,11 +3,13 @@ package app
 import (
 	"context"
 	"errors"
+	"fmt"
 	"io/fs"
 	"log/slog"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	downloadonce "github.com/YannKr/downloadonce"
@@ -16,8 +18,12 @@ import (
 	"github.com/YannKr/downloadonce/internal/db"
 	"github.com/YannKr/downloadonce/internal/diskstat"
 	"github.com/YannKr/downloadonce/internal/email"
+	"github.com/YannKr/downloadonce/internal/events"
+	"github.com/YannKr/downloadonce/internal/geoip"
 	"github.com/YannKr/downloadonce/internal/handler"
 	"github.com/YannKr/downloadonce/internal/sse"
+	"github.com/YannKr/downloadonce/internal/watermark"
+	"github.com/YannKr/downloadonce/internal/watermark/jpegenc"
 	"github.com/YannKr/downloadonce/internal/webhook"
 	"github.com/YannKr/downloadonce/internal/worker"
 )
@@ -29,6 +35,34 @@ func Run(ctx context.Context, cfg *config.Config) error {
 		}
 	}
 
+	sub, err := jpegenc.ParseSubsampling(cfg.WMJPEGSubsampling)
+	if err != nil {
+		return err
+	}
+	watermark.JPEGSubsampling = sub
+	if _, err := watermark.NewInvisibleParams(cfg.WMChannels, cfg.WMScale); err != nil {
+		return err
+	}
+	if _, err := watermark.NewFrameSampling(cfg.WMVideoFrames, cfg.WMVideoFrameSampling); err != nil {
+		return err
+	}
+	backoff, err := webhook.ParseBackoff(cfg.WebhookRetrySchedule)
+	if err != nil {
+		return err
+	}
+	if cfg.WebhookSignatureVersion, err = webhook.ParseSignatureVersion(cfg.WebhookSignatureVersion); err != nil {
+		return err
+	}
+	if cfg.RecipientEmailCheck, err = handler.ParseEmailValidation(cfg.RecipientEmailCheck); err != nil {
+		return err
+	}
+	if _, err := watermark.ParseUploadTypes(cfg.AllowedUploadTypes); err != nil {
+		return err
+	}
+	if cfg.WMLowChroma, err = watermark.ParseLowChroma(cfg.WMLowChroma); err != nil {
+		return err
+	}
+
 	scriptsDir, err := extractScripts()
 	if err != nil {
 		return err
@@ -54,34 +88,74 @@ func Run(ctx context.Context, cfg *config.Config) error {
 		User: cfg.SMTPUser,
 		Pass: cfg.SMTPPass,
 		From: cfg.SMTPFrom,
+
+		ReplyTo:      cfg.SMTPReplyTo,
+		EnvelopeFrom: cfg.SMTPEnvelopeFrom,
+		Concurrency:  cfg.SMTPConcurrency,
+	}
+	for _, addr := range strings.Split(cfg.SMTPBCC, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			mailer.BCC = append(mailer.BCC, addr)
+		}
 	}
 	if mailer.Enabled() {
+		if err := mailer.Validate(); err != nil {
+			return err
+		}
 		slog.Info("email enabled", "host", cfg.SMTPHost, "from", cfg.SMTPFrom)
 	}
+	mailer.Templates, err = email.LoadTemplates(cfg.EmailTemplateDir)
+	if err != nil {
+		return fmt.Errorf("load email templates: %w", err)
+	}
+	if cfg.EmailTemplateDir != "" {
+		slog.Info("email templates loaded", "dir", cfg.EmailTemplateDir, "overrides", mailer.Templates.Overridden)
+	}
 
-	webhookDispatcher := &webhook.Dispatcher{DB: database}
+	webhookDispatcher := &webhook.Dispatcher{DB: database, SignatureVersion: cfg.WebhookSignatureVersion, Backoff: backoff}
+
+	sseHub := sse.New()
+	pool := worker.NewPool(database, cfg, mailer, webhookDispatcher, sseHub)
 
 	cleaner := &cleanup.Cleaner{
-		DB:       database,
-		DataDir:  cfg.DataDir,
-		Interval: time.Duration(cfg.CleanupIntervalMins) * time.Minute,
+		DB:              database,
+		DataDir:         cfg.DataDir,
+		Interval:        time.Duration(cfg.CleanupIntervalMins) * time.Minute,
+		APIKeyRetention: time.Duration(cfg.APIKeyExpiredRetentionDays) * 24 * time.Hour,
+		DeleteGrace:     time.Duration(cfg.DeleteGraceDays) * 24 * time.Hour,
+		EventRetention:  time.Duration(cfg.DownloadEventRetentionDays) * 24 * time.Hour,
+		AuditRetention:  time.Duration(cfg.AuditLogRetentionDays) * 24 * time.Hour,
+		OrphanInterval:  time.Duration(cfg.OrphanSweepHours) * time.Hour,
+		OrphanGrace:     time.Duration(cfg.OrphanGraceHours) * time.Hour,
+		OrphanDryRun:    cfg.OrphanDryRun,
+		OnAssetPurged:   pool.ForgetAsset,
 	}
 	cleaner.Start(ctx)
 	defer cleaner.Stop()
 
-	sseHub := sse.New()
+	diskCache := diskstat.New(cfg.DataDir, 60*time.Second)
+	diskCache.Start()
+	defer diskCache.Stop()
 
-	pool := worker.NewPool(database, cfg, mailer, webhookDispatcher, sseHub)
+	pool.DiskCache = diskCache
 	pool.Start(ctx)
 	defer pool.Stop()
 
-	retrier := &webhook.Retrier{DB: database, Interval: 30 * time.Second}
+	retrier := &webhook.Retrier{DB: database, Interval: 30 * time.Second, SignatureVersion: cfg.WebhookSignatureVersion, Backoff: backoff}
 	retrier.Start(ctx)
 
 	templateFS, err := fs.Sub(downloadonce.TemplateFS, "templates")
 	if err != nil {
 		return err
 	}
+	if cfg.DevMode {
+		if st, err := os.Stat("templates"); err == nil && st.IsDir() {
+			templateFS = os.DirFS("templates")
+			slog.Warn("dev mode: templates are read from ./templates and re-parsed on every render")
+		} else {
+			slog.Warn("dev mode: ./templates not found, re-parsing embedded templates")
+		}
+	}
 
 	staticFS, err := fs.Sub(downloadonce.StaticFS, "static")
 	if err != nil {
@@ -91,12 +165,38 @@ func Run(ctx context.Context, cfg *config.Config) error {
 	authRL := handler.NewRateLimiter(5.0/60.0, 5)
 	defer authRL.Stop()
 
-	diskCache := diskstat.New(cfg.DataDir, 60*time.Second)
-	diskCache.Start()
-	defer diskCache.Stop()
-
 	h := handler.New(database, cfg, templateFS, mailer, webhookDispatcher, sseHub)
 	h.DiskCache = diskCache
+	h.Workers = pool
+	h.Migrations = downloadonce.MigrationFS
+	if h.CORS, err = handler.ParseCORS(cfg); err != nil {
+		return err
+	}
+	if cfg.GeoIPDBPath != "" {
+		geo, err := geoip.Open(cfg.GeoIPDBPath)
+		if err != nil {
+			slog.Warn("geoip database unavailable, download locations disabled", "path", cfg.GeoIPDBPath, "error", err)
+		} else {
+			defer geo.Close()
+			h.GeoIP = geo
+			slog.Info("geoip enabled", "path", cfg.GeoIPDBPath)
+		}
+	}
+	if cfg.AsyncDownloadEvents {
+		eventWriter := events.NewWriter(database, 0)
+		eventWriter.Start()
+		defer eventWriter.Stop()
+		h.Events = eventWriter
+		slog.Info("async download event writer enabled")
+	}
+	if mailer.Enabled() && cfg.NotifyDigestMins > 0 {
+		digest := email.NewDigester(mailer, time.Duration(cfg.NotifyDigestMins)*time.Minute)
+		digest.Start()
+		defer digest.Stop()
+		h.Digest = digest
+		slog.Info("download notification digests enabled", "interval_mins", cfg.NotifyDigestMins)
+	}
+	h.StartScheduler(ctx, 30*time.Second)
 	router := h.Routes(staticFS, authRL)
 
 	srv := &http.Server{
@@ -0,0 +1,47 @@
+package auth
+
+import (
+	"encoding/base64"
+	"net/http"
+	"strings"
+)
+
+const FlashCookieName = "downloadonce_flash"
+
+// SetFlashCookie stores a one-shot UI message. The message is base64-encoded
+// and signed with secret so clients can't inject their own flash text.
+func SetFlashCookie(w http.ResponseWriter, message, secret string) {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(message))
+	http.SetCookie(w, &http.Cookie{
+		Name:     FlashCookieName,
+		Value:    payload + "." + sign(payload, secret),
+		Path:     "/",
+		MaxAge:   10,
+		HttpOnly: true,
+	})
+}
+
+// PopFlash returns the pending flash message and clears the cookie. Missing,
+// malformed or tampered cookies yield "".
+func PopFlash(w http.ResponseWriter, r *http.Request, secret string) string {
+	c, err := r.Cookie(FlashCookieName)
+	if err != nil {
+		return ""
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     FlashCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+	payload, sig, ok := strings.Cut(c.Value, ".")
+	if !ok || !hmacEqual(sign(payload, secret), sig) {
+		return ""
+	}
+	msg, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return ""
+	}
+	return string(msg)
+}
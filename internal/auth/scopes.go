@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// API key scopes. Each scope implies the ones below it: admin > write > read.
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+	ScopeAdmin = "admin"
+)
+
+const ScopesKey contextKey = "scopes"
+const APIKeyIDKey contextKey = "api_key_id"
+
+var scopeRank = map[string]int{ScopeRead: 1, ScopeWrite: 2, ScopeAdmin: 3}
+
+// ValidScope reports whether s is a known scope name.
+func ValidScope(s string) bool {
+	_, ok := scopeRank[s]
+	return ok
+}
+
+// ParseScopes splits a comma-separated scope list, dropping unknown entries.
+func ParseScopes(s string) []string {
+	var scopes []string
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if ValidScope(part) {
+			scopes = append(scopes, part)
+		}
+	}
+	return scopes
+}
+
+// ContextWithScopes records the scopes granted to the API key that
+// authenticated the request.
+func ContextWithScopes(ctx context.Context, scopes []string) context.Context {
+	return context.WithValue(ctx, ScopesKey, scopes)
+}
+
+// ContextWithAPIKey records which API key authenticated the request.
+func ContextWithAPIKey(ctx context.Context, keyID string) context.Context {
+	return context.WithValue(ctx, APIKeyIDKey, keyID)
+}
+
+// APIKeyFromContext returns the authenticating API key's ID, or "" for
+// session-authenticated requests.
+func APIKeyFromContext(ctx context.Context) string {
+	v, _ := ctx.Value(APIKeyIDKey).(string)
+	return v
+}
+
+// HasScope reports whether the request may act with the given scope.
+// Requests without scopes in context (browser sessions) are unrestricted.
+func HasScope(ctx context.Context, scope string) bool {
+	granted, ok := ctx.Value(ScopesKey).([]string)
+	if !ok {
+		return true
+	}
+	need := scopeRank[scope]
+	for _, g := range granted {
+		if scopeRank[g] >= need {
+			return true
+		}
+	}
+	return false
+}
+
+// RequireScope rejects API callers whose key lacks scope with a JSON 403.
+func RequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !HasScope(r.Context(), scope) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusForbidden)
+				json.NewEncoder(w).Encode(map[string]string{
+					"error": "API key lacks the required \"" + scope + "\" scope",
+					"code":  "INSUFFICIENT_SCOPE",
+				})
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
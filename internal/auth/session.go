@@ -55,7 +55,7 @@ func GetSessionID(r *http.Request, secret string) (string, bool) {
 	}
 	sessionID, sig := parts[0], parts[1]
 	expected := sign(sessionID, secret)
-	if !hmac.Equal([]byte(expected), []byte(sig)) {
+	if !hmacEqual(expected, sig) {
 		return "", false
 	}
 	return sessionID, true
@@ -96,3 +96,7 @@ func sign(data, secret string) string {
 	mac.Write([]byte(data))
 	return hex.EncodeToString(mac.Sum(nil))
 }
+
+func hmacEqual(a, b string) bool {
+	return hmac.Equal([]byte(a), []byte(b))
+}
@@ -0,0 +1,165 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+)
+
+const (
+	TOTPIssuer = "DownloadOnce"
+	// TOTPSkew is how many 30-second steps either side of now a code is
+	// accepted for, to tolerate clock drift on the authenticator.
+	TOTPSkew   = 1
+	totpPeriod = 30
+
+	PendingLoginCookieName = "downloadonce_2fa"
+	PendingLoginMaxAge     = 5 * time.Minute
+
+	RecoveryCodeCount = 10
+)
+
+// GenerateTOTPSecret creates a new base32 TOTP secret for accountName.
+func GenerateTOTPSecret(accountName string) (string, error) {
+	key, err := totp.Generate(totp.GenerateOpts{Issuer: TOTPIssuer, AccountName: accountName})
+	if err != nil {
+		return "", err
+	}
+	return key.Secret(), nil
+}
+
+// TOTPURL returns the otpauth:// URL that authenticator apps scan to add
+// secret for accountName.
+func TOTPURL(accountName, secret string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", TOTPIssuer)
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + TOTPIssuer + ":" + accountName,
+		RawQuery: v.Encode(),
+	}
+	return u.String()
+}
+
+// ValidateTOTP reports whether code is valid for secret now, allowing
+// TOTPSkew steps of clock drift.
+func ValidateTOTP(code, secret string) bool {
+	_, ok := validateTOTPAt(code, secret, time.Now(), 0)
+	return ok
+}
+
+// ValidateTOTPAfter is ValidateTOTP for logins: it returns the time step the
+// code belongs to and refuses steps at or before lastStep, so a code that was
+// already accepted can't be replayed within the skew window.
+func ValidateTOTPAfter(code, secret string, lastStep int64) (int64, bool) {
+	return validateTOTPAt(code, secret, time.Now(), lastStep)
+}
+
+// TOTPStep returns the time step that is current at t.
+func TOTPStep(t time.Time) int64 {
+	return t.Unix() / totpPeriod
+}
+
+func validateTOTPAt(code, secret string, t time.Time, lastStep int64) (int64, bool) {
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	now := TOTPStep(t)
+	for step := now - TOTPSkew; step <= now+TOTPSkew; step++ {
+		if step <= lastStep {
+			continue
+		}
+		want, err := totp.GenerateCodeCustom(secret, time.Unix(step*totpPeriod, 0).UTC(), totp.ValidateOpts{
+			Period:    totpPeriod,
+			Digits:    otp.DigitsSix,
+			Algorithm: otp.AlgorithmSHA1,
+		})
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(want), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// GenerateRecoveryCodes returns n random one-time codes formatted as
+// xxxxx-xxxxx (hex).
+func GenerateRecoveryCodes(n int) ([]string, error) {
+	codes := make([]string, n)
+	for i := range codes {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		s := fmt.Sprintf("%x", b)
+		codes[i] = s[:5] + "-" + s[5:]
+	}
+	return codes, nil
+}
+
+// SetPendingLoginCookie records that accountID passed the password step and
+// still has to enter a second-factor code. The cookie is signed, expires
+// after PendingLoginMaxAge and carries the account's last accepted TOTP step,
+// so it stops working once any second factor has been accepted.
+func SetPendingLoginCookie(w http.ResponseWriter, accountID string, lastStep int64, secret string) {
+	payload := accountID + "|" + strconv.FormatInt(lastStep, 10) + "|" + strconv.FormatInt(time.Now().Add(PendingLoginMaxAge).Unix(), 10)
+	http.SetCookie(w, &http.Cookie{
+		Name:     PendingLoginCookieName,
+		Value:    payload + "." + sign(payload, secret),
+		Path:     "/login",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   int(PendingLoginMaxAge.Seconds()),
+	})
+}
+
+// GetPendingLogin returns the account waiting on its second factor and the
+// TOTP step recorded when the cookie was set, if the cookie is present,
+// correctly signed and not expired.
+func GetPendingLogin(r *http.Request, secret string) (string, int64, bool) {
+	cookie, err := r.Cookie(PendingLoginCookieName)
+	if err != nil {
+		return "", 0, false
+	}
+	i := strings.LastIndex(cookie.Value, ".")
+	if i < 0 {
+		return "", 0, false
+	}
+	payload, sig := cookie.Value[:i], cookie.Value[i+1:]
+	if !hmacEqual(sign(payload, secret), sig) {
+		return "", 0, false
+	}
+	parts := strings.Split(payload, "|")
+	if len(parts) != 3 {
+		return "", 0, false
+	}
+	lastStep, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return "", 0, false
+	}
+	expUnix, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil || time.Now().Unix() > expUnix {
+		return "", 0, false
+	}
+	return parts[0], lastStep, true
+}
+
+func ClearPendingLoginCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     PendingLoginCookieName,
+		Value:    "",
+		Path:     "/login",
+		HttpOnly: true,
+		MaxAge:   -1,
+	})
+}
@@ -15,8 +15,26 @@ type Cleaner struct {
 	DB       *sql.DB
 	DataDir  string
 	Interval time.Duration
-	cancel   context.CancelFunc
-	done     chan struct{}
+	// APIKeyRetention is how long expired API keys are kept before deletion.
+	APIKeyRetention time.Duration
+	// DeleteGrace is how long soft-deleted campaigns and assets stay
+	// restorable before they and their files are removed.
+	DeleteGrace time.Duration
+	// OnAssetPurged, if set, is called with the ID of each purged asset.
+	OnAssetPurged func(assetID string)
+	// EventRetention and AuditRetention are how long download events and
+	// audit log entries are kept; zero keeps them forever.
+	EventRetention time.Duration
+	AuditRetention time.Duration
+	// OrphanInterval is how often the orphaned file sweep runs (zero turns it
+	// off); files younger than OrphanGrace are never touched, and with
+	// OrphanDryRun orphans are only logged.
+	OrphanInterval time.Duration
+	OrphanGrace    time.Duration
+	OrphanDryRun   bool
+	lastOrphans    time.Time
+	cancel         context.CancelFunc
+	done           chan struct{}
 }
 
 func (c *Cleaner) Start(ctx context.Context) {
@@ -106,4 +124,75 @@ func (c *Cleaner) runOnce() {
 	} else if n > 0 {
 		slog.Info("cleanup: pruned old webhook deliveries", "count", n)
 	}
+
+	if n, err := db.PruneExpiredAPIKeys(c.DB, time.Now().Add(-c.APIKeyRetention)); err != nil {
+		slog.Error("cleanup: prune expired api keys", "error", err)
+	} else if n > 0 {
+		slog.Info("cleanup: pruned expired api keys", "count", n)
+	}
+
+	c.pruneHistory()
+
+	c.purgeDeleted(time.Now().Add(-c.DeleteGrace))
+
+	if c.OrphanInterval > 0 && time.Since(c.lastOrphans) >= c.OrphanInterval {
+		c.lastOrphans = time.Now()
+		c.sweepOrphans(time.Now().Add(-c.OrphanGrace))
+	}
+}
+
+// pruneHistory applies the download event and audit log retention windows.
+func (c *Cleaner) pruneHistory() {
+	if c.EventRetention > 0 {
+		if n, err := db.PruneOldDownloadEvents(c.DB, time.Now().Add(-c.EventRetention)); err != nil {
+			slog.Error("cleanup: prune download events", "pruned", n, "error", err)
+		} else if n > 0 {
+			slog.Info("cleanup: pruned old download events", "count", n)
+		}
+	}
+	if c.AuditRetention > 0 {
+		if n, err := db.PruneOldAuditLogs(c.DB, time.Now().Add(-c.AuditRetention)); err != nil {
+			slog.Error("cleanup: prune audit logs", "pruned", n, "error", err)
+		} else if n > 0 {
+			slog.Info("cleanup: pruned old audit logs", "count", n)
+		}
+	}
+}
+
+// purgeDeleted removes campaigns and assets soft-deleted before cutoff,
+// campaigns first so the assets they used become purgeable in the same run.
+// Rows go before files, so a failed delete never leaves a row without files.
+func (c *Cleaner) purgeDeleted(cutoff time.Time) {
+	campaignIDs, err := db.ListPurgeableCampaigns(c.DB, cutoff)
+	if err != nil {
+		slog.Error("cleanup: list deleted campaigns", "error", err)
+	}
+	for _, id := range campaignIDs {
+		if err := db.PurgeCampaign(c.DB, id); err != nil {
+			slog.Error("cleanup: purge campaign", "id", id, "error", err)
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(c.DataDir, "watermarked", id)); err != nil {
+			slog.Warn("cleanup: remove watermarked dir", "campaign", id, "error", err)
+		}
+		slog.Info("cleanup: purged deleted campaign", "campaign", id)
+	}
+
+	assetIDs, err := db.ListPurgeableAssets(c.DB, cutoff)
+	if err != nil {
+		slog.Error("cleanup: list deleted assets", "error", err)
+	}
+	for _, id := range assetIDs {
+		if err := db.DeleteAsset(c.DB, id); err != nil {
+			slog.Error("cleanup: purge asset", "id", id, "error", err)
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(c.DataDir, "originals", id)); err != nil {
+			slog.Warn("cleanup: remove asset dir", "asset", id, "error", err)
+		}
+		if c.OnAssetPurged != nil {
+			c.OnAssetPurged(id)
+		}
+		slog.Info("cleanup: purged deleted asset", "asset", id)
+	}
 }
@@ -0,0 +1,138 @@
+package cleanup
+
+import (
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/YannKr/downloadonce/internal/db"
+)
+
+// sweepOrphans removes files under originals/ and watermarked/ that no
+// asset, campaign or token refers to: leftovers of a purge whose RemoveAll
+// failed, of a crashed upload or job, or of a revoked copy. Anything modified
+// after cutoff is left alone, since uploads and jobs write their files before
+// the rows that reference them. With OrphanDryRun it only logs.
+func (c *Cleaner) sweepOrphans(cutoff time.Time) {
+	// Without a complete picture of the database every file would look
+	// orphaned, so any query error skips the sweep.
+	assets, err := db.ListAssetPaths(c.DB)
+	if err != nil {
+		slog.Error("cleanup: orphan sweep: list assets", "error", err)
+		return
+	}
+	campaigns, err := db.ListCampaignIDs(c.DB)
+	if err != nil {
+		slog.Error("cleanup: orphan sweep: list campaigns", "error", err)
+		return
+	}
+	copies, err := db.ListWatermarkedPaths(c.DB)
+	if err != nil {
+		slog.Error("cleanup: orphan sweep: list watermarked files", "error", err)
+		return
+	}
+
+	var count int
+	var bytes int64
+	remove := func(rel string) {
+		path := filepath.Join(c.DataDir, rel)
+		size := treeSize(path)
+		if c.OrphanDryRun {
+			slog.Info("cleanup: orphan (dry run, kept)", "path", rel, "bytes", size)
+		} else if err := os.RemoveAll(path); err != nil {
+			slog.Warn("cleanup: remove orphan", "path", rel, "error", err)
+			return
+		} else {
+			slog.Info("cleanup: removed orphan", "path", rel, "bytes", size)
+		}
+		count++
+		bytes += size
+	}
+
+	c.walkOrphans("originals", cutoff, remove,
+		func(asset string) bool { _, ok := assets[asset]; return ok },
+		func(asset, name string) bool {
+			return name == "thumb.jpg" || filepath.Join("originals", asset, name) == assets[asset]
+		})
+	c.walkOrphans("watermarked", cutoff, remove,
+		func(campaign string) bool { return campaigns[campaign] },
+		func(campaign, name string) bool {
+			return name == "preview.jpg" || copies[filepath.Join("watermarked", campaign, name)]
+		})
+
+	if count > 0 {
+		slog.Info("cleanup: orphan sweep finished", "orphans", count, "bytes", bytes, "dry_run", c.OrphanDryRun)
+	}
+}
+
+// walkOrphans visits the per-asset or per-campaign directories under root.
+// A directory whose owner no longer exists is removed whole once nothing in
+// it is newer than cutoff; in the others, each entry that is not referenced
+// and is older than cutoff is removed.
+func (c *Cleaner) walkOrphans(root string, cutoff time.Time, remove func(rel string), owned func(dir string) bool, referenced func(dir, name string) bool) {
+	dirs, err := os.ReadDir(filepath.Join(c.DataDir, root))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			slog.Warn("cleanup: orphan sweep: read dir", "dir", root, "error", err)
+		}
+		return
+	}
+	for _, d := range dirs {
+		if !d.IsDir() {
+			continue
+		}
+		rel := filepath.Join(root, d.Name())
+		if !owned(d.Name()) {
+			if newestModTime(filepath.Join(c.DataDir, rel)).Before(cutoff) {
+				remove(rel)
+			}
+			continue
+		}
+		entries, err := os.ReadDir(filepath.Join(c.DataDir, rel))
+		if err != nil {
+			slog.Warn("cleanup: orphan sweep: read dir", "dir", rel, "error", err)
+			continue
+		}
+		for _, e := range entries {
+			if referenced(d.Name(), e.Name()) {
+				continue
+			}
+			entryRel := filepath.Join(rel, e.Name())
+			if newestModTime(filepath.Join(c.DataDir, entryRel)).Before(cutoff) {
+				remove(entryRel)
+			}
+		}
+	}
+}
+
+// newestModTime returns the latest modification time of path and anything
+// under it.
+func newestModTime(path string) time.Time {
+	var newest time.Time
+	filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info, err := d.Info(); err == nil && info.ModTime().After(newest) {
+			newest = info.ModTime()
+		}
+		return nil
+	})
+	return newest
+}
+
+// treeSize returns the total size of the files at or under path.
+func treeSize(path string) int64 {
+	var n int64
+	filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			if info, err := d.Info(); err == nil {
+				n += info.Size()
+			}
+		}
+		return nil
+	})
+	return n
+}
@@ -0,0 +1,102 @@
+package cleanup
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	downloadonce "github.com/YannKr/downloadonce"
+	"github.com/YannKr/downloadonce/internal/db"
+	"github.com/YannKr/downloadonce/internal/model"
+)
+
+func TestSweepOrphans(t *testing.T) {
+	dataDir := t.TempDir()
+	database, err := db.Open(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { database.Close() })
+	if err := db.Migrate(database, downloadonce.MigrationFS); err != nil {
+		t.Fatal(err)
+	}
+
+	must := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	must(db.CreateAccount(database, &model.Account{ID: "acc", Email: "a@example.com", Name: "a", PasswordHash: "x", Role: "admin", Enabled: true}))
+	must(db.CreateAsset(database, &model.Asset{ID: "asset", AccountID: "acc", OriginalName: "in.png", AssetType: "image", OriginalPath: filepath.Join("originals", "asset", "source.png"), FileSize: 1, SHA256: "00", MimeType: "image/png"}))
+	must(db.CreateCampaign(database, &model.Campaign{ID: "camp", AccountID: "acc", AssetID: "asset", Name: "camp", State: "READY"}))
+	must(db.CreateRecipient(database, &model.Recipient{ID: "rec", AccountID: "acc", Name: "r", Email: "r@example.com"}))
+	must(db.CreateToken(database, &model.DownloadToken{ID: "tok", CampaignID: "camp", RecipientID: "rec", State: "PENDING"}))
+	must(db.ActivateToken(database, "tok", filepath.Join("watermarked", "camp", "tok.png"), "00", 1))
+
+	old := time.Now().Add(-48 * time.Hour)
+	write := func(rel string, mtime time.Time) {
+		t.Helper()
+		path := filepath.Join(dataDir, rel)
+		must(os.MkdirAll(filepath.Dir(path), 0o755))
+		must(os.WriteFile(path, []byte("x"), 0o644))
+		must(os.Chtimes(path, mtime, mtime))
+	}
+	kept := []string{
+		"originals/asset/source.png",
+		"originals/asset/thumb.jpg",
+		"originals/fresh/source.png", // unknown asset, but still within the grace period
+		"watermarked/camp/tok.png",
+		"watermarked/camp/preview.jpg",
+		"watermarked/camp/new.png",
+	}
+	orphans := []string{
+		"originals/asset/source.jpg", // left over from a replace
+		"originals/gone/source.png",
+		"watermarked/camp/revoked.png",
+		"watermarked/gone/tok.png",
+	}
+	for _, rel := range kept {
+		mtime := old
+		if rel == "originals/fresh/source.png" || rel == "watermarked/camp/new.png" {
+			mtime = time.Now()
+		}
+		write(rel, mtime)
+	}
+	for _, rel := range orphans {
+		write(rel, old)
+	}
+	// A directory's own mtime counts too, so age the orphaned ones.
+	for _, rel := range []string{"originals/gone", "watermarked/gone"} {
+		must(os.Chtimes(filepath.Join(dataDir, rel), old, old))
+	}
+
+	exists := func(rel string) bool {
+		_, err := os.Stat(filepath.Join(dataDir, rel))
+		return err == nil
+	}
+	c := &Cleaner{DB: database, DataDir: dataDir, OrphanDryRun: true}
+	c.sweepOrphans(time.Now().Add(-24 * time.Hour))
+	for _, rel := range append(kept, orphans...) {
+		if !exists(rel) {
+			t.Errorf("dry run removed %s", rel)
+		}
+	}
+
+	c.OrphanDryRun = false
+	c.sweepOrphans(time.Now().Add(-24 * time.Hour))
+	for _, rel := range kept {
+		if !exists(rel) {
+			t.Errorf("%s was removed", rel)
+		}
+	}
+	for _, rel := range orphans {
+		if exists(rel) {
+			t.Errorf("orphan %s was kept", rel)
+		}
+	}
+	if exists("originals/gone") || exists("watermarked/gone") {
+		t.Error("directories of missing owners were kept")
+	}
+}
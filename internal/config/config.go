@@ -10,12 +10,26 @@ type Config struct {
 	DataDir        string
 	BaseURL        string
 	SessionSecret  string
-	MaxUploadBytes int64
+	MaxUploadBytes int64 // per file, for chunked and form uploads
 	WorkerCount    int
 	FontPath       string
 	LogLevel       string
+	DevMode        bool // re-parse templates from ./templates on every render
 	VenvPath       string
 	ScriptsDir     string // set at runtime after extracting embedded scripts
+	Version        string // set at runtime from the build version
+
+	// Worker fairness: running jobs allowed per account at once (0 = no cap)
+	MaxJobsPerAccount int
+	// Detect jobs allowed to run at once across all workers (0 = no cap), so a
+	// burst of detections leaves workers free for publishing
+	MaxConcurrentDetect int
+	// Files one combined detection (POST /api/v1/detect/combine) may vote over
+	DetectCombineMaxFiles int
+
+	// Non-archived campaigns an account may hold (0 = no cap); an admin can
+	// override it per account
+	MaxCampaignsPerAccount int
 
 	// SMTP
 	SMTPHost string
@@ -23,15 +37,61 @@ type Config struct {
 	SMTPUser string
 	SMTPPass string
 	SMTPFrom string
+	// Optional Reply-To header, envelope MAIL FROM distinct from SMTPFrom, and
+	// comma-separated archive addresses BCC'd on download link emails
+	SMTPReplyTo      string
+	SMTPEnvelopeFrom string
+	SMTPBCC          string
+	// Maximum SMTP connections open at once; each is reused for many messages
+	SMTPConcurrency int
+	// Directory of download link email template overrides; "" for built-ins
+	EmailTemplateDir string
+
+	// Owner download notifications: 0 emails each download, otherwise one
+	// digest per account every NotifyDigestMins minutes
+	NotifyDigestMins int
 
 	// Cleanup
 	CleanupIntervalMins int
+	// Days an expired API key stays listed (marked expired) before cleanup deletes it
+	APIKeyExpiredRetentionDays int
+	// Days a deleted asset or campaign can be restored before cleanup purges it
+	DeleteGraceDays int
+	// Days of download events and audit log entries to keep (0 = forever)
+	DownloadEventRetentionDays int
+	AuditLogRetentionDays      int
+	// Hours between sweeps for files under originals/ and watermarked/ that
+	// nothing references (0 = off), the minimum age of a file it removes,
+	// and whether it only logs what it would remove
+	OrphanSweepHours int
+	OrphanGraceHours int
+	OrphanDryRun     bool
 
 	// Registration
 	AllowRegistration bool
 
 	// Chunked upload
 	UploadSessionTTLHours int
+	// Total original-file bytes one account may store, counting uploads in progress (0 = no cap)
+	MaxAccountUploadBytes int64
+	// Generate upload thumbnails in a background job instead of before the upload returns
+	DeferThumbnails bool
+	// MIME types accounts may upload, comma-separated; "image" and "video"
+	// stand for every type of that kind, empty for all. An admin can
+	// override it per account
+	AllowedUploadTypes string
+
+	// Recipient email checks on create and import: off, basic or strict
+	RecipientEmailCheck string
+
+	// On-demand watermarking: a PENDING link does not enqueue a new job if one
+	// was created for the token within this many seconds
+	OnDemandGraceSecs int
+	// Retry-After sent with 503 responses for download files still being watermarked
+	FileRetryAfterSecs int
+
+	// Owner bundle download: cap on the total size of /campaigns/{id}/bundle.zip (0 = no cap)
+	BundleMaxBytes int64
 
 	// Disk space monitoring
 	MaxStorageBytes    int64
@@ -39,6 +99,78 @@ type Config struct {
 	DiskWarnYellowPct  float64
 	DiskWarnRedPct     float64
 	DiskWarnBlockPct   float64
+	WorkerMinFreeBytes int64 // free space a worker must leave on disk after writing its output
+	// Stop claiming watermark jobs while free space is at the block level;
+	// DiskPauseDetect pauses detect jobs too
+	DiskPauseWorkers bool
+	DiskPauseDetect  bool
+
+	// Invisible watermark defaults inherited by campaigns without overrides:
+	// comma-separated YUV channels (Y, U, V) and the embedding scale
+	WMChannels string
+	WMScale    float64
+
+	// Re-detect invisible image watermarks after embedding and retry with
+	// stronger output settings when the payload cannot be recovered
+	WMSelfVerify bool
+
+	// What to do with images too flat in colour for the invisible watermark
+	// (indexed or low-chroma): "warn", "visible" (visible mark only) or
+	// "convert" (expand indexed images to full colour first)
+	WMLowChroma string
+
+	// Chroma subsampling of watermarked JPEG output: "4:4:4" keeps the
+	// U channel carrying the invisible mark at full resolution, "4:2:0" is
+	// smaller
+	WMJPEGSubsampling string
+
+	// Video I-frames carrying the invisible watermark and decoded by
+	// detection: how many, and whether they are the first ones ("first") or
+	// spread over the whole video ("spread")
+	WMVideoFrames        int
+	WMVideoFrameSampling string
+
+	// How downloads are named: "campaign" (campaign name) or "original"
+	// (the uploaded file name, with the extension of the served file)
+	DownloadFilename string
+	// Contact line shown to recipients on download error pages, e.g.
+	// "Email press@example.com for a new link"
+	DownloadSupportContact string
+
+	// Campaigns need an admin's sign-off before publish
+	RequireApproval bool
+
+	// Allow basic formatting in campaign download messages; when off all
+	// markup is stripped and the message is shown as plain text
+	AllowMessageHTML bool
+
+	// CSV analytics exports allowed to run at once; more get 429
+	ExportConcurrency int
+
+	// API requests per second and burst allowed for each API key
+	APIRateLimit float64
+	APIRateBurst int
+
+	// Cross-origin browser access to /api/v1: allowed origins ("*" for any,
+	// empty for same-origin only), the methods and request headers
+	// preflights may ask for, and whether credentials may be sent
+	CORSAllowedOrigins   string
+	CORSAllowedMethods   string
+	CORSAllowedHeaders   string
+	CORSAllowCredentials bool
+
+	// Buffer download-event inserts and write them in batches from one goroutine
+	AsyncDownloadEvents bool
+
+	// Webhook signature scheme: "v1" signs the timestamp and body, "v0" the
+	// body only (for receivers that predate X-DownloadOnce-Timestamp)
+	WebhookSignatureVersion string
+	// Delays before each retry of a failed webhook delivery, e.g.
+	// "30s,5m,30m,2h"; "none" disables retries
+	WebhookRetrySchedule string
+
+	// GeoIP enrichment of download events (MaxMind City .mmdb; empty disables)
+	GeoIPDBPath string
 }
 
 func Load() *Config {
@@ -49,22 +181,72 @@ func Load() *Config {
 		SessionSecret:       envOr("SESSION_SECRET", "change-me-in-production-32-bytes!"),
 		MaxUploadBytes:      envInt64Or("MAX_UPLOAD_BYTES", 50*1024*1024*1024),
 		WorkerCount:         envIntOr("WORKER_COUNT", 2),
+		MaxJobsPerAccount:   envIntOr("MAX_JOBS_PER_ACCOUNT", 0),
+		MaxConcurrentDetect: envIntOr("MAX_CONCURRENT_DETECT", 0),
+		DetectCombineMaxFiles: envIntOr("DETECT_COMBINE_MAX_FILES", 10),
+		MaxCampaignsPerAccount: envIntOr("MAX_CAMPAIGNS_PER_ACCOUNT", 0),
 		FontPath:            envOr("FONT_PATH", "/usr/share/fonts/truetype/dejavu/DejaVuSans.ttf"),
 		LogLevel:            envOr("LOG_LEVEL", "info"),
+		DevMode:             envBoolOr("DEV_MODE", false),
 		VenvPath:            envOr("VENV_PATH", "/opt/venv"),
 		SMTPHost:            envOr("SMTP_HOST", ""),
 		SMTPPort:            envIntOr("SMTP_PORT", 587),
 		SMTPUser:            envOr("SMTP_USER", ""),
 		SMTPPass:            envOr("SMTP_PASS", ""),
 		SMTPFrom:            envOr("SMTP_FROM", ""),
+		SMTPConcurrency:     envIntOr("SMTP_CONCURRENCY", 4),
+		SMTPReplyTo:         envOr("SMTP_REPLY_TO", ""),
+		SMTPEnvelopeFrom:    envOr("SMTP_ENVELOPE_FROM", ""),
+		SMTPBCC:             envOr("SMTP_BCC", ""),
+		EmailTemplateDir:    envOr("EMAIL_TEMPLATE_DIR", ""),
+		NotifyDigestMins:    envIntOr("NOTIFY_DIGEST_MINS", 0),
 		CleanupIntervalMins:   envIntOr("CLEANUP_INTERVAL_MINS", 60),
+		APIKeyExpiredRetentionDays: envIntOr("API_KEY_EXPIRED_RETENTION_DAYS", 30),
+		DeleteGraceDays:            envIntOr("DELETE_GRACE_DAYS", 7),
+		DownloadEventRetentionDays: envIntOr("DOWNLOAD_EVENT_RETENTION_DAYS", 0),
+		AuditLogRetentionDays:      envIntOr("AUDIT_LOG_RETENTION_DAYS", 0),
+		OrphanSweepHours:           envIntOr("ORPHAN_SWEEP_HOURS", 24),
+		OrphanGraceHours:           envIntOr("ORPHAN_GRACE_HOURS", 24),
+		OrphanDryRun:               envBoolOr("ORPHAN_DRY_RUN", false),
 		AllowRegistration:     envBoolOr("ALLOW_REGISTRATION", false),
 		UploadSessionTTLHours: envIntOr("UPLOAD_SESSION_TTL_HOURS", 24),
+		DeferThumbnails:       envBoolOr("DEFER_THUMBNAILS", false),
+		MaxAccountUploadBytes: envInt64Or("MAX_ACCOUNT_UPLOAD_BYTES", 0),
+		AllowedUploadTypes:    envOr("ALLOWED_UPLOAD_TYPES", ""),
+		RecipientEmailCheck:   envOr("RECIPIENT_EMAIL_VALIDATION", "basic"),
+		OnDemandGraceSecs:     envIntOr("ON_DEMAND_GRACE_SECS", 5),
+		FileRetryAfterSecs:    envIntOr("DOWNLOAD_RETRY_AFTER_SECS", 5),
+		BundleMaxBytes:        envInt64Or("BUNDLE_MAX_BYTES", 10*1024*1024*1024),
 		MaxStorageBytes:       envInt64Or("MAX_STORAGE_BYTES", 0),
 		WMCompressionFactor:   envFloat64Or("WM_COMPRESSION_FACTOR", 0.9),
 		DiskWarnYellowPct:     envFloat64Or("DISK_WARN_YELLOW_PCT", 20.0),
 		DiskWarnRedPct:        envFloat64Or("DISK_WARN_RED_PCT", 10.0),
 		DiskWarnBlockPct:      envFloat64Or("DISK_WARN_BLOCK_PCT", 5.0),
+		WorkerMinFreeBytes:    envInt64Or("WORKER_MIN_FREE_BYTES", 256*1024*1024),
+		DiskPauseWorkers:      envBoolOr("DISK_PAUSE_WORKERS", true),
+		DiskPauseDetect:       envBoolOr("DISK_PAUSE_DETECT", false),
+		WMChannels:            envOr("WM_CHANNELS", "U"),
+		WMScale:               envFloat64Or("WM_SCALE", 36),
+		WMSelfVerify:          envBoolOr("WM_SELF_VERIFY", true),
+		WMLowChroma:           envOr("WM_LOW_CHROMA", "warn"),
+		WMJPEGSubsampling:     envOr("WM_JPEG_SUBSAMPLING", "4:4:4"),
+		WMVideoFrames:         envIntOr("WM_VIDEO_FRAMES", 10),
+		WMVideoFrameSampling:  envOr("WM_VIDEO_FRAME_SAMPLING", "first"),
+		DownloadFilename:      envOr("DOWNLOAD_FILENAME", "campaign"),
+		DownloadSupportContact: envOr("DOWNLOAD_SUPPORT_CONTACT", ""),
+		RequireApproval:       envBoolOr("REQUIRE_APPROVAL", false),
+		AllowMessageHTML:      envBoolOr("ALLOW_MESSAGE_HTML", true),
+		ExportConcurrency:     envIntOr("EXPORT_CONCURRENCY", 2),
+		APIRateLimit:          envFloat64Or("API_RATE_LIMIT", 2),
+		APIRateBurst:          envIntOr("API_RATE_BURST", 60),
+		CORSAllowedOrigins:    envOr("CORS_ALLOWED_ORIGINS", ""),
+		CORSAllowedMethods:    envOr("CORS_ALLOWED_METHODS", "GET,POST,PUT,PATCH,DELETE"),
+		CORSAllowedHeaders:    envOr("CORS_ALLOWED_HEADERS", "Authorization,Content-Type"),
+		CORSAllowCredentials:  envBoolOr("CORS_ALLOW_CREDENTIALS", false),
+		AsyncDownloadEvents:   envBoolOr("ASYNC_DOWNLOAD_EVENTS", false),
+		WebhookSignatureVersion: envOr("WEBHOOK_SIGNATURE_VERSION", "v1"),
+		WebhookRetrySchedule:    envOr("WEBHOOK_RETRY_SCHEDULE", "30s,5m,30m,2h"),
+		GeoIPDBPath:           envOr("GEOIP_DB_PATH", ""),
 	}
 }
 
@@ -110,3 +292,4 @@ func envBoolOr(key string, fallback bool) bool {
 	}
 	return fallback
 }
+
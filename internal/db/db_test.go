@@ -0,0 +1,59 @@
+package db
+
+import (
+	"database/sql"
+	"testing"
+
+	downloadonce "github.com/YannKr/downloadonce"
+	"github.com/YannKr/downloadonce/internal/model"
+)
+
+// openTestDB returns a freshly migrated database in a temp directory.
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	database, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	t.Cleanup(func() { database.Close() })
+	if err := Migrate(database, downloadonce.MigrationFS); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	return database
+}
+
+// seedCampaign inserts an account, an image asset, a campaign and one PENDING
+// token per recipient ID (recipients are created as needed). Token IDs are
+// "<campaignID>-<recipientID>".
+func seedCampaign(t *testing.T, database *sql.DB, accountID, campaignID string, recipientIDs ...string) {
+	t.Helper()
+	must := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if a, _ := GetAccountByID(database, accountID); a == nil {
+		must(CreateAccount(database, &model.Account{
+			ID: accountID, Email: accountID + "@example.com", Name: accountID, PasswordHash: "x", Role: "admin", Enabled: true,
+		}))
+	}
+	assetID := campaignID + "-asset"
+	must(CreateAsset(database, &model.Asset{
+		ID: assetID, AccountID: accountID, OriginalName: "in.png", AssetType: "image",
+		OriginalPath: "originals/" + assetID + ".png", FileSize: 1024, SHA256: "00", MimeType: "image/png",
+	}))
+	must(CreateCampaign(database, &model.Campaign{
+		ID: campaignID, AccountID: accountID, AssetID: assetID, Name: campaignID, State: "PROCESSING",
+	}))
+	for _, rid := range recipientIDs {
+		if rec, _ := GetRecipient(database, rid); rec == nil {
+			must(CreateRecipient(database, &model.Recipient{
+				ID: rid, AccountID: accountID, Name: rid, Email: rid + "@example.com",
+			}))
+		}
+		must(CreateToken(database, &model.DownloadToken{
+			ID: campaignID + "-" + rid, CampaignID: campaignID, RecipientID: rid, State: "PENDING",
+		}))
+	}
+}
@@ -30,6 +30,15 @@ func Migrate(database *sql.DB, migrationFS fs.FS) error {
 	}
 	sort.Strings(names)
 
+	// Some migrations recreate tables to change constraints. With foreign
+	// keys enforced, dropping the old table would cascade into its children,
+	// so enforcement is disabled while migrations run (it cannot be toggled
+	// inside a transaction).
+	if _, err := database.Exec("PRAGMA foreign_keys=OFF"); err != nil {
+		return fmt.Errorf("disable foreign keys: %w", err)
+	}
+	defer database.Exec("PRAGMA foreign_keys=ON")
+
 	for _, name := range names {
 		var count int
 		err := database.QueryRow("SELECT COUNT(*) FROM _migrations WHERE filename = ?", name).Scan(&count)
@@ -69,3 +78,36 @@ func Migrate(database *sql.DB, migrationFS fs.FS) error {
 
 	return nil
 }
+
+// PendingMigrations lists the files in migrationFS that Migrate has not
+// applied to database yet, in the order it would apply them.
+func PendingMigrations(database *sql.DB, migrationFS fs.FS) ([]string, error) {
+	entries, err := fs.ReadDir(migrationFS, "migrations")
+	if err != nil {
+		return nil, fmt.Errorf("read migrations dir: %w", err)
+	}
+	applied := make(map[string]bool)
+	rows, err := database.Query("SELECT filename FROM _migrations")
+	if err != nil {
+		return nil, fmt.Errorf("list applied migrations: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		applied[name] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var pending []string
+	for _, e := range entries {
+		if !e.IsDir() && !applied[e.Name()] {
+			pending = append(pending, e.Name())
+		}
+	}
+	return pending, nil
+}
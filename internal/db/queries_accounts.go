@@ -23,15 +23,20 @@ func GetAccountByEmail(database *sql.DB, email string) (*model.Account, error) {
 	var createdAt SQLiteTime
 	var enabled int
 	var notifyOnDl int
+	var maxCampaigns sql.NullInt64
+	var uploadTypes sql.NullString
 	err := database.QueryRow(
-		`SELECT id, email, name, password_hash, role, enabled, notify_on_download, created_at FROM accounts WHERE email = ?`, email,
-	).Scan(&a.ID, &a.Email, &a.Name, &a.PasswordHash, &a.Role, &enabled, &notifyOnDl, &createdAt)
+		`SELECT id, email, name, password_hash, role, enabled, notify_on_download, COALESCE(totp_secret, ''), COALESCE(totp_last_step, 0), COALESCE(default_group_id, ''), max_campaigns, allowed_upload_types, created_at
+		 FROM accounts WHERE email = ?`, email,
+	).Scan(&a.ID, &a.Email, &a.Name, &a.PasswordHash, &a.Role, &enabled, &notifyOnDl, &a.TOTPSecret, &a.TOTPLastStep, &a.DefaultGroupID, &maxCampaigns, &uploadTypes, &createdAt)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
 	a.CreatedAt = createdAt.Time
 	a.Enabled = enabled != 0
 	a.NotifyOnDownload = notifyOnDl != 0
+	a.MaxCampaigns = nullIntPtr(maxCampaigns)
+	a.AllowedUploadTypes = nullStringPtr(uploadTypes)
 	return a, err
 }
 
@@ -40,15 +45,20 @@ func GetAccountByID(database *sql.DB, id string) (*model.Account, error) {
 	var createdAt SQLiteTime
 	var enabled int
 	var notifyOnDl int
+	var maxCampaigns sql.NullInt64
+	var uploadTypes sql.NullString
 	err := database.QueryRow(
-		`SELECT id, email, name, password_hash, role, enabled, notify_on_download, created_at FROM accounts WHERE id = ?`, id,
-	).Scan(&a.ID, &a.Email, &a.Name, &a.PasswordHash, &a.Role, &enabled, &notifyOnDl, &createdAt)
+		`SELECT id, email, name, password_hash, role, enabled, notify_on_download, COALESCE(totp_secret, ''), COALESCE(totp_last_step, 0), COALESCE(default_group_id, ''), max_campaigns, allowed_upload_types, created_at
+		 FROM accounts WHERE id = ?`, id,
+	).Scan(&a.ID, &a.Email, &a.Name, &a.PasswordHash, &a.Role, &enabled, &notifyOnDl, &a.TOTPSecret, &a.TOTPLastStep, &a.DefaultGroupID, &maxCampaigns, &uploadTypes, &createdAt)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
 	a.CreatedAt = createdAt.Time
 	a.Enabled = enabled != 0
 	a.NotifyOnDownload = notifyOnDl != 0
+	a.MaxCampaigns = nullIntPtr(maxCampaigns)
+	a.AllowedUploadTypes = nullStringPtr(uploadTypes)
 	return a, err
 }
 
@@ -60,7 +70,7 @@ func AccountExists(database *sql.DB) (bool, error) {
 
 func ListAccounts(database *sql.DB) ([]model.Account, error) {
 	rows, err := database.Query(
-		`SELECT id, email, name, password_hash, role, enabled, notify_on_download, created_at FROM accounts ORDER BY created_at ASC`,
+		`SELECT id, email, name, password_hash, role, enabled, notify_on_download, max_campaigns, allowed_upload_types, created_at FROM accounts ORDER BY created_at ASC`,
 	)
 	if err != nil {
 		return nil, err
@@ -73,17 +83,50 @@ func ListAccounts(database *sql.DB) ([]model.Account, error) {
 		var createdAt SQLiteTime
 		var enabled int
 		var notifyOnDl int
-		if err := rows.Scan(&a.ID, &a.Email, &a.Name, &a.PasswordHash, &a.Role, &enabled, &notifyOnDl, &createdAt); err != nil {
+		var maxCampaigns sql.NullInt64
+		var uploadTypes sql.NullString
+		if err := rows.Scan(&a.ID, &a.Email, &a.Name, &a.PasswordHash, &a.Role, &enabled, &notifyOnDl, &maxCampaigns, &uploadTypes, &createdAt); err != nil {
 			return nil, err
 		}
 		a.CreatedAt = createdAt.Time
 		a.Enabled = enabled != 0
 		a.NotifyOnDownload = notifyOnDl != 0
+		a.MaxCampaigns = nullIntPtr(maxCampaigns)
+		a.AllowedUploadTypes = nullStringPtr(uploadTypes)
 		accounts = append(accounts, a)
 	}
 	return accounts, rows.Err()
 }
 
+// SetAccountMaxCampaigns sets the account's campaign limit override; nil
+// reverts to the global default.
+func SetAccountMaxCampaigns(database *sql.DB, id string, limit *int) error {
+	_, err := database.Exec(`UPDATE accounts SET max_campaigns = ? WHERE id = ?`, limit, id)
+	return err
+}
+
+// SetAccountUploadTypes sets the account's upload type override; nil
+// reverts to the global default.
+func SetAccountUploadTypes(database *sql.DB, id string, types *string) error {
+	_, err := database.Exec(`UPDATE accounts SET allowed_upload_types = ? WHERE id = ?`, types, id)
+	return err
+}
+
+func nullIntPtr(v sql.NullInt64) *int {
+	if !v.Valid {
+		return nil
+	}
+	n := int(v.Int64)
+	return &n
+}
+
+func nullStringPtr(v sql.NullString) *string {
+	if !v.Valid {
+		return nil
+	}
+	return &v.String
+}
+
 func UpdateAccountRole(database *sql.DB, id, role string) error {
 	_, err := database.Exec(`UPDATE accounts SET role = ? WHERE id = ?`, role, id)
 	return err
@@ -98,6 +141,21 @@ func UpdateAccountEnabled(database *sql.DB, id string, enabled bool) error {
 	return err
 }
 
+// SetAccountDefaultGroup sets the account's default recipient group; an
+// empty groupID clears it. The group must belong to the account.
+func SetAccountDefaultGroup(database *sql.DB, id, groupID string) error {
+	if groupID == "" {
+		_, err := database.Exec(`UPDATE accounts SET default_group_id = NULL WHERE id = ?`, id)
+		return err
+	}
+	_, err := database.Exec(
+		`UPDATE accounts SET default_group_id = ?
+		 WHERE id = ? AND EXISTS (SELECT 1 FROM recipient_groups WHERE id = ? AND account_id = ?)`,
+		groupID, id, groupID, id,
+	)
+	return err
+}
+
 func UpdateAccountNotifyOnDownload(database *sql.DB, id string, notify bool) error {
 	v := 0
 	if notify {
@@ -27,6 +27,8 @@ type DownloadEvent struct {
 	RecipientEmail string
 	DownloadedAt   time.Time
 	IPAddress      string
+	Country        string
+	City           string
 }
 
 // DashboardStats holds aggregate download counts for the dashboard.
@@ -95,33 +97,64 @@ func CampaignAnalyticsByDateRange(database *sql.DB, accountID, start, end string
 	return analytics, rows.Err()
 }
 
-// ExportDownloadEvents returns all download events for the given date range,
-// suitable for CSV export.
-func ExportDownloadEvents(database *sql.DB, accountID, start, end string) ([]DownloadEvent, error) {
+// ExportPageSize is how many rows the streaming exports read per query.
+var ExportPageSize = 1000
+
+// ExportDownloadEvents calls fn for every download event in the given date
+// range, newest first, for CSV export. Rows are read in keyset-paginated
+// pages of ExportPageSize and the query is closed before fn runs, so memory
+// stays bounded and a slow consumer never holds the (single) connection.
+func ExportDownloadEvents(database *sql.DB, accountID, start, end string, fn func(DownloadEvent) error) error {
+	var afterAt, afterID string // cursor: last row of the previous page
+	for {
+		page, lastAt, lastID, err := exportDownloadEventsPage(database, accountID, start, end, afterAt, afterID)
+		if err != nil {
+			return err
+		}
+		for _, ev := range page {
+			if err := fn(ev); err != nil {
+				return err
+			}
+		}
+		if len(page) < ExportPageSize {
+			return nil
+		}
+		afterAt, afterID = lastAt, lastID
+	}
+}
+
+func exportDownloadEventsPage(database *sql.DB, accountID, start, end, afterAt, afterID string) ([]DownloadEvent, string, string, error) {
 	rows, err := database.Query(`
-		SELECT c.name, r.name, r.email, de.downloaded_at, de.ip_address
+		SELECT de.id, c.name, r.name, r.email, de.downloaded_at, de.ip_address,
+		  COALESCE(de.country, ''), COALESCE(de.city, '')
 		FROM download_events de
 		JOIN campaigns c ON de.campaign_id = c.id
 		JOIN recipients r ON de.recipient_id = r.id
 		WHERE c.account_id = ?
 		  AND date(de.downloaded_at) BETWEEN ? AND ?
-		ORDER BY de.downloaded_at DESC`, accountID, start, end)
+		  AND (? = '' OR de.downloaded_at < ? OR (de.downloaded_at = ? AND de.id < ?))
+		ORDER BY de.downloaded_at DESC, de.id DESC
+		LIMIT ?`, accountID, start, end, afterAt, afterAt, afterAt, afterID, ExportPageSize)
 	if err != nil {
-		return nil, err
+		return nil, "", "", err
 	}
 	defer rows.Close()
 
-	var events []DownloadEvent
+	events := make([]DownloadEvent, 0, ExportPageSize)
+	var lastAt, lastID string
 	for rows.Next() {
 		var ev DownloadEvent
 		var downloadedAt SQLiteTime
-		if err := rows.Scan(&ev.CampaignName, &ev.RecipientName, &ev.RecipientEmail, &downloadedAt, &ev.IPAddress); err != nil {
-			return nil, err
+		if err := rows.Scan(&lastID, &ev.CampaignName, &ev.RecipientName, &ev.RecipientEmail, &lastAt, &ev.IPAddress, &ev.Country, &ev.City); err != nil {
+			return nil, "", "", err
+		}
+		if err := downloadedAt.Scan(lastAt); err != nil {
+			return nil, "", "", err
 		}
 		ev.DownloadedAt = downloadedAt.Time
 		events = append(events, ev)
 	}
-	return events, rows.Err()
+	return events, lastAt, lastID, rows.Err()
 }
 
 // GetDashboardStats returns aggregate download counts for the past week,
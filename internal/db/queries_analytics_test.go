@@ -0,0 +1,77 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/YannKr/downloadonce/internal/model"
+)
+
+func TestExportDownloadEventsStreamsPages(t *testing.T) {
+	database := openTestDB(t)
+	seedCampaign(t, database, "acc", "camp", "r1", "r2")
+	seedCampaign(t, database, "other", "theirs", "r3")
+
+	// Many events share a timestamp so the keyset cursor has to break ties by ID.
+	base := time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+	const n = 2500
+	events := make([]*model.DownloadEvent, 0, n+1)
+	for i := 0; i < n; i++ {
+		rid := "r1"
+		if i%2 == 1 {
+			rid = "r2"
+		}
+		events = append(events, &model.DownloadEvent{
+			ID: fmt.Sprintf("e%05d", i), TokenID: "camp-" + rid, CampaignID: "camp", RecipientID: rid,
+			AssetID: "camp-asset", CreatedAt: base.Add(-time.Duration(i/7) * time.Minute),
+		})
+	}
+	events = append(events, &model.DownloadEvent{
+		ID: "x", TokenID: "theirs-r3", CampaignID: "theirs", RecipientID: "r3", AssetID: "theirs-asset", CreatedAt: base,
+	})
+	if err := InsertDownloadEvents(database, events); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(old int) { ExportPageSize = old }(ExportPageSize)
+	ExportPageSize = 100
+
+	count := 0
+	var prev time.Time
+	err := ExportDownloadEvents(database, "acc", "2025-03-01", "2025-03-31", func(ev DownloadEvent) error {
+		if count > 0 && ev.DownloadedAt.After(prev) {
+			t.Fatalf("row %d out of order: %v after %v", count, ev.DownloadedAt, prev)
+		}
+		prev = ev.DownloadedAt
+		count++
+		if count%250 == 0 {
+			// The pool has a single connection; this would block if the
+			// export still held it while handing rows out.
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+			var one int
+			if err := database.QueryRowContext(ctx, "SELECT 1").Scan(&one); err != nil {
+				return fmt.Errorf("query during export: %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != n {
+		t.Errorf("exported %d rows, want %d", count, n)
+	}
+
+	stop := fmt.Errorf("stop")
+	count = 0
+	err = ExportDownloadEvents(database, "acc", "2025-03-01", "2025-03-31", func(DownloadEvent) error {
+		count++
+		return stop
+	})
+	if err != stop || count != 1 {
+		t.Errorf("callback error not propagated: err = %v after %d rows", err, count)
+	}
+}
@@ -2,21 +2,36 @@ package db
 
 import (
 	"database/sql"
+	"time"
 
 	"github.com/YannKr/downloadonce/internal/model"
 )
 
 func CreateAPIKey(database *sql.DB, k *model.APIKey) error {
+	var expiresAt sql.NullString
+	if k.ExpiresAt != nil {
+		expiresAt = sql.NullString{String: k.ExpiresAt.UTC().Format(time.RFC3339), Valid: true}
+	}
 	_, err := database.Exec(
-		`INSERT INTO api_keys (id, account_id, name, key_prefix, key_hash) VALUES (?, ?, ?, ?, ?)`,
-		k.ID, k.AccountID, k.Name, k.KeyPrefix, k.KeyHash,
+		`INSERT INTO api_keys (id, account_id, name, key_prefix, key_hash, scopes, expires_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		k.ID, k.AccountID, k.Name, k.KeyPrefix, k.KeyHash, k.Scopes, expiresAt,
 	)
 	return err
 }
 
+// nullTimePtr parses a nullable timestamp column; NULL gives nil.
+func nullTimePtr(ns sql.NullString) *time.Time {
+	if !ns.Valid {
+		return nil
+	}
+	var t SQLiteTime
+	t.Scan(ns.String)
+	return &t.Time
+}
+
 func ListAPIKeys(database *sql.DB, accountID string) ([]model.APIKey, error) {
 	rows, err := database.Query(
-		`SELECT id, account_id, name, key_prefix, created_at, last_used_at
+		`SELECT id, account_id, name, key_prefix, scopes, created_at, last_used_at, expires_at
 		 FROM api_keys WHERE account_id = ? ORDER BY created_at DESC`, accountID,
 	)
 	if err != nil {
@@ -28,16 +43,13 @@ func ListAPIKeys(database *sql.DB, accountID string) ([]model.APIKey, error) {
 	for rows.Next() {
 		var k model.APIKey
 		var createdAt SQLiteTime
-		var lastUsed sql.NullString
-		if err := rows.Scan(&k.ID, &k.AccountID, &k.Name, &k.KeyPrefix, &createdAt, &lastUsed); err != nil {
+		var lastUsed, expiresAt sql.NullString
+		if err := rows.Scan(&k.ID, &k.AccountID, &k.Name, &k.KeyPrefix, &k.Scopes, &createdAt, &lastUsed, &expiresAt); err != nil {
 			return nil, err
 		}
 		k.CreatedAt = createdAt.Time
-		if lastUsed.Valid {
-			var lu SQLiteTime
-			lu.Scan(lastUsed.String)
-			k.LastUsedAt = &lu.Time
-		}
+		k.LastUsedAt = nullTimePtr(lastUsed)
+		k.ExpiresAt = nullTimePtr(expiresAt)
 		keys = append(keys, k)
 	}
 	return keys, rows.Err()
@@ -48,13 +60,25 @@ func DeleteAPIKey(database *sql.DB, id, accountID string) error {
 	return err
 }
 
+// DeleteAPIKeysByAccount deletes every API key of the account and returns
+// how many there were. The keys cannot be recovered; new ones must be issued.
+func DeleteAPIKeysByAccount(database *sql.DB, accountID string) (int, error) {
+	res, err := database.Exec(`DELETE FROM api_keys WHERE account_id = ?`, accountID)
+	if err != nil {
+		return 0, err
+	}
+	n, _ := res.RowsAffected()
+	return int(n), nil
+}
+
 func GetAPIKeyByPrefix(database *sql.DB, prefix string) (*model.APIKey, error) {
 	k := &model.APIKey{}
 	var createdAt SQLiteTime
+	var expiresAt sql.NullString
 	err := database.QueryRow(
-		`SELECT id, account_id, name, key_prefix, key_hash, created_at
+		`SELECT id, account_id, name, key_prefix, key_hash, scopes, created_at, expires_at
 		 FROM api_keys WHERE key_prefix = ?`, prefix,
-	).Scan(&k.ID, &k.AccountID, &k.Name, &k.KeyPrefix, &k.KeyHash, &createdAt)
+	).Scan(&k.ID, &k.AccountID, &k.Name, &k.KeyPrefix, &k.KeyHash, &k.Scopes, &createdAt, &expiresAt)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -62,6 +86,7 @@ func GetAPIKeyByPrefix(database *sql.DB, prefix string) (*model.APIKey, error) {
 		return nil, err
 	}
 	k.CreatedAt = createdAt.Time
+	k.ExpiresAt = nullTimePtr(expiresAt)
 	return k, nil
 }
 
@@ -71,3 +96,16 @@ func TouchAPIKeyUsed(database *sql.DB, id string) error {
 	)
 	return err
 }
+
+// PruneExpiredAPIKeys deletes keys that expired before cutoff and returns how
+// many were removed.
+func PruneExpiredAPIKeys(database *sql.DB, cutoff time.Time) (int64, error) {
+	res, err := database.Exec(
+		`DELETE FROM api_keys WHERE expires_at IS NOT NULL AND expires_at < ?`,
+		cutoff.UTC().Format(time.RFC3339),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
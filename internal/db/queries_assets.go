@@ -2,26 +2,52 @@ package db
 
 import (
 	"database/sql"
+	"errors"
+	"time"
 
 	"github.com/YannKr/downloadonce/internal/model"
 )
 
+const insertAssetSQL = `INSERT INTO assets (id, account_id, title, asset_type, original_path,
+		  file_size_bytes, sha256_original, mime_type, duration_secs, resolution_w, resolution_h, source_mime_type)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+func assetArgs(a *model.Asset) []any {
+	return []any{a.ID, a.AccountID, a.OriginalName, a.AssetType, a.OriginalPath,
+		a.FileSize, a.SHA256, a.MimeType, a.Duration, a.Width, a.Height, a.SourceMimeType}
+}
+
 func CreateAsset(database *sql.DB, a *model.Asset) error {
-	_, err := database.Exec(
-		`INSERT INTO assets (id, account_id, title, asset_type, original_path,
-		  file_size_bytes, sha256_original, mime_type, duration_secs, resolution_w, resolution_h)
-		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
-		a.ID, a.AccountID, a.OriginalName, a.AssetType, a.OriginalPath,
-		a.FileSize, a.SHA256, a.MimeType, a.Duration, a.Width, a.Height,
-	)
+	_, err := database.Exec(insertAssetSQL, assetArgs(a)...)
 	return err
 }
 
+// CreateAssetWithinQuota inserts a unless it would take the account's stored
+// and pending upload bytes past limit, in which case it returns the bytes
+// already used and ErrUploadQuota. The check and the insert share one
+// transaction so concurrent uploads cannot overshoot the cap. A limit of 0 or
+// less means no cap.
+func CreateAssetWithinQuota(database *sql.DB, a *model.Asset, limit int64) (int64, error) {
+	tx, err := database.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if used, err := checkUploadQuota(tx, a.AccountID, a.FileSize, limit); err != nil {
+		return used, err
+	}
+	if _, err := tx.Exec(insertAssetSQL, assetArgs(a)...); err != nil {
+		return 0, err
+	}
+	return 0, tx.Commit()
+}
+
 func ListAssets(database *sql.DB) ([]model.Asset, error) {
 	rows, err := database.Query(
 		`SELECT id, account_id, title, asset_type, original_path,
-		  file_size_bytes, sha256_original, mime_type, duration_secs, resolution_w, resolution_h, created_at
-		 FROM assets ORDER BY created_at DESC`,
+		  file_size_bytes, sha256_original, mime_type, duration_secs, resolution_w, resolution_h, source_mime_type, created_at
+		 FROM assets WHERE deleted_at IS NULL ORDER BY created_at DESC`,
 	)
 	if err != nil {
 		return nil, err
@@ -34,7 +60,7 @@ func ListAssets(database *sql.DB) ([]model.Asset, error) {
 		var createdAt SQLiteTime
 		err := rows.Scan(&a.ID, &a.AccountID, &a.OriginalName, &a.AssetType,
 			&a.OriginalPath, &a.FileSize, &a.SHA256, &a.MimeType,
-			&a.Duration, &a.Width, &a.Height, &createdAt)
+			&a.Duration, &a.Width, &a.Height, &a.SourceMimeType, &createdAt)
 		if err != nil {
 			return nil, err
 		}
@@ -44,23 +70,157 @@ func ListAssets(database *sql.DB) ([]model.Asset, error) {
 	return assets, rows.Err()
 }
 
+// GetAsset returns the asset, including a soft-deleted one (DeletedAt set).
 func GetAsset(database *sql.DB, id string) (*model.Asset, error) {
 	a := &model.Asset{}
-	var createdAt SQLiteTime
+	var createdAt, deletedAt SQLiteTime
 	err := database.QueryRow(
 		`SELECT id, account_id, title, asset_type, original_path,
-		  file_size_bytes, sha256_original, mime_type, duration_secs, resolution_w, resolution_h, created_at
+		  file_size_bytes, sha256_original, mime_type, duration_secs, resolution_w, resolution_h, source_mime_type, created_at, deleted_at
 		 FROM assets WHERE id = ?`, id,
 	).Scan(&a.ID, &a.AccountID, &a.OriginalName, &a.AssetType,
 		&a.OriginalPath, &a.FileSize, &a.SHA256, &a.MimeType,
-		&a.Duration, &a.Width, &a.Height, &createdAt)
+		&a.Duration, &a.Width, &a.Height, &a.SourceMimeType, &createdAt, &deletedAt)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
 	a.CreatedAt = createdAt.Time
+	if !deletedAt.Time.IsZero() {
+		a.DeletedAt = &deletedAt.Time
+	}
 	return a, err
 }
 
+// ListDeletedAssets returns soft-deleted assets, most recently deleted first.
+func ListDeletedAssets(database *sql.DB) ([]model.Asset, error) {
+	rows, err := database.Query(
+		`SELECT id, account_id, title, asset_type, original_path,
+		  file_size_bytes, sha256_original, mime_type, duration_secs, resolution_w, resolution_h, source_mime_type, created_at, deleted_at
+		 FROM assets WHERE deleted_at IS NOT NULL ORDER BY deleted_at DESC`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var assets []model.Asset
+	for rows.Next() {
+		var a model.Asset
+		var createdAt, deletedAt SQLiteTime
+		err := rows.Scan(&a.ID, &a.AccountID, &a.OriginalName, &a.AssetType,
+			&a.OriginalPath, &a.FileSize, &a.SHA256, &a.MimeType,
+			&a.Duration, &a.Width, &a.Height, &a.SourceMimeType, &createdAt, &deletedAt)
+		if err != nil {
+			return nil, err
+		}
+		a.CreatedAt = createdAt.Time
+		a.DeletedAt = &deletedAt.Time
+		assets = append(assets, a)
+	}
+	return assets, rows.Err()
+}
+
+// GetAssetBySHA returns the account's oldest live asset whose original has
+// the given SHA-256, or nil if there is none.
+func GetAssetBySHA(database *sql.DB, accountID, sha256Hex string) (*model.Asset, error) {
+	a := &model.Asset{}
+	var createdAt SQLiteTime
+	err := database.QueryRow(
+		`SELECT id, account_id, title, asset_type, original_path,
+		  file_size_bytes, sha256_original, mime_type, duration_secs, resolution_w, resolution_h, source_mime_type, created_at
+		 FROM assets WHERE account_id = ? AND sha256_original = ? AND deleted_at IS NULL
+		 ORDER BY created_at LIMIT 1`, accountID, sha256Hex,
+	).Scan(&a.ID, &a.AccountID, &a.OriginalName, &a.AssetType,
+		&a.OriginalPath, &a.FileSize, &a.SHA256, &a.MimeType,
+		&a.Duration, &a.Width, &a.Height, &a.SourceMimeType, &createdAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	a.CreatedAt = createdAt.Time
+	return a, err
+}
+
+// ErrUploadQuota is returned by CreateAssetWithinQuota and
+// CreateUploadSessionWithinQuota when the account has no room left under its
+// upload cap.
+var ErrUploadQuota = errors.New("account upload cap reached")
+
+// checkUploadQuota returns ErrUploadQuota and the account's used bytes (see
+// SumAssetBytesByAccount and SumPendingUploadBytes) when adding size more
+// would exceed limit. A limit of 0 or less means no cap.
+func checkUploadQuota(tx *sql.Tx, accountID string, size, limit int64) (int64, error) {
+	if limit <= 0 {
+		return 0, nil
+	}
+	var used int64
+	err := tx.QueryRow(
+		`SELECT (SELECT COALESCE(SUM(file_size_bytes), 0) FROM assets WHERE account_id = ?)
+		      + (SELECT COALESCE(SUM(size), 0) FROM upload_sessions WHERE account_id = ? AND status = 'PENDING')`,
+		accountID, accountID,
+	).Scan(&used)
+	if err != nil {
+		return 0, err
+	}
+	if used+size > limit {
+		return used, ErrUploadQuota
+	}
+	return used, nil
+}
+
+// SumAssetBytesByAccount totals the original file sizes of the account's assets.
+func SumAssetBytesByAccount(database *sql.DB, accountID string) (int64, error) {
+	var n int64
+	err := database.QueryRow(
+		`SELECT COALESCE(SUM(file_size_bytes), 0) FROM assets WHERE account_id = ?`, accountID,
+	).Scan(&n)
+	return n, err
+}
+
+// assetHasCopiesCond matches an asset (bound as its ID) used by a campaign
+// that has left DRAFT or holds tokens already watermarked from it.
+const assetHasCopiesCond = `EXISTS (
+		   SELECT 1 FROM campaigns c
+		   WHERE c.asset_id = ? AND (c.state != 'DRAFT' OR EXISTS (
+		     SELECT 1 FROM download_tokens t
+		     WHERE t.campaign_id = c.id AND COALESCE(t.watermarked_path, '') != ''
+		   ))
+		 )`
+
+// ErrAssetHasCopies is returned by ReplaceAssetFile when the asset gained
+// watermarked copies since the caller checked AssetHasWatermarkedCopies.
+var ErrAssetHasCopies = errors.New("asset has watermarked copies")
+
+// AssetHasWatermarkedCopies reports whether any campaign using the asset has
+// left DRAFT or holds tokens already watermarked from it (e.g. after a
+// cancelled publish). Replacing the source would then diverge from copies
+// recipients can download.
+func AssetHasWatermarkedCopies(database *sql.DB, assetID string) (bool, error) {
+	var found bool
+	err := database.QueryRow(`SELECT `+assetHasCopiesCond, assetID).Scan(&found)
+	return found, err
+}
+
+// ReplaceAssetFile points an asset at a new original file, keeping its ID so
+// DRAFT campaigns that reference it are unaffected. The update re-checks
+// AssetHasWatermarkedCopies in the same statement, so a publish that started
+// after the caller's check wins and ErrAssetHasCopies is returned.
+func ReplaceAssetFile(database *sql.DB, a *model.Asset) error {
+	res, err := database.Exec(
+		`UPDATE assets SET title = ?, original_path = ?, file_size_bytes = ?, sha256_original = ?,
+		  mime_type = ?, duration_secs = ?, resolution_w = ?, resolution_h = ?, source_mime_type = ?
+		 WHERE id = ? AND NOT `+assetHasCopiesCond,
+		a.OriginalName, a.OriginalPath, a.FileSize, a.SHA256,
+		a.MimeType, a.Duration, a.Width, a.Height, a.SourceMimeType, a.ID, a.ID,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrAssetHasCopies
+	}
+	return nil
+}
+
 func RenameAsset(database *sql.DB, id, title string) error {
 	_, err := database.Exec(`UPDATE assets SET title = ? WHERE id = ?`, title, id)
 	return err
@@ -70,3 +230,91 @@ func DeleteAsset(database *sql.DB, id string) error {
 	_, err := database.Exec(`DELETE FROM assets WHERE id = ?`, id)
 	return err
 }
+
+// SoftDeleteAsset hides the asset from listings until it is restored or
+// purged. Its files are left in place.
+func SoftDeleteAsset(database *sql.DB, id string) error {
+	_, err := database.Exec(
+		`UPDATE assets SET deleted_at = strftime('%Y-%m-%dT%H:%M:%fZ', 'now') WHERE id = ? AND deleted_at IS NULL`, id)
+	return err
+}
+
+func RestoreAsset(database *sql.DB, id string) error {
+	_, err := database.Exec(`UPDATE assets SET deleted_at = NULL WHERE id = ?`, id)
+	return err
+}
+
+// CountCampaignsUsingAsset counts the campaigns using the asset that are
+// neither archived nor deleted; such an asset may not be deleted.
+func CountCampaignsUsingAsset(database *sql.DB, assetID string) (int, error) {
+	var n int
+	err := database.QueryRow(
+		`SELECT COUNT(*) FROM campaigns WHERE asset_id = ? AND state != 'ARCHIVED' AND deleted_at IS NULL`, assetID,
+	).Scan(&n)
+	return n, err
+}
+
+// ListCampaignNamesUsingAsset returns the names of up to limit campaigns
+// counted by CountCampaignsUsingAsset, oldest first.
+func ListCampaignNamesUsingAsset(database *sql.DB, assetID string, limit int) ([]string, error) {
+	rows, err := database.Query(
+		`SELECT name FROM campaigns WHERE asset_id = ? AND state != 'ARCHIVED' AND deleted_at IS NULL
+		 ORDER BY created_at LIMIT ?`, assetID, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// ListPurgeableAssets returns the IDs of assets soft-deleted before cutoff
+// that no campaign references any more, deleted or not.
+func ListPurgeableAssets(database *sql.DB, cutoff time.Time) ([]string, error) {
+	rows, err := database.Query(
+		`SELECT id FROM assets a
+		 WHERE deleted_at IS NOT NULL AND deleted_at < ?
+		   AND NOT EXISTS (SELECT 1 FROM campaigns c WHERE c.asset_id = a.id)`,
+		cutoff.UTC().Format(time.RFC3339),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// ListAssetPaths maps the ID of every asset, soft-deleted included, to its
+// original_path.
+func ListAssetPaths(database *sql.DB) (map[string]string, error) {
+	rows, err := database.Query(`SELECT id, original_path FROM assets`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	paths := make(map[string]string)
+	for rows.Next() {
+		var id, p string
+		if err := rows.Scan(&id, &p); err != nil {
+			return nil, err
+		}
+		paths[id] = p
+	}
+	return paths, rows.Err()
+}
@@ -0,0 +1,63 @@
+package db
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/YannKr/downloadonce/internal/model"
+)
+
+func TestReplaceAssetFileRechecksCopies(t *testing.T) {
+	database := openTestDB(t)
+	seedCampaign(t, database, "acc", "camp", "alice")
+
+	a, err := GetAsset(database, "camp-asset")
+	if err != nil || a == nil {
+		t.Fatalf("GetAsset: %v, %v", a, err)
+	}
+	a.SHA256 = "11"
+	// The campaign was published after any earlier check by the caller.
+	if err := ReplaceAssetFile(database, a); !errors.Is(err, ErrAssetHasCopies) {
+		t.Fatalf("replace while PROCESSING: got %v, want ErrAssetHasCopies", err)
+	}
+	if got, _ := GetAsset(database, "camp-asset"); got.SHA256 != "00" {
+		t.Fatalf("sha = %q after refused replace, want 00", got.SHA256)
+	}
+
+	if err := UpdateCampaignState(database, "camp", "DRAFT"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ReplaceAssetFile(database, a); err != nil {
+		t.Fatalf("replace while DRAFT: %v", err)
+	}
+	if got, _ := GetAsset(database, "camp-asset"); got.SHA256 != "11" {
+		t.Errorf("sha = %q, want 11", got.SHA256)
+	}
+}
+
+func TestCreateWithinQuota(t *testing.T) {
+	database := openTestDB(t)
+	seedCampaign(t, database, "acc", "camp") // one 1024-byte asset
+
+	now := time.Now()
+	session := &model.UploadSession{ID: "s1", AccountID: "acc", Filename: "a.mp4", Size: 1000,
+		MimeType: "video/mp4", ChunkSize: 1000, TotalChunks: 1, Status: "PENDING",
+		CreatedAt: now, UpdatedAt: now, ExpiresAt: now.Add(time.Hour)}
+	if _, err := CreateUploadSessionWithinQuota(database, session, 2048); err != nil {
+		t.Fatalf("session within cap: %v", err)
+	}
+
+	asset := &model.Asset{ID: "a2", AccountID: "acc", OriginalName: "b.png", AssetType: "image",
+		OriginalPath: "originals/a2/source.png", FileSize: 100, SHA256: "22", MimeType: "image/png"}
+	used, err := CreateAssetWithinQuota(database, asset, 2048)
+	if !errors.Is(err, ErrUploadQuota) || used != 2024 {
+		t.Fatalf("asset over cap: used %d, %v; want 2024, ErrUploadQuota", used, err)
+	}
+	if a, _ := GetAsset(database, "a2"); a != nil {
+		t.Fatal("asset inserted despite the cap")
+	}
+	if _, err := CreateAssetWithinQuota(database, asset, 0); err != nil {
+		t.Fatalf("asset without cap: %v", err)
+	}
+}
@@ -10,6 +10,8 @@ import (
 type AuditLog struct {
 	ID         string
 	AccountID  string
+	ActorEmail string // '' when the acting account no longer exists
+	ActorName  string
 	Action     string
 	TargetType string
 	TargetID   string
@@ -18,6 +20,56 @@ type AuditLog struct {
 	CreatedAt  time.Time
 }
 
+// AuditActions lists every action the application records, in the order the
+// admin filter shows them. Add new actions here so they can be filtered on.
+var AuditActions = []string{
+	"login", "login_2fa_failed", "logout", "password_changed", "password_reset_requested",
+	"2fa_enabled", "2fa_disabled", "session_revoked", "sessions_revoked",
+	"user_created", "user_deleted", "user_promoted", "user_enabled", "user_disabled",
+	"user_campaign_limit", "user_upload_types",
+	"api_key_created", "api_key_deleted", "api_keys_revoked",
+	"asset_uploaded", "asset_uploaded_chunked", "asset_upload_deduplicated", "asset_replaced",
+	"asset_deleted", "asset_restored",
+	"campaign_created", "campaign_cloned", "campaign_submitted", "campaign_approved", "campaign_rejected",
+	"campaign_withdrawn", "campaign_scheduled", "campaign_unscheduled", "campaign_schedule_failed",
+	"campaign_published", "campaign_retry_failed", "campaign_cancelled",
+	"campaign_archived", "campaign_deleted", "campaign_restored",
+	"campaign_bundle_downloaded", "campaign_files_exported",
+	"token_revoked", "token_reissued", "token_retry",
+	"recipient_created", "recipient_deleted", "recipients_added", "recipients_imported",
+	"group_created", "group_updated", "group_deleted", "group_import", "group_member_added", "group_member_removed",
+	"webhook_created", "webhook_deleted", "webhook_delivery_replayed", "webhook_deliveries_replayed",
+}
+
+// IsAuditAction reports whether action is one of AuditActions.
+func IsAuditAction(action string) bool {
+	for _, a := range AuditActions {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+// AuditLogFilter narrows audit log queries. Empty fields match everything;
+// Start and End are inclusive YYYY-MM-DD dates.
+type AuditLogFilter struct {
+	Action string
+	Start  string
+	End    string
+}
+
+const auditLogWhere = `(? = '' OR al.action = ?)
+	  AND (? = '' OR date(al.created_at) >= ?)
+	  AND (? = '' OR date(al.created_at) <= ?)`
+
+func (f AuditLogFilter) args() []any {
+	return []any{f.Action, f.Action, f.Start, f.Start, f.End, f.End}
+}
+
+const auditLogColumns = `al.id, al.account_id, COALESCE(a.email, ''), COALESCE(a.name, ''),
+	  al.action, al.target_type, al.target_id, al.detail, al.ip_address, al.created_at`
+
 func InsertAuditLog(database *sql.DB, accountID, action, targetType, targetID, detail, ipAddress string) {
 	go func() {
 		_, _ = database.Exec(
@@ -27,48 +79,84 @@ func InsertAuditLog(database *sql.DB, accountID, action, targetType, targetID, d
 	}()
 }
 
-func ListAuditLogs(database *sql.DB, limit, offset int, filterAction string) ([]AuditLog, error) {
-	var rows *sql.Rows
-	var err error
-
-	if filterAction != "" {
-		rows, err = database.Query(
-			`SELECT id, account_id, action, target_type, target_id, detail, ip_address, created_at
-			 FROM audit_logs WHERE action = ? ORDER BY created_at DESC LIMIT ? OFFSET ?`,
-			filterAction, limit, offset,
-		)
-	} else {
-		rows, err = database.Query(
-			`SELECT id, account_id, action, target_type, target_id, detail, ip_address, created_at
-			 FROM audit_logs ORDER BY created_at DESC LIMIT ? OFFSET ?`,
-			limit, offset,
-		)
-	}
+func ListAuditLogs(database *sql.DB, limit, offset int, f AuditLogFilter) ([]AuditLog, error) {
+	rows, err := database.Query(`
+		SELECT `+auditLogColumns+`
+		FROM audit_logs al LEFT JOIN accounts a ON a.id = al.account_id
+		WHERE `+auditLogWhere+`
+		ORDER BY al.created_at DESC, al.id DESC LIMIT ? OFFSET ?`,
+		append(f.args(), limit, offset)...,
+	)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
+	logs, _, err := scanAuditLogs(rows)
+	return logs, err
+}
 
-	var logs []AuditLog
+func CountAuditLogs(database *sql.DB, f AuditLogFilter) (int, error) {
+	var count int
+	err := database.QueryRow(`SELECT COUNT(*) FROM audit_logs al WHERE `+auditLogWhere, f.args()...).Scan(&count)
+	return count, err
+}
+
+// ExportAuditLogs calls fn for every audit log entry matching f, newest
+// first. Like ExportDownloadEvents it reads keyset-paginated pages of
+// ExportPageSize and closes each query before fn runs.
+func ExportAuditLogs(database *sql.DB, f AuditLogFilter, fn func(AuditLog) error) error {
+	var afterAt, afterID string // cursor: last row of the previous page
+	for {
+		rows, err := database.Query(`
+			SELECT `+auditLogColumns+`
+			FROM audit_logs al LEFT JOIN accounts a ON a.id = al.account_id
+			WHERE `+auditLogWhere+`
+			  AND (? = '' OR al.created_at < ? OR (al.created_at = ? AND al.id < ?))
+			ORDER BY al.created_at DESC, al.id DESC
+			LIMIT ?`,
+			append(f.args(), afterAt, afterAt, afterAt, afterID, ExportPageSize)...,
+		)
+		if err != nil {
+			return err
+		}
+		page, lastAt, err := scanAuditLogs(rows)
+		rows.Close()
+		if err != nil {
+			return err
+		}
+		for _, l := range page {
+			if err := fn(l); err != nil {
+				return err
+			}
+		}
+		if len(page) < ExportPageSize {
+			return nil
+		}
+		afterAt, afterID = lastAt, page[len(page)-1].ID
+	}
+}
+
+// scanAuditLogs reads rows selected with auditLogColumns. lastAt is the raw
+// created_at of the final row, for use as a keyset cursor.
+func scanAuditLogs(rows *sql.Rows) (logs []AuditLog, lastAt string, err error) {
 	for rows.Next() {
 		var l AuditLog
 		var createdAt SQLiteTime
-		if err := rows.Scan(&l.ID, &l.AccountID, &l.Action, &l.TargetType, &l.TargetID, &l.Detail, &l.IPAddress, &createdAt); err != nil {
-			return nil, err
+		if err := rows.Scan(&l.ID, &l.AccountID, &l.ActorEmail, &l.ActorName, &l.Action, &l.TargetType, &l.TargetID, &l.Detail, &l.IPAddress, &lastAt); err != nil {
+			return nil, "", err
+		}
+		if err := createdAt.Scan(lastAt); err != nil {
+			return nil, "", err
 		}
 		l.CreatedAt = createdAt.Time
 		logs = append(logs, l)
 	}
-	return logs, rows.Err()
+	return logs, lastAt, rows.Err()
 }
 
-func CountAuditLogs(database *sql.DB, filterAction string) (int, error) {
-	var count int
-	var err error
-	if filterAction != "" {
-		err = database.QueryRow(`SELECT COUNT(*) FROM audit_logs WHERE action = ?`, filterAction).Scan(&count)
-	} else {
-		err = database.QueryRow(`SELECT COUNT(*) FROM audit_logs`).Scan(&count)
-	}
-	return count, err
+// PruneOldAuditLogs deletes audit log entries older than cutoff.
+func PruneOldAuditLogs(database *sql.DB, cutoff time.Time) (int64, error) {
+	return pruneInBatches(database,
+		`DELETE FROM audit_logs WHERE id IN (SELECT id FROM audit_logs WHERE created_at < ? LIMIT ?)`,
+		cutoff.UTC().Format("2006-01-02T15:04:05.000Z"))
 }
@@ -0,0 +1,74 @@
+package db
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/YannKr/downloadonce/internal/model"
+)
+
+func TestExportAuditLogsFilters(t *testing.T) {
+	database := openTestDB(t)
+	if err := CreateAccount(database, &model.Account{
+		ID: "acc", Email: "acc@example.com", Name: "Ann", PasswordHash: "x", Role: "admin", Enabled: true,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	// Rows are inserted directly: InsertAuditLog writes asynchronously.
+	insert := func(id, account, action, at string) {
+		t.Helper()
+		if _, err := database.Exec(`INSERT INTO audit_logs (id, account_id, action, target_type, target_id, created_at)
+			VALUES (?, ?, ?, 'campaign', 'camp', ?)`, id, account, action, at); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 25; i++ {
+		insert(fmt.Sprintf("m%02d", i), "acc", "login", fmt.Sprintf("2025-03-%02dT10:00:00.000Z", i%5+1))
+	}
+	insert("feb", "acc", "login", "2025-02-28T23:59:59.000Z")
+	insert("gone", "deleted-acc", "campaign_created", "2025-03-02T00:00:00.000Z")
+
+	defer func(old int) { ExportPageSize = old }(ExportPageSize)
+	ExportPageSize = 4
+
+	var got []AuditLog
+	f := AuditLogFilter{Action: "login", Start: "2025-03-01", End: "2025-03-05"}
+	if err := ExportAuditLogs(database, f, func(l AuditLog) error {
+		got = append(got, l)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 25 {
+		t.Fatalf("exported %d rows, want 25", len(got))
+	}
+	seen := map[string]bool{}
+	for i, l := range got {
+		if seen[l.ID] {
+			t.Errorf("row %s exported twice", l.ID)
+		}
+		seen[l.ID] = true
+		if i > 0 && l.CreatedAt.After(got[i-1].CreatedAt) {
+			t.Errorf("row %d out of order", i)
+		}
+		if l.ActorEmail != "acc@example.com" || l.ActorName != "Ann" {
+			t.Errorf("row %s actor = %q %q", l.ID, l.ActorEmail, l.ActorName)
+		}
+	}
+	if n, _ := CountAuditLogs(database, f); n != 25 {
+		t.Errorf("count = %d, want 25", n)
+	}
+
+	all, err := ListAuditLogs(database, 100, 0, AuditLogFilter{Start: "2025-03-02", End: "2025-03-02"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 6 {
+		t.Errorf("entries on 2025-03-02 = %d, want 6", len(all))
+	}
+	for _, l := range all {
+		if l.ID == "gone" && l.ActorEmail != "" {
+			t.Errorf("deleted actor email = %q, want empty", l.ActorEmail)
+		}
+	}
+}
@@ -16,25 +16,36 @@ func CreateCampaign(database *sql.DB, c *model.Campaign) error {
 		expiresAt = &s
 	}
 	_, err := database.Exec(
-		`INSERT INTO campaigns (id, account_id, asset_id, name, max_downloads, expires_at, visible_wm, invisible_wm, state)
-		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		`INSERT INTO campaigns (id, account_id, asset_id, name, max_downloads, expires_at, visible_wm, invisible_wm, wm_channels, wm_scale, wm_text_template,
+		   visible_wm_position, visible_wm_opacity, visible_wm_font_size, download_message, expiry_message, allow_link_requests,
+		   max_total_downloads, filename_template, wm_self_verify, state)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, NULLIF(?, ''), ?, NULLIF(?, ''), NULLIF(?, ''), ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
 		c.ID, c.AccountID, c.AssetID, c.Name, c.MaxDownloads, expiresAt,
-		boolToInt(c.VisibleWM), boolToInt(c.InvisibleWM), c.State,
+		boolToInt(c.VisibleWM), boolToInt(c.InvisibleWM), c.WMChannels, c.WMScale, c.WMTextTemplate,
+		c.VisiblePosition, c.VisibleOpacity, c.VisibleFontSize, c.DownloadMessage,
+		c.ExpiryMessage, boolToInt(c.LinkRequests), c.MaxTotalDownloads, c.FilenameTemplate, c.SelfVerify, c.State,
 	)
 	return err
 }
 
 func GetCampaign(database *sql.DB, id string) (*model.Campaign, error) {
 	c := &model.Campaign{}
-	var visibleWM, invisibleWM int
-	var expiresAt, publishedAt *string
-	var createdAt SQLiteTime
+	var visibleWM, invisibleWM, allowLinkRequests int
+	var expiresAt, publishedAt, approvedAt, publishAt *string
+	var createdAt, deletedAt SQLiteTime
+	var selfVerify sql.NullBool
 	err := database.QueryRow(
 		`SELECT id, account_id, asset_id, name, max_downloads, expires_at,
-		  visible_wm, invisible_wm, state, created_at, published_at
+		  visible_wm, invisible_wm, COALESCE(wm_channels, ''), wm_scale, COALESCE(wm_text_template, ''),
+		  COALESCE(visible_wm_position, ''), visible_wm_opacity, visible_wm_font_size, download_message,
+		  expiry_message, allow_link_requests, max_total_downloads, total_downloads, filename_template,
+		  wm_self_verify, state, created_at, published_at, COALESCE(approved_by, ''), approved_at, deleted_at, publish_at
 		 FROM campaigns WHERE id = ?`, id,
 	).Scan(&c.ID, &c.AccountID, &c.AssetID, &c.Name, &c.MaxDownloads, &expiresAt,
-		&visibleWM, &invisibleWM, &c.State, &createdAt, &publishedAt)
+		&visibleWM, &invisibleWM, &c.WMChannels, &c.WMScale, &c.WMTextTemplate,
+		&c.VisiblePosition, &c.VisibleOpacity, &c.VisibleFontSize, &c.DownloadMessage,
+		&c.ExpiryMessage, &allowLinkRequests, &c.MaxTotalDownloads, &c.TotalDownloads, &c.FilenameTemplate,
+		&selfVerify, &c.State, &createdAt, &publishedAt, &c.ApprovedBy, &approvedAt, &deletedAt, &publishAt)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -42,8 +53,15 @@ func GetCampaign(database *sql.DB, id string) (*model.Campaign, error) {
 		return nil, err
 	}
 	c.CreatedAt = createdAt.Time
+	if !deletedAt.Time.IsZero() {
+		c.DeletedAt = &deletedAt.Time
+	}
 	c.VisibleWM = visibleWM != 0
 	c.InvisibleWM = invisibleWM != 0
+	c.LinkRequests = allowLinkRequests != 0
+	if selfVerify.Valid {
+		c.SelfVerify = &selfVerify.Bool
+	}
 	if expiresAt != nil {
 		t, _ := time.Parse(time.RFC3339, *expiresAt)
 		c.ExpiresAt = &t
@@ -52,13 +70,22 @@ func GetCampaign(database *sql.DB, id string) (*model.Campaign, error) {
 		t, _ := time.Parse(time.RFC3339, *publishedAt)
 		c.PublishedAt = &t
 	}
+	if approvedAt != nil {
+		t, _ := time.Parse(time.RFC3339, *approvedAt)
+		c.ApprovedAt = &t
+	}
+	if publishAt != nil {
+		t, _ := time.Parse(time.RFC3339, *publishAt)
+		c.PublishAt = &t
+	}
 	return c, nil
 }
 
 func ListCampaigns(database *sql.DB, accountID string, showAll bool, showArchived bool) ([]model.CampaignSummary, error) {
 	query := `
 		SELECT c.id, c.account_id, c.asset_id, c.name, c.max_downloads, c.expires_at,
-		  c.visible_wm, c.invisible_wm, c.state, c.created_at, c.published_at,
+		  c.visible_wm, c.invisible_wm, c.max_total_downloads, c.total_downloads,
+		  c.state, c.created_at, c.published_at,
 		  a.title AS asset_name, a.asset_type,
 		  (SELECT COUNT(*) FROM download_tokens WHERE campaign_id = c.id) AS recipient_count,
 		  (SELECT COUNT(DISTINCT de.token_id) FROM download_events de
@@ -71,9 +98,9 @@ func ListCampaigns(database *sql.DB, accountID string, showAll bool, showArchive
 		JOIN assets a ON a.id = c.asset_id
 		JOIN accounts acc ON acc.id = c.account_id`
 
-	archivedFilter := ` AND c.state != 'ARCHIVED'`
+	archivedFilter := ` AND c.state != 'ARCHIVED' AND c.deleted_at IS NULL`
 	if showArchived {
-		archivedFilter = ` AND c.state = 'ARCHIVED'`
+		archivedFilter = ` AND c.state = 'ARCHIVED' AND c.deleted_at IS NULL`
 	}
 
 	var rows *sql.Rows
@@ -98,7 +125,8 @@ func ListCampaigns(database *sql.DB, accountID string, showAll bool, showArchive
 		var createdAt SQLiteTime
 		err := rows.Scan(
 			&cs.ID, &cs.AccountID, &cs.AssetID, &cs.Name, &cs.MaxDownloads, &expiresAt,
-			&visibleWM, &invisibleWM, &cs.State, &createdAt, &publishedAt,
+			&visibleWM, &invisibleWM, &cs.MaxTotalDownloads, &cs.TotalDownloads,
+			&cs.State, &createdAt, &publishedAt,
 			&cs.AssetName, &cs.AssetType,
 			&cs.RecipientCount, &cs.DownloadedCount,
 			&cs.JobsTotal, &cs.JobsCompleted, &cs.JobsFailed,
@@ -152,6 +180,102 @@ func SetCampaignPublishedReady(database *sql.DB, id string) error {
 	return err
 }
 
+// SubmitCampaignForApproval moves a DRAFT campaign to PENDING_APPROVAL. It
+// reports false when the campaign was not in DRAFT.
+func SubmitCampaignForApproval(database *sql.DB, id string) (bool, error) {
+	res, err := database.Exec(`UPDATE campaigns SET state = 'PENDING_APPROVAL' WHERE id = ? AND state = 'DRAFT'`, id)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n == 1, nil
+}
+
+// ApproveCampaign records approverID's sign-off on a PENDING_APPROVAL
+// campaign and returns it to DRAFT, ready to publish. It reports false when
+// the campaign was not pending approval.
+func ApproveCampaign(database *sql.DB, id, approverID string) (bool, error) {
+	res, err := database.Exec(
+		`UPDATE campaigns SET state = 'DRAFT', approved_by = ?, approved_at = strftime('%Y-%m-%dT%H:%M:%fZ', 'now')
+		 WHERE id = ? AND state = 'PENDING_APPROVAL'`,
+		approverID, id,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n == 1, nil
+}
+
+// RejectCampaign returns a PENDING_APPROVAL campaign to DRAFT unapproved.
+func RejectCampaign(database *sql.DB, id string) (bool, error) {
+	res, err := database.Exec(`UPDATE campaigns SET state = 'DRAFT' WHERE id = ? AND state = 'PENDING_APPROVAL'`, id)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n == 1, nil
+}
+
+// ClearCampaignApproval drops a recorded approval, e.g. after the recipient
+// list changes.
+func ClearCampaignApproval(database *sql.DB, id string) error {
+	_, err := database.Exec(`UPDATE campaigns SET approved_by = NULL, approved_at = NULL WHERE id = ?`, id)
+	return err
+}
+
+// ScheduleCampaign sets a DRAFT or already SCHEDULED campaign to publish at
+// the given time. It reports false when the campaign was in another state or
+// is deleted.
+func ScheduleCampaign(database *sql.DB, id string, at time.Time) (bool, error) {
+	res, err := database.Exec(
+		`UPDATE campaigns SET state = 'SCHEDULED', publish_at = ?
+		 WHERE id = ? AND state IN ('DRAFT', 'SCHEDULED') AND deleted_at IS NULL`,
+		at.UTC().Format(time.RFC3339), id,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n == 1, nil
+}
+
+// UnscheduleCampaign returns a SCHEDULED campaign to DRAFT. It reports false
+// when the campaign was not scheduled, so the scheduler also uses it to claim
+// a due campaign before publishing it.
+func UnscheduleCampaign(database *sql.DB, id string) (bool, error) {
+	res, err := database.Exec(`UPDATE campaigns SET state = 'DRAFT', publish_at = NULL WHERE id = ? AND state = 'SCHEDULED'`, id)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n == 1, nil
+}
+
+// ListDueScheduledCampaigns returns the IDs of SCHEDULED campaigns whose
+// publish_at is not after now, oldest first.
+func ListDueScheduledCampaigns(database *sql.DB, now time.Time) ([]string, error) {
+	rows, err := database.Query(
+		`SELECT id FROM campaigns
+		 WHERE state = 'SCHEDULED' AND publish_at <= ? AND deleted_at IS NULL
+		 ORDER BY publish_at`,
+		now.UTC().Format(time.RFC3339),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
 func boolToInt(b bool) int {
 	if b {
 		return 1
@@ -198,11 +322,100 @@ func ListExpiredCampaigns(database *sql.DB) ([]model.Campaign, error) {
 	return campaigns, rows.Err()
 }
 
+// CountActiveCampaignsByAccount counts the account's campaigns that are
+// neither archived nor deleted, which is what MAX_CAMPAIGNS_PER_ACCOUNT limits.
+func CountActiveCampaignsByAccount(database *sql.DB, accountID string) (int, error) {
+	var n int
+	err := database.QueryRow(
+		`SELECT COUNT(*) FROM campaigns WHERE account_id = ? AND state != 'ARCHIVED' AND deleted_at IS NULL`, accountID,
+	).Scan(&n)
+	return n, err
+}
+
 func ArchiveCampaign(database *sql.DB, id string) error {
 	_, err := database.Exec(`UPDATE campaigns SET state = 'ARCHIVED' WHERE id = ?`, id)
 	return err
 }
 
+// SoftDeleteCampaign hides the campaign from listings until it is restored or
+// purged. Its watermarked files are left in place.
+func SoftDeleteCampaign(database *sql.DB, id string) error {
+	_, err := database.Exec(
+		`UPDATE campaigns SET deleted_at = strftime('%Y-%m-%dT%H:%M:%fZ', 'now') WHERE id = ? AND deleted_at IS NULL`, id)
+	return err
+}
+
+func RestoreCampaign(database *sql.DB, id string) error {
+	_, err := database.Exec(`UPDATE campaigns SET deleted_at = NULL WHERE id = ?`, id)
+	return err
+}
+
+// ListDeletedCampaigns returns soft-deleted campaigns, most recently deleted
+// first; all accounts' when showAll is set.
+func ListDeletedCampaigns(database *sql.DB, accountID string, showAll bool) ([]model.Campaign, error) {
+	rows, err := database.Query(`
+		SELECT id, account_id, asset_id, name, state, created_at, deleted_at
+		FROM campaigns
+		WHERE deleted_at IS NOT NULL AND (? OR account_id = ?)
+		ORDER BY deleted_at DESC`, showAll, accountID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var campaigns []model.Campaign
+	for rows.Next() {
+		var c model.Campaign
+		var createdAt, deletedAt SQLiteTime
+		if err := rows.Scan(&c.ID, &c.AccountID, &c.AssetID, &c.Name, &c.State, &createdAt, &deletedAt); err != nil {
+			return nil, err
+		}
+		c.CreatedAt = createdAt.Time
+		c.DeletedAt = &deletedAt.Time
+		campaigns = append(campaigns, c)
+	}
+	return campaigns, rows.Err()
+}
+
+// ListPurgeableCampaigns returns the IDs of campaigns soft-deleted before
+// cutoff.
+func ListPurgeableCampaigns(database *sql.DB, cutoff time.Time) ([]string, error) {
+	rows, err := database.Query(
+		`SELECT id FROM campaigns WHERE deleted_at IS NOT NULL AND deleted_at < ?`,
+		cutoff.UTC().Format(time.RFC3339),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// PurgeCampaign permanently deletes a soft-deleted campaign with its jobs,
+// tokens and (through the tokens) download history.
+func PurgeCampaign(database *sql.DB, id string) error {
+	tx, err := database.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`DELETE FROM jobs WHERE campaign_id = ?`, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM campaigns WHERE id = ? AND deleted_at IS NOT NULL`, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
 func ExpireCampaignAndTokens(database *sql.DB, campaignID string) error {
 	_, err := database.Exec(`UPDATE campaigns SET state = 'EXPIRED' WHERE id = ?`, campaignID)
 	if err != nil {
@@ -228,11 +441,17 @@ func CloneCampaign(database *sql.DB, newCampaign *model.Campaign, recipientIDs [
 	}
 
 	_, err = tx.Exec(
-		`INSERT INTO campaigns (id, account_id, asset_id, name, max_downloads, expires_at, visible_wm, invisible_wm, state)
-		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, 'DRAFT')`,
+		`INSERT INTO campaigns (id, account_id, asset_id, name, max_downloads, expires_at, visible_wm, invisible_wm, wm_channels, wm_scale, wm_text_template,
+		   visible_wm_position, visible_wm_opacity, visible_wm_font_size, download_message, expiry_message, allow_link_requests,
+		   max_total_downloads, filename_template, wm_self_verify, state)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, NULLIF(?, ''), ?, NULLIF(?, ''), NULLIF(?, ''), ?, ?, ?, ?, ?, ?, ?, ?, 'DRAFT')`,
 		newCampaign.ID, newCampaign.AccountID, newCampaign.AssetID,
 		newCampaign.Name, newCampaign.MaxDownloads, expiresAt,
 		boolToInt(newCampaign.VisibleWM), boolToInt(newCampaign.InvisibleWM),
+		newCampaign.WMChannels, newCampaign.WMScale, newCampaign.WMTextTemplate,
+		newCampaign.VisiblePosition, newCampaign.VisibleOpacity, newCampaign.VisibleFontSize,
+		newCampaign.DownloadMessage, newCampaign.ExpiryMessage, boolToInt(newCampaign.LinkRequests),
+		newCampaign.MaxTotalDownloads, newCampaign.FilenameTemplate, newCampaign.SelfVerify,
 	)
 	if err != nil {
 		return 0, err
@@ -253,3 +472,50 @@ func CloneCampaign(database *sql.DB, newCampaign *model.Campaign, recipientIDs [
 
 	return skipped, tx.Commit()
 }
+
+// InvisibleParamOverride is a campaign-level override of the invisible
+// watermark params. Empty Channels or a nil Scale inherit the global default.
+type InvisibleParamOverride struct {
+	Channels string
+	Scale    *float64
+}
+
+// ListInvisibleParamOverrides returns the distinct overrides set on campaigns
+// with invisible watermarking enabled.
+func ListInvisibleParamOverrides(database *sql.DB) ([]InvisibleParamOverride, error) {
+	rows, err := database.Query(`
+		SELECT DISTINCT COALESCE(wm_channels, ''), wm_scale FROM campaigns
+		WHERE invisible_wm = 1 AND (wm_channels IS NOT NULL OR wm_scale IS NOT NULL)`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var overrides []InvisibleParamOverride
+	for rows.Next() {
+		var o InvisibleParamOverride
+		if err := rows.Scan(&o.Channels, &o.Scale); err != nil {
+			return nil, err
+		}
+		overrides = append(overrides, o)
+	}
+	return overrides, rows.Err()
+}
+
+// ListCampaignIDs returns the IDs of all campaigns, soft-deleted included.
+func ListCampaignIDs(database *sql.DB) (map[string]bool, error) {
+	rows, err := database.Query(`SELECT id FROM campaigns`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ids := make(map[string]bool)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids[id] = true
+	}
+	return ids, rows.Err()
+}
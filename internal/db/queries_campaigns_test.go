@@ -0,0 +1,75 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPurgeSoftDeleted(t *testing.T) {
+	database := openTestDB(t)
+	seedCampaign(t, database, "acc", "camp", "r1", "r2")
+	if _, err := database.Exec(`UPDATE campaigns SET state = 'ARCHIVED' WHERE id = 'camp'`); err != nil {
+		t.Fatal(err)
+	}
+	if err := SoftDeleteAsset(database, "camp-asset"); err != nil {
+		t.Fatal(err)
+	}
+	if err := SoftDeleteCampaign(database, "camp"); err != nil {
+		t.Fatal(err)
+	}
+	cutoff := time.Now().Add(time.Hour)
+
+	// The campaign still references the asset, so only the campaign is due.
+	assets, err := ListPurgeableAssets(database, cutoff)
+	if err != nil || len(assets) != 0 {
+		t.Fatalf("purgeable assets before campaign purge = %v, %v", assets, err)
+	}
+	if ids, _ := ListPurgeableCampaigns(database, time.Now().Add(-time.Hour)); len(ids) != 0 {
+		t.Errorf("campaign purgeable inside the grace period: %v", ids)
+	}
+	ids, err := ListPurgeableCampaigns(database, cutoff)
+	if err != nil || len(ids) != 1 || ids[0] != "camp" {
+		t.Fatalf("purgeable campaigns = %v, %v", ids, err)
+	}
+
+	if err := PurgeCampaign(database, "camp"); err != nil {
+		t.Fatal(err)
+	}
+	if c, _ := GetCampaign(database, "camp"); c != nil {
+		t.Error("campaign survived purge")
+	}
+	var tokens int
+	database.QueryRow(`SELECT COUNT(*) FROM download_tokens WHERE campaign_id = 'camp'`).Scan(&tokens)
+	if tokens != 0 {
+		t.Errorf("%d tokens survived purge", tokens)
+	}
+
+	assets, err = ListPurgeableAssets(database, cutoff)
+	if err != nil || len(assets) != 1 || assets[0] != "camp-asset" {
+		t.Fatalf("purgeable assets after campaign purge = %v, %v", assets, err)
+	}
+}
+
+func TestRestoreCampaign(t *testing.T) {
+	database := openTestDB(t)
+	seedCampaign(t, database, "acc", "camp")
+	if err := SoftDeleteCampaign(database, "camp"); err != nil {
+		t.Fatal(err)
+	}
+	if list, _ := ListCampaigns(database, "acc", false, false); len(list) != 0 {
+		t.Errorf("deleted campaign listed: %v", list)
+	}
+	if deleted, _ := ListDeletedCampaigns(database, "acc", false); len(deleted) != 1 {
+		t.Errorf("deleted campaigns = %v, want camp", deleted)
+	}
+	if err := RestoreCampaign(database, "camp"); err != nil {
+		t.Fatal(err)
+	}
+	c, _ := GetCampaign(database, "camp")
+	if c == nil || c.DeletedAt != nil {
+		t.Fatalf("restored campaign = %+v", c)
+	}
+	if list, _ := ListCampaigns(database, "acc", false, false); len(list) != 1 {
+		t.Errorf("restored campaign not listed: %v", list)
+	}
+}
@@ -2,24 +2,75 @@ package db
 
 import (
 	"database/sql"
+	"time"
 
 	"github.com/YannKr/downloadonce/internal/model"
 )
 
+const insertDownloadEventSQL = `INSERT INTO download_events (id, token_id, campaign_id, recipient_id, asset_id, ip_address, user_agent, country, city, downloaded_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?, NULLIF(?, ''), NULLIF(?, ''), COALESCE(?, strftime('%Y-%m-%dT%H:%M:%fZ', 'now')))`
+
+// downloadEventArgs returns the insert arguments for e. A zero CreatedAt is
+// stamped by the database at insert time.
+func downloadEventArgs(e *model.DownloadEvent) []interface{} {
+	var downloadedAt *string
+	if !e.CreatedAt.IsZero() {
+		s := e.CreatedAt.UTC().Format("2006-01-02T15:04:05.000Z")
+		downloadedAt = &s
+	}
+	return []interface{}{e.ID, e.TokenID, e.CampaignID, e.RecipientID, e.AssetID, e.IPAddress, e.UserAgent, e.Country, e.City, downloadedAt}
+}
+
 func InsertDownloadEvent(database *sql.DB, e *model.DownloadEvent) error {
-	_, err := database.Exec(
-		`INSERT INTO download_events (id, token_id, campaign_id, recipient_id, asset_id, ip_address, user_agent)
-		 VALUES (?, ?, ?, ?, ?, ?, ?)`,
-		e.ID, e.TokenID, e.CampaignID, e.RecipientID, e.AssetID, e.IPAddress, e.UserAgent,
-	)
+	_, err := database.Exec(insertDownloadEventSQL, downloadEventArgs(e)...)
 	return err
 }
 
+// InsertDownloadEvents inserts events in a single transaction.
+func InsertDownloadEvents(database *sql.DB, events []*model.DownloadEvent) error {
+	tx, err := database.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(insertDownloadEventSQL)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, e := range events {
+		if _, err := stmt.Exec(downloadEventArgs(e)...); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+const downloadEventsByTokenQuery = `SELECT id, token_id, campaign_id, recipient_id, asset_id, ip_address, user_agent,
+		  COALESCE(country, ''), COALESCE(city, ''), downloaded_at
+		 FROM download_events WHERE token_id = ? ORDER BY downloaded_at DESC, id DESC`
+
 func ListDownloadEventsByToken(database *sql.DB, tokenID string) ([]model.DownloadEvent, error) {
-	rows, err := database.Query(
-		`SELECT id, token_id, campaign_id, recipient_id, asset_id, ip_address, user_agent, downloaded_at
-		 FROM download_events WHERE token_id = ? ORDER BY downloaded_at DESC`, tokenID,
-	)
+	return queryDownloadEvents(database, downloadEventsByTokenQuery, tokenID)
+}
+
+// ListDownloadEventsByTokenPaged returns one page of ListDownloadEventsByToken,
+// in the same order, without loading the token's other events.
+func ListDownloadEventsByTokenPaged(database *sql.DB, tokenID string, limit, offset int) ([]model.DownloadEvent, error) {
+	return queryDownloadEvents(database, downloadEventsByTokenQuery+` LIMIT ? OFFSET ?`, tokenID, limit, offset)
+}
+
+// CountDownloadEventsByToken returns how many download events a token has.
+func CountDownloadEventsByToken(database *sql.DB, tokenID string) (int, error) {
+	var n int
+	err := database.QueryRow(`SELECT COUNT(*) FROM download_events WHERE token_id = ?`, tokenID).Scan(&n)
+	return n, err
+}
+
+func queryDownloadEvents(database *sql.DB, query string, args ...any) ([]model.DownloadEvent, error) {
+	rows, err := database.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -30,7 +81,7 @@ func ListDownloadEventsByToken(database *sql.DB, tokenID string) ([]model.Downlo
 		var e model.DownloadEvent
 		var createdAt SQLiteTime
 		if err := rows.Scan(&e.ID, &e.TokenID, &e.CampaignID, &e.RecipientID,
-			&e.AssetID, &e.IPAddress, &e.UserAgent, &createdAt); err != nil {
+			&e.AssetID, &e.IPAddress, &e.UserAgent, &e.Country, &e.City, &createdAt); err != nil {
 			return nil, err
 		}
 		e.CreatedAt = createdAt.Time
@@ -42,7 +93,7 @@ func ListDownloadEventsByToken(database *sql.DB, tokenID string) ([]model.Downlo
 func ListRecentDownloadEvents(database *sql.DB, accountID string, limit int) ([]model.DownloadEvent, error) {
 	rows, err := database.Query(`
 		SELECT de.id, de.token_id, de.campaign_id, de.recipient_id, de.asset_id,
-		  de.ip_address, de.user_agent, de.downloaded_at
+		  de.ip_address, de.user_agent, COALESCE(de.country, ''), COALESCE(de.city, ''), de.downloaded_at
 		FROM download_events de
 		JOIN campaigns c ON c.id = de.campaign_id
 		WHERE c.account_id = ?
@@ -59,7 +110,7 @@ func ListRecentDownloadEvents(database *sql.DB, accountID string, limit int) ([]
 		var e model.DownloadEvent
 		var createdAt SQLiteTime
 		if err := rows.Scan(&e.ID, &e.TokenID, &e.CampaignID, &e.RecipientID,
-			&e.AssetID, &e.IPAddress, &e.UserAgent, &createdAt); err != nil {
+			&e.AssetID, &e.IPAddress, &e.UserAgent, &e.Country, &e.City, &createdAt); err != nil {
 			return nil, err
 		}
 		e.CreatedAt = createdAt.Time
@@ -67,3 +118,42 @@ func ListRecentDownloadEvents(database *sql.DB, accountID string, limit int) ([]
 	}
 	return events, rows.Err()
 }
+
+// pruneBatchSize bounds how many rows one retention DELETE removes, so a
+// large backlog is pruned without holding the write lock for long.
+const pruneBatchSize = 5000
+
+// pruneInBatches runs a DELETE whose final parameter is the batch size until
+// it removes fewer than pruneBatchSize rows, and returns the total removed.
+func pruneInBatches(database *sql.DB, query string, args ...any) (int64, error) {
+	var total int64
+	for {
+		res, err := database.Exec(query, append(args, pruneBatchSize)...)
+		if err != nil {
+			return total, err
+		}
+		n, err := res.RowsAffected()
+		total += n
+		if err != nil || n < pruneBatchSize {
+			return total, err
+		}
+	}
+}
+
+// PruneOldDownloadEvents deletes download events older than cutoff. The
+// latest event of each ACTIVE token is kept whatever its age, so a live
+// campaign's downloaded counts and last-download times stay correct; it
+// becomes prunable once the token expires, is revoked or is used up.
+func PruneOldDownloadEvents(database *sql.DB, cutoff time.Time) (int64, error) {
+	return pruneInBatches(database, `
+		DELETE FROM download_events WHERE id IN (
+		  SELECT de.id FROM download_events de
+		  WHERE de.downloaded_at < ?
+		    AND NOT EXISTS (
+		      SELECT 1 FROM download_tokens dt
+		      WHERE dt.id = de.token_id AND dt.state = 'ACTIVE'
+		        AND de.id = (SELECT latest.id FROM download_events latest WHERE latest.token_id = dt.id
+		                     ORDER BY latest.downloaded_at DESC, latest.id DESC LIMIT 1))
+		  LIMIT ?)`,
+		cutoff.UTC().Format("2006-01-02T15:04:05.000Z"))
+}
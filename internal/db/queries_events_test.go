@@ -0,0 +1,109 @@
+package db
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/YannKr/downloadonce/internal/model"
+)
+
+func TestPruneOldDownloadEvents(t *testing.T) {
+	database := openTestDB(t)
+	seedCampaign(t, database, "acc", "camp", "live", "done")
+	if _, err := database.Exec(`UPDATE download_tokens SET state = CASE id WHEN 'camp-live' THEN 'ACTIVE' ELSE 'CONSUMED' END`); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	var events []*model.DownloadEvent
+	add := func(id, rid string, age time.Duration) {
+		events = append(events, &model.DownloadEvent{
+			ID: id, TokenID: "camp-" + rid, CampaignID: "camp", RecipientID: rid, AssetID: "camp-asset", CreatedAt: now.Add(-age),
+		})
+	}
+	add("live-old-1", "live", 100*24*time.Hour)
+	add("live-old-2", "live", 90*24*time.Hour)
+	add("done-old", "done", 95*24*time.Hour)
+	add("done-new", "done", time.Hour)
+	if err := InsertDownloadEvents(database, events); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := PruneOldDownloadEvents(database, now.Add(-30*24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("pruned %d events, want 2", n)
+	}
+	var kept []string
+	rows, _ := database.Query(`SELECT id FROM download_events ORDER BY id`)
+	for rows.Next() {
+		var id string
+		rows.Scan(&id)
+		kept = append(kept, id)
+	}
+	rows.Close()
+	// The active token keeps its latest event so its campaign still counts
+	// it as downloaded.
+	if len(kept) != 2 || kept[0] != "done-new" || kept[1] != "live-old-2" {
+		t.Errorf("kept %v, want [done-new live-old-2]", kept)
+	}
+
+	if _, err := database.Exec(`UPDATE download_tokens SET state = 'EXPIRED' WHERE id = 'camp-live'`); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := PruneOldDownloadEvents(database, now.Add(-30*24*time.Hour)); n != 1 {
+		t.Errorf("pruned %d events after the token expired, want 1", n)
+	}
+}
+
+func TestPruneOldAuditLogs(t *testing.T) {
+	database := openTestDB(t)
+	for i, at := range []string{"2024-01-01T00:00:00.000Z", "2024-06-01T00:00:00.000Z", "2099-01-01T00:00:00.000Z"} {
+		if _, err := database.Exec(`INSERT INTO audit_logs (id, account_id, action, created_at) VALUES (?, 'acc', 'login', ?)`,
+			string(rune('a'+i)), at); err != nil {
+			t.Fatal(err)
+		}
+	}
+	n, err := PruneOldAuditLogs(database, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil || n != 2 {
+		t.Fatalf("pruned %d, %v; want 2", n, err)
+	}
+	if count, _ := CountAuditLogs(database, AuditLogFilter{}); count != 1 {
+		t.Errorf("%d entries left, want 1", count)
+	}
+}
+
+func TestListDownloadEventsByTokenPaged(t *testing.T) {
+	database := openTestDB(t)
+	seedCampaign(t, database, "acc", "camp", "alice", "bob")
+	now := time.Now()
+	var events []*model.DownloadEvent
+	for i, id := range []string{"e1", "e2", "e3", "e4", "e5"} {
+		events = append(events, &model.DownloadEvent{
+			ID: id, TokenID: "camp-alice", CampaignID: "camp", RecipientID: "alice", AssetID: "camp-asset", CreatedAt: now.Add(time.Duration(i) * time.Minute),
+		})
+	}
+	events = append(events, &model.DownloadEvent{ID: "other", TokenID: "camp-bob", CampaignID: "camp", RecipientID: "bob", AssetID: "camp-asset", CreatedAt: now})
+	if err := InsertDownloadEvents(database, events); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := CountDownloadEventsByToken(database, "camp-alice"); err != nil || n != 5 {
+		t.Fatalf("count = %d, %v; want 5", n, err)
+	}
+	var paged []string
+	for offset := 0; offset < 6; offset += 2 {
+		page, err := ListDownloadEventsByTokenPaged(database, "camp-alice", 2, offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range page {
+			paged = append(paged, e.ID)
+		}
+	}
+	if got := strings.Join(paged, ","); got != "e5,e4,e3,e2,e1" {
+		t.Errorf("paged events = %s, want newest first e5..e1", got)
+	}
+}
@@ -160,15 +160,35 @@ func ListGroupMemberIDs(database *sql.DB, groupID, accountID string) ([]string,
 	return ids, rows.Err()
 }
 
-func ListRecipientsWithGroups(database *sql.DB) ([]model.RecipientWithGroups, error) {
+// ListRecipientsWithGroups lists accountID's recipients (every account's for
+// an empty accountID) with their group badges, for the recipients page.
+func ListRecipientsWithGroups(database *sql.DB, accountID string) ([]model.RecipientWithGroups, error) {
+	return listRecipientsWithGroups(database, "(? = '' OR r.account_id = ?)", 0, accountID, accountID)
+}
+
+// SearchRecipientsWithGroups is SearchRecipients for the recipients page,
+// with each match's group badges. A limit of zero or less returns all
+// matches.
+func SearchRecipientsWithGroups(database *sql.DB, accountID, query string, limit int) ([]model.RecipientWithGroups, error) {
+	p := likePrefix(query)
+	return listRecipientsWithGroups(database, "(? = '' OR r.account_id = ?) AND "+recipientSearchWhere, limit,
+		accountID, accountID, p, p, p, p)
+}
+
+func listRecipientsWithGroups(database *sql.DB, where string, limit int, args ...any) ([]model.RecipientWithGroups, error) {
+	if limit <= 0 {
+		limit = -1
+	}
 	rows, err := database.Query(`
 		SELECT r.id, r.account_id, r.name, r.email, r.org, r.created_at,
 			COALESCE(GROUP_CONCAT(g.id || '|' || g.name, '||'), '') AS groups
 		FROM recipients r
 		LEFT JOIN recipient_group_members m ON m.recipient_id = r.id
 		LEFT JOIN recipient_groups g ON g.id = m.group_id
+		WHERE `+where+`
 		GROUP BY r.id
-		ORDER BY r.name ASC`)
+		ORDER BY r.name ASC
+		LIMIT ?`, append(args, limit)...)
 	if err != nil {
 		return nil, err
 	}
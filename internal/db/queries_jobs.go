@@ -2,28 +2,64 @@ package db
 
 import (
 	"database/sql"
+	"strings"
 	"time"
 
 	"github.com/YannKr/downloadonce/internal/model"
 )
 
+// Job priorities. ClaimNextJob takes the highest priority first, so a
+// recipient waiting on a download link is served before bulk publish work.
+const (
+	JobPriorityNormal   = 0
+	JobPriorityOnDemand = 10
+)
+
+// jobCampaignAccount fills a job's account_id from its campaign.
+const jobCampaignAccount = `(SELECT account_id FROM campaigns WHERE id = ?)`
+
 func EnqueueJob(database *sql.DB, j *model.Job) error {
 	_, err := database.Exec(
-		`INSERT INTO jobs (id, job_type, campaign_id, token_id, state) VALUES (?, ?, ?, ?, 'PENDING')`,
-		j.ID, j.JobType, j.CampaignID, j.TokenID,
+		`INSERT INTO jobs (id, job_type, campaign_id, account_id, token_id, state, priority, request_id)
+		 VALUES (?, ?, ?, `+jobCampaignAccount+`, ?, 'PENDING', ?, ?)`,
+		j.ID, j.JobType, j.CampaignID, j.CampaignID, j.TokenID, j.Priority, j.RequestID,
+	)
+	return err
+}
+
+// EnqueueDetectJob queues a detect job for the submitting account. Detect
+// jobs have no campaign or token.
+func EnqueueDetectJob(database *sql.DB, id, accountID, inputPath, jobType, requestID string) error {
+	_, err := database.Exec(
+		`INSERT INTO jobs (id, job_type, campaign_id, account_id, token_id, state, input_path, request_id)
+		 VALUES (?, ?, '', ?, '', 'PENDING', ?, ?)`,
+		id, jobType, accountID, inputPath, requestID,
 	)
 	return err
 }
 
-func EnqueueDetectJob(database *sql.DB, id, accountID, inputPath, jobType string) error {
+// EnqueueThumbnailJob queues a thumbnail job for an asset. Thumbnail jobs
+// have no campaign or token.
+func EnqueueThumbnailJob(database *sql.DB, id, accountID, assetID, requestID string) error {
 	_, err := database.Exec(
-		`INSERT INTO jobs (id, job_type, campaign_id, token_id, state, input_path)
-		 VALUES (?, ?, ?, ?, 'PENDING', ?)`,
-		id, jobType, accountID, "", inputPath,
+		`INSERT INTO jobs (id, job_type, campaign_id, account_id, asset_id, token_id, state, request_id)
+		 VALUES (?, 'thumbnail', '', ?, ?, '', 'PENDING', ?)`,
+		id, accountID, assetID, requestID,
 	)
 	return err
 }
 
+// HasPendingThumbnailJob reports whether a thumbnail job for the asset is
+// still waiting or running.
+func HasPendingThumbnailJob(database *sql.DB, assetID string) (bool, error) {
+	var found bool
+	err := database.QueryRow(
+		`SELECT EXISTS (SELECT 1 FROM jobs WHERE asset_id = ? AND job_type = 'thumbnail'
+		   AND state IN ('PENDING', 'RUNNING'))`, assetID,
+	).Scan(&found)
+	return found, err
+}
+
 func ClaimNextJob(database *sql.DB, jobTypes []string) (*model.Job, error) {
 	if len(jobTypes) == 0 {
 		return nil, nil
@@ -46,18 +82,64 @@ func ClaimNextJob(database *sql.DB, jobTypes []string) (*model.Job, error) {
 		args[i] = jt
 	}
 	query += `) AND (next_retry_at IS NULL OR next_retry_at <= strftime('%Y-%m-%dT%H:%M:%fZ', 'now'))
-			ORDER BY created_at ASC LIMIT 1
-		)
-		RETURNING id, job_type, campaign_id, token_id, state, progress,
+			ORDER BY priority DESC, created_at ASC LIMIT 1
+		)` + claimReturning
+
+	return scanClaimedJob(database.QueryRow(query, args...))
+}
+
+// ClaimNextJobFair is ClaimNextJob with per-account fairness: jobs of accounts
+// that already have maxPerAccount jobs RUNNING are skipped, and among jobs of
+// equal priority those of the account with the fewest running jobs go first,
+// so one large publish cannot starve other accounts. maxPerAccount <= 0
+// disables the cap but keeps the fair ordering.
+func ClaimNextJobFair(database *sql.DB, jobTypes []string, maxPerAccount int) (*model.Job, error) {
+	if len(jobTypes) == 0 {
+		return nil, nil
+	}
+
+	placeholders := make([]string, len(jobTypes))
+	args := make([]interface{}, 0, len(jobTypes)+2)
+	for i, jt := range jobTypes {
+		placeholders[i] = "?"
+		args = append(args, jt)
+	}
+	args = append(args, maxPerAccount, maxPerAccount)
+
+	query := `
+		UPDATE jobs
+		SET state = 'RUNNING', started_at = strftime('%Y-%m-%dT%H:%M:%fZ', 'now')
+		WHERE id = (
+			SELECT j.id FROM jobs j
+			LEFT JOIN (
+				SELECT account_id, COUNT(*) AS running
+				FROM jobs
+				WHERE state = 'RUNNING'
+				GROUP BY account_id
+			) busy ON busy.account_id = j.account_id
+			WHERE j.state = 'PENDING' AND j.job_type IN (` + strings.Join(placeholders, ",") + `)
+			  AND (j.next_retry_at IS NULL OR j.next_retry_at <= strftime('%Y-%m-%dT%H:%M:%fZ', 'now'))
+			  AND (? <= 0 OR COALESCE(busy.running, 0) < ?)
+			ORDER BY j.priority DESC, COALESCE(busy.running, 0) ASC, j.created_at ASC LIMIT 1
+		)` + claimReturning
+
+	return scanClaimedJob(database.QueryRow(query, args...))
+}
+
+const claimReturning = `
+		RETURNING id, job_type, campaign_id, COALESCE(account_id, ''), COALESCE(asset_id, ''), token_id, state, progress,
 		          COALESCE(input_path, ''), COALESCE(result_data, ''),
-		          retry_count, created_at, started_at`
+		          retry_count, priority, request_id, created_at, started_at`
 
+// scanClaimedJob scans the RETURNING row of a claim query; it returns nil
+// when no job was claimed.
+func scanClaimedJob(row *sql.Row) (*model.Job, error) {
 	j := &model.Job{}
 	var createdAt, startedAt SQLiteTime
-	err := database.QueryRow(query, args...).Scan(
-		&j.ID, &j.JobType, &j.CampaignID, &j.TokenID,
+	err := row.Scan(
+		&j.ID, &j.JobType, &j.CampaignID, &j.AccountID, &j.AssetID, &j.TokenID,
 		&j.State, &j.Progress, &j.InputPath, &j.ResultData,
-		&j.RetryCount, &createdAt, &startedAt,
+		&j.RetryCount, &j.Priority, &j.RequestID, &createdAt, &startedAt,
 	)
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -87,6 +169,24 @@ func FailJob(database *sql.DB, id, errorMsg string) error {
 	return err
 }
 
+// DeletePendingJobsByCampaign removes the campaign's queued jobs (including
+// ones waiting out a retry backoff) and returns how many were removed. RUNNING
+// jobs are left to the worker, which drops them once it sees the cancel.
+func DeletePendingJobsByCampaign(database *sql.DB, campaignID string) (int, error) {
+	res, err := database.Exec(`DELETE FROM jobs WHERE campaign_id = ? AND state = 'PENDING'`, campaignID)
+	if err != nil {
+		return 0, err
+	}
+	n, _ := res.RowsAffected()
+	return int(n), nil
+}
+
+// DeleteJob removes a single job.
+func DeleteJob(database *sql.DB, id string) error {
+	_, err := database.Exec(`DELETE FROM jobs WHERE id = ?`, id)
+	return err
+}
+
 // RetryOrFailJob checks if a job has retries remaining. If so, it resets the
 // job to PENDING with a backoff delay. Otherwise it marks it FAILED.
 // Returns true if the job was retried (re-queued), false if it was failed.
@@ -130,6 +230,22 @@ func ResetJobForManualRetry(database *sql.DB, id string) error {
 	return err
 }
 
+// ResetFailedJobsForManualRetry resets every FAILED job of a campaign like
+// ResetJobForManualRetry and returns how many were re-queued.
+func ResetFailedJobsForManualRetry(database *sql.DB, campaignID string) (int, error) {
+	res, err := database.Exec(
+		`UPDATE jobs SET state = 'PENDING', retry_count = 0, max_retries = 3,
+		 next_retry_at = NULL, progress = 0, error_message = NULL,
+		 started_at = NULL, completed_at = NULL
+		 WHERE campaign_id = ? AND state = 'FAILED'`, campaignID,
+	)
+	if err != nil {
+		return 0, err
+	}
+	n, _ := res.RowsAffected()
+	return int(n), nil
+}
+
 func UpdateJobProgress(database *sql.DB, id string, progress int) error {
 	_, err := database.Exec(`UPDATE jobs SET progress = ? WHERE id = ?`, progress, id)
 	return err
@@ -145,15 +261,15 @@ func GetJob(database *sql.DB, id string) (*model.Job, error) {
 	var createdAt SQLiteTime
 	var startedAt, completedAt sql.NullString
 	err := database.QueryRow(`
-		SELECT id, job_type, campaign_id, token_id, state, progress,
+		SELECT id, job_type, campaign_id, COALESCE(account_id, ''), COALESCE(asset_id, ''), token_id, state, progress,
 		       COALESCE(error_message, ''), COALESCE(input_path, ''), COALESCE(result_data, ''),
-		       retry_count, max_retries, created_at, started_at, completed_at
+		       retry_count, max_retries, request_id, created_at, started_at, completed_at
 		FROM jobs WHERE id = ?`, id,
 	).Scan(
-		&j.ID, &j.JobType, &j.CampaignID, &j.TokenID,
+		&j.ID, &j.JobType, &j.CampaignID, &j.AccountID, &j.AssetID, &j.TokenID,
 		&j.State, &j.Progress, &j.ErrorMessage,
 		&j.InputPath, &j.ResultData,
-		&j.RetryCount, &j.MaxRetries,
+		&j.RetryCount, &j.MaxRetries, &j.RequestID,
 		&createdAt, &startedAt, &completedAt,
 	)
 	if err == sql.ErrNoRows {
@@ -203,7 +319,7 @@ func CountJobsByCampaignDetailed(database *sql.DB, campaignID string) (total, co
 
 func ListJobsByCampaign(database *sql.DB, campaignID string) ([]model.Job, error) {
 	rows, err := database.Query(`
-		SELECT id, job_type, campaign_id, token_id, state, progress,
+		SELECT id, job_type, campaign_id, COALESCE(account_id, ''), token_id, state, progress,
 		       COALESCE(error_message, ''), retry_count, max_retries, created_at
 		FROM jobs WHERE campaign_id = ?
 		ORDER BY created_at ASC`, campaignID)
@@ -216,7 +332,7 @@ func ListJobsByCampaign(database *sql.DB, campaignID string) ([]model.Job, error
 	for rows.Next() {
 		var j model.Job
 		var createdAt SQLiteTime
-		if err := rows.Scan(&j.ID, &j.JobType, &j.CampaignID, &j.TokenID,
+		if err := rows.Scan(&j.ID, &j.JobType, &j.CampaignID, &j.AccountID, &j.TokenID,
 			&j.State, &j.Progress, &j.ErrorMessage,
 			&j.RetryCount, &j.MaxRetries, &createdAt); err != nil {
 			return nil, err
@@ -227,23 +343,94 @@ func ListJobsByCampaign(database *sql.DB, campaignID string) ([]model.Job, error
 	return jobs, rows.Err()
 }
 
-// EnqueueJobIfNotExists creates a watermark job for the given token only if
-// no PENDING or RUNNING job already exists for that token. Returns true if
-// a job already existed (no new row inserted).
-func EnqueueJobIfNotExists(database *sql.DB, j *model.Job) (alreadyExists bool, err error) {
+// EnqueueJobIfNotExists creates an on-demand watermark job for the given
+// token only if no PENDING or RUNNING job already exists for that token, and
+// no job at all was created for it within the last grace period (so rapid
+// repeated hits do not re-enqueue a job that just finished or failed). The
+// job gets JobPriorityOnDemand; a PENDING job already queued for the token is
+// raised to that priority. Returns true if no new row was inserted.
+func EnqueueJobIfNotExists(database *sql.DB, j *model.Job, grace time.Duration) (alreadyExists bool, err error) {
+	cutoff := time.Now().UTC().Add(-grace).Format("2006-01-02T15:04:05.000Z")
 	res, err := database.Exec(
-		`INSERT INTO jobs (id, job_type, campaign_id, token_id, state)
-		 SELECT ?, ?, ?, ?, 'PENDING'
+		`INSERT INTO jobs (id, job_type, campaign_id, account_id, token_id, state, priority, request_id)
+		 SELECT ?, ?, ?, `+jobCampaignAccount+`, ?, 'PENDING', ?, ?
 		 WHERE NOT EXISTS (
-		   SELECT 1 FROM jobs WHERE token_id = ? AND state IN ('PENDING', 'RUNNING')
+		   SELECT 1 FROM jobs WHERE token_id = ?
+		     AND (state IN ('PENDING', 'RUNNING') OR created_at > ?)
 		 )`,
-		j.ID, j.JobType, j.CampaignID, j.TokenID, j.TokenID,
+		j.ID, j.JobType, j.CampaignID, j.CampaignID, j.TokenID, JobPriorityOnDemand, j.RequestID, j.TokenID, cutoff,
 	)
 	if err != nil {
 		return false, err
 	}
-	n, _ := res.RowsAffected()
-	return n == 0, nil
+	if n, _ := res.RowsAffected(); n > 0 {
+		return false, nil
+	}
+	_, err = database.Exec(
+		`UPDATE jobs SET priority = ? WHERE token_id = ? AND state = 'PENDING' AND priority < ?`,
+		JobPriorityOnDemand, j.TokenID, JobPriorityOnDemand,
+	)
+	return true, err
+}
+
+// ListJobsByTokens returns the jobs of the given tokens, oldest first, so the
+// last job seen for a token is its latest.
+func ListJobsByTokens(database *sql.DB, tokenIDs []string) ([]model.Job, error) {
+	if len(tokenIDs) == 0 {
+		return nil, nil
+	}
+	args := make([]any, len(tokenIDs))
+	for i, id := range tokenIDs {
+		args[i] = id
+	}
+	rows, err := database.Query(`
+		SELECT id, job_type, campaign_id, COALESCE(account_id, ''), token_id, state, progress,
+		       COALESCE(error_message, ''), retry_count, max_retries, created_at
+		FROM jobs WHERE token_id IN (?`+strings.Repeat(", ?", len(tokenIDs)-1)+`)
+		ORDER BY created_at ASC`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []model.Job
+	for rows.Next() {
+		var j model.Job
+		var createdAt SQLiteTime
+		if err := rows.Scan(&j.ID, &j.JobType, &j.CampaignID, &j.AccountID, &j.TokenID,
+			&j.State, &j.Progress, &j.ErrorMessage,
+			&j.RetryCount, &j.MaxRetries, &createdAt); err != nil {
+			return nil, err
+		}
+		j.CreatedAt = createdAt.Time
+		jobs = append(jobs, j)
+	}
+	return jobs, rows.Err()
+}
+
+// CountFailedJobsByError counts the campaign's tokens whose latest job
+// failed, keyed by error message.
+func CountFailedJobsByError(database *sql.DB, campaignID string) (map[string]int, error) {
+	rows, err := database.Query(`
+		SELECT COALESCE(j.error_message, ''), COUNT(*) FROM jobs j
+		WHERE j.campaign_id = ? AND j.state = 'FAILED'
+		  AND NOT EXISTS (SELECT 1 FROM jobs k WHERE k.token_id = j.token_id AND k.created_at > j.created_at)
+		GROUP BY 1`, campaignID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var msg string
+		var n int
+		if err := rows.Scan(&msg, &n); err != nil {
+			return nil, err
+		}
+		counts[msg] = n
+	}
+	return counts, rows.Err()
 }
 
 // GetJobByToken returns the latest job for a given token ID.
@@ -251,11 +438,11 @@ func GetJobByToken(database *sql.DB, tokenID string) (*model.Job, error) {
 	j := &model.Job{}
 	var createdAt SQLiteTime
 	err := database.QueryRow(`
-		SELECT id, job_type, campaign_id, token_id, state, progress,
+		SELECT id, job_type, campaign_id, COALESCE(account_id, ''), token_id, state, progress,
 		       COALESCE(error_message, ''), retry_count, max_retries, created_at
 		FROM jobs WHERE token_id = ?
 		ORDER BY created_at DESC LIMIT 1`, tokenID,
-	).Scan(&j.ID, &j.JobType, &j.CampaignID, &j.TokenID,
+	).Scan(&j.ID, &j.JobType, &j.CampaignID, &j.AccountID, &j.TokenID,
 		&j.State, &j.Progress, &j.ErrorMessage,
 		&j.RetryCount, &j.MaxRetries, &createdAt)
 	if err == sql.ErrNoRows {
@@ -284,10 +471,14 @@ func ResetStuckJobs(database *sql.DB, stuckThreshold time.Duration) (int, error)
 	return int(n), nil
 }
 
-func InsertWatermarkIndex(database *sql.DB, payloadHex, tokenID, campaignID, recipientID, wmAlgorithm string) error {
+// InsertWatermarkIndex records the payload embedded for a token. SelfVerified
+// is nil when the post-embed self-verify did not run; Channels and Scale are
+// empty when the invisible params are not known. CreatedAt is ignored.
+func InsertWatermarkIndex(database *sql.DB, e WatermarkIndexEntry) error {
 	_, err := database.Exec(
-		`INSERT OR IGNORE INTO watermark_index (payload_hex, token_id, campaign_id, recipient_id, wm_algorithm) VALUES (?, ?, ?, ?, ?)`,
-		payloadHex, tokenID, campaignID, recipientID, wmAlgorithm,
+		`INSERT OR IGNORE INTO watermark_index (payload_hex, token_id, campaign_id, recipient_id, wm_algorithm, self_verified, wm_channels, wm_scale)
+		 VALUES (?, ?, ?, ?, ?, ?, NULLIF(?, ''), ?)`,
+		e.PayloadHex, e.TokenID, e.CampaignID, e.RecipientID, e.Algorithm, e.SelfVerified, e.Channels, e.Scale,
 	)
 	return err
 }
@@ -0,0 +1,202 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/YannKr/downloadonce/internal/model"
+)
+
+func TestClaimNextJobPrefersPriority(t *testing.T) {
+	database := openTestDB(t)
+	seedCampaign(t, database, "acc", "camp", "r1", "r2")
+
+	if err := EnqueueJob(database, &model.Job{
+		ID: "bulk", JobType: "watermark_image", CampaignID: "camp", TokenID: "camp-r1",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	// Make the bulk job unambiguously older.
+	if _, err := database.Exec(`UPDATE jobs SET created_at = '2020-01-01T00:00:00.000Z' WHERE id = 'bulk'`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := EnqueueJobIfNotExists(database, &model.Job{
+		ID: "ondemand", JobType: "watermark_image", CampaignID: "camp", TokenID: "camp-r2",
+	}, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"ondemand", "bulk"} {
+		j, err := ClaimNextJob(database, []string{"watermark_image"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if j == nil || j.ID != want {
+			t.Fatalf("claimed %+v, want %s", j, want)
+		}
+	}
+}
+
+func TestEnqueueJobIfNotExistsRaisesPendingPriority(t *testing.T) {
+	database := openTestDB(t)
+	seedCampaign(t, database, "acc", "camp", "r1")
+
+	if err := EnqueueJob(database, &model.Job{
+		ID: "bulk", JobType: "watermark_image", CampaignID: "camp", TokenID: "camp-r1",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	exists, err := EnqueueJobIfNotExists(database, &model.Job{
+		ID: "dup", JobType: "watermark_image", CampaignID: "camp", TokenID: "camp-r1",
+	}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exists {
+		t.Fatal("expected the existing PENDING job to be reused")
+	}
+	j, err := ClaimNextJob(database, []string{"watermark_image"})
+	if err != nil || j == nil {
+		t.Fatalf("claim: %v", err)
+	}
+	if j.ID != "bulk" || j.Priority != JobPriorityOnDemand {
+		t.Errorf("claimed %s with priority %d, want bulk at %d", j.ID, j.Priority, JobPriorityOnDemand)
+	}
+}
+
+func TestClaimNextJobFairInterleavesAccounts(t *testing.T) {
+	database := openTestDB(t)
+	seedCampaign(t, database, "big", "bigcamp", "b1", "b2", "b3")
+	seedCampaign(t, database, "small", "smallcamp", "s1")
+
+	// The big account's publish is queued first.
+	enqueue := func(id, campaignID, tokenID, createdAt string) {
+		t.Helper()
+		if err := EnqueueJob(database, &model.Job{
+			ID: id, JobType: "watermark_image", CampaignID: campaignID, TokenID: tokenID,
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := database.Exec(`UPDATE jobs SET created_at = ? WHERE id = ?`, createdAt, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	enqueue("big1", "bigcamp", "bigcamp-b1", "2020-01-01T00:00:01.000Z")
+	enqueue("big2", "bigcamp", "bigcamp-b2", "2020-01-01T00:00:02.000Z")
+	enqueue("big3", "bigcamp", "bigcamp-b3", "2020-01-01T00:00:03.000Z")
+	enqueue("small1", "smallcamp", "smallcamp-s1", "2020-01-01T00:00:04.000Z")
+
+	claim := func() string {
+		t.Helper()
+		j, err := ClaimNextJobFair(database, []string{"watermark_image"}, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if j == nil {
+			return ""
+		}
+		return j.ID
+	}
+
+	if got := claim(); got != "big1" {
+		t.Fatalf("first claim = %q, want big1", got)
+	}
+	if got := claim(); got != "small1" {
+		t.Fatalf("second claim = %q, want small1 (big account at cap)", got)
+	}
+	if got := claim(); got != "" {
+		t.Fatalf("third claim = %q, want none while both accounts are at cap", got)
+	}
+	if err := CompleteJob(database, "big1"); err != nil {
+		t.Fatal(err)
+	}
+	if got := claim(); got != "big2" {
+		t.Fatalf("claim after completion = %q, want big2", got)
+	}
+}
+
+func TestClaimNextJobFairUncappedPrefersIdleAccount(t *testing.T) {
+	database := openTestDB(t)
+	seedCampaign(t, database, "big", "bigcamp", "b1", "b2")
+	seedCampaign(t, database, "small", "smallcamp", "s1")
+
+	for _, j := range []struct{ id, camp, tok, at string }{
+		{"big1", "bigcamp", "bigcamp-b1", "2020-01-01T00:00:01.000Z"},
+		{"big2", "bigcamp", "bigcamp-b2", "2020-01-01T00:00:02.000Z"},
+		{"small1", "smallcamp", "smallcamp-s1", "2020-01-01T00:00:03.000Z"},
+	} {
+		if err := EnqueueJob(database, &model.Job{ID: j.id, JobType: "watermark_image", CampaignID: j.camp, TokenID: j.tok}); err != nil {
+			t.Fatal(err)
+		}
+		database.Exec(`UPDATE jobs SET created_at = ? WHERE id = ?`, j.at, j.id)
+	}
+
+	var got []string
+	for i := 0; i < 3; i++ {
+		j, err := ClaimNextJobFair(database, []string{"watermark_image"}, 0)
+		if err != nil || j == nil {
+			t.Fatalf("claim %d: %v", i, err)
+		}
+		got = append(got, j.ID)
+	}
+	if got[0] != "big1" || got[1] != "small1" || got[2] != "big2" {
+		t.Errorf("claim order = %v, want [big1 small1 big2]", got)
+	}
+}
+
+func TestJobAccountID(t *testing.T) {
+	database := openTestDB(t)
+	seedCampaign(t, database, "acc", "camp", "r1")
+
+	if err := EnqueueJob(database, &model.Job{
+		ID: "wm", JobType: "watermark_image", CampaignID: "camp", TokenID: "camp-r1",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := EnqueueDetectJob(database, "det", "other", "/leak.png", "detect", "req-1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if j, _ := ClaimNextJob(database, []string{"detect"}); j == nil || j.RequestID != "req-1" {
+		t.Errorf("claimed detect job = %+v, want RequestID req-1", j)
+	}
+	for id, want := range map[string]string{"wm": "acc", "det": "other"} {
+		j, err := GetJob(database, id)
+		if err != nil || j == nil {
+			t.Fatalf("get %s: %v", id, err)
+		}
+		if j.AccountID != want {
+			t.Errorf("%s: AccountID = %q, want %q", id, j.AccountID, want)
+		}
+		if id == "det" && j.CampaignID != "" {
+			t.Errorf("detect job CampaignID = %q, want empty", j.CampaignID)
+		}
+	}
+}
+
+func TestCountFailedJobsByError(t *testing.T) {
+	database := openTestDB(t)
+	seedCampaign(t, database, "acc", "camp", "r1", "r2", "r3")
+
+	for _, j := range []struct{ id, token, state, msg, at string }{
+		{"a", "camp-r1", "FAILED", "disk full", "2020-01-01T00:00:00.000Z"},
+		{"b", "camp-r2", "FAILED", "disk full", "2020-01-01T00:00:00.000Z"},
+		{"c", "camp-r3", "FAILED", "bad input", "2020-01-01T00:00:00.000Z"},
+		// r2 was retried since and succeeded, so its old failure no longer counts.
+		{"d", "camp-r2", "COMPLETED", "", "2020-01-02T00:00:00.000Z"},
+	} {
+		if err := EnqueueJob(database, &model.Job{ID: j.id, JobType: "watermark_image", CampaignID: "camp", TokenID: j.token}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := database.Exec(`UPDATE jobs SET state = ?, error_message = ?, created_at = ? WHERE id = ?`, j.state, j.msg, j.at, j.id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	counts, err := CountFailedJobsByError(database, "camp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(counts) != 2 || counts["disk full"] != 1 || counts["bad input"] != 1 {
+		t.Errorf("counts = %v, want one each of disk full and bad input", counts)
+	}
+}
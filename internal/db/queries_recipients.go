@@ -2,6 +2,7 @@ package db
 
 import (
 	"database/sql"
+	"strings"
 
 	"github.com/YannKr/downloadonce/internal/model"
 )
@@ -14,10 +15,96 @@ func CreateRecipient(database *sql.DB, r *model.Recipient) error {
 	return err
 }
 
-func ListRecipients(database *sql.DB) ([]model.Recipient, error) {
+// ListRecipients returns accountID's recipients by name; an empty accountID
+// lists every account's.
+func ListRecipients(database *sql.DB, accountID string) ([]model.Recipient, error) {
 	rows, err := database.Query(
 		`SELECT id, account_id, name, email, org, created_at
-		 FROM recipients ORDER BY name ASC`,
+		 FROM recipients WHERE ? = '' OR account_id = ? ORDER BY name ASC`,
+		accountID, accountID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var recipients []model.Recipient
+	for rows.Next() {
+		var r model.Recipient
+		var createdAt SQLiteTime
+		if err := rows.Scan(&r.ID, &r.AccountID, &r.Name, &r.Email, &r.Org, &createdAt); err != nil {
+			return nil, err
+		}
+		r.CreatedAt = createdAt.Time
+		recipients = append(recipients, r)
+	}
+	return recipients, rows.Err()
+}
+
+// CountRecipients returns the number of accountID's recipients, or of all
+// recipients for an empty accountID.
+func CountRecipients(database *sql.DB, accountID string) (int, error) {
+	var n int
+	err := database.QueryRow(`SELECT COUNT(*) FROM recipients WHERE ? = '' OR account_id = ?`, accountID, accountID).Scan(&n)
+	return n, err
+}
+
+// recipientSearchWhere matches recipients whose name, any word of the name,
+// email or org starts with the pattern built by likePrefix. SQLite's LIKE is
+// case-insensitive for ASCII. Expects the pattern four times.
+const recipientSearchWhere = `(r.name LIKE ? ESCAPE '\' OR r.name LIKE '% ' || ? ESCAPE '\'
+		   OR r.email LIKE ? ESCAPE '\' OR r.org LIKE ? ESCAPE '\')`
+
+// likeEscaper escapes LIKE wildcards so they match literally (with ESCAPE '\').
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// likePrefix turns user input into a LIKE prefix pattern, escaping the
+// wildcards so they match literally.
+func likePrefix(q string) string {
+	return likeEscaper.Replace(strings.TrimSpace(q)) + "%"
+}
+
+// SearchRecipients returns up to limit recipients whose name, email or org
+// starts with query, by name. An empty accountID searches every account; a
+// limit of zero or less returns all matches.
+func SearchRecipients(database *sql.DB, accountID, query string, limit int) ([]model.Recipient, error) {
+	if limit <= 0 {
+		limit = -1
+	}
+	p := likePrefix(query)
+	rows, err := database.Query(
+		`SELECT r.id, r.account_id, r.name, r.email, r.org, r.created_at
+		 FROM recipients r
+		 WHERE (? = '' OR r.account_id = ?) AND `+recipientSearchWhere+`
+		 ORDER BY r.name ASC, r.id ASC LIMIT ?`,
+		accountID, accountID, p, p, p, p, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var recipients []model.Recipient
+	for rows.Next() {
+		var r model.Recipient
+		var createdAt SQLiteTime
+		if err := rows.Scan(&r.ID, &r.AccountID, &r.Name, &r.Email, &r.Org, &createdAt); err != nil {
+			return nil, err
+		}
+		r.CreatedAt = createdAt.Time
+		recipients = append(recipients, r)
+	}
+	return recipients, rows.Err()
+}
+
+// ListRecipientsNotInCampaign returns the recipients that have no token in
+// the campaign, by name.
+func ListRecipientsNotInCampaign(database *sql.DB, campaignID string) ([]model.Recipient, error) {
+	rows, err := database.Query(
+		`SELECT id, account_id, name, email, org, created_at
+		 FROM recipients
+		 WHERE id NOT IN (SELECT recipient_id FROM download_tokens WHERE campaign_id = ?)
+		 ORDER BY name ASC`, campaignID,
 	)
 	if err != nil {
 		return nil, err
@@ -50,11 +137,15 @@ func GetRecipient(database *sql.DB, id string) (*model.Recipient, error) {
 	return r, err
 }
 
+// GetOrCreateRecipientByEmail looks a recipient up by email, ignoring case so
+// that addresses saved before emails were normalized still match. Callers
+// validate and normalize email first.
 func GetOrCreateRecipientByEmail(database *sql.DB, accountID, name, email, org string) (*model.Recipient, error) {
 	r := &model.Recipient{}
 	var createdAt SQLiteTime
 	err := database.QueryRow(
-		`SELECT id, account_id, name, email, org, created_at FROM recipients WHERE email = ?`,
+		`SELECT id, account_id, name, email, org, created_at FROM recipients WHERE email = ? COLLATE NOCASE
+		 ORDER BY created_at ASC LIMIT 1`,
 		email,
 	).Scan(&r.ID, &r.AccountID, &r.Name, &r.Email, &r.Org, &createdAt)
 	if err == nil {
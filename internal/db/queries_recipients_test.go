@@ -0,0 +1,68 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/YannKr/downloadonce/internal/model"
+)
+
+func TestSearchRecipients(t *testing.T) {
+	database := openTestDB(t)
+	for _, acc := range []string{"acc1", "acc2"} {
+		if err := CreateAccount(database, &model.Account{
+			ID: acc, Email: acc + "@example.com", Name: acc, PasswordHash: "x", Role: "member", Enabled: true,
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, r := range []model.Recipient{
+		{ID: "alice", AccountID: "acc1", Name: "Alice Smith", Email: "alice@example.com", Org: "Acme"},
+		{ID: "bob", AccountID: "acc2", Name: "Bob Jones", Email: "bjones@globex.com", Org: "Globex"},
+		{ID: "carol", AccountID: "acc1", Name: "Carol_X", Email: "carol@example.com"},
+	} {
+		if err := CreateRecipient(database, &r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		account, query string
+		want           []string
+	}{
+		{"", "al", []string{"alice"}},
+		{"", "SMI", []string{"alice"}},   // later word of the name, any case
+		{"", "acme", []string{"alice"}},  // org
+		{"", "bjones@", []string{"bob"}}, // email
+		{"", "c", []string{"carol"}},
+		{"", "carol_", []string{"carol"}},
+		{"", "_", nil}, // wildcards match literally
+		{"", "%", nil},
+		{"acc1", "glo", nil}, // other account's recipient
+		{"acc2", "glo", []string{"bob"}},
+		{"", "zzz", nil},
+	}
+	for _, tc := range tests {
+		got, err := SearchRecipients(database, tc.account, tc.query, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []string
+		for _, r := range got {
+			ids = append(ids, r.ID)
+		}
+		if len(ids) != len(tc.want) || (len(ids) > 0 && ids[0] != tc.want[0]) {
+			t.Errorf("SearchRecipients(%q, %q) = %v, want %v", tc.account, tc.query, ids, tc.want)
+		}
+	}
+
+	// An empty account and query list everyone, limited.
+	if got, _ := SearchRecipients(database, "", "", 2); len(got) != 2 || got[0].ID != "alice" || got[1].ID != "bob" {
+		t.Errorf("limited search = %v", got)
+	}
+	if got, _ := SearchRecipientsWithGroups(database, "", "jones", 0); len(got) != 1 || got[0].ID != "bob" {
+		t.Errorf("SearchRecipientsWithGroups = %v", got)
+	}
+	if got, _ := SearchRecipientsWithGroups(database, "acc1", "jones", 0); len(got) != 0 {
+		t.Errorf("SearchRecipientsWithGroups in another account = %v", got)
+	}
+}
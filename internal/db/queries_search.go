@@ -0,0 +1,176 @@
+package db
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// SearchCampaignRef is a campaign a searched recipient or asset appears in.
+// TokenID and TokenState are the recipient's link in it; they are empty for
+// assets.
+type SearchCampaignRef struct {
+	ID         string
+	Name       string
+	State      string
+	TokenID    string
+	TokenState string
+}
+
+type SearchCampaign struct {
+	ID         string
+	Name       string
+	State      string
+	OwnerEmail string
+	AssetName  string
+	CreatedAt  time.Time
+	Deleted    bool
+}
+
+type SearchRecipient struct {
+	ID         string
+	Name       string
+	Email      string
+	Org        string
+	OwnerEmail string
+	Campaigns  []SearchCampaignRef
+}
+
+type SearchAsset struct {
+	ID           string
+	OriginalName string
+	AssetType    string
+	OwnerEmail   string
+	CreatedAt    time.Time
+	Deleted      bool
+	Campaigns    []SearchCampaignRef
+}
+
+// SearchResults groups the matches of AdminSearch.
+type SearchResults struct {
+	Campaigns  []SearchCampaign
+	Recipients []SearchRecipient
+	Assets     []SearchAsset
+}
+
+// likeContains turns user input into a LIKE pattern matching it anywhere,
+// escaping the wildcards so they match literally.
+func likeContains(q string) string {
+	return "%" + likeEscaper.Replace(strings.TrimSpace(q)) + "%"
+}
+
+// AdminSearch finds campaigns by name, recipients by name, email or org, and
+// assets by original name across every account, matching query anywhere in
+// the text (case-insensitive for ASCII). Each group holds at most limit rows;
+// recipients and assets come with the campaigns they appear in.
+func AdminSearch(database *sql.DB, query string, limit int) (*SearchResults, error) {
+	p := likeContains(query)
+	res := &SearchResults{}
+
+	rows, err := database.Query(
+		`SELECT c.id, c.name, c.state, COALESCE(acc.email, ''), COALESCE(a.title, ''), c.created_at, c.deleted_at IS NOT NULL
+		 FROM campaigns c
+		 LEFT JOIN accounts acc ON acc.id = c.account_id
+		 LEFT JOIN assets a ON a.id = c.asset_id
+		 WHERE c.name LIKE ? ESCAPE '\'
+		 ORDER BY c.created_at DESC LIMIT ?`, p, limit)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var c SearchCampaign
+		var createdAt SQLiteTime
+		if err := rows.Scan(&c.ID, &c.Name, &c.State, &c.OwnerEmail, &c.AssetName, &createdAt, &c.Deleted); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		c.CreatedAt = createdAt.Time
+		res.Campaigns = append(res.Campaigns, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = database.Query(
+		`SELECT r.id, r.name, r.email, r.org, COALESCE(acc.email, '')
+		 FROM recipients r
+		 LEFT JOIN accounts acc ON acc.id = r.account_id
+		 WHERE r.name LIKE ? ESCAPE '\' OR r.email LIKE ? ESCAPE '\' OR r.org LIKE ? ESCAPE '\'
+		 ORDER BY r.email ASC, r.id ASC LIMIT ?`, p, p, p, limit)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var r SearchRecipient
+		if err := rows.Scan(&r.ID, &r.Name, &r.Email, &r.Org, &r.OwnerEmail); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		res.Recipients = append(res.Recipients, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for i := range res.Recipients {
+		res.Recipients[i].Campaigns, err = searchCampaignRefs(database,
+			`SELECT c.id, c.name, c.state, dt.id, dt.state
+			 FROM download_tokens dt JOIN campaigns c ON c.id = dt.campaign_id
+			 WHERE dt.recipient_id = ?
+			 ORDER BY dt.created_at DESC LIMIT ?`, res.Recipients[i].ID, limit)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	rows, err = database.Query(
+		`SELECT a.id, a.title, a.asset_type, COALESCE(acc.email, ''), a.created_at, a.deleted_at IS NOT NULL
+		 FROM assets a
+		 LEFT JOIN accounts acc ON acc.id = a.account_id
+		 WHERE a.title LIKE ? ESCAPE '\'
+		 ORDER BY a.created_at DESC LIMIT ?`, p, limit)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var a SearchAsset
+		var createdAt SQLiteTime
+		if err := rows.Scan(&a.ID, &a.OriginalName, &a.AssetType, &a.OwnerEmail, &createdAt, &a.Deleted); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		a.CreatedAt = createdAt.Time
+		res.Assets = append(res.Assets, a)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for i := range res.Assets {
+		res.Assets[i].Campaigns, err = searchCampaignRefs(database,
+			`SELECT id, name, state, '', '' FROM campaigns WHERE asset_id = ?
+			 ORDER BY created_at DESC LIMIT ?`, res.Assets[i].ID, limit)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+func searchCampaignRefs(database *sql.DB, query string, args ...any) ([]SearchCampaignRef, error) {
+	rows, err := database.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var refs []SearchCampaignRef
+	for rows.Next() {
+		var c SearchCampaignRef
+		if err := rows.Scan(&c.ID, &c.Name, &c.State, &c.TokenID, &c.TokenState); err != nil {
+			return nil, err
+		}
+		refs = append(refs, c)
+	}
+	return refs, rows.Err()
+}
@@ -0,0 +1,63 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/YannKr/downloadonce/internal/model"
+)
+
+func TestAdminSearch(t *testing.T) {
+	database := openTestDB(t)
+	seedCampaign(t, database, "acc1", "spring_launch", "alice", "bob")
+	seedCampaign(t, database, "acc2", "springfield", "bob")
+	seedCampaign(t, database, "acc2", "autumn")
+	if err := CreateAsset(database, &model.Asset{
+		ID: "trailer", AccountID: "acc2", OriginalName: "spring trailer.mp4", AssetType: "video",
+		OriginalPath: "originals/trailer.mp4", FileSize: 1, SHA256: "00", MimeType: "video/mp4",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := AdminSearch(database, "SPRING", 25)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Campaigns) != 2 {
+		t.Errorf("campaigns = %+v, want spring_launch and springfield", res.Campaigns)
+	}
+	if len(res.Assets) != 1 || res.Assets[0].ID != "trailer" || res.Assets[0].OwnerEmail != "acc2@example.com" || len(res.Assets[0].Campaigns) != 0 {
+		t.Errorf("assets = %+v, want the unused trailer", res.Assets)
+	}
+
+	// Wildcards match literally.
+	res, err = AdminSearch(database, "ng_", 25)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Campaigns) != 1 || res.Campaigns[0].ID != "spring_launch" {
+		t.Errorf("ng_ campaigns = %+v, want only spring_launch", res.Campaigns)
+	}
+
+	// Recipients come with the campaigns they are in, across accounts.
+	res, err = AdminSearch(database, "bob@", 25)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Recipients) != 1 || len(res.Recipients[0].Campaigns) != 2 {
+		t.Fatalf("recipients = %+v, want bob in two campaigns", res.Recipients)
+	}
+	for _, c := range res.Recipients[0].Campaigns {
+		if c.TokenID != c.ID+"-bob" || c.TokenState != "PENDING" {
+			t.Errorf("campaign ref = %+v", c)
+		}
+	}
+
+	// Each group is capped.
+	res, err = AdminSearch(database, "in.png", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Assets) != 1 {
+		t.Errorf("capped assets = %d, want 1", len(res.Assets))
+	}
+}
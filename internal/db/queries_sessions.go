@@ -9,8 +9,8 @@ import (
 
 func CreateSession(database *sql.DB, s *model.Session) error {
 	_, err := database.Exec(
-		`INSERT INTO sessions (id, account_id, expires_at) VALUES (?, ?, ?)`,
-		s.ID, s.AccountID, s.ExpiresAt.UTC().Format(time.RFC3339),
+		`INSERT INTO sessions (id, account_id, user_agent, ip, expires_at) VALUES (?, ?, ?, ?, ?)`,
+		s.ID, s.AccountID, s.UserAgent, s.IP, s.ExpiresAt.UTC().Format(time.RFC3339),
 	)
 	return err
 }
@@ -19,8 +19,8 @@ func GetSession(database *sql.DB, id string) (*model.Session, error) {
 	s := &model.Session{}
 	var createdAt, expiresAt SQLiteTime
 	err := database.QueryRow(
-		`SELECT id, account_id, created_at, expires_at FROM sessions WHERE id = ?`, id,
-	).Scan(&s.ID, &s.AccountID, &createdAt, &expiresAt)
+		`SELECT id, account_id, user_agent, ip, created_at, expires_at FROM sessions WHERE id = ?`, id,
+	).Scan(&s.ID, &s.AccountID, &s.UserAgent, &s.IP, &createdAt, &expiresAt)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -34,6 +34,58 @@ func DeleteSession(database *sql.DB, id string) error {
 	return err
 }
 
+// ListSessionsByAccount returns the account's unexpired sessions, newest first.
+func ListSessionsByAccount(database *sql.DB, accountID string) ([]model.Session, error) {
+	rows, err := database.Query(
+		`SELECT id, account_id, user_agent, ip, created_at, expires_at FROM sessions
+		 WHERE account_id = ? AND expires_at >= ? ORDER BY created_at DESC`,
+		accountID, time.Now().UTC().Format(time.RFC3339),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []model.Session
+	for rows.Next() {
+		var s model.Session
+		var createdAt, expiresAt SQLiteTime
+		if err := rows.Scan(&s.ID, &s.AccountID, &s.UserAgent, &s.IP, &createdAt, &expiresAt); err != nil {
+			return nil, err
+		}
+		s.CreatedAt = createdAt.Time
+		s.ExpiresAt = expiresAt.Time
+		sessions = append(sessions, s)
+	}
+	return sessions, rows.Err()
+}
+
+// DeleteAccountSession deletes one session, only if it belongs to accountID.
+func DeleteAccountSession(database *sql.DB, id, accountID string) (bool, error) {
+	res, err := database.Exec(`DELETE FROM sessions WHERE id = ? AND account_id = ?`, id, accountID)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// DeleteOtherSessions deletes every session of accountID except keepID and
+// returns how many were removed.
+func DeleteOtherSessions(database *sql.DB, accountID, keepID string) (int, error) {
+	res, err := database.Exec(`DELETE FROM sessions WHERE account_id = ? AND id != ?`, accountID, keepID)
+	if err != nil {
+		return 0, err
+	}
+	n, _ := res.RowsAffected()
+	return int(n), nil
+}
+
+func UpdateSessionIP(database *sql.DB, id, ip string) error {
+	_, err := database.Exec(`UPDATE sessions SET ip = ? WHERE id = ?`, ip, id)
+	return err
+}
+
 func CleanExpiredSessions(database *sql.DB) error {
 	_, err := database.Exec(
 		`DELETE FROM sessions WHERE expires_at < ?`,
@@ -2,6 +2,8 @@ package db
 
 import (
 	"database/sql"
+	"errors"
+	"strings"
 	"time"
 
 	"github.com/YannKr/downloadonce/internal/model"
@@ -46,16 +48,44 @@ func GetToken(database *sql.DB, id string) (*model.DownloadToken, error) {
 	return t, nil
 }
 
-func ListTokensByCampaign(database *sql.DB, campaignID string) ([]model.TokenWithRecipient, error) {
-	rows, err := database.Query(`
+// DeletedRecipientName is shown in place of the name of a recipient that no
+// longer exists.
+const DeletedRecipientName = "(deleted recipient)"
+
+const tokensWithRecipientQuery = `
 		SELECT t.id, t.campaign_id, t.recipient_id, t.max_downloads, t.download_count,
 		  t.state, t.watermarked_path, t.sha256_output, t.output_size_bytes, t.expires_at, t.created_at,
-		  r.name, r.email, r.org,
+		  t.link_emailed_at,
+		  COALESCE(r.name, ?), COALESCE(r.email, ''), COALESCE(r.org, ''),
 		  (SELECT MAX(de.downloaded_at) FROM download_events de WHERE de.token_id = t.id) AS last_download
 		FROM download_tokens t
-		JOIN recipients r ON r.id = t.recipient_id
+		LEFT JOIN recipients r ON r.id = t.recipient_id
 		WHERE t.campaign_id = ?
-		ORDER BY r.name ASC`, campaignID)
+		ORDER BY r.name ASC, t.id ASC`
+
+// ListTokensByCampaign returns every token of a campaign with its recipient's
+// details. Tokens whose recipient row is gone are still listed, with
+// DeletedRecipientName and empty email/org.
+func ListTokensByCampaign(database *sql.DB, campaignID string) ([]model.TokenWithRecipient, error) {
+	return queryTokensWithRecipient(database, tokensWithRecipientQuery, DeletedRecipientName, campaignID)
+}
+
+// ListTokensByCampaignPaged returns one page of ListTokensByCampaign, in the
+// same order, without loading the rest of the campaign.
+func ListTokensByCampaignPaged(database *sql.DB, campaignID string, limit, offset int) ([]model.TokenWithRecipient, error) {
+	return queryTokensWithRecipient(database, tokensWithRecipientQuery+` LIMIT ? OFFSET ?`,
+		DeletedRecipientName, campaignID, limit, offset)
+}
+
+// CountTokensByCampaign returns how many tokens a campaign has.
+func CountTokensByCampaign(database *sql.DB, campaignID string) (int, error) {
+	var n int
+	err := database.QueryRow(`SELECT COUNT(*) FROM download_tokens WHERE campaign_id = ?`, campaignID).Scan(&n)
+	return n, err
+}
+
+func queryTokensWithRecipient(database *sql.DB, query string, args ...any) ([]model.TokenWithRecipient, error) {
+	rows, err := database.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -64,12 +94,13 @@ func ListTokensByCampaign(database *sql.DB, campaignID string) ([]model.TokenWit
 	var tokens []model.TokenWithRecipient
 	for rows.Next() {
 		var tw model.TokenWithRecipient
-		var expiresAt, lastDL *string
+		var expiresAt, emailedAt, lastDL *string
 		var createdAt SQLiteTime
 		err := rows.Scan(
 			&tw.ID, &tw.CampaignID, &tw.RecipientID, &tw.MaxDownloads, &tw.DownloadCount,
 			&tw.State, &tw.WatermarkedPath, &tw.SHA256Output, &tw.OutputSizeBytes,
 			&expiresAt, &createdAt,
+			&emailedAt,
 			&tw.RecipientName, &tw.RecipientEmail, &tw.RecipientOrg,
 			&lastDL,
 		)
@@ -81,6 +112,10 @@ func ListTokensByCampaign(database *sql.DB, campaignID string) ([]model.TokenWit
 			t, _ := time.Parse(time.RFC3339, *expiresAt)
 			tw.ExpiresAt = &t
 		}
+		if emailedAt != nil {
+			t, _ := time.Parse(time.RFC3339, *emailedAt)
+			tw.LinkEmailedAt = &t
+		}
 		if lastDL != nil {
 			t, _ := time.Parse(time.RFC3339, *lastDL)
 			tw.LastDownloadAt = &t
@@ -90,17 +125,70 @@ func ListTokensByCampaign(database *sql.DB, campaignID string) ([]model.TokenWit
 	return tokens, rows.Err()
 }
 
+// MarkLinksEmailed records that the download links of tokenIDs were emailed,
+// so a later publish of the same campaign skips them.
+func MarkLinksEmailed(database *sql.DB, tokenIDs []string) error {
+	if len(tokenIDs) == 0 {
+		return nil
+	}
+	args := make([]any, 0, len(tokenIDs)+1)
+	args = append(args, time.Now().UTC().Format(time.RFC3339))
+	for _, id := range tokenIDs {
+		args = append(args, id)
+	}
+	_, err := database.Exec(`UPDATE download_tokens SET link_emailed_at = ?
+		WHERE id IN (?`+strings.Repeat(", ?", len(tokenIDs)-1)+`)`, args...)
+	return err
+}
+
 func ActivateToken(database *sql.DB, id, watermarkedPath, sha256 string, sizeBytes int64) error {
 	_, err := database.Exec(
 		`UPDATE download_tokens SET state = 'ACTIVE', watermarked_path = ?, sha256_output = ?, output_size_bytes = ?
-		 WHERE id = ?`,
+		 WHERE id = ? AND state = 'PENDING'`,
 		watermarkedPath, sha256, sizeBytes, id,
 	)
 	return err
 }
 
-func IncrementDownloadCount(database *sql.DB, tokenID string) (newCount int, consumed bool, err error) {
-	err = database.QueryRow(`
+// ErrDownloadBudgetExhausted is returned by IncrementDownloadCount when the
+// campaign's max_total_downloads has already been reached.
+var ErrDownloadBudgetExhausted = errors.New("campaign download budget exhausted")
+
+// IncrementDownloadCount counts one download of an ACTIVE token against both
+// the token and its campaign's download budget, in one transaction so that
+// concurrent downloads cannot overrun either limit. It returns sql.ErrNoRows
+// when the token is no longer ACTIVE and ErrDownloadBudgetExhausted when the
+// budget was already used up. Once the budget runs out, the campaign's
+// remaining PENDING and ACTIVE tokens are expired.
+func IncrementDownloadCount(database *sql.DB, tokenID, campaignID string) (newCount int, consumed bool, err error) {
+	tx, err := database.Begin()
+	if err != nil {
+		return 0, false, err
+	}
+	defer tx.Rollback()
+
+	var total int
+	var budget *int
+	err = tx.QueryRow(`
+		UPDATE campaigns SET total_downloads = total_downloads + 1
+		WHERE id = ? AND (max_total_downloads IS NULL OR total_downloads < max_total_downloads)
+		RETURNING total_downloads, max_total_downloads`,
+		campaignID,
+	).Scan(&total, &budget)
+	if errors.Is(err, sql.ErrNoRows) {
+		if err := expireLiveTokens(tx, campaignID); err != nil {
+			return 0, false, err
+		}
+		if err := tx.Commit(); err != nil {
+			return 0, false, err
+		}
+		return 0, false, ErrDownloadBudgetExhausted
+	}
+	if err != nil {
+		return 0, false, err
+	}
+
+	err = tx.QueryRow(`
 		UPDATE download_tokens
 		SET download_count = download_count + 1,
 		    state = CASE
@@ -111,10 +199,114 @@ func IncrementDownloadCount(database *sql.DB, tokenID string) (newCount int, con
 		RETURNING download_count, (max_downloads IS NOT NULL AND download_count >= max_downloads)`,
 		tokenID,
 	).Scan(&newCount, &consumed)
-	return
+	if err != nil {
+		return 0, false, err
+	}
+
+	if budget != nil && total >= *budget {
+		// This was the last download the budget allows.
+		if err := expireLiveTokens(tx, campaignID); err != nil {
+			return 0, false, err
+		}
+	}
+	return newCount, consumed, tx.Commit()
+}
+
+// expireLiveTokens expires the campaign's PENDING and ACTIVE tokens.
+func expireLiveTokens(tx *sql.Tx, campaignID string) error {
+	_, err := tx.Exec(`UPDATE download_tokens SET state = 'EXPIRED' WHERE campaign_id = ? AND state IN ('PENDING', 'ACTIVE')`, campaignID)
+	return err
 }
 
 func ExpireToken(database *sql.DB, id string) error {
 	_, err := database.Exec(`UPDATE download_tokens SET state = 'EXPIRED' WHERE id = ?`, id)
 	return err
 }
+
+// HasLiveToken reports whether the recipient holds a non-expired token in the
+// campaign other than excludeID.
+func HasLiveToken(database *sql.DB, campaignID, recipientID, excludeID string) (bool, error) {
+	var n int
+	err := database.QueryRow(
+		`SELECT COUNT(*) FROM download_tokens
+		 WHERE campaign_id = ? AND recipient_id = ? AND id != ? AND state != 'EXPIRED'`,
+		campaignID, recipientID, excludeID,
+	).Scan(&n)
+	return n > 0, err
+}
+
+// ReissueToken expires oldID and inserts t in its place within a single
+// transaction, so the recipient never holds two live tokens at once.
+func ReissueToken(database *sql.DB, oldID string, t *model.DownloadToken) error {
+	tx, err := database.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`UPDATE download_tokens SET state = 'EXPIRED' WHERE id = ?`, oldID); err != nil {
+		return err
+	}
+
+	var expiresAt *string
+	if t.ExpiresAt != nil {
+		s := t.ExpiresAt.UTC().Format(time.RFC3339)
+		expiresAt = &s
+	}
+	if _, err := tx.Exec(
+		`INSERT INTO download_tokens (id, campaign_id, recipient_id, max_downloads, state, expires_at)
+		 VALUES (?, ?, ?, ?, ?, ?)`,
+		t.ID, t.CampaignID, t.RecipientID, t.MaxDownloads, t.State, expiresAt,
+	); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// CountTokensByRecipient returns how many tokens, in any state, the
+// recipient holds across all campaigns. download_tokens.recipient_id has no
+// ON DELETE clause, so a recipient can only be deleted at zero.
+func CountTokensByRecipient(database *sql.DB, recipientID string) (int, error) {
+	var n int
+	err := database.QueryRow(
+		`SELECT COUNT(*) FROM download_tokens WHERE recipient_id = ?`,
+		recipientID,
+	).Scan(&n)
+	return n, err
+}
+
+// FirstWatermarkedPath returns the watermarked file of the campaign's oldest
+// token that has one, or "" when no copy has been generated yet.
+func FirstWatermarkedPath(database *sql.DB, campaignID string) (string, error) {
+	var path string
+	err := database.QueryRow(
+		`SELECT watermarked_path FROM download_tokens
+		 WHERE campaign_id = ? AND watermarked_path IS NOT NULL AND watermarked_path != ''
+		 ORDER BY created_at ASC, id ASC LIMIT 1`, campaignID,
+	).Scan(&path)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return path, err
+}
+
+// ListWatermarkedPaths returns every watermarked_path a token refers to, for
+// the orphaned file sweep.
+func ListWatermarkedPaths(database *sql.DB) (map[string]bool, error) {
+	rows, err := database.Query(
+		`SELECT watermarked_path FROM download_tokens
+		 WHERE watermarked_path IS NOT NULL AND watermarked_path != ''`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	paths := make(map[string]bool)
+	for rows.Next() {
+		var p string
+		if err := rows.Scan(&p); err != nil {
+			return nil, err
+		}
+		paths[p] = true
+	}
+	return paths, rows.Err()
+}
@@ -0,0 +1,157 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestListTokensByCampaignKeepsOrphanedTokens(t *testing.T) {
+	database := openTestDB(t)
+	seedCampaign(t, database, "acc", "camp", "alice", "bob")
+
+	// Simulate a recipient removed behind the token's back (e.g. a legacy
+	// database without foreign key enforcement).
+	database.Exec("PRAGMA foreign_keys=OFF")
+	if _, err := database.Exec(`DELETE FROM recipients WHERE id = 'bob'`); err != nil {
+		t.Fatal(err)
+	}
+	database.Exec("PRAGMA foreign_keys=ON")
+
+	tokens, err := ListTokensByCampaign(database, "camp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 2 {
+		t.Fatalf("got %d tokens, want 2", len(tokens))
+	}
+	byRecipient := map[string]string{}
+	for _, tw := range tokens {
+		byRecipient[tw.RecipientID] = tw.RecipientName
+	}
+	if byRecipient["alice"] != "alice" {
+		t.Errorf("alice name = %q", byRecipient["alice"])
+	}
+	if byRecipient["bob"] != DeletedRecipientName {
+		t.Errorf("orphaned token name = %q, want placeholder", byRecipient["bob"])
+	}
+}
+
+func TestCountTokensByRecipient(t *testing.T) {
+	database := openTestDB(t)
+	seedCampaign(t, database, "acc", "c1", "alice")
+	seedCampaign(t, database, "acc", "c2", "alice")
+
+	n, err := CountTokensByRecipient(database, "alice")
+	if err != nil || n != 2 {
+		t.Fatalf("count = %d, %v; want 2", n, err)
+	}
+	// An expired token still references the recipient.
+	ExpireToken(database, "c1-alice")
+	if n, _ = CountTokensByRecipient(database, "alice"); n != 2 {
+		t.Errorf("after expiring one: count = %d, want 2", n)
+	}
+}
+
+func TestListTokensByCampaignPaged(t *testing.T) {
+	database := openTestDB(t)
+	seedCampaign(t, database, "acc", "camp", "r1", "r2", "r3", "r4", "r5")
+	seedCampaign(t, database, "acc", "other", "r1")
+
+	if n, err := CountTokensByCampaign(database, "camp"); err != nil || n != 5 {
+		t.Fatalf("count = %d, %v; want 5", n, err)
+	}
+	all, _ := ListTokensByCampaign(database, "camp")
+	var paged []string
+	for offset := 0; offset < 6; offset += 2 {
+		page, err := ListTokensByCampaignPaged(database, "camp", 2, offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := min(2, 5-offset); len(page) != want {
+			t.Fatalf("page at %d has %d tokens, want %d", offset, len(page), want)
+		}
+		for _, tw := range page {
+			paged = append(paged, tw.ID)
+		}
+	}
+	for i, tw := range all {
+		if paged[i] != tw.ID {
+			t.Fatalf("paged order %v differs from full listing at %d", paged, i)
+		}
+	}
+
+	available, err := ListRecipientsNotInCampaign(database, "other")
+	if err != nil || len(available) != 4 || available[0].ID != "r2" {
+		t.Errorf("recipients not in other = %v, %v; want r2..r5", available, err)
+	}
+}
+
+func TestMarkLinksEmailed(t *testing.T) {
+	database := openTestDB(t)
+	seedCampaign(t, database, "acc", "camp", "alice", "bob")
+
+	if err := MarkLinksEmailed(database, []string{"camp-alice"}); err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := ListTokensByCampaign(database, "camp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tw := range tokens {
+		if emailed := tw.LinkEmailedAt != nil; emailed != (tw.ID == "camp-alice") {
+			t.Errorf("%s: emailed = %v", tw.ID, emailed)
+		}
+	}
+}
+
+func TestIncrementDownloadCountBudget(t *testing.T) {
+	database := openTestDB(t)
+	recipients := []string{"r1", "r2", "r3", "r4", "r5", "r6", "r7", "r8"}
+	seedCampaign(t, database, "acc", "camp", recipients...)
+	if _, err := database.Exec(`UPDATE campaigns SET max_total_downloads = 5 WHERE id = 'camp'`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := database.Exec(`UPDATE download_tokens SET state = 'ACTIVE' WHERE campaign_id = 'camp'`); err != nil {
+		t.Fatal(err)
+	}
+
+	// Two downloads per token, all at once: only the budget's five succeed.
+	var mu sync.Mutex
+	var served, refused int
+	var wg sync.WaitGroup
+	for _, rid := range recipients {
+		for range 2 {
+			wg.Add(1)
+			go func(tokenID string) {
+				defer wg.Done()
+				_, _, err := IncrementDownloadCount(database, tokenID, "camp")
+				mu.Lock()
+				defer mu.Unlock()
+				switch {
+				case err == nil:
+					served++
+				case errors.Is(err, ErrDownloadBudgetExhausted), errors.Is(err, sql.ErrNoRows):
+					refused++
+				default:
+					t.Errorf("%s: %v", tokenID, err)
+				}
+			}("camp-" + rid)
+		}
+	}
+	wg.Wait()
+
+	if served != 5 || refused != 11 {
+		t.Errorf("served %d, refused %d; want 5 and 11", served, refused)
+	}
+	c, _ := GetCampaign(database, "camp")
+	if c.TotalDownloads != 5 || *c.RemainingDownloads() != 0 {
+		t.Errorf("total_downloads = %d, remaining %d", c.TotalDownloads, *c.RemainingDownloads())
+	}
+	var sum, live int
+	database.QueryRow(`SELECT SUM(download_count), SUM(state IN ('ACTIVE', 'PENDING')) FROM download_tokens WHERE campaign_id = 'camp'`).Scan(&sum, &live)
+	if sum != 5 || live != 0 {
+		t.Errorf("tokens: %d downloads counted, %d still live; want 5 and 0", sum, live)
+	}
+}
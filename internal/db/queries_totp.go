@@ -0,0 +1,97 @@
+package db
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+
+	"github.com/google/uuid"
+)
+
+// hashRecoveryCode returns the stored form of a recovery code. Codes are
+// random and high-entropy, so a plain SHA-256 is sufficient.
+func hashRecoveryCode(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// EnableTOTP stores the account's TOTP secret and replaces its recovery codes.
+func EnableTOTP(database *sql.DB, accountID, secret string, recoveryCodes []string) error {
+	tx, err := database.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`UPDATE accounts SET totp_secret = ? WHERE id = ?`, secret, accountID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM totp_recovery_codes WHERE account_id = ?`, accountID); err != nil {
+		return err
+	}
+	for _, code := range recoveryCodes {
+		if _, err := tx.Exec(
+			`INSERT INTO totp_recovery_codes (id, account_id, code_hash) VALUES (?, ?, ?)`,
+			uuid.New().String(), accountID, hashRecoveryCode(code),
+		); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// DisableTOTP clears the account's TOTP secret and recovery codes.
+func DisableTOTP(database *sql.DB, accountID string) error {
+	tx, err := database.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`UPDATE accounts SET totp_secret = NULL WHERE id = ?`, accountID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM totp_recovery_codes WHERE account_id = ?`, accountID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UseRecoveryCode marks an unused recovery code as used. It reports false
+// when the code does not match any unused code for the account.
+func UseRecoveryCode(database *sql.DB, accountID, code string) (bool, error) {
+	res, err := database.Exec(
+		`UPDATE totp_recovery_codes SET used_at = strftime('%Y-%m-%dT%H:%M:%fZ', 'now')
+		 WHERE account_id = ? AND code_hash = ? AND used_at IS NULL`,
+		accountID, hashRecoveryCode(code),
+	)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// AcceptTOTPStep records step as the account's last accepted login step,
+// provided it is still prev. It reports false when another login got there
+// first, so the same code or pending login can't be used twice.
+func AcceptTOTPStep(database *sql.DB, accountID string, prev, step int64) (bool, error) {
+	res, err := database.Exec(
+		`UPDATE accounts SET totp_last_step = ? WHERE id = ? AND COALESCE(totp_last_step, 0) = ?`,
+		step, accountID, prev,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// CountUnusedRecoveryCodes returns how many recovery codes the account has left.
+func CountUnusedRecoveryCodes(database *sql.DB, accountID string) (int, error) {
+	var n int
+	err := database.QueryRow(
+		`SELECT COUNT(*) FROM totp_recovery_codes WHERE account_id = ? AND used_at IS NULL`, accountID,
+	).Scan(&n)
+	return n, err
+}
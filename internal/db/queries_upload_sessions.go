@@ -8,32 +8,64 @@ import (
 	"github.com/YannKr/downloadonce/internal/model"
 )
 
-func CreateUploadSession(database *sql.DB, s *model.UploadSession) error {
-	chunks, _ := json.Marshal(s.ReceivedChunks)
-	_, err := database.Exec(
-		`INSERT INTO upload_sessions
+const insertUploadSessionSQL = `INSERT INTO upload_sessions
 		 (id, account_id, filename, size, mime_type, chunk_size, total_chunks,
-		  received_chunks, status, storage_path, created_at, updated_at, expires_at)
-		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
-		s.ID, s.AccountID, s.Filename, s.Size, s.MimeType, s.ChunkSize, s.TotalChunks,
-		string(chunks), s.Status, s.StoragePath,
+		  received_chunks, chunk_hashes, status, storage_path, created_at, updated_at, expires_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+func uploadSessionArgs(s *model.UploadSession) []any {
+	chunks, _ := json.Marshal(s.ReceivedChunks)
+	hashes := []byte("[]")
+	if len(s.ChunkHashes) > 0 {
+		hashes, _ = json.Marshal(s.ChunkHashes)
+	}
+	return []any{s.ID, s.AccountID, s.Filename, s.Size, s.MimeType, s.ChunkSize, s.TotalChunks,
+		string(chunks), string(hashes), s.Status, s.StoragePath,
 		s.CreatedAt.UTC().Format(time.RFC3339),
 		s.UpdatedAt.UTC().Format(time.RFC3339),
 		s.ExpiresAt.UTC().Format(time.RFC3339),
-	)
-	return err
+	}
+}
+
+// CreateUploadSessionWithinQuota inserts s with the account's upload cap
+// checked in the same transaction; see CreateAssetWithinQuota.
+func CreateUploadSessionWithinQuota(database *sql.DB, s *model.UploadSession, limit int64) (int64, error) {
+	tx, err := database.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if used, err := checkUploadQuota(tx, s.AccountID, s.Size, limit); err != nil {
+		return used, err
+	}
+	if _, err := tx.Exec(insertUploadSessionSQL, uploadSessionArgs(s)...); err != nil {
+		return 0, err
+	}
+	return 0, tx.Commit()
+}
+
+// SumPendingUploadBytes totals the declared sizes of the account's chunked
+// uploads that have not completed yet, so in-flight uploads count towards
+// the account's upload cap.
+func SumPendingUploadBytes(database *sql.DB, accountID string) (int64, error) {
+	var n int64
+	err := database.QueryRow(
+		`SELECT COALESCE(SUM(size), 0) FROM upload_sessions WHERE account_id = ? AND status = 'PENDING'`, accountID,
+	).Scan(&n)
+	return n, err
 }
 
 func GetUploadSession(database *sql.DB, id string) (*model.UploadSession, error) {
 	s := &model.UploadSession{}
 	var createdAt, updatedAt, expiresAt SQLiteTime
-	var chunksJSON string
+	var chunksJSON, hashesJSON string
 	err := database.QueryRow(
 		`SELECT id, account_id, filename, size, mime_type, chunk_size, total_chunks,
-		  received_chunks, status, storage_path, created_at, updated_at, expires_at
+		  received_chunks, chunk_hashes, status, storage_path, created_at, updated_at, expires_at
 		 FROM upload_sessions WHERE id = ?`, id,
 	).Scan(&s.ID, &s.AccountID, &s.Filename, &s.Size, &s.MimeType, &s.ChunkSize,
-		&s.TotalChunks, &chunksJSON, &s.Status, &s.StoragePath,
+		&s.TotalChunks, &chunksJSON, &hashesJSON, &s.Status, &s.StoragePath,
 		&createdAt, &updatedAt, &expiresAt)
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -45,6 +77,7 @@ func GetUploadSession(database *sql.DB, id string) (*model.UploadSession, error)
 	s.UpdatedAt = updatedAt.Time
 	s.ExpiresAt = expiresAt.Time
 	json.Unmarshal([]byte(chunksJSON), &s.ReceivedChunks)
+	json.Unmarshal([]byte(hashesJSON), &s.ChunkHashes)
 	return s, nil
 }
 
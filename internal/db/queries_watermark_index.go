@@ -0,0 +1,137 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+// WatermarkIndexEntry is one row of the watermark_index table, as used for
+// backup export and import.
+type WatermarkIndexEntry struct {
+	PayloadHex  string    `json:"payload_hex"`
+	TokenID     string    `json:"token_id"`
+	CampaignID  string    `json:"campaign_id"`
+	RecipientID string    `json:"recipient_id"`
+	Algorithm   string    `json:"algorithm"`
+	CreatedAt   time.Time `json:"created_at"`
+	// SelfVerified is nil when the post-embed self-verify did not run.
+	SelfVerified *bool `json:"self_verified,omitempty"`
+	// Channels and Scale are the invisible watermark params of the embed,
+	// empty when not known.
+	Channels string   `json:"wm_channels,omitempty"`
+	Scale    *float64 `json:"wm_scale,omitempty"`
+}
+
+// EachWatermarkIndex calls fn for every watermark_index row, oldest first.
+// Rows are read in keyset-paginated pages of ExportPageSize and each query is
+// closed before fn runs, so a slow consumer never holds the (single)
+// connection. Iteration stops at the first error returned by fn.
+func EachWatermarkIndex(database *sql.DB, fn func(WatermarkIndexEntry) error) error {
+	var afterAt, afterPayload string // cursor: last row of the previous page
+	for {
+		page, lastAt, err := watermarkIndexPage(database, afterAt, afterPayload)
+		if err != nil {
+			return err
+		}
+		for _, e := range page {
+			if err := fn(e); err != nil {
+				return err
+			}
+		}
+		if len(page) < ExportPageSize {
+			return nil
+		}
+		afterAt, afterPayload = lastAt, page[len(page)-1].PayloadHex
+	}
+}
+
+// watermarkIndexPage reads the rows after the (afterAt, afterPayload) cursor.
+// lastAt is the raw created_at of the final row.
+func watermarkIndexPage(database *sql.DB, afterAt, afterPayload string) (entries []WatermarkIndexEntry, lastAt string, err error) {
+	rows, err := database.Query(`
+		SELECT payload_hex, token_id, campaign_id, recipient_id, wm_algorithm, created_at, self_verified,
+		  COALESCE(wm_channels, ''), wm_scale
+		FROM watermark_index
+		WHERE ? = '' OR created_at > ? OR (created_at = ? AND payload_hex > ?)
+		ORDER BY created_at ASC, payload_hex ASC
+		LIMIT ?`, afterAt, afterAt, afterAt, afterPayload, ExportPageSize)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e WatermarkIndexEntry
+		var createdAt SQLiteTime
+		var verified sql.NullBool
+		if err := rows.Scan(&e.PayloadHex, &e.TokenID, &e.CampaignID, &e.RecipientID, &e.Algorithm, &lastAt, &verified, &e.Channels, &e.Scale); err != nil {
+			return nil, "", err
+		}
+		if err := createdAt.Scan(lastAt); err != nil {
+			return nil, "", err
+		}
+		e.CreatedAt = createdAt.Time
+		if verified.Valid {
+			e.SelfVerified = &verified.Bool
+		}
+		entries = append(entries, e)
+	}
+	return entries, lastAt, rows.Err()
+}
+
+// ImportWatermarkIndex inserts entries inside one transaction, leaving any
+// existing payload untouched. It returns how many rows were newly inserted.
+func ImportWatermarkIndex(database *sql.DB, entries []WatermarkIndexEntry) (inserted int, err error) {
+	tx, err := database.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(
+		`INSERT OR IGNORE INTO watermark_index (payload_hex, token_id, campaign_id, recipient_id, wm_algorithm, created_at, self_verified, wm_channels, wm_scale)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, NULLIF(?, ''), ?)`)
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	for _, e := range entries {
+		createdAt := e.CreatedAt
+		if createdAt.IsZero() {
+			createdAt = time.Now()
+		}
+		res, err := stmt.Exec(e.PayloadHex, e.TokenID, e.CampaignID, e.RecipientID, e.Algorithm,
+			createdAt.UTC().Format("2006-01-02T15:04:05.000Z"), e.SelfVerified, e.Channels, e.Scale)
+		if err != nil {
+			return 0, err
+		}
+		n, _ := res.RowsAffected()
+		inserted += int(n)
+	}
+	return inserted, tx.Commit()
+}
+
+// ListEmbeddedInvisibleParams returns the distinct invisible params recorded
+// in the watermark index, most used first.
+func ListEmbeddedInvisibleParams(database *sql.DB) ([]InvisibleParamOverride, error) {
+	rows, err := database.Query(`
+		SELECT wm_channels, wm_scale FROM watermark_index
+		WHERE wm_channels IS NOT NULL AND wm_scale IS NOT NULL
+		GROUP BY wm_channels, wm_scale
+		ORDER BY COUNT(*) DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var params []InvisibleParamOverride
+	for rows.Next() {
+		var o InvisibleParamOverride
+		if err := rows.Scan(&o.Channels, &o.Scale); err != nil {
+			return nil, err
+		}
+		params = append(params, o)
+	}
+	return params, rows.Err()
+}
@@ -0,0 +1,58 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestEachWatermarkIndexStreamsPages(t *testing.T) {
+	database := openTestDB(t)
+	seedCampaign(t, database, "acc", "camp", "r1")
+
+	// Entries share timestamps so the keyset cursor has to break ties by payload.
+	base := time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+	const n = 250
+	entries := make([]WatermarkIndexEntry, n)
+	for i := range entries {
+		entries[i] = WatermarkIndexEntry{
+			PayloadHex: fmt.Sprintf("%016x", n-i), TokenID: "camp-r1", CampaignID: "camp", RecipientID: "r1",
+			Algorithm: "dwt", CreatedAt: base.Add(time.Duration(i/7) * time.Minute),
+		}
+	}
+	if _, err := ImportWatermarkIndex(database, entries); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(old int) { ExportPageSize = old }(ExportPageSize)
+	ExportPageSize = 20
+
+	seen := map[string]bool{}
+	var prev WatermarkIndexEntry
+	err := EachWatermarkIndex(database, func(e WatermarkIndexEntry) error {
+		if len(seen) > 0 && (e.CreatedAt.Before(prev.CreatedAt) ||
+			e.CreatedAt.Equal(prev.CreatedAt) && e.PayloadHex <= prev.PayloadHex) {
+			t.Fatalf("row %d out of order: %+v after %+v", len(seen), e, prev)
+		}
+		prev = e
+		seen[e.PayloadHex] = true
+		if len(seen)%50 == 0 {
+			// The pool has a single connection; this would block if the
+			// export still held it while handing rows out.
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+			var one int
+			if err := database.QueryRowContext(ctx, "SELECT 1").Scan(&one); err != nil {
+				return fmt.Errorf("query during export: %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seen) != n {
+		t.Errorf("exported %d entries, want %d", len(seen), n)
+	}
+}
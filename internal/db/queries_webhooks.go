@@ -162,13 +162,15 @@ func ListDueWebhookDeliveries(database *sql.DB, now time.Time) ([]model.WebhookD
 	return deliveries, rows.Err()
 }
 
-func ListWebhookDeliveries(database *sql.DB, webhookID string, limit, offset int) ([]model.WebhookDelivery, error) {
+// ListWebhookDeliveries returns a page of the webhook's deliveries, newest
+// first, limited to those in state unless it is empty.
+func ListWebhookDeliveries(database *sql.DB, webhookID, state string, limit, offset int) ([]model.WebhookDelivery, error) {
 	rows, err := database.Query(
 		`SELECT id, webhook_id, event_type, event_id, attempt_number,
 		        response_status, response_body_preview, error_message, state,
 		        next_retry_at, delivered_at, created_at
-		 FROM webhook_deliveries WHERE webhook_id = ?
-		 ORDER BY created_at DESC LIMIT ? OFFSET ?`, webhookID, limit, offset)
+		 FROM webhook_deliveries WHERE webhook_id = ? AND (? = '' OR state = ?)
+		 ORDER BY created_at DESC LIMIT ? OFFSET ?`, webhookID, state, state, limit, offset)
 	if err != nil {
 		return nil, err
 	}
@@ -241,6 +243,23 @@ func ReplayWebhookDelivery(database *sql.DB, id string) error {
 	return err
 }
 
+// ReplayExhaustedWebhookDeliveries re-queues every exhausted delivery of the
+// webhook in one statement, the same way ReplayWebhookDelivery does, and
+// returns how many were re-queued. Deliveries already pending again are not
+// touched, so repeating the call does not reset their attempts.
+func ReplayExhaustedWebhookDeliveries(database *sql.DB, webhookID string) (int64, error) {
+	nowStr := time.Now().UTC().Format(time.RFC3339)
+	res, err := database.Exec(
+		`UPDATE webhook_deliveries
+		 SET state = 'pending', attempt_number = 0, next_retry_at = ?,
+		     error_message = '', response_status = NULL, response_body_preview = ''
+		 WHERE webhook_id = ? AND state = 'exhausted'`, nowStr, webhookID)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
 func GetLastDeliveryPerWebhook(database *sql.DB, accountID string) (map[string]*model.WebhookDelivery, error) {
 	rows, err := database.Query(
 		`SELECT wd.webhook_id, wd.state, wd.created_at, wd.response_status, wd.error_message
@@ -283,10 +302,13 @@ func CountExhaustedDeliveriesLast24h(database *sql.DB, accountID string) (int, e
 	return count, err
 }
 
-func CountWebhookDeliveries(database *sql.DB, webhookID string) (int, error) {
+// CountWebhookDeliveries counts the webhook's deliveries, only those in state
+// unless it is empty.
+func CountWebhookDeliveries(database *sql.DB, webhookID, state string) (int, error) {
 	var count int
 	err := database.QueryRow(
-		`SELECT COUNT(*) FROM webhook_deliveries WHERE webhook_id = ?`, webhookID,
+		`SELECT COUNT(*) FROM webhook_deliveries WHERE webhook_id = ? AND (? = '' OR state = ?)`,
+		webhookID, state, state,
 	).Scan(&count)
 	return count, err
 }
@@ -135,6 +135,13 @@ func (c *Cache) refresh() {
 	c.mu.Unlock()
 }
 
+// FreeBytes returns the bytes currently free on the filesystem holding path,
+// bypassing the cache. Use it for pre-flight checks right before a large write.
+func FreeBytes(path string) (uint64, error) {
+	_, free, err := statFS(path)
+	return free, err
+}
+
 func statFS(path string) (total, free uint64, err error) {
 	var stat syscall.Statfs_t
 	if err = syscall.Statfs(path, &stat); err != nil {
@@ -0,0 +1,121 @@
+package email
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// DownloadEntry is one download reported to a campaign owner.
+type DownloadEntry struct {
+	CampaignName   string
+	RecipientName  string
+	RecipientEmail string
+	IPAddress      string
+	Time           time.Time
+}
+
+// MaxDigestEntries caps how many downloads a pending digest lists per
+// account. Further downloads in the same interval are only counted, so a
+// burst of downloads cannot grow the buffer without bound.
+const MaxDigestEntries = 200
+
+type pendingDigest struct {
+	to, ownerName string
+	entries       []DownloadEntry
+	omitted       int // downloads past MaxDigestEntries
+}
+
+// Digester batches owner download notifications per account and sends each
+// account one digest email per interval instead of an email per download.
+type Digester struct {
+	interval time.Duration
+	send     func(to, ownerName string, entries []DownloadEntry, omitted int) error
+
+	mu      sync.Mutex
+	pending map[string]*pendingDigest // by account ID
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewDigester creates a Digester that sends through m every interval.
+// Call Start before Add and Stop on shutdown.
+func NewDigester(m *Mailer, interval time.Duration) *Digester {
+	return &Digester{
+		interval: interval,
+		send:     m.SendDownloadDigest,
+		pending:  make(map[string]*pendingDigest),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Start launches the flush loop.
+func (d *Digester) Start() {
+	go d.loop()
+}
+
+// Stop sends any pending digests and waits for the flush loop to exit.
+func (d *Digester) Stop() {
+	select {
+	case <-d.stop:
+		return
+	default:
+	}
+	close(d.stop)
+	<-d.done
+}
+
+// Add queues a download for accountID's next digest. to and ownerName are
+// taken from the most recent call, so an email change applies to the
+// pending digest. Past MaxDigestEntries the download is counted but not
+// listed.
+func (d *Digester) Add(accountID, to, ownerName string, e DownloadEntry) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	p := d.pending[accountID]
+	if p == nil {
+		p = &pendingDigest{}
+		d.pending[accountID] = p
+	}
+	p.to, p.ownerName = to, ownerName
+	if len(p.entries) >= MaxDigestEntries {
+		p.omitted++
+		return
+	}
+	p.entries = append(p.entries, e)
+}
+
+// Flush sends one digest per account with pending downloads.
+func (d *Digester) Flush() {
+	d.mu.Lock()
+	pending := d.pending
+	d.pending = make(map[string]*pendingDigest)
+	d.mu.Unlock()
+
+	for accountID, p := range pending {
+		if err := d.send(p.to, p.ownerName, p.entries, p.omitted); err != nil {
+			slog.Error("send download digest", "account", accountID, "downloads", len(p.entries)+p.omitted, "error", err)
+		}
+	}
+}
+
+func (d *Digester) loop() {
+	defer close(d.done)
+
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			d.Flush()
+		case <-d.stop:
+			d.Flush()
+			return
+		}
+	}
+}
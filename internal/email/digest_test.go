@@ -0,0 +1,103 @@
+package email
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+type sentDigest struct {
+	to      string
+	entries []DownloadEntry
+	omitted int
+}
+
+func testDigester(interval time.Duration) (*Digester, func() []sentDigest) {
+	var mu sync.Mutex
+	var sent []sentDigest
+	d := NewDigester(&Mailer{}, interval)
+	d.send = func(to, ownerName string, entries []DownloadEntry, omitted int) error {
+		mu.Lock()
+		defer mu.Unlock()
+		sent = append(sent, sentDigest{to: to, entries: entries, omitted: omitted})
+		return nil
+	}
+	return d, func() []sentDigest {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]sentDigest(nil), sent...)
+	}
+}
+
+func TestDigesterBatchesDownloadsPerAccount(t *testing.T) {
+	d, sent := testDigester(time.Hour)
+	d.Start()
+
+	for _, name := range []string{"Alice", "Bob", "Carol"} {
+		d.Add("acct-1", "owner@example.com", "Owner", DownloadEntry{CampaignName: "Launch", RecipientName: name})
+	}
+	if got := sent(); len(got) != 0 {
+		t.Fatalf("sent %d emails before the interval elapsed, want 0", len(got))
+	}
+
+	d.Stop()
+	got := sent()
+	if len(got) != 1 {
+		t.Fatalf("sent %d emails, want 1 digest", len(got))
+	}
+	if got[0].to != "owner@example.com" || len(got[0].entries) != 3 {
+		t.Fatalf("digest = %s with %d entries, want owner@example.com with 3", got[0].to, len(got[0].entries))
+	}
+	if got[0].entries[0].RecipientName != "Alice" || got[0].entries[0].Time.IsZero() {
+		t.Errorf("first entry = %+v, want Alice with a timestamp", got[0].entries[0])
+	}
+}
+
+func TestDigesterCapsEntriesPerAccount(t *testing.T) {
+	d, sent := testDigester(time.Hour)
+	d.Start()
+
+	for i := 0; i < MaxDigestEntries+5; i++ {
+		d.Add("acct-1", "owner@example.com", "Owner", DownloadEntry{CampaignName: "Launch"})
+	}
+	d.Stop()
+	got := sent()
+	if len(got) != 1 {
+		t.Fatalf("sent %d emails, want 1 digest", len(got))
+	}
+	if len(got[0].entries) != MaxDigestEntries || got[0].omitted != 5 {
+		t.Errorf("digest lists %d and omits %d, want %d and 5", len(got[0].entries), got[0].omitted, MaxDigestEntries)
+	}
+}
+
+func TestDigesterFlushesOnInterval(t *testing.T) {
+	d, sent := testDigester(20 * time.Millisecond)
+	d.Start()
+	defer d.Stop()
+
+	d.Add("acct-1", "a@example.com", "A", DownloadEntry{CampaignName: "One"})
+	d.Add("acct-1", "a@example.com", "A", DownloadEntry{CampaignName: "Two"})
+	d.Add("acct-2", "b@example.com", "B", DownloadEntry{CampaignName: "Three"})
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(sent()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	got := sent()
+	if len(got) != 2 {
+		t.Fatalf("sent %d emails, want one per account (2)", len(got))
+	}
+	perAccount := map[string]int{}
+	for _, s := range got {
+		perAccount[s.to] = len(s.entries)
+	}
+	if perAccount["a@example.com"] != 2 || perAccount["b@example.com"] != 1 {
+		t.Errorf("entries per recipient = %v", perAccount)
+	}
+
+	// Nothing new queued: the next tick sends nothing.
+	time.Sleep(60 * time.Millisecond)
+	if n := len(sent()); n != 2 {
+		t.Errorf("sent %d emails after an empty interval, want 2", n)
+	}
+}
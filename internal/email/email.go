@@ -1,12 +1,10 @@
 package email
 
 import (
-	"crypto/tls"
 	"fmt"
-	"log/slog"
-	"net"
-	"net/smtp"
+	"html"
 	"strings"
+	"sync"
 )
 
 type Mailer struct {
@@ -15,34 +13,40 @@ type Mailer struct {
 	User string
 	Pass string
 	From string
-}
-
-func (m *Mailer) Enabled() bool {
-	return m.Host != ""
-}
 
-func (m *Mailer) SendDownloadLink(to, recipientName, campaignName, downloadURL string) error {
-	subject := fmt.Sprintf("Your download link for %s", campaignName)
+	// Optional: Reply-To header, a MAIL FROM (return-path) distinct from
+	// From, and archive addresses copied on every download link email.
+	ReplyTo      string
+	EnvelopeFrom string
+	BCC          []string
 
-	textBody := fmt.Sprintf(`Hello %s,
-
-Your file "%s" is ready for download.
-
-Download link: %s
+	// Templates renders download link emails; nil uses DefaultTemplates.
+	Templates *Templates
 
-This file has been prepared specifically for you and contains a digital fingerprint that uniquely identifies your copy. Unauthorized redistribution may allow the source to be traced.
+	// Concurrency caps the SMTP connections open at once across all sends;
+	// 0 means DefaultConcurrency. Set before the first send.
+	Concurrency int
 
-If you did not expect this email, please disregard it.
-`, recipientName, campaignName, downloadURL)
+	poolOnce sync.Once
+	slots    chan struct{}
+}
 
-	htmlBody := fmt.Sprintf(`<html><body>
-<p>Hello %s,</p>
-<p>Your file "<strong>%s</strong>" is ready for download.</p>
-<p><a href="%s" style="display:inline-block;padding:10px 24px;background:#4361ee;color:#fff;text-decoration:none;border-radius:4px;">Download File</a></p>
-<p style="color:#666;font-size:12px;">This file has been prepared specifically for you and contains a digital fingerprint that uniquely identifies your copy. Unauthorized redistribution may allow the source to be traced.</p>
-</body></html>`, recipientName, campaignName, downloadURL)
+func (m *Mailer) Enabled() bool {
+	return m.Host != ""
+}
 
-	return m.sendMultipart(to, subject, textBody, htmlBody)
+// DownloadLinkMessage renders a download link email for SendBatch, using
+// m.Templates (the built-in templates when nil).
+func (m *Mailer) DownloadLinkMessage(to string, d LinkEmail) (Message, error) {
+	t := m.Templates
+	if t == nil {
+		t = DefaultTemplates()
+	}
+	subject, textBody, htmlBody, err := t.RenderDownloadLink(d)
+	if err != nil {
+		return Message{}, err
+	}
+	return Message{To: to, Subject: subject, Text: textBody, HTML: htmlBody, BCC: m.BCC}, nil
 }
 
 func (m *Mailer) SendCampaignReady(to, ownerName, campaignName string, recipientCount int) error {
@@ -111,6 +115,75 @@ IP Address: %s
 	return m.sendMultipart(to, subject, textBody, htmlBody)
 }
 
+// SendLinkRequest tells a campaign owner that a recipient whose link is used
+// up or expired asked for a new one. requesterEmail is what the visitor typed
+// on the public page, so every field is escaped in the HTML part.
+func (m *Mailer) SendLinkRequest(to, ownerName, campaignName, recipientName, recipientEmail, requesterEmail, campaignURL string) error {
+	subject := fmt.Sprintf("New link requested: %s by %s", campaignName, recipientName)
+
+	textBody := fmt.Sprintf(`Hello %s,
+
+A recipient of your campaign "%s" opened a link that is no longer valid and asked for a new one.
+
+Recipient: %s (%s)
+Reply to: %s
+
+You can reissue their link from the campaign page: %s
+`, ownerName, campaignName, recipientName, recipientEmail, requesterEmail, campaignURL)
+
+	htmlBody := fmt.Sprintf(`<html><body>
+<p>Hello %s,</p>
+<p>A recipient of your campaign "<strong>%s</strong>" opened a link that is no longer valid and asked for a new one.</p>
+<table style="border-collapse:collapse;margin:12px 0">
+<tr><td style="padding:4px 12px 4px 0;color:#666">Recipient</td><td>%s (%s)</td></tr>
+<tr><td style="padding:4px 12px 4px 0;color:#666">Reply to</td><td>%s</td></tr>
+</table>
+<p>You can reissue their link from the <a href="%s">campaign page</a>.</p>
+</body></html>`, html.EscapeString(ownerName), html.EscapeString(campaignName), html.EscapeString(recipientName),
+		html.EscapeString(recipientEmail), html.EscapeString(requesterEmail), html.EscapeString(campaignURL))
+
+	return m.sendMultipart(to, subject, textBody, htmlBody)
+}
+
+// SendDownloadDigest summarises downloads from the owner's campaigns in one
+// email, oldest first. omitted more downloads are counted but not listed.
+func (m *Mailer) SendDownloadDigest(to, ownerName string, entries []DownloadEntry, omitted int) error {
+	total := len(entries) + omitted
+	subject := fmt.Sprintf("Download digest: %d download", total)
+	if total != 1 {
+		subject += "s"
+	}
+
+	var text, rows strings.Builder
+	for _, e := range entries {
+		when := e.Time.UTC().Format("2006-01-02 15:04 UTC")
+		fmt.Fprintf(&text, "- %s: %s (%s), %s, IP %s\n", e.CampaignName, e.RecipientName, e.RecipientEmail, when, e.IPAddress)
+		fmt.Fprintf(&rows, `<tr><td style="padding:4px 12px 4px 0">%s</td><td style="padding:4px 12px 4px 0">%s (%s)</td><td style="padding:4px 12px 4px 0">%s</td><td>%s</td></tr>`+"\n",
+			html.EscapeString(e.CampaignName), html.EscapeString(e.RecipientName), html.EscapeString(e.RecipientEmail), when, html.EscapeString(e.IPAddress))
+	}
+	var moreHTML string
+	if omitted > 0 {
+		fmt.Fprintf(&text, "...and %d more not listed.\n", omitted)
+		moreHTML = fmt.Sprintf("<p>...and %d more not listed.</p>\n", omitted)
+	}
+
+	textBody := fmt.Sprintf(`Hello %s,
+
+%d file(s) were downloaded from your campaigns:
+
+%s`, ownerName, total, text.String())
+
+	htmlBody := fmt.Sprintf(`<html><body>
+<p>Hello %s,</p>
+<p><strong>%d</strong> file(s) were downloaded from your campaigns:</p>
+<table style="border-collapse:collapse;margin:12px 0">
+<tr style="color:#666"><td style="padding:4px 12px 4px 0">Campaign</td><td style="padding:4px 12px 4px 0">Recipient</td><td style="padding:4px 12px 4px 0">Time</td><td>IP Address</td></tr>
+%s</table>
+%s</body></html>`, html.EscapeString(ownerName), total, rows.String(), moreHTML)
+
+	return m.sendMultipart(to, subject, textBody, htmlBody)
+}
+
 func (m *Mailer) SendJobFailed(to, ownerName, campaignName, recipientName, errorMsg string) error {
 	subject := fmt.Sprintf("Watermarking failed: %s - %s", campaignName, recipientName)
 
@@ -183,76 +256,5 @@ You can retry individual jobs from the campaign detail page.
 }
 
 func (m *Mailer) sendMultipart(to, subject, textBody, htmlBody string) error {
-	if !m.Enabled() {
-		return nil
-	}
-
-	boundary := "----=_Part_downloadonce_boundary"
-
-	headers := []string{
-		fmt.Sprintf("From: %s", m.From),
-		fmt.Sprintf("To: %s", to),
-		fmt.Sprintf("Subject: %s", subject),
-		"MIME-Version: 1.0",
-		fmt.Sprintf(`Content-Type: multipart/alternative; boundary="%s"`, boundary),
-	}
-
-	body := strings.Join(headers, "\r\n") + "\r\n\r\n"
-	body += "--" + boundary + "\r\n"
-	body += "Content-Type: text/plain; charset=utf-8\r\n\r\n"
-	body += textBody + "\r\n"
-	body += "--" + boundary + "\r\n"
-	body += "Content-Type: text/html; charset=utf-8\r\n\r\n"
-	body += htmlBody + "\r\n"
-	body += "--" + boundary + "--\r\n"
-
-	addr := fmt.Sprintf("%s:%d", m.Host, m.Port)
-
-	conn, err := net.Dial("tcp", addr)
-	if err != nil {
-		return fmt.Errorf("smtp dial: %w", err)
-	}
-
-	client, err := smtp.NewClient(conn, m.Host)
-	if err != nil {
-		conn.Close()
-		return fmt.Errorf("smtp client: %w", err)
-	}
-	defer client.Close()
-
-	// STARTTLS
-	if ok, _ := client.Extension("STARTTLS"); ok {
-		tlsConfig := &tls.Config{ServerName: m.Host}
-		if err := client.StartTLS(tlsConfig); err != nil {
-			slog.Warn("smtp starttls failed, continuing without", "error", err)
-		}
-	}
-
-	// Auth
-	if m.User != "" {
-		auth := smtp.PlainAuth("", m.User, m.Pass, m.Host)
-		if err := client.Auth(auth); err != nil {
-			return fmt.Errorf("smtp auth: %w", err)
-		}
-	}
-
-	if err := client.Mail(m.From); err != nil {
-		return fmt.Errorf("smtp mail from: %w", err)
-	}
-	if err := client.Rcpt(to); err != nil {
-		return fmt.Errorf("smtp rcpt to: %w", err)
-	}
-
-	w, err := client.Data()
-	if err != nil {
-		return fmt.Errorf("smtp data: %w", err)
-	}
-	if _, err := w.Write([]byte(body)); err != nil {
-		return fmt.Errorf("smtp write: %w", err)
-	}
-	if err := w.Close(); err != nil {
-		return fmt.Errorf("smtp close: %w", err)
-	}
-
-	return client.Quit()
+	return m.SendBatch([]Message{{To: to, Subject: subject, Text: textBody, HTML: htmlBody}})
 }
@@ -0,0 +1,235 @@
+package email
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultConcurrency is the SMTP connection cap when Mailer.Concurrency is 0.
+const DefaultConcurrency = 4
+
+// maxPerConn is how many messages go over one connection before it is
+// replaced; providers such as Gmail drop long-lived sessions.
+const maxPerConn = 50
+
+// Message is one multipart/alternative email.
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+	BCC     []string // extra envelope recipients, never shown in headers
+}
+
+// SendBatch delivers msgs over at most Concurrency connections (shared with
+// every other send on m), reusing each connection for up to maxPerConn
+// messages. A failed message does not stop the batch; the returned error
+// joins one error per failed recipient.
+func (m *Mailer) SendBatch(msgs []Message) error {
+	if !m.Enabled() || len(msgs) == 0 {
+		return nil
+	}
+	m.poolOnce.Do(func() {
+		n := m.Concurrency
+		if n <= 0 {
+			n = DefaultConcurrency
+		}
+		m.slots = make(chan struct{}, n)
+	})
+
+	queue := make(chan Message)
+	go func() {
+		for _, msg := range msgs {
+			queue <- msg
+		}
+		close(queue)
+	}()
+
+	var (
+		mu   sync.Mutex
+		errs []error
+		wg   sync.WaitGroup
+	)
+	workers := min(cap(m.slots), len(msgs))
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m.slots <- struct{}{}
+			defer func() { <-m.slots }()
+
+			var c *smtp.Client
+			sent := 0
+			defer func() {
+				if c != nil {
+					c.Quit()
+				}
+			}()
+			for msg := range queue {
+				if c != nil && sent >= maxPerConn {
+					c.Quit()
+					c = nil
+				}
+				if c == nil {
+					var err error
+					if c, err = m.dial(); err != nil {
+						mu.Lock()
+						errs = append(errs, fmt.Errorf("%s: %w", msg.To, err))
+						mu.Unlock()
+						continue
+					}
+					sent = 0
+				}
+				if err := m.deliver(c, msg); err != nil {
+					mu.Lock()
+					errs = append(errs, fmt.Errorf("%s: %w", msg.To, err))
+					mu.Unlock()
+					// The session state is unknown after a failure; start over.
+					c.Close()
+					c = nil
+					continue
+				}
+				sent++
+			}
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// dial connects, upgrades to TLS when offered and authenticates.
+func (m *Mailer) dial() (*smtp.Client, error) {
+	addr := net.JoinHostPort(m.Host, strconv.Itoa(m.Port))
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("smtp dial: %w", err)
+	}
+
+	client, err := smtp.NewClient(conn, m.Host)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("smtp client: %w", err)
+	}
+
+	// STARTTLS
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		tlsConfig := &tls.Config{ServerName: m.Host}
+		if err := client.StartTLS(tlsConfig); err != nil {
+			slog.Warn("smtp starttls failed, continuing without", "error", err)
+		}
+	}
+
+	// Auth
+	if m.User != "" {
+		auth := smtp.PlainAuth("", m.User, m.Pass, m.Host)
+		if err := client.Auth(auth); err != nil {
+			client.Close()
+			return nil, fmt.Errorf("smtp auth: %w", err)
+		}
+	}
+	return client, nil
+}
+
+// deliver sends one message on an open connection and resets the session
+// for the next one.
+func (m *Mailer) deliver(client *smtp.Client, msg Message) error {
+	if err := client.Mail(m.envelopeFrom()); err != nil {
+		return fmt.Errorf("smtp mail from: %w", err)
+	}
+	if err := client.Rcpt(msg.To); err != nil {
+		return fmt.Errorf("smtp rcpt to: %w", err)
+	}
+	for _, bcc := range msg.BCC {
+		if err := client.Rcpt(bcc); err != nil {
+			return fmt.Errorf("smtp rcpt to (bcc): %w", err)
+		}
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("smtp data: %w", err)
+	}
+	if _, err := w.Write(m.buildMessage(msg)); err != nil {
+		return fmt.Errorf("smtp write: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("smtp close: %w", err)
+	}
+	return client.Reset()
+}
+
+func (m *Mailer) buildMessage(msg Message) []byte {
+	boundary := "----=_Part_downloadonce_boundary"
+
+	headers := []string{
+		fmt.Sprintf("From: %s", m.From),
+		fmt.Sprintf("To: %s", msg.To),
+		fmt.Sprintf("Subject: %s", msg.Subject),
+		"MIME-Version: 1.0",
+		fmt.Sprintf(`Content-Type: multipart/alternative; boundary="%s"`, boundary),
+	}
+	if m.ReplyTo != "" {
+		headers = append(headers, fmt.Sprintf("Reply-To: %s", m.ReplyTo))
+	}
+
+	body := strings.Join(headers, "\r\n") + "\r\n\r\n"
+	body += "--" + boundary + "\r\n"
+	body += "Content-Type: text/plain; charset=utf-8\r\n\r\n"
+	body += msg.Text + "\r\n"
+	body += "--" + boundary + "\r\n"
+	body += "Content-Type: text/html; charset=utf-8\r\n\r\n"
+	body += msg.HTML + "\r\n"
+	body += "--" + boundary + "--\r\n"
+	return []byte(body)
+}
+
+// envelopeFrom is the MAIL FROM (return-path) address: EnvelopeFrom when
+// set, otherwise the address part of From.
+func (m *Mailer) envelopeFrom() string {
+	if m.EnvelopeFrom != "" {
+		return m.EnvelopeFrom
+	}
+	if a, err := mail.ParseAddress(m.From); err == nil {
+		return a.Address
+	}
+	return m.From
+}
+
+// Validate checks the configured sender, reply-to, envelope and BCC
+// addresses. From and ReplyTo may carry a display name ("Acme <x@acme.io>");
+// EnvelopeFrom and BCC must be bare addresses.
+func (m *Mailer) Validate() error {
+	if m.From != "" {
+		if _, err := mail.ParseAddress(m.From); err != nil {
+			return fmt.Errorf("SMTP_FROM %q: %w", m.From, err)
+		}
+	}
+	if m.ReplyTo != "" {
+		if _, err := mail.ParseAddress(m.ReplyTo); err != nil {
+			return fmt.Errorf("SMTP_REPLY_TO %q: %w", m.ReplyTo, err)
+		}
+	}
+	if m.EnvelopeFrom != "" && !isBareAddress(m.EnvelopeFrom) {
+		return fmt.Errorf("SMTP_ENVELOPE_FROM %q: not a plain email address", m.EnvelopeFrom)
+	}
+	for _, b := range m.BCC {
+		if !isBareAddress(b) {
+			return fmt.Errorf("SMTP_BCC %q: not a plain email address", b)
+		}
+	}
+	return nil
+}
+
+func isBareAddress(s string) bool {
+	a, err := mail.ParseAddress(s)
+	return err == nil && a.Address == s
+}
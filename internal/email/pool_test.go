@@ -0,0 +1,253 @@
+package email
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// fakeSMTP is a minimal SMTP server that counts connections and messages.
+// Recipients containing "reject" get a 550 on RCPT.
+type fakeSMTP struct {
+	ln       net.Listener
+	conns    atomic.Int32
+	open     atomic.Int32
+	maxOpen  atomic.Int32
+	mu       sync.Mutex
+	received []fakeMessage
+}
+
+type fakeMessage struct {
+	from  string
+	rcpts []string
+	data  string
+}
+
+func newFakeSMTP(t *testing.T) *fakeSMTP {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeSMTP{ln: ln}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(c)
+		}
+	}()
+	return s
+}
+
+func (s *fakeSMTP) serve(c net.Conn) {
+	defer c.Close()
+	s.conns.Add(1)
+	n := s.open.Add(1)
+	closed := false
+	defer func() {
+		if !closed {
+			s.open.Add(-1)
+		}
+	}()
+	for {
+		cur := s.maxOpen.Load()
+		if n <= cur || s.maxOpen.CompareAndSwap(cur, n) {
+			break
+		}
+	}
+
+	r := bufio.NewReader(c)
+	reply := func(line string) { fmt.Fprintf(c, "%s\r\n", line) }
+	reply("220 fake ESMTP")
+	var cur fakeMessage
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250 fake")
+		case strings.HasPrefix(cmd, "MAIL"):
+			cur = fakeMessage{from: strings.TrimSpace(line)}
+			reply("250 ok")
+		case strings.HasPrefix(cmd, "RSET"):
+			reply("250 ok")
+		case strings.HasPrefix(cmd, "RCPT"):
+			if strings.Contains(cmd, "REJECT") {
+				reply("550 no such user")
+			} else {
+				cur.rcpts = append(cur.rcpts, strings.TrimSpace(line))
+				reply("250 ok")
+			}
+		case cmd == "DATA":
+			reply("354 go ahead")
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				cur.data += l
+			}
+			s.mu.Lock()
+			s.received = append(s.received, cur)
+			s.mu.Unlock()
+			reply("250 queued")
+		case cmd == "QUIT":
+			// Count the session as closed before the client sees the reply.
+			s.open.Add(-1)
+			closed = true
+			reply("221 bye")
+			return
+		default:
+			reply("502 unknown")
+		}
+	}
+}
+
+func (s *fakeSMTP) mailer(concurrency int) *Mailer {
+	addr := s.ln.Addr().(*net.TCPAddr)
+	return &Mailer{Host: "127.0.0.1", Port: addr.Port, From: "noreply@example.com", Concurrency: concurrency}
+}
+
+func TestSendBatchReusesConnections(t *testing.T) {
+	s := newFakeSMTP(t)
+	m := s.mailer(2)
+
+	var msgs []Message
+	for i := 0; i < 30; i++ {
+		msgs = append(msgs, Message{To: fmt.Sprintf("r%d@example.com", i), Subject: "s", Text: "t", HTML: "<p>h</p>"})
+	}
+	if err := m.SendBatch(msgs); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(s.received); got != 30 {
+		t.Errorf("received %d messages, want 30", got)
+	}
+	if got := s.conns.Load(); got > 2 {
+		t.Errorf("opened %d connections, want at most 2", got)
+	}
+	if got := s.maxOpen.Load(); got > 2 {
+		t.Errorf("%d connections open at once, want at most 2", got)
+	}
+}
+
+func TestSendBatchReportsFailedRecipients(t *testing.T) {
+	s := newFakeSMTP(t)
+	m := s.mailer(1)
+
+	err := m.SendBatch([]Message{
+		{To: "a@example.com"},
+		{To: "reject@example.com"},
+		{To: "b@example.com"},
+	})
+	if err == nil || !strings.Contains(err.Error(), "reject@example.com") {
+		t.Fatalf("err = %v, want failure for reject@example.com", err)
+	}
+	if strings.Contains(err.Error(), "a@example.com") || strings.Contains(err.Error(), "b@example.com") {
+		t.Errorf("err names delivered recipients: %v", err)
+	}
+	if got := len(s.received); got != 2 {
+		t.Errorf("received %d messages, want 2", got)
+	}
+	// The failed session is dropped and a new one opened for the rest.
+	if got := s.conns.Load(); got != 2 {
+		t.Errorf("opened %d connections, want 2", got)
+	}
+}
+
+func TestSendBatchSharesCapAcrossCalls(t *testing.T) {
+	s := newFakeSMTP(t)
+	m := s.mailer(1)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := m.sendMultipart(fmt.Sprintf("r%d@example.com", i), "s", "t", "h"); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if got := s.maxOpen.Load(); got != 1 {
+		t.Errorf("%d connections open at once, want 1", got)
+	}
+}
+
+func TestSendBatchEnvelopeAndHeaders(t *testing.T) {
+	s := newFakeSMTP(t)
+	m := s.mailer(1)
+	m.From = "Acme <noreply@acme.test>"
+	m.ReplyTo = "support@acme.test"
+	m.BCC = []string{"archive@acme.test"}
+
+	msg, err := m.DownloadLinkMessage("ann@example.com", NewLinkEmail("Ann", "Camp", "https://dl.test/d/x", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SendBatch([]Message{msg}); err != nil {
+		t.Fatal(err)
+	}
+	// Other mail (password resets etc.) is not archived.
+	if err := m.SendPasswordReset("bob@example.com", "Bob", "https://dl.test/reset"); err != nil {
+		t.Fatal(err)
+	}
+	m.EnvelopeFrom = "bounces@acme.test"
+	if err := m.SendBatch([]Message{{To: "cy@example.com"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(s.received) != 3 {
+		t.Fatalf("received %d messages, want 3", len(s.received))
+	}
+	link, reset, bounced := s.received[0], s.received[1], s.received[2]
+	if !strings.Contains(link.from, "<noreply@acme.test>") {
+		t.Errorf("MAIL FROM = %q, want the address part of From", link.from)
+	}
+	if len(link.rcpts) != 2 || !strings.Contains(link.rcpts[1], "archive@acme.test") {
+		t.Errorf("rcpts = %v, want recipient then BCC", link.rcpts)
+	}
+	if strings.Contains(link.data, "archive@acme.test") {
+		t.Error("BCC address leaked into the message headers")
+	}
+	if !strings.Contains(link.data, "Reply-To: support@acme.test\r\n") {
+		t.Errorf("missing Reply-To header:\n%s", link.data)
+	}
+	if len(reset.rcpts) != 1 {
+		t.Errorf("password reset rcpts = %v, want no BCC", reset.rcpts)
+	}
+	if !strings.Contains(bounced.from, "<bounces@acme.test>") || !strings.Contains(bounced.data, "From: Acme <noreply@acme.test>") {
+		t.Errorf("envelope %q / headers %q: want distinct envelope and header From", bounced.from, bounced.data)
+	}
+}
+
+func TestMailerValidate(t *testing.T) {
+	ok := &Mailer{From: "Acme <noreply@acme.test>", ReplyTo: "Support <s@acme.test>", EnvelopeFrom: "b@acme.test", BCC: []string{"a@acme.test"}}
+	if err := ok.Validate(); err != nil {
+		t.Errorf("valid config: %v", err)
+	}
+	for _, m := range []*Mailer{
+		{From: "not an address"},
+		{ReplyTo: "support@"},
+		{EnvelopeFrom: "Bounces <b@acme.test>"},
+		{BCC: []string{"a@acme.test", "bad"}},
+	} {
+		if err := m.Validate(); err == nil {
+			t.Errorf("%+v: expected error", m)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package email
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDefaultTemplatesRender(t *testing.T) {
+	exp := time.Date(2026, 3, 1, 12, 30, 0, 0, time.UTC)
+	subject, text, html, err := DefaultTemplates().RenderDownloadLink(
+		NewLinkEmail("Ann <a@x>", "Q1 & Q2", "https://dl.test/d/abc", &exp))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subject != "Your download link for Q1 & Q2" {
+		t.Errorf("subject = %q", subject)
+	}
+	if !strings.Contains(text, "Hello Ann <a@x>,") || !strings.Contains(text, "expires on 2026-03-01 12:30 UTC") {
+		t.Errorf("text body:\n%s", text)
+	}
+	if !strings.Contains(html, "Ann &lt;a@x&gt;") || !strings.Contains(html, `href="https://dl.test/d/abc"`) {
+		t.Errorf("html body not escaped as expected:\n%s", html)
+	}
+
+	_, text, _, _ = DefaultTemplates().RenderDownloadLink(NewLinkEmail("Ann", "C", "u", nil))
+	if strings.Contains(text, "expires") {
+		t.Errorf("text mentions expiry without one:\n%s", text)
+	}
+}
+
+func TestLoadTemplatesOverrides(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, LinkSubjectFile), []byte("{{.CampaignName}}\nfor {{.RecipientName}}\n"), 0o644)
+	os.WriteFile(filepath.Join(dir, LinkHTMLFile), []byte(`<p>{{.RecipientName}}</p>`), 0o644)
+
+	tm, err := LoadTemplates(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tm.Overridden) != 2 {
+		t.Errorf("Overridden = %v", tm.Overridden)
+	}
+	subject, text, html, err := tm.RenderDownloadLink(NewLinkEmail("<b>", "Camp", "u", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subject != "Camp for <b>" {
+		t.Errorf("subject = %q", subject)
+	}
+	if !strings.Contains(text, "Download link: u") {
+		t.Errorf("text should fall back to the built-in:\n%s", text)
+	}
+	if html != "<p>&lt;b&gt;</p>" {
+		t.Errorf("html = %q", html)
+	}
+}
+
+func TestLoadTemplatesErrors(t *testing.T) {
+	if _, err := LoadTemplates(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("missing dir: expected error")
+	}
+
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, LinkTextFile), []byte("{{.Nope}}"), 0o644)
+	if _, err := LoadTemplates(dir); err == nil || !strings.Contains(err.Error(), LinkTextFile) {
+		t.Errorf("unknown field: err = %v", err)
+	}
+
+	os.WriteFile(filepath.Join(dir, LinkTextFile), []byte("{{.RecipientName"), 0o644)
+	if _, err := LoadTemplates(dir); err == nil {
+		t.Error("parse error: expected error")
+	}
+}
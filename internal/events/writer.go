@@ -0,0 +1,131 @@
+// Package events buffers download-event inserts so that concurrent downloads
+// do not each contend for the SQLite write lock.
+package events
+
+import (
+	"database/sql"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/YannKr/downloadonce/internal/db"
+	"github.com/YannKr/downloadonce/internal/model"
+)
+
+const (
+	defaultBufferSize    = 1024
+	defaultBatchSize     = 100
+	defaultFlushInterval = time.Second
+)
+
+// Writer persists download events from a single goroutine in batches.
+// Record never drops an event: when the buffer is full or the writer has been
+// stopped, the event is inserted synchronously instead.
+type Writer struct {
+	db            *sql.DB
+	batchSize     int
+	flushInterval time.Duration
+
+	mu     sync.RWMutex // guards closed and sends on ch
+	closed bool
+	ch     chan *model.DownloadEvent
+	done   chan struct{}
+}
+
+// NewWriter creates a Writer with room for bufferSize queued events
+// (0 uses a default). Call Start before Record and Stop on shutdown.
+func NewWriter(database *sql.DB, bufferSize int) *Writer {
+	if bufferSize <= 0 {
+		bufferSize = defaultBufferSize
+	}
+	return &Writer{
+		db:            database,
+		batchSize:     defaultBatchSize,
+		flushInterval: defaultFlushInterval,
+		ch:            make(chan *model.DownloadEvent, bufferSize),
+		done:          make(chan struct{}),
+	}
+}
+
+// Start launches the writer goroutine.
+func (w *Writer) Start() {
+	go w.loop()
+}
+
+// Stop flushes all queued events and waits for the writer goroutine to exit.
+// Events recorded afterwards are written synchronously.
+func (w *Writer) Stop() {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return
+	}
+	w.closed = true
+	close(w.ch)
+	w.mu.Unlock()
+	<-w.done
+}
+
+// Record queues e for insertion. The download time is taken now rather than
+// when the batch is written.
+func (w *Writer) Record(e *model.DownloadEvent) {
+	if e.CreatedAt.IsZero() {
+		e.CreatedAt = time.Now()
+	}
+
+	w.mu.RLock()
+	if !w.closed {
+		select {
+		case w.ch <- e:
+			w.mu.RUnlock()
+			return
+		default:
+		}
+	}
+	w.mu.RUnlock()
+
+	if err := db.InsertDownloadEvent(w.db, e); err != nil {
+		slog.Error("insert download event", "error", err, "token", e.TokenID)
+	}
+}
+
+func (w *Writer) loop() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(w.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*model.DownloadEvent, 0, w.batchSize)
+	for {
+		select {
+		case e, ok := <-w.ch:
+			if !ok {
+				w.flush(batch)
+				return
+			}
+			batch = append(batch, e)
+			if len(batch) >= w.batchSize {
+				w.flush(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			w.flush(batch)
+			batch = batch[:0]
+		}
+	}
+}
+
+func (w *Writer) flush(batch []*model.DownloadEvent) {
+	if len(batch) == 0 {
+		return
+	}
+	if err := db.InsertDownloadEvents(w.db, batch); err != nil {
+		// Fall back to row-by-row so one bad row does not lose the batch.
+		slog.Error("insert download event batch", "error", err, "events", len(batch))
+		for _, e := range batch {
+			if err := db.InsertDownloadEvent(w.db, e); err != nil {
+				slog.Error("insert download event", "error", err, "token", e.TokenID)
+			}
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package events
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	downloadonce "github.com/YannKr/downloadonce"
+	"github.com/YannKr/downloadonce/internal/db"
+	"github.com/YannKr/downloadonce/internal/model"
+)
+
+func TestWriterPersistsEvents(t *testing.T) {
+	database, err := db.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { database.Close() })
+	if err := db.Migrate(database, downloadonce.MigrationFS); err != nil {
+		t.Fatal(err)
+	}
+
+	must := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	must(db.CreateAccount(database, &model.Account{ID: "acc", Email: "a@example.com", Name: "a", PasswordHash: "x", Role: "admin", Enabled: true}))
+	must(db.CreateAsset(database, &model.Asset{ID: "asset", AccountID: "acc", OriginalName: "in.png", AssetType: "image", OriginalPath: "originals/asset.png", FileSize: 1, SHA256: "00", MimeType: "image/png"}))
+	must(db.CreateCampaign(database, &model.Campaign{ID: "camp", AccountID: "acc", AssetID: "asset", Name: "camp", State: "READY"}))
+	must(db.CreateRecipient(database, &model.Recipient{ID: "rec", AccountID: "acc", Name: "r", Email: "r@example.com"}))
+	must(db.CreateToken(database, &model.DownloadToken{ID: "tok", CampaignID: "camp", RecipientID: "rec", State: "ACTIVE"}))
+
+	// A tiny buffer forces some events down the synchronous overflow path.
+	w := NewWriter(database, 4)
+	w.flushInterval = 10 * time.Millisecond
+	w.Start()
+
+	const n = 50
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			w.Record(&model.DownloadEvent{
+				ID: fmt.Sprintf("ev-%d", i), TokenID: "tok", CampaignID: "camp", RecipientID: "rec", AssetID: "asset",
+			})
+		}(i)
+	}
+	wg.Wait()
+
+	// Events are written in the background without waiting for Stop.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		events, err := db.ListDownloadEventsByToken(database, "tok")
+		must(err)
+		if len(events) == n {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("only %d of %d events persisted", len(events), n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Stop drains the queue; later events are still written synchronously.
+	w.Record(&model.DownloadEvent{ID: "queued", TokenID: "tok", CampaignID: "camp", RecipientID: "rec", AssetID: "asset"})
+	w.Stop()
+	w.Record(&model.DownloadEvent{ID: "late", TokenID: "tok", CampaignID: "camp", RecipientID: "rec", AssetID: "asset"})
+	events, err := db.ListDownloadEventsByToken(database, "tok")
+	must(err)
+	if len(events) != n+2 {
+		t.Errorf("got %d events after stop, want %d", len(events), n+2)
+	}
+}
@@ -0,0 +1,49 @@
+// Package geoip resolves IP addresses to an approximate country and city
+// using a MaxMind GeoLite2/GeoIP2 City database.
+package geoip
+
+import (
+	"net"
+
+	"github.com/oschwald/geoip2-golang"
+)
+
+// Reader looks up locations. A nil *Reader is valid and resolves nothing,
+// so callers don't need to check whether GeoIP is configured.
+type Reader struct {
+	db *geoip2.Reader
+}
+
+// Open loads the .mmdb file at path.
+func Open(path string) (*Reader, error) {
+	db, err := geoip2.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Reader{db: db}, nil
+}
+
+// Close releases the underlying database.
+func (r *Reader) Close() error {
+	if r == nil {
+		return nil
+	}
+	return r.db.Close()
+}
+
+// Lookup returns the ISO country code and English city name for ip. Either
+// may be empty when unknown, when ip is unparseable, or when r is nil.
+func (r *Reader) Lookup(ip string) (country, city string) {
+	if r == nil {
+		return "", ""
+	}
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return "", ""
+	}
+	rec, err := r.db.City(parsed)
+	if err != nil {
+		return "", ""
+	}
+	return rec.Country.IsoCode, rec.City.Names["en"]
+}
@@ -12,23 +12,28 @@ import (
 	"github.com/YannKr/downloadonce/internal/auth"
 	"github.com/YannKr/downloadonce/internal/db"
 	"github.com/YannKr/downloadonce/internal/model"
+	"github.com/YannKr/downloadonce/internal/watermark"
 )
 
 type adminUsersData struct {
-	Users             []model.Account
-	AllowRegistration bool
+	Users               []model.Account
+	AllowRegistration   bool
+	DefaultMaxCampaigns int
+	DefaultUploadTypes  string // ALLOWED_UPLOAD_TYPES; empty allows every supported type
 }
 
 func (h *Handler) AdminUsers(w http.ResponseWriter, r *http.Request) {
 	users, err := db.ListAccounts(h.DB)
 	if err != nil {
-		slog.Error("list accounts", "error", err)
+		slog.ErrorContext(r.Context(), "list accounts", "error", err)
 		http.Error(w, "Internal error", 500)
 		return
 	}
 	h.renderAuth(w, r, "admin_users.html", "Users", adminUsersData{
-		Users:             users,
-		AllowRegistration: h.Cfg.AllowRegistration,
+		Users:               users,
+		AllowRegistration:   h.Cfg.AllowRegistration,
+		DefaultMaxCampaigns: h.Cfg.MaxCampaignsPerAccount,
+		DefaultUploadTypes:  h.Cfg.AllowedUploadTypes,
 	})
 }
 
@@ -40,7 +45,7 @@ func (h *Handler) AdminCreateUser(w http.ResponseWriter, r *http.Request) {
 
 	if name == "" || email == "" || password == "" {
 		users, _ := db.ListAccounts(h.DB)
-		h.renderAuth(w, r, "admin_users.html", "Users", adminUsersData{Users: users})
+		h.renderAuth(w, r, "admin_users.html", "Users", adminUsersData{Users: users, DefaultMaxCampaigns: h.Cfg.MaxCampaignsPerAccount})
 		return
 	}
 	if role != "admin" && role != "member" {
@@ -53,7 +58,7 @@ func (h *Handler) AdminCreateUser(w http.ResponseWriter, r *http.Request) {
 		h.render(w, r, "admin_users.html", PageData{
 			Title: "Users", Authenticated: true, IsAdmin: true,
 			Error: "An account with this email already exists.",
-			Data:  adminUsersData{Users: users},
+			Data:  adminUsersData{Users: users, DefaultMaxCampaigns: h.Cfg.MaxCampaignsPerAccount},
 		})
 		return
 	}
@@ -78,7 +83,7 @@ func (h *Handler) AdminCreateUser(w http.ResponseWriter, r *http.Request) {
 	}
 
 	db.InsertAuditLog(h.DB, auth.AccountFromContext(r.Context()), "user_created", "account", account.ID, fmt.Sprintf("Created user %s (%s)", name, email), r.RemoteAddr)
-	setFlash(w, "User created successfully.")
+	h.setFlash(w, "User created successfully.")
 	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
 }
 
@@ -100,6 +105,7 @@ func (h *Handler) AdminToggleUser(w http.ResponseWriter, r *http.Request) {
 	db.UpdateAccountEnabled(h.DB, id, !account.Enabled)
 	if account.Enabled {
 		db.DeleteSessionsByAccount(h.DB, id)
+		h.revokeAPIKeys(r, accountID, id, "on account disable")
 	}
 
 	action := "user_enabled"
@@ -107,7 +113,7 @@ func (h *Handler) AdminToggleUser(w http.ResponseWriter, r *http.Request) {
 		action = "user_disabled"
 	}
 	db.InsertAuditLog(h.DB, accountID, action, "account", id, fmt.Sprintf("Toggled user %s", account.Email), r.RemoteAddr)
-	setFlash(w, "User status updated.")
+	h.setFlash(w, "User status updated.")
 	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
 }
 
@@ -123,7 +129,7 @@ func (h *Handler) AdminDeleteUser(w http.ResponseWriter, r *http.Request) {
 	db.DeleteSessionsByAccount(h.DB, id)
 	db.DeleteAccount(h.DB, id)
 	db.InsertAuditLog(h.DB, accountID, "user_deleted", "account", id, "", r.RemoteAddr)
-	setFlash(w, "User deleted.")
+	h.setFlash(w, "User deleted.")
 	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
 }
 
@@ -148,14 +154,80 @@ func (h *Handler) AdminPromoteUser(w http.ResponseWriter, r *http.Request) {
 	}
 	db.UpdateAccountRole(h.DB, id, newRole)
 	db.InsertAuditLog(h.DB, accountID, "user_promoted", "account", id, fmt.Sprintf("Role changed to %s for %s", newRole, account.Email), r.RemoteAddr)
-	setFlash(w, "User role updated.")
+	h.setFlash(w, "User role updated.")
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+// AdminSetCampaignLimit overrides MAX_CAMPAIGNS_PER_ACCOUNT for one user.
+// An empty value reverts to the global default; 0 removes the cap.
+func (h *Handler) AdminSetCampaignLimit(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	account, err := db.GetAccountByID(h.DB, id)
+	if err != nil || account == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	var limit *int
+	detail := "default"
+	if v := strings.TrimSpace(r.FormValue("max_campaigns")); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			h.setFlash(w, "Campaign limit must be a whole number (0 = unlimited).")
+			http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+			return
+		}
+		limit = &n
+		detail = v
+	}
+	if err := db.SetAccountMaxCampaigns(h.DB, id, limit); err != nil {
+		http.Error(w, "Internal error", 500)
+		return
+	}
+	db.InsertAuditLog(h.DB, auth.AccountFromContext(r.Context()), "user_campaign_limit", "account", id, fmt.Sprintf("Campaign limit for %s set to %s", account.Email, detail), r.RemoteAddr)
+	h.setFlash(w, "Campaign limit updated.")
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+// AdminSetUploadTypes overrides ALLOWED_UPLOAD_TYPES for one user, e.g.
+// "image" for an image-only plan. An empty value reverts to the global
+// default; "*" allows every supported type.
+func (h *Handler) AdminSetUploadTypes(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	account, err := db.GetAccountByID(h.DB, id)
+	if err != nil || account == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	var types *string
+	detail := "default"
+	if v := strings.TrimSpace(r.FormValue("upload_types")); v != "" {
+		parsed, err := watermark.ParseUploadTypes(v)
+		if err != nil {
+			h.setFlash(w, "Upload types: use MIME types such as image/png, or image, video or *.")
+			http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+			return
+		}
+		if v != "*" {
+			v = strings.Join(parsed, ",")
+		}
+		types = &v
+		detail = v
+	}
+	if err := db.SetAccountUploadTypes(h.DB, id, types); err != nil {
+		http.Error(w, "Internal error", 500)
+		return
+	}
+	db.InsertAuditLog(h.DB, auth.AccountFromContext(r.Context()), "user_upload_types", "account", id, fmt.Sprintf("Upload types for %s set to %s", account.Email, detail), r.RemoteAddr)
+	h.setFlash(w, "Upload types updated.")
 	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
 }
 
 func (h *Handler) AdminCampaigns(w http.ResponseWriter, r *http.Request) {
 	campaigns, err := db.ListCampaigns(h.DB, "", true, false)
 	if err != nil {
-		slog.Error("list all campaigns", "error", err)
+		slog.ErrorContext(r.Context(), "list all campaigns", "error", err)
 		http.Error(w, "Internal error", 500)
 		return
 	}
@@ -165,6 +237,8 @@ func (h *Handler) AdminCampaigns(w http.ResponseWriter, r *http.Request) {
 type auditPageData struct {
 	Logs         []db.AuditLog
 	FilterAction string
+	Start        string
+	End          string
 	Actions      []string
 	Pagination   *PaginationData
 }
@@ -178,17 +252,16 @@ type PaginationData struct {
 	NextPage   int
 }
 
-func (h *Handler) AdminAudit(w http.ResponseWriter, r *http.Request) {
-	filterAction := r.URL.Query().Get("action")
+// pageOf reads the page query parameter, clamps it to the pages total items
+// fill at perPage, and returns the page with its controls (nil when
+// everything fits on one page).
+func pageOf(r *http.Request, total, perPage int) (int, *PaginationData) {
 	page := 1
 	if p := r.URL.Query().Get("page"); p != "" {
 		if n, err := strconv.Atoi(p); err == nil && n > 0 {
 			page = n
 		}
 	}
-
-	perPage := 50
-	total, _ := db.CountAuditLogs(h.DB, filterAction)
 	totalPages := (total + perPage - 1) / perPage
 	if totalPages < 1 {
 		totalPages = 1
@@ -196,39 +269,43 @@ func (h *Handler) AdminAudit(w http.ResponseWriter, r *http.Request) {
 	if page > totalPages {
 		page = totalPages
 	}
-	offset := (page - 1) * perPage
+	if total <= perPage {
+		return page, nil
+	}
+	return page, &PaginationData{
+		Page:       page,
+		TotalPages: totalPages,
+		HasPrev:    page > 1,
+		HasNext:    page < totalPages,
+		PrevPage:   page - 1,
+		NextPage:   page + 1,
+	}
+}
 
-	logs, err := db.ListAuditLogs(h.DB, perPage, offset, filterAction)
+func (h *Handler) AdminAudit(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseAuditFilter(r)
 	if err != nil {
-		slog.Error("list audit logs", "error", err)
-		http.Error(w, "Internal error", 500)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	perPage := 50
+	total, _ := db.CountAuditLogs(h.DB, filter)
+	page, pagination := pageOf(r, total, perPage)
+	offset := (page - 1) * perPage
 
-	actions := []string{
-		"login", "logout", "user_created", "user_deleted", "user_promoted",
-		"user_enabled", "user_disabled", "campaign_created", "campaign_published",
-		"token_revoked", "asset_deleted", "recipient_deleted", "recipient_created",
-		"api_key_created", "api_key_deleted", "webhook_created", "webhook_deleted",
-		"password_reset_requested", "password_changed",
-	}
-
-	var pagination *PaginationData
-	if total > perPage {
-		pagination = &PaginationData{
-			Page:       page,
-			TotalPages: totalPages,
-			HasPrev:    page > 1,
-			HasNext:    page < totalPages,
-			PrevPage:   page - 1,
-			NextPage:   page + 1,
-		}
+	logs, err := db.ListAuditLogs(h.DB, perPage, offset, filter)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "list audit logs", "error", err)
+		http.Error(w, "Internal error", 500)
+		return
 	}
 
 	h.renderAuth(w, r, "admin_audit.html", "Audit Log", auditPageData{
 		Logs:         logs,
-		FilterAction: filterAction,
-		Actions:      actions,
+		FilterAction: filter.Action,
+		Start:        filter.Start,
+		End:          filter.End,
+		Actions:      db.AuditActions,
 		Pagination:   pagination,
 	})
 }
@@ -0,0 +1,143 @@
+package handler
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/YannKr/downloadonce/internal/db"
+)
+
+// adminSearchLimit caps each group of admin search results.
+const adminSearchLimit = 25
+
+// adminSearchMinLen is the shortest query admin search runs; shorter ones
+// would match most of the database.
+const adminSearchMinLen = 2
+
+type adminSearchPageData struct {
+	Query   string
+	Results *db.SearchResults
+	Limit   int
+	Error   string
+}
+
+// AdminSearch - GET /admin/search?q=
+//
+// Finds campaigns, recipients and assets across every account.
+func (h *Handler) AdminSearch(w http.ResponseWriter, r *http.Request) {
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	data := adminSearchPageData{Query: q, Limit: adminSearchLimit}
+	if q != "" && utf8.RuneCountInString(q) < adminSearchMinLen {
+		data.Error = "Enter at least 2 characters to search."
+	} else if q != "" {
+		res, err := db.AdminSearch(h.DB, q, adminSearchLimit)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "admin search", "error", err)
+			http.Error(w, "Internal error", 500)
+			return
+		}
+		data.Results = res
+	}
+	h.renderAuth(w, r, "admin_search.html", "Search", data)
+}
+
+type apiSearchCampaignRef struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	State      string `json:"state"`
+	TokenID    string `json:"token_id,omitempty"`
+	TokenState string `json:"token_state,omitempty"`
+}
+
+type apiSearchCampaign struct {
+	ID         string    `json:"id"`
+	Name       string    `json:"name"`
+	State      string    `json:"state"`
+	OwnerEmail string    `json:"owner_email"`
+	AssetName  string    `json:"asset_name"`
+	CreatedAt  time.Time `json:"created_at"`
+	Deleted    bool      `json:"deleted"`
+	URL        string    `json:"url"`
+}
+
+type apiSearchRecipient struct {
+	ID         string                 `json:"id"`
+	Name       string                 `json:"name"`
+	Email      string                 `json:"email"`
+	Org        string                 `json:"org"`
+	OwnerEmail string                 `json:"owner_email"`
+	Campaigns  []apiSearchCampaignRef `json:"campaigns"`
+}
+
+type apiSearchAsset struct {
+	ID           string                 `json:"id"`
+	OriginalName string                 `json:"original_name"`
+	AssetType    string                 `json:"asset_type"`
+	OwnerEmail   string                 `json:"owner_email"`
+	CreatedAt    time.Time              `json:"created_at"`
+	Deleted      bool                   `json:"deleted"`
+	Campaigns    []apiSearchCampaignRef `json:"campaigns"`
+}
+
+func toAPISearchRefs(refs []db.SearchCampaignRef) []apiSearchCampaignRef {
+	out := make([]apiSearchCampaignRef, 0, len(refs))
+	for _, c := range refs {
+		out = append(out, apiSearchCampaignRef{ID: c.ID, Name: c.Name, State: c.State, TokenID: c.TokenID, TokenState: c.TokenState})
+	}
+	return out
+}
+
+// APIAdminSearch - GET /api/v1/admin/search?q=
+//
+// The JSON form of the admin search page: campaigns by name, recipients by
+// name, email or org, and assets by original name, each group capped at 25.
+func (h *Handler) APIAdminSearch(w http.ResponseWriter, r *http.Request) {
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if utf8.RuneCountInString(q) < adminSearchMinLen {
+		renderJSONError(w, http.StatusBadRequest, "BAD_REQUEST", "q must be at least 2 characters")
+		return
+	}
+	res, err := db.AdminSearch(h.DB, q, adminSearchLimit)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "api admin search", "error", err)
+		renderJSONError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "search failed")
+		return
+	}
+
+	out := struct {
+		Query      string               `json:"query"`
+		Limit      int                  `json:"limit"`
+		Campaigns  []apiSearchCampaign  `json:"campaigns"`
+		Recipients []apiSearchRecipient `json:"recipients"`
+		Assets     []apiSearchAsset     `json:"assets"`
+	}{
+		Query:      q,
+		Limit:      adminSearchLimit,
+		Campaigns:  []apiSearchCampaign{},
+		Recipients: []apiSearchRecipient{},
+		Assets:     []apiSearchAsset{},
+	}
+	for _, c := range res.Campaigns {
+		out.Campaigns = append(out.Campaigns, apiSearchCampaign{
+			ID: c.ID, Name: c.Name, State: c.State, OwnerEmail: c.OwnerEmail, AssetName: c.AssetName,
+			CreatedAt: c.CreatedAt, Deleted: c.Deleted, URL: h.Cfg.BaseURL + "/campaigns/" + c.ID,
+		})
+	}
+	for _, rc := range res.Recipients {
+		out.Recipients = append(out.Recipients, apiSearchRecipient{
+			ID: rc.ID, Name: rc.Name, Email: rc.Email, Org: rc.Org, OwnerEmail: rc.OwnerEmail,
+			Campaigns: toAPISearchRefs(rc.Campaigns),
+		})
+	}
+	for _, a := range res.Assets {
+		out.Assets = append(out.Assets, apiSearchAsset{
+			ID: a.ID, OriginalName: a.OriginalName, AssetType: a.AssetType, OwnerEmail: a.OwnerEmail,
+			CreatedAt: a.CreatedAt, Deleted: a.Deleted, Campaigns: toAPISearchRefs(a.Campaigns),
+		})
+	}
+
+	renderJSON(w, http.StatusOK, out)
+}
@@ -0,0 +1,56 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestAdminSearch(t *testing.T) {
+	h := newTestHandler(t)
+	seedAccount(t, h.DB, "admin", "admin")
+	seedAccount(t, h.DB, "member", "member")
+	seedCampaign(t, h.DB, "member", "launch", "READY", "alice")
+
+	r := chi.NewRouter()
+	r.With(h.RequireAdmin).Get("/admin/search", h.AdminSearch)
+	r.Get("/api/v1/admin/search", h.APIAdminSearch)
+	get := func(path, account, role string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, asAccount(httptest.NewRequest("GET", path, nil), account, role))
+		return rec
+	}
+
+	if rec := get("/admin/search?q=launch", "member", "member"); rec.Code != http.StatusForbidden {
+		t.Errorf("member: status = %d, want 403", rec.Code)
+	}
+	rec := get("/admin/search?q=alice", "admin", "admin")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("admin: status = %d", rec.Code)
+	}
+	if body := rec.Body.String(); !strings.Contains(body, `href="/campaigns/launch"`) || !strings.Contains(body, "alice@example.com") {
+		t.Errorf("page does not link alice's campaign:\n%s", body)
+	}
+
+	if rec := get("/api/v1/admin/search?q=a", "admin", "admin"); rec.Code != http.StatusBadRequest {
+		t.Errorf("short query: status = %d, want 400", rec.Code)
+	}
+	rec = get("/api/v1/admin/search?q=laun", "admin", "admin")
+	var got struct {
+		Campaigns []struct {
+			ID  string `json:"id"`
+			URL string `json:"url"`
+		} `json:"campaigns"`
+		Recipients []json.RawMessage `json:"recipients"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Campaigns) != 1 || got.Campaigns[0].URL != "http://dl.test/campaigns/launch" || got.Recipients == nil {
+		t.Errorf("api result = %+v", got)
+	}
+}
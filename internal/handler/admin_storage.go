@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+
+	"github.com/YannKr/downloadonce/internal/diskstat"
 )
 
 type storagePageData struct {
@@ -77,3 +79,27 @@ func diskWarnMsg(level int, pctFree float64) string {
 		return ""
 	}
 }
+
+// diskSpaceProblem returns a user-facing reason why an operation that will
+// write about need bytes should be refused: the disk is at the block
+// threshold or has less than need free. It returns "" when the operation may
+// proceed, including when disk monitoring is off or has no sample yet.
+func (h *Handler) diskSpaceProblem(action string, need int64) string {
+	if h.DiskCache == nil {
+		return ""
+	}
+	stats := h.DiskCache.Get()
+	if stats.CapturedAt.IsZero() {
+		return ""
+	}
+	level := stats.WarningLevel(h.Cfg.DiskWarnYellowPct, h.Cfg.DiskWarnRedPct, h.Cfg.DiskWarnBlockPct)
+	if level == diskstat.WarnBlock {
+		return fmt.Sprintf("Disk is nearly full (%.1f%% free); %s is blocked. It needs about %s.",
+			stats.PctFree(), action, formatBytes(need))
+	}
+	if need > 0 && uint64(need) > stats.FreeBytes {
+		return fmt.Sprintf("Not enough disk space for %s: it needs about %s but only %s is free.",
+			action, formatBytes(need), formatBytes(int64(stats.FreeBytes)))
+	}
+	return ""
+}
@@ -3,6 +3,7 @@ package handler
 import (
 	"encoding/csv"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"time"
 
@@ -59,19 +60,27 @@ func (h *Handler) AnalyticsExport(w http.ResponseWriter, r *http.Request) {
 		start = time.Now().AddDate(0, 0, -30).Format("2006-01-02")
 	}
 
-	events, err := db.ExportDownloadEvents(h.DB, accountID, start, end)
-	if err != nil {
-		http.Error(w, "Internal error", 500)
+	select {
+	case h.exportSlots <- struct{}{}:
+		defer func() { <-h.exportSlots }()
+	default:
+		w.Header().Set("Retry-After", "10")
+		http.Error(w, "Too many exports in progress, try again shortly", http.StatusTooManyRequests)
 		return
 	}
 
 	w.Header().Set("Content-Type", "text/csv")
 	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=downloads_%s_%s.csv", start, end))
 
+	// Rows are written as they are read; once the first page is out the
+	// status is committed, so a later error can only cut the file short.
 	writer := csv.NewWriter(w)
-	writer.Write([]string{"Campaign", "Recipient", "Email", "Downloaded At", "IP Address"})
-	for _, e := range events {
-		writer.Write([]string{e.CampaignName, e.RecipientName, e.RecipientEmail, e.DownloadedAt.Format("2006-01-02 15:04:05"), e.IPAddress})
-	}
+	writer.Write([]string{"Campaign", "Recipient", "Email", "Downloaded At", "IP Address", "Country", "City"})
+	err := db.ExportDownloadEvents(h.DB, accountID, start, end, func(e db.DownloadEvent) error {
+		return writer.Write([]string{e.CampaignName, e.RecipientName, e.RecipientEmail, e.DownloadedAt.Format("2006-01-02 15:04:05"), e.IPAddress, e.Country, e.City})
+	})
 	writer.Flush()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "analytics export", "account_id", accountID, "error", err)
+	}
 }
@@ -0,0 +1,54 @@
+package handler
+
+import (
+	"encoding/csv"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/YannKr/downloadonce/internal/db"
+	"github.com/YannKr/downloadonce/internal/model"
+)
+
+func TestAnalyticsExportCSV(t *testing.T) {
+	h := newTestHandler(t)
+	seedAccount(t, h.DB, "acc", "member")
+	toks := seedCampaign(t, h.DB, "acc", "camp", "READY", "r1")
+
+	defer func(old int) { db.ExportPageSize = old }(db.ExportPageSize)
+	db.ExportPageSize = 3
+	var events []*model.DownloadEvent
+	for _, id := range []string{"e1", "e2", "e3", "e4", "e5", "e6", "e7"} {
+		events = append(events, &model.DownloadEvent{ID: id, TokenID: toks[0], CampaignID: "camp", RecipientID: "r1", AssetID: "camp-asset"})
+	}
+	if err := db.InsertDownloadEvents(h.DB, events); err != nil {
+		t.Fatal(err)
+	}
+
+	export := func() *httptest.ResponseRecorder {
+		req := asAccount(httptest.NewRequest("GET", "/analytics/export", nil), "acc", "member")
+		rec := httptest.NewRecorder()
+		h.AnalyticsExport(rec, req)
+		return rec
+	}
+
+	rec := export()
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d", rec.Code)
+	}
+	rows, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 8 || rows[0][0] != "Campaign" || rows[1][0] != "camp" {
+		t.Errorf("got %d rows (want header + 7): %v", len(rows), rows)
+	}
+
+	// Every export slot taken: the next request is turned away.
+	for i := 0; i < cap(h.exportSlots); i++ {
+		h.exportSlots <- struct{}{}
+	}
+	if rec := export(); rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("busy export: status = %d, Retry-After = %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+}
@@ -0,0 +1,243 @@
+package handler
+
+import (
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/YannKr/downloadonce/internal/db"
+	"github.com/YannKr/downloadonce/internal/watermark"
+)
+
+// APIAdminWatermarkIndexExport - GET /api/v1/admin/watermark-index/export
+//
+// Streams the whole watermark_index as CSV (default) or JSON Lines
+// (?format=jsonl) so attribution data can be backed up independently of the
+// database.
+func (h *Handler) APIAdminWatermarkIndexExport(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	if format != "csv" && format != "jsonl" {
+		renderJSONError(w, http.StatusBadRequest, "BAD_REQUEST", "format must be csv or jsonl")
+		return
+	}
+
+	filename := fmt.Sprintf("watermark-index-%s.%s", time.Now().UTC().Format("20060102-150405"), format)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+
+	var err error
+	if format == "jsonl" {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		enc := json.NewEncoder(w)
+		err = db.EachWatermarkIndex(h.DB, func(e db.WatermarkIndexEntry) error {
+			return enc.Encode(e)
+		})
+	} else {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		wr := csv.NewWriter(w)
+		wr.Write(watermarkIndexCSVHeader)
+		err = db.EachWatermarkIndex(h.DB, func(e db.WatermarkIndexEntry) error {
+			return wr.Write([]string{
+				e.PayloadHex, e.TokenID, e.CampaignID, e.RecipientID, e.Algorithm,
+				e.CreatedAt.UTC().Format(time.RFC3339), formatSelfVerified(e.SelfVerified),
+				e.Channels, formatScale(e.Scale),
+			})
+		})
+		wr.Flush()
+	}
+	if err != nil {
+		// Headers are already sent; all we can do is log.
+		slog.ErrorContext(r.Context(), "export watermark index", "error", err)
+	}
+}
+
+var watermarkIndexCSVHeader = []string{"payload_hex", "token_id", "campaign_id", "recipient_id", "algorithm", "created_at", "self_verified", "wm_channels", "wm_scale"}
+
+// formatSelfVerified renders the self_verified CSV column; empty means the
+// self-verify did not run.
+func formatSelfVerified(v *bool) string {
+	if v == nil {
+		return ""
+	}
+	return strconv.FormatBool(*v)
+}
+
+// formatScale renders the wm_scale CSV column; empty when not recorded.
+func formatScale(v *float64) string {
+	if v == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*v, 'g', -1, 64)
+}
+
+// maxWatermarkIndexImportBytes bounds the request body of an index import.
+const maxWatermarkIndexImportBytes = 64 << 20
+
+// APIAdminWatermarkIndexImport - POST /api/v1/admin/watermark-index/import
+//
+// Accepts the export format (CSV with header, or JSON Lines when ?format=jsonl
+// or the Content-Type is application/x-ndjson). Existing payloads are never
+// overwritten. The whole file is validated before anything is written.
+func (h *Handler) APIAdminWatermarkIndexImport(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		ct := r.Header.Get("Content-Type")
+		if strings.Contains(ct, "ndjson") || strings.Contains(ct, "jsonl") {
+			format = "jsonl"
+		} else {
+			format = "csv"
+		}
+	}
+
+	body := http.MaxBytesReader(w, r.Body, maxWatermarkIndexImportBytes)
+	var entries []db.WatermarkIndexEntry
+	var err error
+	switch format {
+	case "csv":
+		entries, err = parseWatermarkIndexCSV(body)
+	case "jsonl":
+		entries, err = parseWatermarkIndexJSONL(body)
+	default:
+		renderJSONError(w, http.StatusBadRequest, "BAD_REQUEST", "format must be csv or jsonl")
+		return
+	}
+	if err != nil {
+		renderJSONError(w, http.StatusBadRequest, "BAD_REQUEST", err.Error())
+		return
+	}
+
+	inserted, err := db.ImportWatermarkIndex(h.DB, entries)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "import watermark index", "error", err)
+		renderJSONError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "failed to import watermark index")
+		return
+	}
+
+	renderJSON(w, http.StatusOK, map[string]int{
+		"inserted": inserted,
+		"skipped":  len(entries) - inserted,
+	})
+}
+
+func parseWatermarkIndexCSV(r io.Reader) ([]db.WatermarkIndexEntry, error) {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, errors.New("empty file")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read header: %w", err)
+	}
+	col := make(map[string]int, len(header))
+	for i, name := range header {
+		col[strings.TrimSpace(strings.ToLower(name))] = i
+	}
+	for _, required := range []string{"payload_hex", "token_id", "campaign_id", "recipient_id"} {
+		if _, ok := col[required]; !ok {
+			return nil, fmt.Errorf("missing column %q", required)
+		}
+	}
+	field := func(row []string, name string) string {
+		if i, ok := col[name]; ok && i < len(row) {
+			return strings.TrimSpace(row[i])
+		}
+		return ""
+	}
+
+	var entries []db.WatermarkIndexEntry
+	for line := 2; ; line++ {
+		row, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		e := db.WatermarkIndexEntry{
+			PayloadHex:  field(row, "payload_hex"),
+			TokenID:     field(row, "token_id"),
+			CampaignID:  field(row, "campaign_id"),
+			RecipientID: field(row, "recipient_id"),
+			Algorithm:   field(row, "algorithm"),
+		}
+		if ts := field(row, "created_at"); ts != "" {
+			t, err := time.Parse(time.RFC3339, ts)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid created_at", line)
+			}
+			e.CreatedAt = t
+		}
+		if v := field(row, "self_verified"); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid self_verified", line)
+			}
+			e.SelfVerified = &b
+		}
+		e.Channels = field(row, "wm_channels")
+		if v := field(row, "wm_scale"); v != "" {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid wm_scale", line)
+			}
+			e.Scale = &f
+		}
+		if err := normalizeWatermarkIndexEntry(&e); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+func parseWatermarkIndexJSONL(r io.Reader) ([]db.WatermarkIndexEntry, error) {
+	dec := json.NewDecoder(r)
+	var entries []db.WatermarkIndexEntry
+	for n := 1; ; n++ {
+		var e db.WatermarkIndexEntry
+		if err := dec.Decode(&e); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("record %d: %w", n, err)
+		}
+		if err := normalizeWatermarkIndexEntry(&e); err != nil {
+			return nil, fmt.Errorf("record %d: %w", n, err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// normalizeWatermarkIndexEntry validates an imported entry and fills in
+// defaults. Payloads must be exactly watermark.PayloadLength bytes of hex.
+func normalizeWatermarkIndexEntry(e *db.WatermarkIndexEntry) error {
+	e.PayloadHex = strings.ToLower(e.PayloadHex)
+	if b, err := hex.DecodeString(e.PayloadHex); err != nil || len(b) != watermark.PayloadLength {
+		return fmt.Errorf("payload_hex must be %d hex characters", watermark.PayloadLength*2)
+	}
+	if e.TokenID == "" || e.CampaignID == "" || e.RecipientID == "" {
+		return errors.New("token_id, campaign_id and recipient_id are required")
+	}
+	if e.Algorithm == "" {
+		e.Algorithm = "dwtDctSvd-python"
+	}
+	if (e.Channels == "") != (e.Scale == nil) {
+		return errors.New("wm_channels and wm_scale must be given together")
+	}
+	if e.Scale != nil {
+		if _, err := watermark.NewInvisibleParams(e.Channels, *e.Scale); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package handler
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/YannKr/downloadonce/internal/db"
+)
+
+func TestAPIAdminWatermarkIndexExport(t *testing.T) {
+	h := newTestHandler(t)
+	seedAccount(t, h.DB, "admin", "admin")
+	tokens := seedCampaign(t, h.DB, "admin", "camp", "READY", "r1", "r2")
+	payloads := []string{"aa01", "bb02"}
+	for i, tok := range tokens {
+		if err := db.InsertWatermarkIndex(h.DB, db.WatermarkIndexEntry{
+			PayloadHex: payloads[i], TokenID: tok, CampaignID: "camp", RecipientID: []string{"r1", "r2"}[i], Algorithm: "dwtDctSvd-go",
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("csv", func(t *testing.T) {
+		req := asAccount(httptest.NewRequest("GET", "/api/v1/admin/watermark-index/export", nil), "admin", "admin")
+		rec := httptest.NewRecorder()
+		h.APIAdminWatermarkIndexExport(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d", rec.Code)
+		}
+		records, err := csv.NewReader(rec.Body).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(records) != 3 {
+			t.Fatalf("got %d rows, want header + 2", len(records))
+		}
+		got := map[string][]string{}
+		for _, row := range records[1:] {
+			got[row[0]] = row
+		}
+		for i, p := range payloads {
+			row, ok := got[p]
+			if !ok {
+				t.Errorf("payload %s missing from export", p)
+				continue
+			}
+			if row[1] != tokens[i] || row[2] != "camp" || row[4] != "dwtDctSvd-go" {
+				t.Errorf("row for %s = %v", p, row)
+			}
+		}
+	})
+
+	t.Run("jsonl", func(t *testing.T) {
+		req := asAccount(httptest.NewRequest("GET", "/api/v1/admin/watermark-index/export?format=jsonl", nil), "admin", "admin")
+		rec := httptest.NewRecorder()
+		h.APIAdminWatermarkIndexExport(rec, req)
+		lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n")
+		if len(lines) != 2 {
+			t.Fatalf("got %d lines, want 2", len(lines))
+		}
+		var e db.WatermarkIndexEntry
+		if err := json.Unmarshal([]byte(lines[0]), &e); err != nil {
+			t.Fatal(err)
+		}
+		if e.CampaignID != "camp" || e.PayloadHex == "" {
+			t.Errorf("unexpected entry %+v", e)
+		}
+	})
+}
+
+func TestRequireAPIAdmin(t *testing.T) {
+	h := newTestHandler(t)
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusTeapot) })
+
+	rec := httptest.NewRecorder()
+	h.requireAPIAdmin(next).ServeHTTP(rec, asAccount(httptest.NewRequest("GET", "/", nil), "m", "member"))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("member: status = %d, want 403", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	h.requireAPIAdmin(next).ServeHTTP(rec, asAccount(httptest.NewRequest("GET", "/", nil), "a", "admin"))
+	if rec.Code != http.StatusTeapot {
+		t.Errorf("admin: status = %d, want pass-through", rec.Code)
+	}
+}
+
+func importWatermarkIndex(t *testing.T, h *Handler, contentType, body string) (int, map[string]int) {
+	t.Helper()
+	req := httptest.NewRequest("POST", "/api/v1/admin/watermark-index/import", strings.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	rec := httptest.NewRecorder()
+	h.APIAdminWatermarkIndexImport(rec, asAccount(req, "admin", "admin"))
+	var counts map[string]int
+	json.Unmarshal(rec.Body.Bytes(), &counts)
+	return rec.Code, counts
+}
+
+func TestAPIAdminWatermarkIndexImport(t *testing.T) {
+	h := newTestHandler(t)
+	p1 := strings.Repeat("a1", 16)
+	p2 := strings.Repeat("B2", 16)
+
+	csvBody := "payload_hex,token_id,campaign_id,recipient_id,algorithm,created_at\n" +
+		p1 + ",tok1,camp1,rec1,dwtDctSvd-go,2025-01-02T03:04:05Z\n" +
+		p2 + ",tok2,camp1,rec2,,\n"
+	code, counts := importWatermarkIndex(t, h, "text/csv", csvBody)
+	if code != http.StatusOK || counts["inserted"] != 2 || counts["skipped"] != 0 {
+		t.Fatalf("clean import: status %d counts %v", code, counts)
+	}
+
+	tokenID, campaignID, recipientID, err := db.LookupWatermarkIndex(h.DB, p1[4:20])
+	if err != nil || tokenID != "tok1" || campaignID != "camp1" || recipientID != "rec1" {
+		t.Errorf("lookup imported p1 = %q %q %q %v", tokenID, campaignID, recipientID, err)
+	}
+
+	// Re-importing p1 with different attribution must not clobber it.
+	jsonl := `{"payload_hex":"` + p1 + `","token_id":"other","campaign_id":"other","recipient_id":"other"}` + "\n" +
+		`{"payload_hex":"` + strings.Repeat("c3", 16) + `","token_id":"tok3","campaign_id":"camp2","recipient_id":"rec3"}` + "\n"
+	code, counts = importWatermarkIndex(t, h, "application/x-ndjson", jsonl)
+	if code != http.StatusOK || counts["inserted"] != 1 || counts["skipped"] != 1 {
+		t.Fatalf("duplicate import: status %d counts %v", code, counts)
+	}
+	tokenID, _, _, _ = db.LookupWatermarkIndex(h.DB, p1[4:20])
+	if tokenID != "tok1" {
+		t.Errorf("existing entry overwritten: token_id = %q", tokenID)
+	}
+
+	// Mixed-case input is stored lowercase.
+	tokenID, _, _, _ = db.LookupWatermarkIndex(h.DB, strings.ToLower(p2[4:20]))
+	if tokenID != "tok2" {
+		t.Errorf("p2 not stored lowercase")
+	}
+}
+
+func TestAPIAdminWatermarkIndexImportRejectsBadHex(t *testing.T) {
+	h := newTestHandler(t)
+	body := "payload_hex,token_id,campaign_id,recipient_id\n" +
+		strings.Repeat("a1", 16) + ",tok1,camp1,rec1\n" +
+		"not-hex,tok2,camp1,rec2\n"
+	code, _ := importWatermarkIndex(t, h, "text/csv", body)
+	if code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400", code)
+	}
+	var n int
+	h.DB.QueryRow("SELECT COUNT(*) FROM watermark_index").Scan(&n)
+	if n != 0 {
+		t.Errorf("rejected import wrote %d rows", n)
+	}
+}
@@ -0,0 +1,130 @@
+package handler
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/YannKr/downloadonce/internal/auth"
+	"github.com/YannKr/downloadonce/internal/db"
+)
+
+type apiDailyCount struct {
+	Date  string `json:"date"`
+	Count int    `json:"count"`
+}
+
+type apiCampaignAnalytics struct {
+	CampaignID       string  `json:"campaign_id"`
+	CampaignName     string  `json:"campaign_name"`
+	TotalDownloads   int     `json:"total_downloads"`
+	UniqueRecipients int     `json:"unique_recipients"`
+	LastDownloadAt   *string `json:"last_download_at"`
+}
+
+type apiDashboardStats struct {
+	DownloadsThisWeek  int `json:"downloads_this_week"`
+	DownloadsThisMonth int `json:"downloads_this_month"`
+	DownloadsAllTime   int `json:"downloads_all_time"`
+}
+
+type apiAnalytics struct {
+	Start          string                 `json:"start"`
+	End            string                 `json:"end"`
+	TotalDownloads int                    `json:"total_downloads"`
+	Daily          []apiDailyCount        `json:"daily"`
+	Campaigns      []apiCampaignAnalytics `json:"campaigns"`
+	Dashboard      apiDashboardStats      `json:"dashboard"`
+}
+
+// parseAnalyticsRange reads the start and end query parameters (YYYY-MM-DD),
+// defaulting to the last 30 days like the web analytics page. Spans longer
+// than a year are rejected.
+func parseAnalyticsRange(r *http.Request) (start, end string, err error) {
+	end = r.URL.Query().Get("end")
+	start = r.URL.Query().Get("start")
+	if end == "" {
+		end = time.Now().Format("2006-01-02")
+	}
+	if start == "" {
+		start = time.Now().AddDate(0, 0, -30).Format("2006-01-02")
+	}
+
+	s, err := time.Parse("2006-01-02", start)
+	if err != nil {
+		return "", "", errors.New("start must be a date in YYYY-MM-DD format")
+	}
+	e, err := time.Parse("2006-01-02", end)
+	if err != nil {
+		return "", "", errors.New("end must be a date in YYYY-MM-DD format")
+	}
+	if e.Before(s) {
+		return "", "", errors.New("end must not be before start")
+	}
+	if e.After(s.AddDate(1, 0, 0)) {
+		return "", "", errors.New("date range must not exceed one year")
+	}
+	return start, end, nil
+}
+
+// APIAnalytics - GET /api/v1/analytics
+func (h *Handler) APIAnalytics(w http.ResponseWriter, r *http.Request) {
+	accountID := auth.AccountFromContext(r.Context())
+
+	start, end, err := parseAnalyticsRange(r)
+	if err != nil {
+		renderJSONError(w, http.StatusBadRequest, "BAD_REQUEST", err.Error())
+		return
+	}
+
+	daily, err := db.CountDownloadsByDateRange(h.DB, accountID, start, end)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "api analytics daily counts", "error", err)
+		renderJSONError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "failed to load analytics")
+		return
+	}
+	campaigns, err := db.CampaignAnalyticsByDateRange(h.DB, accountID, start, end)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "api analytics campaigns", "error", err)
+		renderJSONError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "failed to load analytics")
+		return
+	}
+	stats, err := db.GetDashboardStats(h.DB, accountID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "api analytics dashboard stats", "error", err)
+		renderJSONError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "failed to load analytics")
+		return
+	}
+
+	resp := apiAnalytics{
+		Start:     start,
+		End:       end,
+		Daily:     make([]apiDailyCount, 0, len(daily)),
+		Campaigns: make([]apiCampaignAnalytics, 0, len(campaigns)),
+		Dashboard: apiDashboardStats{
+			DownloadsThisWeek:  stats.DownloadsThisWeek,
+			DownloadsThisMonth: stats.DownloadsThisMonth,
+			DownloadsAllTime:   stats.DownloadsAllTime,
+		},
+	}
+	for _, d := range daily {
+		resp.TotalDownloads += d.Count
+		resp.Daily = append(resp.Daily, apiDailyCount{Date: d.Date, Count: d.Count})
+	}
+	for _, c := range campaigns {
+		ac := apiCampaignAnalytics{
+			CampaignID:       c.CampaignID,
+			CampaignName:     c.CampaignName,
+			TotalDownloads:   c.TotalDownloads,
+			UniqueRecipients: c.UniqueRecipients,
+		}
+		if c.LastDownload != nil {
+			s := c.LastDownload.UTC().Format("2006-01-02T15:04:05Z")
+			ac.LastDownloadAt = &s
+		}
+		resp.Campaigns = append(resp.Campaigns, ac)
+	}
+
+	renderJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,77 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/YannKr/downloadonce/internal/db"
+	"github.com/YannKr/downloadonce/internal/model"
+)
+
+func TestAPIAnalytics(t *testing.T) {
+	h := newTestHandler(t)
+	seedAccount(t, h.DB, "acc", "member")
+	seedAccount(t, h.DB, "other", "member")
+	mine := seedCampaign(t, h.DB, "acc", "camp", "READY", "r1", "r2")
+	theirs := seedCampaign(t, h.DB, "other", "theirs", "READY", "r3")
+
+	insert := func(id, tokenID, campaignID, recipientID string) {
+		t.Helper()
+		if err := db.InsertDownloadEvent(h.DB, &model.DownloadEvent{
+			ID: id, TokenID: tokenID, CampaignID: campaignID, RecipientID: recipientID, AssetID: campaignID + "-asset",
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	insert("e1", mine[0], "camp", "r1")
+	insert("e2", mine[0], "camp", "r1")
+	insert("e3", mine[1], "camp", "r2")
+	insert("e4", theirs[0], "theirs", "r3")
+
+	req := asAccount(httptest.NewRequest("GET", "/api/v1/analytics", nil), "acc", "member")
+	rec := httptest.NewRecorder()
+	h.APIAnalytics(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+
+	var got apiAnalytics
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.End != time.Now().Format("2006-01-02") {
+		t.Errorf("end = %s, want today", got.End)
+	}
+	if got.TotalDownloads != 3 || got.Dashboard.DownloadsAllTime != 3 {
+		t.Errorf("total = %d, all time = %d, want 3 (other accounts excluded)", got.TotalDownloads, got.Dashboard.DownloadsAllTime)
+	}
+	if len(got.Campaigns) != 1 || got.Campaigns[0].CampaignID != "camp" || got.Campaigns[0].UniqueRecipients != 2 {
+		t.Errorf("campaigns = %+v", got.Campaigns)
+	}
+}
+
+func TestAPIAnalyticsRangeValidation(t *testing.T) {
+	h := newTestHandler(t)
+	for _, q := range []string{
+		"start=2024-01-01&end=2025-06-01",
+		"start=2024-02-01&end=2024-01-01",
+		"start=yesterday",
+	} {
+		req := asAccount(httptest.NewRequest("GET", "/api/v1/analytics?"+q, nil), "acc", "member")
+		rec := httptest.NewRecorder()
+		h.APIAnalytics(rec, req)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", q, rec.Code)
+		}
+	}
+
+	req := asAccount(httptest.NewRequest("GET", "/api/v1/analytics?start=2024-01-01&end=2024-12-31", nil), "acc", "member")
+	rec := httptest.NewRecorder()
+	h.APIAnalytics(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("one-year range: status = %d, want 200", rec.Code)
+	}
+}
@@ -1,9 +1,11 @@
 package handler
 
 import (
+	"bytes"
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
@@ -33,67 +35,120 @@ type apiAsset struct {
 	Width         *int64   `json:"width"`
 	Height        *int64   `json:"height"`
 	CreatedAt     string   `json:"created_at"`
+	DeletedAt     *string  `json:"deleted_at,omitempty"`
+	// SourceMimeType is set when the upload was converted, e.g. image/heic.
+	SourceMimeType string `json:"source_mime_type,omitempty"`
 }
 
 func assetToAPI(a *model.Asset) apiAsset {
-	return apiAsset{
-		ID:            a.ID,
-		AccountID:     a.AccountID,
-		Title:         a.OriginalName,
-		AssetType:     a.AssetType,
-		MimeType:      a.MimeType,
-		FileSizeBytes: a.FileSize,
-		SHA256:        a.SHA256,
-		DurationSecs:  a.Duration,
-		Width:         a.Width,
-		Height:        a.Height,
-		CreatedAt:     a.CreatedAt.UTC().Format("2006-01-02T15:04:05Z"),
+	aa := apiAsset{
+		ID:             a.ID,
+		AccountID:      a.AccountID,
+		Title:          a.OriginalName,
+		AssetType:      a.AssetType,
+		MimeType:       a.MimeType,
+		FileSizeBytes:  a.FileSize,
+		SHA256:         a.SHA256,
+		DurationSecs:   a.Duration,
+		Width:          a.Width,
+		Height:         a.Height,
+		CreatedAt:      a.CreatedAt.UTC().Format("2006-01-02T15:04:05Z"),
+		SourceMimeType: a.SourceMimeType,
 	}
+	if a.DeletedAt != nil {
+		s := a.DeletedAt.UTC().Format("2006-01-02T15:04:05Z")
+		aa.DeletedAt = &s
+	}
+	return aa
 }
 
 // APIAssetUpload — POST /api/v1/assets
+//
+// The multipart body is read part by part and the file is streamed straight
+// to disk, so memory use does not grow with the upload size.
 func (h *Handler) APIAssetUpload(w http.ResponseWriter, r *http.Request) {
 	accountID := auth.AccountFromContext(r.Context())
 
-	if err := r.ParseMultipartForm(2 << 30); err != nil {
+	mr, err := r.MultipartReader()
+	if err != nil {
 		renderJSONError(w, http.StatusBadRequest, "BAD_REQUEST", "failed to parse multipart form")
 		return
 	}
-
-	file, header, err := r.FormFile("file")
-	if err != nil {
+	var part *multipart.Part
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			renderJSONError(w, http.StatusBadRequest, "BAD_REQUEST", "failed to parse multipart form")
+			return
+		}
+		if p.FormName() == "file" && p.FileName() != "" {
+			part = p
+			break
+		}
+		p.Close()
+	}
+	if part == nil {
 		renderJSONError(w, http.StatusBadRequest, "BAD_REQUEST", "missing 'file' field in form")
 		return
 	}
-	defer file.Close()
+	defer part.Close()
 
-	asset, err := h.processUploadReturn(accountID, header, file)
+	asset, duplicateOf, err := h.processUploadReturn(r.Context(), accountID, part.FileName(), part, r.URL.Query().Get("dedupe") == "1")
 	if err != nil {
+		if isUploadLimitError(err) {
+			renderJSONError(w, http.StatusRequestEntityTooLarge, "TOO_LARGE", err.Error())
+			return
+		}
 		if err.Error() == "unsupported_media_type" {
 			renderJSONError(w, http.StatusUnsupportedMediaType, "UNSUPPORTED_MEDIA_TYPE", "unsupported file type")
 			return
 		}
-		slog.Error("api asset upload", "error", err)
+		if errors.Is(err, errUploadType) {
+			renderJSONError(w, http.StatusUnsupportedMediaType, "UNSUPPORTED_MEDIA_TYPE", err.Error())
+			return
+		}
+		if errors.Is(err, errTranscodeFailed) {
+			renderJSONError(w, http.StatusUnprocessableEntity, "TRANSCODE_FAILED", err.Error())
+			return
+		}
+		slog.ErrorContext(r.Context(), "api asset upload", "error", err)
 		renderJSONError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "failed to upload asset")
 		return
 	}
 
+	if duplicateOf != "" {
+		w.Header().Set("X-Duplicate-Of", duplicateOf)
+	}
+	if asset.ID == duplicateOf {
+		db.InsertAuditLog(h.DB, accountID, "asset_upload_deduplicated", "asset", asset.ID, part.FileName(), r.RemoteAddr)
+		renderJSON(w, http.StatusOK, assetToAPI(asset))
+		return
+	}
 	db.InsertAuditLog(h.DB, accountID, "asset_uploaded", "asset", asset.ID, asset.OriginalName, r.RemoteAddr)
 	renderJSON(w, http.StatusCreated, assetToAPI(asset))
 }
 
-// processUploadReturn is like processOneUpload but returns the created asset.
-func (h *Handler) processUploadReturn(accountID string, header *multipart.FileHeader, file multipart.File) (*model.Asset, error) {
-	originalName := header.Filename
-
-	buf := make([]byte, 512)
-	n, _ := file.Read(buf)
-	mimeType := http.DetectContentType(buf[:n])
-	file.Seek(0, io.SeekStart)
+// processUploadReturn is like processAssetFromReader but returns the created
+// asset. The size limits are enforced against the bytes actually written; at
+// most MaxUploadBytes+1 bytes are read from r.
+//
+// duplicateOf is the ID of an existing asset with the same content, if any.
+// With dedupe set that asset is returned instead and the new copy is dropped.
+func (h *Handler) processUploadReturn(ctx context.Context, accountID, originalName string, r io.Reader, dedupe bool) (asset *model.Asset, duplicateOf string, err error) {
+	var sniff [512]byte
+	n, _ := io.ReadFull(r, sniff[:])
+	mimeType := sniffUploadMime(sniff[:n])
+	r = io.MultiReader(bytes.NewReader(sniff[:n]), r)
+	if h.Cfg.MaxUploadBytes > 0 {
+		r = io.LimitReader(r, h.Cfg.MaxUploadBytes+1)
+	}
 
 	ext, ok := watermark.MimeToExt[mimeType]
 	if !ok {
-		origExt := strings.ToLower(filepath.Ext(header.Filename))
+		origExt := strings.ToLower(filepath.Ext(originalName))
 		found := false
 		for _, e := range watermark.MimeToExt {
 			if e == origExt {
@@ -109,41 +164,65 @@ func (h *Handler) processUploadReturn(accountID string, header *multipart.FileHe
 			}
 		}
 		if !found {
-			return nil, fmt.Errorf("unsupported_media_type")
+			return nil, "", fmt.Errorf("unsupported_media_type")
 		}
 	}
+	if err := h.checkUploadType(accountID, mimeType); err != nil {
+		return nil, "", err
+	}
 
 	assetType := watermark.MimeToAssetType[mimeType]
 	assetID := uuid.New().String()
 
 	assetDir := filepath.Join(h.Cfg.DataDir, "originals", assetID)
 	if err := os.MkdirAll(assetDir, 0755); err != nil {
-		return nil, fmt.Errorf("create asset dir: %w", err)
+		return nil, "", fmt.Errorf("create asset dir: %w", err)
 	}
 
 	srcPath := filepath.Join(assetDir, "source"+ext)
 	dst, err := os.Create(srcPath)
 	if err != nil {
 		os.RemoveAll(assetDir)
-		return nil, fmt.Errorf("create file: %w", err)
+		return nil, "", fmt.Errorf("create file: %w", err)
 	}
 
 	hasher := sha256.New()
-	written, err := io.Copy(dst, io.TeeReader(file, hasher))
+	written, err := io.Copy(dst, io.TeeReader(r, hasher))
 	dst.Close()
 	if err != nil {
 		os.RemoveAll(assetDir)
-		return nil, fmt.Errorf("write file: %w", err)
+		return nil, "", fmt.Errorf("write file: %w", err)
+	}
+	if err := h.checkUploadSize(accountID, written); err != nil {
+		os.RemoveAll(assetDir)
+		return nil, "", err
 	}
 
 	sha256Hex := hex.EncodeToString(hasher.Sum(nil))
+	if dup := h.duplicateAsset(accountID, sha256Hex); dup != nil {
+		if dedupe {
+			os.RemoveAll(assetDir)
+			return dup, dup.ID, nil
+		}
+		slog.WarnContext(ctx, "api asset upload: duplicate of existing asset", "account", accountID, "asset_id", dup.ID, "sha256", sha256Hex)
+		duplicateOf = dup.ID
+	}
+
+	var sourceMime string
+	if watermark.NeedsTranscode(mimeType) {
+		if srcPath, written, err = transcodeToPNG(ctx, srcPath); err != nil {
+			os.RemoveAll(assetDir)
+			return nil, "", err
+		}
+		sourceMime, mimeType, ext = mimeType, "image/png", ".png"
+	}
 
 	var duration *float64
 	var width, height *int64
 	if assetType == "video" {
 		probe, err := watermark.Probe(srcPath)
 		if err != nil {
-			slog.Warn("ffprobe failed", "error", err)
+			slog.WarnContext(ctx, "ffprobe failed", "error", err)
 		} else {
 			duration = &probe.DurationSecs
 			w64 := int64(probe.Width)
@@ -161,23 +240,7 @@ func (h *Handler) processUploadReturn(accountID string, header *multipart.FileHe
 		}
 	}
 
-	thumbPath := filepath.Join(assetDir, "thumb.jpg")
-	ctx := context.Background()
-	if assetType == "video" {
-		seekSec := 1.0
-		if duration != nil && *duration > 10 {
-			seekSec = *duration * 0.1
-		}
-		if err := watermark.ExtractVideoThumbnail(ctx, srcPath, thumbPath, seekSec); err != nil {
-			slog.Warn("thumbnail extraction failed", "error", err)
-		}
-	} else {
-		if err := watermark.ExtractImageThumbnail(ctx, srcPath, thumbPath); err != nil {
-			slog.Warn("thumbnail extraction failed", "error", err)
-		}
-	}
-
-	asset := &model.Asset{
+	asset = &model.Asset{
 		ID:           assetID,
 		AccountID:    accountID,
 		OriginalName: originalName,
@@ -189,14 +252,21 @@ func (h *Handler) processUploadReturn(accountID string, header *multipart.FileHe
 		Duration:     duration,
 		Width:        width,
 		Height:       height,
+
+		SourceMimeType: sourceMime,
 	}
 
-	if err := db.CreateAsset(h.DB, asset); err != nil {
+	if used, err := db.CreateAssetWithinQuota(h.DB, asset, h.Cfg.MaxAccountUploadBytes); err != nil {
 		os.RemoveAll(assetDir)
-		return nil, fmt.Errorf("insert asset: %w", err)
+		if errors.Is(err, db.ErrUploadQuota) {
+			return nil, "", h.uploadQuotaError(used)
+		}
+		return nil, "", fmt.Errorf("insert asset: %w", err)
 	}
+	h.generateThumbnail(ctx, asset)
+	h.dispatchAssetReady(ctx, asset)
 
-	return asset, nil
+	return asset, duplicateOf, nil
 }
 
 // APIAssetList — GET /api/v1/assets
@@ -206,7 +276,7 @@ func (h *Handler) APIAssetList(w http.ResponseWriter, r *http.Request) {
 
 	assets, err := db.ListAssets(h.DB)
 	if err != nil {
-		slog.Error("api list assets", "error", err)
+		slog.ErrorContext(r.Context(), "api list assets", "error", err)
 		renderJSONError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "failed to list assets")
 		return
 	}
@@ -239,12 +309,7 @@ func (h *Handler) APIAssetList(w http.ResponseWriter, r *http.Request) {
 		result[i] = assetToAPI(&a)
 	}
 
-	renderJSON(w, http.StatusOK, paginatedResult{
-		Data:    result,
-		Total:   total,
-		Page:    page,
-		PerPage: perPage,
-	})
+	h.renderPaginated(w, r, result, total, page, perPage)
 }
 
 // APIAssetGet — GET /api/v1/assets/{id}
@@ -265,6 +330,25 @@ func (h *Handler) APIAssetGet(w http.ResponseWriter, r *http.Request) {
 	renderJSON(w, http.StatusOK, assetToAPI(asset))
 }
 
+// APIAssetThumbnail — GET /api/v1/assets/{id}/thumbnail
+func (h *Handler) APIAssetThumbnail(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	accountID := auth.AccountFromContext(r.Context())
+
+	asset, err := db.GetAsset(h.DB, id)
+	if err != nil {
+		renderJSONError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "failed to get asset")
+		return
+	}
+	if asset == nil || (asset.AccountID != accountID && !auth.IsAdmin(r.Context())) {
+		renderJSONError(w, http.StatusNotFound, "NOT_FOUND", "asset not found")
+		return
+	}
+	if !h.serveThumbnail(w, r, asset) {
+		renderJSONError(w, http.StatusNotFound, "NOT_FOUND", "no thumbnail was generated for this asset")
+	}
+}
+
 // APIAssetDelete — DELETE /api/v1/assets/{id}
 func (h *Handler) APIAssetDelete(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
@@ -280,9 +364,50 @@ func (h *Handler) APIAssetDelete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	db.DeleteAsset(h.DB, id)
-	os.RemoveAll(filepath.Join(h.Cfg.DataDir, "originals", id))
-	db.InsertAuditLog(h.DB, accountID, "asset_deleted", "asset", id, "", r.RemoteAddr)
+	if asset.DeletedAt == nil {
+		msg, err := h.assetDeleteBlocked(id)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "api asset delete: count campaigns", "error", err)
+			renderJSONError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "failed to delete asset")
+			return
+		}
+		if msg != "" && !forceDelete(r) {
+			renderJSONError(w, http.StatusConflict, "ASSET_IN_USE", msg)
+			return
+		}
+		if err := db.SoftDeleteAsset(h.DB, id); err != nil {
+			slog.ErrorContext(r.Context(), "api soft delete asset", "error", err)
+			renderJSONError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "failed to delete asset")
+			return
+		}
+		db.InsertAuditLog(h.DB, accountID, "asset_deleted", "asset", id, assetDeleteDetail(asset, msg), r.RemoteAddr)
+	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
+
+// APIAssetRestore — POST /api/v1/assets/{id}/restore
+func (h *Handler) APIAssetRestore(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	accountID := auth.AccountFromContext(r.Context())
+
+	asset, err := db.GetAsset(h.DB, id)
+	if err != nil {
+		renderJSONError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "failed to get asset")
+		return
+	}
+	if asset == nil || (asset.AccountID != accountID && !auth.IsAdmin(r.Context())) {
+		renderJSONError(w, http.StatusNotFound, "NOT_FOUND", "asset not found")
+		return
+	}
+	if asset.DeletedAt != nil {
+		if err := db.RestoreAsset(h.DB, id); err != nil {
+			slog.ErrorContext(r.Context(), "api restore asset", "error", err)
+			renderJSONError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "failed to restore asset")
+			return
+		}
+		db.InsertAuditLog(h.DB, accountID, "asset_restored", "asset", id, asset.OriginalName, r.RemoteAddr)
+		asset.DeletedAt = nil
+	}
+	renderJSON(w, http.StatusOK, assetToAPI(asset))
+}
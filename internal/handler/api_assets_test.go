@@ -0,0 +1,251 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/YannKr/downloadonce/internal/db"
+)
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+// pngStream returns size bytes that sniff as a PNG without holding them in
+// memory.
+func pngStream(size int64) io.Reader {
+	sig := []byte("\x89PNG\r\n\x1a\n")
+	return io.MultiReader(bytes.NewReader(sig), io.LimitReader(zeroReader{}, size-int64(len(sig))))
+}
+
+// multipartUpload builds a streaming POST /api/v1/assets request whose "file"
+// part follows a plain form field.
+func multipartUpload(name string, body io.Reader) *http.Request {
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		mw.WriteField("note", "ignored")
+		fw, err := mw.CreateFormFile("file", name)
+		if err == nil {
+			_, err = io.Copy(fw, body)
+		}
+		if err == nil {
+			err = mw.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+	req := httptest.NewRequest("POST", "/api/v1/assets", pr)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return req
+}
+
+func TestAPIAssetUploadStreams(t *testing.T) {
+	h := newTestHandler(t)
+	seedAccount(t, h.DB, "acc", "member")
+
+	const size = 32 << 20
+	hasher := sha256.New()
+	io.Copy(hasher, pngStream(size))
+	wantSHA := hex.EncodeToString(hasher.Sum(nil))
+
+	req := asAccount(multipartUpload("big.png", pngStream(size)), "acc", "member")
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	rec := httptest.NewRecorder()
+	h.APIAssetUpload(rec, req)
+	runtime.ReadMemStats(&after)
+
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	if alloc := after.TotalAlloc - before.TotalAlloc; alloc > size/4 {
+		t.Errorf("allocated %d bytes for a %d byte upload; want it streamed", alloc, size)
+	}
+	var got apiAsset
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Title != "big.png" || got.FileSizeBytes != size || got.SHA256 != wantSHA {
+		t.Errorf("asset = %+v, want big.png, %d bytes, sha %s", got, size, wantSHA)
+	}
+	fi, err := os.Stat(filepath.Join(h.Cfg.DataDir, "originals", got.ID, "source.png"))
+	if err != nil || fi.Size() != size {
+		t.Errorf("stored file: %v, %v", fi, err)
+	}
+}
+
+func TestAPIAssetUploadTooLarge(t *testing.T) {
+	h := newTestHandler(t)
+	h.Cfg.MaxUploadBytes = 1 << 20
+	seedAccount(t, h.DB, "acc", "member")
+
+	rec := httptest.NewRecorder()
+	h.APIAssetUpload(rec, asAccount(multipartUpload("big.png", pngStream(2<<20)), "acc", "member"))
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status = %d, want 413: %s", rec.Code, rec.Body)
+	}
+	if entries, _ := os.ReadDir(filepath.Join(h.Cfg.DataDir, "originals")); len(entries) != 0 {
+		t.Errorf("rejected upload left %d asset dirs", len(entries))
+	}
+
+	rec = httptest.NewRecorder()
+	h.APIAssetUpload(rec, asAccount(multipartUpload("small.png", pngStream(1<<20)), "acc", "member"))
+	if rec.Code != http.StatusCreated {
+		t.Errorf("upload at the limit: status = %d: %s", rec.Code, rec.Body)
+	}
+}
+
+// fakeMagick puts a "magick" script on PATH that runs body, with $out set to
+// its last argument.
+func fakeMagick(t *testing.T, body string) {
+	t.Helper()
+	dir := t.TempDir()
+	script := "#!/bin/sh\nfor a; do out=$a; done\n" + body + "\n"
+	if err := os.WriteFile(filepath.Join(dir, "magick"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestAPIAssetUploadHEIC(t *testing.T) {
+	h := newTestHandler(t)
+	seedAccount(t, h.DB, "acc", "member")
+	// An ftyp box branded heic, as phones write it.
+	heic := append([]byte("\x00\x00\x00\x18ftypheic\x00\x00\x00\x00mif1heic"), make([]byte, 1000)...)
+
+	fakeMagick(t, "exit 1")
+	rec := httptest.NewRecorder()
+	h.APIAssetUpload(rec, asAccount(multipartUpload("IMG_0001.HEIC", bytes.NewReader(heic)), "acc", "member"))
+	if rec.Code != http.StatusUnprocessableEntity || !strings.Contains(rec.Body.String(), "TRANSCODE_FAILED") {
+		t.Fatalf("failed conversion: status = %d: %s", rec.Code, rec.Body)
+	}
+	if entries, _ := os.ReadDir(filepath.Join(h.Cfg.DataDir, "originals")); len(entries) != 0 {
+		t.Errorf("failed conversion left %d asset dirs", len(entries))
+	}
+
+	fakeMagick(t, `printf '\211PNG\r\n\032\nconverted' > "$out"`)
+	rec = httptest.NewRecorder()
+	h.APIAssetUpload(rec, asAccount(multipartUpload("IMG_0001.HEIC", bytes.NewReader(heic)), "acc", "member"))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	var got apiAsset
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(heic)
+	if got.MimeType != "image/png" || got.SourceMimeType != "image/heic" || got.AssetType != "image" ||
+		got.SHA256 != hex.EncodeToString(sum[:]) || got.FileSizeBytes != 17 {
+		t.Errorf("asset = %+v", got)
+	}
+	dir := filepath.Join(h.Cfg.DataDir, "originals", got.ID)
+	if _, err := os.Stat(filepath.Join(dir, "source.png")); err != nil {
+		t.Errorf("converted original: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "source.heic")); !os.IsNotExist(err) {
+		t.Errorf("HEIC upload kept after conversion: %v", err)
+	}
+	if a, _ := db.GetAsset(h.DB, got.ID); a == nil || a.SourceMimeType != "image/heic" || a.OriginalPath != filepath.Join("originals", got.ID, "source.png") {
+		t.Errorf("stored asset = %+v", a)
+	}
+}
+
+func TestAPIAssetDeleteRestore(t *testing.T) {
+	h := newTestHandler(t)
+	seedAccount(t, h.DB, "acc", "member")
+	seedCampaign(t, h.DB, "acc", "camp", "READY")
+
+	r := chi.NewRouter()
+	r.Delete("/api/v1/assets/{id}", h.APIAssetDelete)
+	r.Post("/api/v1/assets/{id}/restore", h.APIAssetRestore)
+	do := func(method, path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, asAccount(httptest.NewRequest(method, path, nil), "acc", "member"))
+		return rec
+	}
+
+	if rec := do("DELETE", "/api/v1/assets/camp-asset"); rec.Code != http.StatusConflict {
+		t.Fatalf("delete with live campaign: status = %d, want 409: %s", rec.Code, rec.Body)
+	}
+
+	if _, err := h.DB.Exec(`UPDATE campaigns SET state = 'ARCHIVED' WHERE id = 'camp'`); err != nil {
+		t.Fatal(err)
+	}
+	if rec := do("DELETE", "/api/v1/assets/camp-asset"); rec.Code != http.StatusNoContent {
+		t.Fatalf("delete: status = %d: %s", rec.Code, rec.Body)
+	}
+	if assets, _ := db.ListAssets(h.DB); len(assets) != 0 {
+		t.Errorf("deleted asset still listed: %v", assets)
+	}
+	if a, _ := db.GetAsset(h.DB, "camp-asset"); a == nil || a.DeletedAt == nil {
+		t.Fatalf("asset after delete = %+v, want soft-deleted row", a)
+	}
+
+	rec := do("POST", "/api/v1/assets/camp-asset/restore")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("restore: status = %d: %s", rec.Code, rec.Body)
+	}
+	var got apiAsset
+	json.NewDecoder(rec.Body).Decode(&got)
+	if got.ID != "camp-asset" || got.DeletedAt != nil {
+		t.Errorf("restored asset = %+v", got)
+	}
+	if assets, _ := db.ListAssets(h.DB); len(assets) != 1 {
+		t.Errorf("restored asset not listed: %v", assets)
+	}
+}
+
+func TestAPIAssetDeleteForce(t *testing.T) {
+	h := newTestHandler(t)
+	seedAccount(t, h.DB, "acc", "member")
+	seedAccount(t, h.DB, "root", "admin")
+	seedCampaign(t, h.DB, "acc", "camp", "DRAFT")
+
+	r := chi.NewRouter()
+	r.Delete("/api/v1/assets/{id}", h.APIAssetDelete)
+	del := func(path, acct, role string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, asAccount(httptest.NewRequest("DELETE", path, nil), acct, role))
+		return rec
+	}
+
+	rec := del("/api/v1/assets/camp-asset", "acc", "member")
+	if rec.Code != http.StatusConflict || !bytes.Contains(rec.Body.Bytes(), []byte(`\"camp\"`)) {
+		t.Fatalf("in-use delete: status = %d, body %s; want 409 naming the campaign", rec.Code, rec.Body)
+	}
+	if rec := del("/api/v1/assets/camp-asset?force=1", "acc", "member"); rec.Code != http.StatusConflict {
+		t.Fatalf("member force: status = %d, want 409", rec.Code)
+	}
+	if rec := del("/api/v1/assets/camp-asset?force=1", "root", "admin"); rec.Code != http.StatusNoContent {
+		t.Fatalf("admin force: status = %d: %s", rec.Code, rec.Body)
+	}
+	if a, _ := db.GetAsset(h.DB, "camp-asset"); a == nil || a.DeletedAt == nil {
+		t.Errorf("asset after forced delete = %+v", a)
+	}
+	// The audit log is written asynchronously.
+	var detail string
+	for i := 0; i < 50 && detail == ""; i++ {
+		h.DB.QueryRow(`SELECT detail FROM audit_logs WHERE action = 'asset_deleted'`).Scan(&detail)
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !strings.Contains(detail, "forced") {
+		t.Errorf("audit detail = %q, want the forced override recorded", detail)
+	}
+}
@@ -2,33 +2,59 @@ package handler
 
 import (
 	"encoding/json"
+	"fmt"
 	"log/slog"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
 	"github.com/YannKr/downloadonce/internal/auth"
 	"github.com/YannKr/downloadonce/internal/db"
+	"github.com/YannKr/downloadonce/internal/logging"
 	"github.com/YannKr/downloadonce/internal/model"
+	"github.com/YannKr/downloadonce/internal/watermark"
 )
 
 type apiCampaign struct {
-	ID              string  `json:"id"`
-	Name            string  `json:"name"`
-	AssetID         string  `json:"asset_id"`
-	State           string  `json:"state"`
-	MaxDownloads    *int    `json:"max_downloads"`
-	ExpiresAt       *string `json:"expires_at"`
-	VisibleWM       bool    `json:"visible_wm"`
-	InvisibleWM     bool    `json:"invisible_wm"`
-	JobsTotal       int     `json:"jobs_total"`
-	JobsCompleted   int     `json:"jobs_completed"`
-	JobsFailed      int     `json:"jobs_failed"`
-	RecipientCount  int     `json:"recipient_count"`
-	DownloadedCount int     `json:"downloaded_count"`
-	CreatedAt       string  `json:"created_at"`
-	PublishedAt     *string `json:"published_at"`
+	ID              string   `json:"id"`
+	Name            string   `json:"name"`
+	AssetID         string   `json:"asset_id"`
+	State           string   `json:"state"`
+	MaxDownloads    *int     `json:"max_downloads"`
+	ExpiresAt       *string  `json:"expires_at"`
+	VisibleWM       bool     `json:"visible_wm"`
+	InvisibleWM     bool     `json:"invisible_wm"`
+	WMChannels      string   `json:"wm_channels,omitempty"`
+	WMScale         *float64 `json:"wm_scale,omitempty"`
+	WMTextTemplate  string   `json:"wm_text_template,omitempty"`
+	VisiblePosition string   `json:"visible_wm_position,omitempty"`
+	VisibleOpacity  *float64 `json:"visible_wm_opacity,omitempty"`
+	VisibleFontSize *int     `json:"visible_wm_font_size,omitempty"`
+	DownloadMessage string   `json:"download_message,omitempty"`
+	ExpiryMessage   string   `json:"expiry_message,omitempty"`
+	LinkRequests    bool     `json:"link_requests"`
+	JobsTotal       int      `json:"jobs_total"`
+	JobsCompleted   int      `json:"jobs_completed"`
+	JobsFailed      int      `json:"jobs_failed"`
+	RecipientCount  int      `json:"recipient_count"`
+	DownloadedCount int      `json:"downloaded_count"`
+	CreatedAt       string   `json:"created_at"`
+	PublishedAt     *string  `json:"published_at"`
+	PublishAt       *string  `json:"publish_at,omitempty"`
+	ApprovedBy      string   `json:"approved_by,omitempty"`
+	ApprovedAt      *string  `json:"approved_at,omitempty"`
+	DeletedAt       *string  `json:"deleted_at,omitempty"`
+
+	// Campaign-wide download budget; null when there is none.
+	MaxTotalDownloads  *int `json:"max_total_downloads"`
+	TotalDownloads     int  `json:"total_downloads"`
+	RemainingDownloads *int `json:"remaining_downloads"`
+
+	FilenameTemplate string `json:"filename_template,omitempty"`
+	// SelfVerify overrides WM_SELF_VERIFY; omitted when inherited.
+	SelfVerify *bool `json:"wm_self_verify,omitempty"`
 }
 
 type apiToken struct {
@@ -45,6 +71,22 @@ type apiToken struct {
 	ExpiresAt      *string `json:"expires_at"`
 	DownloadURL    string  `json:"download_url"`
 	CreatedAt      string  `json:"created_at"`
+
+	// Latest watermark job for the token; null when none was ever queued.
+	JobState    *string `json:"job_state"`
+	JobProgress *int    `json:"job_progress"`
+	JobError    *string `json:"job_error"`
+}
+
+type apiDownloadEvent struct {
+	ID           string `json:"id"`
+	TokenID      string `json:"token_id"`
+	RecipientID  string `json:"recipient_id"`
+	IPAddress    string `json:"ip_address"`
+	UserAgent    string `json:"user_agent"`
+	Country      string `json:"country,omitempty"`
+	City         string `json:"city,omitempty"`
+	DownloadedAt string `json:"downloaded_at"`
 }
 
 func campaignToAPI(c *model.Campaign, jobsTotal, jobsCompleted, jobsFailed, recipientCount, downloadedCount int) apiCampaign {
@@ -56,12 +98,28 @@ func campaignToAPI(c *model.Campaign, jobsTotal, jobsCompleted, jobsFailed, reci
 		MaxDownloads:    c.MaxDownloads,
 		VisibleWM:       c.VisibleWM,
 		InvisibleWM:     c.InvisibleWM,
+		WMChannels:      c.WMChannels,
+		WMScale:         c.WMScale,
+		WMTextTemplate:  c.WMTextTemplate,
+		VisiblePosition: c.VisiblePosition,
+		VisibleOpacity:  c.VisibleOpacity,
+		VisibleFontSize: c.VisibleFontSize,
+		DownloadMessage: c.DownloadMessage,
+		ExpiryMessage:   c.ExpiryMessage,
+		LinkRequests:    c.LinkRequests,
 		JobsTotal:       jobsTotal,
 		JobsCompleted:   jobsCompleted,
 		JobsFailed:      jobsFailed,
 		RecipientCount:  recipientCount,
 		DownloadedCount: downloadedCount,
 		CreatedAt:       c.CreatedAt.UTC().Format(time.RFC3339),
+
+		MaxTotalDownloads:  c.MaxTotalDownloads,
+		TotalDownloads:     c.TotalDownloads,
+		RemainingDownloads: c.RemainingDownloads(),
+
+		FilenameTemplate: c.FilenameTemplate,
+		SelfVerify:       c.SelfVerify,
 	}
 	if c.ExpiresAt != nil {
 		s := c.ExpiresAt.UTC().Format(time.RFC3339)
@@ -71,10 +129,24 @@ func campaignToAPI(c *model.Campaign, jobsTotal, jobsCompleted, jobsFailed, reci
 		s := c.PublishedAt.UTC().Format(time.RFC3339)
 		ac.PublishedAt = &s
 	}
+	if c.PublishAt != nil {
+		s := c.PublishAt.UTC().Format(time.RFC3339)
+		ac.PublishAt = &s
+	}
+	if c.ApprovedAt != nil {
+		s := c.ApprovedAt.UTC().Format(time.RFC3339)
+		ac.ApprovedBy, ac.ApprovedAt = c.ApprovedBy, &s
+	}
+	if c.DeletedAt != nil {
+		s := c.DeletedAt.UTC().Format(time.RFC3339)
+		ac.DeletedAt = &s
+	}
 	return ac
 }
 
-func tokenToAPI(t *model.TokenWithRecipient, downloadURL string) apiToken {
+// tokenToAPI converts t for the API. job is the token's latest watermark job,
+// or nil.
+func tokenToAPI(t *model.TokenWithRecipient, downloadURL string, job *model.Job) apiToken {
 	at := apiToken{
 		ID:             t.ID,
 		CampaignID:     t.CampaignID,
@@ -96,6 +168,14 @@ func tokenToAPI(t *model.TokenWithRecipient, downloadURL string) apiToken {
 		s := t.ExpiresAt.UTC().Format(time.RFC3339)
 		at.ExpiresAt = &s
 	}
+	if job != nil {
+		state, progress := job.State, job.Progress
+		at.JobState, at.JobProgress = &state, &progress
+		if job.ErrorMessage != "" {
+			msg := job.ErrorMessage
+			at.JobError = &msg
+		}
+	}
 	return at
 }
 
@@ -108,10 +188,22 @@ func (h *Handler) APICampaignCreate(w http.ResponseWriter, r *http.Request) {
 		AssetID      string   `json:"asset_id"`
 		RecipientIDs []string `json:"recipient_ids"`
 		MaxDownloads *int     `json:"max_downloads"`
+		MaxTotal     *int     `json:"max_total_downloads"`
 		ExpiresAt    string   `json:"expires_at"`
 		VisibleWM    bool     `json:"visible_wm"`
 		InvisibleWM  bool     `json:"invisible_wm"`
+		WMChannels   string   `json:"wm_channels"`
+		WMScale      *float64 `json:"wm_scale"`
+		WMText       string   `json:"wm_text_template"`
+		VisiblePos   string   `json:"visible_wm_position"`
+		VisibleOpac  *float64 `json:"visible_wm_opacity"`
+		VisibleFont  *int     `json:"visible_wm_font_size"`
+		Message      string   `json:"download_message"`
+		ExpiryMsg    string   `json:"expiry_message"`
+		Filename     string   `json:"filename_template"`
+		LinkRequests bool     `json:"link_requests"`
 		AutoPublish  bool     `json:"auto_publish"`
+		SelfVerify   *bool    `json:"wm_self_verify"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
 		renderJSONError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid JSON body")
@@ -126,7 +218,47 @@ func (h *Handler) APICampaignCreate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if len(body.RecipientIDs) == 0 {
-		renderJSONError(w, http.StatusBadRequest, "BAD_REQUEST", "recipient_ids must be a non-empty array")
+		// Fall back to the account's default group, if one is set.
+		if account, _ := db.GetAccountByID(h.DB, accountID); account != nil && account.DefaultGroupID != "" {
+			body.RecipientIDs, _ = db.ListGroupMemberIDs(h.DB, account.DefaultGroupID, accountID)
+		}
+	}
+	if len(body.RecipientIDs) == 0 {
+		renderJSONError(w, http.StatusBadRequest, "BAD_REQUEST", "recipient_ids must be a non-empty array (or set a default recipient group)")
+		return
+	}
+	if body.MaxTotal != nil && *body.MaxTotal < 1 {
+		renderJSONError(w, http.StatusBadRequest, "BAD_REQUEST", "max_total_downloads must be at least 1")
+		return
+	}
+	channels, err := normalizeInvisibleOverride(body.WMChannels, body.WMScale)
+	if err != nil {
+		renderJSONError(w, http.StatusBadRequest, "BAD_REQUEST", err.Error())
+		return
+	}
+	body.WMChannels = channels
+	body.WMText = strings.TrimSpace(body.WMText)
+	if err := watermark.ValidateTextTemplate(body.WMText); err != nil {
+		renderJSONError(w, http.StatusBadRequest, "BAD_REQUEST", err.Error())
+		return
+	}
+	body.Message = strings.TrimSpace(body.Message)
+	if len(body.Message) > maxDownloadMessageLen {
+		renderJSONError(w, http.StatusBadRequest, "BAD_REQUEST", fmt.Sprintf("download_message must be at most %d characters", maxDownloadMessageLen))
+		return
+	}
+	body.ExpiryMsg = strings.TrimSpace(body.ExpiryMsg)
+	if len(body.ExpiryMsg) > maxDownloadMessageLen {
+		renderJSONError(w, http.StatusBadRequest, "BAD_REQUEST", fmt.Sprintf("expiry_message must be at most %d characters", maxDownloadMessageLen))
+		return
+	}
+	if err := validateVisibleStyle(body.VisiblePos, body.VisibleOpac, body.VisibleFont); err != nil {
+		renderJSONError(w, http.StatusBadRequest, "BAD_REQUEST", err.Error())
+		return
+	}
+	body.Filename = strings.TrimSpace(body.Filename)
+	if err := validateFilenameTemplate(body.Filename); err != nil {
+		renderJSONError(w, http.StatusBadRequest, "BAD_REQUEST", err.Error())
 		return
 	}
 
@@ -135,20 +267,54 @@ func (h *Handler) APICampaignCreate(w http.ResponseWriter, r *http.Request) {
 		renderJSONError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "failed to get asset")
 		return
 	}
-	if asset == nil {
+	if asset == nil || asset.DeletedAt != nil {
 		renderJSONError(w, http.StatusNotFound, "NOT_FOUND", "asset not found")
 		return
 	}
 
+	if body.InvisibleWM {
+		if msg := invisibleSizeProblem(asset); msg != "" {
+			renderJSONError(w, http.StatusUnprocessableEntity, "IMAGE_TOO_SMALL", msg)
+			return
+		}
+	}
+
+	if msg, err := h.campaignLimitReached(accountID); err != nil {
+		renderJSONError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "failed to check campaign limit")
+		return
+	} else if msg != "" {
+		renderJSONError(w, http.StatusConflict, "CAMPAIGN_LIMIT", msg)
+		return
+	}
+	if body.AutoPublish {
+		if msg := h.publishDiskProblem(asset, len(body.RecipientIDs)); msg != "" {
+			renderJSONError(w, http.StatusInsufficientStorage, "INSUFFICIENT_STORAGE", msg)
+			return
+		}
+	}
+
 	campaign := &model.Campaign{
-		ID:           uuid.New().String(),
-		AccountID:    accountID,
-		AssetID:      body.AssetID,
-		Name:         body.Name,
-		MaxDownloads: body.MaxDownloads,
-		VisibleWM:    body.VisibleWM,
-		InvisibleWM:  body.InvisibleWM,
-		State:        "DRAFT",
+		ID:              uuid.New().String(),
+		AccountID:       accountID,
+		AssetID:         body.AssetID,
+		Name:            body.Name,
+		MaxDownloads:    body.MaxDownloads,
+		VisibleWM:       body.VisibleWM,
+		InvisibleWM:     body.InvisibleWM,
+		WMChannels:      body.WMChannels,
+		WMScale:         body.WMScale,
+		WMTextTemplate:  body.WMText,
+		VisiblePosition: body.VisiblePos,
+		VisibleOpacity:  body.VisibleOpac,
+		VisibleFontSize: body.VisibleFont,
+		DownloadMessage: body.Message,
+		ExpiryMessage:   body.ExpiryMsg,
+		LinkRequests:    body.LinkRequests,
+		State:           "DRAFT",
+
+		MaxTotalDownloads: body.MaxTotal,
+		FilenameTemplate:  body.Filename,
+		SelfVerify:        body.SelfVerify,
 	}
 
 	if body.ExpiresAt != "" {
@@ -161,7 +327,7 @@ func (h *Handler) APICampaignCreate(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := db.CreateCampaign(h.DB, campaign); err != nil {
-		slog.Error("api create campaign", "error", err)
+		slog.ErrorContext(r.Context(), "api create campaign", "error", err)
 		renderJSONError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "failed to create campaign")
 		return
 	}
@@ -177,7 +343,7 @@ func (h *Handler) APICampaignCreate(w http.ResponseWriter, r *http.Request) {
 			ExpiresAt:    campaign.ExpiresAt,
 		}
 		if err := db.CreateToken(h.DB, token); err != nil {
-			slog.Error("api create token", "error", err, "recipient_id", rid)
+			slog.ErrorContext(r.Context(), "api create token", "error", err, "recipient_id", rid)
 			continue
 		}
 		tokens = append(tokens, token)
@@ -198,9 +364,10 @@ func (h *Handler) APICampaignCreate(w http.ResponseWriter, r *http.Request) {
 				JobType:    jobType,
 				CampaignID: campaign.ID,
 				TokenID:    t.ID,
+				RequestID:  logging.RequestID(r.Context()),
 			}
 			if err := db.EnqueueJob(h.DB, job); err != nil {
-				slog.Error("api auto-publish enqueue job", "error", err, "token", t.ID)
+				slog.ErrorContext(r.Context(), "api auto-publish enqueue job", "error", err, "token", t.ID)
 			}
 		}
 	}
@@ -268,6 +435,10 @@ func (h *Handler) APICampaignPublish(w http.ResponseWriter, r *http.Request) {
 		renderJSONError(w, http.StatusConflict, "CONFLICT", "campaign is not in DRAFT state")
 		return
 	}
+	if campaign.DeletedAt != nil {
+		renderJSONError(w, http.StatusConflict, "CONFLICT", "campaign is deleted")
+		return
+	}
 
 	tokens, err := db.ListTokensByCampaign(h.DB, id)
 	if err != nil {
@@ -279,44 +450,155 @@ func (h *Handler) APICampaignPublish(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	asset, err := db.GetAsset(h.DB, campaign.AssetID)
-	if err != nil || asset == nil {
-		renderJSONError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "asset not found")
+	// With REQUIRE_APPROVAL, an unapproved campaign is submitted instead:
+	// 202 and state PENDING_APPROVAL; publish again once approved.
+	if h.needsApproval(campaign) {
+		if _, err := db.SubmitCampaignForApproval(h.DB, id); err != nil {
+			renderJSONError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "failed to submit campaign")
+			return
+		}
+		db.InsertAuditLog(h.DB, accountID, "campaign_submitted", "campaign", id, campaign.Name, r.RemoteAddr)
+		h.renderAPICampaign(w, http.StatusAccepted, id)
 		return
 	}
 
-	jobType := "watermark_video"
-	if asset.AssetType == "image" {
-		jobType = "watermark_image"
+	_, problem, err := h.startPublish(r.Context(), campaign, tokens)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "api publish campaign", "error", err, "campaign", id)
+		renderJSONError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "failed to publish campaign")
+		return
 	}
+	if problem != nil {
+		renderJSONError(w, problem.status, problem.code, problem.msg)
+		return
+	}
+	db.InsertAuditLog(h.DB, accountID, "campaign_published", "campaign", id, campaign.Name, r.RemoteAddr)
 
-	db.SetCampaignPublished(h.DB, id)
+	campaign, _ = db.GetCampaign(h.DB, id)
+
+	downloadedCount := 0
 	for _, t := range tokens {
-		job := &model.Job{
-			ID:         uuid.New().String(),
-			JobType:    jobType,
-			CampaignID: id,
-			TokenID:    t.ID,
-		}
-		if err := db.EnqueueJob(h.DB, job); err != nil {
-			slog.Error("api enqueue watermark job", "error", err, "token", t.ID)
+		if t.DownloadCount > 0 {
+			downloadedCount++
 		}
 	}
-	db.InsertAuditLog(h.DB, accountID, "campaign_published", "campaign", id, campaign.Name, r.RemoteAddr)
+	jobsTotal, jobsCompleted, jobsFailed, _ := db.CountJobsByCampaign(h.DB, id)
+	ac := campaignToAPI(campaign, jobsTotal, jobsCompleted, jobsFailed, len(tokens), downloadedCount)
+	renderJSON(w, http.StatusOK, ac)
+}
 
-	if h.Mailer != nil && h.Mailer.Enabled() {
-		for _, t := range tokens {
-			downloadURL := h.Cfg.BaseURL + "/d/" + t.ID
-			go func(toEmail, name, url string) {
-				if err := h.Mailer.SendDownloadLink(toEmail, name, campaign.Name, url); err != nil {
-					slog.Error("send download email", "error", err, "to", toEmail)
-				}
-			}(t.RecipientEmail, t.RecipientName, downloadURL)
+// APICampaignCancel - POST /api/v1/campaigns/{id}/cancel
+//
+// Stops a PROCESSING campaign's publish, or a SCHEDULED campaign's pending
+// one; either way the campaign returns to DRAFT.
+func (h *Handler) APICampaignCancel(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	accountID := auth.AccountFromContext(r.Context())
+
+	campaign, err := db.GetCampaign(h.DB, id)
+	if err != nil {
+		renderJSONError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "failed to get campaign")
+		return
+	}
+	if campaign == nil || (campaign.AccountID != accountID && !auth.IsAdmin(r.Context())) {
+		renderJSONError(w, http.StatusNotFound, "NOT_FOUND", "campaign not found")
+		return
+	}
+	switch campaign.State {
+	case "SCHEDULED":
+		if _, err := db.UnscheduleCampaign(h.DB, id); err != nil {
+			slog.ErrorContext(r.Context(), "api unschedule campaign", "error", err, "campaign", id)
+			renderJSONError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "failed to cancel campaign")
+			return
 		}
+		db.InsertAuditLog(h.DB, accountID, "campaign_unscheduled", "campaign", id, campaign.Name, r.RemoteAddr)
+	case "PROCESSING":
+		if _, err := h.cancelCampaignPublish(campaign); err != nil {
+			slog.ErrorContext(r.Context(), "api cancel campaign publish", "error", err, "campaign", id)
+			renderJSONError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "failed to cancel campaign")
+			return
+		}
+		db.InsertAuditLog(h.DB, accountID, "campaign_cancelled", "campaign", id, campaign.Name, r.RemoteAddr)
+	default:
+		renderJSONError(w, http.StatusConflict, "CONFLICT", "campaign is not in SCHEDULED or PROCESSING state")
+		return
 	}
 
 	campaign, _ = db.GetCampaign(h.DB, id)
+	tokens, _ := db.ListTokensByCampaign(h.DB, id)
+	downloadedCount := 0
+	for _, t := range tokens {
+		if t.DownloadCount > 0 {
+			downloadedCount++
+		}
+	}
+	jobsTotal, jobsCompleted, jobsFailed, _ := db.CountJobsByCampaign(h.DB, id)
+	renderJSON(w, http.StatusOK, campaignToAPI(campaign, jobsTotal, jobsCompleted, jobsFailed, len(tokens), downloadedCount))
+}
 
+// APICampaignDelete - DELETE /api/v1/campaigns/{id}
+func (h *Handler) APICampaignDelete(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	accountID := auth.AccountFromContext(r.Context())
+
+	campaign, err := db.GetCampaign(h.DB, id)
+	if err != nil {
+		renderJSONError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "failed to get campaign")
+		return
+	}
+	if campaign == nil || (campaign.AccountID != accountID && !auth.IsAdmin(r.Context())) {
+		renderJSONError(w, http.StatusNotFound, "NOT_FOUND", "campaign not found")
+		return
+	}
+	if campaign.DeletedAt == nil {
+		if !canDelete(campaign.State) {
+			renderJSONError(w, http.StatusConflict, "CONFLICT", "campaign must be DRAFT, EXPIRED or ARCHIVED to delete")
+			return
+		}
+		if err := db.SoftDeleteCampaign(h.DB, id); err != nil {
+			slog.ErrorContext(r.Context(), "api soft delete campaign", "error", err, "campaign", id)
+			renderJSONError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "failed to delete campaign")
+			return
+		}
+		db.InsertAuditLog(h.DB, accountID, "campaign_deleted", "campaign", id, campaign.Name, r.RemoteAddr)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// APICampaignRestore - POST /api/v1/campaigns/{id}/restore
+func (h *Handler) APICampaignRestore(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	accountID := auth.AccountFromContext(r.Context())
+
+	campaign, err := db.GetCampaign(h.DB, id)
+	if err != nil {
+		renderJSONError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "failed to get campaign")
+		return
+	}
+	if campaign == nil || (campaign.AccountID != accountID && !auth.IsAdmin(r.Context())) {
+		renderJSONError(w, http.StatusNotFound, "NOT_FOUND", "campaign not found")
+		return
+	}
+	if campaign.DeletedAt != nil {
+		msg, err := h.campaignRestoreBlocked(campaign)
+		if err != nil {
+			renderJSONError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "failed to restore campaign")
+			return
+		}
+		if msg != "" {
+			renderJSONError(w, http.StatusConflict, "CONFLICT", msg)
+			return
+		}
+		if err := db.RestoreCampaign(h.DB, id); err != nil {
+			slog.ErrorContext(r.Context(), "api restore campaign", "error", err, "campaign", id)
+			renderJSONError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "failed to restore campaign")
+			return
+		}
+		db.InsertAuditLog(h.DB, accountID, "campaign_restored", "campaign", id, campaign.Name, r.RemoteAddr)
+		campaign.DeletedAt = nil
+	}
+
+	tokens, _ := db.ListTokensByCampaign(h.DB, id)
 	downloadedCount := 0
 	for _, t := range tokens {
 		if t.DownloadCount > 0 {
@@ -324,8 +606,7 @@ func (h *Handler) APICampaignPublish(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 	jobsTotal, jobsCompleted, jobsFailed, _ := db.CountJobsByCampaign(h.DB, id)
-	ac := campaignToAPI(campaign, jobsTotal, jobsCompleted, jobsFailed, len(tokens), downloadedCount)
-	renderJSON(w, http.StatusOK, ac)
+	renderJSON(w, http.StatusOK, campaignToAPI(campaign, jobsTotal, jobsCompleted, jobsFailed, len(tokens), downloadedCount))
 }
 
 // APICampaignTokenList - GET /api/v1/campaigns/{id}/tokens
@@ -347,37 +628,43 @@ func (h *Handler) APICampaignTokenList(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	tokens, err := db.ListTokensByCampaign(h.DB, id)
+	page, perPage := paginate(r)
+	total, err := db.CountTokensByCampaign(h.DB, id)
 	if err != nil {
-		slog.Error("api list tokens", "error", err)
+		slog.ErrorContext(r.Context(), "api count tokens", "error", err)
+		renderJSONError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "failed to list tokens")
+		return
+	}
+	tokens, err := db.ListTokensByCampaignPaged(h.DB, id, perPage, (page-1)*perPage)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "api list tokens", "error", err)
 		renderJSONError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "failed to list tokens")
 		return
 	}
 
-	page, perPage := paginate(r)
-	total := len(tokens)
-	start := (page - 1) * perPage
-	if start > total {
-		start = total
+	tokenIDs := make([]string, len(tokens))
+	for i, t := range tokens {
+		tokenIDs[i] = t.ID
 	}
-	end := start + perPage
-	if end > total {
-		end = total
+	jobs, err := db.ListJobsByTokens(h.DB, tokenIDs)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "api list token jobs", "error", err)
+		renderJSONError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "failed to list jobs")
+		return
+	}
+	// Jobs come back oldest first, so the last one seen per token wins.
+	latestJob := make(map[string]*model.Job, len(jobs))
+	for i := range jobs {
+		latestJob[jobs[i].TokenID] = &jobs[i]
 	}
-	slice := tokens[start:end]
 
-	result := make([]apiToken, len(slice))
-	for i, t := range slice {
+	result := make([]apiToken, len(tokens))
+	for i, t := range tokens {
 		downloadURL := h.Cfg.BaseURL + "/d/" + t.ID
-		result[i] = tokenToAPI(&t, downloadURL)
+		result[i] = tokenToAPI(&t, downloadURL, latestJob[t.ID])
 	}
 
-	renderJSON(w, http.StatusOK, paginatedResult{
-		Data:    result,
-		Total:   total,
-		Page:    page,
-		PerPage: perPage,
-	})
+	h.renderPaginated(w, r, result, total, page, perPage)
 }
 
 // APICampaignAddRecipients - POST /api/v1/campaigns/{id}/recipients
@@ -442,7 +729,7 @@ func (h *Handler) APICampaignAddRecipients(w http.ResponseWriter, r *http.Reques
 			ExpiresAt:    campaign.ExpiresAt,
 		}
 		if err := db.CreateToken(h.DB, token); err != nil {
-			slog.Error("api add recipient token", "error", err, "recipient_id", rid)
+			slog.ErrorContext(r.Context(), "api add recipient token", "error", err, "recipient_id", rid)
 			skipped++
 			continue
 		}
@@ -452,9 +739,10 @@ func (h *Handler) APICampaignAddRecipients(w http.ResponseWriter, r *http.Reques
 				JobType:    jobType,
 				CampaignID: campaign.ID,
 				TokenID:    token.ID,
+				RequestID:  logging.RequestID(r.Context()),
 			}
 			if err := db.EnqueueJob(h.DB, job); err != nil {
-				slog.Error("api enqueue watermark job for new token", "error", err, "token", token.ID)
+				slog.ErrorContext(r.Context(), "api enqueue watermark job for new token", "error", err, "token", token.ID)
 			}
 		}
 		added++
@@ -463,6 +751,9 @@ func (h *Handler) APICampaignAddRecipients(w http.ResponseWriter, r *http.Reques
 	if added > 0 && (campaign.State == "READY" || campaign.State == "PARTIAL" || campaign.State == "FAILED") {
 		db.UpdateCampaignState(h.DB, campaign.ID, "PROCESSING")
 	}
+	if added > 0 && campaign.State == "DRAFT" && campaign.ApprovedAt != nil {
+		db.ClearCampaignApproval(h.DB, campaign.ID)
+	}
 
 	renderJSON(w, http.StatusOK, map[string]int{"added": added, "skipped": skipped})
 }
@@ -492,3 +783,130 @@ func (h *Handler) APICampaignRevokeToken(w http.ResponseWriter, r *http.Request)
 
 	w.WriteHeader(http.StatusNoContent)
 }
+
+// APICampaignReissueToken - POST /api/v1/campaigns/{id}/tokens/{tokenID}/reissue
+func (h *Handler) APICampaignReissueToken(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	tokenID := chi.URLParam(r, "tokenID")
+	accountID := auth.AccountFromContext(r.Context())
+
+	campaign, err := db.GetCampaign(h.DB, id)
+	if err != nil {
+		renderJSONError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "failed to get campaign")
+		return
+	}
+	if campaign == nil {
+		renderJSONError(w, http.StatusNotFound, "NOT_FOUND", "campaign not found")
+		return
+	}
+	if campaign.AccountID != accountID && !auth.IsAdmin(r.Context()) {
+		renderJSONError(w, http.StatusNotFound, "NOT_FOUND", "campaign not found")
+		return
+	}
+
+	old, err := db.GetToken(h.DB, tokenID)
+	if err != nil {
+		renderJSONError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "failed to get token")
+		return
+	}
+	if old == nil || old.CampaignID != id {
+		renderJSONError(w, http.StatusNotFound, "NOT_FOUND", "token not found")
+		return
+	}
+	if !canReissue(campaign.State) {
+		renderJSONError(w, http.StatusConflict, "CONFLICT", "cannot reissue tokens for a campaign in state "+campaign.State)
+		return
+	}
+	if live, err := db.HasLiveToken(h.DB, id, old.RecipientID, old.ID); err != nil {
+		renderJSONError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "failed to check tokens")
+		return
+	} else if live {
+		renderJSONError(w, http.StatusConflict, "CONFLICT", "recipient already has a newer token")
+		return
+	}
+
+	rec, err := db.GetRecipient(h.DB, old.RecipientID)
+	if err != nil || rec == nil {
+		renderJSONError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "recipient not found")
+		return
+	}
+
+	token, err := h.reissueToken(r.Context(), campaign, old)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "api reissue token", "error", err, "token", tokenID)
+		renderJSONError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "failed to reissue token")
+		return
+	}
+	db.InsertAuditLog(h.DB, accountID, "token_reissued", "token", token.ID, "replaces "+tokenID, r.RemoteAddr)
+
+	token.CreatedAt = time.Now()
+	tw := model.TokenWithRecipient{
+		DownloadToken:  *token,
+		RecipientName:  rec.Name,
+		RecipientEmail: rec.Email,
+		RecipientOrg:   rec.Org,
+	}
+	job, _ := db.GetJobByToken(h.DB, token.ID)
+	renderJSON(w, http.StatusCreated, tokenToAPI(&tw, h.Cfg.BaseURL+"/d/"+token.ID, job))
+}
+
+// APITokenEvents - GET /api/v1/campaigns/{id}/tokens/{tokenID}/events
+func (h *Handler) APITokenEvents(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	tokenID := chi.URLParam(r, "tokenID")
+	accountID := auth.AccountFromContext(r.Context())
+
+	campaign, err := db.GetCampaign(h.DB, id)
+	if err != nil {
+		renderJSONError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "failed to get campaign")
+		return
+	}
+	if campaign == nil {
+		renderJSONError(w, http.StatusNotFound, "NOT_FOUND", "campaign not found")
+		return
+	}
+	if campaign.AccountID != accountID && !auth.IsAdmin(r.Context()) {
+		renderJSONError(w, http.StatusNotFound, "NOT_FOUND", "campaign not found")
+		return
+	}
+
+	token, err := db.GetToken(h.DB, tokenID)
+	if err != nil {
+		renderJSONError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "failed to get token")
+		return
+	}
+	if token == nil || token.CampaignID != id {
+		renderJSONError(w, http.StatusNotFound, "NOT_FOUND", "token not found")
+		return
+	}
+
+	page, perPage := paginate(r)
+	total, err := db.CountDownloadEventsByToken(h.DB, tokenID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "api count token events", "error", err)
+		renderJSONError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "failed to list events")
+		return
+	}
+	events, err := db.ListDownloadEventsByTokenPaged(h.DB, tokenID, perPage, (page-1)*perPage)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "api list token events", "error", err)
+		renderJSONError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "failed to list events")
+		return
+	}
+
+	result := make([]apiDownloadEvent, 0, len(events))
+	for _, e := range events {
+		result = append(result, apiDownloadEvent{
+			ID:           e.ID,
+			TokenID:      e.TokenID,
+			RecipientID:  e.RecipientID,
+			IPAddress:    e.IPAddress,
+			UserAgent:    e.UserAgent,
+			Country:      e.Country,
+			City:         e.City,
+			DownloadedAt: e.CreatedAt.UTC().Format(time.RFC3339),
+		})
+	}
+
+	h.renderPaginated(w, r, result, total, page, perPage)
+}
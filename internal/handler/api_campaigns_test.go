@@ -0,0 +1,481 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/YannKr/downloadonce/internal/db"
+	"github.com/YannKr/downloadonce/internal/diskstat"
+	"github.com/YannKr/downloadonce/internal/model"
+)
+
+func TestAPICampaignCancel(t *testing.T) {
+	h := newTestHandler(t)
+	seedAccount(t, h.DB, "acc", "member")
+	tokens := seedCampaign(t, h.DB, "acc", "camp", "PROCESSING", "r1", "r2", "r3")
+
+	// r1 finished, r2 is being watermarked, r3 is still queued.
+	for i, state := range []string{"COMPLETED", "RUNNING", "PENDING"} {
+		job := &model.Job{ID: "job-" + tokens[i], JobType: "watermark_image", CampaignID: "camp", TokenID: tokens[i]}
+		if err := db.EnqueueJob(h.DB, job); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := h.DB.Exec(`UPDATE jobs SET state = ? WHERE id = ?`, state, job.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := h.DB.Exec(`UPDATE download_tokens SET state = 'ACTIVE' WHERE id = ?`, tokens[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	r := chi.NewRouter()
+	r.Post("/api/v1/campaigns/{id}/cancel", h.APICampaignCancel)
+	r.Post("/api/v1/campaigns/{id}/publish", h.APICampaignPublish)
+	post := func(path, account string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, asAccount(httptest.NewRequest("POST", path, nil), account, "member"))
+		return rec
+	}
+
+	seedAccount(t, h.DB, "other", "member")
+	if rec := post("/api/v1/campaigns/camp/cancel", "other"); rec.Code != http.StatusNotFound {
+		t.Errorf("other account: status = %d, want 404", rec.Code)
+	}
+
+	if rec := post("/api/v1/campaigns/camp/cancel", "acc"); rec.Code != http.StatusOK {
+		t.Fatalf("cancel: status = %d, body %s", rec.Code, rec.Body)
+	}
+	if c, _ := db.GetCampaign(h.DB, "camp"); c.State != "DRAFT" {
+		t.Errorf("campaign state = %s, want DRAFT", c.State)
+	}
+	if j, _ := db.GetJob(h.DB, "job-"+tokens[2]); j != nil {
+		t.Errorf("queued job still present: %+v", j)
+	}
+	if j, _ := db.GetJob(h.DB, "job-"+tokens[1]); j == nil {
+		t.Error("running job was deleted; the worker should drop it")
+	}
+	if tok, _ := db.GetToken(h.DB, tokens[0]); tok.State != "ACTIVE" {
+		t.Errorf("finished token state = %s, want ACTIVE", tok.State)
+	}
+
+	if rec := post("/api/v1/campaigns/camp/cancel", "acc"); rec.Code != http.StatusConflict {
+		t.Errorf("second cancel: status = %d, want 409", rec.Code)
+	}
+
+	// Publishing again only queues the tokens that were not finished.
+	if _, err := h.DB.Exec(`DELETE FROM jobs WHERE id = ?`, "job-"+tokens[1]); err != nil {
+		t.Fatal(err)
+	}
+	if rec := post("/api/v1/campaigns/camp/publish", "acc"); rec.Code != http.StatusOK {
+		t.Fatalf("republish: status = %d, body %s", rec.Code, rec.Body)
+	}
+	var n int
+	if err := h.DB.QueryRow(`SELECT COUNT(*) FROM jobs WHERE campaign_id = 'camp' AND state = 'PENDING' AND token_id != ?`, tokens[0]).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("republish queued %d jobs, want 2", n)
+	}
+	if err := h.DB.QueryRow(`SELECT COUNT(*) FROM jobs WHERE token_id = ? AND state = 'PENDING'`, tokens[0]).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Errorf("finished token was queued again")
+	}
+}
+
+func TestAPICampaignCreateUsesDefaultGroup(t *testing.T) {
+	h := newTestHandler(t)
+	seedAccount(t, h.DB, "acc", "member")
+	seedCampaign(t, h.DB, "acc", "seed", "DRAFT", "r1", "r2", "r3")
+	if err := db.CreateRecipientGroup(h.DB, "grp", "acc", "Press", ""); err != nil {
+		t.Fatal(err)
+	}
+	for _, rid := range []string{"r1", "r3"} {
+		if err := db.AddGroupMember(h.DB, "grp", rid); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	create := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/api/v1/campaigns", strings.NewReader(body))
+		h.APICampaignCreate(rec, asAccount(req, "acc", "member"))
+		return rec
+	}
+
+	if rec := create(`{"name":"no default","asset_id":"seed-asset"}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("without default group: status = %d, want 400", rec.Code)
+	}
+
+	if err := db.SetAccountDefaultGroup(h.DB, "acc", "grp"); err != nil {
+		t.Fatal(err)
+	}
+	rec := create(`{"name":"to default","asset_id":"seed-asset"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp struct {
+		ID string `json:"id"`
+	}
+	json.NewDecoder(rec.Body).Decode(&resp)
+	tokens, err := db.ListTokensByCampaign(h.DB, resp.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]bool{}
+	for _, tok := range tokens {
+		got[tok.RecipientID] = true
+	}
+	if len(tokens) != 2 || !got["r1"] || !got["r3"] {
+		t.Errorf("tokens for %v, want r1 and r3", got)
+	}
+
+	// Explicit recipients win over the default group.
+	rec = create(`{"name":"explicit","asset_id":"seed-asset","recipient_ids":["r2"]}`)
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if tokens, _ := db.ListTokensByCampaign(h.DB, resp.ID); len(tokens) != 1 || tokens[0].RecipientID != "r2" {
+		t.Errorf("explicit recipients not honoured: %+v", tokens)
+	}
+
+	// The new-campaign form pre-checks the default group.
+	rec = httptest.NewRecorder()
+	h.CampaignNewForm(rec, asAccount(httptest.NewRequest("GET", "/campaigns/new", nil), "acc", "member"))
+	if !regexp.MustCompile(`value="grp"[^>]*checked`).MatchString(rec.Body.String()) {
+		t.Error("default group not pre-checked on the new campaign form")
+	}
+}
+
+func TestAPICampaignCreateLimit(t *testing.T) {
+	h := newTestHandler(t)
+	h.Cfg.MaxCampaignsPerAccount = 2
+	seedAccount(t, h.DB, "acc", "member")
+	seedCampaign(t, h.DB, "acc", "seed", "READY", "r1")
+
+	create := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/api/v1/campaigns",
+			strings.NewReader(`{"name":"c","asset_id":"seed-asset","recipient_ids":["r1"]}`))
+		h.APICampaignCreate(rec, asAccount(req, "acc", "member"))
+		return rec
+	}
+
+	// One existing campaign: the second is allowed, the third is not.
+	if rec := create(); rec.Code != http.StatusCreated {
+		t.Fatalf("at limit-1: status = %d: %s", rec.Code, rec.Body.String())
+	}
+	rec := create()
+	if rec.Code != http.StatusConflict || !strings.Contains(rec.Body.String(), "CAMPAIGN_LIMIT") {
+		t.Fatalf("at limit: status = %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// Archived campaigns don't count.
+	if err := db.ArchiveCampaign(h.DB, "seed"); err != nil {
+		t.Fatal(err)
+	}
+	if rec := create(); rec.Code != http.StatusCreated {
+		t.Fatalf("after archiving: status = %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// A per-account override replaces the global limit; 0 lifts it.
+	zero := 0
+	if err := db.SetAccountMaxCampaigns(h.DB, "acc", &zero); err != nil {
+		t.Fatal(err)
+	}
+	if rec := create(); rec.Code != http.StatusCreated {
+		t.Fatalf("with unlimited override: status = %d", rec.Code)
+	}
+	three := 3
+	db.SetAccountMaxCampaigns(h.DB, "acc", &three)
+	if rec := create(); rec.Code != http.StatusConflict {
+		t.Fatalf("with override 3 and 3 active: status = %d, want 409", rec.Code)
+	}
+}
+
+func TestAPICampaignPublishDiskPreflight(t *testing.T) {
+	h := newTestHandler(t)
+	h.Cfg.WMCompressionFactor = 0.9
+	h.DiskCache = diskstat.New(h.Cfg.DataDir, time.Hour)
+	h.DiskCache.Refresh()
+	seedAccount(t, h.DB, "acc", "member")
+	seedCampaign(t, h.DB, "acc", "camp", "DRAFT", "r1", "r2")
+
+	r := chi.NewRouter()
+	r.Post("/api/v1/campaigns/{id}/publish", h.APICampaignPublish)
+	publish := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, asAccount(httptest.NewRequest("POST", "/api/v1/campaigns/camp/publish", nil), "acc", "member"))
+		return rec
+	}
+	queued := func() int {
+		var n int
+		h.DB.QueryRow(`SELECT COUNT(*) FROM jobs WHERE campaign_id = 'camp'`).Scan(&n)
+		return n
+	}
+
+	// Two copies of an asset larger than any test disk.
+	if _, err := h.DB.Exec(`UPDATE assets SET file_size_bytes = ? WHERE id = 'camp-asset'`, int64(1)<<55); err != nil {
+		t.Fatal(err)
+	}
+	rec := publish()
+	if rec.Code != http.StatusInsufficientStorage || !strings.Contains(rec.Body.String(), "needs about") {
+		t.Fatalf("oversized publish: status = %d, body %s", rec.Code, rec.Body)
+	}
+	if n := queued(); n != 0 {
+		t.Errorf("refused publish queued %d jobs", n)
+	}
+
+	// At the block threshold even a small publish is refused.
+	if _, err := h.DB.Exec(`UPDATE assets SET file_size_bytes = 1024 WHERE id = 'camp-asset'`); err != nil {
+		t.Fatal(err)
+	}
+	h.Cfg.DiskWarnBlockPct = 100
+	if rec := publish(); rec.Code != http.StatusInsufficientStorage {
+		t.Fatalf("publish at block level: status = %d, body %s", rec.Code, rec.Body)
+	}
+
+	h.Cfg.DiskWarnBlockPct = 0
+	if rec := publish(); rec.Code != http.StatusOK {
+		t.Fatalf("publish with space: status = %d, body %s", rec.Code, rec.Body)
+	}
+	if n := queued(); n != 2 {
+		t.Errorf("queued %d jobs, want 2", n)
+	}
+}
+
+func TestCampaignInvisibleSizeCheck(t *testing.T) {
+	h := newTestHandler(t)
+	seedAccount(t, h.DB, "acc", "member")
+	seedCampaign(t, h.DB, "acc", "camp", "DRAFT", "r1")
+	if _, err := h.DB.Exec(`UPDATE assets SET resolution_w = 200, resolution_h = 40 WHERE id = 'camp-asset'`); err != nil {
+		t.Fatal(err)
+	}
+
+	create := func(invisible bool) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/api/v1/campaigns", strings.NewReader(fmt.Sprintf(
+			`{"name":"c","asset_id":"camp-asset","recipient_ids":["r1"],"invisible_wm":%v}`, invisible)))
+		h.APICampaignCreate(rec, asAccount(req, "acc", "member"))
+		return rec
+	}
+	rec := create(true)
+	if rec.Code != http.StatusUnprocessableEntity || !strings.Contains(rec.Body.String(), "IMAGE_TOO_SMALL") ||
+		!strings.Contains(rec.Body.String(), "96x96") {
+		t.Fatalf("create with invisible: status = %d: %s", rec.Code, rec.Body)
+	}
+	if rec := create(false); rec.Code != http.StatusCreated {
+		t.Fatalf("create without invisible: status = %d: %s", rec.Code, rec.Body)
+	}
+
+	// The seeded campaign predates the check and is refused at publish.
+	r := chi.NewRouter()
+	r.Post("/api/v1/campaigns/{id}/publish", h.APICampaignPublish)
+	r.Post("/campaigns/{id}/publish", h.CampaignPublish)
+	publish := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, asAccount(httptest.NewRequest("POST", path, nil), "acc", "member"))
+		return rec
+	}
+	if rec := publish("/api/v1/campaigns/camp/publish"); rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("api publish: status = %d: %s", rec.Code, rec.Body)
+	}
+	if rec := publish("/campaigns/camp/publish"); rec.Code != http.StatusSeeOther {
+		t.Fatalf("web publish: status = %d", rec.Code)
+	}
+	if c, _ := db.GetCampaign(h.DB, "camp"); c.State != "DRAFT" {
+		t.Errorf("state after refused publish = %s, want DRAFT", c.State)
+	}
+
+	if _, err := h.DB.Exec(`UPDATE assets SET resolution_w = 200, resolution_h = 48 WHERE id = 'camp-asset'`); err != nil {
+		t.Fatal(err)
+	}
+	if rec := publish("/api/v1/campaigns/camp/publish"); rec.Code != http.StatusOK {
+		t.Fatalf("publish at 200x48: status = %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestAPICampaignTokenListJobState(t *testing.T) {
+	h := newTestHandler(t)
+	seedAccount(t, h.DB, "acc", "member")
+	tokens := seedCampaign(t, h.DB, "acc", "camp", "PROCESSING", "r1", "r2", "r3")
+
+	// r1's first attempt failed and a retry is now running; r2 failed for
+	// good; r3 never had a job.
+	jobs := []struct {
+		id, token, state, errMsg string
+		progress                 int
+	}{
+		{"job-1", tokens[0], "FAILED", "ffmpeg crashed", 10},
+		{"job-2", tokens[0], "RUNNING", "", 42},
+		{"job-3", tokens[1], "FAILED", "disk full", 0},
+	}
+	for _, j := range jobs {
+		if err := db.EnqueueJob(h.DB, &model.Job{ID: j.id, JobType: "watermark_image", CampaignID: "camp", TokenID: j.token}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := h.DB.Exec(`UPDATE jobs SET state = ?, progress = ?, error_message = NULLIF(?, '') WHERE id = ?`,
+			j.state, j.progress, j.errMsg, j.id); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(2 * time.Millisecond) // distinct created_at
+	}
+
+	r := chi.NewRouter()
+	r.Get("/api/v1/campaigns/{id}/tokens", h.APICampaignTokenList)
+	req := httptest.NewRequest("GET", "/api/v1/campaigns/camp/tokens", nil)
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, asAccount(req, "acc", "member"))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	var body struct {
+		Data []apiToken `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	byID := map[string]apiToken{}
+	for _, tok := range body.Data {
+		byID[tok.ID] = tok
+	}
+
+	running := byID[tokens[0]]
+	if running.JobState == nil || *running.JobState != "RUNNING" || running.JobProgress == nil || *running.JobProgress != 42 {
+		t.Errorf("processing token: job_state=%v job_progress=%v, want RUNNING 42", running.JobState, running.JobProgress)
+	}
+	if running.JobError != nil {
+		t.Errorf("processing token: job_error = %q, want the latest job's (none)", *running.JobError)
+	}
+	failed := byID[tokens[1]]
+	if failed.JobState == nil || *failed.JobState != "FAILED" || failed.JobError == nil || *failed.JobError != "disk full" {
+		t.Errorf("failed token: job_state=%v job_error=%v", failed.JobState, failed.JobError)
+	}
+	if none := byID[tokens[2]]; none.JobState != nil || none.JobProgress != nil {
+		t.Errorf("token without job: job_state=%v job_progress=%v, want null", none.JobState, none.JobProgress)
+	}
+}
+
+func TestAPICampaignTokenListPaged(t *testing.T) {
+	h := newTestHandler(t)
+	seedAccount(t, h.DB, "acc", "member")
+	tokens := seedCampaign(t, h.DB, "acc", "camp", "PROCESSING", "r1", "r2", "r3", "r4", "r5")
+	if err := db.EnqueueJob(h.DB, &model.Job{ID: "job-5", JobType: "watermark_image", CampaignID: "camp", TokenID: tokens[4]}); err != nil {
+		t.Fatal(err)
+	}
+
+	r := chi.NewRouter()
+	r.Get("/api/v1/campaigns/{id}/tokens", h.APICampaignTokenList)
+	var hdr http.Header
+	get := func(query string) (body struct {
+		Data       []apiToken `json:"data"`
+		Total      int        `json:"total"`
+		Page       int        `json:"page"`
+		PerPage    int        `json:"per_page"`
+		TotalPages int        `json:"total_pages"`
+	}) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, asAccount(httptest.NewRequest("GET", "/api/v1/campaigns/camp/tokens"+query, nil), "acc", "member"))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d: %s", query, rec.Code, rec.Body)
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+		hdr = rec.Header()
+		return body
+	}
+
+	middle := get("?page=2&per_page=2")
+	if middle.TotalPages != 3 || hdr.Get("X-Total-Count") != "5" {
+		t.Errorf("total_pages = %d, X-Total-Count = %q; want 3 and 5", middle.TotalPages, hdr.Get("X-Total-Count"))
+	}
+	const base = "http://dl.test/api/v1/campaigns/camp/tokens"
+	wantLink := `<` + base + `?page=1&per_page=2>; rel="first", ` +
+		`<` + base + `?page=1&per_page=2>; rel="prev", ` +
+		`<` + base + `?page=3&per_page=2>; rel="next", ` +
+		`<` + base + `?page=3&per_page=2>; rel="last"`
+	if got := hdr.Get("Link"); got != wantLink {
+		t.Errorf("Link = %s\nwant   %s", got, wantLink)
+	}
+	if all := get("?per_page=1000"); all.PerPage != maxPerPage || all.TotalPages != 1 {
+		t.Errorf("per_page=1000: per_page = %d, total_pages = %d; want clamped to %d", all.PerPage, all.TotalPages, maxPerPage)
+	}
+	if strings.Contains(hdr.Get("Link"), `rel="prev"`) || strings.Contains(hdr.Get("Link"), `rel="next"`) {
+		t.Errorf("single page Link = %s, want only first and last", hdr.Get("Link"))
+	}
+
+	last := get("?page=3&per_page=2")
+	if last.Total != 5 || last.Page != 3 || last.PerPage != 2 || len(last.Data) != 1 {
+		t.Fatalf("last page = %+v", last)
+	}
+	if last.Data[0].ID != tokens[4] || last.Data[0].JobState == nil || *last.Data[0].JobState != "PENDING" {
+		t.Errorf("last token = %+v, want %s with its pending job", last.Data[0], tokens[4])
+	}
+	if beyond := get("?page=9&per_page=2"); beyond.Total != 5 || len(beyond.Data) != 0 {
+		t.Errorf("page past the end = %+v, want no tokens", beyond)
+	}
+}
+
+func TestCampaignCreateInvisibleOverrides(t *testing.T) {
+	h := newTestHandler(t)
+	seedAccount(t, h.DB, "acc", "member")
+	seedCampaign(t, h.DB, "acc", "seed", "DRAFT", "r1")
+
+	form := func(channels, scale string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.CampaignCreate(rec, asAccount(postForm("/campaigns", url.Values{
+			"name": {channels + scale}, "asset_id": {"seed-asset"}, "recipient_ids": {"r1"},
+			"wm_channels": {channels}, "wm_scale": {scale}, "wm_self_verify": {"off"},
+		}), "acc", "member"))
+		return rec
+	}
+	for _, tc := range [][2]string{{"W", ""}, {"", "abc"}, {"", "-1"}} {
+		rec := form(tc[0], tc[1])
+		if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "Invalid invisible watermark settings") {
+			t.Errorf("form %q/%q: status = %d, want the form back with an error", tc[0], tc[1], rec.Code)
+		}
+	}
+	if rec := form("y, u", "24"); rec.Code != http.StatusSeeOther {
+		t.Fatalf("form: status = %d: %s", rec.Code, rec.Body.String())
+	}
+	var id string
+	if err := h.DB.QueryRow(`SELECT id FROM campaigns WHERE name = 'y, u24'`).Scan(&id); err != nil {
+		t.Fatal(err)
+	}
+	c, err := db.GetCampaign(h.DB, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.WMChannels != "Y,U" || c.WMScale == nil || *c.WMScale != 24 {
+		t.Errorf("stored %q/%v, want Y,U/24", c.WMChannels, c.WMScale)
+	}
+	if c.SelfVerify == nil || *c.SelfVerify {
+		t.Errorf("self-verify = %v, want an off override", c.SelfVerify)
+	}
+
+	api := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/api/v1/campaigns", strings.NewReader(body))
+		h.APICampaignCreate(rec, asAccount(req, "acc", "member"))
+		return rec
+	}
+	if rec := api(`{"name":"a","asset_id":"seed-asset","recipient_ids":["r1"],"wm_channels":"W"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("api bad channels: status = %d, want 400", rec.Code)
+	}
+	rec := api(`{"name":"b","asset_id":"seed-asset","recipient_ids":["r1"],"wm_channels":"v","wm_self_verify":true}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("api channels: status = %d, want 201", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), `"wm_self_verify":true`) {
+		t.Errorf("api response lacks the self-verify override: %s", rec.Body.String())
+	}
+}
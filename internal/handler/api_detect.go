@@ -2,17 +2,22 @@ package handler
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"log/slog"
+	"mime"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
 	"github.com/YannKr/downloadonce/internal/auth"
 	"github.com/YannKr/downloadonce/internal/db"
+	"github.com/YannKr/downloadonce/internal/logging"
 )
 
 type apiDetectResult struct {
@@ -33,16 +38,52 @@ type detectFinding struct {
 	RecipientName  *string `json:"recipient_name"`
 	RecipientEmail *string `json:"recipient_email"`
 	Confidence     *string `json:"confidence"`
+	// Variants are the per-file detections of a combined job.
+	Variants []detectVariantFinding `json:"variants,omitempty"`
+}
+
+type detectVariantFinding struct {
+	File       string  `json:"file"`
+	PayloadHex string  `json:"payload_hex,omitempty"`
+	TokenID    string  `json:"token_id,omitempty"`
+	MatchType  string  `json:"match_type,omitempty"`
+	Confidence float64 `json:"confidence,omitempty"`
+}
+
+// detectExts are the file extensions accepted for leak detection.
+var detectExts = map[string]bool{
+	".jpg": true, ".jpeg": true, ".png": true, ".webp": true,
+	".heic": true, ".heif": true, ".avif": true,
+	".mp4": true, ".mkv": true, ".avi": true, ".mov": true, ".webm": true,
 }
 
 // APIDetectSubmit - POST /api/v1/detect
+//
+// Takes the leaked file as a multipart "file" part, or a URL to fetch it from
+// as a "url" form field or a JSON body {"url": "..."}.
 func (h *Handler) APIDetectSubmit(w http.ResponseWriter, r *http.Request) {
 	accountID := auth.AccountFromContext(r.Context())
 
+	if ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); ct == "application/json" {
+		var body struct {
+			URL string `json:"url"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || strings.TrimSpace(body.URL) == "" {
+			renderJSONError(w, http.StatusBadRequest, "BAD_REQUEST", "expected a JSON body with a url field")
+			return
+		}
+		h.apiDetectURL(w, r, accountID, strings.TrimSpace(body.URL))
+		return
+	}
+
 	if err := r.ParseMultipartForm(2 << 30); err != nil {
 		renderJSONError(w, http.StatusBadRequest, "BAD_REQUEST", "failed to parse multipart form")
 		return
 	}
+	if rawURL := strings.TrimSpace(r.FormValue("url")); rawURL != "" {
+		h.apiDetectURL(w, r, accountID, rawURL)
+		return
+	}
 
 	file, header, err := r.FormFile("file")
 	if err != nil {
@@ -52,11 +93,7 @@ func (h *Handler) APIDetectSubmit(w http.ResponseWriter, r *http.Request) {
 	defer file.Close()
 
 	ext := strings.ToLower(filepath.Ext(header.Filename))
-	allowed := map[string]bool{
-		".jpg": true, ".jpeg": true, ".png": true, ".webp": true,
-		".mp4": true, ".mkv": true, ".avi": true, ".mov": true, ".webm": true,
-	}
-	if !allowed[ext] {
+	if !detectExts[ext] {
 		renderJSONError(w, http.StatusBadRequest, "BAD_REQUEST", "unsupported file type")
 		return
 	}
@@ -65,7 +102,7 @@ func (h *Handler) APIDetectSubmit(w http.ResponseWriter, r *http.Request) {
 
 	detectDir := filepath.Join(h.Cfg.DataDir, "detect", jobID)
 	if err := os.MkdirAll(detectDir, 0755); err != nil {
-		slog.Error("create detect dir", "error", err)
+		slog.ErrorContext(r.Context(), "create detect dir", "error", err)
 		renderJSONError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "failed to create job directory")
 		return
 	}
@@ -73,24 +110,44 @@ func (h *Handler) APIDetectSubmit(w http.ResponseWriter, r *http.Request) {
 	inputPath := filepath.Join(detectDir, "input"+ext)
 	dst, err := os.Create(inputPath)
 	if err != nil {
-		slog.Error("create detect file", "error", err)
+		slog.ErrorContext(r.Context(), "create detect file", "error", err)
 		renderJSONError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "failed to create input file")
 		return
 	}
 	defer dst.Close()
 
 	if _, err := io.Copy(dst, file); err != nil {
-		slog.Error("save detect file", "error", err)
+		slog.ErrorContext(r.Context(), "save detect file", "error", err)
 		renderJSONError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "failed to save file")
 		return
 	}
 
-	if err := db.EnqueueDetectJob(h.DB, jobID, accountID, inputPath, "detect"); err != nil {
-		slog.Error("enqueue detect job", "error", err)
+	if err := db.EnqueueDetectJob(h.DB, jobID, accountID, inputPath, "detect", logging.RequestID(r.Context())); err != nil {
+		slog.ErrorContext(r.Context(), "enqueue detect job", "error", err)
 		renderJSONError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "failed to enqueue job")
 		return
 	}
+	h.renderDetectAccepted(w, jobID)
+}
 
+// apiDetectURL starts a detect job on the file at rawURL.
+func (h *Handler) apiDetectURL(w http.ResponseWriter, r *http.Request, accountID, rawURL string) {
+	jobID, err := h.enqueueDetectURL(r.Context(), accountID, rawURL)
+	if err != nil {
+		var ferr *fetchError
+		if errors.As(err, &ferr) {
+			renderJSONError(w, http.StatusBadRequest, "FETCH_FAILED", ferr.Error())
+			return
+		}
+		slog.ErrorContext(r.Context(), "detect from url", "error", err)
+		renderJSONError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "failed to start detection")
+		return
+	}
+	h.renderDetectAccepted(w, jobID)
+}
+
+// renderDetectAccepted answers 202 with the newly queued detect job.
+func (h *Handler) renderDetectAccepted(w http.ResponseWriter, jobID string) {
 	job, _ := db.GetJob(h.DB, jobID)
 	result := apiDetectResult{
 		JobID:     jobID,
@@ -101,6 +158,113 @@ func (h *Handler) APIDetectSubmit(w http.ResponseWriter, r *http.Request) {
 	renderJSON(w, http.StatusAccepted, result)
 }
 
+// APIDetectCombine - POST /api/v1/detect/combine
+//
+// Accepts several variants of one leaked file as repeated "file" parts,
+// streamed to disk. The detect job votes their payloads into one result.
+func (h *Handler) APIDetectCombine(w http.ResponseWriter, r *http.Request) {
+	accountID := auth.AccountFromContext(r.Context())
+
+	mr, err := r.MultipartReader()
+	if err != nil {
+		renderJSONError(w, http.StatusBadRequest, "BAD_REQUEST", "expected multipart/form-data")
+		return
+	}
+
+	jobID := uuid.New().String()
+	detectDir := filepath.Join(h.Cfg.DataDir, "detect", jobID)
+	if err := os.MkdirAll(detectDir, 0755); err != nil {
+		slog.ErrorContext(r.Context(), "create detect dir", "error", err)
+		renderJSONError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "failed to create job directory")
+		return
+	}
+	fail := func(status int, code, msg string) {
+		os.RemoveAll(detectDir)
+		renderJSONError(w, status, code, msg)
+	}
+
+	n := 0
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fail(http.StatusBadRequest, "BAD_REQUEST", "malformed multipart body")
+			return
+		}
+		if part.FormName() != "file" || part.FileName() == "" {
+			part.Close()
+			continue
+		}
+		name := filepath.Base(part.FileName())
+		ext := strings.ToLower(filepath.Ext(name))
+		if !detectExts[ext] {
+			part.Close()
+			fail(http.StatusBadRequest, "BAD_REQUEST", "unsupported file type: "+name)
+			return
+		}
+		n++
+		if max := h.Cfg.DetectCombineMaxFiles; max > 0 && n > max {
+			part.Close()
+			fail(http.StatusBadRequest, "BAD_REQUEST", fmt.Sprintf("at most %d files can be combined", h.Cfg.DetectCombineMaxFiles))
+			return
+		}
+		// Prefix with the part index so equal names do not collide.
+		err = saveDetectInput(filepath.Join(detectDir, fmt.Sprintf("%02d-%s", n, name)), part, h.Cfg.MaxUploadBytes)
+		part.Close()
+		if err == errDetectInputTooLarge {
+			fail(http.StatusRequestEntityTooLarge, "TOO_LARGE", name+" is larger than the upload limit")
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "save detect file", "error", err)
+			fail(http.StatusInternalServerError, "INTERNAL_ERROR", "failed to save file")
+			return
+		}
+	}
+	if n < 2 {
+		fail(http.StatusBadRequest, "BAD_REQUEST", "combine needs at least two file parts")
+		return
+	}
+
+	if err := db.EnqueueDetectJob(h.DB, jobID, accountID, detectDir, "detect", logging.RequestID(r.Context())); err != nil {
+		slog.ErrorContext(r.Context(), "enqueue combined detect job", "error", err)
+		fail(http.StatusInternalServerError, "INTERNAL_ERROR", "failed to enqueue job")
+		return
+	}
+
+	job, _ := db.GetJob(h.DB, jobID)
+	renderJSON(w, http.StatusAccepted, apiDetectResult{
+		JobID:     jobID,
+		State:     "PENDING",
+		CreatedAt: job.CreatedAt.UTC().Format("2006-01-02T15:04:05Z"),
+	})
+}
+
+var errDetectInputTooLarge = errors.New("detect input too large")
+
+// saveDetectInput writes r to path, failing with errDetectInputTooLarge once
+// more than limit bytes arrive (0 = no limit).
+func saveDetectInput(path string, r io.Reader, limit int64) error {
+	dst, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+	if limit > 0 {
+		r = io.LimitReader(r, limit+1)
+	}
+	written, err := io.Copy(dst, r)
+	if err != nil {
+		return err
+	}
+	if limit > 0 && written > limit {
+		return errDetectInputTooLarge
+	}
+	return dst.Close()
+}
+
 // APIDetectGet - GET /api/v1/detect/{jobID}
 func (h *Handler) APIDetectGet(w http.ResponseWriter, r *http.Request) {
 	jobID := chi.URLParam(r, "jobID")
@@ -109,7 +273,7 @@ func (h *Handler) APIDetectGet(w http.ResponseWriter, r *http.Request) {
 
 	job, err := db.GetJob(h.DB, jobID)
 	if err != nil {
-		slog.Error("api get detect job", "error", err)
+		slog.ErrorContext(r.Context(), "api get detect job", "error", err)
 		renderJSONError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "failed to get job")
 		return
 	}
@@ -118,7 +282,7 @@ func (h *Handler) APIDetectGet(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if job.CampaignID != accountID && !isAdmin {
+	if job.AccountID != accountID && !isAdmin {
 		renderJSONError(w, http.StatusNotFound, "NOT_FOUND", "job not found")
 		return
 	}
@@ -141,15 +305,19 @@ func (h *Handler) APIDetectGet(w http.ResponseWriter, r *http.Request) {
 
 	if job.State == "COMPLETED" && job.ResultData != "" {
 		var raw struct {
-			Found          bool   `json:"found"`
-			TokenID        string `json:"token_id"`
-			CampaignID     string `json:"campaign_id"`
-			RecipientName  string `json:"recipient_name"`
-			RecipientEmail string `json:"recipient_email"`
+			Found          bool                   `json:"found"`
+			TokenID        string                 `json:"token_id"`
+			CampaignID     string                 `json:"campaign_id"`
+			RecipientID    string                 `json:"recipient_id"`
+			RecipientName  string                 `json:"recipient_name"`
+			RecipientEmail string                 `json:"recipient_email"`
+			Confidence     float64                `json:"confidence"`
+			Variants       []detectVariantFinding `json:"variants"`
 		}
 		if err := json.Unmarshal([]byte(job.ResultData), &raw); err == nil {
 			finding := &detectFinding{
 				MatchFound: raw.Found,
+				Variants:   raw.Variants,
 			}
 			if raw.TokenID != "" {
 				finding.TokenID = &raw.TokenID
@@ -163,6 +331,13 @@ func (h *Handler) APIDetectGet(w http.ResponseWriter, r *http.Request) {
 			if raw.RecipientEmail != "" {
 				finding.RecipientEmail = &raw.RecipientEmail
 			}
+			if raw.RecipientID != "" {
+				finding.RecipientID = &raw.RecipientID
+			}
+			if raw.Confidence > 0 {
+				c := strconv.FormatFloat(raw.Confidence, 'f', 2, 64)
+				finding.Confidence = &c
+			}
 			result.Result = finding
 		}
 	}
@@ -0,0 +1,69 @@
+package handler
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/YannKr/downloadonce/internal/auth"
+	"github.com/YannKr/downloadonce/internal/db"
+	"github.com/YannKr/downloadonce/internal/model"
+)
+
+type apiKeyInfo struct {
+	ID         string  `json:"id"`
+	Name       string  `json:"name"`
+	KeyPrefix  string  `json:"key_prefix"`
+	Scopes     string  `json:"scopes"`
+	CreatedAt  string  `json:"created_at"`
+	LastUsedAt *string `json:"last_used_at"`
+	ExpiresAt  *string `json:"expires_at"`
+	Current    bool    `json:"current"`
+}
+
+func apiKeyToAPI(k *model.APIKey, currentID string) apiKeyInfo {
+	info := apiKeyInfo{
+		ID:        k.ID,
+		Name:      k.Name,
+		KeyPrefix: "do_" + k.KeyPrefix,
+		Scopes:    k.Scopes,
+		CreatedAt: k.CreatedAt.UTC().Format("2006-01-02T15:04:05Z"),
+		Current:   k.ID == currentID,
+	}
+	if k.LastUsedAt != nil {
+		s := k.LastUsedAt.UTC().Format("2006-01-02T15:04:05Z")
+		info.LastUsedAt = &s
+	}
+	if k.ExpiresAt != nil {
+		s := k.ExpiresAt.UTC().Format("2006-01-02T15:04:05Z")
+		info.ExpiresAt = &s
+	}
+	return info
+}
+
+// APIKeyList — GET /api/v1/keys
+// Lists the account's API keys. "current" is the key making the request.
+// last_used_at is updated at most once a minute per key.
+func (h *Handler) APIKeyList(w http.ResponseWriter, r *http.Request) {
+	accountID := auth.AccountFromContext(r.Context())
+	currentID := auth.APIKeyFromContext(r.Context())
+
+	keys, err := db.ListAPIKeys(h.DB, accountID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "api list keys", "error", err)
+		renderJSONError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "failed to list API keys")
+		return
+	}
+
+	resp := struct {
+		Current *apiKeyInfo  `json:"current"`
+		Data    []apiKeyInfo `json:"data"`
+	}{Data: []apiKeyInfo{}}
+	for i := range keys {
+		info := apiKeyToAPI(&keys[i], currentID)
+		resp.Data = append(resp.Data, info)
+		if info.Current {
+			resp.Current = &info
+		}
+	}
+	renderJSON(w, http.StatusOK, resp)
+}
@@ -2,8 +2,10 @@ package handler
 
 import (
 	"encoding/json"
+	"fmt"
 	"log/slog"
 	"net/http"
+	"strings"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
@@ -47,10 +49,15 @@ func (h *Handler) APIRecipientCreate(w http.ResponseWriter, r *http.Request) {
 		renderJSONError(w, http.StatusBadRequest, "BAD_REQUEST", "name and email are required")
 		return
 	}
+	email, err := h.validateEmail(body.Email)
+	if err != nil {
+		renderJSONError(w, http.StatusBadRequest, "INVALID_EMAIL", err.Error())
+		return
+	}
 
-	rec, err := db.GetOrCreateRecipientByEmail(h.DB, accountID, body.Name, body.Email, body.Org)
+	rec, err := db.GetOrCreateRecipientByEmail(h.DB, accountID, body.Name, email, body.Org)
 	if err != nil {
-		slog.Error("api get/create recipient", "error", err)
+		slog.ErrorContext(r.Context(), "api get/create recipient", "error", err)
 		renderJSONError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "failed to create recipient")
 		return
 	}
@@ -63,7 +70,7 @@ func (h *Handler) APIRecipientCreate(w http.ResponseWriter, r *http.Request) {
 		// New recipient — assign ID and insert
 		rec.ID = uuid.New().String()
 		if err := db.CreateRecipient(h.DB, rec); err != nil {
-			slog.Error("api create recipient", "error", err)
+			slog.ErrorContext(r.Context(), "api create recipient", "error", err)
 			renderJSONError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "failed to create recipient")
 			return
 		}
@@ -74,27 +81,22 @@ func (h *Handler) APIRecipientCreate(w http.ResponseWriter, r *http.Request) {
 }
 
 // APIRecipientList — GET /api/v1/recipients
+//
+// With ?q= only recipients whose name, email or org starts with q are listed.
 func (h *Handler) APIRecipientList(w http.ResponseWriter, r *http.Request) {
-	accountID := auth.AccountFromContext(r.Context())
-	isAdmin := auth.IsAdmin(r.Context())
-
-	recipients, err := db.ListRecipients(h.DB)
+	var recipients []model.Recipient
+	var err error
+	if q := strings.TrimSpace(r.URL.Query().Get("q")); q != "" {
+		recipients, err = db.SearchRecipients(h.DB, recipientScope(r), q, 0)
+	} else {
+		recipients, err = db.ListRecipients(h.DB, recipientScope(r))
+	}
 	if err != nil {
-		slog.Error("api list recipients", "error", err)
+		slog.ErrorContext(r.Context(), "api list recipients", "error", err)
 		renderJSONError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "failed to list recipients")
 		return
 	}
 
-	if !isAdmin {
-		filtered := recipients[:0]
-		for _, rec := range recipients {
-			if rec.AccountID == accountID {
-				filtered = append(filtered, rec)
-			}
-		}
-		recipients = filtered
-	}
-
 	page, perPage := paginate(r)
 	total := len(recipients)
 	start := (page - 1) * perPage
@@ -112,12 +114,7 @@ func (h *Handler) APIRecipientList(w http.ResponseWriter, r *http.Request) {
 		result[i] = recipientToAPI(&rec)
 	}
 
-	renderJSON(w, http.StatusOK, paginatedResult{
-		Data:    result,
-		Total:   total,
-		Page:    page,
-		PerPage: perPage,
-	})
+	h.renderPaginated(w, r, result, total, page, perPage)
 }
 
 // APIRecipientDelete — DELETE /api/v1/recipients/{id}
@@ -135,8 +132,18 @@ func (h *Handler) APIRecipientDelete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	n, err := db.CountTokensByRecipient(h.DB, id)
+	if err != nil {
+		renderJSONError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "failed to check recipient tokens")
+		return
+	}
+	if n > 0 {
+		renderJSONError(w, http.StatusConflict, "CONFLICT", fmt.Sprintf("recipient has %d download token(s); delete their campaigns first", n))
+		return
+	}
+
 	if err := db.DeleteRecipient(h.DB, id); err != nil {
-		slog.Error("api delete recipient", "error", err)
+		slog.ErrorContext(r.Context(), "api delete recipient", "error", err)
 		renderJSONError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "failed to delete recipient")
 		return
 	}
@@ -0,0 +1,108 @@
+package handler
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/YannKr/downloadonce/internal/auth"
+	"github.com/YannKr/downloadonce/internal/db"
+	"github.com/YannKr/downloadonce/internal/model"
+)
+
+type apiWebhookDelivery struct {
+	ID             string     `json:"id"`
+	EventType      string     `json:"event_type"`
+	EventID        string     `json:"event_id"`
+	State          string     `json:"state"`
+	AttemptNumber  int        `json:"attempt_number"`
+	ResponseStatus *int       `json:"response_status"`
+	ErrorMessage   string     `json:"error_message,omitempty"`
+	NextRetryAt    *time.Time `json:"next_retry_at"`
+	DeliveredAt    *time.Time `json:"delivered_at"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+// apiWebhook loads the webhook named in the URL, writing a 404 and returning
+// nil when it does not exist or belongs to another account.
+func (h *Handler) apiWebhook(w http.ResponseWriter, r *http.Request) *model.Webhook {
+	wh, err := db.GetWebhookByID(h.DB, chi.URLParam(r, "id"))
+	if err != nil || wh == nil || (wh.AccountID != auth.AccountFromContext(r.Context()) && !auth.IsAdmin(r.Context())) {
+		renderJSONError(w, http.StatusNotFound, "NOT_FOUND", "webhook not found")
+		return nil
+	}
+	return wh
+}
+
+// APIWebhookDeliveries - GET /api/v1/webhooks/{id}/deliveries
+//
+// Lists the webhook's deliveries, newest first. ?state= (pending, failed,
+// delivered or exhausted) limits the list to one state.
+func (h *Handler) APIWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	wh := h.apiWebhook(w, r)
+	if wh == nil {
+		return
+	}
+	state, ok := parseDeliveryState(r)
+	if !ok {
+		renderJSONError(w, http.StatusBadRequest, "BAD_REQUEST",
+			"state must be one of "+strings.Join(webhookDeliveryStates, ", "))
+		return
+	}
+	page, perPage := paginate(r)
+	total, err := db.CountWebhookDeliveries(h.DB, wh.ID, state)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "api count webhook deliveries", "error", err)
+		renderJSONError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "failed to list deliveries")
+		return
+	}
+	deliveries, err := db.ListWebhookDeliveries(h.DB, wh.ID, state, perPage, (page-1)*perPage)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "api list webhook deliveries", "error", err)
+		renderJSONError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "failed to list deliveries")
+		return
+	}
+
+	result := make([]apiWebhookDelivery, len(deliveries))
+	for i, d := range deliveries {
+		result[i] = apiWebhookDelivery{
+			ID:             d.ID,
+			EventType:      d.EventType,
+			EventID:        d.EventID,
+			State:          d.State,
+			AttemptNumber:  d.AttemptNumber,
+			ResponseStatus: d.ResponseStatus,
+			ErrorMessage:   d.ErrorMessage,
+			NextRetryAt:    d.NextRetryAt,
+			DeliveredAt:    d.DeliveredAt,
+			CreatedAt:      d.CreatedAt,
+		}
+	}
+	h.renderPaginated(w, r, result, total, page, perPage)
+}
+
+// APIWebhookReplayAll - POST /api/v1/webhooks/{id}/replay-all
+//
+// Re-queues every exhausted delivery of the webhook and returns how many were
+// re-queued. Deliveries already pending are left alone, so repeating the call
+// is harmless.
+func (h *Handler) APIWebhookReplayAll(w http.ResponseWriter, r *http.Request) {
+	wh := h.apiWebhook(w, r)
+	if wh == nil {
+		return
+	}
+	n, err := db.ReplayExhaustedWebhookDeliveries(h.DB, wh.ID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "api replay webhook deliveries", "error", err)
+		renderJSONError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "failed to replay deliveries")
+		return
+	}
+	if n > 0 {
+		db.InsertAuditLog(h.DB, auth.AccountFromContext(r.Context()), "webhook_deliveries_replayed", "webhook", wh.ID,
+			fmt.Sprintf("%s (%d deliveries)", wh.URL, n), r.RemoteAddr)
+	}
+	renderJSON(w, http.StatusOK, map[string]any{"replayed": n})
+}
@@ -0,0 +1,101 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/YannKr/downloadonce/internal/db"
+	"github.com/YannKr/downloadonce/internal/model"
+)
+
+func TestAPIWebhookReplayAll(t *testing.T) {
+	h := newTestHandler(t)
+	seedAccount(t, h.DB, "acc", "member")
+	seedAccount(t, h.DB, "other", "member")
+	if err := db.CreateWebhook(h.DB, &model.Webhook{ID: "wh", AccountID: "acc", URL: "http://hook.test", Secret: "s", Events: "download", Enabled: true}); err != nil {
+		t.Fatal(err)
+	}
+	for id, state := range map[string]string{"d1": "exhausted", "d2": "exhausted", "d3": "delivered", "d4": "failed"} {
+		d := &model.WebhookDelivery{ID: id, WebhookID: "wh", EventType: "download", EventID: id, PayloadJSON: "{}", AttemptNumber: 5, State: state}
+		if err := db.CreateWebhookDelivery(h.DB, d); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := chi.NewRouter()
+	r.Get("/api/v1/webhooks/{id}/deliveries", h.APIWebhookDeliveries)
+	r.Post("/api/v1/webhooks/{id}/replay-all", h.APIWebhookReplayAll)
+	do := func(method, path, account string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, asAccount(httptest.NewRequest(method, path, nil), account, "member"))
+		return rec
+	}
+	listed := func(query string) (ids []string, total int) {
+		t.Helper()
+		rec := do("GET", "/api/v1/webhooks/wh/deliveries"+query, "acc")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("list%s: status = %d, body %s", query, rec.Code, rec.Body)
+		}
+		var resp struct {
+			Data  []apiWebhookDelivery `json:"data"`
+			Total int                  `json:"total"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		for _, d := range resp.Data {
+			ids = append(ids, d.ID)
+		}
+		return ids, resp.Total
+	}
+	replay := func() int {
+		t.Helper()
+		rec := do("POST", "/api/v1/webhooks/wh/replay-all", "acc")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("replay-all: status = %d, body %s", rec.Code, rec.Body)
+		}
+		var resp struct{ Replayed int }
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		return resp.Replayed
+	}
+
+	if _, total := listed(""); total != 4 {
+		t.Errorf("unfiltered total = %d, want 4", total)
+	}
+	if ids, total := listed("?state=exhausted"); total != 2 || len(ids) != 2 {
+		t.Errorf("exhausted = %v (total %d), want d1 and d2", ids, total)
+	}
+	if rec := do("GET", "/api/v1/webhooks/wh/deliveries?state=bogus", "acc"); rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown state: status = %d, want 400", rec.Code)
+	}
+	if rec := do("POST", "/api/v1/webhooks/wh/replay-all", "other"); rec.Code != http.StatusNotFound {
+		t.Errorf("other account: status = %d, want 404", rec.Code)
+	}
+
+	if n := replay(); n != 2 {
+		t.Errorf("replayed %d, want 2", n)
+	}
+	for _, id := range []string{"d1", "d2"} {
+		d, _ := db.GetWebhookDelivery(h.DB, id)
+		if d.State != "pending" || d.AttemptNumber != 0 || d.NextRetryAt == nil {
+			t.Errorf("%s after replay: state %s, attempt %d", id, d.State, d.AttemptNumber)
+		}
+	}
+	if d, _ := db.GetWebhookDelivery(h.DB, "d3"); d.State != "delivered" {
+		t.Errorf("delivered delivery changed to %s", d.State)
+	}
+
+	// Re-queued deliveries are not reset again by a second call.
+	if _, err := h.DB.Exec(`UPDATE webhook_deliveries SET attempt_number = 1 WHERE id = 'd1'`); err != nil {
+		t.Fatal(err)
+	}
+	if n := replay(); n != 0 {
+		t.Errorf("second replay re-queued %d, want 0", n)
+	}
+	if d, _ := db.GetWebhookDelivery(h.DB, "d1"); d.AttemptNumber != 1 {
+		t.Errorf("pending delivery was reset: attempt %d", d.AttemptNumber)
+	}
+}
@@ -0,0 +1,168 @@
+package handler
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/YannKr/downloadonce/internal/auth"
+	"github.com/YannKr/downloadonce/internal/db"
+	"github.com/YannKr/downloadonce/internal/model"
+)
+
+// needsApproval reports whether publishing c must wait for an approver
+// (REQUIRE_APPROVAL is on and nobody has signed off yet).
+func (h *Handler) needsApproval(c *model.Campaign) bool {
+	return h.Cfg.RequireApproval && c.ApprovedAt == nil
+}
+
+// canApprove reports whether the signed-in account may approve campaigns.
+// Approval is an admin task.
+func (h *Handler) canApprove(ctx context.Context) bool {
+	return auth.IsAdmin(ctx)
+}
+
+// approvalProblem explains why the signed-in account cannot approve or reject
+// c, or returns "". Nobody approves their own campaign.
+func (h *Handler) approvalProblem(ctx context.Context, c *model.Campaign) string {
+	switch {
+	case !h.canApprove(ctx):
+		return "only approvers can approve campaigns"
+	case c.AccountID == auth.AccountFromContext(ctx):
+		return "a campaign must be approved by someone other than its owner"
+	case c.State != "PENDING_APPROVAL":
+		return "campaign is not awaiting approval"
+	}
+	return ""
+}
+
+// approvalInfo is the sign-off shown on the campaign page.
+type approvalInfo struct {
+	By string // approver's name
+	At time.Time
+}
+
+func (h *Handler) campaignApproval(c *model.Campaign) *approvalInfo {
+	if c.ApprovedAt == nil {
+		return nil
+	}
+	info := &approvalInfo{By: c.ApprovedBy, At: *c.ApprovedAt}
+	if acct, _ := db.GetAccountByID(h.DB, c.ApprovedBy); acct != nil {
+		info.By = acct.Name
+	}
+	return info
+}
+
+// CampaignApprove - POST /campaigns/{id}/approve
+func (h *Handler) CampaignApprove(w http.ResponseWriter, r *http.Request) {
+	h.decideCampaign(w, r, true)
+}
+
+// CampaignReject - POST /campaigns/{id}/reject. The owner may also use it to
+// withdraw a campaign from review.
+func (h *Handler) CampaignReject(w http.ResponseWriter, r *http.Request) {
+	h.decideCampaign(w, r, false)
+}
+
+func (h *Handler) decideCampaign(w http.ResponseWriter, r *http.Request, approve bool) {
+	id := chi.URLParam(r, "id")
+	accountID := auth.AccountFromContext(r.Context())
+
+	campaign, err := db.GetCampaign(h.DB, id)
+	if err != nil || campaign == nil || (campaign.AccountID != accountID && !h.canApprove(r.Context())) {
+		http.NotFound(w, r)
+		return
+	}
+	withdraw := !approve && campaign.AccountID == accountID && campaign.State == "PENDING_APPROVAL"
+	if !withdraw {
+		if msg := h.approvalProblem(r.Context(), campaign); msg != "" {
+			verb := "reject"
+			if approve {
+				verb = "approve"
+			}
+			h.setFlash(w, "Cannot "+verb+": "+msg+".")
+			http.Redirect(w, r, "/campaigns/"+id, http.StatusSeeOther)
+			return
+		}
+	}
+
+	ok, err := h.applyDecision(campaign, accountID, approve)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "campaign approval", "error", err, "campaign", id)
+		http.Error(w, "Internal error", 500)
+		return
+	}
+	switch {
+	case !ok:
+		h.setFlash(w, "Campaign is no longer awaiting approval.")
+	case approve:
+		db.InsertAuditLog(h.DB, accountID, "campaign_approved", "campaign", id, campaign.Name, r.RemoteAddr)
+		h.setFlash(w, "Campaign approved. It can now be published.")
+	case withdraw:
+		db.InsertAuditLog(h.DB, accountID, "campaign_withdrawn", "campaign", id, campaign.Name, r.RemoteAddr)
+		h.setFlash(w, "Campaign withdrawn from review and returned to draft.")
+	default:
+		db.InsertAuditLog(h.DB, accountID, "campaign_rejected", "campaign", id, campaign.Name, r.RemoteAddr)
+		h.setFlash(w, "Campaign rejected and returned to draft.")
+	}
+	http.Redirect(w, r, "/campaigns/"+id, http.StatusSeeOther)
+}
+
+func (h *Handler) applyDecision(c *model.Campaign, accountID string, approve bool) (bool, error) {
+	if approve {
+		return db.ApproveCampaign(h.DB, c.ID, accountID)
+	}
+	return db.RejectCampaign(h.DB, c.ID)
+}
+
+// APICampaignApprove - POST /api/v1/campaigns/{id}/approve
+func (h *Handler) APICampaignApprove(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	accountID := auth.AccountFromContext(r.Context())
+
+	campaign, err := db.GetCampaign(h.DB, id)
+	if err != nil {
+		renderJSONError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "failed to get campaign")
+		return
+	}
+	if campaign == nil || (campaign.AccountID != accountID && !h.canApprove(r.Context())) {
+		renderJSONError(w, http.StatusNotFound, "NOT_FOUND", "campaign not found")
+		return
+	}
+	if !h.canApprove(r.Context()) || campaign.AccountID == accountID {
+		renderJSONError(w, http.StatusForbidden, "FORBIDDEN", h.approvalProblem(r.Context(), campaign))
+		return
+	}
+	ok, err := db.ApproveCampaign(h.DB, id, accountID)
+	if err != nil {
+		renderJSONError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "failed to approve campaign")
+		return
+	}
+	if !ok {
+		renderJSONError(w, http.StatusConflict, "CONFLICT", "campaign is not in PENDING_APPROVAL state")
+		return
+	}
+	db.InsertAuditLog(h.DB, accountID, "campaign_approved", "campaign", id, campaign.Name, r.RemoteAddr)
+	h.renderAPICampaign(w, http.StatusOK, id)
+}
+
+// renderAPICampaign writes the current state of campaign id as an
+// apiCampaign.
+func (h *Handler) renderAPICampaign(w http.ResponseWriter, status int, id string) {
+	campaign, err := db.GetCampaign(h.DB, id)
+	if err != nil || campaign == nil {
+		renderJSONError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "failed to get campaign")
+		return
+	}
+	tokens, _ := db.ListTokensByCampaign(h.DB, id)
+	downloadedCount := 0
+	for _, t := range tokens {
+		if t.DownloadCount > 0 {
+			downloadedCount++
+		}
+	}
+	jobsTotal, jobsCompleted, jobsFailed, _ := db.CountJobsByCampaign(h.DB, id)
+	renderJSON(w, status, campaignToAPI(campaign, jobsTotal, jobsCompleted, jobsFailed, len(tokens), downloadedCount))
+}
@@ -0,0 +1,117 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/YannKr/downloadonce/internal/db"
+)
+
+func TestCampaignPublishRequiresApproval(t *testing.T) {
+	h := newTestHandler(t)
+	h.Cfg.RequireApproval = true
+	seedAccount(t, h.DB, "owner", "admin")
+	seedAccount(t, h.DB, "reviewer", "admin")
+	seedAccount(t, h.DB, "member", "member")
+	seedCampaign(t, h.DB, "owner", "camp", "DRAFT", "r1")
+
+	r := chi.NewRouter()
+	r.Post("/campaigns/{id}/publish", h.CampaignPublish)
+	r.Post("/campaigns/{id}/approve", h.CampaignApprove)
+	r.Post("/campaigns/{id}/reject", h.CampaignReject)
+	post := func(path, account, role string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, asAccount(httptest.NewRequest("POST", path, nil), account, role))
+		return rec
+	}
+	state := func() string {
+		c, _ := db.GetCampaign(h.DB, "camp")
+		return c.State
+	}
+	jobs := func() int {
+		total, _, _, _ := db.CountJobsByCampaign(h.DB, "camp")
+		return total
+	}
+
+	// Publishing an unapproved campaign only submits it.
+	post("/campaigns/camp/publish", "owner", "admin")
+	if s := state(); s != "PENDING_APPROVAL" || jobs() != 0 {
+		t.Fatalf("after submit: state = %s, jobs = %d; want PENDING_APPROVAL, 0", s, jobs())
+	}
+	post("/campaigns/camp/publish", "owner", "admin")
+	if jobs() != 0 {
+		t.Fatal("publish went through while awaiting approval")
+	}
+
+	// Neither the owner nor a member can approve.
+	post("/campaigns/camp/approve", "owner", "admin")
+	post("/campaigns/camp/approve", "member", "member")
+	if c, _ := db.GetCampaign(h.DB, "camp"); c.State != "PENDING_APPROVAL" || c.ApprovedAt != nil {
+		t.Fatalf("self/member approval accepted: %+v", c)
+	}
+
+	// Rejecting returns it to draft; it has to be submitted again.
+	post("/campaigns/camp/reject", "reviewer", "admin")
+	if s := state(); s != "DRAFT" {
+		t.Fatalf("after reject: state = %s", s)
+	}
+	post("/campaigns/camp/publish", "owner", "admin")
+
+	post("/campaigns/camp/approve", "reviewer", "admin")
+	c, _ := db.GetCampaign(h.DB, "camp")
+	if c.State != "DRAFT" || c.ApprovedBy != "reviewer" || c.ApprovedAt == nil {
+		t.Fatalf("after approve: %+v", c)
+	}
+
+	post("/campaigns/camp/publish", "owner", "admin")
+	if s := state(); s != "PROCESSING" || jobs() != 1 {
+		t.Errorf("after approved publish: state = %s, jobs = %d; want PROCESSING, 1", s, jobs())
+	}
+}
+
+func TestAPICampaignApprove(t *testing.T) {
+	h := newTestHandler(t)
+	h.Cfg.RequireApproval = true
+	seedAccount(t, h.DB, "acc", "member")
+	seedAccount(t, h.DB, "boss", "admin")
+	seedCampaign(t, h.DB, "acc", "camp", "DRAFT", "r1")
+
+	r := chi.NewRouter()
+	r.Post("/api/v1/campaigns/{id}/publish", h.APICampaignPublish)
+	r.Post("/api/v1/campaigns/{id}/approve", h.APICampaignApprove)
+	post := func(path, account, role string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, asAccount(httptest.NewRequest("POST", path, nil), account, role))
+		return rec
+	}
+
+	rec := post("/api/v1/campaigns/camp/publish", "acc", "member")
+	var ac apiCampaign
+	json.Unmarshal(rec.Body.Bytes(), &ac)
+	if rec.Code != http.StatusAccepted || ac.State != "PENDING_APPROVAL" {
+		t.Fatalf("submit: status = %d, state = %q", rec.Code, ac.State)
+	}
+	if rec := post("/api/v1/campaigns/camp/publish", "acc", "member"); rec.Code != http.StatusConflict {
+		t.Errorf("publish while pending: status = %d, want 409", rec.Code)
+	}
+	if rec := post("/api/v1/campaigns/camp/approve", "acc", "member"); rec.Code != http.StatusForbidden {
+		t.Errorf("owner approve: status = %d, want 403", rec.Code)
+	}
+
+	rec = post("/api/v1/campaigns/camp/approve", "boss", "admin")
+	ac = apiCampaign{}
+	json.Unmarshal(rec.Body.Bytes(), &ac)
+	if rec.Code != http.StatusOK || ac.State != "DRAFT" || ac.ApprovedBy != "boss" || ac.ApprovedAt == nil {
+		t.Fatalf("approve: status = %d, body %s", rec.Code, rec.Body)
+	}
+	if rec := post("/api/v1/campaigns/camp/approve", "boss", "admin"); rec.Code != http.StatusConflict {
+		t.Errorf("second approve: status = %d, want 409", rec.Code)
+	}
+
+	if rec := post("/api/v1/campaigns/camp/publish", "acc", "member"); rec.Code != http.StatusOK {
+		t.Errorf("approved publish: status = %d, body %s", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,256 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/YannKr/downloadonce/internal/auth"
+	"github.com/YannKr/downloadonce/internal/db"
+	"github.com/YannKr/downloadonce/internal/model"
+	"github.com/YannKr/downloadonce/internal/watermark"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+var (
+	errAssetInUse       = errors.New("asset has watermarked copies; only assets whose campaigns are all unpublished drafts can be replaced")
+	errAssetUnsupported = errors.New("unsupported file type")
+	errAssetTypeChanged = errors.New("replacement must be the same kind of file as the original")
+)
+
+// detectAssetMime picks the MIME type and extension for an upload from its
+// first bytes, falling back to the filename extension.
+func detectAssetMime(head []byte, filename string) (mimeType, ext string, ok bool) {
+	mimeType = sniffUploadMime(head)
+	if ext, ok := watermark.MimeToExt[mimeType]; ok {
+		return mimeType, ext, true
+	}
+	origExt := strings.ToLower(filepath.Ext(filename))
+	for m, e := range watermark.MimeToExt {
+		if e == origExt {
+			return m, e, true
+		}
+	}
+	return mimeType, "", false
+}
+
+// replaceAsset swaps asset's original file for the contents of r and
+// recomputes its hash, dimensions and thumbnail. The asset keeps its ID, so
+// DRAFT campaigns using it need no changes. asset is updated in place; the
+// previous SHA-256 is returned for the audit log.
+func (h *Handler) replaceAsset(ctx context.Context, asset *model.Asset, r io.Reader, filename string, size int64) (string, error) {
+	inUse, err := db.AssetHasWatermarkedCopies(h.DB, asset.ID)
+	if err != nil {
+		return "", err
+	}
+	if inUse {
+		return "", errAssetInUse
+	}
+	if h.Cfg.MaxUploadBytes > 0 && size > h.Cfg.MaxUploadBytes {
+		return "", fmt.Errorf("%w (%s)", errFileTooLarge, formatBytes(h.Cfg.MaxUploadBytes))
+	}
+	// The old file is freed, so only growth counts against the account cap.
+	if err := h.checkUploadSize(asset.AccountID, max(0, size-asset.FileSize)); err != nil {
+		return "", err
+	}
+
+	var sniff [512]byte
+	n, _ := io.ReadFull(r, sniff[:])
+	mimeType, ext, ok := detectAssetMime(sniff[:n], filename)
+	if !ok {
+		return "", errAssetUnsupported
+	}
+	if watermark.MimeToAssetType[mimeType] != asset.AssetType {
+		return "", fmt.Errorf("%w (%s)", errAssetTypeChanged, asset.AssetType)
+	}
+	if err := h.checkUploadType(asset.AccountID, mimeType); err != nil {
+		return "", err
+	}
+	r = io.MultiReader(bytes.NewReader(sniff[:n]), r)
+
+	assetDir := filepath.Join(h.Cfg.DataDir, "originals", asset.ID)
+	if err := os.MkdirAll(assetDir, 0755); err != nil {
+		return "", fmt.Errorf("create asset dir: %w", err)
+	}
+	tmpPath := filepath.Join(assetDir, "replace-"+uuid.New().String()+ext)
+	dst, err := os.Create(tmpPath)
+	if err != nil {
+		return "", fmt.Errorf("create file: %w", err)
+	}
+	hasher := sha256.New()
+	written, err := io.Copy(dst, io.TeeReader(r, hasher))
+	dst.Close()
+	if err != nil {
+		os.Remove(tmpPath)
+		return "", fmt.Errorf("write file: %w", err)
+	}
+	var sourceMime string
+	if watermark.NeedsTranscode(mimeType) {
+		pngPath, size, err := transcodeToPNG(ctx, tmpPath)
+		if err != nil {
+			os.Remove(tmpPath)
+			return "", err
+		}
+		tmpPath, written = pngPath, size
+		sourceMime, mimeType, ext = mimeType, "image/png", ".png"
+	}
+
+	var duration *float64
+	var width, height *int64
+	if probe, err := watermark.Probe(tmpPath); err == nil && probe.Width > 0 {
+		w64, h64 := int64(probe.Width), int64(probe.Height)
+		width, height = &w64, &h64
+		if asset.AssetType == "video" {
+			duration = &probe.DurationSecs
+		}
+	} else if err != nil {
+		slog.WarnContext(ctx, "replace asset: probe failed", "asset", asset.ID, "error", err)
+	}
+
+	// Keep the old file until the database points at the new one.
+	oldPath := filepath.Join(h.Cfg.DataDir, asset.OriginalPath)
+	backupPath := oldPath + ".bak"
+	hadOld := os.Rename(oldPath, backupPath) == nil
+	newPath := filepath.Join(assetDir, "source"+ext)
+	if err := os.Rename(tmpPath, newPath); err != nil {
+		os.Remove(tmpPath)
+		if hadOld {
+			os.Rename(backupPath, oldPath)
+		}
+		return "", fmt.Errorf("move file: %w", err)
+	}
+
+	oldSHA, oldExt := asset.SHA256, filepath.Ext(asset.OriginalPath)
+	updated := *asset
+	updated.OriginalPath = filepath.Join("originals", asset.ID, "source"+ext)
+	updated.FileSize = written
+	updated.SHA256 = hex.EncodeToString(hasher.Sum(nil))
+	updated.MimeType = mimeType
+	updated.SourceMimeType = sourceMime
+	updated.Duration, updated.Width, updated.Height = duration, width, height
+	if oldExt != ext && strings.EqualFold(filepath.Ext(asset.OriginalName), oldExt) {
+		updated.OriginalName = strings.TrimSuffix(asset.OriginalName, filepath.Ext(asset.OriginalName)) + ext
+	}
+	if err := db.ReplaceAssetFile(h.DB, &updated); err != nil {
+		os.Remove(newPath)
+		if hadOld {
+			os.Rename(backupPath, oldPath)
+		}
+		if errors.Is(err, db.ErrAssetHasCopies) {
+			return "", errAssetInUse
+		}
+		return "", fmt.Errorf("update asset: %w", err)
+	}
+	if hadOld {
+		os.Remove(backupPath)
+	}
+	*asset = updated
+
+	os.Remove(filepath.Join(assetDir, "thumb.jpg"))
+	h.generateThumbnail(ctx, asset)
+	return oldSHA, nil
+}
+
+// AssetReplace handles POST /assets/{id}/replace
+func (h *Handler) AssetReplace(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	accountID := auth.AccountFromContext(r.Context())
+
+	asset, err := db.GetAsset(h.DB, id)
+	if err != nil || asset == nil || (asset.AccountID != accountID && !auth.IsAdmin(r.Context())) {
+		http.NotFound(w, r)
+		return
+	}
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		h.setFlash(w, "Choose a file to replace the asset with.")
+		http.Redirect(w, r, "/assets", http.StatusSeeOther)
+		return
+	}
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		h.setFlash(w, "Choose a file to replace the asset with.")
+		http.Redirect(w, r, "/assets", http.StatusSeeOther)
+		return
+	}
+	defer file.Close()
+
+	oldSHA, err := h.replaceAsset(r.Context(), asset, file, header.Filename, header.Size)
+	switch {
+	case err == nil:
+		db.InsertAuditLog(h.DB, accountID, "asset_replaced", "asset", id,
+			fmt.Sprintf("sha256 %s -> %s", oldSHA, asset.SHA256), r.RemoteAddr)
+		h.setFlash(w, "Asset replaced.")
+	case errors.Is(err, errAssetInUse), errors.Is(err, errAssetUnsupported), errors.Is(err, errUploadType),
+		errors.Is(err, errAssetTypeChanged), errors.Is(err, errTranscodeFailed), isUploadLimitError(err):
+		h.setFlash(w, "Cannot replace asset: "+err.Error()+".")
+	default:
+		slog.ErrorContext(r.Context(), "replace asset", "asset", id, "error", err)
+		http.Error(w, "Internal error", 500)
+		return
+	}
+	http.Redirect(w, r, "/assets", http.StatusSeeOther)
+}
+
+// APIAssetReplace - POST /api/v1/assets/{id}/replace
+func (h *Handler) APIAssetReplace(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	accountID := auth.AccountFromContext(r.Context())
+
+	asset, err := db.GetAsset(h.DB, id)
+	if err != nil {
+		renderJSONError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "failed to get asset")
+		return
+	}
+	if asset == nil || (asset.AccountID != accountID && !auth.IsAdmin(r.Context())) {
+		renderJSONError(w, http.StatusNotFound, "NOT_FOUND", "asset not found")
+		return
+	}
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		renderJSONError(w, http.StatusBadRequest, "BAD_REQUEST", "failed to parse multipart form")
+		return
+	}
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		renderJSONError(w, http.StatusBadRequest, "BAD_REQUEST", "missing 'file' field in form")
+		return
+	}
+	defer file.Close()
+
+	oldSHA, err := h.replaceAsset(r.Context(), asset, file, header.Filename, header.Size)
+	switch {
+	case err == nil:
+	case errors.Is(err, errAssetInUse):
+		renderJSONError(w, http.StatusConflict, "ASSET_IN_USE", err.Error())
+		return
+	case errors.Is(err, errAssetUnsupported), errors.Is(err, errUploadType):
+		renderJSONError(w, http.StatusUnsupportedMediaType, "UNSUPPORTED_MEDIA_TYPE", err.Error())
+		return
+	case errors.Is(err, errAssetTypeChanged):
+		renderJSONError(w, http.StatusBadRequest, "BAD_REQUEST", err.Error())
+		return
+	case errors.Is(err, errTranscodeFailed):
+		renderJSONError(w, http.StatusUnprocessableEntity, "TRANSCODE_FAILED", err.Error())
+		return
+	case isUploadLimitError(err):
+		renderJSONError(w, http.StatusRequestEntityTooLarge, "TOO_LARGE", err.Error())
+		return
+	default:
+		slog.ErrorContext(r.Context(), "api replace asset", "asset", id, "error", err)
+		renderJSONError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "failed to replace asset")
+		return
+	}
+
+	db.InsertAuditLog(h.DB, accountID, "asset_replaced", "asset", id,
+		fmt.Sprintf("sha256 %s -> %s", oldSHA, asset.SHA256), r.RemoteAddr)
+	renderJSON(w, http.StatusOK, assetToAPI(asset))
+}
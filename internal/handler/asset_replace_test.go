@@ -0,0 +1,87 @@
+package handler
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/YannKr/downloadonce/internal/db"
+	"github.com/go-chi/chi/v5"
+)
+
+func TestAPIAssetReplace(t *testing.T) {
+	h := newTestHandler(t)
+	seedAccount(t, h.DB, "acc", "member")
+	tokens := seedCampaign(t, h.DB, "acc", "camp", "DRAFT", "r1")
+
+	r := chi.NewRouter()
+	r.Post("/api/v1/assets/{id}/replace", h.APIAssetReplace)
+	replace := func(filename string, content []byte) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		fw, _ := mw.CreateFormFile("file", filename)
+		fw.Write(content)
+		mw.Close()
+		req := httptest.NewRequest("POST", "/api/v1/assets/camp-asset/replace", &body)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, asAccount(req, "acc", "member"))
+		return rec
+	}
+
+	var img bytes.Buffer
+	png.Encode(&img, image.NewGray(image.Rect(0, 0, 40, 30)))
+	rec := replace("fixed.png", img.Bytes())
+	if rec.Code != http.StatusOK {
+		t.Fatalf("replace: status = %d: %s", rec.Code, rec.Body.String())
+	}
+	asset, _ := db.GetAsset(h.DB, "camp-asset")
+	if asset.SHA256 == "00" || asset.FileSize != int64(img.Len()) {
+		t.Errorf("asset not updated: sha=%s size=%d", asset.SHA256, asset.FileSize)
+	}
+	if got, err := os.ReadFile(filepath.Join(h.Cfg.DataDir, asset.OriginalPath)); err != nil || !bytes.Equal(got, img.Bytes()) {
+		t.Errorf("original file not written at %s: %v", asset.OriginalPath, err)
+	}
+	if c, _ := db.GetCampaign(h.DB, "camp"); c.AssetID != "camp-asset" {
+		t.Errorf("campaign asset = %s", c.AssetID)
+	}
+	// The audit log is written asynchronously.
+	var detail string
+	for i := 0; i < 50 && detail == ""; i++ {
+		h.DB.QueryRow(`SELECT detail FROM audit_logs WHERE action = 'asset_replaced'`).Scan(&detail)
+		time.Sleep(10 * time.Millisecond)
+	}
+	if detail != "sha256 00 -> "+asset.SHA256 {
+		t.Errorf("audit detail = %q", detail)
+	}
+
+	if rec := replace("notes.txt", []byte("hello")); rec.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("text file: status = %d, want 415", rec.Code)
+	}
+
+	// Once a token has been watermarked from the source, replacing is refused.
+	if _, err := h.DB.Exec(`UPDATE download_tokens SET watermarked_path = 'x' WHERE id = ?`, tokens[0]); err != nil {
+		t.Fatal(err)
+	}
+	rec = replace("again.png", img.Bytes())
+	if rec.Code != http.StatusConflict || !strings.Contains(rec.Body.String(), "ASSET_IN_USE") {
+		t.Errorf("watermarked copies: status = %d: %s", rec.Code, rec.Body.String())
+	}
+	if _, err := h.DB.Exec(`UPDATE download_tokens SET watermarked_path = NULL`); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.UpdateCampaignState(h.DB, "camp", "READY"); err != nil {
+		t.Fatal(err)
+	}
+	if rec := replace("again.png", img.Bytes()); rec.Code != http.StatusConflict {
+		t.Errorf("published campaign: status = %d, want 409", rec.Code)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
@@ -21,6 +22,7 @@ import (
 	"github.com/google/uuid"
 	"github.com/YannKr/downloadonce/internal/auth"
 	"github.com/YannKr/downloadonce/internal/db"
+	"github.com/YannKr/downloadonce/internal/logging"
 	"github.com/YannKr/downloadonce/internal/model"
 	"github.com/YannKr/downloadonce/internal/watermark"
 )
@@ -29,14 +31,28 @@ type assetUploadData struct {
 	URLValue string // repopulate URL field on error
 }
 
+type assetListData struct {
+	Assets    []model.Asset
+	Deleted   []model.Asset // soft-deleted, still restorable
+	GraceDays int
+}
+
 func (h *Handler) AssetList(w http.ResponseWriter, r *http.Request) {
 	assets, err := db.ListAssets(h.DB)
 	if err != nil {
-		slog.Error("list assets", "error", err)
+		slog.ErrorContext(r.Context(), "list assets", "error", err)
 		http.Error(w, "Internal error", 500)
 		return
 	}
-	h.renderAuth(w, r, "assets.html", "Assets", assets)
+	deleted, err := db.ListDeletedAssets(h.DB)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "list deleted assets", "error", err)
+		http.Error(w, "Internal error", 500)
+		return
+	}
+	h.renderAuth(w, r, "assets.html", "Assets", assetListData{
+		Assets: assets, Deleted: deleted, GraceDays: h.Cfg.DeleteGraceDays,
+	})
 }
 
 func (h *Handler) AssetUploadForm(w http.ResponseWriter, r *http.Request) {
@@ -61,14 +77,20 @@ func (h *Handler) AssetUploadSubmit(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	dedupe := r.FormValue("dedupe") == "1"
 	uploaded := 0
 	var lastErr string
+	var duplicates []string
 	for _, fh := range files {
-		if err := h.processOneUpload(accountID, fh); err != nil {
-			slog.Warn("upload failed", "file", fh.Filename, "error", err)
+		dup, err := h.processOneUpload(r.Context(), accountID, fh, dedupe)
+		if err != nil {
+			slog.WarnContext(r.Context(), "upload failed", "file", fh.Filename, "error", err)
 			lastErr = fmt.Sprintf("Failed to upload %s: %v", fh.Filename, err)
-		} else {
-			uploaded++
+			continue
+		}
+		uploaded++
+		if dup != "" {
+			duplicates = append(duplicates, fh.Filename)
 		}
 	}
 
@@ -81,9 +103,20 @@ func (h *Handler) AssetUploadSubmit(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if len(duplicates) > 0 {
+		h.setFlash(w, duplicateFlash(strings.Join(duplicates, ", "), dedupe))
+	}
 	http.Redirect(w, r, "/assets", http.StatusSeeOther)
 }
 
+// duplicateFlash describes uploads whose content matched an existing asset.
+func duplicateFlash(names string, dedupe bool) string {
+	if dedupe {
+		return "Already uploaded, reused the existing asset: " + names
+	}
+	return "Identical to an existing asset (uploaded again): " + names
+}
+
 func (h *Handler) AssetFetchURL(w http.ResponseWriter, r *http.Request) {
 	accountID := auth.AccountFromContext(r.Context())
 
@@ -146,7 +179,9 @@ func (h *Handler) AssetFetchURL(w http.ResponseWriter, r *http.Request) {
 	}
 
 	body := io.LimitReader(resp.Body, h.Cfg.MaxUploadBytes)
-	if err := h.processAssetFromReader(accountID, body, originalName); err != nil {
+	dedupe := r.FormValue("dedupe") == "1"
+	dup, err := h.processAssetFromReader(r.Context(), accountID, body, originalName, dedupe)
+	if err != nil {
 		h.render(w, r, "asset_upload.html", PageData{
 			Title: "Upload Asset", Authenticated: true,
 			IsAdmin: auth.IsAdmin(r.Context()), UserName: auth.NameFromContext(r.Context()),
@@ -156,24 +191,34 @@ func (h *Handler) AssetFetchURL(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	setFlash(w, "Asset imported from URL.")
+	if dup != "" {
+		h.setFlash(w, duplicateFlash(originalName, dedupe))
+	} else {
+		h.setFlash(w, "Asset imported from URL.")
+	}
 	http.Redirect(w, r, "/assets", http.StatusSeeOther)
 }
 
-func (h *Handler) processOneUpload(accountID string, header *multipart.FileHeader) error {
+func (h *Handler) processOneUpload(ctx context.Context, accountID string, header *multipart.FileHeader, dedupe bool) (string, error) {
+	if err := h.checkUploadSize(accountID, header.Size); err != nil {
+		return "", err
+	}
 	file, err := header.Open()
 	if err != nil {
-		return err
+		return "", err
 	}
 	defer file.Close()
-	return h.processAssetFromReader(accountID, file, header.Filename)
+	return h.processAssetFromReader(ctx, accountID, file, header.Filename, dedupe)
 }
 
-func (h *Handler) processAssetFromReader(accountID string, r io.Reader, originalName string) error {
+// processAssetFromReader stores r as a new asset. duplicateOf is the ID of an
+// existing asset of the account with the same content, if any; with dedupe
+// set no new asset is created and that ID is returned instead.
+func (h *Handler) processAssetFromReader(ctx context.Context, accountID string, r io.Reader, originalName string, dedupe bool) (duplicateOf string, err error) {
 	// Detect MIME type from first 512 bytes, then prepend them back via MultiReader
 	var sniff [512]byte
 	n, _ := io.ReadFull(r, sniff[:])
-	mimeType := http.DetectContentType(sniff[:n])
+	mimeType := sniffUploadMime(sniff[:n])
 	r = io.MultiReader(bytes.NewReader(sniff[:n]), r)
 
 	// Check allowed types
@@ -195,16 +240,19 @@ func (h *Handler) processAssetFromReader(accountID string, r io.Reader, original
 			}
 		}
 		if !found {
-			return fmt.Errorf("unsupported file type: %s", mimeType)
+			return "", fmt.Errorf("unsupported file type: %s", mimeType)
 		}
 	}
+	if err := h.checkUploadType(accountID, mimeType); err != nil {
+		return "", err
+	}
 
 	assetType := watermark.MimeToAssetType[mimeType]
 	assetID := uuid.New().String()
 
 	assetDir := filepath.Join(h.Cfg.DataDir, "originals", assetID)
 	if err := os.MkdirAll(assetDir, 0755); err != nil {
-		return fmt.Errorf("create asset dir: %w", err)
+		return "", fmt.Errorf("create asset dir: %w", err)
 	}
 
 	srcPath := filepath.Join(assetDir, "source"+ext)
@@ -212,7 +260,7 @@ func (h *Handler) processAssetFromReader(accountID string, r io.Reader, original
 	dst, err := os.Create(srcPath)
 	if err != nil {
 		os.RemoveAll(assetDir)
-		return fmt.Errorf("create file: %w", err)
+		return "", fmt.Errorf("create file: %w", err)
 	}
 
 	hasher := sha256.New()
@@ -220,17 +268,34 @@ func (h *Handler) processAssetFromReader(accountID string, r io.Reader, original
 	dst.Close()
 	if err != nil {
 		os.RemoveAll(assetDir)
-		return fmt.Errorf("write file: %w", err)
+		return "", fmt.Errorf("write file: %w", err)
 	}
 
 	sha256Hex := hex.EncodeToString(hasher.Sum(nil))
+	if dup := h.duplicateAsset(accountID, sha256Hex); dup != nil {
+		if dedupe {
+			os.RemoveAll(assetDir)
+			return dup.ID, nil
+		}
+		slog.WarnContext(ctx, "upload: duplicate of existing asset", "account", accountID, "asset_id", dup.ID, "sha256", sha256Hex)
+		duplicateOf = dup.ID
+	}
+
+	var sourceMime string
+	if watermark.NeedsTranscode(mimeType) {
+		if srcPath, written, err = transcodeToPNG(ctx, srcPath); err != nil {
+			os.RemoveAll(assetDir)
+			return "", err
+		}
+		sourceMime, mimeType, ext = mimeType, "image/png", ".png"
+	}
 
 	var duration *float64
 	var width, height *int64
 	if assetType == "video" {
 		probe, err := watermark.Probe(srcPath)
 		if err != nil {
-			slog.Warn("ffprobe failed", "error", err)
+			slog.WarnContext(ctx, "ffprobe failed", "error", err)
 		} else {
 			duration = &probe.DurationSecs
 			w64 := int64(probe.Width)
@@ -248,22 +313,6 @@ func (h *Handler) processAssetFromReader(accountID string, r io.Reader, original
 		}
 	}
 
-	thumbPath := filepath.Join(assetDir, "thumb.jpg")
-	ctx := context.Background()
-	if assetType == "video" {
-		seekSec := 1.0
-		if duration != nil && *duration > 10 {
-			seekSec = *duration * 0.1
-		}
-		if err := watermark.ExtractVideoThumbnail(ctx, srcPath, thumbPath, seekSec); err != nil {
-			slog.Warn("thumbnail extraction failed", "error", err)
-		}
-	} else {
-		if err := watermark.ExtractImageThumbnail(ctx, srcPath, thumbPath); err != nil {
-			slog.Warn("thumbnail extraction failed", "error", err)
-		}
-	}
-
 	asset := &model.Asset{
 		ID:           assetID,
 		AccountID:    accountID,
@@ -276,24 +325,162 @@ func (h *Handler) processAssetFromReader(accountID string, r io.Reader, original
 		Duration:     duration,
 		Width:        width,
 		Height:       height,
+
+		SourceMimeType: sourceMime,
 	}
 
-	if err := db.CreateAsset(h.DB, asset); err != nil {
+	if used, err := db.CreateAssetWithinQuota(h.DB, asset, h.Cfg.MaxAccountUploadBytes); err != nil {
 		os.RemoveAll(assetDir)
-		return fmt.Errorf("insert asset: %w", err)
+		if errors.Is(err, db.ErrUploadQuota) {
+			return "", h.uploadQuotaError(used)
+		}
+		return "", fmt.Errorf("insert asset: %w", err)
+	}
+	h.generateThumbnail(ctx, asset)
+	h.dispatchAssetReady(ctx, asset)
+
+	return duplicateOf, nil
+}
+
+// errTranscodeFailed is returned when a HEIC/AVIF upload cannot be converted,
+// usually because ImageMagick was built without libheif.
+var errTranscodeFailed = errors.New("could not convert the HEIC/AVIF image to PNG")
+
+// sniffUploadMime is http.DetectContentType plus the HEIF-family formats and
+// TIFF, which it reports as application/octet-stream.
+func sniffUploadMime(head []byte) string {
+	if m := watermark.SniffHEIF(head); m != "" {
+		return m
 	}
+	if bytes.HasPrefix(head, []byte("II*\x00")) || bytes.HasPrefix(head, []byte("MM\x00*")) {
+		return "image/tiff"
+	}
+	return http.DetectContentType(head)
+}
 
-	return nil
+// transcodeToPNG converts the HEIC/AVIF file at path to a PNG beside it (same
+// name, .png extension) and removes path. It returns the PNG's path and size.
+// The SHA-256 of the upload is kept as the asset's hash, so re-uploading the
+// same HEIC still deduplicates.
+func transcodeToPNG(ctx context.Context, path string) (string, int64, error) {
+	pngPath := strings.TrimSuffix(path, filepath.Ext(path)) + ".png"
+	if err := watermark.TranscodeToPNG(ctx, path, pngPath); err != nil {
+		slog.WarnContext(ctx, "transcode upload", "path", path, "error", err)
+		os.Remove(pngPath)
+		return "", 0, errTranscodeFailed
+	}
+	fi, err := os.Stat(pngPath)
+	if err != nil {
+		return "", 0, err
+	}
+	os.Remove(path)
+	return pngPath, fi.Size(), nil
+}
+
+// generateThumbnail writes the asset's thumb.jpg. With DEFER_THUMBNAILS it
+// queues a thumbnail job instead, so the upload returns without waiting for
+// ffmpeg or ImageMagick; thumbnails are served as a placeholder until then.
+func (h *Handler) generateThumbnail(ctx context.Context, asset *model.Asset) {
+	if h.Cfg.DeferThumbnails {
+		err := db.EnqueueThumbnailJob(h.DB, uuid.New().String(), asset.AccountID, asset.ID, logging.RequestID(ctx))
+		if err == nil {
+			return
+		}
+		slog.ErrorContext(ctx, "enqueue thumbnail job", "asset", asset.ID, "error", err)
+	}
+	var duration float64
+	if asset.Duration != nil {
+		duration = *asset.Duration
+	}
+	srcPath := filepath.Join(h.Cfg.DataDir, asset.OriginalPath)
+	thumbPath := filepath.Join(h.Cfg.DataDir, "originals", asset.ID, "thumb.jpg")
+	if err := watermark.ExtractAssetThumbnail(context.Background(), asset.AssetType, srcPath, thumbPath, duration); err != nil {
+		slog.WarnContext(ctx, "thumbnail extraction failed", "asset", asset.ID, "error", err)
+	}
+}
+
+// dispatchAssetReady sends the asset_ready webhook once an upload has been
+// stored and probed. With DEFER_THUMBNAILS the thumbnail job may still be
+// queued at this point. Dimensions and duration are only included when
+// probing found them.
+func (h *Handler) dispatchAssetReady(ctx context.Context, asset *model.Asset) {
+	if h.Webhook == nil {
+		return
+	}
+	data := map[string]interface{}{
+		"asset_id":   asset.ID,
+		"name":       asset.OriginalName,
+		"asset_type": asset.AssetType,
+		"mime_type":  asset.MimeType,
+		"file_size":  asset.FileSize,
+		"sha256":     asset.SHA256,
+	}
+	if asset.Width != nil && asset.Height != nil {
+		data["width"] = *asset.Width
+		data["height"] = *asset.Height
+	}
+	if asset.Duration != nil {
+		data["duration_secs"] = *asset.Duration
+	}
+	h.Webhook.Dispatch(ctx, asset.AccountID, "asset_ready", data)
 }
 
 func (h *Handler) AssetThumbnail(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
-	thumbPath := filepath.Join(h.Cfg.DataDir, "originals", id, "thumb.jpg")
-	if _, err := os.Stat(thumbPath); os.IsNotExist(err) {
+	accountID := auth.AccountFromContext(r.Context())
+
+	asset, err := db.GetAsset(h.DB, id)
+	if err != nil || asset == nil || (asset.AccountID != accountID && !auth.IsAdmin(r.Context())) {
 		http.NotFound(w, r)
 		return
 	}
-	http.ServeFile(w, r, thumbPath)
+	if !h.serveThumbnail(w, r, asset) {
+		http.NotFound(w, r)
+	}
+}
+
+// thumbnailPlaceholder is served while a deferred thumbnail job is pending.
+const thumbnailPlaceholder = `<svg xmlns="http://www.w3.org/2000/svg" width="400" height="225" viewBox="0 0 400 225">` +
+	`<rect width="400" height="225" fill="#e5e7eb"/>` +
+	`<text x="200" y="118" font-family="sans-serif" font-size="16" fill="#6b7280" text-anchor="middle">Generating preview…</text></svg>`
+
+// serveThumbnail writes asset's thumb.jpg with an ETag derived from the
+// asset's SHA-256, answering If-None-Match with 304. While a deferred
+// thumbnail job is pending it serves an uncached placeholder instead. It
+// returns false without writing anything when thumbnail extraction never
+// produced a file.
+func (h *Handler) serveThumbnail(w http.ResponseWriter, r *http.Request, asset *model.Asset) bool {
+	f, err := os.Open(filepath.Join(h.Cfg.DataDir, "originals", asset.ID, "thumb.jpg"))
+	if err != nil {
+		return h.serveThumbnailPlaceholder(w, asset)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil || info.Size() == 0 {
+		return h.serveThumbnailPlaceholder(w, asset)
+	}
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	// Browsers revalidate on every use; replacing the file changes both the
+	// SHA and the modification time, so a replaced asset's thumbnail is
+	// refetched while an unchanged one is answered with 304.
+	w.Header().Set("Cache-Control", "private, no-cache")
+	if asset.SHA256 != "" {
+		w.Header().Set("ETag", `"`+asset.SHA256+`"`)
+	}
+	http.ServeContent(w, r, "thumb.jpg", info.ModTime(), f)
+	return true
+}
+
+func (h *Handler) serveThumbnailPlaceholder(w http.ResponseWriter, asset *model.Asset) bool {
+	pending, err := db.HasPendingThumbnailJob(h.DB, asset.ID)
+	if err != nil || !pending {
+		return false
+	}
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Header().Set("Cache-Control", "no-store")
+	io.WriteString(w, thumbnailPlaceholder)
+	return true
 }
 
 func (h *Handler) AssetDownload(w http.ResponseWriter, r *http.Request) {
@@ -323,13 +510,13 @@ func (h *Handler) AssetRename(w http.ResponseWriter, r *http.Request) {
 
 	newName := strings.TrimSpace(r.FormValue("name"))
 	if newName == "" {
-		setFlash(w, "Name cannot be empty.")
+		h.setFlash(w, "Name cannot be empty.")
 		http.Redirect(w, r, "/assets", http.StatusSeeOther)
 		return
 	}
 
 	if err := db.RenameAsset(h.DB, id, newName); err != nil {
-		slog.Error("rename asset", "error", err)
+		slog.ErrorContext(r.Context(), "rename asset", "error", err)
 		http.Error(w, "Internal error", 500)
 		return
 	}
@@ -347,11 +534,93 @@ func (h *Handler) AssetDelete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	db.DeleteAsset(h.DB, id)
-	os.RemoveAll(filepath.Join(h.Cfg.DataDir, "originals", id))
+	if asset.DeletedAt != nil {
+		http.Redirect(w, r, "/assets", http.StatusSeeOther)
+		return
+	}
+	blocked, err := h.assetDeleteBlocked(id)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "asset delete: count campaigns", "error", err)
+		http.Error(w, "Internal error", 500)
+		return
+	}
+	force := forceDelete(r)
+	if blocked != "" && !force {
+		h.setFlash(w, blocked)
+		http.Redirect(w, r, "/assets", http.StatusSeeOther)
+		return
+	}
+
+	if err := db.SoftDeleteAsset(h.DB, id); err != nil {
+		slog.ErrorContext(r.Context(), "soft delete asset", "error", err)
+		http.Error(w, "Internal error", 500)
+		return
+	}
+	db.InsertAuditLog(h.DB, accountID, "asset_deleted", "asset", id, assetDeleteDetail(asset, blocked), r.RemoteAddr)
+
+	h.setFlash(w, fmt.Sprintf("Asset deleted. It can be restored for %d days.", h.Cfg.DeleteGraceDays))
+	http.Redirect(w, r, "/assets", http.StatusSeeOther)
+}
+
+// blockingCampaignNames caps how many campaigns assetDeleteBlocked names.
+const blockingCampaignNames = 5
+
+// assetDeleteBlocked returns a user-facing reason the asset may not be
+// deleted, naming the campaigns in the way, or "" when it may: campaigns that
+// are still live need their original to publish, re-watermark and reissue
+// links.
+func (h *Handler) assetDeleteBlocked(assetID string) (string, error) {
+	n, err := db.CountCampaignsUsingAsset(h.DB, assetID)
+	if err != nil || n == 0 {
+		return "", err
+	}
+	names, err := db.ListCampaignNamesUsingAsset(h.DB, assetID, blockingCampaignNames)
+	if err != nil {
+		return "", err
+	}
+	for i, name := range names {
+		names[i] = fmt.Sprintf("%q", name)
+	}
+	list := strings.Join(names, ", ")
+	if n > len(names) {
+		list += fmt.Sprintf(" and %d more", n-len(names))
+	}
+	return fmt.Sprintf("This asset is used by %d campaign(s) that are not archived: %s. Archive or delete them first.", n, list), nil
+}
 
-	db.InsertAuditLog(h.DB, auth.AccountFromContext(r.Context()), "asset_deleted", "asset", id, "", r.RemoteAddr)
+// forceDelete reports whether the request asks, with ?force=1, to delete an
+// asset despite campaigns that still use it. Only admins may.
+func forceDelete(r *http.Request) bool {
+	return r.URL.Query().Get("force") == "1" && auth.IsAdmin(r.Context())
+}
 
-	setFlash(w, "Asset deleted.")
+// assetDeleteDetail is the audit detail for an asset deletion; a forced
+// delete records which campaigns it overrode.
+func assetDeleteDetail(asset *model.Asset, blocked string) string {
+	if blocked == "" {
+		return asset.OriginalName
+	}
+	return asset.OriginalName + " (forced) " + blocked
+}
+
+// AssetRestore handles POST /assets/{id}/restore, undoing a soft delete.
+func (h *Handler) AssetRestore(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	accountID := auth.AccountFromContext(r.Context())
+
+	asset, err := db.GetAsset(h.DB, id)
+	if err != nil || asset == nil || (asset.AccountID != accountID && !auth.IsAdmin(r.Context())) {
+		http.NotFound(w, r)
+		return
+	}
+	if asset.DeletedAt != nil {
+		if err := db.RestoreAsset(h.DB, id); err != nil {
+			slog.ErrorContext(r.Context(), "restore asset", "error", err)
+			http.Error(w, "Internal error", 500)
+			return
+		}
+		db.InsertAuditLog(h.DB, accountID, "asset_restored", "asset", id, asset.OriginalName, r.RemoteAddr)
+		h.setFlash(w, "Asset restored.")
+	}
 	http.Redirect(w, r, "/assets", http.StatusSeeOther)
 }
@@ -0,0 +1,163 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/YannKr/downloadonce/internal/db"
+	"github.com/YannKr/downloadonce/internal/model"
+	"github.com/YannKr/downloadonce/internal/webhook"
+)
+
+func TestAssetThumbnailCaching(t *testing.T) {
+	h := newTestHandler(t)
+	seedAccount(t, h.DB, "owner", "member")
+	seedAccount(t, h.DB, "other", "member")
+	seedCampaign(t, h.DB, "owner", "camp", "DRAFT")
+
+	r := chi.NewRouter()
+	r.Get("/assets/{id}/thumb", h.AssetThumbnail)
+	r.Get("/api/v1/assets/{id}/thumbnail", h.APIAssetThumbnail)
+	get := func(path, account, ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, asAccount(req, account, "member"))
+		return rec
+	}
+
+	// seedCampaign's asset has no thumbnail on disk.
+	for _, path := range []string{"/assets/camp-asset/thumb", "/api/v1/assets/camp-asset/thumbnail"} {
+		if rec := get(path, "owner", ""); rec.Code != http.StatusNotFound {
+			t.Errorf("%s without thumbnail: status = %d, want 404", path, rec.Code)
+		}
+	}
+
+	thumbPath := filepath.Join(h.Cfg.DataDir, "originals", "camp-asset", "thumb.jpg")
+	os.MkdirAll(filepath.Dir(thumbPath), 0755)
+	if err := os.WriteFile(thumbPath, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if rec := get("/assets/camp-asset/thumb", "owner", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("zero-byte thumbnail: status = %d, want 404", rec.Code)
+	}
+
+	os.WriteFile(thumbPath, []byte("\xff\xd8\xff\xe0jpeg"), 0644)
+	for _, path := range []string{"/assets/camp-asset/thumb", "/api/v1/assets/camp-asset/thumbnail"} {
+		rec := get(path, "owner", "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d", path, rec.Code)
+		}
+		if got := rec.Header().Get("ETag"); got != `"00"` {
+			t.Errorf("%s: ETag = %q, want the asset SHA", path, got)
+		}
+		if rec.Header().Get("Cache-Control") != "private, no-cache" || rec.Header().Get("Content-Type") != "image/jpeg" {
+			t.Errorf("%s: headers = %v", path, rec.Header())
+		}
+		if rec := get(path, "owner", `"00"`); rec.Code != http.StatusNotModified {
+			t.Errorf("%s with If-None-Match: status = %d, want 304", path, rec.Code)
+		}
+		if rec := get(path, "other", ""); rec.Code != http.StatusNotFound {
+			t.Errorf("%s as another account: status = %d, want 404", path, rec.Code)
+		}
+	}
+}
+
+func TestAssetReadyWebhook(t *testing.T) {
+	h := newTestHandler(t)
+	seedAccount(t, h.DB, "acc", "member")
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	if err := db.CreateWebhook(h.DB, &model.Webhook{ID: "wh", AccountID: "acc", URL: srv.URL, Secret: "s",
+		Events: "asset_ready", Enabled: true}); err != nil {
+		t.Fatal(err)
+	}
+	h.Webhook = &webhook.Dispatcher{DB: h.DB}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 8, 8))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.processAssetFromReader(context.Background(), "acc", &buf, "a.png", false); err != nil {
+		t.Fatal(err)
+	}
+	assets, err := db.ListAssets(h.DB)
+	if err != nil || len(assets) != 1 {
+		t.Fatalf("assets = %v, %v", assets, err)
+	}
+
+	var payload string
+	if err := h.DB.QueryRow(`SELECT payload_json FROM webhook_deliveries WHERE event_type = 'asset_ready'`).Scan(&payload); err != nil {
+		t.Fatalf("no asset_ready delivery: %v", err)
+	}
+	for _, want := range []string{assets[0].ID, `"name":"a.png"`, `"asset_type":"image"`} {
+		if !strings.Contains(payload, want) {
+			t.Errorf("payload %s missing %s", payload, want)
+		}
+	}
+}
+
+func TestDeferredThumbnailPlaceholder(t *testing.T) {
+	h := newTestHandler(t)
+	h.Cfg.DeferThumbnails = true
+	seedAccount(t, h.DB, "acc", "member")
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 8, 8))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.processAssetFromReader(context.Background(), "acc", &buf, "a.png", false); err != nil {
+		t.Fatal(err)
+	}
+	assets, err := db.ListAssets(h.DB)
+	if err != nil || len(assets) != 1 {
+		t.Fatalf("assets = %v, %v", assets, err)
+	}
+	id := assets[0].ID
+	thumbPath := filepath.Join(h.Cfg.DataDir, "originals", id, "thumb.jpg")
+	if _, err := os.Stat(thumbPath); !os.IsNotExist(err) {
+		t.Fatalf("thumbnail generated during upload: %v", err)
+	}
+	var jobAsset string
+	if err := h.DB.QueryRow(`SELECT asset_id FROM jobs WHERE job_type = 'thumbnail' AND state = 'PENDING'`).Scan(&jobAsset); err != nil || jobAsset != id {
+		t.Fatalf("thumbnail job for %q, %v; want %s", jobAsset, err, id)
+	}
+
+	r := chi.NewRouter()
+	r.Get("/assets/{id}/thumb", h.AssetThumbnail)
+	get := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, asAccount(httptest.NewRequest("GET", "/assets/"+id+"/thumb", nil), "acc", "member"))
+		return rec
+	}
+
+	rec := get()
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "image/svg+xml" || rec.Header().Get("Cache-Control") != "no-store" {
+		t.Errorf("pending: status = %d, headers = %v; want uncached SVG placeholder", rec.Code, rec.Header())
+	}
+
+	// Once the worker has written the file it is served as usual.
+	os.WriteFile(thumbPath, []byte("\xff\xd8\xff\xe0jpeg"), 0644)
+	if rec := get(); rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "image/jpeg" {
+		t.Errorf("ready: status = %d, Content-Type = %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+
+	// A job that failed leaves no thumbnail and no placeholder.
+	os.Remove(thumbPath)
+	h.DB.Exec(`UPDATE jobs SET state = 'FAILED' WHERE job_type = 'thumbnail'`)
+	if rec := get(); rec.Code != http.StatusNotFound {
+		t.Errorf("failed job: status = %d, want 404", rec.Code)
+	}
+}
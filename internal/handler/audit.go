@@ -0,0 +1,131 @@
+package handler
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/YannKr/downloadonce/internal/db"
+)
+
+// parseAuditFilter reads the action, start and end query parameters shared
+// by the audit page, its CSV export and the API. Dates are inclusive
+// YYYY-MM-DD; an unknown action is rejected rather than matching nothing.
+func parseAuditFilter(r *http.Request) (db.AuditLogFilter, error) {
+	q := r.URL.Query()
+	f := db.AuditLogFilter{Action: q.Get("action"), Start: q.Get("start"), End: q.Get("end")}
+	if f.Action != "" && !db.IsAuditAction(f.Action) {
+		return f, fmt.Errorf("unknown action %q", f.Action)
+	}
+	for _, d := range []string{f.Start, f.End} {
+		if d == "" {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", d); err != nil {
+			return f, fmt.Errorf("invalid date %q, want YYYY-MM-DD", d)
+		}
+	}
+	if f.Start != "" && f.End != "" && f.Start > f.End {
+		return f, fmt.Errorf("start must not be after end")
+	}
+	return f, nil
+}
+
+var auditCSVHeader = []string{"created_at", "action", "actor_id", "actor_email", "actor_name", "target_type", "target_id", "detail", "ip_address"}
+
+// AdminAuditExport - GET /admin/audit/export
+//
+// Streams the audit log entries matching the page's filters as CSV for
+// compliance archiving.
+func (h *Handler) AdminAuditExport(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseAuditFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	select {
+	case h.exportSlots <- struct{}{}:
+		defer func() { <-h.exportSlots }()
+	default:
+		w.Header().Set("Retry-After", "10")
+		http.Error(w, "Too many exports in progress, try again shortly", http.StatusTooManyRequests)
+		return
+	}
+
+	filename := fmt.Sprintf("audit-log-%s.csv", time.Now().UTC().Format("20060102-150405"))
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+
+	// As with the analytics export, a failure after the first page can only
+	// cut the file short.
+	wr := csv.NewWriter(w)
+	wr.Write(auditCSVHeader)
+	err = db.ExportAuditLogs(h.DB, filter, func(l db.AuditLog) error {
+		return wr.Write([]string{
+			l.CreatedAt.UTC().Format(time.RFC3339), l.Action, l.AccountID, l.ActorEmail, l.ActorName,
+			l.TargetType, l.TargetID, l.Detail, l.IPAddress,
+		})
+	})
+	wr.Flush()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "export audit log", "error", err)
+	}
+}
+
+type apiAuditLog struct {
+	ID         string    `json:"id"`
+	Action     string    `json:"action"`
+	ActorID    string    `json:"actor_id"`
+	ActorEmail string    `json:"actor_email,omitempty"`
+	ActorName  string    `json:"actor_name,omitempty"`
+	TargetType string    `json:"target_type"`
+	TargetID   string    `json:"target_id"`
+	Detail     string    `json:"detail"`
+	IPAddress  string    `json:"ip_address"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// APIAuditList - GET /api/v1/audit
+//
+// Read-only, admin-scoped access to the audit log with the same filters as
+// the admin page.
+func (h *Handler) APIAuditList(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseAuditFilter(r)
+	if err != nil {
+		renderJSONError(w, http.StatusBadRequest, "BAD_REQUEST", err.Error())
+		return
+	}
+	page, perPage := paginate(r)
+	total, err := db.CountAuditLogs(h.DB, filter)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "api count audit logs", "error", err)
+		renderJSONError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "failed to list audit logs")
+		return
+	}
+	logs, err := db.ListAuditLogs(h.DB, perPage, (page-1)*perPage, filter)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "api list audit logs", "error", err)
+		renderJSONError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "failed to list audit logs")
+		return
+	}
+
+	result := make([]apiAuditLog, len(logs))
+	for i, l := range logs {
+		result[i] = apiAuditLog{
+			ID:         l.ID,
+			Action:     l.Action,
+			ActorID:    l.AccountID,
+			ActorEmail: l.ActorEmail,
+			ActorName:  l.ActorName,
+			TargetType: l.TargetType,
+			TargetID:   l.TargetID,
+			Detail:     l.Detail,
+			IPAddress:  l.IPAddress,
+			CreatedAt:  l.CreatedAt,
+		}
+	}
+	h.renderPaginated(w, r, result, total, page, perPage)
+}
@@ -0,0 +1,97 @@
+package handler
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func seedAuditLogs(t *testing.T, h *Handler) {
+	t.Helper()
+	seedAccount(t, h.DB, "admin", "admin")
+	for _, row := range [][]string{
+		{"a1", "login", "2025-03-01T09:00:00.000Z"},
+		{"a2", "campaign_created", "2025-03-02T09:00:00.000Z"},
+		{"a3", "campaign_created", "2025-03-04T09:00:00.000Z"},
+		{"a4", "campaign_created", "2025-03-09T09:00:00.000Z"},
+	} {
+		if _, err := h.DB.Exec(`INSERT INTO audit_logs (id, account_id, action, target_type, target_id, detail, created_at)
+			VALUES (?, 'admin', ?, 'campaign', 'camp-1', 'd', ?)`, row[0], row[1], row[2]); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestAdminAuditExport(t *testing.T) {
+	h := newTestHandler(t)
+	seedAuditLogs(t, h)
+
+	req := asAccount(httptest.NewRequest("GET", "/admin/audit/export?action=campaign_created&start=2025-03-01&end=2025-03-05", nil), "admin", "admin")
+	rec := httptest.NewRecorder()
+	h.AdminAuditExport(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	records, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("got %d rows, want header + 2: %v", len(records), records)
+	}
+	if row := records[1]; row[0] != "2025-03-04T09:00:00Z" || row[1] != "campaign_created" || row[2] != "admin" ||
+		row[3] != "admin@example.com" || row[5] != "campaign" || row[6] != "camp-1" {
+		t.Errorf("first row = %v", row)
+	}
+
+	rec = httptest.NewRecorder()
+	h.AdminAuditExport(rec, asAccount(httptest.NewRequest("GET", "/admin/audit/export?action=bogus", nil), "admin", "admin"))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown action: status = %d, want 400", rec.Code)
+	}
+}
+
+func TestAPIAuditList(t *testing.T) {
+	h := newTestHandler(t)
+	seedAuditLogs(t, h)
+
+	get := func(query string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.APIAuditList(rec, asAccount(httptest.NewRequest("GET", "/api/v1/audit"+query, nil), "admin", "admin"))
+		return rec
+	}
+
+	rec := get("?action=campaign_created&per_page=2&page=2")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	var got struct {
+		Data    []apiAuditLog `json:"data"`
+		Total   int           `json:"total"`
+		Page    int           `json:"page"`
+		PerPage int           `json:"per_page"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Total != 3 || got.Page != 2 || got.PerPage != 2 || len(got.Data) != 1 || got.Data[0].ID != "a2" {
+		t.Errorf("page 2 = %+v", got)
+	}
+	if got.Data[0].ActorEmail != "admin@example.com" || got.Data[0].TargetID != "camp-1" {
+		t.Errorf("entry = %+v", got.Data[0])
+	}
+	// Links keep the filter.
+	if link := rec.Header().Get("Link"); !strings.Contains(link, "/api/v1/audit?action=campaign_created&page=1&per_page=2>; rel=\"prev\"") ||
+		strings.Contains(link, `rel="next"`) {
+		t.Errorf("Link = %s", link)
+	}
+
+	for _, q := range []string{"?start=03/01/2025", "?start=2025-03-05&end=2025-03-01", "?action=nope"} {
+		if rec := get(q); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", q, rec.Code)
+		}
+	}
+}
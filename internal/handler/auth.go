@@ -3,6 +3,7 @@ package handler
 import (
 	"log/slog"
 	"net/http"
+	"net/url"
 	"strings"
 	"time"
 
@@ -79,47 +80,68 @@ func (h *Handler) LoginForm(w http.ResponseWriter, r *http.Request) {
 	}
 	h.render(w, r, "login.html", PageData{Title: "Login", Data: map[string]interface{}{
 		"AllowRegistration": h.Cfg.AllowRegistration,
+		"Next":              h.safeRedirect(r.URL.Query().Get("next"), ""),
 	}})
 }
 
 func (h *Handler) LoginSubmit(w http.ResponseWriter, r *http.Request) {
 	email := strings.TrimSpace(r.FormValue("email"))
 	password := r.FormValue("password")
+	next := h.safeRedirect(r.FormValue("next"), "")
 
 	account, err := db.GetAccountByEmail(h.DB, email)
 	if err != nil || account == nil || !auth.CheckPassword(account.PasswordHash, password) {
 		h.render(w, r, "login.html", PageData{Title: "Login", Error: "Invalid email or password.",
-			Data: map[string]interface{}{"Email": email, "AllowRegistration": h.Cfg.AllowRegistration}})
+			Data: map[string]interface{}{"Email": email, "AllowRegistration": h.Cfg.AllowRegistration, "Next": next}})
 		return
 	}
 
 	if !account.Enabled {
 		h.render(w, r, "login.html", PageData{Title: "Login", Error: "Your account has been disabled.",
-			Data: map[string]interface{}{"Email": email, "AllowRegistration": h.Cfg.AllowRegistration}})
+			Data: map[string]interface{}{"Email": email, "AllowRegistration": h.Cfg.AllowRegistration, "Next": next}})
 		return
 	}
 
-	sessionID, err := auth.GenerateToken(32)
-	if err != nil {
+	if account.TOTPSecret != "" {
+		// Password is correct; the session is only created after the code.
+		auth.SetPendingLoginCookie(w, account.ID, account.TOTPLastStep, h.Cfg.SessionSecret)
+		target := "/login/2fa"
+		if next != "" {
+			target += "?next=" + url.QueryEscape(next)
+		}
+		http.Redirect(w, r, target, http.StatusSeeOther)
+		return
+	}
+
+	if err := h.startSession(w, r, account.ID); err != nil {
+		slog.ErrorContext(r.Context(), "create session", "error", err)
 		h.render(w, r, "login.html", PageData{Title: "Login", Error: "Internal error.",
-			Data: map[string]interface{}{"AllowRegistration": h.Cfg.AllowRegistration}})
+			Data: map[string]interface{}{"AllowRegistration": h.Cfg.AllowRegistration, "Next": next}})
 		return
 	}
+	db.InsertAuditLog(h.DB, account.ID, "login", "account", account.ID, "", r.RemoteAddr)
+	http.Redirect(w, r, h.safeRedirect(next, "/dashboard"), http.StatusSeeOther)
+}
 
+// startSession creates a session for accountID and sets the session cookie.
+// The browser and client IP are recorded for the settings session list.
+func (h *Handler) startSession(w http.ResponseWriter, r *http.Request, accountID string) error {
+	sessionID, err := auth.GenerateToken(32)
+	if err != nil {
+		return err
+	}
 	session := &model.Session{
 		ID:        sessionID,
-		AccountID: account.ID,
+		AccountID: accountID,
+		UserAgent: truncate(r.UserAgent(), 255),
+		IP:        realIP(r),
 		ExpiresAt: time.Now().Add(auth.SessionMaxAge),
 	}
 	if err := db.CreateSession(h.DB, session); err != nil {
-		h.render(w, r, "login.html", PageData{Title: "Login", Error: "Internal error.",
-			Data: map[string]interface{}{"AllowRegistration": h.Cfg.AllowRegistration}})
-		return
+		return err
 	}
-
 	auth.SetSessionCookie(w, sessionID, h.Cfg.SessionSecret)
-	db.InsertAuditLog(h.DB, account.ID, "login", "account", account.ID, "", r.RemoteAddr)
-	http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+	return nil
 }
 
 func (h *Handler) Logout(w http.ResponseWriter, r *http.Request) {
@@ -228,7 +250,7 @@ func (h *Handler) ForgotPasswordSubmit(w http.ResponseWriter, r *http.Request) {
 	// Generate token
 	token, err := auth.GenerateToken(32)
 	if err != nil {
-		slog.Error("generate reset token", "error", err)
+		slog.ErrorContext(r.Context(), "generate reset token", "error", err)
 		h.render(w, r, "forgot_password.html", PageData{Title: "Forgot Password", Flash: successMsg})
 		return
 	}
@@ -237,7 +259,7 @@ func (h *Handler) ForgotPasswordSubmit(w http.ResponseWriter, r *http.Request) {
 	expiresAt := time.Now().Add(1 * time.Hour)
 
 	if err := db.CreatePasswordReset(h.DB, uuid.New().String(), account.ID, tokenHash, expiresAt); err != nil {
-		slog.Error("create password reset", "error", err)
+		slog.ErrorContext(r.Context(), "create password reset", "error", err)
 		h.render(w, r, "forgot_password.html", PageData{Title: "Forgot Password", Flash: successMsg})
 		return
 	}
@@ -246,7 +268,7 @@ func (h *Handler) ForgotPasswordSubmit(w http.ResponseWriter, r *http.Request) {
 
 	resetURL := h.Cfg.BaseURL + "/reset-password?token=" + token
 	if err := h.Mailer.SendPasswordReset(account.Email, account.Name, resetURL); err != nil {
-		slog.Error("send password reset email", "error", err)
+		slog.ErrorContext(r.Context(), "send password reset email", "error", err)
 	}
 
 	h.render(w, r, "forgot_password.html", PageData{Title: "Forgot Password", Flash: successMsg})
@@ -313,7 +335,7 @@ func (h *Handler) ResetPasswordSubmit(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := db.UpdateAccountPassword(h.DB, pr.AccountID, hash); err != nil {
-		slog.Error("update password", "error", err)
+		slog.ErrorContext(r.Context(), "update password", "error", err)
 		h.render(w, r, "reset_password.html", PageData{Title: "Reset Password",
 			Error: "Internal error.",
 			Data:  map[string]string{"Token": token}})
@@ -325,6 +347,10 @@ func (h *Handler) ResetPasswordSubmit(w http.ResponseWriter, r *http.Request) {
 	db.MarkPasswordResetUsed(h.DB, pr.ID)
 	// Invalidate all sessions for this user
 	db.DeleteSessionsByAccount(h.DB, pr.AccountID)
+	// A reset after a compromise can also cut off keys the attacker made.
+	if r.FormValue("revoke_api_keys") != "" {
+		h.revokeAPIKeys(r, pr.AccountID, pr.AccountID, "on password reset")
+	}
 
 	http.Redirect(w, r, "/login?reset=1", http.StatusSeeOther)
 }
@@ -0,0 +1,199 @@
+package handler
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/YannKr/downloadonce/internal/auth"
+	"github.com/YannKr/downloadonce/internal/db"
+	"github.com/YannKr/downloadonce/internal/watermark"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// CampaignWatermarkedThumb handles GET /campaigns/{id}/watermarked-thumb: a
+// small JPEG of the campaign's first watermarked copy, so the owner can check
+// how the visible mark looks. It is generated on first request and cached
+// beside the copies as preview.jpg; a newer copy (after a retry or reissue)
+// regenerates it.
+func (h *Handler) CampaignWatermarkedThumb(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	accountID := auth.AccountFromContext(r.Context())
+
+	campaign, err := db.GetCampaign(h.DB, id)
+	if err != nil || campaign == nil || (campaign.AccountID != accountID && !auth.IsAdmin(r.Context())) {
+		http.NotFound(w, r)
+		return
+	}
+	rel, err := db.FirstWatermarkedPath(h.DB, campaign.ID)
+	if err != nil {
+		http.Error(w, "Internal error", 500)
+		return
+	}
+	if rel == "" {
+		http.Error(w, "No watermarked copy has been generated yet", http.StatusNotFound)
+		return
+	}
+	srcPath := filepath.Join(h.Cfg.DataDir, rel)
+	srcInfo, err := os.Stat(srcPath)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	thumbPath := filepath.Join(h.Cfg.DataDir, "watermarked", campaign.ID, "preview.jpg")
+	if info, err := os.Stat(thumbPath); err != nil || info.Size() == 0 || info.ModTime().Before(srcInfo.ModTime()) {
+		if err := generatePreview(r.Context(), srcPath, thumbPath); err != nil {
+			slog.WarnContext(r.Context(), "watermarked preview failed", "campaign", campaign.ID, "error", err)
+			http.NotFound(w, r)
+			return
+		}
+	}
+
+	// ServeFile sends Last-Modified, so a regenerated preview is picked up
+	// on revalidation.
+	w.Header().Set("Cache-Control", "private, no-cache")
+	http.ServeFile(w, r, thumbPath)
+}
+
+// maxConcurrentPreviews bounds the campaign previews rendering at once; each
+// runs ImageMagick or ffmpeg on the full-size original.
+const maxConcurrentPreviews = 2
+
+// Stand-in recipient for previewing a campaign that has no recipients yet.
+const (
+	previewRecipientName  = "Jane Doe"
+	previewRecipientEmail = "jane.doe@example.com"
+	previewRecipientOrg   = "Example Org"
+)
+
+// CampaignPreview handles POST /campaigns/{id}/preview: the campaign's asset
+// with the visible watermark its current settings draw, for its first
+// recipient, returned inline as a JPEG. Videos get a single frame. Nothing is
+// recorded: the token ID in the text is never stored, no watermark_index
+// entry is written and the rendered file is removed once sent.
+func (h *Handler) CampaignPreview(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	accountID := auth.AccountFromContext(r.Context())
+
+	campaign, err := db.GetCampaign(h.DB, id)
+	if err != nil || campaign == nil || (campaign.AccountID != accountID && !auth.IsAdmin(r.Context())) {
+		http.NotFound(w, r)
+		return
+	}
+	asset, err := db.GetAsset(h.DB, campaign.AssetID)
+	if err != nil || asset == nil {
+		http.Error(w, "The campaign's asset no longer exists", http.StatusNotFound)
+		return
+	}
+
+	select {
+	case h.previewSlots <- struct{}{}:
+		defer func() { <-h.previewSlots }()
+	default:
+		w.Header().Set("Retry-After", "10")
+		http.Error(w, "Too many previews in progress, try again shortly", http.StatusTooManyRequests)
+		return
+	}
+
+	name, email, org := previewRecipientName, previewRecipientEmail, previewRecipientOrg
+	if tokens, _ := db.ListTokensByCampaignPaged(h.DB, campaign.ID, 1, 0); len(tokens) > 0 {
+		name, email, org = tokens[0].RecipientName, tokens[0].RecipientEmail, tokens[0].RecipientOrg
+	}
+	textData := watermark.NewTextData(uuid.New().String(), name, email, org, campaign.Name, time.Now())
+	wmText, err := watermark.WatermarkText(campaign.WMTextTemplate, textData)
+	if err != nil {
+		// As in the worker, a broken template falls back to the default.
+		wmText, _ = watermark.WatermarkText("", textData)
+	}
+	var opacity float64
+	var fontSize int
+	if campaign.VisibleOpacity != nil {
+		opacity = *campaign.VisibleOpacity
+	}
+	if campaign.VisibleFontSize != nil {
+		fontSize = *campaign.VisibleFontSize
+	}
+
+	outDir := filepath.Join(h.Cfg.DataDir, "watermarked", campaign.ID)
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		slog.ErrorContext(r.Context(), "create preview dir", "error", err)
+		http.Error(w, "Internal error", 500)
+		return
+	}
+	out := filepath.Join(outDir, "settings-preview-"+uuid.New().String()+".jpg")
+	defer os.Remove(out)
+
+	ctx, cancel := context.WithTimeout(r.Context(), 60*time.Second)
+	defer cancel()
+	inputPath := filepath.Join(h.Cfg.DataDir, asset.OriginalPath)
+	if asset.AssetType == "video" {
+		var duration float64
+		if asset.Duration != nil {
+			duration = *asset.Duration
+		}
+		err = watermark.VideoWatermarkFrame(ctx, watermark.VideoParams{
+			InputPath:  inputPath,
+			OutputPath: out,
+			Text:       wmText,
+			FontPath:   h.Cfg.FontPath,
+			Position:   campaign.VisiblePosition,
+			Opacity:    opacity,
+			FontSize:   fontSize,
+		}, watermark.PosterSeek(duration))
+	} else {
+		err = watermark.ImageWatermark(ctx, watermark.ImageParams{
+			InputPath:  inputPath,
+			OutputPath: out,
+			Text:       wmText,
+			FontPath:   h.Cfg.FontPath,
+			Position:   campaign.VisiblePosition,
+			Opacity:    opacity,
+			FontSize:   fontSize,
+		})
+	}
+	if err != nil {
+		slog.WarnContext(r.Context(), "campaign preview failed", "campaign", campaign.ID, "error", err)
+		http.Error(w, "The preview could not be generated", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Disposition", `inline; filename="preview.jpg"`)
+	http.ServeFile(w, r, out)
+}
+
+// generatePreview writes a preview of srcPath to dst via a temporary file so
+// concurrent requests never serve a half-written image.
+func generatePreview(ctx context.Context, srcPath, dst string) error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	tmp := strings.TrimSuffix(dst, ".jpg") + "-" + uuid.New().String() + ".jpg"
+	var err error
+	if isVideoExt(filepath.Ext(srcPath)) {
+		err = watermark.ExtractVideoThumbnail(ctx, srcPath, tmp, 1)
+	} else {
+		err = watermark.ExtractImagePreview(ctx, srcPath, tmp)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dst)
+}
+
+func isVideoExt(ext string) bool {
+	ext = strings.ToLower(ext)
+	for mime, e := range watermark.MimeToExt {
+		if e == ext {
+			return watermark.MimeToAssetType[mime] == "video"
+		}
+	}
+	return false
+}
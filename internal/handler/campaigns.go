@@ -1,10 +1,17 @@
 package handler
 
 import (
+	"archive/zip"
+	"context"
 	"encoding/csv"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -13,30 +20,78 @@ import (
 	"github.com/google/uuid"
 	"github.com/YannKr/downloadonce/internal/auth"
 	"github.com/YannKr/downloadonce/internal/db"
+	"github.com/YannKr/downloadonce/internal/diskstat"
+	"github.com/YannKr/downloadonce/internal/email"
+	"github.com/YannKr/downloadonce/internal/logging"
 	"github.com/YannKr/downloadonce/internal/model"
+	"github.com/YannKr/downloadonce/internal/watermark"
 )
 
 type campaignNewData struct {
-	Assets         []model.Asset
-	Recipients     []model.Recipient
-	Groups         []model.RecipientGroupSummary
-	Name           string
-	AssetID        string
-	MaxDownloads   string
-	ExpiresAt      string
-	SelectedIDs    map[string]bool
-	SelectedGroups map[string]bool
-	VisibleWM      bool
-	InvisibleWM    bool
+	Assets          []model.Asset
+	Recipients      []model.Recipient // listed up front; see pickerRecipients
+	RecipientTotal  int
+	Groups          []model.RecipientGroupSummary
+	Name            string
+	AssetID         string
+	MaxDownloads    string
+	MaxTotal        string
+	ExpiresAt       string
+	SelectedIDs     map[string]bool
+	SelectedGroups  map[string]bool
+	VisibleWM       bool
+	InvisibleWM     bool
+	WMTextTemplate  string
+	VisiblePosition string
+	VisibleOpacity  string
+	VisibleFontSize string
+	WMChannels      string
+	WMScale         string
+	SelfVerify      string // "", "on" or "off"; empty inherits WM_SELF_VERIFY
+	SelfVerifyOn    bool   // WM_SELF_VERIFY, shown as the inherited choice
+	DownloadMessage string
+	ExpiryMessage   string
+	LinkRequests    bool
+	Positions       []string
+	FilenameTmpl    string
 }
 
 type campaignDetailData struct {
 	Campaign            model.CampaignSummary
 	Asset               model.Asset
-	Tokens              []model.TokenWithRecipient
+	Tokens              []model.TokenWithRecipient // the current page
+	TokenTotal          int
+	TokenPagination     *PaginationData      // nil when every token fits on one page
 	Jobs                map[string]model.Job // keyed by token_id
+	FailureReasons      []failureReason      // distinct FAILED job errors, most common first
 	BaseURL             string
 	AvailableRecipients []model.Recipient
+	RequireApproval     bool
+	Approval            *approvalInfo // nil until approved
+	CanApprove          bool          // signed-in account may approve or reject this campaign
+	GraceDays           int           // days a deleted campaign stays restorable
+}
+
+type failureReason struct {
+	Message string
+	Count   int
+}
+
+// summarizeFailures orders failed-job counts by error message so the
+// operator can see why a campaign is PARTIAL or FAILED without opening every
+// token.
+func summarizeFailures(counts map[string]int) []failureReason {
+	out := make([]failureReason, 0, len(counts))
+	for msg, n := range counts {
+		out = append(out, failureReason{Message: msg, Count: n})
+	}
+	sort.Slice(out, func(i, k int) bool {
+		if out[i].Count != out[k].Count {
+			return out[i].Count > out[k].Count
+		}
+		return out[i].Message < out[k].Message
+	})
+	return out
 }
 
 func (h *Handler) CampaignList(w http.ResponseWriter, r *http.Request) {
@@ -44,32 +99,192 @@ func (h *Handler) CampaignList(w http.ResponseWriter, r *http.Request) {
 	showArchived := r.URL.Query().Get("archived") == "1"
 	campaigns, err := db.ListCampaigns(h.DB, accountID, false, showArchived)
 	if err != nil {
-		slog.Error("list campaigns", "error", err)
+		slog.ErrorContext(r.Context(), "list campaigns", "error", err)
 		http.Error(w, "Internal error", 500)
 		return
 	}
+	var deleted []model.Campaign
+	if showArchived {
+		deleted, err = db.ListDeletedCampaigns(h.DB, accountID, false)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "list deleted campaigns", "error", err)
+			http.Error(w, "Internal error", 500)
+			return
+		}
+	}
 	h.renderAuth(w, r, "campaign_list.html", "My Campaigns", map[string]interface{}{
 		"Campaigns":    campaigns,
 		"ShowArchived": showArchived,
+		"Deleted":      deleted,
+		"GraceDays":    h.Cfg.DeleteGraceDays,
 	})
 }
 
+// recipientPickerMax is the most recipients the new campaign form lists as
+// checkboxes; beyond it recipients are added through the search box.
+const recipientPickerMax = 200
+
+// pickerRecipients returns the recipients the new campaign form lists up
+// front and the total number of recipients in the caller's recipientScope:
+// everyone for short lists, otherwise only those already selected.
+func (h *Handler) pickerRecipients(r *http.Request, selected map[string]bool) ([]model.Recipient, int) {
+	scope := recipientScope(r)
+	total, err := db.CountRecipients(h.DB, scope)
+	if err != nil {
+		slog.Error("count recipients", "error", err)
+	}
+	if total <= recipientPickerMax {
+		recipients, _ := db.ListRecipients(h.DB, scope)
+		return recipients, total
+	}
+	var recipients []model.Recipient
+	for id := range selected {
+		if rec, _ := db.GetRecipient(h.DB, id); rec != nil {
+			recipients = append(recipients, *rec)
+		}
+	}
+	sort.Slice(recipients, func(i, j int) bool { return recipients[i].Name < recipients[j].Name })
+	return recipients, total
+}
+
 func (h *Handler) CampaignNewForm(w http.ResponseWriter, r *http.Request) {
 	accountID := auth.AccountFromContext(r.Context())
 	assets, _ := db.ListAssets(h.DB)
-	recipients, _ := db.ListRecipients(h.DB)
+	recipients, recipientTotal := h.pickerRecipients(r, nil)
 	groups, _ := db.ListRecipientGroups(h.DB, accountID)
+	selectedGroups := make(map[string]bool)
+	if account, _ := db.GetAccountByID(h.DB, accountID); account != nil && account.DefaultGroupID != "" {
+		selectedGroups[account.DefaultGroupID] = true
+	}
 	h.renderAuth(w, r, "campaign_new.html", "New Campaign", campaignNewData{
 		Assets:         assets,
 		Recipients:     recipients,
+		RecipientTotal: recipientTotal,
 		Groups:         groups,
 		SelectedIDs:    make(map[string]bool),
-		SelectedGroups: make(map[string]bool),
+		SelectedGroups: selectedGroups,
 		VisibleWM:      true,
 		InvisibleWM:    true,
+		SelfVerifyOn:   h.Cfg.WMSelfVerify,
+		Positions:      watermark.VisiblePositions,
 	})
 }
 
+// campaignLimitReached returns a user-facing message when accountID already
+// holds as many non-archived campaigns as it is allowed, or "" otherwise.
+func (h *Handler) campaignLimitReached(accountID string) (string, error) {
+	limit := h.Cfg.MaxCampaignsPerAccount
+	account, err := db.GetAccountByID(h.DB, accountID)
+	if err != nil {
+		return "", err
+	}
+	if account != nil && account.MaxCampaigns != nil {
+		limit = *account.MaxCampaigns
+	}
+	if limit <= 0 {
+		return "", nil
+	}
+	n, err := db.CountActiveCampaignsByAccount(h.DB, accountID)
+	if err != nil {
+		return "", err
+	}
+	if n < limit {
+		return "", nil
+	}
+	return fmt.Sprintf("Campaign limit reached: this account may have %d campaigns that are not archived. Archive a campaign to create another.", limit), nil
+}
+
+// validateVisibleStyle checks optional visible watermark overrides. Unlike
+// watermark.ValidateVisibleStyle, an explicit zero is rejected: leave the
+// value out to keep the default.
+func validateVisibleStyle(position string, opacity *float64, fontSize *int) error {
+	if opacity != nil && *opacity <= 0 {
+		return errors.New("visible watermark opacity must be greater than 0")
+	}
+	if fontSize != nil && *fontSize <= 0 {
+		return fmt.Errorf("visible watermark font size must be between %d and %d", watermark.MinVisibleFontSize, watermark.MaxVisibleFontSize)
+	}
+	var o float64
+	var f int
+	if opacity != nil {
+		o = *opacity
+	}
+	if fontSize != nil {
+		f = *fontSize
+	}
+	return watermark.ValidateVisibleStyle(position, o, f)
+}
+
+// normalizeInvisibleOverride upper-cases and strips spaces from a campaign's
+// invisible watermark channels and checks the pair; a missing half falls
+// back to the watermark defaults for the check only.
+func normalizeInvisibleOverride(channels string, scale *float64) (string, error) {
+	channels = strings.ToUpper(strings.ReplaceAll(channels, " ", ""))
+	if channels == "" && scale == nil {
+		return "", nil
+	}
+	c, s := channels, watermark.DefaultScale
+	if c == "" {
+		c = watermark.DefaultChannels
+	}
+	if scale != nil {
+		s = *scale
+	}
+	if _, err := watermark.NewInvisibleParams(c, s); err != nil {
+		return "", err
+	}
+	return channels, nil
+}
+
+// parseInvisibleOverride reads the optional invisible watermark channels and
+// scale of the campaign form; blank fields keep the server defaults.
+func parseInvisibleOverride(r *http.Request) (channels string, scale *float64, err error) {
+	if v := strings.TrimSpace(r.FormValue("wm_scale")); v != "" {
+		f, perr := strconv.ParseFloat(v, 64)
+		if perr != nil {
+			return "", nil, errors.New("invisible watermark scale must be a number")
+		}
+		scale = &f
+	}
+	channels, err = normalizeInvisibleOverride(r.FormValue("wm_channels"), scale)
+	return channels, scale, err
+}
+
+// parseSelfVerify reads the campaign form's self-verify choice: "on" or
+// "off" override WM_SELF_VERIFY, anything else inherits it.
+func parseSelfVerify(v string) *bool {
+	switch v {
+	case "on":
+		b := true
+		return &b
+	case "off":
+		b := false
+		return &b
+	}
+	return nil
+}
+
+// parseVisibleStyle reads the optional visible watermark fields of the
+// campaign form; blank fields stay nil.
+func parseVisibleStyle(r *http.Request) (position string, opacity *float64, fontSize *int, err error) {
+	position = r.FormValue("visible_wm_position")
+	if v := strings.TrimSpace(r.FormValue("visible_wm_opacity")); v != "" {
+		o, perr := strconv.ParseFloat(v, 64)
+		if perr != nil {
+			return "", nil, nil, errors.New("visible watermark opacity must be a number")
+		}
+		opacity = &o
+	}
+	if v := strings.TrimSpace(r.FormValue("visible_wm_font_size")); v != "" {
+		n, perr := strconv.Atoi(v)
+		if perr != nil {
+			return "", nil, nil, errors.New("visible watermark font size must be a whole number")
+		}
+		fontSize = &n
+	}
+	return position, opacity, fontSize, validateVisibleStyle(position, opacity, fontSize)
+}
+
 func (h *Handler) CampaignCreate(w http.ResponseWriter, r *http.Request) {
 	accountID := auth.AccountFromContext(r.Context())
 	r.ParseForm()
@@ -98,14 +313,41 @@ func (h *Handler) CampaignCreate(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	wmText := strings.TrimSpace(r.FormValue("wm_text_template"))
+	position, opacity, fontSize, styleErr := parseVisibleStyle(r)
+	wmChannels, wmScale, invisibleErr := parseInvisibleOverride(r)
+	message := strings.TrimSpace(r.FormValue("download_message"))
+	expiryMessage := strings.TrimSpace(r.FormValue("expiry_message"))
+	filenameTmpl := strings.TrimSpace(r.FormValue("filename_template"))
+	formError := ""
 	if assetID == "" || name == "" || len(finalIDs) == 0 {
+		formError = "Asset, name, and at least one recipient or group are required."
+	} else if err := watermark.ValidateTextTemplate(wmText); err != nil {
+		formError = "Invalid watermark text: " + err.Error()
+	} else if err := styleErr; err != nil {
+		formError = "Invalid watermark style: " + err.Error()
+	} else if err := invisibleErr; err != nil {
+		formError = "Invalid invisible watermark settings: " + err.Error()
+	} else if len(message) > maxDownloadMessageLen {
+		formError = fmt.Sprintf("Download message must be at most %d characters.", maxDownloadMessageLen)
+	} else if len(expiryMessage) > maxDownloadMessageLen {
+		formError = fmt.Sprintf("Expired link message must be at most %d characters.", maxDownloadMessageLen)
+	} else if err := validateFilenameTemplate(filenameTmpl); err != nil {
+		formError = "Invalid download file name: " + err.Error()
+	}
+	if formError == "" && r.FormValue("invisible_wm") == "on" {
+		if asset, _ := db.GetAsset(h.DB, assetID); asset != nil {
+			formError = invisibleSizeProblem(asset)
+		}
+	}
+	if formError != "" {
 		assets, _ := db.ListAssets(h.DB)
-		recipients, _ := db.ListRecipients(h.DB)
 		groups, _ := db.ListRecipientGroups(h.DB, accountID)
 		selected := make(map[string]bool)
 		for _, rid := range recipientIDs {
 			selected[rid] = true
 		}
+		recipients, recipientTotal := h.pickerRecipients(r, selected)
 		selectedGroups := make(map[string]bool)
 		for _, gid := range groupIDs {
 			selectedGroups[gid] = true
@@ -113,38 +355,73 @@ func (h *Handler) CampaignCreate(w http.ResponseWriter, r *http.Request) {
 		h.render(w, r, "campaign_new.html", PageData{
 			Title: "New Campaign", Authenticated: true,
 			IsAdmin: auth.IsAdmin(r.Context()), UserName: auth.NameFromContext(r.Context()),
-			Error: "Asset, name, and at least one recipient or group are required.",
+			Error: formError,
 			Data: campaignNewData{
-				Assets:         assets,
-				Recipients:     recipients,
-				Groups:         groups,
-				Name:           name,
-				AssetID:        assetID,
-				MaxDownloads:   r.FormValue("max_downloads"),
-				ExpiresAt:      r.FormValue("expires_at"),
-				SelectedIDs:    selected,
-				SelectedGroups: selectedGroups,
-				VisibleWM:      r.FormValue("visible_wm") == "on",
-				InvisibleWM:    r.FormValue("invisible_wm") == "on",
+				Assets:          assets,
+				Recipients:      recipients,
+				RecipientTotal:  recipientTotal,
+				Groups:          groups,
+				Name:            name,
+				AssetID:         assetID,
+				MaxDownloads:    r.FormValue("max_downloads"),
+				MaxTotal:        r.FormValue("max_total_downloads"),
+				ExpiresAt:       r.FormValue("expires_at"),
+				SelectedIDs:     selected,
+				SelectedGroups:  selectedGroups,
+				VisibleWM:       r.FormValue("visible_wm") == "on",
+				InvisibleWM:     r.FormValue("invisible_wm") == "on",
+				WMTextTemplate:  wmText,
+				VisiblePosition: position,
+				VisibleOpacity:  r.FormValue("visible_wm_opacity"),
+				VisibleFontSize: r.FormValue("visible_wm_font_size"),
+				WMChannels:      r.FormValue("wm_channels"),
+				WMScale:         r.FormValue("wm_scale"),
+				SelfVerify:      r.FormValue("wm_self_verify"),
+				SelfVerifyOn:    h.Cfg.WMSelfVerify,
+				DownloadMessage: message,
+				ExpiryMessage:   expiryMessage,
+				LinkRequests:    r.FormValue("link_requests") == "on",
+				Positions:       watermark.VisiblePositions,
+				FilenameTmpl:    filenameTmpl,
 			},
 		})
 		return
 	}
 
 	asset, err := db.GetAsset(h.DB, assetID)
-	if err != nil || asset == nil {
+	if err != nil || asset == nil || asset.DeletedAt != nil {
 		http.Error(w, "Invalid asset", 400)
 		return
 	}
 
+	if msg, err := h.campaignLimitReached(accountID); err != nil {
+		http.Error(w, "Internal error", 500)
+		return
+	} else if msg != "" {
+		http.Error(w, msg, http.StatusConflict)
+		return
+	}
+
 	campaign := &model.Campaign{
-		ID:          uuid.New().String(),
-		AccountID:   accountID,
-		AssetID:     assetID,
-		Name:        name,
-		VisibleWM:   r.FormValue("visible_wm") == "on",
-		InvisibleWM: r.FormValue("invisible_wm") == "on",
-		State:       "DRAFT",
+		ID:              uuid.New().String(),
+		AccountID:       accountID,
+		AssetID:         assetID,
+		Name:            name,
+		VisibleWM:       r.FormValue("visible_wm") == "on",
+		InvisibleWM:     r.FormValue("invisible_wm") == "on",
+		WMTextTemplate:  wmText,
+		VisiblePosition: position,
+		VisibleOpacity:  opacity,
+		VisibleFontSize: fontSize,
+		WMChannels:      wmChannels,
+		WMScale:         wmScale,
+		SelfVerify:      parseSelfVerify(r.FormValue("wm_self_verify")),
+		DownloadMessage: message,
+		ExpiryMessage:   expiryMessage,
+		LinkRequests:    r.FormValue("link_requests") == "on",
+		State:           "DRAFT",
+
+		FilenameTemplate: filenameTmpl,
 	}
 
 	if maxDL := r.FormValue("max_downloads"); maxDL != "" {
@@ -153,6 +430,11 @@ func (h *Handler) CampaignCreate(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	if maxTotal := r.FormValue("max_total_downloads"); maxTotal != "" {
+		if n, err := strconv.Atoi(maxTotal); err == nil && n > 0 {
+			campaign.MaxTotalDownloads = &n
+		}
+	}
 	if expiry := r.FormValue("expires_at"); expiry != "" {
 		if t, err := time.Parse("2006-01-02T15:04", expiry); err == nil {
 			campaign.ExpiresAt = &t
@@ -160,7 +442,7 @@ func (h *Handler) CampaignCreate(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := db.CreateCampaign(h.DB, campaign); err != nil {
-		slog.Error("create campaign", "error", err)
+		slog.ErrorContext(r.Context(), "create campaign", "error", err)
 		http.Error(w, "Internal error", 500)
 		return
 	}
@@ -175,7 +457,7 @@ func (h *Handler) CampaignCreate(w http.ResponseWriter, r *http.Request) {
 			ExpiresAt:    campaign.ExpiresAt,
 		}
 		if err := db.CreateToken(h.DB, token); err != nil {
-			slog.Error("create token", "error", err)
+			slog.ErrorContext(r.Context(), "create token", "error", err)
 			continue
 		}
 	}
@@ -184,6 +466,9 @@ func (h *Handler) CampaignCreate(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/campaigns/"+campaign.ID, http.StatusSeeOther)
 }
 
+// campaignTokensPerPage is how many tokens the campaign page shows at once.
+const campaignTokensPerPage = 100
+
 func (h *Handler) CampaignDetail(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 	accountID := auth.AccountFromContext(r.Context())
@@ -212,6 +497,8 @@ func (h *Handler) CampaignDetail(w http.ResponseWriter, r *http.Request) {
 		http.NotFound(w, r)
 		return
 	}
+	cs.PublishAt = campaign.PublishAt
+	cs.FilenameTemplate = campaign.FilenameTemplate
 
 	asset, _ := db.GetAsset(h.DB, cs.AssetID)
 	if asset == nil {
@@ -219,18 +506,25 @@ func (h *Handler) CampaignDetail(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	tokens, _ := db.ListTokensByCampaign(h.DB, id)
+	total, _ := db.CountTokensByCampaign(h.DB, id)
+	page, pagination := pageOf(r, total, campaignTokensPerPage)
+	tokens, _ := db.ListTokensByCampaignPaged(h.DB, id, campaignTokensPerPage, (page-1)*campaignTokensPerPage)
 
-	// Load download events for each token
+	// Load download events for each token on the page
 	for i := range tokens {
 		events, _ := db.ListDownloadEventsByToken(h.DB, tokens[i].ID)
 		tokens[i].DownloadEvents = events
 	}
 
-	// Load jobs for progress display (PENDING/RUNNING) and error display (FAILED)
+	// Load the page's jobs for progress display (PENDING/RUNNING) and error
+	// display (FAILED)
 	jobMap := make(map[string]model.Job)
 	{
-		jobs, _ := db.ListJobsByCampaign(h.DB, id)
+		tokenIDs := make([]string, len(tokens))
+		for i := range tokens {
+			tokenIDs[i] = tokens[i].ID
+		}
+		jobs, _ := db.ListJobsByTokens(h.DB, tokenIDs)
 		for _, j := range jobs {
 			if j.State == "PENDING" || j.State == "RUNNING" || j.State == "FAILED" {
 				jobMap[j.TokenID] = j
@@ -238,26 +532,23 @@ func (h *Handler) CampaignDetail(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// Build set of already-added recipient IDs for filtering
-	added := make(map[string]struct{}, len(tokens))
-	for _, t := range tokens {
-		added[t.RecipientID] = struct{}{}
-	}
-	allRecipients, _ := db.ListRecipients(h.DB)
-	var available []model.Recipient
-	for _, rec := range allRecipients {
-		if _, ok := added[rec.ID]; !ok {
-			available = append(available, rec)
-		}
-	}
+	failed, _ := db.CountFailedJobsByError(h.DB, id)
+	available, _ := db.ListRecipientsNotInCampaign(h.DB, id)
 
 	h.renderAuth(w, r, "campaign_detail.html", cs.Name, campaignDetailData{
 		Campaign:            *cs,
 		Asset:               *asset,
 		Tokens:              tokens,
+		TokenTotal:          total,
+		TokenPagination:     pagination,
 		Jobs:                jobMap,
+		FailureReasons:      summarizeFailures(failed),
 		BaseURL:             h.Cfg.BaseURL,
 		AvailableRecipients: available,
+		RequireApproval:     h.Cfg.RequireApproval,
+		Approval:            h.campaignApproval(campaign),
+		CanApprove:          h.approvalProblem(r.Context(), campaign) == "",
+		GraceDays:           h.Cfg.DeleteGraceDays,
 	})
 }
 
@@ -271,7 +562,7 @@ func (h *Handler) CampaignPublish(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if campaign.State != "DRAFT" {
+	if campaign.State != "DRAFT" || campaign.DeletedAt != nil {
 		http.Redirect(w, r, "/campaigns/"+id, http.StatusSeeOther)
 		return
 	}
@@ -282,45 +573,225 @@ func (h *Handler) CampaignPublish(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	asset, err := db.GetAsset(h.DB, campaign.AssetID)
-	if err != nil || asset == nil {
-		http.Error(w, "Asset not found", 500)
+	if h.needsApproval(campaign) {
+		if _, err := db.SubmitCampaignForApproval(h.DB, id); err != nil {
+			http.Error(w, "Internal error", 500)
+			return
+		}
+		db.InsertAuditLog(h.DB, accountID, "campaign_submitted", "campaign", id, campaign.Name, r.RemoteAddr)
+		h.setFlash(w, "Campaign submitted for approval. It can be published once an approver signs off.")
+		http.Redirect(w, r, "/campaigns/"+id, http.StatusSeeOther)
+		return
+	}
+
+	queued, problem, err := h.startPublish(r.Context(), campaign, tokens)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "publish campaign", "error", err, "campaign", id)
+		http.Error(w, "Internal error", 500)
+		return
+	}
+	if problem != nil {
+		h.setFlash(w, problem.msg)
+		http.Redirect(w, r, "/campaigns/"+id, http.StatusSeeOther)
 		return
 	}
+	db.InsertAuditLog(h.DB, accountID, "campaign_published", "campaign", id, campaign.Name, r.RemoteAddr)
+
+	if queued == 0 {
+		h.setFlash(w, "Campaign published.")
+	} else {
+		h.setFlash(w, "Campaign published. Watermarking in progress.")
+	}
+	http.Redirect(w, r, "/campaigns/"+id, http.StatusSeeOther)
+}
+
+// publishProblem is why a campaign cannot be published right now. status and
+// code are what the API reports; msg is shown to the user.
+type publishProblem struct {
+	status int
+	code   string
+	msg    string
+}
+
+// startPublish publishes a DRAFT campaign: every token without a watermarked
+// file gets a watermark job and its link by email, and the campaign moves to
+// PROCESSING, or straight to READY when there is nothing to watermark. Tokens
+// left over from a cancelled publish keep their file if ACTIVE and are not
+// emailed again. It
+// returns how many tokens were queued, or a problem and no changes when the
+// disk or the image cannot take the publish. The web and API handlers and the
+// scheduler all publish through here.
+func (h *Handler) startPublish(ctx context.Context, campaign *model.Campaign, tokens []model.TokenWithRecipient) (int, *publishProblem, error) {
+	asset, err := db.GetAsset(h.DB, campaign.AssetID)
+	if err != nil {
+		return 0, nil, err
+	}
+	if asset == nil {
+		return 0, nil, fmt.Errorf("asset %s not found", campaign.AssetID)
+	}
 
 	jobType := "watermark_video"
 	if asset.AssetType == "image" {
 		jobType = "watermark_image"
 	}
 
+	pending := unpublishedTokens(tokens)
+	if len(pending) == 0 {
+		return 0, nil, db.SetCampaignPublishedReady(h.DB, campaign.ID)
+	}
+	if msg := h.publishDiskProblem(asset, len(pending)); msg != "" {
+		return 0, &publishProblem{http.StatusInsufficientStorage, "INSUFFICIENT_STORAGE", msg}, nil
+	}
+	if campaign.InvisibleWM {
+		if msg := invisibleSizeProblem(asset); msg != "" {
+			return 0, &publishProblem{http.StatusUnprocessableEntity, "IMAGE_TOO_SMALL", msg}, nil
+		}
+	}
+
 	// Set campaign to PROCESSING and enqueue one watermark job per token
-	db.SetCampaignPublished(h.DB, id)
-	for _, t := range tokens {
+	if err := db.SetCampaignPublished(h.DB, campaign.ID); err != nil {
+		return 0, nil, err
+	}
+	for _, t := range pending {
 		job := &model.Job{
 			ID:         uuid.New().String(),
 			JobType:    jobType,
-			CampaignID: id,
+			CampaignID: campaign.ID,
 			TokenID:    t.ID,
+			RequestID:  logging.RequestID(ctx),
 		}
 		if err := db.EnqueueJob(h.DB, job); err != nil {
-			slog.Error("enqueue watermark job", "error", err, "token", t.ID)
+			slog.ErrorContext(ctx, "enqueue watermark job", "error", err, "token", t.ID)
 		}
 	}
-	db.InsertAuditLog(h.DB, accountID, "campaign_published", "campaign", id, campaign.Name, r.RemoteAddr)
 
 	// Send download link emails if SMTP is configured
-	if h.Mailer != nil && h.Mailer.Enabled() {
-		for _, t := range tokens {
-			downloadURL := h.Cfg.BaseURL + "/d/" + t.ID
-			go func(toEmail, name, url string) {
-				if err := h.Mailer.SendDownloadLink(toEmail, name, campaign.Name, url); err != nil {
-					slog.Error("send download email", "error", err, "to", toEmail)
-				}
-			}(t.RecipientEmail, t.RecipientName, downloadURL)
+	h.emailDownloadLinks(campaign, pending)
+	return len(pending), nil, nil
+}
+
+// publishDiskProblem checks that watermarking n copies of asset should fit on
+// disk; see diskSpaceProblem.
+func (h *Handler) publishDiskProblem(asset *model.Asset, n int) string {
+	estimate := diskstat.PublishEstimate(asset.FileSize, n, h.Cfg.WMCompressionFactor)
+	return h.diskSpaceProblem("publishing", estimate)
+}
+
+// invisibleSizeProblem returns a message for the user when asset is an image
+// too small to carry the invisible watermark, or "" when it fits or its size
+// is unknown. Without this check the worker silently falls back to a
+// visible-only copy.
+func invisibleSizeProblem(asset *model.Asset) string {
+	if asset.AssetType != "image" || asset.Width == nil || asset.Height == nil {
+		return ""
+	}
+	if err := watermark.CheckInvisibleSize(int(*asset.Width), int(*asset.Height)); err != nil {
+		return fmt.Sprintf("%s: %v. Use a larger image or turn off the invisible watermark.", asset.OriginalName, err)
+	}
+	return ""
+}
+
+// unpublishedTokens drops tokens that already have a watermarked file.
+func unpublishedTokens(tokens []model.TokenWithRecipient) []model.TokenWithRecipient {
+	var out []model.TokenWithRecipient
+	for _, t := range tokens {
+		if t.State != "ACTIVE" {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// unemailedTokens drops tokens whose link was already emailed by an earlier,
+// cancelled publish.
+func unemailedTokens(tokens []model.TokenWithRecipient) []model.TokenWithRecipient {
+	var out []model.TokenWithRecipient
+	for _, t := range tokens {
+		if t.LinkEmailedAt == nil {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// emailDownloadLinks sends each token's recipient their link in the
+// background, as one batch so the mailer's connection cap applies. Tokens
+// already emailed are skipped, and the rest are marked as emailed before the
+// batch goes out so that publishing again never sends a link twice.
+func (h *Handler) emailDownloadLinks(campaign *model.Campaign, tokens []model.TokenWithRecipient) {
+	if h.Mailer == nil || !h.Mailer.Enabled() {
+		return
+	}
+	tokens = unemailedTokens(tokens)
+	msgs := make([]email.Message, 0, len(tokens))
+	ids := make([]string, 0, len(tokens))
+	for _, t := range tokens {
+		downloadURL := h.Cfg.BaseURL + "/d/" + t.ID
+		msg, err := h.Mailer.DownloadLinkMessage(t.RecipientEmail, email.NewLinkEmail(t.RecipientName, campaign.Name, downloadURL, t.ExpiresAt))
+		if err != nil {
+			slog.Error("render download email", "error", err, "to", t.RecipientEmail)
+			continue
+		}
+		msgs = append(msgs, msg)
+		ids = append(ids, t.ID)
+	}
+	if err := db.MarkLinksEmailed(h.DB, ids); err != nil {
+		slog.Error("mark download links emailed", "campaign_id", campaign.ID, "error", err)
+	}
+	go func() {
+		if err := h.Mailer.SendBatch(msgs); err != nil {
+			slog.Error("send download emails", "campaign_id", campaign.ID, "error", err)
+		}
+	}()
+}
+
+// cancelCampaignPublish stops a PROCESSING campaign: queued jobs are deleted
+// and the campaign returns to DRAFT. Tokens that finished stay ACTIVE; jobs
+// already running are dropped by the worker.
+func (h *Handler) cancelCampaignPublish(campaign *model.Campaign) (int, error) {
+	n, err := db.DeletePendingJobsByCampaign(h.DB, campaign.ID)
+	if err != nil {
+		return 0, err
+	}
+	if err := db.UpdateCampaignState(h.DB, campaign.ID, "DRAFT"); err != nil {
+		return n, err
+	}
+	return n, nil
+}
+
+func (h *Handler) CampaignCancel(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	accountID := auth.AccountFromContext(r.Context())
+
+	campaign, err := db.GetCampaign(h.DB, id)
+	if err != nil || campaign == nil || (campaign.AccountID != accountID && !auth.IsAdmin(r.Context())) {
+		http.NotFound(w, r)
+		return
+	}
+	if campaign.State == "SCHEDULED" {
+		if _, err := db.UnscheduleCampaign(h.DB, id); err != nil {
+			slog.ErrorContext(r.Context(), "unschedule campaign", "error", err, "campaign", id)
+			http.Error(w, "Internal error", 500)
+			return
 		}
+		db.InsertAuditLog(h.DB, accountID, "campaign_unscheduled", "campaign", id, campaign.Name, r.RemoteAddr)
+		h.setFlash(w, "Scheduled publish cancelled. The campaign is a draft again.")
+		http.Redirect(w, r, "/campaigns/"+id, http.StatusSeeOther)
+		return
+	}
+	if campaign.State != "PROCESSING" {
+		http.Redirect(w, r, "/campaigns/"+id, http.StatusSeeOther)
+		return
 	}
 
-	setFlash(w, "Campaign published. Watermarking in progress.")
+	n, err := h.cancelCampaignPublish(campaign)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "cancel campaign publish", "error", err, "campaign", id)
+		http.Error(w, "Internal error", 500)
+		return
+	}
+	db.InsertAuditLog(h.DB, accountID, "campaign_cancelled", "campaign", id, campaign.Name, r.RemoteAddr)
+	h.setFlash(w, fmt.Sprintf("Publish cancelled. %d queued job(s) removed; finished links stay active.", n))
 	http.Redirect(w, r, "/campaigns/"+id, http.StatusSeeOther)
 }
 
@@ -337,10 +808,106 @@ func (h *Handler) TokenRevoke(w http.ResponseWriter, r *http.Request) {
 
 	db.ExpireToken(h.DB, tokenID)
 	db.InsertAuditLog(h.DB, accountID, "token_revoked", "token", tokenID, "", r.RemoteAddr)
-	setFlash(w, "Token revoked.")
+	h.setFlash(w, "Token revoked.")
+	http.Redirect(w, r, "/campaigns/"+campaignID, http.StatusSeeOther)
+}
+
+func (h *Handler) TokenReissue(w http.ResponseWriter, r *http.Request) {
+	campaignID := chi.URLParam(r, "id")
+	tokenID := chi.URLParam(r, "tokenID")
+	accountID := auth.AccountFromContext(r.Context())
+
+	campaign, err := db.GetCampaign(h.DB, campaignID)
+	if err != nil || campaign == nil || (campaign.AccountID != accountID && !auth.IsAdmin(r.Context())) {
+		http.NotFound(w, r)
+		return
+	}
+
+	old, err := db.GetToken(h.DB, tokenID)
+	if err != nil || old == nil || old.CampaignID != campaignID {
+		http.NotFound(w, r)
+		return
+	}
+
+	if !canReissue(campaign.State) {
+		h.setFlash(w, "Links can only be reissued for published campaigns.")
+		http.Redirect(w, r, "/campaigns/"+campaignID, http.StatusSeeOther)
+		return
+	}
+	if live, _ := db.HasLiveToken(h.DB, campaignID, old.RecipientID, old.ID); live {
+		h.setFlash(w, "This recipient already has a newer link.")
+		http.Redirect(w, r, "/campaigns/"+campaignID, http.StatusSeeOther)
+		return
+	}
+
+	token, err := h.reissueToken(r.Context(), campaign, old)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "reissue token", "error", err, "token", tokenID)
+		http.Error(w, "Internal error", 500)
+		return
+	}
+
+	db.InsertAuditLog(h.DB, accountID, "token_reissued", "token", token.ID, "replaces "+tokenID, r.RemoteAddr)
+	h.setFlash(w, "New download link issued: "+h.Cfg.BaseURL+"/d/"+token.ID)
 	http.Redirect(w, r, "/campaigns/"+campaignID, http.StatusSeeOther)
 }
 
+// canReissue reports whether tokens of a campaign in the given state may be
+// reissued. Draft campaigns have not sent any links yet, and expired or
+// archived campaigns no longer serve downloads.
+func canReissue(state string) bool {
+	switch state {
+	case "PROCESSING", "READY", "PARTIAL", "FAILED":
+		return true
+	}
+	return false
+}
+
+// reissueToken expires old and creates a fresh PENDING token for the same
+// recipient, enqueuing a watermark job for it. The new token ID yields a new
+// watermark payload, so the reissued copy is traced independently.
+func (h *Handler) reissueToken(ctx context.Context, campaign *model.Campaign, old *model.DownloadToken) (*model.DownloadToken, error) {
+	asset, err := db.GetAsset(h.DB, campaign.AssetID)
+	if err != nil {
+		return nil, err
+	}
+	if asset == nil {
+		return nil, fmt.Errorf("asset %s not found", campaign.AssetID)
+	}
+
+	token := &model.DownloadToken{
+		ID:           uuid.New().String(),
+		CampaignID:   campaign.ID,
+		RecipientID:  old.RecipientID,
+		MaxDownloads: campaign.MaxDownloads,
+		State:        "PENDING",
+		ExpiresAt:    campaign.ExpiresAt,
+	}
+	if err := db.ReissueToken(h.DB, old.ID, token); err != nil {
+		return nil, err
+	}
+
+	jobType := "watermark_video"
+	if asset.AssetType == "image" {
+		jobType = "watermark_image"
+	}
+	job := &model.Job{
+		ID:         uuid.New().String(),
+		JobType:    jobType,
+		CampaignID: campaign.ID,
+		TokenID:    token.ID,
+		RequestID:  logging.RequestID(ctx),
+	}
+	if err := db.EnqueueJob(h.DB, job); err != nil {
+		return nil, err
+	}
+
+	if campaign.State == "READY" || campaign.State == "PARTIAL" || campaign.State == "FAILED" {
+		db.UpdateCampaignState(h.DB, campaign.ID, "PROCESSING")
+	}
+	return token, nil
+}
+
 func (h *Handler) CampaignClone(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 	accountID := auth.AccountFromContext(r.Context())
@@ -350,15 +917,29 @@ func (h *Handler) CampaignClone(w http.ResponseWriter, r *http.Request) {
 		http.NotFound(w, r)
 		return
 	}
+	if msg, err := h.campaignLimitReached(accountID); err != nil {
+		http.Error(w, "Internal error", 500)
+		return
+	} else if msg != "" {
+		h.setFlash(w, msg)
+		http.Redirect(w, r, "/campaigns/"+id, http.StatusSeeOther)
+		return
+	}
 
 	srcTokens, err := db.ListTokensByCampaign(h.DB, id)
 	if err != nil {
-		slog.Error("clone: list tokens", "error", err)
+		slog.ErrorContext(r.Context(), "clone: list tokens", "error", err)
 		http.Error(w, "Internal error", 500)
 		return
 	}
 	recipientIDs := make([]string, 0, len(srcTokens))
+	seen := make(map[string]struct{}, len(srcTokens))
 	for _, t := range srcTokens {
+		// Reissued links leave several tokens for the same recipient.
+		if _, ok := seen[t.RecipientID]; ok {
+			continue
+		}
+		seen[t.RecipientID] = struct{}{}
 		recipientIDs = append(recipientIDs, t.RecipientID)
 	}
 
@@ -375,7 +956,7 @@ func (h *Handler) CampaignClone(w http.ResponseWriter, r *http.Request) {
 	}
 
 	assetMissing := false
-	if a, _ := db.GetAsset(h.DB, assetID); a == nil {
+	if a, _ := db.GetAsset(h.DB, assetID); a == nil || a.DeletedAt != nil {
 		assetMissing = true
 		assetID = ""
 	}
@@ -388,20 +969,33 @@ func (h *Handler) CampaignClone(w http.ResponseWriter, r *http.Request) {
 	}
 
 	newCampaign := &model.Campaign{
-		ID:          uuid.New().String(),
-		AccountID:   accountID,
-		AssetID:     assetID,
-		Name:        name,
-		MaxDownloads: src.MaxDownloads,
-		ExpiresAt:   newExpiry,
-		VisibleWM:   src.VisibleWM,
-		InvisibleWM: src.InvisibleWM,
-		State:       "DRAFT",
+		ID:              uuid.New().String(),
+		AccountID:       accountID,
+		AssetID:         assetID,
+		Name:            name,
+		MaxDownloads:    src.MaxDownloads,
+		ExpiresAt:       newExpiry,
+		VisibleWM:       src.VisibleWM,
+		InvisibleWM:     src.InvisibleWM,
+		WMChannels:      src.WMChannels,
+		WMScale:         src.WMScale,
+		WMTextTemplate:  src.WMTextTemplate,
+		VisiblePosition: src.VisiblePosition,
+		VisibleOpacity:  src.VisibleOpacity,
+		VisibleFontSize: src.VisibleFontSize,
+		DownloadMessage: src.DownloadMessage,
+		ExpiryMessage:   src.ExpiryMessage,
+		LinkRequests:    src.LinkRequests,
+		State:           "DRAFT",
+
+		MaxTotalDownloads: src.MaxTotalDownloads,
+		FilenameTemplate:  src.FilenameTemplate,
+		SelfVerify:        src.SelfVerify,
 	}
 
 	skipped, err := db.CloneCampaign(h.DB, newCampaign, recipientIDs)
 	if err != nil {
-		slog.Error("clone campaign", "src", id, "error", err)
+		slog.ErrorContext(r.Context(), "clone campaign", "src", id, "error", err)
 		http.Error(w, "Internal error", 500)
 		return
 	}
@@ -414,7 +1008,7 @@ func (h *Handler) CampaignClone(w http.ResponseWriter, r *http.Request) {
 	} else if skipped > 0 {
 		flashMsg = fmt.Sprintf("Campaign cloned. %d recipient(s) were skipped because they no longer exist.", skipped)
 	}
-	setFlash(w, flashMsg)
+	h.setFlash(w, flashMsg)
 	http.Redirect(w, r, "/campaigns/"+newCampaign.ID, http.StatusSeeOther)
 }
 
@@ -438,7 +1032,7 @@ func (h *Handler) CampaignExportLinks(w http.ResponseWriter, r *http.Request) {
 
 	tokens, err := db.ListTokensByCampaign(h.DB, id)
 	if err != nil {
-		slog.Error("export-links: list tokens", "error", err)
+		slog.ErrorContext(r.Context(), "export-links: list tokens", "error", err)
 		http.Error(w, "Internal error", 500)
 		return
 	}
@@ -472,6 +1066,190 @@ func (h *Handler) CampaignExportLinks(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// CampaignExportFiles streams a ZIP of every ACTIVE token's watermarked file,
+// one entry per recipient, for operators who distribute files themselves.
+func (h *Handler) CampaignExportFiles(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	accountID := auth.AccountFromContext(r.Context())
+
+	campaign, err := db.GetCampaign(h.DB, id)
+	if err != nil || campaign == nil || (campaign.AccountID != accountID && !auth.IsAdmin(r.Context())) {
+		http.NotFound(w, r)
+		return
+	}
+	if campaign.State != "READY" {
+		http.Error(w, "File export is only available for READY campaigns.", http.StatusBadRequest)
+		return
+	}
+
+	tokens, err := db.ListTokensByCampaign(h.DB, id)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "export-files: list tokens", "error", err)
+		http.Error(w, "Internal error", 500)
+		return
+	}
+
+	var files []zipFile
+	for _, t := range tokens {
+		if t.State != "ACTIVE" || t.WatermarkedPath == nil {
+			continue
+		}
+		files = append(files, zipFile{t, filepath.Join(h.Cfg.DataDir, *t.WatermarkedPath)})
+	}
+
+	db.InsertAuditLog(h.DB, accountID, "campaign_files_exported", "campaign", id, campaign.Name, r.RemoteAddr)
+	streamTokenZip(w, r, sanitizeFilename(campaign.Name)+"-files.zip", "export-files", files)
+}
+
+// zipEntryName names a token's file after its recipient, numbering repeats
+// ("Bob.jpg", "Bob (2).jpg"). used tracks names handed out so far.
+func zipEntryName(used map[string]int, t model.TokenWithRecipient, ext string) string {
+	base := sanitizeFilename(t.RecipientName)
+	if base == "" {
+		base = t.ID
+	}
+	name := base + ext
+	if n := used[base]; n > 0 {
+		name = fmt.Sprintf("%s (%d)%s", base, n+1, ext)
+	}
+	used[base]++
+	return name
+}
+
+// CampaignBundle (POST, so prefetchers and crawlers cannot start work)
+// streams every recipient's watermarked copy in one zip for the owner to
+// distribute by hand. Tokens that have no file yet (still PENDING, or whose
+// file is gone from disk) get an on-demand job and the request answers 202
+// until they are all ready. The size cap is checked against an estimate
+// before any job is queued.
+func (h *Handler) CampaignBundle(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	accountID := auth.AccountFromContext(r.Context())
+
+	campaign, err := db.GetCampaign(h.DB, id)
+	if err != nil || campaign == nil || (campaign.AccountID != accountID && !auth.IsAdmin(r.Context())) {
+		http.NotFound(w, r)
+		return
+	}
+	switch campaign.State {
+	case "DRAFT":
+		http.Error(w, "Publish the campaign before downloading its files.", http.StatusBadRequest)
+		return
+	case "EXPIRED", "ARCHIVED":
+		http.Error(w, "This campaign is "+strings.ToLower(campaign.State)+"; its files can no longer be bundled.", http.StatusBadRequest)
+		return
+	}
+
+	tokens, err := db.ListTokensByCampaign(h.DB, id)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "bundle: list tokens", "error", err)
+		http.Error(w, "Internal error", 500)
+		return
+	}
+	asset, err := db.GetAsset(h.DB, campaign.AssetID)
+	if err != nil || asset == nil {
+		http.Error(w, "Asset not found", 500)
+		return
+	}
+	jobType := "watermark_video"
+	if asset.AssetType == "image" {
+		jobType = "watermark_image"
+	}
+
+	var files []zipFile
+	var missing []model.TokenWithRecipient
+	var total int64
+	for _, t := range tokens {
+		if t.State != "ACTIVE" && t.State != "PENDING" {
+			continue // revoked, consumed or expired links are not bundled
+		}
+		if t.State == "ACTIVE" && t.WatermarkedPath != nil {
+			src := filepath.Join(h.Cfg.DataDir, *t.WatermarkedPath)
+			if info, err := os.Stat(src); err == nil {
+				files = append(files, zipFile{t, src})
+				total += info.Size()
+				continue
+			}
+		}
+		missing = append(missing, t)
+	}
+
+	estimate := total + diskstat.PublishEstimate(asset.FileSize, len(missing), h.Cfg.WMCompressionFactor)
+	if h.Cfg.BundleMaxBytes > 0 && estimate > h.Cfg.BundleMaxBytes {
+		http.Error(w, fmt.Sprintf("Bundle would be about %s, over the %s limit. Export the links instead.",
+			formatBytes(estimate), formatBytes(h.Cfg.BundleMaxBytes)), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	if len(missing) > 0 {
+		for _, t := range missing {
+			job := &model.Job{ID: uuid.New().String(), JobType: jobType, CampaignID: id, TokenID: t.ID, RequestID: logging.RequestID(r.Context())}
+			if _, err := db.EnqueueJobIfNotExists(h.DB, job, 0); err != nil {
+				slog.ErrorContext(r.Context(), "bundle: enqueue job", "error", err, "token", t.ID)
+			}
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(h.retryAfterSecs()))
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprintf(w, "%d file(s) are being watermarked. Try again once they are ready.\n", len(missing))
+		return
+	}
+
+	db.InsertAuditLog(h.DB, accountID, "campaign_bundle_downloaded", "campaign", id, campaign.Name, r.RemoteAddr)
+	streamTokenZip(w, r, sanitizeFilename(campaign.Name)+"-bundle.zip", "bundle", files)
+}
+
+// zipFile is one recipient's watermarked copy for streamTokenZip.
+type zipFile struct {
+	token model.TokenWithRecipient
+	path  string
+}
+
+// streamTokenZip sends files as an attachment named filename, one entry per
+// recipient (see zipEntryName). logTag prefixes log messages.
+func streamTokenZip(w http.ResponseWriter, r *http.Request, filename, logTag string, files []zipFile) {
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+
+	zw := zip.NewWriter(w)
+	used := make(map[string]int)
+	for _, f := range files {
+		if err := addFileToZip(zw, zipEntryName(used, f.token, filepath.Ext(f.path)), f.path); err != nil {
+			// The response is already streaming; log and abort the archive.
+			slog.ErrorContext(r.Context(), logTag+": add file", "error", err, "token", f.token.ID)
+			return
+		}
+	}
+	if err := zw.Close(); err != nil {
+		slog.ErrorContext(r.Context(), logTag+": close zip", "error", err)
+	}
+}
+
+// addFileToZip copies the file at src into zw as name. Entries are stored
+// uncompressed: watermarked media is already compressed.
+func addFileToZip(zw *zip.Writer, name, src string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	hdr, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+	hdr.Method = zip.Store
+	dst, err := zw.CreateHeader(hdr)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, f)
+	return err
+}
+
 func (h *Handler) CampaignAddRecipients(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 	accountID := auth.AccountFromContext(r.Context())
@@ -493,7 +1271,7 @@ func (h *Handler) CampaignAddRecipients(w http.ResponseWriter, r *http.Request)
 	r.ParseForm()
 	recipientIDs := r.Form["recipient_ids"]
 	if len(recipientIDs) == 0 {
-		setFlash(w, "No recipients selected.")
+		h.setFlash(w, "No recipients selected.")
 		http.Redirect(w, r, "/campaigns/"+id, http.StatusSeeOther)
 		return
 	}
@@ -520,7 +1298,7 @@ func (h *Handler) CampaignAddRecipients(w http.ResponseWriter, r *http.Request)
 			ExpiresAt:    campaign.ExpiresAt,
 		}
 		if err := db.CreateToken(h.DB, token); err != nil {
-			slog.Error("add recipient token", "error", err, "recipient_id", rid)
+			slog.ErrorContext(r.Context(), "add recipient token", "error", err, "recipient_id", rid)
 			continue
 		}
 		// For published campaigns, immediately enqueue a watermark job
@@ -530,9 +1308,10 @@ func (h *Handler) CampaignAddRecipients(w http.ResponseWriter, r *http.Request)
 				JobType:    jobType,
 				CampaignID: campaign.ID,
 				TokenID:    token.ID,
+				RequestID:  logging.RequestID(r.Context()),
 			}
 			if err := db.EnqueueJob(h.DB, job); err != nil {
-				slog.Error("enqueue watermark job for new token", "error", err, "token", token.ID)
+				slog.ErrorContext(r.Context(), "enqueue watermark job for new token", "error", err, "token", token.ID)
 			}
 		}
 		added++
@@ -542,9 +1321,13 @@ func (h *Handler) CampaignAddRecipients(w http.ResponseWriter, r *http.Request)
 	if added > 0 && (campaign.State == "READY" || campaign.State == "PARTIAL" || campaign.State == "FAILED") {
 		db.UpdateCampaignState(h.DB, id, "PROCESSING")
 	}
+	// An approved draft goes back for review once its recipients change
+	if added > 0 && campaign.State == "DRAFT" && campaign.ApprovedAt != nil {
+		db.ClearCampaignApproval(h.DB, id)
+	}
 
 	db.InsertAuditLog(h.DB, accountID, "recipients_added", "campaign", id, campaign.Name, r.RemoteAddr)
-	setFlash(w, fmt.Sprintf("%d recipient(s) added.", added))
+	h.setFlash(w, fmt.Sprintf("%d recipient(s) added.", added))
 	http.Redirect(w, r, "/campaigns/"+id, http.StatusSeeOther)
 }
 
@@ -561,19 +1344,19 @@ func (h *Handler) TokenRetry(w http.ResponseWriter, r *http.Request) {
 
 	job, err := db.GetJobByToken(h.DB, tokenID)
 	if err != nil || job == nil {
-		setFlash(w, "Job not found.")
+		h.setFlash(w, "Job not found.")
 		http.Redirect(w, r, "/campaigns/"+campaignID, http.StatusSeeOther)
 		return
 	}
 	if job.State != "FAILED" {
-		setFlash(w, "Token is not in a failed state.")
+		h.setFlash(w, "Token is not in a failed state.")
 		http.Redirect(w, r, "/campaigns/"+campaignID, http.StatusSeeOther)
 		return
 	}
 
 	if err := db.ResetJobForManualRetry(h.DB, job.ID); err != nil {
-		slog.Error("manual retry", "error", err)
-		setFlash(w, "Retry failed.")
+		slog.ErrorContext(r.Context(), "manual retry", "error", err)
+		h.setFlash(w, "Retry failed.")
 		http.Redirect(w, r, "/campaigns/"+campaignID, http.StatusSeeOther)
 		return
 	}
@@ -584,10 +1367,42 @@ func (h *Handler) TokenRetry(w http.ResponseWriter, r *http.Request) {
 	}
 
 	db.InsertAuditLog(h.DB, accountID, "token_retry", "token", tokenID, "", r.RemoteAddr)
-	setFlash(w, "Retry queued.")
+	h.setFlash(w, "Retry queued.")
 	http.Redirect(w, r, "/campaigns/"+campaignID, http.StatusSeeOther)
 }
 
+// CampaignRetryFailed re-queues every FAILED job of the campaign.
+func (h *Handler) CampaignRetryFailed(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	accountID := auth.AccountFromContext(r.Context())
+
+	campaign, err := db.GetCampaign(h.DB, id)
+	if err != nil || campaign == nil || (campaign.AccountID != accountID && !auth.IsAdmin(r.Context())) {
+		http.NotFound(w, r)
+		return
+	}
+
+	n, err := db.ResetFailedJobsForManualRetry(h.DB, id)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "retry failed jobs", "error", err, "campaign", id)
+		http.Error(w, "Internal error", 500)
+		return
+	}
+	if n == 0 {
+		h.setFlash(w, "No failed jobs to retry.")
+		http.Redirect(w, r, "/campaigns/"+id, http.StatusSeeOther)
+		return
+	}
+
+	if campaign.State == "FAILED" || campaign.State == "PARTIAL" {
+		db.UpdateCampaignState(h.DB, id, "PROCESSING")
+	}
+
+	db.InsertAuditLog(h.DB, accountID, "campaign_retry_failed", "campaign", id, campaign.Name, r.RemoteAddr)
+	h.setFlash(w, fmt.Sprintf("Retry queued for %d failed job(s).", n))
+	http.Redirect(w, r, "/campaigns/"+id, http.StatusSeeOther)
+}
+
 func (h *Handler) CampaignArchive(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 	accountID := auth.AccountFromContext(r.Context())
@@ -603,11 +1418,115 @@ func (h *Handler) CampaignArchive(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := db.ArchiveCampaign(h.DB, id); err != nil {
-		slog.Error("archive campaign", "error", err)
+		slog.ErrorContext(r.Context(), "archive campaign", "error", err)
 		http.Error(w, "Internal error", 500)
 		return
 	}
 	db.InsertAuditLog(h.DB, accountID, "campaign_archived", "campaign", id, campaign.Name, r.RemoteAddr)
-	setFlash(w, "Campaign archived.")
+	h.setFlash(w, "Campaign archived.")
 	http.Redirect(w, r, "/campaigns", http.StatusSeeOther)
 }
+
+// canDelete reports whether a campaign in state may be soft-deleted. Anything
+// that can still issue or serve downloads has to be archived first.
+func canDelete(state string) bool {
+	switch state {
+	case "DRAFT", "EXPIRED", "ARCHIVED":
+		return true
+	}
+	return false
+}
+
+// CampaignDelete handles POST /campaigns/{id}/delete. The campaign is hidden
+// and purged with its tokens and download history once the grace period ends.
+func (h *Handler) CampaignDelete(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	accountID := auth.AccountFromContext(r.Context())
+
+	campaign, err := db.GetCampaign(h.DB, id)
+	if err != nil || campaign == nil || (campaign.AccountID != accountID && !auth.IsAdmin(r.Context())) {
+		http.NotFound(w, r)
+		return
+	}
+	back := "/campaigns"
+	if campaign.State == "ARCHIVED" {
+		back = "/campaigns?archived=1"
+	}
+	if campaign.DeletedAt != nil {
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+	if !canDelete(campaign.State) {
+		h.setFlash(w, "Archive the campaign before deleting it.")
+		http.Redirect(w, r, "/campaigns/"+id, http.StatusSeeOther)
+		return
+	}
+
+	if err := db.SoftDeleteCampaign(h.DB, id); err != nil {
+		slog.ErrorContext(r.Context(), "soft delete campaign", "error", err)
+		http.Error(w, "Internal error", 500)
+		return
+	}
+	db.InsertAuditLog(h.DB, accountID, "campaign_deleted", "campaign", id, campaign.Name, r.RemoteAddr)
+	h.setFlash(w, fmt.Sprintf("Campaign deleted. It can be restored from the archived list for %d days.", h.Cfg.DeleteGraceDays))
+	http.Redirect(w, r, back, http.StatusSeeOther)
+}
+
+// CampaignRestore handles POST /campaigns/{id}/restore, issued from the
+// deleted list under the archived view.
+func (h *Handler) CampaignRestore(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	accountID := auth.AccountFromContext(r.Context())
+
+	campaign, err := db.GetCampaign(h.DB, id)
+	if err != nil || campaign == nil || (campaign.AccountID != accountID && !auth.IsAdmin(r.Context())) {
+		http.NotFound(w, r)
+		return
+	}
+	if campaign.DeletedAt == nil {
+		http.Redirect(w, r, "/campaigns?archived=1", http.StatusSeeOther)
+		return
+	}
+	msg, err := h.campaignRestoreBlocked(campaign)
+	if err != nil {
+		http.Error(w, "Internal error", 500)
+		return
+	}
+	if msg != "" {
+		h.setFlash(w, msg)
+		http.Redirect(w, r, "/campaigns?archived=1", http.StatusSeeOther)
+		return
+	}
+
+	if err := db.RestoreCampaign(h.DB, id); err != nil {
+		slog.ErrorContext(r.Context(), "restore campaign", "error", err)
+		http.Error(w, "Internal error", 500)
+		return
+	}
+	db.InsertAuditLog(h.DB, accountID, "campaign_restored", "campaign", id, campaign.Name, r.RemoteAddr)
+	h.setFlash(w, "Campaign restored.")
+	if campaign.State == "ARCHIVED" {
+		http.Redirect(w, r, "/campaigns?archived=1", http.StatusSeeOther)
+		return
+	}
+	http.Redirect(w, r, "/campaigns/"+id, http.StatusSeeOther)
+}
+
+// campaignRestoreBlocked returns a user-facing reason the deleted campaign
+// may not be restored: a restored draft counts towards the active campaign
+// limit again, and it needs its asset back first.
+func (h *Handler) campaignRestoreBlocked(c *model.Campaign) (string, error) {
+	if c.State == "ARCHIVED" {
+		return "", nil
+	}
+	if c.State == "DRAFT" {
+		asset, err := db.GetAsset(h.DB, c.AssetID)
+		if err != nil {
+			return "", err
+		}
+		if asset == nil || asset.DeletedAt != nil {
+			return "Restore the campaign's asset first.", nil
+		}
+	}
+	return h.campaignLimitReached(c.AccountID)
+}
@@ -0,0 +1,325 @@
+package handler
+
+import (
+	"archive/zip"
+	"bytes"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/YannKr/downloadonce/internal/db"
+	"github.com/YannKr/downloadonce/internal/model"
+)
+
+func TestCampaignBundle(t *testing.T) {
+	h := newTestHandler(t)
+	seedAccount(t, h.DB, "acc", "member")
+	tokens := seedCampaign(t, h.DB, "acc", "camp", "PARTIAL", "alice", "bob", "carol")
+
+	// alice and bob are watermarked; carol's link has not been prepared yet.
+	outDir := filepath.Join(h.Cfg.DataDir, "watermarked", "camp")
+	if err := os.MkdirAll(outDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, tok := range tokens[:2] {
+		rel := filepath.Join("watermarked", "camp", tok+".png")
+		if err := os.WriteFile(filepath.Join(h.Cfg.DataDir, rel), []byte("png-"+tok), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.ActivateToken(h.DB, tok, rel, "00", 8); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := chi.NewRouter()
+	r.Post("/campaigns/{id}/bundle.zip", h.CampaignBundle)
+	post := func(account, campaign string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, asAccount(httptest.NewRequest("POST", "/campaigns/"+campaign+"/bundle.zip", nil), account, "member"))
+		return rec
+	}
+
+	seedAccount(t, h.DB, "other", "member")
+	if rec := post("other", "camp"); rec.Code != http.StatusNotFound {
+		t.Errorf("other account: status = %d, want 404", rec.Code)
+	}
+	for _, state := range []string{"DRAFT", "EXPIRED", "ARCHIVED"} {
+		seedCampaign(t, h.DB, "acc", state, state, "alice")
+		if rec := post("acc", state); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s campaign: status = %d, want 400", state, rec.Code)
+		}
+	}
+
+	// The cap is checked against an estimate for carol's copy before any
+	// job is queued.
+	h.Cfg.BundleMaxBytes = 100
+	if rec := post("acc", "camp"); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("estimate over cap: status = %d, want 413", rec.Code)
+	}
+	if job, _ := db.GetJobByToken(h.DB, tokens[2]); job != nil {
+		t.Error("job enqueued for a bundle over the cap")
+	}
+	h.Cfg.BundleMaxBytes = 0
+
+	// The missing file is generated on demand first.
+	if rec := post("acc", "camp"); rec.Code != http.StatusAccepted || rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("with pending token: status = %d, Retry-After %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if job, _ := db.GetJobByToken(h.DB, tokens[2]); job == nil {
+		t.Fatal("no on-demand job enqueued for the pending token")
+	}
+
+	rel := filepath.Join("watermarked", "camp", tokens[2]+".png")
+	if err := os.WriteFile(filepath.Join(h.Cfg.DataDir, rel), []byte("png-c"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.ActivateToken(h.DB, tokens[2], rel, "00", 5); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := post("acc", "camp")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(rec.Body.Bytes()), int64(rec.Body.Len()))
+	if err != nil {
+		t.Fatalf("read zip: %v", err)
+	}
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	sort.Strings(names)
+	want := []string{"alice.png", "bob.png", "carol.png"}
+	if len(names) != len(want) {
+		t.Fatalf("zip entries = %v, want %v", names, want)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Errorf("zip entries = %v, want %v", names, want)
+			break
+		}
+	}
+
+	h.Cfg.BundleMaxBytes = 10
+	if rec := post("acc", "camp"); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("over cap: status = %d, want 413", rec.Code)
+	}
+}
+
+func TestCampaignRetryFailed(t *testing.T) {
+	h := newTestHandler(t)
+	seedAccount(t, h.DB, "acc", "member")
+	tokens := seedCampaign(t, h.DB, "acc", "camp", "PARTIAL", "r1", "r2", "r3")
+	for i, state := range []string{"FAILED", "FAILED", "COMPLETED"} {
+		job := &model.Job{ID: "job-" + tokens[i], JobType: "watermark_image", CampaignID: "camp", TokenID: tokens[i]}
+		if err := db.EnqueueJob(h.DB, job); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := h.DB.Exec(`UPDATE jobs SET state = ?, error_message = 'boom', retry_count = 3 WHERE id = ?`, state, job.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := chi.NewRouter()
+	r.Post("/campaigns/{id}/retry-failed", h.CampaignRetryFailed)
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, asAccount(httptest.NewRequest("POST", "/campaigns/camp/retry-failed", nil), "acc", "member"))
+	if rec.Code != http.StatusSeeOther {
+		t.Fatalf("status = %d", rec.Code)
+	}
+
+	for i, want := range []string{"PENDING", "PENDING", "COMPLETED"} {
+		j, _ := db.GetJob(h.DB, "job-"+tokens[i])
+		if j.State != want {
+			t.Errorf("job %d state = %s, want %s", i, j.State, want)
+		}
+		if want == "PENDING" && (j.RetryCount != 0 || j.ErrorMessage != "") {
+			t.Errorf("job %d not reset: retry_count=%d error=%q", i, j.RetryCount, j.ErrorMessage)
+		}
+	}
+	if c, _ := db.GetCampaign(h.DB, "camp"); c.State != "PROCESSING" {
+		t.Errorf("campaign state = %s, want PROCESSING", c.State)
+	}
+}
+
+func TestCampaignWatermarkedThumb(t *testing.T) {
+	h := newTestHandler(t)
+	seedAccount(t, h.DB, "acc", "member")
+	seedAccount(t, h.DB, "other", "member")
+	tokens := seedCampaign(t, h.DB, "acc", "camp", "READY", "alice", "bob")
+
+	r := chi.NewRouter()
+	r.Get("/campaigns/{id}/watermarked-thumb", h.CampaignWatermarkedThumb)
+	get := func(account string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/campaigns/camp/watermarked-thumb", nil)
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, asAccount(req, account, "member"))
+		return rec
+	}
+
+	if rec := get("acc"); rec.Code != http.StatusNotFound {
+		t.Fatalf("before any copy exists: status = %d, want 404", rec.Code)
+	}
+
+	outDir := filepath.Join(h.Cfg.DataDir, "watermarked", "camp")
+	if err := os.MkdirAll(outDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	var src bytes.Buffer
+	png.Encode(&src, image.NewNRGBA(image.Rect(0, 0, 800, 600)))
+	rel := filepath.Join("watermarked", "camp", tokens[0]+".png")
+	if err := os.WriteFile(filepath.Join(h.Cfg.DataDir, rel), src.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.ActivateToken(h.DB, tokens[0], rel, "ab", int64(src.Len())); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := get("acc")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "image/jpeg" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	img, err := jpeg.Decode(rec.Body)
+	if err != nil {
+		t.Fatalf("decode preview: %v", err)
+	}
+	if b := img.Bounds(); b.Dx() != 400 || b.Dy() != 300 {
+		t.Errorf("preview is %dx%d, want 400x300", b.Dx(), b.Dy())
+	}
+	if _, err := os.Stat(filepath.Join(outDir, "preview.jpg")); err != nil {
+		t.Errorf("preview not cached: %v", err)
+	}
+
+	if rec := get("other"); rec.Code != http.StatusNotFound {
+		t.Errorf("other account: status = %d, want 404", rec.Code)
+	}
+}
+
+func TestCampaignDeleteRestore(t *testing.T) {
+	h := newTestHandler(t)
+	seedAccount(t, h.DB, "acc", "member")
+	seedCampaign(t, h.DB, "acc", "camp", "READY", "r1")
+
+	r := chi.NewRouter()
+	r.Post("/campaigns/{id}/delete", h.CampaignDelete)
+	r.Post("/campaigns/{id}/restore", h.CampaignRestore)
+	post := func(path string) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, asAccount(httptest.NewRequest("POST", path, nil), "acc", "member"))
+		if rec.Code != http.StatusSeeOther {
+			t.Fatalf("POST %s: status = %d", path, rec.Code)
+		}
+	}
+
+	post("/campaigns/camp/delete")
+	if c, _ := db.GetCampaign(h.DB, "camp"); c.DeletedAt != nil {
+		t.Fatal("READY campaign deleted without archiving")
+	}
+
+	db.ArchiveCampaign(h.DB, "camp")
+	post("/campaigns/camp/delete")
+	if c, _ := db.GetCampaign(h.DB, "camp"); c.DeletedAt == nil {
+		t.Fatal("archived campaign not deleted")
+	}
+	if list, _ := db.ListCampaigns(h.DB, "acc", false, true); len(list) != 0 {
+		t.Errorf("deleted campaign still in archived list: %v", list)
+	}
+
+	post("/campaigns/camp/restore")
+	if c, _ := db.GetCampaign(h.DB, "camp"); c.DeletedAt != nil || c.State != "ARCHIVED" {
+		t.Errorf("restored campaign = %+v, want ARCHIVED and not deleted", c)
+	}
+}
+
+func TestCampaignPreview(t *testing.T) {
+	h := newTestHandler(t)
+	seedAccount(t, h.DB, "acc", "member")
+	seedAccount(t, h.DB, "other", "member")
+	seedCampaign(t, h.DB, "acc", "camp", "DRAFT", "alice")
+	h.Cfg.FontPath = "/usr/share/fonts/truetype/dejavu/DejaVuSans.ttf"
+
+	var src bytes.Buffer
+	png.Encode(&src, image.NewNRGBA(image.Rect(0, 0, 320, 200)))
+	if err := os.MkdirAll(filepath.Join(h.Cfg.DataDir, "originals"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(h.Cfg.DataDir, "originals", "camp-asset.png"), src.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	r := chi.NewRouter()
+	r.Post("/campaigns/{id}/preview", h.CampaignPreview)
+	post := func(account string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, asAccount(httptest.NewRequest("POST", "/campaigns/camp/preview", nil), account, "member"))
+		return rec
+	}
+
+	if rec := post("other"); rec.Code != http.StatusNotFound {
+		t.Fatalf("other account: status = %d, want 404", rec.Code)
+	}
+
+	rec := post("acc")
+	_, magickErr := exec.LookPath("magick")
+	_, fontErr := os.Stat(h.Cfg.FontPath)
+	if magickErr == nil && fontErr == nil {
+		if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "image/jpeg" {
+			t.Fatalf("status = %d, Content-Type %q: %s", rec.Code, rec.Header().Get("Content-Type"), rec.Body)
+		}
+		if img, err := jpeg.Decode(rec.Body); err != nil || img.Bounds().Dx() != 320 {
+			t.Errorf("preview: %v", err)
+		}
+	} else if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("without ImageMagick: status = %d, want 500", rec.Code)
+	}
+
+	// The preview leaves nothing behind: no file, no token, no index entry.
+	if entries, _ := os.ReadDir(filepath.Join(h.Cfg.DataDir, "watermarked", "camp")); len(entries) != 0 {
+		t.Errorf("preview left %d file(s) behind", len(entries))
+	}
+	var n int
+	h.DB.QueryRow(`SELECT (SELECT COUNT(*) FROM watermark_index) + (SELECT COUNT(*) FROM download_tokens WHERE campaign_id = 'camp')`).Scan(&n)
+	if n != 1 {
+		t.Errorf("tokens + index entries = %d, want only alice's token", n)
+	}
+}
+
+func TestCampaignCloneLimit(t *testing.T) {
+	h := newTestHandler(t)
+	h.Cfg.MaxCampaignsPerAccount = 2
+	seedAccount(t, h.DB, "acc", "member")
+	seedCampaign(t, h.DB, "acc", "camp", "READY", "r1")
+
+	r := chi.NewRouter()
+	r.Post("/campaigns/{id}/clone", h.CampaignClone)
+	clone := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, asAccount(httptest.NewRequest("POST", "/campaigns/camp/clone", nil), "acc", "member"))
+		return rec
+	}
+
+	// One existing campaign: the first clone fits, the second does not.
+	if rec := clone(); rec.Code != http.StatusSeeOther || rec.Header().Get("Location") == "/campaigns/camp" {
+		t.Fatalf("first clone: status = %d, Location %q", rec.Code, rec.Header().Get("Location"))
+	}
+	rec := clone()
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/campaigns/camp" {
+		t.Fatalf("clone at the limit: status = %d, Location %q; want back to the source", rec.Code, rec.Header().Get("Location"))
+	}
+	if n, _ := db.CountActiveCampaignsByAccount(h.DB, "acc"); n != 2 {
+		t.Errorf("active campaigns = %d, want 2", n)
+	}
+}
@@ -0,0 +1,117 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/YannKr/downloadonce/internal/config"
+)
+
+// corsExposedHeaders are the API response headers scripts on an allowed
+// origin may read; browsers hide all but a few basic ones otherwise.
+const corsExposedHeaders = "X-RateLimit-Limit, X-RateLimit-Remaining, Retry-After, X-Duplicate-Of, Content-Disposition, X-Total-Count, Link"
+
+// CORSPolicy says which other origins may call /api/v1 from a browser.
+type CORSPolicy struct {
+	origins     map[string]bool
+	anyOrigin   bool
+	methods     string // Access-Control-Allow-Methods
+	headers     string // Access-Control-Allow-Headers
+	credentials bool
+}
+
+// ParseCORS reads the CORS_* settings. With no allowed origins it returns
+// nil: the API stays same-origin only and no CORS headers are sent. "*"
+// allows any origin but cannot be combined with credentials.
+func ParseCORS(cfg *config.Config) (*CORSPolicy, error) {
+	if strings.TrimSpace(cfg.CORSAllowedOrigins) == "" {
+		return nil, nil
+	}
+	p := &CORSPolicy{origins: map[string]bool{}, credentials: cfg.CORSAllowCredentials}
+	for _, o := range strings.Split(cfg.CORSAllowedOrigins, ",") {
+		o = strings.ToLower(strings.TrimSpace(o))
+		if o == "" {
+			continue
+		}
+		if o == "*" {
+			p.anyOrigin = true
+			continue
+		}
+		u, err := url.Parse(o)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" ||
+			u.Path != "" || u.RawQuery != "" || u.Fragment != "" || u.User != nil {
+			return nil, fmt.Errorf("cors allowed origins: %q is not an origin like https://app.example.com", o)
+		}
+		p.origins[o] = true
+	}
+	if p.anyOrigin && p.credentials {
+		return nil, fmt.Errorf("cors: allowing any origin (*) cannot be combined with credentials")
+	}
+
+	var methods []string
+	for _, m := range strings.Split(cfg.CORSAllowedMethods, ",") {
+		if m = strings.ToUpper(strings.TrimSpace(m)); m != "" {
+			methods = append(methods, m)
+		}
+	}
+	var headers []string
+	for _, hdr := range strings.Split(cfg.CORSAllowedHeaders, ",") {
+		if hdr = strings.TrimSpace(hdr); hdr != "" {
+			headers = append(headers, http.CanonicalHeaderKey(hdr))
+		}
+	}
+	p.methods = strings.Join(methods, ", ")
+	p.headers = strings.Join(headers, ", ")
+	return p, nil
+}
+
+func (p *CORSPolicy) allows(origin string) bool {
+	return p.anyOrigin || p.origins[strings.ToLower(origin)]
+}
+
+// Middleware adds CORS headers for allowed origins and answers preflight
+// requests itself, before API key auth: browsers send preflights without
+// the Authorization header. Preflights from other origins get 403; their
+// actual requests are served without CORS headers, so the browser keeps the
+// response from the calling page.
+func (p *CORSPolicy) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Origin")
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+		if !p.allows(origin) {
+			if preflight {
+				renderJSONError(w, http.StatusForbidden, "FORBIDDEN", "origin not allowed")
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if p.anyOrigin {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		} else {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		}
+		if p.credentials {
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
+		if preflight {
+			w.Header().Add("Vary", "Access-Control-Request-Method")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+			w.Header().Set("Access-Control-Allow-Methods", p.methods)
+			w.Header().Set("Access-Control-Allow-Headers", p.headers)
+			w.Header().Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("Access-Control-Expose-Headers", corsExposedHeaders)
+		next.ServeHTTP(w, r)
+	})
+}
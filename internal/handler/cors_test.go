@@ -0,0 +1,132 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+
+	"github.com/YannKr/downloadonce/internal/config"
+)
+
+func TestParseCORS(t *testing.T) {
+	for _, tc := range []struct {
+		origins string
+		creds   bool
+		ok      bool
+	}{
+		{"", true, true},
+		{"https://app.example.com, http://localhost:3000", true, true},
+		{"*", false, true},
+		{"*", true, false},
+		{"app.example.com", false, false},
+		{"https://app.example.com/dashboard", false, false},
+		{"ftp://app.example.com", false, false},
+	} {
+		_, err := ParseCORS(&config.Config{CORSAllowedOrigins: tc.origins, CORSAllowCredentials: tc.creds})
+		if (err == nil) != tc.ok {
+			t.Errorf("ParseCORS(%q, credentials=%v): err = %v", tc.origins, tc.creds, err)
+		}
+	}
+}
+
+func TestAPICORS(t *testing.T) {
+	h := newTestHandler(t)
+	seedAccount(t, h.DB, "acct", "member")
+	key := seedAPIKey(t, h, "acct", "cccccccc", "write")
+	h.Cfg.CORSAllowedOrigins = "https://dash.example.com"
+	h.Cfg.CORSAllowedMethods = "GET,POST,DELETE"
+	h.Cfg.CORSAllowedHeaders = "authorization,content-type"
+	var err error
+	if h.CORS, err = ParseCORS(h.Cfg); err != nil {
+		t.Fatal(err)
+	}
+	router := h.Routes(fstest.MapFS{}, NewRateLimiter(100, 100))
+
+	do := func(method, path, origin, bearer string, preflight bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Origin", origin)
+		if preflight {
+			req.Header.Set("Access-Control-Request-Method", "DELETE")
+			req.Header.Set("Access-Control-Request-Headers", "authorization")
+		}
+		if bearer != "" {
+			req.Header.Set("Authorization", "Bearer "+bearer)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("preflight from allowed origin", func(t *testing.T) {
+		rec := do("OPTIONS", "/api/v1/assets/missing", "https://dash.example.com", "", true)
+		if rec.Code != http.StatusNoContent {
+			t.Fatalf("status = %d, want 204: %s", rec.Code, rec.Body)
+		}
+		hdr := rec.Header()
+		if hdr.Get("Access-Control-Allow-Origin") != "https://dash.example.com" ||
+			hdr.Get("Access-Control-Allow-Methods") != "GET, POST, DELETE" ||
+			hdr.Get("Access-Control-Allow-Headers") != "Authorization, Content-Type" ||
+			hdr.Get("Access-Control-Allow-Credentials") != "" {
+			t.Errorf("preflight headers = %v", hdr)
+		}
+		if hdr.Get("Set-Cookie") != "" {
+			t.Errorf("preflight set a cookie: %s", hdr.Get("Set-Cookie"))
+		}
+	})
+
+	t.Run("preflight from other origin", func(t *testing.T) {
+		rec := do("OPTIONS", "/api/v1/assets/missing", "https://evil.example.com", "", true)
+		if rec.Code != http.StatusForbidden || rec.Header().Get("Access-Control-Allow-Origin") != "" {
+			t.Errorf("status = %d, allow-origin = %q; want 403 without CORS headers",
+				rec.Code, rec.Header().Get("Access-Control-Allow-Origin"))
+		}
+	})
+
+	t.Run("request from allowed origin", func(t *testing.T) {
+		rec := do("GET", "/api/v1/assets", "https://dash.example.com", key, false)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+		}
+		if rec.Header().Get("Access-Control-Allow-Origin") != "https://dash.example.com" ||
+			rec.Header().Get("Access-Control-Expose-Headers") == "" {
+			t.Errorf("headers = %v", rec.Header())
+		}
+		// Bearer requests still skip CSRF, so cross-origin writes work.
+		if rec := do("DELETE", "/api/v1/assets/missing", "https://dash.example.com", key, false); rec.Code != http.StatusNotFound {
+			t.Errorf("cross-origin DELETE: status = %d, want 404 from the handler", rec.Code)
+		}
+	})
+
+	t.Run("request from other origin", func(t *testing.T) {
+		rec := do("GET", "/api/v1/assets", "https://evil.example.com", key, false)
+		if rec.Header().Get("Access-Control-Allow-Origin") != "" {
+			t.Errorf("allow-origin = %q for a disallowed origin", rec.Header().Get("Access-Control-Allow-Origin"))
+		}
+	})
+
+	t.Run("web forms keep CSRF", func(t *testing.T) {
+		rec := do("POST", "/settings/apikeys", "https://dash.example.com", "", false)
+		if rec.Code != http.StatusForbidden || rec.Header().Get("Access-Control-Allow-Origin") != "" {
+			t.Errorf("status = %d, allow-origin = %q; want CSRF 403 without CORS",
+				rec.Code, rec.Header().Get("Access-Control-Allow-Origin"))
+		}
+	})
+}
+
+func TestAPICORSDisabledByDefault(t *testing.T) {
+	h := newTestHandler(t)
+	seedAccount(t, h.DB, "acct", "member")
+	key := seedAPIKey(t, h, "acct", "cccccccc", "read")
+	router := h.Routes(fstest.MapFS{}, NewRateLimiter(100, 100))
+
+	req := httptest.NewRequest("GET", "/api/v1/assets", nil)
+	req.Header.Set("Origin", "https://dash.example.com")
+	req.Header.Set("Authorization", "Bearer "+key)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || rec.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("status = %d, allow-origin = %q; want 200 without CORS headers",
+			rec.Code, rec.Header().Get("Access-Control-Allow-Origin"))
+	}
+}
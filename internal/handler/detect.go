@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"errors"
 	"io"
 	"log/slog"
 	"net/http"
@@ -12,6 +13,7 @@ import (
 	"github.com/google/uuid"
 	"github.com/YannKr/downloadonce/internal/auth"
 	"github.com/YannKr/downloadonce/internal/db"
+	"github.com/YannKr/downloadonce/internal/logging"
 )
 
 func (h *Handler) DetectForm(w http.ResponseWriter, r *http.Request) {
@@ -30,12 +32,32 @@ func (h *Handler) DetectSubmit(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if rawURL := strings.TrimSpace(r.FormValue("url")); rawURL != "" {
+		jobID, err := h.enqueueDetectURL(r.Context(), accountID, rawURL)
+		if err != nil {
+			var ferr *fetchError
+			if !errors.As(err, &ferr) {
+				slog.ErrorContext(r.Context(), "detect from url", "error", err)
+				http.Error(w, "Internal error", 500)
+				return
+			}
+			h.render(w, r, "detect.html", PageData{
+				Title: "Detect Watermark", Authenticated: true,
+				IsAdmin: auth.IsAdmin(r.Context()), UserName: auth.NameFromContext(r.Context()),
+				Error: "Could not fetch the URL: " + ferr.Error() + ".",
+			})
+			return
+		}
+		http.Redirect(w, r, "/detect/"+jobID, http.StatusSeeOther)
+		return
+	}
+
 	file, header, err := r.FormFile("file")
 	if err != nil {
 		h.render(w, r, "detect.html", PageData{
 			Title: "Detect Watermark", Authenticated: true,
 			IsAdmin: auth.IsAdmin(r.Context()), UserName: auth.NameFromContext(r.Context()),
-			Error: "No file selected.",
+			Error: "Select a file or enter a URL.",
 		})
 		return
 	}
@@ -43,15 +65,11 @@ func (h *Handler) DetectSubmit(w http.ResponseWriter, r *http.Request) {
 
 	// Validate file extension
 	ext := strings.ToLower(filepath.Ext(header.Filename))
-	allowed := map[string]bool{
-		".jpg": true, ".jpeg": true, ".png": true, ".webp": true,
-		".mp4": true, ".mkv": true, ".avi": true, ".mov": true, ".webm": true,
-	}
-	if !allowed[ext] {
+	if !detectExts[ext] {
 		h.render(w, r, "detect.html", PageData{
 			Title: "Detect Watermark", Authenticated: true,
 			IsAdmin: auth.IsAdmin(r.Context()), UserName: auth.NameFromContext(r.Context()),
-			Error: "Unsupported file type. Please upload an image (JPEG/PNG/WebP) or video (MP4/MKV/AVI/MOV/WebM).",
+			Error: "Unsupported file type. Please upload an image (JPEG/PNG/WebP/HEIC/AVIF) or video (MP4/MKV/AVI/MOV/WebM).",
 		})
 		return
 	}
@@ -61,7 +79,7 @@ func (h *Handler) DetectSubmit(w http.ResponseWriter, r *http.Request) {
 	// Save uploaded file
 	detectDir := filepath.Join(h.Cfg.DataDir, "detect", jobID)
 	if err := os.MkdirAll(detectDir, 0755); err != nil {
-		slog.Error("create detect dir", "error", err)
+		slog.ErrorContext(r.Context(), "create detect dir", "error", err)
 		http.Error(w, "Internal error", 500)
 		return
 	}
@@ -69,21 +87,21 @@ func (h *Handler) DetectSubmit(w http.ResponseWriter, r *http.Request) {
 	inputPath := filepath.Join(detectDir, "input"+ext)
 	dst, err := os.Create(inputPath)
 	if err != nil {
-		slog.Error("create detect file", "error", err)
+		slog.ErrorContext(r.Context(), "create detect file", "error", err)
 		http.Error(w, "Internal error", 500)
 		return
 	}
 	defer dst.Close()
 
 	if _, err := io.Copy(dst, file); err != nil {
-		slog.Error("save detect file", "error", err)
+		slog.ErrorContext(r.Context(), "save detect file", "error", err)
 		http.Error(w, "Internal error", 500)
 		return
 	}
 
 	// Enqueue detection job
-	if err := db.EnqueueDetectJob(h.DB, jobID, accountID, inputPath, "detect"); err != nil {
-		slog.Error("enqueue detect job", "error", err)
+	if err := db.EnqueueDetectJob(h.DB, jobID, accountID, inputPath, "detect", logging.RequestID(r.Context())); err != nil {
+		slog.ErrorContext(r.Context(), "enqueue detect job", "error", err)
 		http.Error(w, "Internal error", 500)
 		return
 	}
@@ -96,11 +114,13 @@ func (h *Handler) DetectResult(w http.ResponseWriter, r *http.Request) {
 
 	job, err := db.GetJob(h.DB, jobID)
 	if err != nil {
-		slog.Error("get detect job", "error", err)
+		slog.ErrorContext(r.Context(), "get detect job", "error", err)
 		http.Error(w, "Internal error", 500)
 		return
 	}
-	if job == nil {
+	// Other accounts' jobs are reported as missing, as in the API.
+	if job == nil || job.JobType != "detect" ||
+		(job.AccountID != auth.AccountFromContext(r.Context()) && !auth.IsAdmin(r.Context())) {
 		http.Error(w, "Not found", 404)
 		return
 	}
@@ -0,0 +1,41 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/YannKr/downloadonce/internal/db"
+)
+
+func TestDetectResultOwnership(t *testing.T) {
+	h := newTestHandler(t)
+	seedAccount(t, h.DB, "owner", "member")
+	seedAccount(t, h.DB, "other", "member")
+	seedAccount(t, h.DB, "admin", "admin")
+	if err := db.EnqueueDetectJob(h.DB, "det-1", "owner", "detect/det-1", "detect", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	r := chi.NewRouter()
+	r.Get("/detect/{id}", h.DetectResult)
+	r.Get("/api/v1/detect/{jobID}", h.APIDetectGet)
+
+	for _, tc := range []struct {
+		acct, role string
+		want       int
+	}{
+		{"owner", "member", http.StatusOK},
+		{"admin", "admin", http.StatusOK},
+		{"other", "member", http.StatusNotFound},
+	} {
+		for _, path := range []string{"/detect/det-1", "/api/v1/detect/det-1"} {
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, asAccount(httptest.NewRequest("GET", path, nil), tc.acct, tc.role))
+			if rec.Code != tc.want {
+				t.Errorf("%s %s: status = %d, want %d", tc.acct, path, rec.Code, tc.want)
+			}
+		}
+	}
+}
@@ -1,18 +1,28 @@
 package handler
 
 import (
+	"database/sql"
+	"errors"
 	"fmt"
+	"html/template"
 	"log/slog"
+	"mime"
 	"net"
 	"net/http"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
 	"github.com/YannKr/downloadonce/internal/db"
+	"github.com/YannKr/downloadonce/internal/email"
+	"github.com/YannKr/downloadonce/internal/logging"
 	"github.com/YannKr/downloadonce/internal/model"
+	"github.com/YannKr/downloadonce/internal/watermark"
 )
 
 type downloadPageData struct {
@@ -21,18 +31,165 @@ type downloadPageData struct {
 	Recipient *model.Recipient
 	Token     *model.DownloadToken
 	BaseURL   string
+	Message   template.HTML // sanitized campaign download message
+
+	// RemainingDownloads is how many more times the link can be used; nil
+	// when it has no cap. ExpiresIn is the time left before the link
+	// expires, e.g. "3 days"; empty when it never expires.
+	RemainingDownloads *int
+	ExpiresIn          string
+}
+
+// humanizeUntil renders the time left before a deadline in its largest
+// whole unit, e.g. "3 days", "1 hour" or "less than a minute".
+func humanizeUntil(d time.Duration) string {
+	unit := func(n int, name string) string {
+		if n == 1 {
+			return "1 " + name
+		}
+		return fmt.Sprintf("%d %ss", n, name)
+	}
+	switch {
+	case d >= 48*time.Hour:
+		return unit(int(d/(24*time.Hour)), "day")
+	case d >= time.Hour:
+		return unit(int(d/time.Hour), "hour")
+	case d >= time.Minute:
+		return unit(int(d/time.Minute), "minute")
+	}
+	return "less than a minute"
+}
+
+// downloadErrorData fills download_expired.html for a link that cannot be
+// served.
+type downloadErrorData struct {
+	Message       string
+	Hint          string
+	Contact       string        // DOWNLOAD_SUPPORT_CONTACT, shown when set
+	ExpiryMessage template.HTML // sanitized campaign expiry message
+	RequestToken  string        // token ID when the campaign accepts new-link requests
+	RequestEmail  string        // address entered in the request form
+}
+
+type downloadError struct {
+	status int
+	title  string
+	data   downloadErrorData
+}
+
+// Recipient-facing errors shared by the download page and the file endpoint.
+var (
+	errLinkNotFound = downloadError{http.StatusNotFound, "Link Not Found", downloadErrorData{
+		Message: "This download link doesn't exist.",
+		Hint:    "Check that you opened the complete link from your email.",
+	}}
+	errLinkUsed = downloadError{http.StatusGone, "Link Used", downloadErrorData{
+		Message: "This download link has already been used.",
+		Hint:    "Each link can only be downloaded a limited number of times.",
+	}}
+	errLinkExpired = downloadError{http.StatusGone, "Link Expired", downloadErrorData{
+		Message: "This download link has expired or been revoked.",
+		Hint:    "Ask the sender for a new link if you still need the file.",
+	}}
+	errBudgetUsed = downloadError{http.StatusGone, "Download Limit Reached", downloadErrorData{
+		Message: "This file has reached its total download limit.",
+		Hint:    "The sender allowed a fixed number of downloads across all recipients, and they have all been used.",
+	}}
+	errLinkUnavailable = downloadError{http.StatusInternalServerError, "Download Unavailable", downloadErrorData{
+		Message: "This file can't be downloaded right now.",
+		Hint:    "Please try again later.",
+	}}
+	errLinkWithdrawn = downloadError{http.StatusGone, "Download Unavailable", downloadErrorData{
+		Message: "This file is no longer available.",
+		Hint:    "The sender has removed it. Ask them if you still need the file.",
+	}}
+)
+
+// campaignDeleted reports whether token's campaign is soft-deleted. Its links
+// stop working at once rather than when the cleanup purge removes them.
+func (h *Handler) campaignDeleted(token *model.DownloadToken) bool {
+	c, _ := db.GetCampaign(h.DB, token.CampaignID)
+	return c != nil && c.DeletedAt != nil
+}
+
+// renderDownloadError renders the styled error page for e with its status.
+func (h *Handler) renderDownloadError(w http.ResponseWriter, r *http.Request, e downloadError) {
+	data := e.data
+	data.Contact = h.Cfg.DownloadSupportContact
+	h.renderStatus(w, r, e.status, "download_expired.html", PageData{Title: e.title, Data: data})
+}
+
+// renderLinkGone renders e for a token that can no longer be downloaded. For
+// used and expired links it adds the campaign's expiry message and, when the
+// owner allows it, the form asking for a new link. formError is shown above
+// the page when that form was rejected.
+func (h *Handler) renderLinkGone(w http.ResponseWriter, r *http.Request, e downloadError, token *model.DownloadToken, formError string) {
+	data := e.data
+	data.Contact = h.Cfg.DownloadSupportContact
+	status := e.status
+	if e.status == http.StatusGone {
+		if campaign, _ := db.GetCampaign(h.DB, token.CampaignID); campaign != nil {
+			data.ExpiryMessage = h.sanitizeMessage(campaign.ExpiryMessage)
+			if campaign.LinkRequests {
+				data.RequestToken = token.ID
+			}
+		}
+	}
+	if formError != "" {
+		status = http.StatusBadRequest
+		data.RequestEmail = r.FormValue("email")
+	}
+	h.renderStatus(w, r, status, "download_expired.html", PageData{Title: e.title, Error: formError, Data: data})
+}
+
+// linkGoneError returns the error for a token that can no longer be
+// downloaded, expiring it first if its deadline has passed, and false for a
+// token that is still usable.
+func linkGoneError(database *sql.DB, token *model.DownloadToken) (downloadError, bool) {
+	switch token.State {
+	case "CONSUMED", "EXPIRED":
+		// Tokens expired because the campaign's download budget ran out say so.
+		if token.State == "EXPIRED" {
+			if c, _ := db.GetCampaign(database, token.CampaignID); c != nil {
+				if left := c.RemainingDownloads(); left != nil && *left == 0 {
+					return errBudgetUsed, true
+				}
+			}
+		}
+		return tokenStateError(token.State), true
+	}
+	if token.ExpiresAt != nil && token.ExpiresAt.Before(time.Now()) {
+		db.ExpireToken(database, token.ID)
+		return errLinkExpired, true
+	}
+	return downloadError{}, false
+}
+
+// tokenStateError maps a token state that cannot be downloaded to its error.
+func tokenStateError(state string) downloadError {
+	if state == "CONSUMED" {
+		return errLinkUsed
+	}
+	if state == "EXPIRED" {
+		return errLinkExpired
+	}
+	return errLinkNotFound
 }
 
 func (h *Handler) DownloadPage(w http.ResponseWriter, r *http.Request) {
 	tokenStr := chi.URLParam(r, "token")
 	if _, err := uuid.Parse(tokenStr); err != nil {
-		h.render(w, r, "download_expired.html", PageData{Title: "Not Found"})
+		h.renderDownloadError(w, r, errLinkNotFound)
 		return
 	}
 
 	token, err := db.GetToken(h.DB, tokenStr)
 	if err != nil || token == nil {
-		h.render(w, r, "download_expired.html", PageData{Title: "Not Found"})
+		h.renderDownloadError(w, r, errLinkNotFound)
+		return
+	}
+	if h.campaignDeleted(token) {
+		h.renderDownloadError(w, r, errLinkWithdrawn)
 		return
 	}
 
@@ -50,7 +207,7 @@ func (h *Handler) DownloadPage(w http.ResponseWriter, r *http.Request) {
 
 		asset, _ := db.GetAsset(h.DB, campaign.AssetID)
 		if asset == nil {
-			h.render(w, r, "download_expired.html", PageData{Title: "Error"})
+			h.renderDownloadError(w, r, errLinkUnavailable)
 			return
 		}
 
@@ -64,10 +221,12 @@ func (h *Handler) DownloadPage(w http.ResponseWriter, r *http.Request) {
 			JobType:    jobType,
 			CampaignID: token.CampaignID,
 			TokenID:    token.ID,
+			RequestID:  logging.RequestID(r.Context()),
 		}
-		_, err := db.EnqueueJobIfNotExists(h.DB, job)
+		grace := time.Duration(h.Cfg.OnDemandGraceSecs) * time.Second
+		_, err := db.EnqueueJobIfNotExists(h.DB, job, grace)
 		if err != nil {
-			slog.Error("enqueue on-demand job", "error", err, "token", token.ID)
+			slog.ErrorContext(r.Context(), "enqueue on-demand job", "error", err, "token", token.ID)
 		}
 
 		// Get current job progress
@@ -82,24 +241,28 @@ func (h *Handler) DownloadPage(w http.ResponseWriter, r *http.Request) {
 			Data:  map[string]interface{}{"TokenID": token.ID, "Progress": progress},
 		})
 		return
-	case "CONSUMED":
-		h.render(w, r, "download_expired.html", PageData{Title: "Link Used"})
-		return
-	case "EXPIRED":
-		h.render(w, r, "download_expired.html", PageData{Title: "Link Expired"})
-		return
 	}
 
-	// Check expiry
-	if token.ExpiresAt != nil && token.ExpiresAt.Before(time.Now()) {
-		db.ExpireToken(h.DB, token.ID)
-		h.render(w, r, "download_expired.html", PageData{Title: "Link Expired"})
+	if e, gone := linkGoneError(h.DB, token); gone {
+		h.renderLinkGone(w, r, e, token, "")
 		return
 	}
 
 	campaign, _ := db.GetCampaign(h.DB, token.CampaignID)
 	asset, _ := db.GetAsset(h.DB, campaign.AssetID)
 	recipient, _ := db.GetRecipient(h.DB, token.RecipientID)
+	if recipient == nil {
+		recipient = &model.Recipient{ID: token.RecipientID, Name: db.DeletedRecipientName}
+	}
+	var remaining *int
+	if token.MaxDownloads != nil {
+		n := max(*token.MaxDownloads-token.DownloadCount, 0)
+		remaining = &n
+	}
+	var expiresIn string
+	if token.ExpiresAt != nil {
+		expiresIn = humanizeUntil(time.Until(*token.ExpiresAt))
+	}
 
 	h.render(w, r, "download.html", PageData{
 		Title: campaign.Name,
@@ -109,37 +272,162 @@ func (h *Handler) DownloadPage(w http.ResponseWriter, r *http.Request) {
 			Recipient: recipient,
 			Token:     token,
 			BaseURL:   h.Cfg.BaseURL,
+			Message:   h.sanitizeMessage(campaign.DownloadMessage),
+
+			RemainingDownloads: remaining,
+			ExpiresIn:          expiresIn,
 		},
 	})
 }
 
+// DownloadRequestLink — POST /d/{token}/request-link
+//
+// Lets the recipient of a used or expired link ask the campaign owner for a
+// new one, when the campaign allows it. The owner gets a link_requested
+// webhook and, with SMTP configured, an email; nothing changes on the token.
+// The route shares the login rate limiter.
+func (h *Handler) DownloadRequestLink(w http.ResponseWriter, r *http.Request) {
+	tokenStr := chi.URLParam(r, "token")
+	if _, err := uuid.Parse(tokenStr); err != nil {
+		h.renderDownloadError(w, r, errLinkNotFound)
+		return
+	}
+	token, err := db.GetToken(h.DB, tokenStr)
+	if err != nil || token == nil {
+		h.renderDownloadError(w, r, errLinkNotFound)
+		return
+	}
+	e, gone := linkGoneError(h.DB, token)
+	campaign, _ := db.GetCampaign(h.DB, token.CampaignID)
+	if !gone || e.status != http.StatusGone || campaign == nil || !campaign.LinkRequests {
+		http.Redirect(w, r, "/d/"+token.ID, http.StatusSeeOther)
+		return
+	}
+
+	addr, err := h.validateEmail(r.FormValue("email"))
+	if err != nil {
+		h.renderLinkGone(w, r, e, token, "Enter a valid email address so the sender can reach you.")
+		return
+	}
+
+	recipient, _ := db.GetRecipient(h.DB, token.RecipientID)
+	if recipient == nil {
+		recipient = &model.Recipient{ID: token.RecipientID, Name: db.DeletedRecipientName}
+	}
+	slog.InfoContext(r.Context(), "new link requested", "token", token.ID, "campaign", campaign.ID)
+
+	if h.Webhook != nil {
+		h.Webhook.Dispatch(r.Context(), campaign.AccountID, "link_requested", map[string]interface{}{
+			"token_id":        token.ID,
+			"token_state":     token.State,
+			"campaign_id":     campaign.ID,
+			"campaign_name":   campaign.Name,
+			"recipient_id":    token.RecipientID,
+			"recipient_name":  recipient.Name,
+			"recipient_email": recipient.Email,
+			"requested_by":    addr,
+			"ip_address":      realIP(r),
+		})
+	}
+	if h.Mailer != nil && h.Mailer.Enabled() {
+		if owner, _ := db.GetAccountByID(h.DB, campaign.AccountID); owner != nil {
+			campaignURL := h.Cfg.BaseURL + "/campaigns/" + campaign.ID
+			go func() {
+				if err := h.Mailer.SendLinkRequest(owner.Email, owner.Name, campaign.Name, recipient.Name, recipient.Email, addr, campaignURL); err != nil {
+					slog.ErrorContext(r.Context(), "send link request", "error", err)
+				}
+			}()
+		}
+	}
+
+	h.setFlash(w, "Your request has been sent. The sender will be in touch.")
+	http.Redirect(w, r, "/d/"+token.ID, http.StatusSeeOther)
+}
+
+type fileNotReadyResponse struct {
+	State      string `json:"state"`
+	Progress   int    `json:"progress"`
+	RetryAfter int    `json:"retry_after"`
+}
+
+// fileNotReady answers a file request for a token whose watermarked copy does
+// not exist yet with 503 and a Retry-After hint. Browsers get the preparing
+// page; clients that accept JSON get the job state and progress so they can
+// poll instead of scraping it.
+func (h *Handler) fileNotReady(w http.ResponseWriter, r *http.Request, token *model.DownloadToken) {
+	retryAfter := h.retryAfterSecs()
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+
+	resp := fileNotReadyResponse{State: token.State, RetryAfter: retryAfter}
+	if job, _ := db.GetJobByToken(h.DB, token.ID); job != nil {
+		resp.State = job.State
+		resp.Progress = job.Progress
+	}
+	if !strings.Contains(r.Header.Get("Accept"), "application/json") {
+		h.renderStatus(w, r, http.StatusServiceUnavailable, "download_preparing.html", PageData{
+			Title: "Preparing",
+			Data:  map[string]interface{}{"TokenID": token.ID, "Progress": resp.Progress},
+		})
+		return
+	}
+	renderJSON(w, http.StatusServiceUnavailable, resp)
+}
+
+// retryAfterSecs is the Retry-After hint for files still being watermarked.
+func (h *Handler) retryAfterSecs() int {
+	if h.Cfg.FileRetryAfterSecs <= 0 {
+		return 5
+	}
+	return h.Cfg.FileRetryAfterSecs
+}
+
 func (h *Handler) DownloadFile(w http.ResponseWriter, r *http.Request) {
 	tokenStr := chi.URLParam(r, "token")
 	if _, err := uuid.Parse(tokenStr); err != nil {
-		http.NotFound(w, r)
+		h.renderDownloadError(w, r, errLinkNotFound)
 		return
 	}
 
 	token, err := db.GetToken(h.DB, tokenStr)
-	if err != nil || token == nil || token.State != "ACTIVE" {
-		http.NotFound(w, r)
+	if err != nil || token == nil {
+		h.renderDownloadError(w, r, errLinkNotFound)
 		return
 	}
-
-	if token.ExpiresAt != nil && token.ExpiresAt.Before(time.Now()) {
-		db.ExpireToken(h.DB, token.ID)
-		http.Error(w, "Link expired", http.StatusGone)
+	if h.campaignDeleted(token) {
+		h.renderDownloadError(w, r, errLinkWithdrawn)
+		return
+	}
+	if token.State == "PENDING" {
+		h.fileNotReady(w, r, token)
+		return
+	}
+	if e, gone := linkGoneError(h.DB, token); gone {
+		h.renderLinkGone(w, r, e, token, "")
+		return
+	}
+	if token.State != "ACTIVE" {
+		h.renderDownloadError(w, r, tokenStateError(token.State))
 		return
 	}
 
 	if token.WatermarkedPath == nil {
-		http.Error(w, "File not ready", http.StatusServiceUnavailable)
+		h.fileNotReady(w, r, token)
 		return
 	}
 
-	_, consumed, err := db.IncrementDownloadCount(h.DB, token.ID)
+	count, consumed, err := db.IncrementDownloadCount(h.DB, token.ID, token.CampaignID)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Another request used up the link since it was loaded.
+		h.renderLinkGone(w, r, errLinkUsed, token, "")
+		return
+	}
+	if errors.Is(err, db.ErrDownloadBudgetExhausted) {
+		h.renderLinkGone(w, r, errBudgetUsed, token, "")
+		return
+	}
 	if err != nil {
-		http.Error(w, "Internal error", 500)
+		slog.ErrorContext(r.Context(), "increment download count", "token", token.ID, "error", err)
+		h.renderDownloadError(w, r, errLinkUnavailable)
 		return
 	}
 	_ = consumed
@@ -155,7 +443,12 @@ func (h *Handler) DownloadFile(w http.ResponseWriter, r *http.Request) {
 		IPAddress:   realIP(r),
 		UserAgent:   r.UserAgent(),
 	}
-	db.InsertDownloadEvent(h.DB, event)
+	event.Country, event.City = h.GeoIP.Lookup(event.IPAddress)
+	if h.Events != nil {
+		h.Events.Record(event)
+	} else {
+		db.InsertDownloadEvent(h.DB, event)
+	}
 
 	// Dispatch download webhook
 	recipient, _ := db.GetRecipient(h.DB, token.RecipientID)
@@ -171,7 +464,11 @@ func (h *Handler) DownloadFile(w http.ResponseWriter, r *http.Request) {
 			webhookData["recipient_name"] = recipient.Name
 			webhookData["recipient_email"] = recipient.Email
 		}
-		h.Webhook.Dispatch(campaign.AccountID, "download", webhookData)
+		h.Webhook.Dispatch(r.Context(), campaign.AccountID, "download", webhookData)
+		if count == 1 {
+			// Only the download that takes the token from 0 to 1.
+			h.Webhook.Dispatch(r.Context(), campaign.AccountID, "recipient_first_download", webhookData)
+		}
 	}
 
 	// Send download notification email to campaign owner if enabled
@@ -184,25 +481,107 @@ func (h *Handler) DownloadFile(w http.ResponseWriter, r *http.Request) {
 				recipientName = recipient.Name
 				recipientEmail = recipient.Email
 			}
-			downloadTime := time.Now().UTC().Format("2006-01-02 15:04 UTC")
-			ipAddress := event.IPAddress
-			go func() {
-				if err := h.Mailer.SendDownloadNotification(owner.Email, owner.Name, campaign.Name, recipientName, recipientEmail, downloadTime, ipAddress); err != nil {
-					slog.Error("send download notification", "error", err)
-				}
-			}()
+			if h.Digest != nil {
+				h.Digest.Add(owner.ID, owner.Email, owner.Name, email.DownloadEntry{
+					CampaignName:   campaign.Name,
+					RecipientName:  recipientName,
+					RecipientEmail: recipientEmail,
+					IPAddress:      event.IPAddress,
+				})
+			} else {
+				downloadTime := time.Now().UTC().Format("2006-01-02 15:04 UTC")
+				ipAddress := event.IPAddress
+				go func() {
+					if err := h.Mailer.SendDownloadNotification(owner.Email, owner.Name, campaign.Name, recipientName, recipientEmail, downloadTime, ipAddress); err != nil {
+						slog.ErrorContext(r.Context(), "send download notification", "error", err)
+					}
+				}()
+			}
 		}
 	}
 
 	filePath := filepath.Join(h.Cfg.DataDir, *token.WatermarkedPath)
-	ext := filepath.Ext(filePath)
-	filename := sanitizeFilename(campaign.Name) + ext
+	filename := ""
+	if campaign.FilenameTemplate != "" {
+		var d watermark.TextData
+		if recipient != nil {
+			d = watermark.NewTextData(token.ID, recipient.Name, recipient.Email, recipient.Org, campaign.Name, time.Now())
+		} else {
+			d = watermark.NewTextData(token.ID, "", "", "", campaign.Name, time.Now())
+		}
+		if filename, err = templateFilename(campaign.FilenameTemplate, d, filepath.Ext(filePath)); err != nil {
+			slog.WarnContext(r.Context(), "render download filename", "campaign", campaign.ID, "error", err)
+		}
+	}
+	if filename == "" {
+		originalName := ""
+		if h.Cfg.DownloadFilename == "original" {
+			if asset, _ := db.GetAsset(h.DB, campaign.AssetID); asset != nil {
+				originalName = asset.OriginalName
+			}
+		}
+		filename = downloadFilename(campaign.Name, originalName, filepath.Ext(filePath))
+	}
 
-	w.Header().Set("Content-Disposition",
-		fmt.Sprintf(`attachment; filename="%s"`, filename))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
 	http.ServeFile(w, r, filePath)
 }
 
+// downloadFilename names a recipient's copy. With originalName set (the
+// DOWNLOAD_FILENAME=original mode) the uploaded name is kept as far as it is
+// safe, case and non-ASCII included; otherwise the campaign name is used. The
+// extension always comes from the served file, ext, so that it matches the
+// actual type: the original's extension is kept only when it is equivalent
+// (".JPEG" for ".jpg"), and replaced otherwise.
+func downloadFilename(campaignName, originalName, ext string) string {
+	name := preserveFilename(originalName)
+	if name == "" {
+		return sanitizeFilename(campaignName) + ext
+	}
+	if origExt := filepath.Ext(name); origExt != "" && origExt != name {
+		name = strings.TrimSuffix(name, origExt)
+		if sameExtType(origExt, ext) {
+			ext = origExt
+		}
+	}
+	for len(name) > 200 {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+	return name + ext
+}
+
+// preserveFilename drops any directory part, control characters and
+// characters that break paths or headers, leaving everything else as uploaded.
+func preserveFilename(name string) string {
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case unicode.IsControl(r), r == utf8.RuneError:
+			return -1
+		case r == '"', r == ':', r == '*', r == '?', r == '<', r == '>', r == '|':
+			return '_'
+		}
+		return r
+	}, name)
+	name = strings.TrimSpace(name)
+	if strings.Trim(name, ".") == "" {
+		return ""
+	}
+	return name
+}
+
+// sameExtType reports whether two extensions denote the same file type.
+func sameExtType(a, b string) bool {
+	if strings.EqualFold(a, b) {
+		return true
+	}
+	ta, tb := mime.TypeByExtension(strings.ToLower(a)), mime.TypeByExtension(strings.ToLower(b))
+	return ta != "" && ta == tb
+}
+
 func realIP(r *http.Request) string {
 	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
 		parts := strings.Split(xff, ",")
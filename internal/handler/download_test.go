@@ -0,0 +1,524 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/YannKr/downloadonce/internal/db"
+	"github.com/YannKr/downloadonce/internal/model"
+	"github.com/YannKr/downloadonce/internal/watermark"
+	"github.com/YannKr/downloadonce/internal/webhook"
+)
+
+func TestDownloadPageDebouncesOnDemandEnqueue(t *testing.T) {
+	h := newTestHandler(t)
+	h.Cfg.OnDemandGraceSecs = 60
+	seedAccount(t, h.DB, "acc", "member")
+	seedCampaign(t, h.DB, "acc", "camp", "READY")
+
+	// Download links are addressed by UUID, so add the token by hand.
+	if err := db.CreateRecipient(h.DB, &model.Recipient{
+		ID: "alice", AccountID: "acc", Name: "alice", Email: "alice@example.com",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	tokenID := uuid.New().String()
+	if err := db.CreateToken(h.DB, &model.DownloadToken{
+		ID: tokenID, CampaignID: "camp", RecipientID: "alice", State: "PENDING",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	r := chi.NewRouter()
+	r.Get("/d/{token}", h.DownloadPage)
+	load := func() {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest("GET", "/d/"+tokenID, nil))
+	}
+
+	for i := 0; i < 5; i++ {
+		load()
+	}
+	job, err := db.GetJobByToken(h.DB, tokenID)
+	if err != nil || job == nil {
+		t.Fatalf("no on-demand job enqueued: %v", err)
+	}
+
+	// Even once the first job has finished (here: failed), hits inside the
+	// grace window must not enqueue another.
+	if err := db.FailJob(h.DB, job.ID, "boom"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		load()
+	}
+
+	var n int
+	if err := h.DB.QueryRow(`SELECT COUNT(*) FROM jobs WHERE token_id = ?`, tokenID).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("got %d jobs for token, want 1", n)
+	}
+}
+
+func TestDownloadPageRemaining(t *testing.T) {
+	h := newTestHandler(t)
+	seedAccount(t, h.DB, "acc", "member")
+	seedCampaign(t, h.DB, "acc", "camp", "READY")
+
+	token := func(max *int, expiresAt *time.Time) string {
+		t.Helper()
+		id := uuid.New().String()
+		rid := "r-" + id[:8]
+		if err := db.CreateRecipient(h.DB, &model.Recipient{ID: rid, AccountID: "acc", Name: rid, Email: rid + "@example.com"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.CreateToken(h.DB, &model.DownloadToken{
+			ID: id, CampaignID: "camp", RecipientID: rid, State: "ACTIVE", MaxDownloads: max, ExpiresAt: expiresAt,
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	one, three := 1, 3
+	in3Days := time.Now().Add(3*24*time.Hour + time.Minute)
+	capped := token(&one, &in3Days)
+	several := token(&three, nil)
+	open := token(nil, nil)
+
+	r := chi.NewRouter()
+	r.Get("/d/{token}", h.DownloadPage)
+	page := func(id string) string {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest("GET", "/d/"+id, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d", rec.Code)
+		}
+		return rec.Body.String()
+	}
+
+	if body := page(capped); !strings.Contains(body, "download this file 1 more time.") || !strings.Contains(body, "Link expires in 3 days") {
+		t.Errorf("capped page missing remaining downloads or expiry: %s", body)
+	}
+	if body := page(several); !strings.Contains(body, "3 more times.") || strings.Contains(body, "expires in") {
+		t.Errorf("uncapped expiry page: %s", body)
+	}
+	if body := page(open); strings.Contains(body, "more time") || strings.Contains(body, "expires in") {
+		t.Errorf("unlimited page mentions a limit: %s", body)
+	}
+}
+
+func TestHumanizeUntil(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{30 * time.Second, "less than a minute"},
+		{-time.Minute, "less than a minute"},
+		{time.Minute, "1 minute"},
+		{59 * time.Minute, "59 minutes"},
+		{time.Hour, "1 hour"},
+		{47 * time.Hour, "47 hours"},
+		{48 * time.Hour, "2 days"},
+		{10*24*time.Hour + 5*time.Hour, "10 days"},
+	}
+	for _, tc := range tests {
+		if got := humanizeUntil(tc.d); got != tc.want {
+			t.Errorf("humanizeUntil(%v) = %q, want %q", tc.d, got, tc.want)
+		}
+	}
+}
+
+func TestDownloadFileNotReadyJSON(t *testing.T) {
+	h := newTestHandler(t)
+	h.Cfg.FileRetryAfterSecs = 7
+	seedAccount(t, h.DB, "acc", "member")
+	seedCampaign(t, h.DB, "acc", "camp", "PROCESSING")
+
+	if err := db.CreateRecipient(h.DB, &model.Recipient{
+		ID: "alice", AccountID: "acc", Name: "alice", Email: "alice@example.com",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	tokenID := uuid.New().String()
+	if err := db.CreateToken(h.DB, &model.DownloadToken{
+		ID: tokenID, CampaignID: "camp", RecipientID: "alice", State: "PENDING",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.EnqueueJob(h.DB, &model.Job{ID: "job", JobType: "watermark_image", CampaignID: "camp", TokenID: tokenID}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.UpdateJobProgress(h.DB, "job", 40); err != nil {
+		t.Fatal(err)
+	}
+
+	r := chi.NewRouter()
+	r.Get("/d/{token}/file", h.DownloadFile)
+	req := httptest.NewRequest("GET", "/d/"+tokenID+"/file", nil)
+	req.Header.Set("Accept", "application/json")
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want 503", rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "7" {
+		t.Errorf("Retry-After = %q, want 7", got)
+	}
+	var body struct {
+		State      string `json:"state"`
+		Progress   int    `json:"progress"`
+		RetryAfter int    `json:"retry_after"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatalf("decode body: %v", err)
+	}
+	if body.State != "PENDING" || body.Progress != 40 || body.RetryAfter != 7 {
+		t.Errorf("body = %+v, want PENDING/40/7", body)
+	}
+
+	// Browsers get the preparing page with the same 503 and Retry-After.
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/d/"+tokenID+"/file", nil))
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") != "7" {
+		t.Errorf("html: status=%d Retry-After=%q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if body := rec.Body.String(); !strings.Contains(body, "Preparing Your Download") || !strings.Contains(body, "40%") {
+		t.Errorf("html: body is not the preparing page: %.200s", body)
+	}
+}
+
+func TestDownloadFilename(t *testing.T) {
+	tests := []struct {
+		campaign, original, ext, want string
+	}{
+		// campaign mode (no original name)
+		{"Q1: Report?", "", ".jpg", "Q1_ Report_.jpg"},
+		// original mode keeps case, spaces and non-ASCII
+		{"c", "Holiday Photo.JPG", ".jpg", "Holiday Photo.JPG"},
+		{"c", "Ünïcödé – draft.png", ".png", "Ünïcödé – draft.png"},
+		{"c", "photo.jpeg", ".jpg", "photo.jpeg"},
+		// extension follows the served file when the type differs
+		{"c", "scan.heic", ".jpg", "scan.jpg"},
+		{"c", "clip.MOV", ".mp4", "clip.mp4"},
+		{"c", "README", ".png", "README.png"},
+		// unsafe characters and paths
+		{"c", `C:\Users\ann\My "best" shot?.jpg`, ".jpg", "My _best_ shot_.jpg"},
+		{"c", "../../etc/passwd", ".jpg", "passwd.jpg"},
+		{"c", "tab\there\x00.jpg", ".jpg", "tabhere.jpg"},
+		// nothing usable left: fall back to the campaign name
+		{"Camp", "..", ".jpg", "Camp.jpg"},
+		{"Camp", "dir/", ".jpg", "Camp.jpg"},
+	}
+	for _, tc := range tests {
+		if got := downloadFilename(tc.campaign, tc.original, tc.ext); got != tc.want {
+			t.Errorf("downloadFilename(%q, %q, %q) = %q, want %q", tc.campaign, tc.original, tc.ext, got, tc.want)
+		}
+	}
+
+	long := strings.Repeat("é", 150) + ".jpg"
+	if got := downloadFilename("c", long, ".jpg"); len(got) > 204 || !utf8.ValidString(got) || !strings.HasSuffix(got, ".jpg") {
+		t.Errorf("long name not truncated cleanly: %q", got)
+	}
+}
+
+func TestTemplateFilename(t *testing.T) {
+	d := watermark.NewTextData("tok", "Ann Lee", "ann@example.com", "Acme/Legal", "Q1: Report", time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC))
+	tests := []struct {
+		tmpl, ext, want string
+	}{
+		{"{{.CampaignName}} - {{.RecipientName}}", ".jpg", "Q1_ Report - Ann Lee.jpg"},
+		{"{{.RecipientOrg}} {{.Date}}", ".mp4", "Acme_Legal 2026-03-01.mp4"},
+		// path separators never survive, wherever they come from
+		{"../../etc/{{.RecipientName}}", ".jpg", "_.._etc_Ann Lee.jpg"},
+		{`..\{{.RecipientOrg}}\x`, ".png", "_Acme_Legal_x.png"},
+		{"/{{.RecipientName}}/", ".jpg", "_Ann Lee_.jpg"},
+		{"...hidden", ".jpg", "hidden.jpg"},
+		// the served file decides the extension
+		{"{{.RecipientName}}.mp4", ".mp4", "Ann Lee.mp4"},
+		{"{{.RecipientName}}.MOV", ".mp4", "Ann Lee.MOV.mp4"},
+		{"{{.RecipientName}}.jpeg", ".jpg", "Ann Lee.jpg"},
+		// control characters and runs of whitespace
+		{"a\tb\n  c\x00", ".jpg", "a b c.jpg"},
+		// nothing usable left
+		{" . ", ".jpg", ""},
+		{"{{/* empty */}}", ".jpg", ""},
+		{"{{if .RecipientOrg}}{{.RecipientOrg}}{{end}}", ".jpg", "Acme_Legal.jpg"},
+	}
+	for _, tc := range tests {
+		got, err := templateFilename(tc.tmpl, d, tc.ext)
+		if err != nil {
+			t.Errorf("templateFilename(%q): %v", tc.tmpl, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("templateFilename(%q) = %q, want %q", tc.tmpl, got, tc.want)
+		}
+	}
+
+	if _, err := templateFilename("{{.Nope}}", d, ".jpg"); err == nil {
+		t.Error("unknown field rendered without error")
+	}
+	for tmpl, ok := range map[string]bool{
+		"":                   true,
+		"{{.RecipientName}}": true,
+		"{{.RecipientName":   false,
+		"{{.Nope}}":          false,
+		"///":                true, // renders "___"
+		"...":                false,
+		strings.Repeat("x", maxFilenameTemplateLen+1): false,
+
+		// rendered on public download requests, so nothing that loops or
+		// builds large output
+		`{{range 100000000}}{{printf "%0100d" 1}}{{end}}`: false,
+		`{{.RecipientOrg | printf "%.0s"}}`:               false,
+	} {
+		if err := validateFilenameTemplate(tmpl); (err == nil) != ok {
+			t.Errorf("validateFilenameTemplate(%q) = %v, want ok %v", tmpl, err, ok)
+		}
+	}
+}
+
+func TestRecipientFirstDownloadWebhook(t *testing.T) {
+	h := newTestHandler(t)
+	seedAccount(t, h.DB, "acc", "member")
+	seedCampaign(t, h.DB, "acc", "camp", "READY")
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	if err := db.CreateWebhook(h.DB, &model.Webhook{ID: "wh", AccountID: "acc", URL: srv.URL, Secret: "s",
+		Events: "download,recipient_first_download", Enabled: true}); err != nil {
+		t.Fatal(err)
+	}
+	h.Webhook = &webhook.Dispatcher{DB: h.DB}
+
+	if err := db.CreateRecipient(h.DB, &model.Recipient{
+		ID: "alice", AccountID: "acc", Name: "alice", Email: "alice@example.com",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	tokenID := uuid.New().String()
+	if err := db.CreateToken(h.DB, &model.DownloadToken{
+		ID: tokenID, CampaignID: "camp", RecipientID: "alice", State: "PENDING",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	rel := filepath.Join("watermarked", "camp", tokenID+".jpg")
+	if err := os.MkdirAll(filepath.Join(h.Cfg.DataDir, "watermarked", "camp"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(h.Cfg.DataDir, rel), []byte("jpg"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.ActivateToken(h.DB, tokenID, rel, "00", 3); err != nil {
+		t.Fatal(err)
+	}
+
+	r := chi.NewRouter()
+	r.Get("/d/{token}/file", h.DownloadFile)
+	for i := 0; i < 3; i++ {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest("GET", "/d/"+tokenID+"/file", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("download %d: status %d", i, rec.Code)
+		}
+	}
+
+	counts := map[string]int{}
+	rows, err := h.DB.Query(`SELECT event_type, COUNT(*) FROM webhook_deliveries GROUP BY event_type`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rows.Next() {
+		var ev string
+		var n int
+		rows.Scan(&ev, &n)
+		counts[ev] = n
+	}
+	rows.Close()
+	if counts["download"] != 3 || counts["recipient_first_download"] != 1 {
+		t.Errorf("deliveries = %v, want 3 download and 1 recipient_first_download", counts)
+	}
+}
+
+func TestDownloadErrorPages(t *testing.T) {
+	h := newTestHandler(t)
+	h.Cfg.DownloadSupportContact = "Email press@example.com for a new link"
+	seedAccount(t, h.DB, "acc", "member")
+	seedCampaign(t, h.DB, "acc", "camp", "READY")
+
+	token := func(state string, expired bool) string {
+		t.Helper()
+		id := uuid.New().String()
+		rid := "r-" + id[:8]
+		if err := db.CreateRecipient(h.DB, &model.Recipient{ID: rid, AccountID: "acc", Name: rid, Email: rid + "@example.com"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.CreateToken(h.DB, &model.DownloadToken{ID: id, CampaignID: "camp", RecipientID: rid, State: state}); err != nil {
+			t.Fatal(err)
+		}
+		if expired {
+			if _, err := h.DB.Exec(`UPDATE download_tokens SET expires_at = '2020-01-01T00:00:00.000Z' WHERE id = ?`, id); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return id
+	}
+	used := token("CONSUMED", false)
+	revoked := token("EXPIRED", false)
+	lapsed := token("ACTIVE", true)
+	// A link expired because the campaign's total download budget ran out.
+	budgetTok := uuid.New().String()
+	seeded := seedCampaign(t, h.DB, "acc", "budget", "READY", "rb")[0]
+	if _, err := h.DB.Exec(`UPDATE campaigns SET max_total_downloads = 3, total_downloads = 3 WHERE id = 'budget'`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.DB.Exec(`UPDATE download_tokens SET id = ?, state = 'EXPIRED' WHERE id = ?`, budgetTok, seeded); err != nil {
+		t.Fatal(err)
+	}
+
+	// Links of a soft-deleted campaign, ready or still to be watermarked.
+	deleted := seedCampaign(t, h.DB, "acc", "deleted", "READY", "rd1", "rd2")
+	deletedActive, deletedPending := uuid.New().String(), uuid.New().String()
+	if _, err := h.DB.Exec(`UPDATE download_tokens SET id = ?, state = 'ACTIVE', watermarked_path = 'x.png' WHERE id = ?`, deletedActive, deleted[0]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.DB.Exec(`UPDATE download_tokens SET id = ? WHERE id = ?`, deletedPending, deleted[1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SoftDeleteCampaign(h.DB, "deleted"); err != nil {
+		t.Fatal(err)
+	}
+
+	r := chi.NewRouter()
+	r.Get("/d/{token}", h.DownloadPage)
+	r.Get("/d/{token}/file", h.DownloadFile)
+
+	tests := []struct {
+		name, id   string
+		status     int
+		title, msg string
+	}{
+		{"malformed", "not-a-uuid", http.StatusNotFound, "Link Not Found", "doesn&#39;t exist"},
+		{"unknown", uuid.New().String(), http.StatusNotFound, "Link Not Found", "doesn&#39;t exist"},
+		{"consumed", used, http.StatusGone, "Link Used", "already been used"},
+		{"revoked", revoked, http.StatusGone, "Link Expired", "expired or been revoked"},
+		{"past expiry", lapsed, http.StatusGone, "Link Expired", "expired or been revoked"},
+		{"budget used", budgetTok, http.StatusGone, "Download Limit Reached", "total download limit"},
+		{"campaign deleted", deletedActive, http.StatusGone, "Download Unavailable", "no longer available"},
+		{"campaign deleted, not watermarked", deletedPending, http.StatusGone, "Download Unavailable", "no longer available"},
+	}
+	for _, tc := range tests {
+		for _, suffix := range []string{"", "/file"} {
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, httptest.NewRequest("GET", "/d/"+tc.id+suffix, nil))
+			body := rec.Body.String()
+			if rec.Code != tc.status {
+				t.Errorf("%s%s: status = %d, want %d", tc.name, suffix, rec.Code, tc.status)
+			}
+			if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+				t.Errorf("%s%s: Content-Type = %q, want the templated page", tc.name, suffix, ct)
+			}
+			if !strings.Contains(body, `class="download-card"`) || !strings.Contains(body, "<h1>"+tc.title+"</h1>") ||
+				!strings.Contains(body, tc.msg) || !strings.Contains(body, "press@example.com") {
+				t.Errorf("%s%s: body is not the %q page: %.300s", tc.name, suffix, tc.title, body)
+			}
+		}
+	}
+	if job, _ := db.GetJobByToken(h.DB, deletedPending); job != nil {
+		t.Errorf("deleted campaign's pending link queued job %s", job.ID)
+	}
+}
+
+func TestDownloadRequestLink(t *testing.T) {
+	h := newTestHandler(t)
+	seedAccount(t, h.DB, "acc", "member")
+	seedCampaign(t, h.DB, "acc", "camp", "READY")
+	if _, err := h.DB.Exec(`UPDATE campaigns SET expiry_message = 'Write to <b>press@example.com</b><script>x</script>',
+		allow_link_requests = 1 WHERE id = 'camp'`); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	if err := db.CreateWebhook(h.DB, &model.Webhook{ID: "wh", AccountID: "acc", URL: srv.URL, Secret: "s",
+		Events: "link_requested", Enabled: true}); err != nil {
+		t.Fatal(err)
+	}
+	h.Webhook = &webhook.Dispatcher{DB: h.DB}
+
+	used, active := uuid.New().String(), uuid.New().String()
+	for _, tok := range []struct{ id, recipient, state string }{
+		{used, "alice", "CONSUMED"},
+		{active, "bob", "ACTIVE"},
+	} {
+		if err := db.CreateRecipient(h.DB, &model.Recipient{ID: tok.recipient, AccountID: "acc", Name: tok.recipient,
+			Email: tok.recipient + "@example.com"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.CreateToken(h.DB, &model.DownloadToken{ID: tok.id, CampaignID: "camp", RecipientID: tok.recipient,
+			State: tok.state}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	h.Cfg.AllowMessageHTML = true
+
+	r := chi.NewRouter()
+	r.Get("/d/{token}", h.DownloadPage)
+	r.Post("/d/{token}/request-link", h.DownloadRequestLink)
+	request := func(id, addr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/d/"+id+"/request-link", strings.NewReader("email="+addr))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec
+	}
+	deliveries := func() int {
+		var n int
+		h.DB.QueryRow(`SELECT COUNT(*) FROM webhook_deliveries WHERE event_type = 'link_requested'`).Scan(&n)
+		return n
+	}
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/d/"+used, nil))
+	body := rec.Body.String()
+	if rec.Code != http.StatusGone || !strings.Contains(body, "press@example.com</b>") || strings.Contains(body, "<script>x") {
+		t.Errorf("expired page = %d, message not rendered sanitized: %.500s", rec.Code, body)
+	}
+	if !strings.Contains(body, `action="/d/`+used+`/request-link"`) {
+		t.Error("expired page has no request form")
+	}
+
+	if rec := request(used, "not-an-email"); rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "valid email") {
+		t.Errorf("invalid email = %d", rec.Code)
+	}
+	if rec := request(active, "alice@example.com"); rec.Code != http.StatusSeeOther || deliveries() != 0 {
+		t.Errorf("active token = %d, %d deliveries", rec.Code, deliveries())
+	}
+	if rec := request(used, "alice@example.com"); rec.Code != http.StatusSeeOther || deliveries() != 1 {
+		t.Errorf("request = %d, %d deliveries", rec.Code, deliveries())
+	}
+
+	if _, err := h.DB.Exec(`UPDATE campaigns SET allow_link_requests = 0 WHERE id = 'camp'`); err != nil {
+		t.Fatal(err)
+	}
+	if rec := request(used, "alice@example.com"); rec.Code != http.StatusSeeOther || deliveries() != 1 {
+		t.Errorf("disabled campaign = %d, %d deliveries", rec.Code, deliveries())
+	}
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/d/"+used, nil))
+	if strings.Contains(rec.Body.String(), "request-link") {
+		t.Error("request form shown with link requests disabled")
+	}
+}
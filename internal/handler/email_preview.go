@@ -0,0 +1,39 @@
+package handler
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/YannKr/downloadonce/internal/email"
+)
+
+type emailPreviewData struct {
+	Subject    string
+	Text       string
+	HTML       string // rendered body, shown in a sandboxed iframe
+	Dir        string
+	Overridden []string
+	Error      string
+}
+
+// EmailPreview renders the download link email with sample data so the
+// output of EMAIL_TEMPLATE_DIR overrides can be checked before publishing.
+func (h *Handler) EmailPreview(w http.ResponseWriter, r *http.Request) {
+	tmpls := email.DefaultTemplates()
+	if h.Mailer != nil && h.Mailer.Templates != nil {
+		tmpls = h.Mailer.Templates
+	}
+
+	expires := time.Now().Add(7 * 24 * time.Hour)
+	sample := email.NewLinkEmail("Jane Doe", "Quarterly Report", h.Cfg.BaseURL+"/d/00000000-0000-4000-8000-000000000000", &expires)
+
+	data := emailPreviewData{Dir: tmpls.Dir, Overridden: tmpls.Overridden}
+	subject, text, html, err := tmpls.RenderDownloadLink(sample)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "email preview", "error", err)
+		data.Error = err.Error()
+	}
+	data.Subject, data.Text, data.HTML = subject, text, html
+	h.renderAuth(w, r, "settings_email_preview.html", "Email preview", data)
+}
@@ -0,0 +1,45 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/YannKr/downloadonce/internal/email"
+)
+
+func TestEmailPreview(t *testing.T) {
+	h := newTestHandler(t)
+	seedAccount(t, h.DB, "acct-1", "member")
+
+	get := func() string {
+		t.Helper()
+		req := asAccount(httptest.NewRequest("GET", "/settings/email-preview", nil), "acct-1", "member")
+		rec := httptest.NewRecorder()
+		h.EmailPreview(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d", rec.Code)
+		}
+		return rec.Body.String()
+	}
+
+	body := get()
+	if !strings.Contains(body, "Your download link for Quarterly Report") || !strings.Contains(body, "http://dl.test/d/") {
+		t.Errorf("built-in preview missing subject or link:\n%s", body)
+	}
+
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, email.LinkSubjectFile), []byte("Custom: {{.CampaignName}}"), 0o644)
+	tm, err := email.LoadTemplates(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Mailer = &email.Mailer{Templates: tm}
+	body = get()
+	if !strings.Contains(body, "Custom: Quarterly Report") || !strings.Contains(body, email.LinkSubjectFile) {
+		t.Errorf("override preview missing custom subject:\n%s", body)
+	}
+}
@@ -0,0 +1,120 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+)
+
+// Recipient email validation modes (RECIPIENT_EMAIL_VALIDATION).
+const (
+	emailValidationOff    = "off"    // any non-empty string
+	emailValidationBasic  = "basic"  // parses as a bare RFC 5322 address
+	emailValidationStrict = "strict" // basic, plus a dot-atom local part and a dotted LDH domain
+)
+
+// ParseEmailValidation normalizes a RECIPIENT_EMAIL_VALIDATION value. An
+// empty string is basic; anything other than off, basic or strict is an
+// error.
+func ParseEmailValidation(s string) (string, error) {
+	switch v := strings.ToLower(strings.TrimSpace(s)); v {
+	case "":
+		return emailValidationBasic, nil
+	case emailValidationOff, emailValidationBasic, emailValidationStrict:
+		return v, nil
+	}
+	return "", fmt.Errorf("recipient email validation: unknown value %q (want off, basic or strict)", s)
+}
+
+// validateEmail normalizes a recipient address with normalizeEmail and checks
+// it according to the configured mode (see ParseEmailValidation), returning
+// the address to store and an error suitable for showing to the user.
+func (h *Handler) validateEmail(addr string) (string, error) {
+	addr = normalizeEmail(addr)
+	return addr, validateEmailMode(addr, h.Cfg.RecipientEmailCheck)
+}
+
+// normalizeEmail trims and lowercases addr so a mailbox is stored, and
+// matched, the same way however it was typed.
+func normalizeEmail(addr string) string {
+	return strings.ToLower(strings.TrimSpace(addr))
+}
+
+func validateEmailMode(addr, mode string) error {
+	if addr == "" {
+		return errors.New("email is required")
+	}
+	if mode == emailValidationOff {
+		return nil
+	}
+
+	parsed, err := mail.ParseAddress(addr)
+	if err != nil || parsed.Address != addr {
+		// Reject display-name forms ("Bob <bob@example.com>") as well as
+		// unparseable input: the stored value is used as the To address.
+		return fmt.Errorf("%q is not a valid email address", addr)
+	}
+	if mode != emailValidationStrict {
+		return nil
+	}
+
+	at := strings.LastIndex(addr, "@")
+	local, domain := addr[:at], addr[at+1:]
+	if !isDotAtom(local) || !isHostname(domain) {
+		return fmt.Errorf("%q is not a valid email address", addr)
+	}
+	return nil
+}
+
+// isDotAtom reports whether s is an unquoted RFC 5322 dot-atom.
+func isDotAtom(s string) bool {
+	for _, part := range strings.Split(s, ".") {
+		if part == "" {
+			return false
+		}
+		for _, c := range part {
+			if !isAtext(c) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func isAtext(c rune) bool {
+	switch {
+	case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		return true
+	}
+	return strings.ContainsRune("!#$%&'*+-/=?^_`{|}~", c)
+}
+
+// isHostname reports whether s is a dotted DNS name whose last label is
+// alphabetic, e.g. "mail.example.com".
+func isHostname(s string) bool {
+	labels := strings.Split(s, ".")
+	if len(labels) < 2 {
+		return false
+	}
+	for _, l := range labels {
+		if l == "" || len(l) > 63 || l[0] == '-' || l[len(l)-1] == '-' {
+			return false
+		}
+		for _, c := range l {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+				return false
+			}
+		}
+	}
+	tld := labels[len(labels)-1]
+	if len(tld) < 2 {
+		return false
+	}
+	for _, c := range tld {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z') {
+			return false
+		}
+	}
+	return true
+}
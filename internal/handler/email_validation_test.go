@@ -0,0 +1,147 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/YannKr/downloadonce/internal/db"
+)
+
+func TestValidateEmailMode(t *testing.T) {
+	tests := []struct {
+		addr               string
+		off, basic, strict bool // valid in each mode
+	}{
+		{"bob@example.com", true, true, true},
+		{"first.last+tag@mail.example.co.uk", true, true, true},
+		{"john@", true, false, false},
+		{"@example.com", true, false, false},
+		{"john", true, false, false},
+		{"john@@example.com", true, false, false},
+		{"Bob <bob@example.com>", true, false, false},
+		{"bob@localhost", true, true, false},
+		{"bob@example.c", true, true, false},
+		{"bob@-example.com", true, true, false},
+		{`"bob smith"@example.com`, true, false, false},
+		{"john doe@example.com", true, false, false},
+		{"john@example..com", true, false, false},
+		{"john.@example.com", true, false, false},
+		{"john@example,com", true, false, false},
+		{"john@[127.0.0.1]", true, true, false},
+		{"", false, false, false},
+	}
+	for _, tt := range tests {
+		for mode, want := range map[string]bool{
+			emailValidationOff: tt.off, emailValidationBasic: tt.basic, emailValidationStrict: tt.strict,
+		} {
+			err := validateEmailMode(tt.addr, mode)
+			if (err == nil) != want {
+				t.Errorf("%s: validateEmailMode(%q) = %v, want valid=%v", mode, tt.addr, err, want)
+			}
+		}
+	}
+}
+
+func TestParseEmailValidation(t *testing.T) {
+	for in, want := range map[string]string{"": emailValidationBasic, "off": emailValidationOff, " Strict ": emailValidationStrict} {
+		if got, err := ParseEmailValidation(in); err != nil || got != want {
+			t.Errorf("ParseEmailValidation(%q) = %q, %v, want %q", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"none", "stric", "true"} {
+		if _, err := ParseEmailValidation(in); err == nil {
+			t.Errorf("ParseEmailValidation(%q) accepted", in)
+		}
+	}
+}
+
+func TestAPIRecipientCreateRejectsInvalidEmail(t *testing.T) {
+	h := newTestHandler(t)
+	seedAccount(t, h.DB, "acc", "member")
+
+	post := func(body string) *httptest.ResponseRecorder {
+		req := asAccount(httptest.NewRequest("POST", "/api/v1/recipients", strings.NewReader(body)), "acc", "member")
+		rec := httptest.NewRecorder()
+		h.APIRecipientCreate(rec, req)
+		return rec
+	}
+
+	rec := post(`{"name":"John","email":"john@"}`)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "INVALID_EMAIL") {
+		t.Fatalf("invalid email: status = %d, body %s", rec.Code, rec.Body)
+	}
+	if rec := post(`{"name":"John","email":"john@example.com"}`); rec.Code != http.StatusCreated {
+		t.Fatalf("valid email: status = %d, body %s", rec.Code, rec.Body)
+	}
+
+	h.Cfg.RecipientEmailCheck = emailValidationOff
+	if rec := post(`{"name":"Typo","email":"typo@"}`); rec.Code != http.StatusCreated {
+		t.Errorf("validation off: status = %d, body %s", rec.Code, rec.Body)
+	}
+}
+
+func TestRecipientImportReportsInvalidRows(t *testing.T) {
+	h := newTestHandler(t)
+	seedAccount(t, h.DB, "acc", "member")
+
+	form := "bulk=" + strings.ReplaceAll("Ann,ann@example.com\nJohn,john@\nBea,bea@example.com", "\n", "%0A")
+	req := httptest.NewRequest("POST", "/recipients/import", strings.NewReader(form))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	h.RecipientImport(rec, asAccount(req, "acc", "member"))
+
+	if !strings.Contains(rec.Body.String(), "line 2") {
+		t.Errorf("response does not report the invalid line")
+	}
+	recipients, err := db.ListRecipients(h.DB, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recipients) != 2 {
+		t.Errorf("imported %d recipients, want 2", len(recipients))
+	}
+}
+
+func TestRecipientEmailsAreNormalized(t *testing.T) {
+	h := newTestHandler(t)
+	seedAccount(t, h.DB, "acc", "member")
+	// Saved before addresses were normalized.
+	if _, err := h.DB.Exec(`INSERT INTO recipients (id, account_id, name, email) VALUES ('bob', 'acc', 'Bob', 'Bob@Example.com')`); err != nil {
+		t.Fatal(err)
+	}
+
+	req := asAccount(httptest.NewRequest("POST", "/api/v1/recipients", strings.NewReader(`{"name":"Ann","email":" Ann@Example.COM "}`)), "acc", "member")
+	rec := httptest.NewRecorder()
+	h.APIRecipientCreate(rec, req)
+	if rec.Code != http.StatusCreated || !strings.Contains(rec.Body.String(), `"email":"ann@example.com"`) {
+		t.Fatalf("create: status = %d, body %s", rec.Code, rec.Body)
+	}
+
+	// The API returns the legacy row instead of creating a case variant.
+	req = asAccount(httptest.NewRequest("POST", "/api/v1/recipients", strings.NewReader(`{"name":"Bob","email":"BOB@example.com"}`)), "acc", "member")
+	rec = httptest.NewRecorder()
+	h.APIRecipientCreate(rec, req)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"id":"bob"`) {
+		t.Errorf("existing: status = %d, body %s", rec.Code, rec.Body)
+	}
+
+	form := "name=Bob&email=bob%40example.com"
+	req = httptest.NewRequest("POST", "/recipients", strings.NewReader(form))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec = httptest.NewRecorder()
+	h.RecipientCreate(rec, asAccount(req, "acc", "member"))
+	if !strings.Contains(rec.Body.String(), "already exists") {
+		t.Errorf("web create of a case variant was not rejected")
+	}
+
+	req = httptest.NewRequest("POST", "/recipients/import", strings.NewReader("bulk=Ann+Again,ANN@example.com"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	h.RecipientImport(httptest.NewRecorder(), asAccount(req, "acc", "member"))
+
+	recipients, _ := db.ListRecipients(h.DB, "")
+	if len(recipients) != 2 {
+		t.Errorf("got %d recipients, want ann and bob only: %+v", len(recipients), recipients)
+	}
+}
@@ -0,0 +1,77 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/YannKr/downloadonce/internal/watermark"
+)
+
+// maxFilenameTemplateLen bounds a campaign's filename template; the rendered
+// name is cut to 200 bytes like every other download name.
+const maxFilenameTemplateLen = 200
+
+// validateFilenameTemplate checks that tmpl parses and renders a usable name
+// for sample data, so a campaign cannot be created with a template that fails
+// every download. An empty template is valid and keeps DOWNLOAD_FILENAME.
+func validateFilenameTemplate(tmpl string) error {
+	if tmpl == "" {
+		return nil
+	}
+	if len(tmpl) > maxFilenameTemplateLen {
+		return fmt.Errorf("filename template is longer than %d characters", maxFilenameTemplateLen)
+	}
+	sample := watermark.NewTextData("00000000-0000-0000-0000-000000000000", "Jane Doe", "jane@example.com", "Example Corp", "Campaign", time.Now())
+	name, err := templateFilename(tmpl, sample, ".mp4")
+	if err != nil {
+		return err
+	}
+	if name == "" {
+		return errors.New("filename template renders an empty name")
+	}
+	return nil
+}
+
+// templateFilename renders a campaign's filename template for one download
+// and makes the result safe to send: path separators and the other
+// characters that break paths or headers become "_", control characters are
+// dropped, whitespace collapses, and leading and trailing dots are trimmed so
+// the name can neither climb directories nor be hidden. The extension is
+// always ext, the served file's (a watermarked video is always ".mp4"); one
+// the template writes itself is dropped when it means the same type. It
+// returns "" when nothing usable is left.
+func templateFilename(tmpl string, d watermark.TextData, ext string) (string, error) {
+	out, err := watermark.ExecuteTemplate("filename", tmpl, d)
+	if err != nil {
+		return "", err
+	}
+
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case unicode.IsSpace(r):
+			return ' '
+		case unicode.IsControl(r), r == utf8.RuneError:
+			return -1
+		case strings.ContainsRune(`/\:*?"<>|`, r):
+			return '_'
+		}
+		return r
+	}, out)
+	name = strings.Trim(strings.Join(strings.Fields(name), " "), ". ")
+	if e := filepath.Ext(name); e != "" && sameExtType(e, ext) {
+		name = strings.TrimRight(strings.TrimSuffix(name, e), ". ")
+	}
+	for len(name) > 200 {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = strings.TrimRight(name[:len(name)-size], ". ")
+	}
+	if name == "" {
+		return "", nil
+	}
+	return name + ext, nil
+}
@@ -0,0 +1,69 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/YannKr/downloadonce/internal/auth"
+)
+
+func flashCookie(t *testing.T, h *Handler, message string) *http.Cookie {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.setFlash(rec, message)
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == auth.FlashCookieName {
+			return c
+		}
+	}
+	t.Fatal("no flash cookie set")
+	return nil
+}
+
+func TestFlashRoundTrip(t *testing.T) {
+	h := newTestHandler(t)
+	msg := "Group 'Press; \"VIP\"' created."
+	req := httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(flashCookie(t, h, msg))
+	if got := h.getFlash(httptest.NewRecorder(), req); got != msg {
+		t.Errorf("getFlash = %q, want %q", got, msg)
+	}
+}
+
+func TestFlashTamperedIgnored(t *testing.T) {
+	h := newTestHandler(t)
+	valid := flashCookie(t, h, "Campaign published.")
+	payload, sig, _ := strings.Cut(valid.Value, ".")
+	forged := flashCookie(t, h, "Your account is suspended.")
+	forgedPayload, _, _ := strings.Cut(forged.Value, ".")
+
+	cases := map[string]string{
+		"plaintext":         "Your account is suspended, call 555-0100",
+		"swapped payload":   forgedPayload + "." + sig,
+		"truncated sig":     payload + "." + sig[:len(sig)-2],
+		"missing signature": payload,
+	}
+	for name, value := range cases {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.AddCookie(&http.Cookie{Name: auth.FlashCookieName, Value: value})
+			rec := httptest.NewRecorder()
+			if got := h.getFlash(rec, req); got != "" {
+				t.Errorf("getFlash = %q, want tampered cookie ignored", got)
+			}
+			if c := rec.Result().Cookies(); len(c) != 1 || c[0].MaxAge >= 0 {
+				t.Errorf("tampered cookie was not cleared: %v", c)
+			}
+		})
+	}
+
+	other := newTestHandler(t)
+	other.Cfg.SessionSecret = "another-secret-another-secret-an"
+	req := httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(valid)
+	if got := other.getFlash(httptest.NewRecorder(), req); got != "" {
+		t.Errorf("cookie signed with a different secret was accepted: %q", got)
+	}
+}
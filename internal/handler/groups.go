@@ -1,9 +1,7 @@
 package handler
 
 import (
-	"encoding/csv"
 	"fmt"
-	"io"
 	"log/slog"
 	"net/http"
 	"strings"
@@ -20,16 +18,17 @@ type groupListData struct {
 }
 
 type groupDetailData struct {
-	Group      model.RecipientGroup
-	Members    []model.RecipientGroupMember
-	NonMembers []model.Recipient
+	Group        model.RecipientGroup
+	Members      []model.RecipientGroupMember
+	NonMembers   []model.Recipient
+	ImportErrors []importRowError // rows rejected by the last CSV import
 }
 
 func (h *Handler) GroupList(w http.ResponseWriter, r *http.Request) {
 	accountID := auth.AccountFromContext(r.Context())
 	groups, err := db.ListRecipientGroups(h.DB, accountID)
 	if err != nil {
-		slog.Error("list recipient groups", "error", err)
+		slog.ErrorContext(r.Context(), "list recipient groups", "error", err)
 		http.Error(w, "Internal error", 500)
 		return
 	}
@@ -62,12 +61,12 @@ func (h *Handler) GroupCreate(w http.ResponseWriter, r *http.Request) {
 			})
 			return
 		}
-		slog.Error("create recipient group", "error", err)
+		slog.ErrorContext(r.Context(), "create recipient group", "error", err)
 		http.Error(w, "Internal error", 500)
 		return
 	}
 	db.InsertAuditLog(h.DB, accountID, "group_created", "group", id, name, r.RemoteAddr)
-	setFlash(w, "Group '"+name+"' created.")
+	h.setFlash(w, "Group '"+name+"' created.")
 	http.Redirect(w, r, "/recipients/groups/"+id, http.StatusSeeOther)
 }
 
@@ -83,13 +82,17 @@ func (h *Handler) GroupDetail(w http.ResponseWriter, r *http.Request) {
 		http.NotFound(w, r)
 		return
 	}
-	members, _ := db.ListGroupMembers(h.DB, id, group.AccountID)
-	nonMembers, _ := db.ListNonMembers(h.DB, id)
-	h.renderAuth(w, r, "recipient_group_detail.html", group.Name, groupDetailData{
+	h.renderAuth(w, r, "recipient_group_detail.html", group.Name, h.groupDetail(group))
+}
+
+func (h *Handler) groupDetail(group *model.RecipientGroup) groupDetailData {
+	members, _ := db.ListGroupMembers(h.DB, group.ID, group.AccountID)
+	nonMembers, _ := db.ListNonMembers(h.DB, group.ID)
+	return groupDetailData{
 		Group:      *group,
 		Members:    members,
 		NonMembers: nonMembers,
-	})
+	}
 }
 
 func (h *Handler) GroupEdit(w http.ResponseWriter, r *http.Request) {
@@ -107,22 +110,22 @@ func (h *Handler) GroupEdit(w http.ResponseWriter, r *http.Request) {
 	name := strings.TrimSpace(r.FormValue("name"))
 	description := strings.TrimSpace(r.FormValue("description"))
 	if name == "" {
-		setFlash(w, "Group name cannot be empty.")
+		h.setFlash(w, "Group name cannot be empty.")
 		http.Redirect(w, r, "/recipients/groups/"+id, http.StatusSeeOther)
 		return
 	}
 	if err := db.UpdateRecipientGroup(h.DB, id, group.AccountID, name, description); err != nil {
 		if strings.Contains(err.Error(), "UNIQUE") {
-			setFlash(w, "A group named '"+name+"' already exists.")
+			h.setFlash(w, "A group named '"+name+"' already exists.")
 			http.Redirect(w, r, "/recipients/groups/"+id, http.StatusSeeOther)
 			return
 		}
-		slog.Error("update recipient group", "error", err)
+		slog.ErrorContext(r.Context(), "update recipient group", "error", err)
 		http.Error(w, "Internal error", 500)
 		return
 	}
 	db.InsertAuditLog(h.DB, accountID, "group_updated", "group", id, name, r.RemoteAddr)
-	setFlash(w, "Group updated.")
+	h.setFlash(w, "Group updated.")
 	http.Redirect(w, r, "/recipients/groups/"+id, http.StatusSeeOther)
 }
 
@@ -139,12 +142,12 @@ func (h *Handler) GroupDelete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if err := db.DeleteRecipientGroup(h.DB, id, group.AccountID); err != nil {
-		slog.Error("delete recipient group", "error", err)
+		slog.ErrorContext(r.Context(), "delete recipient group", "error", err)
 		http.Error(w, "Internal error", 500)
 		return
 	}
 	db.InsertAuditLog(h.DB, accountID, "group_deleted", "group", id, group.Name, r.RemoteAddr)
-	setFlash(w, "Group '"+group.Name+"' deleted.")
+	h.setFlash(w, "Group '"+group.Name+"' deleted.")
 	http.Redirect(w, r, "/recipients/groups", http.StatusSeeOther)
 }
 
@@ -168,7 +171,7 @@ func (h *Handler) GroupAddMembers(w http.ResponseWriter, r *http.Request) {
 			db.InsertAuditLog(h.DB, accountID, "group_member_added", "group", id, rid, r.RemoteAddr)
 		}
 	}
-	setFlash(w, fmt.Sprintf("%d member(s) added.", added))
+	h.setFlash(w, fmt.Sprintf("%d member(s) added.", added))
 	http.Redirect(w, r, "/recipients/groups/"+id, http.StatusSeeOther)
 }
 
@@ -186,12 +189,12 @@ func (h *Handler) GroupRemoveMember(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if err := db.RemoveGroupMember(h.DB, id, recipientID); err != nil {
-		slog.Error("remove group member", "error", err)
+		slog.ErrorContext(r.Context(), "remove group member", "error", err)
 		http.Error(w, "Internal error", 500)
 		return
 	}
 	db.InsertAuditLog(h.DB, accountID, "group_member_removed", "group", id, recipientID, r.RemoteAddr)
-	setFlash(w, "Member removed.")
+	h.setFlash(w, "Member removed.")
 	http.Redirect(w, r, "/recipients/groups/"+id, http.StatusSeeOther)
 }
 
@@ -210,63 +213,31 @@ func (h *Handler) GroupImport(w http.ResponseWriter, r *http.Request) {
 	r.ParseMultipartForm(10 << 20)
 	file, _, err := r.FormFile("file")
 	if err != nil {
-		setFlash(w, "No file uploaded.")
+		h.setFlash(w, "No file uploaded.")
 		http.Redirect(w, r, "/recipients/groups/"+id, http.StatusSeeOther)
 		return
 	}
 	defer file.Close()
 
-	reader := csv.NewReader(file)
-	reader.TrimLeadingSpace = true
-	reader.FieldsPerRecord = -1
+	rows, errs, err := parseRecipientCSV(file)
+	if err != nil {
+		h.setFlash(w, "Could not read the CSV file.")
+		http.Redirect(w, r, "/recipients/groups/"+id, http.StatusSeeOther)
+		return
+	}
 
-	var added, newRecipients, alreadyMember int
-	firstRow := true
-	for {
-		record, err := reader.Read()
-		if err == io.EOF {
-			break
-		}
-		if err != nil || len(record) < 2 {
-			continue
-		}
-		name := strings.TrimSpace(record[0])
-		email := strings.ToLower(strings.TrimSpace(record[1]))
-		org := ""
-		if len(record) >= 3 {
-			org = strings.TrimSpace(record[2])
-		}
-		// Skip header row
-		if firstRow {
-			firstRow = false
-			if strings.EqualFold(name, "name") && strings.EqualFold(email, "email") {
-				continue
-			}
-		}
-		if name == "" || email == "" {
-			continue
-		}
-		recipient, _ := db.GetOrCreateRecipientByEmail(h.DB, accountID, name, email, org)
-		if recipient.ID == "" {
-			recipient.ID = uuid.New().String()
-			if err := db.CreateRecipient(h.DB, recipient); err != nil {
-				slog.Error("csv import: create recipient", "error", err)
-				continue
-			}
-			newRecipients++
-		}
-		prevAdded := added
+	var added, alreadyMember int
+	res := h.importRecipients(accountID, rows, errs, func(recipient *model.Recipient) {
 		if err := db.AddGroupMember(h.DB, id, recipient.ID); err == nil {
 			added++
 			db.InsertAuditLog(h.DB, accountID, "group_member_added", "group", id, recipient.ID, r.RemoteAddr)
-		}
-		if added == prevAdded {
+		} else {
 			alreadyMember++
 		}
-	}
+	})
 	parts := []string{}
-	if newRecipients > 0 {
-		parts = append(parts, fmt.Sprintf("%d new recipient(s) created", newRecipients))
+	if res.Created > 0 {
+		parts = append(parts, fmt.Sprintf("%d new recipient(s) created", res.Created))
 	}
 	if added > 0 {
 		parts = append(parts, fmt.Sprintf("%d added to group", added))
@@ -278,7 +249,22 @@ func (h *Handler) GroupImport(w http.ResponseWriter, r *http.Request) {
 	if len(parts) > 0 {
 		msg = strings.Join(parts, ", ") + "."
 	}
-	db.InsertAuditLog(h.DB, accountID, "group_import", "group", id, msg, r.RemoteAddr)
-	setFlash(w, msg)
-	http.Redirect(w, r, "/recipients/groups/"+id, http.StatusSeeOther)
+	db.InsertAuditLog(h.DB, accountID, "group_import", "group", id,
+		fmt.Sprintf("%s %d row(s) rejected.", msg, len(res.Errors)), r.RemoteAddr)
+	if len(res.Errors) == 0 {
+		h.setFlash(w, msg)
+		http.Redirect(w, r, "/recipients/groups/"+id, http.StatusSeeOther)
+		return
+	}
+
+	// Render the report inline; it is too long for a flash message.
+	data := h.groupDetail(group)
+	data.ImportErrors = res.Errors
+	h.render(w, r, "recipient_group_detail.html", PageData{
+		Title: group.Name, Authenticated: true,
+		IsAdmin: auth.IsAdmin(r.Context()), UserName: auth.NameFromContext(r.Context()),
+		Flash: msg,
+		Error: invalidRowsMessage(res.Errors),
+		Data:  data,
+	})
 }
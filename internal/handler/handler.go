@@ -9,6 +9,8 @@ import (
 	"log/slog"
 	"net/http"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/gorilla/csrf"
@@ -16,6 +18,8 @@ import (
 	"github.com/YannKr/downloadonce/internal/config"
 	"github.com/YannKr/downloadonce/internal/diskstat"
 	"github.com/YannKr/downloadonce/internal/email"
+	"github.com/YannKr/downloadonce/internal/events"
+	"github.com/YannKr/downloadonce/internal/geoip"
 	"github.com/YannKr/downloadonce/internal/sse"
 	"github.com/YannKr/downloadonce/internal/webhook"
 )
@@ -27,7 +31,27 @@ type Handler struct {
 	Webhook   *webhook.Dispatcher
 	SSE       *sse.Hub
 	DiskCache *diskstat.Cache
+	GeoIP     *geoip.Reader   // nil when GEOIP_DB_PATH is unset
+	Events    *events.Writer  // nil writes download events synchronously
+	Digest    *email.Digester // nil emails owners on each download
+	Workers   WorkerStatus    // nil skips the worker check of /readyz
+	CORS      *CORSPolicy     // nil keeps /api/v1 same-origin only
 	templates map[string]*template.Template
+
+	// Migrations /readyz expects to be applied; nil skips the check
+	Migrations fs.FS
+
+	// API key ID -> time its last_used_at was last written (see touchAPIKey)
+	apiKeyTouched sync.Map
+
+	// One slot per CSV export allowed to run at once (Cfg.ExportConcurrency)
+	exportSlots chan struct{}
+	// One slot per campaign preview allowed to render at once
+	previewSlots chan struct{}
+
+	// Kept for Cfg.DevMode, which re-parses a page on every render.
+	templateFS fs.FS
+	funcMap    template.FuncMap
 }
 
 func New(database *sql.DB, cfg *config.Config, templateFS fs.FS, mailer *email.Mailer, webhookDispatcher *webhook.Dispatcher, sseHub *sse.Hub) *Handler {
@@ -50,18 +74,10 @@ func New(database *sql.DB, cfg *config.Config, templateFS fs.FS, mailer *email.M
 			}
 			return t.Format("2006-01-02 15:04 UTC")
 		},
-		"formatBytes": func(b int64) string {
-			switch {
-			case b >= 1<<30:
-				return fmt.Sprintf("%.1f GB", float64(b)/float64(1<<30))
-			case b >= 1<<20:
-				return fmt.Sprintf("%.1f MB", float64(b)/float64(1<<20))
-			case b >= 1<<10:
-				return fmt.Sprintf("%.1f KB", float64(b)/float64(1<<10))
-			default:
-				return fmt.Sprintf("%d B", b)
-			}
+		"isPast": func(t *time.Time) bool {
+			return t != nil && !time.Now().Before(*t)
 		},
+		"formatBytes": formatBytes,
 		"formatDuration": func(s *float64) string {
 			if s == nil {
 				return ""
@@ -119,16 +135,15 @@ func New(database *sql.DB, cfg *config.Config, templateFS fs.FS, mailer *email.M
 				class += " badge-gray"
 			case "PENDING":
 				class += " badge-blue"
+			case "PENDING_APPROVAL":
+				class += " badge-yellow"
+			case "SCHEDULED":
+				class += " badge-blue"
 			}
 			return template.HTML(fmt.Sprintf(`<span class="%s">%s</span>`, class, state))
 		},
 	}
 
-	// Parse layout template as the base
-	layoutTmpl := template.Must(
-		template.New("layout.html").Funcs(funcMap).ParseFS(templateFS, "layout.html"),
-	)
-
 	// Build per-page template sets: clone layout + parse page
 	templates := make(map[string]*template.Template)
 	entries, err := fs.ReadDir(templateFS, ".")
@@ -140,20 +155,33 @@ func New(database *sql.DB, cfg *config.Config, templateFS fs.FS, mailer *email.M
 		if name == "layout.html" || e.IsDir() {
 			continue
 		}
-		t := template.Must(template.Must(layoutTmpl.Clone()).ParseFS(templateFS, name))
-		templates[name] = t
+		templates[name] = template.Must(parsePage(templateFS, funcMap, name))
 	}
 
 	return &Handler{
-		DB:        database,
-		Cfg:       cfg,
-		Mailer:    mailer,
-		Webhook:   webhookDispatcher,
-		SSE:       sseHub,
-		templates: templates,
+		DB:         database,
+		Cfg:        cfg,
+		Mailer:     mailer,
+		Webhook:    webhookDispatcher,
+		SSE:        sseHub,
+		templateFS: templateFS,
+		funcMap:    funcMap,
+		templates:  templates,
+
+		exportSlots:  make(chan struct{}, max(cfg.ExportConcurrency, 1)),
+		previewSlots: make(chan struct{}, maxConcurrentPreviews),
 	}
 }
 
+// parsePage parses layout.html and then the named page into one template set.
+func parsePage(templateFS fs.FS, funcMap template.FuncMap, name string) (*template.Template, error) {
+	t, err := template.New("layout.html").Funcs(funcMap).ParseFS(templateFS, "layout.html")
+	if err != nil {
+		return nil, err
+	}
+	return t.ParseFS(templateFS, name)
+}
+
 type PageData struct {
 	Title         string
 	Authenticated bool
@@ -169,9 +197,23 @@ type PageData struct {
 }
 
 func (h *Handler) render(w http.ResponseWriter, r *http.Request, name string, data PageData) {
+	h.renderStatus(w, r, http.StatusOK, name, data)
+}
+
+// renderStatus renders a page template with the given HTTP status.
+func (h *Handler) renderStatus(w http.ResponseWriter, r *http.Request, status int, name string, data PageData) {
 	t, ok := h.templates[name]
+	if h.Cfg.DevMode {
+		fresh, err := parsePage(h.templateFS, h.funcMap, name)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "reparse template", "name", name, "error", err)
+			http.Error(w, "Template error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		t, ok = fresh, true
+	}
 	if !ok {
-		slog.Error("template not found", "name", name)
+		slog.ErrorContext(r.Context(), "template not found", "name", name)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
@@ -179,7 +221,7 @@ func (h *Handler) render(w http.ResponseWriter, r *http.Request, name string, da
 		data.CSRFField = csrf.TemplateField(r)
 		data.CSRFToken = csrf.Token(r)
 		if data.Flash == "" {
-			data.Flash = getFlash(w, r)
+			data.Flash = h.getFlash(w, r)
 		}
 	}
 	if h.DiskCache != nil && data.IsAdmin {
@@ -202,8 +244,11 @@ func (h *Handler) render(w http.ResponseWriter, r *http.Request, name string, da
 		}
 	}
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if status != http.StatusOK {
+		w.WriteHeader(status)
+	}
 	if err := t.ExecuteTemplate(w, "layout.html", data); err != nil {
-		slog.Error("render template", "name", name, "error", err)
+		slog.ErrorContext(r.Context(), "render template", "name", name, "error", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 	}
 }
@@ -218,6 +263,20 @@ func (h *Handler) renderAuth(w http.ResponseWriter, r *http.Request, name, title
 	})
 }
 
+// formatBytes renders a byte count for display, e.g. "1.5 GB".
+func formatBytes(b int64) string {
+	switch {
+	case b >= 1<<30:
+		return fmt.Sprintf("%.1f GB", float64(b)/float64(1<<30))
+	case b >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(b)/float64(1<<20))
+	case b >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(b)/float64(1<<10))
+	default:
+		return fmt.Sprintf("%d B", b)
+	}
+}
+
 func renderJSON(w http.ResponseWriter, status int, v any) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
@@ -230,11 +289,19 @@ func renderJSONError(w http.ResponseWriter, status int, code, message string) {
 	renderJSON(w, status, map[string]string{"error": message, "code": code})
 }
 
+// Page sizes for paginated API lists: per_page defaults to
+// defaultPerPage and is clamped to maxPerPage.
+const (
+	defaultPerPage = 50
+	maxPerPage     = 200
+)
+
 type paginatedResult struct {
-	Data    any `json:"data"`
-	Total   int `json:"total"`
-	Page    int `json:"page"`
-	PerPage int `json:"per_page"`
+	Data       any `json:"data"`
+	Total      int `json:"total"`
+	Page       int `json:"page"`
+	PerPage    int `json:"per_page"`
+	TotalPages int `json:"total_pages"`
 }
 
 func paginate(r *http.Request) (page, perPage int) {
@@ -244,36 +311,52 @@ func paginate(r *http.Request) (page, perPage int) {
 	}
 	perPage, _ = strconv.Atoi(r.URL.Query().Get("per_page"))
 	if perPage < 1 {
-		perPage = 50
+		perPage = defaultPerPage
 	}
-	if perPage > 200 {
-		perPage = 200
+	if perPage > maxPerPage {
+		perPage = maxPerPage
 	}
 	return
 }
 
-func setFlash(w http.ResponseWriter, message string) {
-	http.SetCookie(w, &http.Cookie{
-		Name:     "downloadonce_flash",
-		Value:    message,
-		Path:     "/",
-		MaxAge:   10,
-		HttpOnly: true,
-	})
-}
+// renderPaginated writes one page of an API list with X-Total-Count and an
+// RFC 5988 Link header (first, prev, next, last) for generic pagination
+// clients. Links keep the request's other query parameters, so filters
+// carry over.
+func (h *Handler) renderPaginated(w http.ResponseWriter, r *http.Request, data any, total, page, perPage int) {
+	totalPages := (total + perPage - 1) / perPage
+	last := max(totalPages, 1)
 
-func getFlash(w http.ResponseWriter, r *http.Request) string {
-	c, err := r.Cookie("downloadonce_flash")
-	if err != nil {
-		return ""
+	link := func(p int, rel string) string {
+		q := r.URL.Query()
+		q.Set("page", strconv.Itoa(p))
+		q.Set("per_page", strconv.Itoa(perPage))
+		return fmt.Sprintf("<%s%s?%s>; rel=\"%s\"", h.Cfg.BaseURL, r.URL.Path, q.Encode(), rel)
+	}
+	links := []string{link(1, "first")}
+	if page > 1 {
+		links = append(links, link(min(page-1, last), "prev"))
 	}
-	// Clear the cookie
-	http.SetCookie(w, &http.Cookie{
-		Name:     "downloadonce_flash",
-		Value:    "",
-		Path:     "/",
-		MaxAge:   -1,
-		HttpOnly: true,
+	if page < totalPages {
+		links = append(links, link(page+1, "next"))
+	}
+	links = append(links, link(last, "last"))
+
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	w.Header().Set("Link", strings.Join(links, ", "))
+	renderJSON(w, http.StatusOK, paginatedResult{
+		Data:       data,
+		Total:      total,
+		Page:       page,
+		PerPage:    perPage,
+		TotalPages: totalPages,
 	})
-	return c.Value
+}
+
+func (h *Handler) setFlash(w http.ResponseWriter, message string) {
+	auth.SetFlashCookie(w, message, h.Cfg.SessionSecret)
+}
+
+func (h *Handler) getFlash(w http.ResponseWriter, r *http.Request) string {
+	return auth.PopFlash(w, r, h.Cfg.SessionSecret)
 }
@@ -0,0 +1,90 @@
+package handler
+
+import (
+	"database/sql"
+	"io/fs"
+	"net/http"
+	"testing"
+
+	downloadonce "github.com/YannKr/downloadonce"
+	"github.com/YannKr/downloadonce/internal/auth"
+	"github.com/YannKr/downloadonce/internal/config"
+	"github.com/YannKr/downloadonce/internal/db"
+	"github.com/YannKr/downloadonce/internal/model"
+)
+
+// newTestHandler returns a Handler backed by a fresh migrated database with
+// the embedded templates loaded.
+func newTestHandler(t *testing.T) *Handler {
+	t.Helper()
+	dataDir := t.TempDir()
+	database, err := db.Open(dataDir)
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	t.Cleanup(func() { database.Close() })
+	if err := db.Migrate(database, downloadonce.MigrationFS); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	templateFS, err := fs.Sub(downloadonce.TemplateFS, "templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{
+		DataDir:       dataDir,
+		BaseURL:       "http://dl.test",
+		SessionSecret: "test-secret-test-secret-test-sec",
+	}
+	return New(database, cfg, templateFS, nil, nil, nil)
+}
+
+// asAccount returns r with the given account attached to its context.
+func asAccount(r *http.Request, accountID, role string) *http.Request {
+	return r.WithContext(auth.ContextWithAccountAndRole(r.Context(), accountID, role, accountID))
+}
+
+// seedAccount inserts an enabled account with the given ID and role.
+func seedAccount(t *testing.T, database *sql.DB, id, role string) {
+	t.Helper()
+	err := db.CreateAccount(database, &model.Account{
+		ID: id, Email: id + "@example.com", Name: id, PasswordHash: "x", Role: role, Enabled: true,
+	})
+	if err != nil {
+		t.Fatalf("seed account: %v", err)
+	}
+}
+
+// seedCampaign inserts an image asset, a campaign in the given state and one
+// token per recipient ID (recipients are created as needed), all owned by
+// accountID. It returns the token IDs in recipient order.
+func seedCampaign(t *testing.T, database *sql.DB, accountID, campaignID, state string, recipientIDs ...string) []string {
+	t.Helper()
+	must := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	assetID := campaignID + "-asset"
+	must(db.CreateAsset(database, &model.Asset{
+		ID: assetID, AccountID: accountID, OriginalName: "in.png", AssetType: "image",
+		OriginalPath: "originals/" + assetID + ".png", FileSize: 1024, SHA256: "00", MimeType: "image/png",
+	}))
+	must(db.CreateCampaign(database, &model.Campaign{
+		ID: campaignID, AccountID: accountID, AssetID: assetID, Name: campaignID, InvisibleWM: true, State: state,
+	}))
+	var tokenIDs []string
+	for i, rid := range recipientIDs {
+		if rec, _ := db.GetRecipient(database, rid); rec == nil {
+			must(db.CreateRecipient(database, &model.Recipient{
+				ID: rid, AccountID: accountID, Name: rid, Email: rid + "@example.com",
+			}))
+		}
+		tokenID := campaignID + "-tok-" + string(rune('a'+i))
+		must(db.CreateToken(database, &model.DownloadToken{
+			ID: tokenID, CampaignID: campaignID, RecipientID: rid, State: "PENDING",
+		}))
+		tokenIDs = append(tokenIDs, tokenID)
+	}
+	return tokenIDs
+}
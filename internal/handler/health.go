@@ -0,0 +1,103 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/YannKr/downloadonce/internal/db"
+)
+
+// WorkerStatus reports on the background worker pool; *worker.Pool
+// implements it.
+type WorkerStatus interface {
+	Running() int
+	DiskPaused() bool
+}
+
+// readyTimeout bounds the database checks of one readiness probe.
+const readyTimeout = 2 * time.Second
+
+type healthResponse struct {
+	Status  string `json:"status"`
+	Version string `json:"version"`
+}
+
+type readyResponse struct {
+	Status  string            `json:"status"` // "ready" or "not_ready"
+	Version string            `json:"version"`
+	Checks  map[string]string `json:"checks"` // check name -> "ok" or why it failed
+	Workers *readyWorkers     `json:"workers,omitempty"`
+}
+
+type readyWorkers struct {
+	Running    int  `json:"running"`
+	Configured int  `json:"configured"`
+	DiskPaused bool `json:"disk_paused"`
+}
+
+// Healthz — GET /healthz
+//
+// Liveness probe: answers 200 while the process can serve requests.
+func (h *Handler) Healthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	renderJSON(w, http.StatusOK, healthResponse{Status: "ok", Version: h.Cfg.Version})
+}
+
+// Readyz — GET /readyz
+//
+// Readiness probe: checks that the database answers, that every migration
+// has been applied and that the worker pool is running. Answers 503 when any
+// check fails. Disk-paused workers are reported but still count as ready.
+func (h *Handler) Readyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readyTimeout)
+	defer cancel()
+
+	res := readyResponse{Status: "ready", Version: h.Cfg.Version, Checks: map[string]string{}}
+	fail := func(check, reason string) {
+		res.Status = "not_ready"
+		res.Checks[check] = reason
+	}
+
+	if err := h.DB.PingContext(ctx); err != nil {
+		slog.WarnContext(r.Context(), "readiness: database ping", "error", err)
+		fail("database", "unreachable")
+	} else {
+		res.Checks["database"] = "ok"
+	}
+
+	if h.Migrations != nil {
+		pending, err := db.PendingMigrations(h.DB, h.Migrations)
+		switch {
+		case err != nil:
+			slog.WarnContext(r.Context(), "readiness: migrations", "error", err)
+			fail("migrations", "unknown")
+		case len(pending) > 0:
+			fail("migrations", fmt.Sprintf("%d pending", len(pending)))
+		default:
+			res.Checks["migrations"] = "ok"
+		}
+	}
+
+	if h.Workers != nil {
+		res.Workers = &readyWorkers{
+			Running:    h.Workers.Running(),
+			Configured: h.Cfg.WorkerCount,
+			DiskPaused: h.Workers.DiskPaused(),
+		}
+		if res.Workers.Running < res.Workers.Configured {
+			fail("workers", fmt.Sprintf("%d of %d running", res.Workers.Running, res.Workers.Configured))
+		} else {
+			res.Checks["workers"] = "ok"
+		}
+	}
+
+	status := http.StatusOK
+	if res.Status != "ready" {
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	renderJSON(w, status, res)
+}
@@ -0,0 +1,75 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+
+	downloadonce "github.com/YannKr/downloadonce"
+)
+
+type fakeWorkers struct {
+	running int
+	paused  bool
+}
+
+func (f fakeWorkers) Running() int     { return f.running }
+func (f fakeWorkers) DiskPaused() bool { return f.paused }
+
+func TestProbes(t *testing.T) {
+	h := newTestHandler(t)
+	h.Cfg.Version = "v1.2.3"
+	h.Cfg.WorkerCount = 2
+	h.Migrations = downloadonce.MigrationFS
+	h.Workers = fakeWorkers{running: 2, paused: true}
+	router := h.Routes(fstest.MapFS{}, NewRateLimiter(100, 100))
+
+	probe := func(path string) (int, readyResponse) {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		var res readyResponse
+		if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+			t.Fatalf("%s: decode: %v", path, err)
+		}
+		return rec.Code, res
+	}
+
+	// No account exists yet: the probes must not redirect to /setup.
+	if code, res := probe("/healthz"); code != http.StatusOK || res.Status != "ok" || res.Version != "v1.2.3" {
+		t.Errorf("healthz = %d %+v", code, res)
+	}
+	code, res := probe("/readyz")
+	if code != http.StatusOK || res.Status != "ready" {
+		t.Fatalf("readyz = %d %+v", code, res)
+	}
+	for _, check := range []string{"database", "migrations", "workers"} {
+		if res.Checks[check] != "ok" {
+			t.Errorf("check %s = %q", check, res.Checks[check])
+		}
+	}
+	if res.Workers == nil || res.Workers.Running != 2 || !res.Workers.DiskPaused {
+		t.Errorf("workers = %+v", res.Workers)
+	}
+
+	h.Workers = fakeWorkers{running: 1}
+	if code, res := probe("/readyz"); code != http.StatusServiceUnavailable || res.Checks["workers"] != "1 of 2 running" {
+		t.Errorf("stopped worker: %d %+v", code, res)
+	}
+	h.Workers = nil
+
+	h.Migrations = fstest.MapFS{"migrations/999_future.sql": {Data: []byte("SELECT 1;")}}
+	if code, res := probe("/readyz"); code != http.StatusServiceUnavailable || res.Checks["migrations"] != "1 pending" {
+		t.Errorf("pending migration: %d %+v", code, res)
+	}
+	h.Migrations = nil
+
+	h.DB.Close()
+	if code, res := probe("/readyz"); code != http.StatusServiceUnavailable || res.Checks["database"] != "unreachable" {
+		t.Errorf("closed database: %d %+v", code, res)
+	}
+	if code, _ := probe("/healthz"); code != http.StatusOK {
+		t.Errorf("healthz with closed database = %d", code)
+	}
+}
@@ -0,0 +1,203 @@
+package handler
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/YannKr/downloadonce/internal/auth"
+	"github.com/YannKr/downloadonce/internal/db"
+	"github.com/YannKr/downloadonce/internal/model"
+)
+
+// importRow is one parsed recipient row of a bulk import, before validation.
+type importRow struct {
+	Line             int
+	Name, Email, Org string
+}
+
+// importRowError is a rejected import row and why it was rejected.
+type importRowError struct {
+	Line   int    `json:"line"`
+	Reason string `json:"reason"`
+}
+
+// importResult is the outcome of a bulk recipient import. Skipped counts
+// valid rows whose email already belongs to a recipient.
+type importResult struct {
+	Created int              `json:"created"`
+	Skipped int              `json:"skipped"`
+	Errors  []importRowError `json:"errors"`
+}
+
+// parseBulkRecipients reads the recipients page textarea: one
+// "Name, Email, Org" per line, the org optional and free to contain commas.
+func parseBulkRecipients(text string) (rows []importRow, errs []importRowError) {
+	for i, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		parts := strings.SplitN(line, ",", 3)
+		if len(parts) < 2 {
+			errs = append(errs, importRowError{i + 1, "expected Name, Email and an optional Org"})
+			continue
+		}
+		row := importRow{Line: i + 1, Name: strings.TrimSpace(parts[0]), Email: strings.TrimSpace(parts[1])}
+		if len(parts) == 3 {
+			row.Org = strings.TrimSpace(parts[2])
+		}
+		rows = append(rows, row)
+	}
+	return rows, errs
+}
+
+// parseRecipientCSV reads Name, Email, Organization columns from a CSV file,
+// skipping an optional header row. Malformed rows are reported in errs; err is
+// set only when r itself fails.
+func parseRecipientCSV(r io.Reader) (rows []importRow, errs []importRowError, err error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = -1
+
+	first := true
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var perr *csv.ParseError
+			if !errors.As(err, &perr) {
+				return nil, nil, err // the reader failed, not the CSV
+			}
+			errs = append(errs, importRowError{perr.StartLine, "malformed CSV: " + perr.Err.Error()})
+			continue
+		}
+		line, _ := reader.FieldPos(0)
+		if first {
+			first = false
+			if len(record) >= 2 && strings.EqualFold(strings.TrimSpace(record[0]), "name") &&
+				strings.EqualFold(strings.TrimSpace(record[1]), "email") {
+				continue
+			}
+		}
+		if len(record) < 2 {
+			errs = append(errs, importRowError{line, "expected Name, Email and an optional Organization"})
+			continue
+		}
+		row := importRow{Line: line, Name: strings.TrimSpace(record[0]), Email: strings.TrimSpace(record[1])}
+		if len(record) >= 3 {
+			row.Org = strings.TrimSpace(record[2])
+		}
+		rows = append(rows, row)
+	}
+	return rows, errs, nil
+}
+
+// importRecipients validates rows, creates the recipients that do not exist
+// yet and reports every rejected row alongside the parse errors in errs. If
+// fn is not nil it is called with the recipient of each accepted row.
+func (h *Handler) importRecipients(accountID string, rows []importRow, errs []importRowError, fn func(rec *model.Recipient)) importResult {
+	res := importResult{Errors: errs}
+	seen := make(map[string]int) // normalized email -> first line
+	for _, row := range rows {
+		reject := func(reason string) {
+			res.Errors = append(res.Errors, importRowError{row.Line, reason})
+		}
+		switch {
+		case row.Name == "":
+			reject("missing name")
+			continue
+		case row.Email == "":
+			reject("missing email")
+			continue
+		}
+		email, err := h.validateEmail(row.Email)
+		if err != nil {
+			reject(err.Error())
+			continue
+		}
+		if first, ok := seen[email]; ok {
+			reject(fmt.Sprintf("duplicate of line %d", first))
+			continue
+		}
+		seen[email] = row.Line
+
+		rec, err := db.GetOrCreateRecipientByEmail(h.DB, accountID, row.Name, email, row.Org)
+		if err != nil {
+			slog.Error("import: look up recipient", "error", err)
+			reject("could not be saved")
+			continue
+		}
+		if rec.ID != "" {
+			res.Skipped++
+		} else {
+			rec.ID = uuid.New().String()
+			if err := db.CreateRecipient(h.DB, rec); err != nil {
+				slog.Error("import: create recipient", "error", err)
+				reject("could not be saved")
+				continue
+			}
+			res.Created++
+		}
+		if fn != nil {
+			fn(rec)
+		}
+	}
+	sort.SliceStable(res.Errors, func(i, j int) bool { return res.Errors[i].Line < res.Errors[j].Line })
+	return res
+}
+
+// invalidRowsMessage summarizes rejected import rows, listing the first few.
+func invalidRowsMessage(rows []importRowError) string {
+	const maxListed = 5
+	listed := make([]string, 0, maxListed)
+	for i, e := range rows {
+		if i == maxListed {
+			break
+		}
+		listed = append(listed, fmt.Sprintf("line %d: %s", e.Line, e.Reason))
+	}
+	msg := fmt.Sprintf("%d row(s) rejected: ", len(rows))
+	if len(rows) > maxListed {
+		return msg + strings.Join(listed, "; ") + fmt.Sprintf("; and %d more.", len(rows)-maxListed)
+	}
+	return msg + strings.Join(listed, "; ") + "."
+}
+
+// maxRecipientImportBytes bounds a recipient CSV import.
+const maxRecipientImportBytes = 10 << 20
+
+// APIRecipientImport — POST /api/v1/recipients/import
+//
+// Accepts a CSV body (Name, Email, Organization; header optional) and reports
+// every rejected row with its line number instead of failing the import.
+func (h *Handler) APIRecipientImport(w http.ResponseWriter, r *http.Request) {
+	accountID := auth.AccountFromContext(r.Context())
+
+	rows, errs, err := parseRecipientCSV(http.MaxBytesReader(w, r.Body, maxRecipientImportBytes))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			renderJSONError(w, http.StatusRequestEntityTooLarge, "TOO_LARGE", fmt.Sprintf("import is limited to %d bytes", maxRecipientImportBytes))
+			return
+		}
+		renderJSONError(w, http.StatusBadRequest, "BAD_REQUEST", "could not read CSV body")
+		return
+	}
+
+	res := h.importRecipients(accountID, rows, errs, nil)
+	if res.Errors == nil {
+		res.Errors = []importRowError{}
+	}
+	db.InsertAuditLog(h.DB, accountID, "recipients_imported", "recipient", "",
+		fmt.Sprintf("%d created, %d skipped, %d rejected", res.Created, res.Skipped, len(res.Errors)), r.RemoteAddr)
+	renderJSON(w, http.StatusOK, res)
+}
@@ -0,0 +1,112 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/YannKr/downloadonce/internal/db"
+)
+
+const importCSV = `Name,Email,Organization
+Ann Lee,ann@example.com,Acme
+,nameless@example.com
+Bob,bob@
+Ann Again,ANN@example.com
+just-one-field
+Carl,carl@example.com,"Carl ""The"" Org"
+Dora,"dora@example.com
+Existing,old@example.com
+`
+
+func TestAPIRecipientImportReportsRows(t *testing.T) {
+	h := newTestHandler(t)
+	seedAccount(t, h.DB, "acc", "member")
+	if _, err := h.DB.Exec(`INSERT INTO recipients (id, account_id, name, email) VALUES ('old', 'acc', 'Old', 'old@example.com')`); err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest("POST", "/api/v1/recipients/import", strings.NewReader(importCSV))
+	req.Header.Set("Content-Type", "text/csv")
+	rec := httptest.NewRecorder()
+	h.APIRecipientImport(rec, asAccount(req, "acc", "member"))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	var got importResult
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Created != 2 || got.Skipped != 0 {
+		t.Errorf("created %d, skipped %d; want 2, 0", got.Created, got.Skipped)
+	}
+	// The unterminated quote on line 8 swallows the rest of the file.
+	want := map[int]string{3: "missing name", 4: "not a valid email", 5: "duplicate of line 2", 6: "expected Name, Email", 8: "malformed CSV"}
+	if len(got.Errors) != len(want) {
+		t.Fatalf("errors = %+v, want lines %v", got.Errors, want)
+	}
+	for i, e := range got.Errors {
+		if i > 0 && e.Line < got.Errors[i-1].Line {
+			t.Errorf("errors not in line order: %+v", got.Errors)
+		}
+		if !strings.Contains(e.Reason, want[e.Line]) {
+			t.Errorf("line %d reason = %q, want %q", e.Line, e.Reason, want[e.Line])
+		}
+	}
+	if r, _ := db.GetOrCreateRecipientByEmail(h.DB, "acc", "", "carl@example.com", ""); r.ID == "" || r.Org != `Carl "The" Org` {
+		t.Errorf("carl = %+v", r)
+	}
+
+	// Re-importing an existing recipient is a skip, not an error.
+	req = httptest.NewRequest("POST", "/api/v1/recipients/import", strings.NewReader("Old,old@example.com\n"))
+	rec = httptest.NewRecorder()
+	h.APIRecipientImport(rec, asAccount(req, "acc", "member"))
+	if body := strings.TrimSpace(rec.Body.String()); body != `{"created":0,"skipped":1,"errors":[]}` {
+		t.Errorf("re-import = %s", body)
+	}
+}
+
+func TestGroupImportRendersReport(t *testing.T) {
+	h := newTestHandler(t)
+	seedAccount(t, h.DB, "acc", "member")
+	if err := db.CreateRecipientGroup(h.DB, "grp", "acc", "Press", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	upload := func(csv string) *httptest.ResponseRecorder {
+		var buf bytes.Buffer
+		mw := multipart.NewWriter(&buf)
+		fw, _ := mw.CreateFormFile("file", "people.csv")
+		fw.Write([]byte(csv))
+		mw.Close()
+		req := httptest.NewRequest("POST", "/recipients/groups/grp/import", &buf)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		r := chi.NewRouter()
+		r.Post("/recipients/groups/{id}/import", h.GroupImport)
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, asAccount(req, "acc", "member"))
+		return rec
+	}
+
+	rec := upload("Ann,ann@example.com\nBob,bob@\n")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want the report page", rec.Code)
+	}
+	body := rec.Body.String()
+	if !strings.Contains(body, "Import Report") || !strings.Contains(body, "<td>2</td>") {
+		t.Errorf("report missing from page:\n%s", body)
+	}
+	if members, _ := db.ListGroupMembers(h.DB, "grp", "acc"); len(members) != 1 {
+		t.Errorf("group has %d members, want 1", len(members))
+	}
+
+	// A clean import keeps the redirect.
+	if rec := upload("Cy,cy@example.com\n"); rec.Code != http.StatusSeeOther {
+		t.Errorf("clean import status = %d, want 303", rec.Code)
+	}
+}
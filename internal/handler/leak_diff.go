@@ -0,0 +1,207 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/YannKr/downloadonce/internal/auth"
+	"github.com/YannKr/downloadonce/internal/db"
+	"github.com/YannKr/downloadonce/internal/watermark"
+	"github.com/go-chi/chi/v5"
+)
+
+// leakDiffReport compares the file submitted to a detect job with the
+// original asset of the campaign it matched.
+type leakDiffReport struct {
+	JobID         string                 `json:"job_id"`
+	TokenID       string                 `json:"token_id"`
+	CampaignID    string                 `json:"campaign_id"`
+	AssetID       string                 `json:"asset_id"`
+	OriginalName  string                 `json:"original_name"`
+	OriginalBytes int64                  `json:"original_bytes"`
+	LeakedBytes   int64                  `json:"leaked_bytes"`
+	FileSizeRatio float64                `json:"file_size_ratio"`
+	OriginalType  string                 `json:"original_format"`
+	LeakedType    string                 `json:"leaked_format"`
+	Metrics       *watermark.LeakMetrics `json:"metrics"`
+	Findings      []string               `json:"findings"`
+}
+
+// leakDiffError carries the HTTP status for a diff that can't be produced.
+type leakDiffError struct {
+	status int
+	msg    string
+}
+
+func (e *leakDiffError) Error() string { return e.msg }
+
+// leakDiff builds the report for detect job jobID on behalf of the caller.
+func (h *Handler) leakDiff(r *http.Request, jobID string) (*leakDiffReport, error) {
+	accountID := auth.AccountFromContext(r.Context())
+	isAdmin := auth.IsAdmin(r.Context())
+
+	job, err := db.GetJob(h.DB, jobID)
+	if err != nil {
+		return nil, err
+	}
+	if job == nil || job.JobType != "detect" || (job.AccountID != accountID && !isAdmin) {
+		return nil, &leakDiffError{http.StatusNotFound, "job not found"}
+	}
+	if job.State != "COMPLETED" {
+		return nil, &leakDiffError{http.StatusConflict, "detection has not completed"}
+	}
+	var result struct {
+		Found   bool   `json:"found"`
+		TokenID string `json:"token_id"`
+	}
+	if err := json.Unmarshal([]byte(job.ResultData), &result); err != nil || !result.Found || result.TokenID == "" {
+		return nil, &leakDiffError{http.StatusConflict, "detection did not match a download token"}
+	}
+
+	token, err := db.GetToken(h.DB, result.TokenID)
+	if err != nil {
+		return nil, err
+	}
+	if token == nil {
+		return nil, &leakDiffError{http.StatusGone, "matched token no longer exists"}
+	}
+	campaign, err := db.GetCampaign(h.DB, token.CampaignID)
+	if err != nil {
+		return nil, err
+	}
+	if campaign == nil || (campaign.AccountID != accountID && !isAdmin) {
+		return nil, &leakDiffError{http.StatusNotFound, "matched campaign not found"}
+	}
+	asset, err := db.GetAsset(h.DB, campaign.AssetID)
+	if err != nil {
+		return nil, err
+	}
+	if asset == nil {
+		return nil, &leakDiffError{http.StatusGone, "original asset no longer exists"}
+	}
+	if asset.AssetType != "image" {
+		return nil, &leakDiffError{http.StatusUnprocessableEntity, "leak comparison is only available for images"}
+	}
+
+	originalPath := filepath.Join(h.Cfg.DataDir, asset.OriginalPath)
+	origInfo, err := os.Stat(originalPath)
+	if err != nil {
+		return nil, &leakDiffError{http.StatusGone, "original file is no longer on disk"}
+	}
+	leakInfo, err := os.Stat(job.InputPath)
+	if err != nil {
+		return nil, &leakDiffError{http.StatusGone, "submitted file is no longer on disk"}
+	}
+	if leakInfo.IsDir() {
+		return nil, &leakDiffError{http.StatusUnprocessableEntity, "leak comparison is not available for combined detections"}
+	}
+	metrics, err := watermark.CompareImageFiles(originalPath, job.InputPath)
+	if err != nil {
+		return nil, &leakDiffError{http.StatusUnprocessableEntity, err.Error()}
+	}
+
+	report := &leakDiffReport{
+		JobID:         job.ID,
+		TokenID:       token.ID,
+		CampaignID:    campaign.ID,
+		AssetID:       asset.ID,
+		OriginalName:  asset.OriginalName,
+		OriginalBytes: origInfo.Size(),
+		LeakedBytes:   leakInfo.Size(),
+		OriginalType:  imageFormat(originalPath),
+		LeakedType:    imageFormat(job.InputPath),
+		Metrics:       metrics,
+	}
+	if report.OriginalBytes > 0 {
+		report.FileSizeRatio = float64(report.LeakedBytes) / float64(report.OriginalBytes)
+	}
+	report.Findings = leakFindings(report)
+	return report, nil
+}
+
+// imageFormat names a file's format from its extension, e.g. "JPEG".
+func imageFormat(path string) string {
+	ext := strings.ToUpper(strings.TrimPrefix(filepath.Ext(path), "."))
+	if ext == "JPG" {
+		return "JPEG"
+	}
+	return ext
+}
+
+// leakFindings turns the raw numbers into short hints about the leak path.
+// Thresholds are rough: a watermarked copy that was simply downloaded and
+// re-shared typically stays above 38 dB PSNR and 0.97 SSIM.
+func leakFindings(r *leakDiffReport) []string {
+	m := r.Metrics
+	var out []string
+	if m.AspectChanged {
+		out = append(out, "Aspect ratio differs from the original: likely cropped, padded or captured as a screenshot.")
+	}
+	if math.Abs(m.Scale-1) > 0.01 {
+		out = append(out, fmt.Sprintf("Resized to %.0f%% of the original width (%d×%d → %d×%d).",
+			m.Scale*100, m.OriginalWidth, m.OriginalHeight, m.LeakedWidth, m.LeakedHeight))
+	}
+	if r.OriginalType != r.LeakedType {
+		out = append(out, fmt.Sprintf("Converted from %s to %s.", r.OriginalType, r.LeakedType))
+	}
+	switch {
+	case m.SSIM < 0.8 || m.PSNR < 25:
+		out = append(out, "Heavily altered: re-encoded at low quality, filtered, or re-photographed.")
+	case m.SSIM < 0.97 || m.PSNR < 38:
+		out = append(out, "Noticeable quality loss: probably recompressed or re-encoded.")
+	default:
+		out = append(out, "Close to the distributed copy: little or no recompression.")
+	}
+	if r.FileSizeRatio > 0 && r.FileSizeRatio < 0.5 {
+		out = append(out, fmt.Sprintf("File is %.0f%% of the original size.", r.FileSizeRatio*100))
+	}
+	return out
+}
+
+// DetectDiff renders the leak comparison for a detect job.
+func (h *Handler) DetectDiff(w http.ResponseWriter, r *http.Request) {
+	report, err := h.leakDiff(r, chi.URLParam(r, "id"))
+	if err != nil {
+		var de *leakDiffError
+		if errors.As(err, &de) {
+			http.Error(w, de.msg, de.status)
+			return
+		}
+		slog.ErrorContext(r.Context(), "leak diff", "error", err)
+		http.Error(w, "Internal error", 500)
+		return
+	}
+	h.renderAuth(w, r, "detect_diff.html", "Leak Comparison", report)
+}
+
+// APIDetectDiff - GET /api/v1/detect/{jobID}/diff
+func (h *Handler) APIDetectDiff(w http.ResponseWriter, r *http.Request) {
+	report, err := h.leakDiff(r, chi.URLParam(r, "jobID"))
+	if err != nil {
+		var de *leakDiffError
+		if errors.As(err, &de) {
+			code := "NOT_FOUND"
+			switch de.status {
+			case http.StatusConflict:
+				code = "CONFLICT"
+			case http.StatusGone:
+				code = "GONE"
+			case http.StatusUnprocessableEntity:
+				code = "UNPROCESSABLE"
+			}
+			renderJSONError(w, de.status, code, de.msg)
+			return
+		}
+		slog.ErrorContext(r.Context(), "api leak diff", "error", err)
+		renderJSONError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "failed to compare files")
+		return
+	}
+	renderJSON(w, http.StatusOK, report)
+}
@@ -1,42 +1,60 @@
 package handler
 
 import (
+	"errors"
+	"log/slog"
+	"math"
 	"net/http"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/google/uuid"
+	"golang.org/x/time/rate"
 	"github.com/YannKr/downloadonce/internal/auth"
 	"github.com/YannKr/downloadonce/internal/db"
+	"github.com/YannKr/downloadonce/internal/logging"
+	"github.com/YannKr/downloadonce/internal/model"
 )
 
 func (h *Handler) RequireAuth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		var accountID string
+		var accountID, keyID string
+		var scopes []string
 
 		// Check API key first (Authorization: Bearer do_...)
 		if authHeader := r.Header.Get("Authorization"); strings.HasPrefix(authHeader, "Bearer do_") {
 			apiKey := strings.TrimPrefix(authHeader, "Bearer ")
-			id, ok := h.validateAPIKey(apiKey)
-			if !ok {
+			key, err := h.validateAPIKey(apiKey)
+			if errors.Is(err, errAPIKeyExpired) {
+				http.Error(w, "API key has expired", http.StatusUnauthorized)
+				return
+			}
+			if err != nil {
 				http.Error(w, "Invalid API key", http.StatusUnauthorized)
 				return
 			}
-			accountID = id
+			accountID = key.AccountID
+			keyID = key.ID
+			scopes = auth.ParseScopes(key.Scopes)
 		} else {
 			// Fall back to session cookie
 			sessionID, ok := auth.GetSessionID(r, h.Cfg.SessionSecret)
 			if !ok {
-				http.Redirect(w, r, "/login", http.StatusSeeOther)
+				redirectToLogin(w, r)
 				return
 			}
 			session, err := db.GetSession(h.DB, sessionID)
 			if err != nil || session == nil || session.ExpiresAt.Before(time.Now()) {
 				auth.ClearSessionCookie(w)
-				http.Redirect(w, r, "/login", http.StatusSeeOther)
+				redirectToLogin(w, r)
 				return
 			}
 			accountID = session.AccountID
+			if ip := realIP(r); ip != session.IP {
+				db.UpdateSessionIP(h.DB, session.ID, ip)
+			}
 		}
 
 		// Load account to get role and enabled status
@@ -54,13 +72,24 @@ func (h *Handler) RequireAuth(next http.Handler) http.Handler {
 		}
 
 		ctx := auth.ContextWithAccountAndRole(r.Context(), accountID, account.Role, account.Name)
+		if scopes != nil {
+			ctx = auth.ContextWithScopes(ctx, scopes)
+			ctx = auth.ContextWithAPIKey(ctx, keyID)
+			// Web routes don't declare scopes; treat anything but a read as a write.
+			if r.Method != http.MethodGet && r.Method != http.MethodHead && !auth.HasScope(ctx, auth.ScopeWrite) {
+				http.Error(w, "API key lacks the write scope", http.StatusForbidden)
+				return
+			}
+		}
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+// RequireAdmin allows admin accounts only. An API key used on the web admin
+// pages must also carry the admin scope, as on /api/v1/admin.
 func (h *Handler) RequireAdmin(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if !auth.IsAdmin(r.Context()) {
+		if !auth.IsAdmin(r.Context()) || !auth.HasScope(r.Context(), auth.ScopeAdmin) {
 			http.Error(w, "Forbidden", http.StatusForbidden)
 			return
 		}
@@ -68,28 +97,50 @@ func (h *Handler) RequireAdmin(next http.Handler) http.Handler {
 	})
 }
 
-func (h *Handler) validateAPIKey(key string) (string, bool) {
+var (
+	errInvalidAPIKey = errors.New("invalid or missing API key")
+	errAPIKeyExpired = errors.New("API key has expired")
+)
+
+func (h *Handler) validateAPIKey(key string) (*model.APIKey, error) {
 	// Key format: do_<64 hex chars>
 	// Prefix for DB lookup: first 8 chars after "do_"
 	withoutPrefix := strings.TrimPrefix(key, "do_")
 	if len(withoutPrefix) < 8 {
-		return "", false
+		return nil, errInvalidAPIKey
 	}
 	prefix := withoutPrefix[:8]
 
 	apiKey, err := db.GetAPIKeyByPrefix(h.DB, prefix)
 	if err != nil || apiKey == nil {
-		return "", false
+		return nil, errInvalidAPIKey
 	}
 
 	if !auth.CheckPassword(apiKey.KeyHash, key) {
-		return "", false
+		return nil, errInvalidAPIKey
+	}
+	if apiKey.ExpiresAt != nil && !time.Now().Before(*apiKey.ExpiresAt) {
+		return nil, errAPIKeyExpired
 	}
 
-	// Update last used timestamp
-	go db.TouchAPIKeyUsed(h.DB, apiKey.ID)
+	h.touchAPIKey(apiKey.ID)
 
-	return apiKey.AccountID, true
+	return apiKey, nil
+}
+
+// apiKeyTouchInterval throttles last_used_at writes so busy keys don't turn
+// every API call into a database write.
+const apiKeyTouchInterval = time.Minute
+
+// touchAPIKey records that key id was used, at most once per
+// apiKeyTouchInterval per key.
+func (h *Handler) touchAPIKey(id string) {
+	now := time.Now()
+	if last, ok := h.apiKeyTouched.Load(id); ok && now.Sub(last.(time.Time)) < apiKeyTouchInterval {
+		return
+	}
+	h.apiKeyTouched.Store(id, now)
+	go db.TouchAPIKeyUsed(h.DB, id)
 }
 
 // requireAPIAuth validates Bearer API keys and returns JSON errors (not redirects).
@@ -101,46 +152,105 @@ func (h *Handler) requireAPIAuth(next http.Handler) http.Handler {
 			return
 		}
 		apiKey := strings.TrimPrefix(authHeader, "Bearer ")
-		accountID, ok := h.validateAPIKey(apiKey)
-		if !ok {
+		key, err := h.validateAPIKey(apiKey)
+		if errors.Is(err, errAPIKeyExpired) {
+			renderJSONError(w, http.StatusUnauthorized, "API_KEY_EXPIRED", err.Error())
+			return
+		}
+		if err != nil {
 			renderJSONError(w, http.StatusUnauthorized, "UNAUTHORIZED", "invalid or missing API key")
 			return
 		}
+		accountID := key.AccountID
 		account, err := db.GetAccountByID(h.DB, accountID)
 		if err != nil || account == nil || !account.Enabled {
 			renderJSONError(w, http.StatusUnauthorized, "UNAUTHORIZED", "account is disabled or not found")
 			return
 		}
 		ctx := auth.ContextWithAccountAndRole(r.Context(), accountID, account.Role, account.Name)
+		ctx = auth.ContextWithScopes(ctx, auth.ParseScopes(key.Scopes))
+		ctx = auth.ContextWithAPIKey(ctx, key.ID)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
-// apiRateLimit returns a middleware that rate-limits by IP and sets X-RateLimit-* headers.
+// requireAPIAdmin rejects non-admin API callers with a JSON 403.
+func (h *Handler) requireAPIAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !auth.IsAdmin(r.Context()) {
+			renderJSONError(w, http.StatusForbidden, "FORBIDDEN", "admin access required")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// apiRateLimit returns a middleware that rate-limits API calls and sets
+// X-RateLimit-* headers. It runs after requireAPIAuth so each API key gets
+// its own bucket: one busy integration cannot throttle the others, and keys
+// used from behind a shared NAT are not limited together.
 func (h *Handler) apiRateLimit(rl *RateLimiter) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			ip := r.RemoteAddr
-			if fwd := r.Header.Get("X-Real-Ip"); fwd != "" {
-				ip = fwd
-			}
-			limiter := rl.Get(ip)
-			tokens := limiter.Tokens()
-			burst := rl.Burst()
+			limiter := rl.Get("key:" + auth.APIKeyFromContext(r.Context()))
+			w.Header().Set("X-RateLimit-Limit", strconv.Itoa(rl.Burst()))
 
-			w.Header().Set("X-RateLimit-Limit", strconv.Itoa(burst))
-			w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(int(tokens)))
-
-			if !limiter.Allow() {
-				w.Header().Set("Retry-After", "1")
-				renderJSONError(w, http.StatusTooManyRequests, "RATE_LIMITED", "rate limit exceeded")
+			now := time.Now()
+			if delay := reserveDelay(limiter, now); delay > 0 {
+				w.Header().Set("X-RateLimit-Remaining", "0")
+				renderRateLimited(w, delay)
 				return
 			}
+			remaining := int(limiter.TokensAt(now))
+			if remaining < 0 {
+				remaining = 0
+			}
+			w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
 			next.ServeHTTP(w, r)
 		})
 	}
 }
 
+// limitAPIAuthFailures runs before requireAPIAuth and throttles, per client
+// IP, requests it rejects with 401. Each costs a key lookup and a bcrypt
+// comparison, and the per-key buckets of apiRateLimit never see them. Only
+// failures draw from the bucket, so valid keys sharing an IP cost nothing;
+// once it is empty, though, every request from that IP waits, since telling a
+// valid key apart needs the very comparison being limited.
+func (h *Handler) limitAPIAuthFailures(rl *RateLimiter) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			limiter := rl.Get("ip:" + realIP(r))
+			now := time.Now()
+			if limiter.TokensAt(now) < 1 {
+				renderRateLimited(w, reserveDelay(limiter, now))
+				return
+			}
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			next.ServeHTTP(ww, r)
+			if ww.Status() == http.StatusUnauthorized {
+				limiter.AllowN(now, 1)
+			}
+		})
+	}
+}
+
+// reserveDelay takes a token from limiter if one is available at now and
+// returns 0, or returns how long until one will be, leaving the bucket as is.
+func reserveDelay(limiter *rate.Limiter, now time.Time) time.Duration {
+	res := limiter.ReserveN(now, 1)
+	delay := res.DelayFrom(now)
+	if delay > 0 {
+		res.CancelAt(now)
+	}
+	return delay
+}
+
+func renderRateLimited(w http.ResponseWriter, delay time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(max(int(math.Ceil(delay.Seconds())), 1)))
+	renderJSONError(w, http.StatusTooManyRequests, "RATE_LIMITED", "rate limit exceeded")
+}
+
 func (h *Handler) RequireSetup(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.URL.Path == "/setup" || r.URL.Path == "/static/style.css" {
@@ -155,3 +265,54 @@ func (h *Handler) RequireSetup(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// requestIDHeader carries the request ID both ways: an ID set by a proxy in
+// front of the app is reused when it looks sane, otherwise one is generated.
+const requestIDHeader = "X-Request-Id"
+
+// RequestID tags each request with an ID, returned in the X-Request-Id
+// response header and stored in the context so log lines and queued jobs
+// can be traced back to the request (see logging.RequestID).
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = uuid.New().String()
+		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(logging.WithRequestID(r.Context(), id)))
+	})
+}
+
+// validRequestID accepts up to 64 letters, digits, '-', '_' and '.', so a
+// client-supplied ID cannot forge log fields.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_', c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// logRequests logs each request through slog once it has been served.
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		start := time.Now()
+		defer func() {
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			slog.InfoContext(r.Context(), "request", "method", r.Method, "path", r.URL.Path,
+				"status", status, "bytes", ww.BytesWritten(), "duration", time.Since(start), "ip", realIP(r))
+		}()
+		next.ServeHTTP(ww, r)
+	})
+}
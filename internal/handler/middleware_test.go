@@ -0,0 +1,301 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/YannKr/downloadonce/internal/auth"
+	"github.com/YannKr/downloadonce/internal/db"
+	"github.com/YannKr/downloadonce/internal/logging"
+	"github.com/YannKr/downloadonce/internal/model"
+)
+
+func seedAPIKey(t *testing.T, h *Handler, accountID, prefix, scopes string) string {
+	t.Helper()
+	full := "do_" + prefix + "0000000000000000000000000000000000000000000000000000000"
+	hash, err := auth.HashPassword(full)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreateAPIKey(h.DB, &model.APIKey{
+		ID: "key-" + prefix, AccountID: accountID, Name: prefix,
+		KeyPrefix: prefix, KeyHash: hash, Scopes: scopes,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return full
+}
+
+func TestAPIKeyScopes(t *testing.T) {
+	h := newTestHandler(t)
+	seedAccount(t, h.DB, "admin", "admin")
+	readKey := seedAPIKey(t, h, "admin", "aaaaaaaa", "read")
+	writeKey := seedAPIKey(t, h, "admin", "bbbbbbbb", "write")
+	adminKey := seedAPIKey(t, h, "admin", "cccccccc", "admin")
+	router := h.Routes(fstest.MapFS{}, NewRateLimiter(100, 100))
+
+	cases := []struct {
+		name, key, method, path string
+		want                    int
+	}{
+		{"read key lists assets", readKey, "GET", "/api/v1/assets", http.StatusOK},
+		{"read key cannot delete", readKey, "DELETE", "/api/v1/assets/missing", http.StatusForbidden},
+		{"read key cannot publish", readKey, "POST", "/api/v1/campaigns/missing/publish", http.StatusForbidden},
+		{"read key cannot post web forms", readKey, "POST", "/settings/apikeys", http.StatusForbidden},
+		{"write key can delete", writeKey, "DELETE", "/api/v1/assets/missing", http.StatusNotFound},
+		{"write key cannot use admin API", writeKey, "GET", "/api/v1/admin/watermark-index/export", http.StatusForbidden},
+		{"admin key uses admin API", adminKey, "GET", "/api/v1/admin/watermark-index/export", http.StatusOK},
+		{"write key cannot use admin pages", writeKey, "POST", "/admin/users/admin/promote", http.StatusForbidden},
+		{"read key cannot export audit log", readKey, "GET", "/admin/audit/export", http.StatusForbidden},
+		{"read key cannot search as admin", readKey, "GET", "/admin/search?q=ab", http.StatusForbidden},
+		{"admin key uses admin pages", adminKey, "GET", "/admin/search?q=ab", http.StatusOK},
+		{"write key cannot create keys", writeKey, "POST", "/settings/apikeys?scope=admin", http.StatusForbidden},
+		{"admin key cannot create keys", adminKey, "POST", "/settings/apikeys?scope=read", http.StatusForbidden},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.path, nil)
+			req.Header.Set("Authorization", "Bearer "+tc.key)
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			if rec.Code != tc.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tc.want, rec.Body.String())
+			}
+		})
+	}
+
+	req := httptest.NewRequest("DELETE", "/api/v1/assets/missing", nil)
+	req.Header.Set("Authorization", "Bearer "+readKey)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	var body map[string]string
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatalf("403 body is not JSON: %v", err)
+	}
+	if body["code"] != "INSUFFICIENT_SCOPE" {
+		t.Errorf("code = %q, want INSUFFICIENT_SCOPE", body["code"])
+	}
+}
+
+func TestAPIKeyLastUsedThrottled(t *testing.T) {
+	h := newTestHandler(t)
+	seedAccount(t, h.DB, "acct", "member")
+	key := seedAPIKey(t, h, "acct", "dddddddd", "read")
+	seedAPIKey(t, h, "acct", "eeeeeeee", "write")
+	router := h.Routes(fstest.MapFS{}, NewRateLimiter(100, 100))
+
+	list := func() map[string]any {
+		req := httptest.NewRequest("GET", "/api/v1/keys", nil)
+		req.Header.Set("Authorization", "Bearer "+key)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+		}
+		var body map[string]any
+		json.NewDecoder(rec.Body).Decode(&body)
+		return body
+	}
+
+	body := list()
+	if n := len(body["data"].([]any)); n != 2 {
+		t.Errorf("listed %d keys, want 2", n)
+	}
+	current, _ := body["current"].(map[string]any)
+	if current["id"] != "key-dddddddd" || current["scopes"] != "read" {
+		t.Errorf("current = %v", current)
+	}
+
+	// The first call records a touch; further calls within the interval
+	// must not reset the throttle window.
+	first, ok := h.apiKeyTouched.Load("key-dddddddd")
+	if !ok {
+		t.Fatal("key use was not recorded")
+	}
+	list()
+	if again, _ := h.apiKeyTouched.Load("key-dddddddd"); again != first {
+		t.Error("last_used_at touched again within the throttle interval")
+	}
+	if _, ok := h.apiKeyTouched.Load("key-eeeeeeee"); ok {
+		t.Error("unused key was touched")
+	}
+}
+
+func TestAPIKeyExpiry(t *testing.T) {
+	h := newTestHandler(t)
+	seedAccount(t, h.DB, "acct", "member")
+	neverKey := seedAPIKey(t, h, "acct", "ffffffff", "write")
+	expiredKey := seedAPIKey(t, h, "acct", "99999999", "write")
+	liveKey := seedAPIKey(t, h, "acct", "88888888", "write")
+	expire := func(id string, at time.Time) {
+		if _, err := h.DB.Exec(`UPDATE api_keys SET expires_at = ? WHERE id = ?`, at.UTC().Format(time.RFC3339), id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expire("key-99999999", time.Now().Add(-time.Hour))
+	expire("key-88888888", time.Now().Add(time.Hour))
+	router := h.Routes(fstest.MapFS{}, NewRateLimiter(100, 100))
+
+	call := func(key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api/v1/assets", nil)
+		req.Header.Set("Authorization", "Bearer "+key)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	if rec := call(neverKey); rec.Code != http.StatusOK {
+		t.Errorf("non-expiring key: status = %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := call(liveKey); rec.Code != http.StatusOK {
+		t.Errorf("key expiring later: status = %d: %s", rec.Code, rec.Body.String())
+	}
+	rec := call(expiredKey)
+	if rec.Code != http.StatusUnauthorized || !strings.Contains(rec.Body.String(), "API_KEY_EXPIRED") {
+		t.Errorf("expired key: status = %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// Cleanup removes keys once they have been expired past the retention.
+	if n, err := db.PruneExpiredAPIKeys(h.DB, time.Now().Add(-2*time.Hour)); err != nil || n != 0 {
+		t.Errorf("prune within retention: n=%d err=%v", n, err)
+	}
+	if n, err := db.PruneExpiredAPIKeys(h.DB, time.Now()); err != nil || n != 1 {
+		t.Errorf("prune past retention: n=%d err=%v, want 1", n, err)
+	}
+	keys, _ := db.ListAPIKeys(h.DB, "acct")
+	if len(keys) != 2 {
+		t.Errorf("%d keys left after prune, want 2", len(keys))
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	var seen string
+	handler := RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = logging.RequestID(r.Context())
+	}))
+	serve := func(incoming string) string {
+		req := httptest.NewRequest("GET", "/", nil)
+		if incoming != "" {
+			req.Header.Set("X-Request-Id", incoming)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if got := rec.Header().Get("X-Request-Id"); got != seen {
+			t.Errorf("header %q != context %q", got, seen)
+		}
+		return seen
+	}
+
+	generated := serve("")
+	if len(generated) != 36 {
+		t.Errorf("generated ID = %q, want a UUID", generated)
+	}
+	if serve("") == generated {
+		t.Error("IDs repeat across requests")
+	}
+	if got := serve("lb-7f3a.01"); got != "lb-7f3a.01" {
+		t.Errorf("proxy ID not reused: %q", got)
+	}
+	for _, bad := range []string{"a b", "x\" evil=1", strings.Repeat("a", 65)} {
+		if got := serve(bad); got == bad {
+			t.Errorf("invalid ID %q reused", bad)
+		}
+	}
+}
+
+func TestAPIRateLimitPerKey(t *testing.T) {
+	h := newTestHandler(t)
+	h.Cfg.APIRateLimit = 0.01
+	h.Cfg.APIRateBurst = 1
+	seedAccount(t, h.DB, "acc", "member")
+	keyA := seedAPIKey(t, h, "acc", "aaaaaaaa", "read")
+	keyB := seedAPIKey(t, h, "acc", "bbbbbbbb", "read")
+	router := h.Routes(fstest.MapFS{}, NewRateLimiter(100, 100))
+
+	call := func(key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api/v1/assets", nil)
+		req.Header.Set("Authorization", "Bearer "+key)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// Both requests come from the same IP; only the key tells them apart.
+	if rec := call(keyA); rec.Code != http.StatusOK || rec.Header().Get("X-RateLimit-Remaining") != "0" {
+		t.Fatalf("key A first call = %d, remaining %q", rec.Code, rec.Header().Get("X-RateLimit-Remaining"))
+	}
+	rec := call(keyA)
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("key A second call = %d, want 429", rec.Code)
+	}
+	if rec.Header().Get("X-RateLimit-Remaining") != "0" {
+		t.Errorf("X-RateLimit-Remaining = %q", rec.Header().Get("X-RateLimit-Remaining"))
+	}
+	if ra := rec.Header().Get("Retry-After"); ra == "" || ra == "0" {
+		t.Errorf("Retry-After = %q", ra)
+	}
+	if rec := call(keyB); rec.Code != http.StatusOK {
+		t.Errorf("key B shares key A's bucket: %d", rec.Code)
+	}
+}
+
+func TestAPIRateLimitFailedAuth(t *testing.T) {
+	h := newTestHandler(t)
+	h.Cfg.APIRateLimit = 0.01
+	h.Cfg.APIRateBurst = 2
+	seedAccount(t, h.DB, "acc", "member")
+	key := seedAPIKey(t, h, "acc", "aaaaaaaa", "read")
+	router := h.Routes(fstest.MapFS{}, NewRateLimiter(100, 100))
+
+	call := func(auth string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api/v1/assets", nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for i, auth := range []string{"Bearer do_bbbbbbbb00000000", ""} {
+		if rec := call(auth); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("failure %d = %d, want 401", i+1, rec.Code)
+		}
+	}
+	rec := call("Bearer do_bbbbbbbb00000000")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("third failure = %d, Retry-After %q; want 429", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	// The IP is blocked as a whole until the bucket refills.
+	if rec := call("Bearer " + key); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("valid key from the blocked IP = %d, want 429", rec.Code)
+	}
+}
+
+func TestAPIRateLimitSuccessfulAuthNotCounted(t *testing.T) {
+	h := newTestHandler(t)
+	h.Cfg.APIRateLimit = 0.01
+	h.Cfg.APIRateBurst = 2
+	seedAccount(t, h.DB, "acc", "member")
+	keys := []string{
+		seedAPIKey(t, h, "acc", "aaaaaaaa", "read"),
+		seedAPIKey(t, h, "acc", "bbbbbbbb", "read"),
+		seedAPIKey(t, h, "acc", "cccccccc", "read"),
+	}
+	router := h.Routes(fstest.MapFS{}, NewRateLimiter(100, 100))
+
+	// Three keys on one IP each make a call; only failures use the IP bucket.
+	for i, key := range keys {
+		req := httptest.NewRequest("GET", "/api/v1/assets", nil)
+		req.Header.Set("Authorization", "Bearer "+key)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Errorf("key %d = %d, want 200", i+1, rec.Code)
+		}
+	}
+}
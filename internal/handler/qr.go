@@ -0,0 +1,134 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/base64"
+	"html/template"
+	"image"
+	"image/color"
+	"image/png"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/YannKr/downloadonce/internal/auth"
+	"github.com/YannKr/downloadonce/internal/db"
+	"rsc.io/qr"
+)
+
+const (
+	qrDefaultSize = 256
+	qrMinSize     = 64
+	qrMaxSize     = 1024
+	qrQuietZone   = 4 // modules of white border required by the QR spec
+)
+
+// qrSize reads ?size= from the request, clamped to [qrMinSize, qrMaxSize].
+func qrSize(r *http.Request) int {
+	size, err := strconv.Atoi(r.URL.Query().Get("size"))
+	if err != nil || size <= 0 {
+		return qrDefaultSize
+	}
+	if size < qrMinSize {
+		return qrMinSize
+	}
+	if size > qrMaxSize {
+		return qrMaxSize
+	}
+	return size
+}
+
+// qrImage encodes text as a size×size QR code, quiet zone included.
+func qrImage(text string, size int) (image.Image, error) {
+	code, err := qr.Encode(text, qr.M)
+	if err != nil {
+		return nil, err
+	}
+
+	modules := code.Size + 2*qrQuietZone
+	img := image.NewGray(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		my := y*modules/size - qrQuietZone
+		for x := 0; x < size; x++ {
+			mx := x*modules/size - qrQuietZone
+			c := color.Gray{Y: 0xff}
+			if code.Black(mx, my) {
+				c.Y = 0
+			}
+			img.SetGray(x, y, c)
+		}
+	}
+	return img, nil
+}
+
+// renderQRPNG encodes text as a size×size PNG QR code, quiet zone included.
+func renderQRPNG(w http.ResponseWriter, text string, size int) {
+	img, err := qrImage(text, size)
+	if err != nil {
+		slog.Error("qr encode", "error", err)
+		http.Error(w, "Internal error", 500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	png.Encode(w, img)
+}
+
+// qrDataURL encodes text as a PNG QR code data: URL for inline <img> use.
+func qrDataURL(text string, size int) (template.URL, error) {
+	img, err := qrImage(text, size)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return "", err
+	}
+	return template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes())), nil
+}
+
+// DownloadQR - GET /d/{token}/qr.png
+func (h *Handler) DownloadQR(w http.ResponseWriter, r *http.Request) {
+	tokenStr := chi.URLParam(r, "token")
+	if _, err := uuid.Parse(tokenStr); err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	token, err := db.GetToken(h.DB, tokenStr)
+	if err != nil || token == nil {
+		http.NotFound(w, r)
+		return
+	}
+	renderQRPNG(w, h.Cfg.BaseURL+"/d/"+token.ID, qrSize(r))
+}
+
+// APITokenQR - GET /api/v1/campaigns/{id}/tokens/{tokenID}/qr
+func (h *Handler) APITokenQR(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	tokenID := chi.URLParam(r, "tokenID")
+	accountID := auth.AccountFromContext(r.Context())
+
+	campaign, err := db.GetCampaign(h.DB, id)
+	if err != nil {
+		renderJSONError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "failed to get campaign")
+		return
+	}
+	if campaign == nil || (campaign.AccountID != accountID && !auth.IsAdmin(r.Context())) {
+		renderJSONError(w, http.StatusNotFound, "NOT_FOUND", "campaign not found")
+		return
+	}
+
+	token, err := db.GetToken(h.DB, tokenID)
+	if err != nil {
+		renderJSONError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "failed to get token")
+		return
+	}
+	if token == nil || token.CampaignID != id {
+		renderJSONError(w, http.StatusNotFound, "NOT_FOUND", "token not found")
+		return
+	}
+	renderQRPNG(w, h.Cfg.BaseURL+"/d/"+token.ID, qrSize(r))
+}
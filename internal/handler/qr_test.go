@@ -0,0 +1,69 @@
+package handler
+
+import (
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/YannKr/downloadonce/internal/db"
+	"github.com/YannKr/downloadonce/internal/model"
+)
+
+func TestTokenQR(t *testing.T) {
+	h := newTestHandler(t)
+	seedAccount(t, h.DB, "owner", "member")
+	seedAccount(t, h.DB, "other", "member")
+	seedAccount(t, h.DB, "admin", "admin")
+	tok := seedCampaign(t, h.DB, "owner", "camp", "READY", "rec")[0]
+	seedCampaign(t, h.DB, "owner", "camp2", "READY")
+	const uuidTok = "6f1c2a9e-3b4d-4e5f-8a7b-9c0d1e2f3a4b"
+	if err := db.CreateToken(h.DB, &model.DownloadToken{
+		ID: uuidTok, CampaignID: "camp2", RecipientID: "rec", State: "ACTIVE",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	r := chi.NewRouter()
+	r.Get("/api/v1/campaigns/{id}/tokens/{tokenID}/qr", h.APITokenQR)
+	r.Get("/d/{token}/qr.png", h.DownloadQR)
+
+	for _, tc := range []struct {
+		acct, role, path string
+		want             int
+	}{
+		{"owner", "member", "/api/v1/campaigns/camp/tokens/" + tok + "/qr", http.StatusOK},
+		{"admin", "admin", "/api/v1/campaigns/camp/tokens/" + tok + "/qr", http.StatusOK},
+		{"other", "member", "/api/v1/campaigns/camp/tokens/" + tok + "/qr", http.StatusNotFound},
+		{"owner", "member", "/api/v1/campaigns/camp2/tokens/" + tok + "/qr", http.StatusNotFound},
+		{"owner", "member", "/api/v1/campaigns/camp/tokens/missing/qr", http.StatusNotFound},
+		{"owner", "member", "/api/v1/campaigns/missing/tokens/" + tok + "/qr", http.StatusNotFound},
+		{"", "", "/d/" + uuidTok + "/qr.png", http.StatusOK},
+		{"", "", "/d/" + tok + "/qr.png", http.StatusNotFound},
+		{"", "", "/d/00000000-0000-4000-8000-000000000000/qr.png", http.StatusNotFound},
+	} {
+		req := httptest.NewRequest("GET", tc.path+"?size=100", nil)
+		if tc.acct != "" {
+			req = asAccount(req, tc.acct, tc.role)
+		}
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		if rec.Code != tc.want {
+			t.Errorf("%s %s: status = %d, want %d", tc.acct, tc.path, rec.Code, tc.want)
+			continue
+		}
+		if tc.want != http.StatusOK {
+			continue
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != "image/png" {
+			t.Errorf("%s: Content-Type = %q", tc.path, ct)
+		}
+		img, err := png.Decode(rec.Body)
+		if err != nil {
+			t.Errorf("%s: decode: %v", tc.path, err)
+		} else if b := img.Bounds(); b.Dx() != 100 || b.Dy() != 100 {
+			t.Errorf("%s: size = %v, want 100x100", tc.path, b)
+		}
+	}
+}
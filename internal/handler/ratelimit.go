@@ -13,7 +13,8 @@ type visitor struct {
 	lastSeen time.Time
 }
 
-// RateLimiter tracks per-IP rate limits using token buckets.
+// RateLimiter tracks per-client rate limits using token buckets, keyed by
+// client IP or, for the API, by API key.
 type RateLimiter struct {
 	visitors sync.Map
 	rate     rate.Limit
@@ -34,15 +35,15 @@ func NewRateLimiter(r rate.Limit, burst int) *RateLimiter {
 	return rl
 }
 
-func (rl *RateLimiter) getLimiter(ip string) *rate.Limiter {
-	v, ok := rl.visitors.Load(ip)
+func (rl *RateLimiter) getLimiter(key string) *rate.Limiter {
+	v, ok := rl.visitors.Load(key)
 	if ok {
 		vis := v.(*visitor)
 		vis.lastSeen = time.Now()
 		return vis.limiter
 	}
 	limiter := rate.NewLimiter(rl.rate, rl.burst)
-	rl.visitors.Store(ip, &visitor{limiter: limiter, lastSeen: time.Now()})
+	rl.visitors.Store(key, &visitor{limiter: limiter, lastSeen: time.Now()})
 	return limiter
 }
 
@@ -80,9 +81,9 @@ func (rl *RateLimiter) Burst() int {
 	return rl.burst
 }
 
-// Get returns the rate.Limiter for the given IP, creating one if needed.
-func (rl *RateLimiter) Get(ip string) *rate.Limiter {
-	return rl.getLimiter(ip)
+// Get returns the rate.Limiter for the given key, creating one if needed.
+func (rl *RateLimiter) Get(key string) *rate.Limiter {
+	return rl.getLimiter(key)
 }
 
 // Middleware returns an HTTP middleware that rate-limits by client IP.
@@ -1,6 +1,8 @@
 package handler
 
 import (
+	"fmt"
+	"log/slog"
 	"net/http"
 	"strings"
 
@@ -12,19 +14,61 @@ import (
 )
 
 type recipientPageData struct {
-	Recipients []model.RecipientWithGroups
-	FormName   string
-	FormEmail  string
-	FormOrg    string
+	Recipients   []model.RecipientWithGroups
+	Query        string
+	ImportErrors []importRowError // rows rejected by the last bulk import
+	FormName     string
+	FormEmail    string
+	FormOrg      string
+}
+
+// recipientSearchLimit caps the matches returned to the autocomplete box.
+const recipientSearchLimit = 20
+
+// recipientScope is the account whose recipients the caller may list and
+// search: their own, or "" (every account) for admins. The recipients page,
+// the pickers, their autocomplete and the API all use it.
+func recipientScope(r *http.Request) string {
+	if auth.IsAdmin(r.Context()) {
+		return ""
+	}
+	return auth.AccountFromContext(r.Context())
 }
 
 func (h *Handler) RecipientList(w http.ResponseWriter, r *http.Request) {
-	recipients, err := db.ListRecipientsWithGroups(h.DB)
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	var recipients []model.RecipientWithGroups
+	var err error
+	if q != "" {
+		recipients, err = db.SearchRecipientsWithGroups(h.DB, recipientScope(r), q, 0)
+	} else {
+		recipients, err = db.ListRecipientsWithGroups(h.DB, recipientScope(r))
+	}
 	if err != nil {
 		http.Error(w, "Internal error", 500)
 		return
 	}
-	h.renderAuth(w, r, "recipients.html", "Recipients", recipientPageData{Recipients: recipients})
+	h.renderAuth(w, r, "recipients.html", "Recipients", recipientPageData{Recipients: recipients, Query: q})
+}
+
+// RecipientSearch - GET /recipients/search?q=
+//
+// Autocomplete for the recipient pickers, over the same recipients as the
+// recipients page.
+func (h *Handler) RecipientSearch(w http.ResponseWriter, r *http.Request) {
+	result := []apiRecipient{}
+	if q := strings.TrimSpace(r.URL.Query().Get("q")); q != "" {
+		recipients, err := db.SearchRecipients(h.DB, recipientScope(r), q, recipientSearchLimit)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "search recipients", "error", err)
+			renderJSONError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "failed to search recipients")
+			return
+		}
+		for _, rec := range recipients {
+			result = append(result, recipientToAPI(&rec))
+		}
+	}
+	renderJSON(w, http.StatusOK, result)
 }
 
 func (h *Handler) RecipientCreate(w http.ResponseWriter, r *http.Request) {
@@ -34,12 +78,25 @@ func (h *Handler) RecipientCreate(w http.ResponseWriter, r *http.Request) {
 	email := strings.TrimSpace(r.FormValue("email"))
 	org := strings.TrimSpace(r.FormValue("org"))
 
+	formErr := ""
 	if name == "" || email == "" {
-		recipients, _ := db.ListRecipientsWithGroups(h.DB)
+		formErr = "Name and email are required."
+	} else if normalized, err := h.validateEmail(email); err != nil {
+		formErr = "Invalid email: " + err.Error() + "."
+	} else {
+		email = normalized
+		// The UNIQUE constraint is case-sensitive; this also catches older
+		// mixed-case rows.
+		if existing, _ := db.GetOrCreateRecipientByEmail(h.DB, accountID, name, email, org); existing != nil && existing.ID != "" {
+			formErr = "A recipient with this email already exists."
+		}
+	}
+	if formErr != "" {
+		recipients, _ := db.ListRecipientsWithGroups(h.DB, recipientScope(r))
 		h.render(w, r, "recipients.html", PageData{
 			Title: "Recipients", Authenticated: true,
 			IsAdmin: auth.IsAdmin(r.Context()), UserName: auth.NameFromContext(r.Context()),
-			Error: "Name and email are required.",
+			Error: formErr,
 			Data:  recipientPageData{Recipients: recipients, FormName: name, FormEmail: email, FormOrg: org},
 		})
 		return
@@ -54,7 +111,7 @@ func (h *Handler) RecipientCreate(w http.ResponseWriter, r *http.Request) {
 	}
 	if err := db.CreateRecipient(h.DB, recipient); err != nil {
 		if strings.Contains(err.Error(), "UNIQUE") {
-			recipients, _ := db.ListRecipientsWithGroups(h.DB)
+			recipients, _ := db.ListRecipientsWithGroups(h.DB, recipientScope(r))
 			h.render(w, r, "recipients.html", PageData{
 				Title: "Recipients", Authenticated: true,
 				IsAdmin: auth.IsAdmin(r.Context()), UserName: auth.NameFromContext(r.Context()),
@@ -69,66 +126,36 @@ func (h *Handler) RecipientCreate(w http.ResponseWriter, r *http.Request) {
 
 	db.InsertAuditLog(h.DB, auth.AccountFromContext(r.Context()), "recipient_created", "recipient", recipient.ID, recipient.Email, r.RemoteAddr)
 
-	setFlash(w, "Recipient created.")
+	h.setFlash(w, "Recipient created.")
 	http.Redirect(w, r, "/recipients", http.StatusSeeOther)
 }
 
 func (h *Handler) RecipientImport(w http.ResponseWriter, r *http.Request) {
 	accountID := auth.AccountFromContext(r.Context())
-	bulk := r.FormValue("bulk")
-
-	var created, skipped int
-	lines := strings.Split(bulk, "\n")
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" {
-			continue
-		}
-		parts := strings.SplitN(line, ",", 3)
-		if len(parts) < 2 {
-			continue
-		}
-		name := strings.TrimSpace(parts[0])
-		email := strings.TrimSpace(parts[1])
-		org := ""
-		if len(parts) == 3 {
-			org = strings.TrimSpace(parts[2])
-		}
-		if name == "" || email == "" {
-			continue
-		}
-
-		existing, _ := db.GetOrCreateRecipientByEmail(h.DB, accountID, name, email, org)
-		if existing.ID != "" {
-			skipped++
-			continue
-		}
-
-		existing.ID = uuid.New().String()
-		if err := db.CreateRecipient(h.DB, existing); err != nil {
-			skipped++
-			continue
-		}
-		created++
+	rows, errs := parseBulkRecipients(r.FormValue("bulk"))
+	res := h.importRecipients(accountID, rows, errs, nil)
+	db.InsertAuditLog(h.DB, accountID, "recipients_imported", "recipient", "",
+		fmt.Sprintf("%d created, %d skipped, %d rejected", res.Created, res.Skipped, len(res.Errors)), r.RemoteAddr)
+
+	recipients, _ := db.ListRecipientsWithGroups(h.DB, recipientScope(r))
+	var counts []string
+	if res.Created > 0 {
+		counts = append(counts, fmt.Sprintf("%d created", res.Created))
 	}
-
-	recipients, _ := db.ListRecipientsWithGroups(h.DB)
-	flash := ""
-	if created > 0 {
-		flash += strings.Replace("N created", "N", strings.TrimSpace(itoa(created)), 1)
+	if res.Skipped > 0 {
+		counts = append(counts, fmt.Sprintf("%d skipped", res.Skipped))
 	}
-	if skipped > 0 {
-		if flash != "" {
-			flash += ", "
-		}
-		flash += strings.Replace("N skipped", "N", strings.TrimSpace(itoa(skipped)), 1)
+	errMsg := ""
+	if len(res.Errors) > 0 {
+		errMsg = invalidRowsMessage(res.Errors)
 	}
 
 	h.render(w, r, "recipients.html", PageData{
 		Title: "Recipients", Authenticated: true,
 		IsAdmin: auth.IsAdmin(r.Context()), UserName: auth.NameFromContext(r.Context()),
-		Flash: flash,
-		Data:  recipientPageData{Recipients: recipients},
+		Flash: strings.Join(counts, ", "),
+		Error: errMsg,
+		Data:  recipientPageData{Recipients: recipients, ImportErrors: res.Errors},
 	})
 }
 
@@ -142,20 +169,23 @@ func (h *Handler) RecipientDelete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	db.DeleteRecipient(h.DB, id)
-	db.InsertAuditLog(h.DB, auth.AccountFromContext(r.Context()), "recipient_deleted", "recipient", id, "", r.RemoteAddr)
-	setFlash(w, "Recipient deleted.")
-	http.Redirect(w, r, "/recipients", http.StatusSeeOther)
-}
-
-func itoa(n int) string {
-	if n == 0 {
-		return "0"
+	if n, err := db.CountTokensByRecipient(h.DB, id); err != nil {
+		slog.ErrorContext(r.Context(), "count recipient tokens", "error", err)
+		http.Error(w, "Internal error", 500)
+		return
+	} else if n > 0 {
+		h.setFlash(w, fmt.Sprintf("Cannot delete %s: they have %d download link(s) in campaigns. Delete those campaigns first.", recipient.Name, n))
+		http.Redirect(w, r, "/recipients", http.StatusSeeOther)
+		return
 	}
-	s := ""
-	for n > 0 {
-		s = string(rune('0'+n%10)) + s
-		n /= 10
+
+	if err := db.DeleteRecipient(h.DB, id); err != nil {
+		slog.ErrorContext(r.Context(), "delete recipient", "error", err)
+		h.setFlash(w, "Recipient could not be deleted.")
+		http.Redirect(w, r, "/recipients", http.StatusSeeOther)
+		return
 	}
-	return s
+	db.InsertAuditLog(h.DB, auth.AccountFromContext(r.Context()), "recipient_deleted", "recipient", id, "", r.RemoteAddr)
+	h.setFlash(w, "Recipient deleted.")
+	http.Redirect(w, r, "/recipients", http.StatusSeeOther)
 }
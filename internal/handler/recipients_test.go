@@ -0,0 +1,134 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/YannKr/downloadonce/internal/db"
+)
+
+func deleteRecipientRequest(h *Handler, id string) *httptest.ResponseRecorder {
+	r := chi.NewRouter()
+	r.Delete("/api/v1/recipients/{id}", h.APIRecipientDelete)
+	req := asAccount(httptest.NewRequest("DELETE", "/api/v1/recipients/"+id, nil), "acc", "admin")
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAPIRecipientDeleteBlockedByTokens(t *testing.T) {
+	h := newTestHandler(t)
+	seedAccount(t, h.DB, "acc", "admin")
+	tokens := seedCampaign(t, h.DB, "acc", "camp", "READY", "alice")
+
+	rec := deleteRecipientRequest(h, "alice")
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("status = %d, want 409; body %s", rec.Code, rec.Body)
+	}
+	if r, _ := db.GetRecipient(h.DB, "alice"); r == nil {
+		t.Fatal("recipient deleted despite its token")
+	}
+
+	// An expired token still references the recipient, so the guard
+	// answers 409 rather than letting the foreign key fail the delete.
+	db.ExpireToken(h.DB, tokens[0])
+	if rec := deleteRecipientRequest(h, "alice"); rec.Code != http.StatusConflict {
+		t.Fatalf("with expired token: status = %d, want 409; body %s", rec.Code, rec.Body)
+	}
+	listed, err := db.ListTokensByCampaign(h.DB, "camp")
+	if err != nil || len(listed) != 1 {
+		t.Fatalf("list tokens = %d, %v", len(listed), err)
+	}
+}
+
+func TestAPIRecipientDeleteWithoutTokens(t *testing.T) {
+	h := newTestHandler(t)
+	seedAccount(t, h.DB, "acc", "admin")
+
+	// carol has never been part of a campaign.
+	if _, err := h.DB.Exec(`INSERT INTO recipients (id, account_id, name, email) VALUES ('carol', 'acc', 'Carol', 'carol@example.com')`); err != nil {
+		t.Fatal(err)
+	}
+	if rec := deleteRecipientRequest(h, "carol"); rec.Code != http.StatusNoContent {
+		t.Fatalf("status = %d, want 204", rec.Code)
+	}
+}
+
+func TestRecipientSearch(t *testing.T) {
+	h := newTestHandler(t)
+	seedAccount(t, h.DB, "acc", "member")
+	seedAccount(t, h.DB, "other", "member")
+	for _, q := range []string{
+		`INSERT INTO recipients (id, account_id, name, email, org) VALUES ('ann', 'acc', 'Ann Lee', 'ann@example.com', 'Acme')`,
+		`INSERT INTO recipients (id, account_id, name, email, org) VALUES ('andy', 'other', 'Andy Low', 'andy@example.com', '')`,
+		`INSERT INTO recipients (id, account_id, name, email, org) VALUES ('bea', 'acc', 'Bea Lam', 'bea@example.com', '')`,
+	} {
+		if _, err := h.DB.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ids := func(rs []apiRecipient) []string {
+		var out []string
+		for _, r := range rs {
+			out = append(out, r.ID)
+		}
+		return out
+	}
+
+	// The API only searches the caller's own recipients.
+	rec := httptest.NewRecorder()
+	h.APIRecipientList(rec, asAccount(httptest.NewRequest("GET", "/api/v1/recipients?q=an", nil), "acc", "member"))
+	var page struct {
+		Data  []apiRecipient `json:"data"`
+		Total int            `json:"total"`
+	}
+	json.NewDecoder(rec.Body).Decode(&page)
+	if got := ids(page.Data); page.Total != 1 || len(got) != 1 || got[0] != "ann" {
+		t.Errorf("API search = %v (total %d), want [ann]", got, page.Total)
+	}
+
+	// The autocomplete matches name word prefixes, over the same recipients:
+	// the caller's own, or every account's for admins.
+	seedAccount(t, h.DB, "admin", "admin")
+	for _, tc := range []struct {
+		acct, role string
+		want       int
+	}{{"acc", "member", 2}, {"admin", "admin", 3}} {
+		rec = httptest.NewRecorder()
+		h.RecipientSearch(rec, asAccount(httptest.NewRequest("GET", "/recipients/search?q=l", nil), tc.acct, tc.role))
+		var matches []apiRecipient
+		if err := json.NewDecoder(rec.Body).Decode(&matches); err != nil {
+			t.Fatalf("status %d: %v", rec.Code, err)
+		}
+		if got := ids(matches); len(got) != tc.want {
+			t.Errorf("%s autocomplete = %v, want %d matches", tc.acct, got, tc.want)
+		}
+	}
+
+	// So does the recipients page, with or without a query.
+	for _, path := range []string{"/recipients", "/recipients?q=a"} {
+		rec = httptest.NewRecorder()
+		h.RecipientList(rec, asAccount(httptest.NewRequest("GET", path, nil), "acc", "member"))
+		if body := rec.Body.String(); !strings.Contains(body, "ann@example.com") || strings.Contains(body, "andy@example.com") {
+			t.Errorf("%s lists another account's recipients or misses its own", path)
+		}
+	}
+
+	// And the API list without a query.
+	rec = httptest.NewRecorder()
+	h.APIRecipientList(rec, asAccount(httptest.NewRequest("GET", "/api/v1/recipients", nil), "acc", "member"))
+	json.NewDecoder(rec.Body).Decode(&page)
+	if page.Total != 2 {
+		t.Errorf("API list total = %d, want 2", page.Total)
+	}
+
+	rec = httptest.NewRecorder()
+	h.RecipientSearch(rec, asAccount(httptest.NewRequest("GET", "/recipients/search?q=", nil), "acc", "member"))
+	if body := strings.TrimSpace(rec.Body.String()); body != "[]" {
+		t.Errorf("empty query = %s, want []", body)
+	}
+}
@@ -0,0 +1,55 @@
+package handler
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// safeRedirect returns target if it stays on this site, or fallback
+// otherwise. Relative paths must be rooted ("/campaigns") and not
+// scheme-relative ("//evil.example", "/\evil.example"); absolute URLs are
+// accepted only when their scheme and host match Cfg.BaseURL, and are
+// reduced to their path. Use it for every redirect that includes user input.
+func (h *Handler) safeRedirect(target, fallback string) string {
+	if target == "" || strings.ContainsAny(target, "\r\n\x00") {
+		return fallback
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return fallback
+	}
+	if u.Scheme == "" && u.Host == "" {
+		// Browsers treat a backslash like a slash, so "/\host" is
+		// scheme-relative too.
+		if !strings.HasPrefix(target, "/") || strings.HasPrefix(target, "//") || strings.HasPrefix(target, `/\`) {
+			return fallback
+		}
+		return target
+	}
+	base, err := url.Parse(h.Cfg.BaseURL)
+	if err != nil || base.Host == "" || !strings.EqualFold(u.Scheme, base.Scheme) || !strings.EqualFold(u.Host, base.Host) {
+		return fallback
+	}
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	if strings.HasPrefix(path, "//") || strings.HasPrefix(path, `/\`) {
+		return fallback
+	}
+	if u.RawQuery != "" {
+		path += "?" + u.RawQuery
+	}
+	return path
+}
+
+// redirectToLogin sends an unauthenticated browser to the login page,
+// remembering a GET request's URL so login can return there.
+func redirectToLogin(w http.ResponseWriter, r *http.Request) {
+	target := "/login"
+	if r.Method == http.MethodGet {
+		target += "?next=" + url.QueryEscape(r.URL.RequestURI())
+	}
+	http.Redirect(w, r, target, http.StatusSeeOther)
+}
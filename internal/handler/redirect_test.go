@@ -0,0 +1,74 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestSafeRedirect(t *testing.T) {
+	h := newTestHandler(t)
+	h.Cfg.BaseURL = "https://files.example.com"
+
+	cases := map[string]string{
+		"/campaigns/abc":                       "/campaigns/abc",
+		"/campaigns?page=2":                    "/campaigns?page=2",
+		"https://files.example.com/assets?x=1": "/assets?x=1",
+		"https://FILES.example.com":            "/",
+		"":                                     "/dashboard",
+		"campaigns":                            "/dashboard",
+		"//evil.example/phish":                 "/dashboard",
+		`/\evil.example`:                       "/dashboard",
+		"https://evil.example/":                "/dashboard",
+		"http://files.example.com/":            "/dashboard", // scheme differs
+		"https://files.example.com.evil.example/": "/dashboard",
+		"javascript:alert(1)":                     "/dashboard",
+		"/ok\r\nSet-Cookie: x=1":                  "/dashboard",
+	}
+	for target, want := range cases {
+		if got := h.safeRedirect(target, "/dashboard"); got != want {
+			t.Errorf("safeRedirect(%q) = %q, want %q", target, got, want)
+		}
+	}
+}
+
+func TestLoginNextRedirect(t *testing.T) {
+	h := newTestHandler(t)
+	seedPasswordAccount(t, h, "acc", "correct horse")
+	router := h.Routes(nil, NewRateLimiter(100, 100))
+
+	// An unauthenticated page view remembers where it was going.
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", "/campaigns?page=2", nil))
+	if loc := rec.Header().Get("Location"); rec.Code != http.StatusSeeOther || loc != "/login?next="+url.QueryEscape("/campaigns?page=2") {
+		t.Fatalf("unauthenticated: status = %d, Location %q", rec.Code, loc)
+	}
+
+	login := func(next string) string {
+		rec := httptest.NewRecorder()
+		h.LoginSubmit(rec, postForm("/login", url.Values{
+			"email": {"acc@example.com"}, "password": {"correct horse"}, "next": {next},
+		}))
+		if rec.Code != http.StatusSeeOther {
+			t.Fatalf("login(next=%q): status = %d", next, rec.Code)
+		}
+		return rec.Header().Get("Location")
+	}
+	if loc := login("/campaigns?page=2"); loc != "/campaigns?page=2" {
+		t.Errorf("same-site next: Location = %q", loc)
+	}
+	for _, evil := range []string{"https://evil.example/", "//evil.example", `/\evil.example`} {
+		if loc := login(evil); loc != "/dashboard" {
+			t.Errorf("next=%q: Location = %q, want /dashboard", evil, loc)
+		}
+	}
+
+	// The login form carries only a safe next value.
+	rec = httptest.NewRecorder()
+	h.LoginForm(rec, httptest.NewRequest("GET", "/login?next="+url.QueryEscape("https://evil.example/"), nil))
+	if strings.Contains(rec.Body.String(), "evil.example") {
+		t.Error("login form echoed an external next target")
+	}
+}
@@ -0,0 +1,203 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/YannKr/downloadonce/internal/db"
+	"github.com/YannKr/downloadonce/internal/logging"
+	"github.com/YannKr/downloadonce/internal/watermark"
+)
+
+const (
+	// detectFetchTimeout bounds fetching a leaked file from a URL, body
+	// included.
+	detectFetchTimeout = 5 * time.Minute
+	// maxDetectFetchBytes caps a fetched file, like the multipart limit of
+	// POST /api/v1/detect. MAX_UPLOAD_BYTES applies too when it is lower.
+	maxDetectFetchBytes = 2 << 30
+)
+
+// errFetchBlocked is returned for URLs resolving to an address that is not
+// publicly routable.
+var errFetchBlocked = errors.New("address is not publicly routable")
+
+// nonPublicPrefixes are blocked on top of what the net/netip predicates
+// cover: shared, benchmarking, reserved and NAT64 ranges that can still reach
+// internal hosts.
+var nonPublicPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+	netip.MustParsePrefix("64:ff9b:1::/48"),
+}
+
+// isPublicAddr reports whether addr is a globally routable unicast address.
+// Loopback, RFC 1918 and unique local, link-local (which covers the cloud
+// metadata endpoint 169.254.169.254), multicast and unspecified addresses
+// are all rejected.
+func isPublicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsValid() || !addr.IsGlobalUnicast() || addr.IsPrivate() || addr.IsLoopback() ||
+		addr.IsLinkLocalUnicast() || addr.IsMulticast() || addr.IsUnspecified() {
+		return false
+	}
+	for _, p := range nonPublicPrefixes {
+		if p.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// allowFetchAddr decides which resolved addresses remote fetches may connect
+// to. Tests swap it to reach httptest servers on loopback.
+var allowFetchAddr = isPublicAddr
+
+// newRemoteFetchClient returns an HTTP client for fetching user-supplied
+// URLs. The address check runs on every connection after DNS resolution, so
+// redirects and rebinding DNS cannot reach internal hosts, and environment
+// proxies are ignored because they would bypass it.
+func newRemoteFetchClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			ap, err := netip.ParseAddrPort(address)
+			if err != nil || !allowFetchAddr(ap.Addr()) {
+				return fmt.Errorf("%w: %s", errFetchBlocked, address)
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			Proxy:               nil,
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: 10 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 5 {
+				return errors.New("too many redirects")
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return fmt.Errorf("redirect to unsupported scheme %q", req.URL.Scheme)
+			}
+			return nil
+		},
+	}
+}
+
+// fetchError is a failed remote fetch whose message can be shown to the
+// user.
+type fetchError struct{ msg string }
+
+func (e *fetchError) Error() string { return e.msg }
+
+func fetchErrorf(format string, args ...any) error {
+	return &fetchError{fmt.Sprintf(format, args...)}
+}
+
+// fetchDetectInput downloads rawURL into dir as input<ext> for a detect job
+// and returns its path. Only image and video responses with an extension
+// detection accepts are kept. A *fetchError describes a problem with the URL
+// or what it served; other errors are internal.
+func (h *Handler) fetchDetectInput(ctx context.Context, rawURL, dir string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fetchErrorf("URL must start with http:// or https://")
+	}
+	if u.User != nil {
+		return "", fetchErrorf("URLs with credentials are not accepted")
+	}
+	limit := int64(maxDetectFetchBytes)
+	if h.Cfg.MaxUploadBytes > 0 && h.Cfg.MaxUploadBytes < limit {
+		limit = h.Cfg.MaxUploadBytes
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", fetchErrorf("invalid URL")
+	}
+	resp, err := newRemoteFetchClient(detectFetchTimeout).Do(req)
+	if err != nil {
+		if errors.Is(err, errFetchBlocked) {
+			return "", fetchErrorf("URL points to a private or internal address")
+		}
+		return "", fetchErrorf("failed to fetch URL: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fetchErrorf("remote server returned %d", resp.StatusCode)
+	}
+
+	ct, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if !strings.HasPrefix(ct, "image/") && !strings.HasPrefix(ct, "video/") {
+		return "", fetchErrorf("URL is not an image or video (Content-Type %q)", ct)
+	}
+	ext := watermark.MimeToExt[ct]
+	if !detectExts[ext] {
+		ext = strings.ToLower(path.Ext(resp.Request.URL.Path))
+	}
+	if !detectExts[ext] {
+		return "", fetchErrorf("unsupported file type %q", ct)
+	}
+	if resp.ContentLength > limit {
+		return "", fetchErrorf("file is larger than %d bytes", limit)
+	}
+
+	inputPath := filepath.Join(dir, "input"+ext)
+	dst, err := os.Create(inputPath)
+	if err != nil {
+		return "", err
+	}
+	n, err := io.Copy(dst, io.LimitReader(resp.Body, limit+1))
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	switch {
+	case err != nil:
+		os.Remove(inputPath)
+		return "", fetchErrorf("failed to fetch URL: %v", err)
+	case n > limit:
+		os.Remove(inputPath)
+		return "", fetchErrorf("file is larger than %d bytes", limit)
+	}
+	return inputPath, nil
+}
+
+// enqueueDetectURL fetches rawURL into a new detect job's directory and
+// queues the job, returning its ID. See fetchDetectInput for the errors.
+func (h *Handler) enqueueDetectURL(ctx context.Context, accountID, rawURL string) (string, error) {
+	jobID := uuid.New().String()
+	detectDir := filepath.Join(h.Cfg.DataDir, "detect", jobID)
+	if err := os.MkdirAll(detectDir, 0755); err != nil {
+		return "", fmt.Errorf("create detect dir: %w", err)
+	}
+	inputPath, err := h.fetchDetectInput(ctx, rawURL, detectDir)
+	if err == nil {
+		err = db.EnqueueDetectJob(h.DB, jobID, accountID, inputPath, "detect", logging.RequestID(ctx))
+	}
+	if err != nil {
+		os.RemoveAll(detectDir)
+		return "", err
+	}
+	return jobID, nil
+}
@@ -0,0 +1,145 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/YannKr/downloadonce/internal/db"
+)
+
+func TestIsPublicAddr(t *testing.T) {
+	tests := map[string]bool{
+		"8.8.8.8":          true,
+		"2606:4700::1111":  true,
+		"127.0.0.1":        false,
+		"10.1.2.3":         false,
+		"172.16.0.1":       false,
+		"192.168.1.1":      false,
+		"169.254.169.254":  false, // cloud metadata
+		"100.64.0.1":       false, // carrier-grade NAT
+		"0.0.0.0":          false,
+		"255.255.255.255":  false,
+		"::1":              false,
+		"::":               false,
+		"fc00::1":          false,
+		"fe80::1":          false,
+		"::ffff:10.0.0.1":  false, // IPv4-mapped private
+		"::ffff:127.0.0.1": false,
+		"64:ff9b::a00:1":   false, // NAT64 of 10.0.0.1
+	}
+	for s, want := range tests {
+		if got := isPublicAddr(netip.MustParseAddr(s)); got != want {
+			t.Errorf("isPublicAddr(%s) = %v, want %v", s, got, want)
+		}
+	}
+}
+
+func TestFetchDetectInput(t *testing.T) {
+	h := newTestHandler(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/leak.png":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte("\x89PNG fake"))
+		case "/redirect":
+			http.Redirect(w, r, "/leak.png", http.StatusFound)
+		default:
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<html></html>"))
+		}
+	}))
+	defer srv.Close()
+
+	fetch := func(url string) (string, error) {
+		return h.fetchDetectInput(context.Background(), url, t.TempDir())
+	}
+
+	// The httptest server is on loopback, which is blocked by default.
+	if _, err := fetch(srv.URL + "/leak.png"); err == nil || !strings.Contains(err.Error(), "private or internal") {
+		t.Errorf("loopback fetch err = %v", err)
+	}
+	for _, u := range []string{"file:///etc/passwd", "ftp://example.com/x.png", "http://user:pw@example.com/x.png", "not a url"} {
+		if _, err := fetch(u); err == nil {
+			t.Errorf("fetch(%q) succeeded", u)
+		}
+	}
+
+	allowFetchAddr = func(netip.Addr) bool { return true }
+	t.Cleanup(func() { allowFetchAddr = isPublicAddr })
+
+	if _, err := fetch(srv.URL + "/page"); err == nil || !strings.Contains(err.Error(), "not an image or video") {
+		t.Errorf("html fetch err = %v", err)
+	}
+	for _, p := range []string{"/leak.png", "/redirect"} {
+		path, err := fetch(srv.URL + p)
+		if err != nil {
+			t.Fatalf("fetch %s: %v", p, err)
+		}
+		if !strings.HasSuffix(path, "input.png") {
+			t.Errorf("fetch %s path = %s", p, path)
+		}
+		if b, _ := os.ReadFile(path); string(b) != "\x89PNG fake" {
+			t.Errorf("fetch %s body = %q", p, b)
+		}
+	}
+
+	h.Cfg.MaxUploadBytes = 4
+	if _, err := fetch(srv.URL + "/leak.png"); err == nil || !strings.Contains(err.Error(), "larger than") {
+		t.Errorf("oversized fetch err = %v", err)
+	}
+}
+
+func TestAPIDetectSubmitURL(t *testing.T) {
+	h := newTestHandler(t)
+	seedAccount(t, h.DB, "acc", "member")
+
+	submit := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/v1/detect", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		h.APIDetectSubmit(rec, asAccount(req, "acc", "member"))
+		return rec
+	}
+
+	if rec := submit(`{"url": "http://169.254.169.254/latest/meta-data/"}`); rec.Code != http.StatusBadRequest ||
+		!strings.Contains(rec.Body.String(), "FETCH_FAILED") {
+		t.Errorf("metadata URL: %d %s", rec.Code, rec.Body)
+	}
+	if rec := submit(`{}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("missing url status = %d", rec.Code)
+	}
+	// Rejected fetches leave no job behind.
+	if n, _ := os.ReadDir(h.Cfg.DataDir + "/detect"); len(n) != 0 {
+		t.Errorf("detect dir has %d entries after failed fetches", len(n))
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write([]byte("jpeg"))
+	}))
+	defer srv.Close()
+	allowFetchAddr = func(netip.Addr) bool { return true }
+	t.Cleanup(func() { allowFetchAddr = isPublicAddr })
+
+	rec := submit(`{"url": "` + srv.URL + `/leak"}`)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	var got apiDetectResult
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	job, err := db.GetJob(h.DB, got.JobID)
+	if err != nil || job == nil {
+		t.Fatalf("job %s: %v", got.JobID, err)
+	}
+	if !strings.HasSuffix(job.InputPath, "input.jpg") {
+		t.Errorf("input path = %s", job.InputPath)
+	}
+}
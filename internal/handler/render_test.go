@@ -0,0 +1,38 @@
+package handler
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/YannKr/downloadonce/internal/config"
+)
+
+func TestRenderDevModeReparsesTemplates(t *testing.T) {
+	for _, dev := range []bool{false, true} {
+		templateFS := fstest.MapFS{
+			"layout.html": {Data: []byte(`{{define "layout.html"}}[{{template "content" .}}]{{end}}`)},
+			"page.html":   {Data: []byte(`{{define "content"}}v1{{end}}`)},
+		}
+		h := New(nil, &config.Config{DevMode: dev}, templateFS, nil, nil, nil)
+
+		render := func() string {
+			rec := httptest.NewRecorder()
+			h.render(rec, nil, "page.html", PageData{})
+			return strings.TrimSpace(rec.Body.String())
+		}
+		if got := render(); got != "[v1]" {
+			t.Fatalf("dev=%v: first render = %q", dev, got)
+		}
+
+		templateFS["page.html"] = &fstest.MapFile{Data: []byte(`{{define "content"}}v2{{end}}`)}
+		want := "[v1]"
+		if dev {
+			want = "[v2]"
+		}
+		if got := render(); got != want {
+			t.Errorf("dev=%v: render after edit = %q, want %q", dev, got, want)
+		}
+	}
+}
@@ -5,15 +5,24 @@ import (
 	"net/http"
 	"strings"
 
+	"github.com/YannKr/downloadonce/internal/auth"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/gorilla/csrf"
+	"golang.org/x/time/rate"
 )
 
 func (h *Handler) Routes(staticFS fs.FS, authRL *RateLimiter) chi.Router {
+	// Probes sit outside every middleware below: orchestrators poll them
+	// often, without sessions, CSRF tokens or a completed setup.
+	root := chi.NewRouter()
+	root.Get("/healthz", h.Healthz)
+	root.Get("/readyz", h.Readyz)
+
 	r := chi.NewRouter()
 
-	r.Use(middleware.Logger)
+	r.Use(RequestID)
+	r.Use(logRequests)
 	r.Use(middleware.Recoverer)
 	r.Use(middleware.RealIP)
 	r.Use(h.RequireSetup)
@@ -27,7 +36,10 @@ func (h *Handler) Routes(staticFS fs.FS, authRL *RateLimiter) chi.Router {
 	r.Use(func(next http.Handler) http.Handler {
 		protected := csrfProtect(next)
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			if strings.HasPrefix(r.Header.Get("Authorization"), "Bearer do_") {
+			// CORS preflights to the API carry no credentials and change
+			// nothing; they are answered by the API's CORS middleware.
+			if strings.HasPrefix(r.Header.Get("Authorization"), "Bearer do_") ||
+				(r.Method == http.MethodOptions && strings.HasPrefix(r.URL.Path, "/api/v1/")) {
 				next.ServeHTTP(w, r)
 				return
 			}
@@ -49,30 +61,73 @@ func (h *Handler) Routes(staticFS fs.FS, authRL *RateLimiter) chi.Router {
 		w.Write(content)
 	})
 
-	// JSON REST API v1 — Bearer API key auth, separate rate limiter
-	apiRL := NewRateLimiter(2.0, 60) // 2 req/sec sustained, burst 60
+	// JSON REST API v1 — Bearer API key auth, rate-limited per key
+	apiRate, apiBurst := h.Cfg.APIRateLimit, h.Cfg.APIRateBurst
+	if apiRate <= 0 || apiBurst <= 0 {
+		apiRate, apiBurst = 2.0, 60 // 2 req/sec sustained, burst 60
+	}
+	apiRL := NewRateLimiter(rate.Limit(apiRate), apiBurst)
+	authFailRL := NewRateLimiter(rate.Limit(apiRate), apiBurst)
 	r.Route("/api/v1", func(r chi.Router) {
-		r.Use(h.apiRateLimit(apiRL))
+		if h.CORS != nil {
+			r.Use(h.CORS.Middleware)
+		}
+		r.Use(h.limitAPIAuthFailures(authFailRL))
 		r.Use(h.requireAPIAuth)
+		r.Use(h.apiRateLimit(apiRL))
+
+		read := auth.RequireScope(auth.ScopeRead)
+		write := auth.RequireScope(auth.ScopeWrite)
+
+		r.With(write).Post("/assets", h.APIAssetUpload)
+		r.With(read).Get("/assets", h.APIAssetList)
+		r.With(read).Get("/assets/{id}", h.APIAssetGet)
+		r.With(read).Get("/assets/{id}/thumbnail", h.APIAssetThumbnail)
+		r.With(write).Delete("/assets/{id}", h.APIAssetDelete)
+		r.With(write).Post("/assets/{id}/restore", h.APIAssetRestore)
+		r.With(write).Post("/assets/{id}/replace", h.APIAssetReplace)
 
-		r.Post("/assets", h.APIAssetUpload)
-		r.Get("/assets", h.APIAssetList)
-		r.Get("/assets/{id}", h.APIAssetGet)
-		r.Delete("/assets/{id}", h.APIAssetDelete)
+		r.With(write).Post("/recipients", h.APIRecipientCreate)
+		r.With(write).Post("/recipients/import", h.APIRecipientImport)
+		r.With(read).Get("/recipients", h.APIRecipientList)
+		r.With(write).Delete("/recipients/{id}", h.APIRecipientDelete)
 
-		r.Post("/recipients", h.APIRecipientCreate)
-		r.Get("/recipients", h.APIRecipientList)
-		r.Delete("/recipients/{id}", h.APIRecipientDelete)
+		r.With(write).Post("/campaigns", h.APICampaignCreate)
+		r.With(read).Get("/campaigns/{id}", h.APICampaignGet)
+		r.With(write).Post("/campaigns/{id}/publish", h.APICampaignPublish)
+		r.With(write).Post("/campaigns/{id}/schedule", h.APICampaignSchedule)
+		r.With(write).Post("/campaigns/{id}/cancel", h.APICampaignCancel)
+		r.With(write).Post("/campaigns/{id}/approve", h.APICampaignApprove)
+		r.With(write).Delete("/campaigns/{id}", h.APICampaignDelete)
+		r.With(write).Post("/campaigns/{id}/restore", h.APICampaignRestore)
+		r.With(read).Get("/campaigns/{id}/tokens", h.APICampaignTokenList)
+		r.With(write).Post("/campaigns/{id}/recipients", h.APICampaignAddRecipients)
+		r.With(write).Delete("/campaigns/{id}/tokens/{tokenID}", h.APICampaignRevokeToken)
+		r.With(write).Post("/campaigns/{id}/tokens/{tokenID}/reissue", h.APICampaignReissueToken)
+		r.With(read).Get("/campaigns/{id}/tokens/{tokenID}/qr", h.APITokenQR)
+		r.With(read).Get("/campaigns/{id}/tokens/{tokenID}/events", h.APITokenEvents)
 
-		r.Post("/campaigns", h.APICampaignCreate)
-		r.Get("/campaigns/{id}", h.APICampaignGet)
-		r.Post("/campaigns/{id}/publish", h.APICampaignPublish)
-		r.Get("/campaigns/{id}/tokens", h.APICampaignTokenList)
-		r.Post("/campaigns/{id}/recipients", h.APICampaignAddRecipients)
-		r.Delete("/campaigns/{id}/tokens/{tokenID}", h.APICampaignRevokeToken)
+		r.With(write).Post("/detect", h.APIDetectSubmit)
+		r.With(write).Post("/detect/combine", h.APIDetectCombine)
+		r.With(read).Get("/detect/{jobID}", h.APIDetectGet)
+		r.With(read).Get("/detect/{jobID}/diff", h.APIDetectDiff)
 
-		r.Post("/detect", h.APIDetectSubmit)
-		r.Get("/detect/{jobID}", h.APIDetectGet)
+		r.With(read).Get("/analytics", h.APIAnalytics)
+
+		r.With(read).Get("/keys", h.APIKeyList)
+
+		r.With(read).Get("/webhooks/{id}/deliveries", h.APIWebhookDeliveries)
+		r.With(write).Post("/webhooks/{id}/replay-all", h.APIWebhookReplayAll)
+
+		r.With(h.requireAPIAdmin, auth.RequireScope(auth.ScopeAdmin)).Get("/audit", h.APIAuditList)
+
+		r.Route("/admin", func(r chi.Router) {
+			r.Use(h.requireAPIAdmin)
+			r.Use(auth.RequireScope(auth.ScopeAdmin))
+			r.Get("/watermark-index/export", h.APIAdminWatermarkIndexExport)
+			r.Post("/watermark-index/import", h.APIAdminWatermarkIndexImport)
+			r.Get("/search", h.APIAdminSearch)
+		})
 	})
 
 	// Public routes (rate-limited)
@@ -80,6 +135,8 @@ func (h *Handler) Routes(staticFS fs.FS, authRL *RateLimiter) chi.Router {
 		r.Use(authRL.Middleware)
 		r.Get("/login", h.LoginForm)
 		r.Post("/login", h.LoginSubmit)
+		r.Get("/login/2fa", h.LoginTOTPForm)
+		r.Post("/login/2fa", h.LoginTOTPSubmit)
 		r.Get("/setup", h.SetupForm)
 		r.Post("/setup", h.SetupSubmit)
 		r.Get("/register", h.RegisterForm)
@@ -88,11 +145,13 @@ func (h *Handler) Routes(staticFS fs.FS, authRL *RateLimiter) chi.Router {
 		r.Post("/forgot-password", h.ForgotPasswordSubmit)
 		r.Get("/reset-password", h.ResetPasswordForm)
 		r.Post("/reset-password", h.ResetPasswordSubmit)
+		r.Post("/d/{token}/request-link", h.DownloadRequestLink)
 	})
 
 	r.Get("/d/{token}", h.DownloadPage)
 	r.Get("/d/{token}/file", h.DownloadFile)
 	r.Get("/d/{token}/events", h.TokenSSE)
+	r.Get("/d/{token}/qr.png", h.DownloadQR)
 
 	r.Group(func(r chi.Router) {
 		r.Use(h.RequireAuth)
@@ -111,9 +170,12 @@ func (h *Handler) Routes(staticFS fs.FS, authRL *RateLimiter) chi.Router {
 		r.Get("/assets/{id}/thumb", h.AssetThumbnail)
 		r.Get("/assets/{id}/download", h.AssetDownload)
 		r.Post("/assets/{id}/rename", h.AssetRename)
+		r.Post("/assets/{id}/replace", h.AssetReplace)
 		r.Post("/assets/{id}/delete", h.AssetDelete)
+		r.Post("/assets/{id}/restore", h.AssetRestore)
 
 		r.Get("/recipients", h.RecipientList)
+		r.Get("/recipients/search", h.RecipientSearch)
 		r.Post("/recipients", h.RecipientCreate)
 		r.Post("/recipients/import", h.RecipientImport)
 		r.Post("/recipients/{id}/delete", h.RecipientDelete)
@@ -132,29 +194,51 @@ func (h *Handler) Routes(staticFS fs.FS, authRL *RateLimiter) chi.Router {
 		r.Post("/campaigns/new", h.CampaignCreate)
 		r.Get("/campaigns/{id}", h.CampaignDetail)
 		r.Post("/campaigns/{id}/publish", h.CampaignPublish)
+		r.Post("/campaigns/{id}/schedule", h.CampaignSchedule)
+		r.Post("/campaigns/{id}/cancel", h.CampaignCancel)
+		r.Post("/campaigns/{id}/approve", h.CampaignApprove)
+		r.Post("/campaigns/{id}/reject", h.CampaignReject)
 		r.Post("/campaigns/{id}/tokens/{tokenID}/revoke", h.TokenRevoke)
 		r.Post("/campaigns/{id}/tokens/{tokenID}/retry", h.TokenRetry)
+		r.Post("/campaigns/{id}/retry-failed", h.CampaignRetryFailed)
+		r.Post("/campaigns/{id}/tokens/{tokenID}/reissue", h.TokenReissue)
 		r.Get("/campaigns/{id}/events", h.CampaignSSE)
+		r.Get("/campaigns/{id}/watermarked-thumb", h.CampaignWatermarkedThumb)
+		r.Post("/campaigns/{id}/preview", h.CampaignPreview)
 		r.Post("/campaigns/{id}/clone", h.CampaignClone)
 		r.Get("/campaigns/{id}/export-links", h.CampaignExportLinks)
+		r.Get("/campaigns/{id}/export/files", h.CampaignExportFiles)
+		r.Post("/campaigns/{id}/bundle.zip", h.CampaignBundle)
 		r.Post("/campaigns/{id}/add-recipients", h.CampaignAddRecipients)
 		r.Post("/campaigns/{id}/archive", h.CampaignArchive)
+		r.Post("/campaigns/{id}/delete", h.CampaignDelete)
+		r.Post("/campaigns/{id}/restore", h.CampaignRestore)
 
 		r.Get("/detect", h.DetectForm)
 		r.Post("/detect", h.DetectSubmit)
 		r.Get("/detect/{id}", h.DetectResult)
+		r.Get("/detect/{id}/diff", h.DetectDiff)
 
 		r.Get("/analytics", h.Analytics)
 		r.Get("/analytics/export", h.AnalyticsExport)
 
 		r.Get("/settings", h.SettingsPage)
 		r.Post("/settings/notify", h.NotifyOnDownloadUpdate)
+		r.Get("/settings/email-preview", h.EmailPreview)
+		r.Post("/settings/default-group", h.DefaultGroupUpdate)
+		r.Post("/settings/sessions/revoke-others", h.SessionRevokeOthers)
+		r.Post("/settings/sessions/{handle}/revoke", h.SessionRevoke)
+		r.Get("/settings/2fa", h.TwoFactorPage)
+		r.Post("/settings/2fa", h.TwoFactorEnable)
+		r.Post("/settings/2fa/disable", h.TwoFactorDisable)
 		r.Post("/settings/apikeys", h.APIKeyCreate)
+		r.Post("/settings/apikeys/revoke-all", h.APIKeyRevokeAll)
 		r.Post("/settings/apikeys/{id}/delete", h.APIKeyDelete)
 		r.Post("/settings/webhooks", h.WebhookCreate)
 		r.Post("/settings/webhooks/{id}/delete", h.WebhookDelete)
 		r.Get("/settings/webhooks/{id}/deliveries", h.WebhookDeliveries)
 		r.Post("/settings/webhooks/{id}/deliveries/{deliveryID}/replay", h.WebhookDeliveryReplay)
+		r.Post("/settings/webhooks/{id}/replay-all", h.WebhookReplayAll)
 
 		r.Post("/upload/chunks/init", h.UploadInit)
 		r.Put("/upload/chunks/{sessionID}/{chunkIndex}", h.UploadChunk)
@@ -169,12 +253,17 @@ func (h *Handler) Routes(staticFS fs.FS, authRL *RateLimiter) chi.Router {
 			r.Post("/users/{id}/toggle", h.AdminToggleUser)
 			r.Post("/users/{id}/delete", h.AdminDeleteUser)
 			r.Post("/users/{id}/promote", h.AdminPromoteUser)
+			r.Post("/users/{id}/campaign-limit", h.AdminSetCampaignLimit)
+			r.Post("/users/{id}/upload-types", h.AdminSetUploadTypes)
 			r.Get("/campaigns", h.AdminCampaigns)
+			r.Get("/search", h.AdminSearch)
 			r.Get("/audit", h.AdminAudit)
+			r.Get("/audit/export", h.AdminAuditExport)
 			r.Get("/storage", h.AdminStorage)
 			r.Get("/storage.json", h.AdminStorageJSON)
 		})
 	})
 
-	return r
+	root.Mount("/", r)
+	return root
 }
@@ -0,0 +1,51 @@
+package handler
+
+import (
+	"html/template"
+	"strings"
+
+	"github.com/microcosm-cc/bluemonday"
+)
+
+// maxDownloadMessageLen caps a campaign's download message, in bytes.
+const maxDownloadMessageLen = 5000
+
+var (
+	// messagePolicy is the allowlist for user-provided rich text shown on
+	// public pages: paragraphs, emphasis, lists, quotes and plain links.
+	// Scripts, event handlers, styles, images and iframes never survive.
+	messagePolicy = newMessagePolicy()
+	// plainPolicy strips every tag and leaves escaped text.
+	plainPolicy = bluemonday.StrictPolicy()
+)
+
+func newMessagePolicy() *bluemonday.Policy {
+	p := bluemonday.NewPolicy()
+	p.AllowElements("p", "br", "strong", "b", "em", "i", "u", "s",
+		"ul", "ol", "li", "blockquote", "code", "pre", "h3", "h4")
+	p.AllowAttrs("href").OnElements("a")
+	p.AllowURLSchemes("http", "https", "mailto")
+	p.RequireParseableURLs(true)
+	p.RequireNoFollowOnLinks(true)
+	p.AddTargetBlankToFullyQualifiedLinks(true)
+	return p
+}
+
+// sanitizeMessage renders user-provided message text for a public page. With
+// ALLOW_MESSAGE_HTML it keeps the formatting messagePolicy allows, otherwise
+// all markup is stripped. Line breaks are kept when the result is plain text.
+func (h *Handler) sanitizeMessage(s string) template.HTML {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return ""
+	}
+	policy := plainPolicy
+	if h.Cfg.AllowMessageHTML {
+		policy = messagePolicy
+	}
+	out := policy.Sanitize(s)
+	if !strings.Contains(out, "<") {
+		out = strings.ReplaceAll(strings.ReplaceAll(out, "\r\n", "\n"), "\n", "<br>")
+	}
+	return template.HTML(out)
+}
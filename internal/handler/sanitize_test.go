@@ -0,0 +1,71 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+func TestSanitizeMessage(t *testing.T) {
+	h := newTestHandler(t)
+	h.Cfg.AllowMessageHTML = true
+
+	tests := []struct {
+		in, want string
+	}{
+		{`<p>Hello <strong>Ann</strong>, <em>thanks</em>!</p>`, `<p>Hello <strong>Ann</strong>, <em>thanks</em>!</p>`},
+		{`<ul><li>one</li><li>two</li></ul>`, `<ul><li>one</li><li>two</li></ul>`},
+		{`Hi<script>alert(1)</script> there`, `Hi there`},
+		{`<p onclick="alert(1)" style="color:red">x</p>`, `<p>x</p>`},
+		{`<a href="javascript:alert(1)">x</a>`, `x`},
+		{`<a href="https://example.com/t">terms</a>`, `<a href="https://example.com/t" rel="nofollow noopener" target="_blank">terms</a>`},
+		{`<img src=x onerror=alert(1)><iframe src="https://evil.test"></iframe>ok`, `ok`},
+		{"line one\nline two", "line one<br>line two"},
+		{"  ", ""},
+	}
+	for _, tc := range tests {
+		if got := string(h.sanitizeMessage(tc.in)); got != tc.want {
+			t.Errorf("sanitizeMessage(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+
+	h.Cfg.AllowMessageHTML = false
+	if got := string(h.sanitizeMessage(`<p>Hello <strong>Ann</strong> & co<script>x()</script></p>`)); got != "Hello Ann &amp; co" {
+		t.Errorf("plain sanitizeMessage = %q", got)
+	}
+}
+
+func TestDownloadPageSanitizesMessage(t *testing.T) {
+	h := newTestHandler(t)
+	h.Cfg.AllowMessageHTML = true
+	seedAccount(t, h.DB, "acc", "member")
+	tokens := seedCampaign(t, h.DB, "acc", "camp", "READY", "r1")
+	if _, err := h.DB.Exec(`UPDATE campaigns SET download_message = ? WHERE id = 'camp'`,
+		`<p>Please read the <strong>embargo</strong>.</p><script>document.location="https://evil.test"</script>`); err != nil {
+		t.Fatal(err)
+	}
+	// Download links are addressed by UUID.
+	tokenID := uuid.New().String()
+	if _, err := h.DB.Exec(`UPDATE download_tokens SET id = ?, state = 'ACTIVE' WHERE id = ?`, tokenID, tokens[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	r := chi.NewRouter()
+	r.Get("/d/{token}", h.DownloadPage)
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/d/"+tokenID, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d", rec.Code)
+	}
+	body := rec.Body.String()
+	if strings.Contains(body, "evil.test") || strings.Contains(body, "<script>document") {
+		t.Error("download page contains the injected script")
+	}
+	if !strings.Contains(body, "<p>Please read the <strong>embargo</strong>.</p>") {
+		t.Error("download page lost the message formatting")
+	}
+}
@@ -0,0 +1,226 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/YannKr/downloadonce/internal/auth"
+	"github.com/YannKr/downloadonce/internal/db"
+	"github.com/YannKr/downloadonce/internal/model"
+)
+
+// notSchedulable is the problem with scheduling a campaign that is not a
+// draft.
+var notSchedulable = &publishProblem{http.StatusConflict, "CONFLICT", "only draft campaigns can be scheduled"}
+
+// scheduleProblem explains why c cannot be scheduled to publish at at, or
+// returns nil. Only drafts (or already scheduled campaigns) with recipients
+// can be scheduled, and with REQUIRE_APPROVAL only approved ones: the
+// scheduler cannot submit a campaign for approval on the owner's behalf.
+func (h *Handler) scheduleProblem(c *model.Campaign, at time.Time) (*publishProblem, error) {
+	switch {
+	case c.DeletedAt != nil:
+		return &publishProblem{http.StatusConflict, "CONFLICT", "campaign is deleted"}, nil
+	case c.State != "DRAFT" && c.State != "SCHEDULED":
+		return notSchedulable, nil
+	case h.needsApproval(c):
+		return &publishProblem{http.StatusConflict, "CONFLICT", "campaign must be approved before it can be scheduled"}, nil
+	case c.ExpiresAt != nil && !at.Before(*c.ExpiresAt):
+		return &publishProblem{http.StatusBadRequest, "BAD_REQUEST", "publish time must be before the campaign expires"}, nil
+	}
+	tokens, err := db.ListTokensByCampaign(h.DB, c.ID)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return &publishProblem{http.StatusBadRequest, "BAD_REQUEST", "no recipients attached"}, nil
+	}
+	return nil, nil
+}
+
+// CampaignSchedule handles POST /campaigns/{id}/schedule: the campaign is
+// published at publish_at (UTC) instead of right away.
+func (h *Handler) CampaignSchedule(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	accountID := auth.AccountFromContext(r.Context())
+
+	campaign, err := db.GetCampaign(h.DB, id)
+	if err != nil || campaign == nil || (campaign.AccountID != accountID && !auth.IsAdmin(r.Context())) {
+		http.NotFound(w, r)
+		return
+	}
+
+	at, err := time.Parse("2006-01-02T15:04", r.FormValue("publish_at"))
+	if err != nil {
+		h.setFlash(w, "Choose a date and time to publish at.")
+		http.Redirect(w, r, "/campaigns/"+id, http.StatusSeeOther)
+		return
+	}
+	problem, err := h.scheduleProblem(campaign, at)
+	if err == nil && problem == nil {
+		var ok bool
+		if ok, err = db.ScheduleCampaign(h.DB, id, at); err == nil && !ok {
+			problem = notSchedulable
+		}
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "schedule campaign", "error", err, "campaign", id)
+		http.Error(w, "Internal error", 500)
+		return
+	}
+	if problem != nil {
+		h.setFlash(w, "Cannot schedule: "+problem.msg+".")
+		http.Redirect(w, r, "/campaigns/"+id, http.StatusSeeOther)
+		return
+	}
+	db.InsertAuditLog(h.DB, accountID, "campaign_scheduled", "campaign", id,
+		campaign.Name+" at "+at.UTC().Format(time.RFC3339), r.RemoteAddr)
+	h.setFlash(w, "Campaign scheduled to publish on "+at.UTC().Format("2006-01-02 15:04 UTC")+".")
+	http.Redirect(w, r, "/campaigns/"+id, http.StatusSeeOther)
+}
+
+// APICampaignSchedule - POST /api/v1/campaigns/{id}/schedule
+//
+// Body {"publish_at": RFC 3339}. Moves a DRAFT campaign to SCHEDULED, or
+// moves the time of one already scheduled. A time in the past publishes on
+// the scheduler's next tick. POST /cancel returns it to DRAFT.
+func (h *Handler) APICampaignSchedule(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	accountID := auth.AccountFromContext(r.Context())
+
+	campaign, err := db.GetCampaign(h.DB, id)
+	if err != nil {
+		renderJSONError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "failed to get campaign")
+		return
+	}
+	if campaign == nil || (campaign.AccountID != accountID && !auth.IsAdmin(r.Context())) {
+		renderJSONError(w, http.StatusNotFound, "NOT_FOUND", "campaign not found")
+		return
+	}
+
+	var body struct {
+		PublishAt string `json:"publish_at"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		renderJSONError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid JSON body")
+		return
+	}
+	at, err := time.Parse(time.RFC3339, body.PublishAt)
+	if err != nil {
+		renderJSONError(w, http.StatusBadRequest, "BAD_REQUEST", "publish_at is required, use RFC3339")
+		return
+	}
+
+	problem, err := h.scheduleProblem(campaign, at)
+	if err == nil && problem == nil {
+		var ok bool
+		if ok, err = db.ScheduleCampaign(h.DB, id, at); err == nil && !ok {
+			problem = notSchedulable
+		}
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "api schedule campaign", "error", err, "campaign", id)
+		renderJSONError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "failed to schedule campaign")
+		return
+	}
+	if problem != nil {
+		renderJSONError(w, problem.status, problem.code, problem.msg)
+		return
+	}
+	db.InsertAuditLog(h.DB, accountID, "campaign_scheduled", "campaign", id,
+		campaign.Name+" at "+at.UTC().Format(time.RFC3339), r.RemoteAddr)
+	h.renderAPICampaign(w, http.StatusOK, id)
+}
+
+// StartScheduler publishes SCHEDULED campaigns once their publish_at has
+// passed, checking every interval until ctx is done.
+func (h *Handler) StartScheduler(ctx context.Context, interval time.Duration) {
+	go func() {
+		// Campaigns that came due while the server was down publish right away.
+		h.publishDueCampaigns(ctx)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				h.publishDueCampaigns(ctx)
+			}
+		}
+	}()
+	slog.Info("publish scheduler started", "interval", interval)
+}
+
+// publishDueCampaigns publishes every SCHEDULED campaign that is due.
+func (h *Handler) publishDueCampaigns(ctx context.Context) {
+	ids, err := db.ListDueScheduledCampaigns(h.DB, time.Now())
+	if err != nil {
+		slog.Error("list scheduled campaigns", "error", err)
+		return
+	}
+	for _, id := range ids {
+		if ctx.Err() != nil {
+			return
+		}
+		h.publishScheduled(ctx, id)
+	}
+}
+
+// publishScheduled publishes one due campaign the way CampaignPublish does,
+// on behalf of its owner. The campaign is returned to DRAFT first, which
+// also keeps a second tick from publishing it again; if it cannot be
+// published it stays there and the reason goes to the audit log.
+func (h *Handler) publishScheduled(ctx context.Context, id string) {
+	claimed, err := db.UnscheduleCampaign(h.DB, id)
+	if err != nil {
+		slog.Error("claim scheduled campaign", "error", err, "campaign", id)
+		return
+	}
+	if !claimed {
+		return // cancelled since it was listed
+	}
+	campaign, err := db.GetCampaign(h.DB, id)
+	if err != nil || campaign == nil {
+		slog.Error("load scheduled campaign", "error", err, "campaign", id)
+		return
+	}
+	fail := func(reason string) {
+		slog.Warn("scheduled publish failed", "campaign", id, "reason", reason)
+		db.InsertAuditLog(h.DB, campaign.AccountID, "campaign_schedule_failed", "campaign", id,
+			fmt.Sprintf("%s: %s", campaign.Name, reason), "")
+	}
+
+	tokens, err := db.ListTokensByCampaign(h.DB, id)
+	if err != nil {
+		slog.Error("list scheduled campaign tokens", "error", err, "campaign", id)
+		fail("internal error")
+		return
+	}
+	if len(tokens) == 0 {
+		fail("no recipients attached")
+		return
+	}
+	if h.needsApproval(campaign) {
+		fail("campaign is not approved")
+		return
+	}
+	queued, problem, err := h.startPublish(ctx, campaign, tokens)
+	if err != nil {
+		slog.Error("publish scheduled campaign", "error", err, "campaign", id)
+		fail("internal error")
+		return
+	}
+	if problem != nil {
+		fail(problem.msg)
+		return
+	}
+	db.InsertAuditLog(h.DB, campaign.AccountID, "campaign_published", "campaign", id, campaign.Name, "")
+	slog.Info("scheduled campaign published", "campaign", id, "queued", queued)
+}
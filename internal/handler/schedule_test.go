@@ -0,0 +1,84 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/YannKr/downloadonce/internal/db"
+)
+
+func TestAPICampaignSchedule(t *testing.T) {
+	h := newTestHandler(t)
+	seedAccount(t, h.DB, "acc", "member")
+	seedAccount(t, h.DB, "other", "member")
+	seedCampaign(t, h.DB, "acc", "camp", "DRAFT", "r1")
+	seedCampaign(t, h.DB, "acc", "later", "DRAFT", "r2")
+
+	r := chi.NewRouter()
+	r.Post("/api/v1/campaigns/{id}/schedule", h.APICampaignSchedule)
+	r.Post("/api/v1/campaigns/{id}/cancel", h.APICampaignCancel)
+	post := func(path, account, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, asAccount(httptest.NewRequest("POST", path, strings.NewReader(body)), account, "member"))
+		return rec
+	}
+	schedule := func(id string, at time.Time) *httptest.ResponseRecorder {
+		return post("/api/v1/campaigns/"+id+"/schedule", "acc", `{"publish_at":"`+at.UTC().Format(time.RFC3339)+`"}`)
+	}
+	state := func(id string) (string, int) {
+		t.Helper()
+		c, err := db.GetCampaign(h.DB, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		jobs, _, _, _ := db.CountJobsByCampaign(h.DB, id)
+		return c.State, jobs
+	}
+
+	if rec := post("/api/v1/campaigns/camp/schedule", "acc", `{"publish_at":"tomorrow"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("bad publish_at: status = %d, want 400", rec.Code)
+	}
+	if rec := post("/api/v1/campaigns/camp/schedule", "other", `{"publish_at":"2030-01-01T00:00:00Z"}`); rec.Code != http.StatusNotFound {
+		t.Errorf("other account: status = %d, want 404", rec.Code)
+	}
+
+	// A future time waits for its tick.
+	if rec := schedule("camp", time.Now().Add(time.Hour)); rec.Code != http.StatusOK {
+		t.Fatalf("schedule: status = %d, body %s", rec.Code, rec.Body)
+	}
+	h.publishDueCampaigns(context.Background())
+	if s, jobs := state("camp"); s != "SCHEDULED" || jobs != 0 {
+		t.Fatalf("before publish_at: state = %s, jobs = %d; want SCHEDULED, 0", s, jobs)
+	}
+
+	// Moving it into the past publishes it on the next tick, once.
+	if rec := schedule("camp", time.Now().Add(-time.Minute)); rec.Code != http.StatusOK {
+		t.Fatalf("reschedule: status = %d, body %s", rec.Code, rec.Body)
+	}
+	h.publishDueCampaigns(context.Background())
+	h.publishDueCampaigns(context.Background())
+	if s, jobs := state("camp"); s != "PROCESSING" || jobs != 1 {
+		t.Errorf("after due tick: state = %s, jobs = %d; want PROCESSING, 1", s, jobs)
+	}
+	if rec := schedule("camp", time.Now().Add(time.Hour)); rec.Code != http.StatusConflict {
+		t.Errorf("schedule published campaign: status = %d, want 409", rec.Code)
+	}
+
+	// A cancelled schedule is a draft again and is not published.
+	if rec := schedule("later", time.Now().Add(time.Hour)); rec.Code != http.StatusOK {
+		t.Fatalf("schedule later: status = %d, body %s", rec.Code, rec.Body)
+	}
+	if rec := post("/api/v1/campaigns/later/cancel", "acc", ""); rec.Code != http.StatusOK {
+		t.Fatalf("cancel: status = %d, body %s", rec.Code, rec.Body)
+	}
+	h.publishDueCampaigns(context.Background())
+	c, _ := db.GetCampaign(h.DB, "later")
+	if _, jobs := state("later"); c.State != "DRAFT" || c.PublishAt != nil || jobs != 0 {
+		t.Errorf("after cancel: state = %s, publish_at = %v, jobs = %d", c.State, c.PublishAt, jobs)
+	}
+}
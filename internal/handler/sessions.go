@@ -0,0 +1,147 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/YannKr/downloadonce/internal/auth"
+	"github.com/YannKr/downloadonce/internal/db"
+	"github.com/YannKr/downloadonce/internal/model"
+	"github.com/go-chi/chi/v5"
+)
+
+type sessionRow struct {
+	model.Session
+	Handle  string // sessionHandle(ID); the ID itself is the credential
+	Device  string
+	Current bool
+}
+
+// sessionHandle is the non-secret name of a session in the settings page and
+// its revoke URL: the first 16 hex digits of SHA-256(id). Session IDs are the
+// session credentials, so they never appear in HTML.
+func sessionHandle(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:8])
+}
+
+// accountSessions lists the account's sessions for the settings page,
+// flagging the one making the request.
+func (h *Handler) accountSessions(r *http.Request, accountID string) []sessionRow {
+	sessions, err := db.ListSessionsByAccount(h.DB, accountID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "list sessions", "error", err)
+		return nil
+	}
+	currentID, _ := auth.GetSessionID(r, h.Cfg.SessionSecret)
+	rows := make([]sessionRow, 0, len(sessions))
+	for _, s := range sessions {
+		rows = append(rows, sessionRow{Session: s, Handle: sessionHandle(s.ID), Device: describeUserAgent(s.UserAgent), Current: s.ID == currentID})
+	}
+	return rows
+}
+
+func (h *Handler) SessionRevoke(w http.ResponseWriter, r *http.Request) {
+	accountID := auth.AccountFromContext(r.Context())
+	handle := chi.URLParam(r, "handle")
+	sessions, err := db.ListSessionsByAccount(h.DB, accountID)
+	if err != nil {
+		http.Error(w, "Internal error", 500)
+		return
+	}
+	id := ""
+	for _, s := range sessions {
+		if sessionHandle(s.ID) == handle {
+			id = s.ID
+			break
+		}
+	}
+	if id == "" {
+		http.NotFound(w, r)
+		return
+	}
+	if currentID, _ := auth.GetSessionID(r, h.Cfg.SessionSecret); id == currentID {
+		h.setFlash(w, "Use Log out to end the current session.")
+		http.Redirect(w, r, "/settings", http.StatusSeeOther)
+		return
+	}
+	if _, err := db.DeleteAccountSession(h.DB, id, accountID); err != nil {
+		http.Error(w, "Internal error", 500)
+		return
+	}
+	db.InsertAuditLog(h.DB, accountID, "session_revoked", "account", accountID, "", r.RemoteAddr)
+	h.setFlash(w, "Session revoked.")
+	http.Redirect(w, r, "/settings", http.StatusSeeOther)
+}
+
+func (h *Handler) SessionRevokeOthers(w http.ResponseWriter, r *http.Request) {
+	accountID := auth.AccountFromContext(r.Context())
+	// API-key requests have no session; keepID is then empty and every
+	// session is revoked.
+	currentID, _ := auth.GetSessionID(r, h.Cfg.SessionSecret)
+	n, err := db.DeleteOtherSessions(h.DB, accountID, currentID)
+	if err != nil {
+		http.Error(w, "Internal error", 500)
+		return
+	}
+	db.InsertAuditLog(h.DB, accountID, "sessions_revoked", "account", accountID, fmt.Sprintf("%d other sessions", n), r.RemoteAddr)
+	h.setFlash(w, fmt.Sprintf("Revoked %d other session(s).", n))
+	http.Redirect(w, r, "/settings", http.StatusSeeOther)
+}
+
+// describeUserAgent reduces a User-Agent header to "Browser on OS" for the
+// session list. It is a display hint only, not a security control.
+func describeUserAgent(ua string) string {
+	if ua == "" {
+		return "Unknown device"
+	}
+	browser := "Unknown browser"
+	for _, b := range []struct{ token, name string }{
+		{"Edg/", "Edge"},
+		{"OPR/", "Opera"},
+		{"Firefox/", "Firefox"},
+		{"Chrome/", "Chrome"},
+		{"Safari/", "Safari"},
+		{"curl/", "curl"},
+	} {
+		if strings.Contains(ua, b.token) {
+			browser = b.name
+			break
+		}
+	}
+	os := ""
+	for _, o := range []struct{ token, name string }{
+		{"Android", "Android"},
+		{"iPhone", "iOS"},
+		{"iPad", "iPadOS"},
+		{"Windows", "Windows"},
+		{"Mac OS X", "macOS"},
+		{"CrOS", "ChromeOS"},
+		{"Linux", "Linux"},
+	} {
+		if strings.Contains(ua, o.token) {
+			os = o.name
+			break
+		}
+	}
+	if os == "" {
+		return browser
+	}
+	return browser + " on " + os
+}
+
+// truncate shortens s to at most n bytes without splitting a UTF-8 sequence.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
@@ -0,0 +1,89 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/YannKr/downloadonce/internal/auth"
+	"github.com/YannKr/downloadonce/internal/db"
+	"github.com/YannKr/downloadonce/internal/model"
+	"github.com/go-chi/chi/v5"
+)
+
+func withSessionCookie(h *Handler, r *http.Request, sessionID string) *http.Request {
+	rec := httptest.NewRecorder()
+	auth.SetSessionCookie(rec, sessionID, h.Cfg.SessionSecret)
+	for _, c := range rec.Result().Cookies() {
+		r.AddCookie(c)
+	}
+	return r
+}
+
+func TestSessionRevoke(t *testing.T) {
+	h := newTestHandler(t)
+	seedAccount(t, h.DB, "acc", "member")
+	seedAccount(t, h.DB, "other", "member")
+	for _, s := range []model.Session{
+		{ID: "sess-laptop", AccountID: "acc", UserAgent: "Mozilla/5.0 (Macintosh; Intel Mac OS X 14_0) Gecko/20100101 Firefox/128.0", IP: "10.0.0.1"},
+		{ID: "sess-phone", AccountID: "acc", UserAgent: "Mozilla/5.0 (Linux; Android 14) Chrome/126.0 Mobile Safari/537.36"},
+		{ID: "sess-kiosk", AccountID: "acc", UserAgent: "Mozilla/5.0 (Windows NT 10.0) Chrome/126.0 Safari/537.36 Edg/126.0"},
+		{ID: "sess-other", AccountID: "other"},
+	} {
+		s.ExpiresAt = time.Now().Add(time.Hour)
+		if err := db.CreateSession(h.DB, &s); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := chi.NewRouter()
+	r.Get("/settings", h.SettingsPage)
+	r.Post("/settings/sessions/{handle}/revoke", h.SessionRevoke)
+	r.Post("/settings/sessions/revoke-others", h.SessionRevokeOthers)
+	do := func(method, path string) *httptest.ResponseRecorder {
+		req := withSessionCookie(h, httptest.NewRequest(method, path, nil), "sess-laptop")
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, asAccount(req, "acc", "member"))
+		return rec
+	}
+
+	body := do("GET", "/settings").Body.String()
+	for _, want := range []string{"Firefox on macOS", "Chrome on Android", "Edge on Windows", "This session", "10.0.0.1"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("settings page missing %q", want)
+		}
+	}
+	if strings.Contains(body, sessionHandle("sess-other")) {
+		t.Error("settings page lists another account's session")
+	}
+	if strings.Contains(body, "sess-phone") || !strings.Contains(body, "/settings/sessions/"+sessionHandle("sess-phone")+"/revoke") {
+		t.Error("settings page does not name sessions by handle only")
+	}
+
+	if rec := do("POST", "/settings/sessions/"+sessionHandle("sess-other")+"/revoke"); rec.Code != http.StatusNotFound {
+		t.Errorf("revoking another account's session: status = %d, want 404", rec.Code)
+	}
+	if rec := do("POST", "/settings/sessions/sess-phone/revoke"); rec.Code != http.StatusNotFound {
+		t.Errorf("revoking by raw session ID: status = %d, want 404", rec.Code)
+	}
+	do("POST", "/settings/sessions/"+sessionHandle("sess-laptop")+"/revoke")
+	if s, _ := db.GetSession(h.DB, "sess-laptop"); s == nil {
+		t.Error("current session was revoked by the per-row button")
+	}
+
+	do("POST", "/settings/sessions/"+sessionHandle("sess-phone")+"/revoke")
+	if s, _ := db.GetSession(h.DB, "sess-phone"); s != nil {
+		t.Error("sess-phone still exists after revoke")
+	}
+
+	do("POST", "/settings/sessions/revoke-others")
+	sessions, _ := db.ListSessionsByAccount(h.DB, "acc")
+	if len(sessions) != 1 || sessions[0].ID != "sess-laptop" {
+		t.Errorf("after revoke-others: %+v, want only sess-laptop", sessions)
+	}
+	if s, _ := db.GetSession(h.DB, "sess-other"); s == nil {
+		t.Error("revoke-others removed another account's session")
+	}
+}
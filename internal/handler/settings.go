@@ -1,15 +1,19 @@
 package handler
 
 import (
+	"fmt"
 	"log/slog"
 	"net/http"
+	"slices"
 	"strconv"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
 	"github.com/YannKr/downloadonce/internal/auth"
 	"github.com/YannKr/downloadonce/internal/db"
 	"github.com/YannKr/downloadonce/internal/model"
+	"github.com/YannKr/downloadonce/internal/webhook"
 )
 
 type settingsData struct {
@@ -18,8 +22,36 @@ type settingsData struct {
 	NewAPIKey           string
 	SMTPEnabled         bool
 	NotifyOnDownload    bool
+	NotifyDigestMins    int
+	TOTPEnabled         bool
+	Groups              []model.RecipientGroupSummary
+	DefaultGroupID      string
+	Sessions            []sessionRow
 	WebhookLastDelivery map[string]*model.WebhookDelivery
 	ExhaustedDeliveries int
+
+	retrySchedule []time.Duration // the webhook dispatcher's
+}
+
+// WebhookRetrySchedule is the configured retry delays, e.g. "30s, 5m, 30m, 2h".
+func (d settingsData) WebhookRetrySchedule() string {
+	return webhook.FormatBackoff(d.retrySchedule)
+}
+
+// WebhookMaxAttempts is how many times a delivery is tried before it is
+// marked exhausted.
+func (d settingsData) WebhookMaxAttempts() int {
+	return len(d.retrySchedule) + 1
+}
+
+// WebhookGiveUpAfter is roughly how long after the first attempt a failing
+// delivery is given up on.
+func (d settingsData) WebhookGiveUpAfter() string {
+	var total time.Duration
+	for _, delay := range d.retrySchedule {
+		total += delay
+	}
+	return webhook.FormatBackoff([]time.Duration{total})
 }
 
 func (h *Handler) SettingsPage(w http.ResponseWriter, r *http.Request) {
@@ -28,10 +60,13 @@ func (h *Handler) SettingsPage(w http.ResponseWriter, r *http.Request) {
 	webhooks, _ := db.ListWebhooks(h.DB, accountID)
 	account, _ := db.GetAccountByID(h.DB, accountID)
 
-	notifyOn := false
+	notifyOn, totpOn, defaultGroup := false, false, ""
 	if account != nil {
 		notifyOn = account.NotifyOnDownload
+		totpOn = account.TOTPSecret != ""
+		defaultGroup = account.DefaultGroupID
 	}
+	groups, _ := db.ListRecipientGroups(h.DB, accountID)
 
 	lastDelivery, _ := db.GetLastDeliveryPerWebhook(h.DB, accountID)
 	exhausted, _ := db.CountExhaustedDeliveriesLast24h(h.DB, accountID)
@@ -41,8 +76,15 @@ func (h *Handler) SettingsPage(w http.ResponseWriter, r *http.Request) {
 		Webhooks:            webhooks,
 		SMTPEnabled:         h.Cfg.SMTPHost != "",
 		NotifyOnDownload:    notifyOn,
+		NotifyDigestMins:    h.Cfg.NotifyDigestMins,
+		TOTPEnabled:         totpOn,
+		Groups:              groups,
+		DefaultGroupID:      defaultGroup,
+		Sessions:            h.accountSessions(r, accountID),
 		WebhookLastDelivery: lastDelivery,
 		ExhaustedDeliveries: exhausted,
+
+		retrySchedule: h.Webhook.RetrySchedule(),
 	})
 }
 
@@ -52,6 +94,31 @@ func (h *Handler) APIKeyCreate(w http.ResponseWriter, r *http.Request) {
 	if name == "" {
 		name = "Unnamed key"
 	}
+	scope := r.FormValue("scope")
+	if !auth.ValidScope(scope) {
+		scope = auth.ScopeWrite
+	}
+	// Keys are issued from a browser session only: a leaked key must not be
+	// able to mint more keys, least of all with a wider scope.
+	if auth.APIKeyFromContext(r.Context()) != "" || !auth.HasScope(r.Context(), scope) {
+		http.Error(w, "API keys cannot create API keys", http.StatusForbidden)
+		return
+	}
+	if scope == auth.ScopeAdmin && !auth.IsAdmin(r.Context()) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	// ttl_days: blank or 0 for a key that never expires.
+	var expiresAt *time.Time
+	if v := r.FormValue("ttl_days"); v != "" && v != "0" {
+		days, err := strconv.Atoi(v)
+		if err != nil || days < 0 || days > 3650 {
+			http.Error(w, "Invalid expiry", http.StatusBadRequest)
+			return
+		}
+		t := time.Now().UTC().AddDate(0, 0, days)
+		expiresAt = &t
+	}
 
 	rawKey, err := auth.GenerateToken(32)
 	if err != nil {
@@ -73,13 +140,15 @@ func (h *Handler) APIKeyCreate(w http.ResponseWriter, r *http.Request) {
 		Name:      name,
 		KeyPrefix: prefix,
 		KeyHash:   hash,
+		Scopes:    scope,
+		ExpiresAt: expiresAt,
 	}
 	if err := db.CreateAPIKey(h.DB, apiKey); err != nil {
 		http.Error(w, "Internal error", 500)
 		return
 	}
 
-	db.InsertAuditLog(h.DB, accountID, "api_key_created", "api_key", apiKey.ID, name, r.RemoteAddr)
+	db.InsertAuditLog(h.DB, accountID, "api_key_created", "api_key", apiKey.ID, name+" ("+scope+")", r.RemoteAddr)
 
 	keys, _ := db.ListAPIKeys(h.DB, accountID)
 	webhooks, _ := db.ListWebhooks(h.DB, accountID)
@@ -95,6 +164,8 @@ func (h *Handler) APIKeyCreate(w http.ResponseWriter, r *http.Request) {
 			Webhooks:    webhooks,
 			NewAPIKey:   fullKey,
 			SMTPEnabled: h.Cfg.SMTPHost != "",
+
+			retrySchedule: h.Webhook.RetrySchedule(),
 		},
 	})
 }
@@ -104,10 +175,39 @@ func (h *Handler) APIKeyDelete(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 	db.DeleteAPIKey(h.DB, id, accountID)
 	db.InsertAuditLog(h.DB, accountID, "api_key_deleted", "api_key", id, "", r.RemoteAddr)
-	setFlash(w, "API key deleted.")
+	h.setFlash(w, "API key deleted.")
+	http.Redirect(w, r, "/settings", http.StatusSeeOther)
+}
+
+// APIKeyRevokeAll - POST /settings/apikeys/revoke-all
+//
+// Deletes every API key of the account at once, e.g. after a compromise.
+func (h *Handler) APIKeyRevokeAll(w http.ResponseWriter, r *http.Request) {
+	accountID := auth.AccountFromContext(r.Context())
+	n, err := h.revokeAPIKeys(r, accountID, accountID, "from settings")
+	if err != nil {
+		http.Error(w, "Internal error", 500)
+		return
+	}
+	h.setFlash(w, fmt.Sprintf("Revoked %d API key(s). Issue new keys to restore API access.", n))
 	http.Redirect(w, r, "/settings", http.StatusSeeOther)
 }
 
+// revokeAPIKeys deletes all of targetID's API keys and records it in the
+// audit log as actorID, noting why. Nothing is logged when there were none.
+func (h *Handler) revokeAPIKeys(r *http.Request, actorID, targetID, why string) (int, error) {
+	n, err := db.DeleteAPIKeysByAccount(h.DB, targetID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "revoke api keys", "error", err, "account", targetID)
+		return 0, err
+	}
+	if n > 0 {
+		db.InsertAuditLog(h.DB, actorID, "api_keys_revoked", "account", targetID,
+			fmt.Sprintf("%d API key(s) %s", n, why), r.RemoteAddr)
+	}
+	return n, nil
+}
+
 func (h *Handler) WebhookCreate(w http.ResponseWriter, r *http.Request) {
 	accountID := auth.AccountFromContext(r.Context())
 	url := r.FormValue("url")
@@ -150,7 +250,7 @@ func (h *Handler) WebhookCreate(w http.ResponseWriter, r *http.Request) {
 
 	db.InsertAuditLog(h.DB, accountID, "webhook_created", "webhook", wh.ID, url, r.RemoteAddr)
 
-	setFlash(w, "Webhook created.")
+	h.setFlash(w, "Webhook created.")
 	http.Redirect(w, r, "/settings", http.StatusSeeOther)
 }
 
@@ -159,7 +259,7 @@ func (h *Handler) WebhookDelete(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 	db.DeleteWebhook(h.DB, id, accountID)
 	db.InsertAuditLog(h.DB, accountID, "webhook_deleted", "webhook", id, "", r.RemoteAddr)
-	setFlash(w, "Webhook deleted.")
+	h.setFlash(w, "Webhook deleted.")
 	http.Redirect(w, r, "/settings", http.StatusSeeOther)
 }
 
@@ -167,13 +267,46 @@ func (h *Handler) NotifyOnDownloadUpdate(w http.ResponseWriter, r *http.Request)
 	accountID := auth.AccountFromContext(r.Context())
 	notify := r.FormValue("notify_on_download") == "1"
 	db.UpdateAccountNotifyOnDownload(h.DB, accountID, notify)
-	setFlash(w, "Notification preference saved.")
+	h.setFlash(w, "Notification preference saved.")
 	http.Redirect(w, r, "/settings", http.StatusSeeOther)
 }
 
+func (h *Handler) DefaultGroupUpdate(w http.ResponseWriter, r *http.Request) {
+	accountID := auth.AccountFromContext(r.Context())
+	groupID := r.FormValue("group_id")
+	if groupID != "" {
+		group, err := db.GetRecipientGroupByID(h.DB, groupID)
+		if err != nil || group == nil || group.AccountID != accountID {
+			h.setFlash(w, "Group not found.")
+			http.Redirect(w, r, "/settings", http.StatusSeeOther)
+			return
+		}
+	}
+	if err := db.SetAccountDefaultGroup(h.DB, accountID, groupID); err != nil {
+		http.Error(w, "Internal error", 500)
+		return
+	}
+	h.setFlash(w, "Default recipient group saved.")
+	http.Redirect(w, r, "/settings", http.StatusSeeOther)
+}
+
+// webhookDeliveryStates are the values accepted by the ?state= filter of the
+// delivery history.
+var webhookDeliveryStates = []string{"pending", "failed", "delivered", "exhausted"}
+
+// parseDeliveryState returns the ?state= filter ("" for all) and whether it
+// names a known state.
+func parseDeliveryState(r *http.Request) (string, bool) {
+	state := r.URL.Query().Get("state")
+	return state, state == "" || slices.Contains(webhookDeliveryStates, state)
+}
+
 type deliveriesData struct {
 	Webhook    model.Webhook
 	Deliveries []model.WebhookDelivery
+	States     []string
+	State      string
+	Exhausted  int
 	Total      int
 	Page       int
 	PerPage    int
@@ -192,6 +325,12 @@ func (h *Handler) WebhookDeliveries(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	state, ok := parseDeliveryState(r)
+	if !ok {
+		http.Error(w, "Unknown delivery state", http.StatusBadRequest)
+		return
+	}
+
 	page := 1
 	if p, _ := strconv.Atoi(r.URL.Query().Get("page")); p > 0 {
 		page = p
@@ -199,10 +338,11 @@ func (h *Handler) WebhookDeliveries(w http.ResponseWriter, r *http.Request) {
 	perPage := 50
 	offset := (page - 1) * perPage
 
-	total, _ := db.CountWebhookDeliveries(h.DB, whID)
-	deliveries, err := db.ListWebhookDeliveries(h.DB, whID, perPage, offset)
+	total, _ := db.CountWebhookDeliveries(h.DB, whID, state)
+	exhausted, _ := db.CountWebhookDeliveries(h.DB, whID, "exhausted")
+	deliveries, err := db.ListWebhookDeliveries(h.DB, whID, state, perPage, offset)
 	if err != nil {
-		slog.Error("list webhook deliveries", "error", err)
+		slog.ErrorContext(r.Context(), "list webhook deliveries", "error", err)
 		http.Error(w, "Internal error", 500)
 		return
 	}
@@ -224,6 +364,9 @@ func (h *Handler) WebhookDeliveries(w http.ResponseWriter, r *http.Request) {
 	h.renderAuth(w, r, "webhook_deliveries.html", "Delivery History", deliveriesData{
 		Webhook:    *wh,
 		Deliveries: deliveries,
+		States:     webhookDeliveryStates,
+		State:      state,
+		Exhausted:  exhausted,
 		Total:      total,
 		Page:       page,
 		PerPage:    perPage,
@@ -256,12 +399,40 @@ func (h *Handler) WebhookDeliveryReplay(w http.ResponseWriter, r *http.Request)
 	}
 
 	if err := db.ReplayWebhookDelivery(h.DB, deliveryID); err != nil {
-		slog.Error("replay webhook delivery", "error", err)
+		slog.ErrorContext(r.Context(), "replay webhook delivery", "error", err)
 		http.Error(w, "Internal error", 500)
 		return
 	}
 
 	db.InsertAuditLog(h.DB, accountID, "webhook_delivery_replayed", "webhook_delivery", deliveryID, wh.URL, r.RemoteAddr)
-	setFlash(w, "Delivery re-queued.")
+	h.setFlash(w, "Delivery re-queued.")
+	http.Redirect(w, r, "/settings/webhooks/"+whID+"/deliveries", http.StatusSeeOther)
+}
+
+// WebhookReplayAll re-queues every exhausted delivery of the webhook, e.g.
+// after the receiving endpoint comes back from an outage.
+func (h *Handler) WebhookReplayAll(w http.ResponseWriter, r *http.Request) {
+	whID := chi.URLParam(r, "id")
+	accountID := auth.AccountFromContext(r.Context())
+
+	wh, err := db.GetWebhookByID(h.DB, whID)
+	if err != nil || wh == nil || (wh.AccountID != accountID && !auth.IsAdmin(r.Context())) {
+		http.NotFound(w, r)
+		return
+	}
+
+	n, err := db.ReplayExhaustedWebhookDeliveries(h.DB, whID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "replay exhausted webhook deliveries", "error", err)
+		http.Error(w, "Internal error", 500)
+		return
+	}
+
+	if n == 0 {
+		h.setFlash(w, "No exhausted deliveries to replay.")
+	} else {
+		db.InsertAuditLog(h.DB, accountID, "webhook_deliveries_replayed", "webhook", whID, fmt.Sprintf("%s (%d deliveries)", wh.URL, n), r.RemoteAddr)
+		h.setFlash(w, fmt.Sprintf("%d exhausted deliveries re-queued.", n))
+	}
 	http.Redirect(w, r, "/settings/webhooks/"+whID+"/deliveries", http.StatusSeeOther)
 }
@@ -0,0 +1,85 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/YannKr/downloadonce/internal/db"
+	"github.com/YannKr/downloadonce/internal/model"
+)
+
+func TestRevokeAPIKeys(t *testing.T) {
+	h := newTestHandler(t)
+	seedAccount(t, h.DB, "admin", "admin")
+	for _, acc := range []string{"reset", "keep", "disabled", "self"} {
+		seedAccount(t, h.DB, acc, "member")
+		for _, name := range []string{"ci", "backup"} {
+			if err := db.CreateAPIKey(h.DB, &model.APIKey{
+				ID: acc + "-" + name, AccountID: acc, Name: name, KeyPrefix: acc[:3] + name[:2] + "xxx", KeyHash: "x", Scopes: "read",
+			}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	keys := func(acc string) int {
+		t.Helper()
+		k, err := db.ListAPIKeys(h.DB, acc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(k)
+	}
+
+	r := chi.NewRouter()
+	r.Post("/reset-password", h.ResetPasswordSubmit)
+	r.Post("/admin/users/{id}/toggle", h.AdminToggleUser)
+	r.Post("/settings/apikeys/revoke-all", h.APIKeyRevokeAll)
+	post := func(req *http.Request) {
+		t.Helper()
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		if rec.Code != http.StatusSeeOther {
+			t.Fatalf("%s: status = %d, body %s", req.URL.Path, rec.Code, rec.Body)
+		}
+	}
+	reset := func(acc string, revoke bool) {
+		t.Helper()
+		token := "tok-" + acc
+		if err := db.CreatePasswordReset(h.DB, "pr-"+acc, acc, db.HashToken(token), time.Now().Add(time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+		form := url.Values{"token": {token}, "password": {"new-password"}, "password_confirm": {"new-password"}}
+		if revoke {
+			form.Set("revoke_api_keys", "1")
+		}
+		post(httptest.NewRequest("POST", "/reset-password", strings.NewReader(form.Encode())))
+	}
+
+	reset("keep", false)
+	if n := keys("keep"); n != 2 {
+		t.Errorf("reset without the option: %d keys left, want 2", n)
+	}
+	reset("reset", true)
+	if n := keys("reset"); n != 0 {
+		t.Errorf("reset with the option: %d keys left, want 0", n)
+	}
+
+	post(asAccount(httptest.NewRequest("POST", "/admin/users/disabled/toggle", nil), "admin", "admin"))
+	if n := keys("disabled"); n != 0 {
+		t.Errorf("disabled account: %d keys left, want 0", n)
+	}
+
+	post(asAccount(httptest.NewRequest("POST", "/settings/apikeys/revoke-all", nil), "self", "member"))
+	if n := keys("self"); n != 0 {
+		t.Errorf("revoke all: %d keys left, want 0", n)
+	}
+	if n := keys("keep"); n != 2 {
+		t.Errorf("other account lost keys: %d left, want 2", n)
+	}
+}
@@ -0,0 +1,184 @@
+package handler
+
+import (
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/YannKr/downloadonce/internal/auth"
+	"github.com/YannKr/downloadonce/internal/db"
+)
+
+type twoFactorData struct {
+	Enabled        bool
+	Secret         string       // pending secret while enrolling
+	QRCode         template.URL // otpauth URL as an inline PNG
+	RecoveryCodes  []string     // shown once, right after enabling
+	RecoveryUnused int
+}
+
+// LoginTOTPForm - GET /login/2fa
+func (h *Handler) LoginTOTPForm(w http.ResponseWriter, r *http.Request) {
+	if _, _, ok := auth.GetPendingLogin(r, h.Cfg.SessionSecret); !ok {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	h.render(w, r, "login_2fa.html", PageData{Title: "Two-factor authentication",
+		Data: map[string]interface{}{"Next": h.safeRedirect(r.URL.Query().Get("next"), "")}})
+}
+
+// LoginTOTPSubmit - POST /login/2fa
+// Accepts either a current TOTP code or an unused recovery code.
+func (h *Handler) LoginTOTPSubmit(w http.ResponseWriter, r *http.Request) {
+	accountID, lastStep, ok := auth.GetPendingLogin(r, h.Cfg.SessionSecret)
+	if !ok {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	account, err := db.GetAccountByID(h.DB, accountID)
+	// A second factor accepted since the cookie was set means it was used.
+	if err != nil || account == nil || !account.Enabled || account.TOTPSecret == "" || account.TOTPLastStep != lastStep {
+		auth.ClearPendingLoginCookie(w)
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	code := strings.TrimSpace(r.FormValue("code"))
+	next := h.safeRedirect(r.FormValue("next"), "")
+	formData := map[string]interface{}{"Next": next}
+	detail := ""
+	step, valid := auth.ValidateTOTPAfter(code, account.TOTPSecret, lastStep)
+	if !valid && code != "" {
+		valid, err = db.UseRecoveryCode(h.DB, account.ID, strings.ToLower(code))
+		if err != nil {
+			slog.ErrorContext(r.Context(), "use recovery code", "error", err)
+		}
+		detail = "recovery code"
+		// Spend the current step too, which retires this pending login.
+		step = max(auth.TOTPStep(time.Now()), lastStep+1)
+	}
+	if valid {
+		valid, err = db.AcceptTOTPStep(h.DB, account.ID, lastStep, step)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "accept totp step", "error", err)
+		}
+	}
+	if !valid {
+		db.InsertAuditLog(h.DB, account.ID, "login_2fa_failed", "account", account.ID, "", r.RemoteAddr)
+		h.render(w, r, "login_2fa.html", PageData{Title: "Two-factor authentication", Error: "Invalid code.", Data: formData})
+		return
+	}
+
+	auth.ClearPendingLoginCookie(w)
+	if err := h.startSession(w, r, account.ID); err != nil {
+		slog.ErrorContext(r.Context(), "create session", "error", err)
+		h.render(w, r, "login_2fa.html", PageData{Title: "Two-factor authentication", Error: "Internal error.", Data: formData})
+		return
+	}
+	db.InsertAuditLog(h.DB, account.ID, "login", "account", account.ID, detail, r.RemoteAddr)
+	http.Redirect(w, r, h.safeRedirect(next, "/dashboard"), http.StatusSeeOther)
+}
+
+// TwoFactorPage - GET /settings/2fa
+// Shows the enrollment QR code, or the current status once enabled.
+func (h *Handler) TwoFactorPage(w http.ResponseWriter, r *http.Request) {
+	account, err := db.GetAccountByID(h.DB, auth.AccountFromContext(r.Context()))
+	if err != nil || account == nil {
+		http.Error(w, "Internal error", 500)
+		return
+	}
+	if account.TOTPSecret != "" {
+		unused, _ := db.CountUnusedRecoveryCodes(h.DB, account.ID)
+		h.renderAuth(w, r, "settings_2fa.html", "Two-factor authentication", twoFactorData{Enabled: true, RecoveryUnused: unused})
+		return
+	}
+
+	secret, err := auth.GenerateTOTPSecret(account.Email)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "generate totp secret", "error", err)
+		http.Error(w, "Internal error", 500)
+		return
+	}
+	h.renderTwoFactorEnroll(w, r, account.Email, secret, "")
+}
+
+func (h *Handler) renderTwoFactorEnroll(w http.ResponseWriter, r *http.Request, email, secret, errMsg string) {
+	qrCode, err := qrDataURL(auth.TOTPURL(email, secret), qrDefaultSize)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "totp qr", "error", err)
+	}
+	h.render(w, r, "settings_2fa.html", PageData{
+		Title: "Two-factor authentication", Authenticated: true,
+		IsAdmin: auth.IsAdmin(r.Context()), UserName: auth.NameFromContext(r.Context()),
+		Error: errMsg,
+		Data:  twoFactorData{Secret: secret, QRCode: qrCode},
+	})
+}
+
+// TwoFactorEnable - POST /settings/2fa
+// Verifies a code for the secret shown on the enrollment page before saving
+// it, so a mistyped scan cannot lock the account out.
+func (h *Handler) TwoFactorEnable(w http.ResponseWriter, r *http.Request) {
+	accountID := auth.AccountFromContext(r.Context())
+	account, err := db.GetAccountByID(h.DB, accountID)
+	if err != nil || account == nil {
+		http.Error(w, "Internal error", 500)
+		return
+	}
+	if account.TOTPSecret != "" {
+		http.Redirect(w, r, "/settings/2fa", http.StatusSeeOther)
+		return
+	}
+
+	secret := strings.TrimSpace(r.FormValue("secret"))
+	if secret == "" {
+		http.Redirect(w, r, "/settings/2fa", http.StatusSeeOther)
+		return
+	}
+	if !auth.ValidateTOTP(r.FormValue("code"), secret) {
+		h.renderTwoFactorEnroll(w, r, account.Email, secret, "That code did not match. Check the time on your device and try again.")
+		return
+	}
+
+	codes, err := auth.GenerateRecoveryCodes(auth.RecoveryCodeCount)
+	if err != nil {
+		http.Error(w, "Internal error", 500)
+		return
+	}
+	if err := db.EnableTOTP(h.DB, accountID, secret, codes); err != nil {
+		slog.ErrorContext(r.Context(), "enable totp", "error", err)
+		http.Error(w, "Internal error", 500)
+		return
+	}
+	db.InsertAuditLog(h.DB, accountID, "2fa_enabled", "account", accountID, "", r.RemoteAddr)
+
+	h.renderAuth(w, r, "settings_2fa.html", "Two-factor authentication", twoFactorData{
+		Enabled: true, RecoveryCodes: codes, RecoveryUnused: len(codes),
+	})
+}
+
+// TwoFactorDisable - POST /settings/2fa/disable
+// Requires the account password.
+func (h *Handler) TwoFactorDisable(w http.ResponseWriter, r *http.Request) {
+	accountID := auth.AccountFromContext(r.Context())
+	account, err := db.GetAccountByID(h.DB, accountID)
+	if err != nil || account == nil {
+		http.Error(w, "Internal error", 500)
+		return
+	}
+	if !auth.CheckPassword(account.PasswordHash, r.FormValue("password")) {
+		h.setFlash(w, "Incorrect password; two-factor authentication is still enabled.")
+		http.Redirect(w, r, "/settings/2fa", http.StatusSeeOther)
+		return
+	}
+	if err := db.DisableTOTP(h.DB, accountID); err != nil {
+		slog.ErrorContext(r.Context(), "disable totp", "error", err)
+		http.Error(w, "Internal error", 500)
+		return
+	}
+	db.InsertAuditLog(h.DB, accountID, "2fa_disabled", "account", accountID, "", r.RemoteAddr)
+	h.setFlash(w, "Two-factor authentication disabled.")
+	http.Redirect(w, r, "/settings", http.StatusSeeOther)
+}
@@ -0,0 +1,179 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pquerna/otp/totp"
+	"github.com/YannKr/downloadonce/internal/auth"
+	"github.com/YannKr/downloadonce/internal/db"
+	"github.com/YannKr/downloadonce/internal/model"
+)
+
+func postForm(path string, form url.Values) *http.Request {
+	req := httptest.NewRequest("POST", path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req
+}
+
+// seedPasswordAccount creates an enabled member account with a real password.
+func seedPasswordAccount(t *testing.T, h *Handler, id, password string) {
+	t.Helper()
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreateAccount(h.DB, &model.Account{
+		ID: id, Email: id + "@example.com", Name: id, PasswordHash: hash, Role: "member", Enabled: true,
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTwoFactorEnrollAndLogin(t *testing.T) {
+	h := newTestHandler(t)
+	seedPasswordAccount(t, h, "acc", "correct horse")
+
+	secret, err := auth.GenerateTOTPSecret("acc@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Enroll: a wrong code is rejected and nothing is saved.
+	rec := httptest.NewRecorder()
+	h.TwoFactorEnable(rec, asAccount(postForm("/settings/2fa", url.Values{"secret": {secret}, "code": {"000000"}}), "acc", "member"))
+	if a, _ := db.GetAccountByID(h.DB, "acc"); a.TOTPSecret != "" {
+		t.Fatal("secret saved despite a wrong code")
+	}
+
+	code, _ := totp.GenerateCode(secret, time.Now())
+	rec = httptest.NewRecorder()
+	h.TwoFactorEnable(rec, asAccount(postForm("/settings/2fa", url.Values{"secret": {secret}, "code": {code}}), "acc", "member"))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("enable: status = %d", rec.Code)
+	}
+	if a, _ := db.GetAccountByID(h.DB, "acc"); a.TOTPSecret != secret {
+		t.Fatal("secret not saved after a valid code")
+	}
+	if n, _ := db.CountUnusedRecoveryCodes(h.DB, "acc"); n != auth.RecoveryCodeCount {
+		t.Fatalf("recovery codes = %d, want %d", n, auth.RecoveryCodeCount)
+	}
+	// The recovery codes are shown once on the enable page.
+	body := rec.Body.String()
+	i := strings.Index(body, "<pre")
+	if i < 0 {
+		t.Fatal("recovery codes not shown")
+	}
+	recovery := strings.Fields(body[strings.Index(body[i:], ">")+i+1:])[0]
+
+	// Password alone no longer creates a session.
+	login := func() *http.Cookie {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		h.LoginSubmit(rec, postForm("/login", url.Values{"email": {"acc@example.com"}, "password": {"correct horse"}}))
+		if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/login/2fa" {
+			t.Fatalf("login: status = %d, Location %q", rec.Code, rec.Header().Get("Location"))
+		}
+		var pending *http.Cookie
+		for _, c := range rec.Result().Cookies() {
+			if c.Name == auth.CookieName {
+				t.Fatal("session cookie set before the second factor")
+			}
+			if c.Name == auth.PendingLoginCookieName {
+				pending = c
+			}
+		}
+		if pending == nil {
+			t.Fatal("no pending-login cookie")
+		}
+		return pending
+	}
+
+	submit := func(pending *http.Cookie, code string) *httptest.ResponseRecorder {
+		req := postForm("/login/2fa", url.Values{"code": {code}})
+		req.AddCookie(pending)
+		rec := httptest.NewRecorder()
+		h.LoginTOTPSubmit(rec, req)
+		return rec
+	}
+	hasSession := func(rec *httptest.ResponseRecorder) bool {
+		for _, c := range rec.Result().Cookies() {
+			if c.Name == auth.CookieName && c.Value != "" {
+				return true
+			}
+		}
+		return false
+	}
+
+	pending := login()
+	if rec := submit(pending, "123456"); hasSession(rec) || !strings.Contains(rec.Body.String(), "Invalid code") {
+		t.Fatal("wrong code accepted")
+	}
+	code, _ = totp.GenerateCode(secret, time.Now())
+	if rec := submit(pending, code); rec.Code != http.StatusSeeOther || !hasSession(rec) {
+		t.Fatalf("valid code: status = %d, session = %v", rec.Code, hasSession(rec))
+	}
+
+	// The pending login is spent, and the code can't be replayed from a
+	// fresh one either.
+	if rec := submit(pending, code); hasSession(rec) || rec.Header().Get("Location") != "/login" {
+		t.Fatalf("spent pending login reused: status = %d", rec.Code)
+	}
+	if rec := submit(login(), code); hasSession(rec) {
+		t.Fatal("TOTP code accepted twice")
+	}
+
+	// A recovery code works exactly once.
+	pending = login()
+	if rec := submit(pending, recovery); !hasSession(rec) {
+		t.Fatalf("recovery code %q rejected", recovery)
+	}
+	if rec := submit(login(), recovery); hasSession(rec) {
+		t.Fatal("recovery code accepted twice")
+	}
+}
+
+func TestValidateTOTPSkew(t *testing.T) {
+	secret, err := auth.GenerateTOTPSecret("acc@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev, _ := totp.GenerateCode(secret, time.Now().Add(-30*time.Second))
+	if !auth.ValidateTOTP(prev, secret) {
+		t.Error("code from the previous step rejected")
+	}
+	old, _ := totp.GenerateCode(secret, time.Now().Add(-5*time.Minute))
+	if auth.ValidateTOTP(old, secret) {
+		t.Error("five-minute-old code accepted")
+	}
+	step, ok := auth.ValidateTOTPAfter(prev, secret, 0)
+	if !ok || step >= auth.TOTPStep(time.Now()) {
+		t.Fatalf("ValidateTOTPAfter = %d, %v", step, ok)
+	}
+	if _, ok := auth.ValidateTOTPAfter(prev, secret, step); ok {
+		t.Error("code accepted again for an already used step")
+	}
+}
+
+func TestPendingLoginCookieTampered(t *testing.T) {
+	rec := httptest.NewRecorder()
+	auth.SetPendingLoginCookie(rec, "acc", 7, "secret")
+	c := rec.Result().Cookies()[0]
+
+	req := httptest.NewRequest("GET", "/login/2fa", nil)
+	req.AddCookie(c)
+	if id, step, ok := auth.GetPendingLogin(req, "secret"); !ok || id != "acc" || step != 7 {
+		t.Fatalf("GetPendingLogin = %q, %d, %v", id, step, ok)
+	}
+
+	c.Value = strings.Replace(c.Value, "acc|", "admin|", 1)
+	req = httptest.NewRequest("GET", "/login/2fa", nil)
+	req.AddCookie(c)
+	if _, _, ok := auth.GetPendingLogin(req, "secret"); ok {
+		t.Error("tampered cookie accepted")
+	}
+}
@@ -1,16 +1,17 @@
 package handler
 
 import (
-	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
@@ -35,14 +36,149 @@ func jsonOK(w http.ResponseWriter, v interface{}) {
 	json.NewEncoder(w).Encode(v)
 }
 
+var (
+	errFileTooLarge = errors.New("file exceeds the maximum upload size")
+	errUploadQuota  = errors.New("upload would exceed the account's storage limit")
+	errUploadType   = errors.New("file type not allowed for this account")
+)
+
+// allowedUploadTypes returns the MIME types accountID may upload: the
+// account's own list when an admin set one, otherwise ALLOWED_UPLOAD_TYPES.
+func (h *Handler) allowedUploadTypes(accountID string) ([]string, error) {
+	policy := h.Cfg.AllowedUploadTypes
+	account, err := db.GetAccountByID(h.DB, accountID)
+	if err != nil {
+		return nil, err
+	}
+	if account != nil && account.AllowedUploadTypes != nil {
+		policy = *account.AllowedUploadTypes
+	}
+	return watermark.ParseUploadTypes(policy)
+}
+
+// checkUploadType refuses an upload of mimeType the account's policy does
+// not allow. The error lists the permitted types.
+func (h *Handler) checkUploadType(accountID, mimeType string) error {
+	allowed, err := h.allowedUploadTypes(accountID)
+	if err != nil {
+		return err
+	}
+	if slices.Contains(allowed, mimeType) {
+		return nil
+	}
+	return fmt.Errorf("%w: %s (allowed: %s)", errUploadType, mimeType, strings.Join(allowed, ", "))
+}
+
+// declaredUploadType is the type a chunked upload claims to be: the declared
+// MIME type, or the one its file extension maps to. ok is false when neither
+// is a type the application handles.
+func declaredUploadType(mimeType, filename string) (string, bool) {
+	if _, ok := watermark.MimeToExt[mimeType]; ok {
+		return mimeType, true
+	}
+	ext := strings.ToLower(filepath.Ext(filename))
+	for m, e := range watermark.MimeToExt {
+		if e == ext {
+			return m, true
+		}
+	}
+	return "", false
+}
+
+// assembledUploadType sniffs the assembled chunked upload at path and
+// returns the type to store it as. A sniffed type the application handles
+// wins over the declared one, so a file cannot pass the upload-type policy
+// under another kind's name. Content that sniffs as nothing known keeps the
+// declared type only when that is a video (some QuickTime and Matroska files
+// have no signature DetectContentType knows); every supported image format is
+// recognised by its header, so an unrecognised "image" is refused.
+func assembledUploadType(path, declared string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	f.Close()
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	sniffed := sniffUploadMime(head[:n])
+	if _, ok := watermark.MimeToExt[sniffed]; ok {
+		return sniffed, nil
+	}
+	if watermark.MimeToAssetType[declared] == "video" && !strings.HasPrefix(sniffed, "image/") {
+		return declared, nil
+	}
+	return "", fmt.Errorf("%w: content is %s, not %s", errUploadType, sniffed, declared)
+}
+
+// checkUploadSize enforces MaxUploadBytes for a single file of size bytes and
+// MaxAccountUploadBytes for the account's assets plus uploads in progress.
+func (h *Handler) checkUploadSize(accountID string, size int64) error {
+	if h.Cfg.MaxUploadBytes > 0 && size > h.Cfg.MaxUploadBytes {
+		return fmt.Errorf("%w (%s)", errFileTooLarge, formatBytes(h.Cfg.MaxUploadBytes))
+	}
+	if h.Cfg.MaxAccountUploadBytes <= 0 {
+		return nil
+	}
+	stored, err := db.SumAssetBytesByAccount(h.DB, accountID)
+	if err != nil {
+		return err
+	}
+	pending, err := db.SumPendingUploadBytes(h.DB, accountID)
+	if err != nil {
+		return err
+	}
+	if stored+pending+size > h.Cfg.MaxAccountUploadBytes {
+		return h.uploadQuotaError(stored + pending)
+	}
+	return nil
+}
+
+// uploadQuotaError is the errUploadQuota shown when used bytes leave no room
+// under MaxAccountUploadBytes. checkUploadSize rejects most uploads early;
+// the insert re-checks in a transaction and reports db.ErrUploadQuota
+// through here when a concurrent upload took the room.
+func (h *Handler) uploadQuotaError(used int64) error {
+	return fmt.Errorf("%w (%s used of %s)", errUploadQuota,
+		formatBytes(used), formatBytes(h.Cfg.MaxAccountUploadBytes))
+}
+
+// duplicateAsset returns the account's existing asset with the same content
+// as a new upload, or nil. A failed lookup is logged and treated as no match
+// so it never blocks the upload.
+func (h *Handler) duplicateAsset(accountID, sha256Hex string) *model.Asset {
+	dup, err := db.GetAssetBySHA(h.DB, accountID, sha256Hex)
+	if err != nil {
+		slog.Error("upload: duplicate lookup", "error", err)
+		return nil
+	}
+	return dup
+}
+
+func isUploadLimitError(err error) bool {
+	return errors.Is(err, errFileTooLarge) || errors.Is(err, errUploadQuota)
+}
+
+// isSHA256Hex reports whether s is a lowercase hex SHA-256 digest.
+func isSHA256Hex(s string) bool {
+	if len(s) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil && strings.ToLower(s) == s
+}
+
 // UploadInit handles POST /upload/chunks/init
 func (h *Handler) UploadInit(w http.ResponseWriter, r *http.Request) {
 	accountID := auth.AccountFromContext(r.Context())
 	var req struct {
-		Filename  string `json:"filename"`
-		Size      int64  `json:"size"`
-		MimeType  string `json:"mime_type"`
-		ChunkSize int64  `json:"chunk_size"`
+		Filename    string   `json:"filename"`
+		Size        int64    `json:"size"`
+		MimeType    string   `json:"mime_type"`
+		ChunkSize   int64    `json:"chunk_size"`
+		ChunkSHA256 []string `json:"chunk_sha256"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		jsonError(w, "invalid JSON body", http.StatusBadRequest)
@@ -52,27 +188,53 @@ func (h *Handler) UploadInit(w http.ResponseWriter, r *http.Request) {
 		jsonError(w, "filename, size, mime_type, chunk_size required", http.StatusBadRequest)
 		return
 	}
-	_, mimeOK := watermark.MimeToExt[req.MimeType]
+	uploadType, mimeOK := declaredUploadType(req.MimeType, req.Filename)
 	if !mimeOK {
-		ext := strings.ToLower(filepath.Ext(req.Filename))
-		for _, e := range watermark.MimeToExt {
-			if e == ext {
-				mimeOK = true
-				break
-			}
+		jsonError(w, "unsupported file type", http.StatusBadRequest)
+		return
+	}
+	if err := h.checkUploadType(accountID, uploadType); err != nil {
+		if errors.Is(err, errUploadType) {
+			jsonError(w, err.Error(), http.StatusUnsupportedMediaType)
+			return
 		}
+		slog.ErrorContext(r.Context(), "upload init: type check", "error", err)
+		jsonError(w, "internal error", http.StatusInternalServerError)
+		return
 	}
-	if !mimeOK {
-		jsonError(w, "unsupported file type", http.StatusBadRequest)
+	if err := h.checkUploadSize(accountID, req.Size); err != nil {
+		if isUploadLimitError(err) {
+			jsonError(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		slog.ErrorContext(r.Context(), "upload init: size check", "error", err)
+		jsonError(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if msg := h.diskSpaceProblem("this upload", req.Size); msg != "" {
+		jsonError(w, msg, http.StatusInsufficientStorage)
 		return
 	}
 	totalChunks := int((req.Size + req.ChunkSize - 1) / req.ChunkSize)
+	if len(req.ChunkSHA256) > 0 {
+		if len(req.ChunkSHA256) != totalChunks {
+			jsonError(w, fmt.Sprintf("chunk_sha256 must list %d digests, one per chunk", totalChunks), http.StatusBadRequest)
+			return
+		}
+		for i, sum := range req.ChunkSHA256 {
+			req.ChunkSHA256[i] = strings.ToLower(sum)
+			if !isSHA256Hex(req.ChunkSHA256[i]) {
+				jsonError(w, fmt.Sprintf("chunk_sha256[%d] is not a hex SHA-256 digest", i), http.StatusBadRequest)
+				return
+			}
+		}
+	}
 	sessionID := uuid.New().String()
 	now := time.Now()
 	expiresAt := now.Add(time.Duration(h.Cfg.UploadSessionTTLHours) * time.Hour)
 	sessionDir := filepath.Join(h.Cfg.DataDir, "uploads", sessionID)
 	if err := os.MkdirAll(sessionDir, 0755); err != nil {
-		slog.Error("upload init: mkdir", "error", err)
+		slog.ErrorContext(r.Context(), "upload init: mkdir", "error", err)
 		jsonError(w, "internal error", http.StatusInternalServerError)
 		return
 	}
@@ -84,14 +246,19 @@ func (h *Handler) UploadInit(w http.ResponseWriter, r *http.Request) {
 		MimeType:    req.MimeType,
 		ChunkSize:   req.ChunkSize,
 		TotalChunks: totalChunks,
+		ChunkHashes: req.ChunkSHA256,
 		Status:      "PENDING",
 		CreatedAt:   now,
 		UpdatedAt:   now,
 		ExpiresAt:   expiresAt,
 	}
-	if err := db.CreateUploadSession(h.DB, session); err != nil {
-		slog.Error("upload init: db create", "error", err)
+	if used, err := db.CreateUploadSessionWithinQuota(h.DB, session, h.Cfg.MaxAccountUploadBytes); err != nil {
 		os.RemoveAll(sessionDir)
+		if errors.Is(err, db.ErrUploadQuota) {
+			jsonError(w, h.uploadQuotaError(used).Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		slog.ErrorContext(r.Context(), "upload init: db create", "error", err)
 		jsonError(w, "internal error", http.StatusInternalServerError)
 		return
 	}
@@ -104,6 +271,15 @@ func (h *Handler) UploadInit(w http.ResponseWriter, r *http.Request) {
 }
 
 // UploadChunk handles PUT /upload/chunks/{sessionID}/{chunkIndex}
+//
+// The client may send the chunk's SHA-256 in the X-Chunk-SHA256 header or
+// the sha256 query parameter; with a manifest from UploadInit the manifest
+// entry is used. A chunk that doesn't match is discarded with 422 and is not
+// recorded as received, so any earlier good copy of it is kept.
+//
+// Re-sending a received chunk is idempotent: identical content is a no-op.
+// Different content replaces the stored chunk only when it was verified
+// against a checksum; an unverified one is refused with 409.
 func (h *Handler) UploadChunk(w http.ResponseWriter, r *http.Request) {
 	sessionID := chi.URLParam(r, "sessionID")
 	chunkIndex, err := strconv.Atoi(chi.URLParam(r, "chunkIndex"))
@@ -136,40 +312,131 @@ func (h *Handler) UploadChunk(w http.ResponseWriter, r *http.Request) {
 		jsonError(w, "chunk index out of range", http.StatusBadRequest)
 		return
 	}
-	chunkPath := filepath.Join(h.Cfg.DataDir, "uploads", sessionID, fmt.Sprintf("chunk_%d", chunkIndex))
-	f, err := os.Create(chunkPath)
+	expected := strings.ToLower(r.Header.Get("X-Chunk-SHA256"))
+	if expected == "" {
+		expected = strings.ToLower(r.URL.Query().Get("sha256"))
+	}
+	if expected != "" && !isSHA256Hex(expected) {
+		jsonError(w, "X-Chunk-SHA256 is not a hex SHA-256 digest", http.StatusBadRequest)
+		return
+	}
+	if chunkIndex < len(session.ChunkHashes) {
+		if expected != "" && expected != session.ChunkHashes[chunkIndex] {
+			jsonError(w, "X-Chunk-SHA256 does not match the upload manifest", http.StatusUnprocessableEntity)
+			return
+		}
+		expected = session.ChunkHashes[chunkIndex]
+	}
+	sessionDir := filepath.Join(h.Cfg.DataDir, "uploads", sessionID)
+	chunkPath := filepath.Join(sessionDir, fmt.Sprintf("chunk_%d", chunkIndex))
+
+	// The chunks together may not exceed the size declared at init, which is
+	// what the upload limits were checked against.
+	var otherBytes int64
+	for _, c := range session.ReceivedChunks {
+		if c == chunkIndex {
+			continue
+		}
+		if fi, err := os.Stat(filepath.Join(sessionDir, fmt.Sprintf("chunk_%d", c))); err == nil {
+			otherBytes += fi.Size()
+		}
+	}
+	allowed := session.Size - otherBytes
+
+	// A temp name per request, so concurrent retries of one chunk cannot
+	// interleave their writes.
+	f, err := os.CreateTemp(sessionDir, fmt.Sprintf("chunk_%d.*.part", chunkIndex))
 	if err != nil {
-		slog.Error("upload chunk: create file", "error", err)
+		slog.ErrorContext(r.Context(), "upload chunk: create file", "error", err)
 		jsonError(w, "internal error", http.StatusInternalServerError)
 		return
 	}
-	defer f.Close()
-	if _, err = io.Copy(f, r.Body); err != nil {
-		slog.Error("upload chunk: copy body", "error", err)
-		os.Remove(chunkPath)
+	partPath := f.Name()
+	hasher := sha256.New()
+	written, err := io.Copy(io.MultiWriter(f, hasher), io.LimitReader(r.Body, allowed+1))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "upload chunk: copy body", "error", err)
+		os.Remove(partPath)
 		jsonError(w, "internal error", http.StatusInternalServerError)
 		return
 	}
+	if written > allowed {
+		os.Remove(partPath)
+		jsonError(w, fmt.Sprintf("chunks exceed the declared upload size of %d bytes", session.Size), http.StatusRequestEntityTooLarge)
+		return
+	}
+	sum := hex.EncodeToString(hasher.Sum(nil))
+	if expected != "" && sum != expected {
+		os.Remove(partPath)
+		jsonError(w, fmt.Sprintf("chunk %d checksum mismatch: expected %s, got %s", chunkIndex, expected, sum), http.StatusUnprocessableEntity)
+		return
+	}
 	recvd := session.ReceivedChunks
-	found := false
-	for _, c := range recvd {
-		if c == chunkIndex {
-			found = true
-			break
+	found := slices.Contains(recvd, chunkIndex)
+	if found {
+		stored, err := watermark.SHA256File(chunkPath)
+		switch {
+		case err != nil:
+			// Recorded but gone from disk: store this copy.
+		case stored == sum:
+			os.Remove(partPath)
+			jsonOK(w, map[string]interface{}{
+				"chunk_index":    chunkIndex,
+				"sha256":         sum,
+				"received_count": len(recvd),
+				"total_chunks":   session.TotalChunks,
+				"duplicate":      true,
+			})
+			return
+		case expected == "":
+			os.Remove(partPath)
+			jsonError(w, fmt.Sprintf("chunk %d was already received with different content (sha256 %s); send X-Chunk-SHA256 to replace it", chunkIndex, stored), http.StatusConflict)
+			return
 		}
 	}
+	if err := os.Rename(partPath, chunkPath); err != nil {
+		slog.ErrorContext(r.Context(), "upload chunk: rename", "error", err)
+		os.Remove(partPath)
+		jsonError(w, "internal error", http.StatusInternalServerError)
+		return
+	}
 	if !found {
 		recvd = append(recvd, chunkIndex)
 	}
 	db.UpdateUploadSessionChunks(h.DB, sessionID, recvd)
 	jsonOK(w, map[string]interface{}{
 		"chunk_index":    chunkIndex,
+		"sha256":         sum,
 		"received_count": len(recvd),
 		"total_chunks":   session.TotalChunks,
 	})
 }
 
+// missingChunks returns the chunk indices in [0, total) not in received, in
+// order. It is never nil, so it encodes as [] once every chunk is in.
+func missingChunks(total int, received []int) []int {
+	have := make([]bool, total)
+	for _, c := range received {
+		if c >= 0 && c < total {
+			have[c] = true
+		}
+	}
+	missing := []int{}
+	for i, ok := range have {
+		if !ok {
+			missing = append(missing, i)
+		}
+	}
+	return missing
+}
+
 // UploadStatus handles GET /upload/chunks/{sessionID}/status
+//
+// Received chunks are recorded in the database, so a client can resume after
+// a dropped connection or a server restart by sending only missing_chunks.
 func (h *Handler) UploadStatus(w http.ResponseWriter, r *http.Request) {
 	sessionID := chi.URLParam(r, "sessionID")
 	session, err := db.GetUploadSession(h.DB, sessionID)
@@ -191,6 +458,7 @@ func (h *Handler) UploadStatus(w http.ResponseWriter, r *http.Request) {
 		"size":            session.Size,
 		"total_chunks":    session.TotalChunks,
 		"received_chunks": session.ReceivedChunks,
+		"missing_chunks":  missingChunks(session.TotalChunks, session.ReceivedChunks),
 		"status":          session.Status,
 		"expires_at":      session.ExpiresAt.Format(time.RFC3339),
 	})
@@ -226,17 +494,31 @@ func (h *Handler) UploadComplete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	sort.Ints(session.ReceivedChunks)
-	ext := strings.ToLower(filepath.Ext(session.Filename))
-	if ext == "" {
-		if mappedExt, ok := watermark.MimeToExt[session.MimeType]; ok {
-			ext = mappedExt
+	sessionDir := filepath.Join(h.Cfg.DataDir, "uploads", sessionID)
+	if len(session.ChunkHashes) > 0 {
+		bad, err := verifyChunkManifest(sessionDir, session.ChunkHashes)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "upload complete: verify chunks", "error", err)
+			jsonError(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		if len(bad) > 0 {
+			// Forget the corrupted chunks so the client can re-send just those.
+			var keep []int
+			for _, c := range session.ReceivedChunks {
+				if !slices.Contains(bad, c) {
+					keep = append(keep, c)
+				}
+			}
+			db.UpdateUploadSessionChunks(h.DB, sessionID, keep)
+			jsonError(w, fmt.Sprintf("chunks %v do not match the upload manifest; re-upload them", bad), http.StatusUnprocessableEntity)
+			return
 		}
 	}
-	sessionDir := filepath.Join(h.Cfg.DataDir, "uploads", sessionID)
-	finalPath := filepath.Join(sessionDir, "final"+ext)
+	finalPath := filepath.Join(sessionDir, "final")
 	dst, err := os.Create(finalPath)
 	if err != nil {
-		slog.Error("upload complete: create final", "error", err)
+		slog.ErrorContext(r.Context(), "upload complete: create final", "error", err)
 		jsonError(w, "internal error", http.StatusInternalServerError)
 		return
 	}
@@ -258,12 +540,50 @@ func (h *Handler) UploadComplete(w http.ResponseWriter, r *http.Request) {
 	}
 	dst.Close()
 	if assembleErr != nil {
-		slog.Error("upload complete: assemble", "error", assembleErr)
+		slog.ErrorContext(r.Context(), "upload complete: assemble", "error", assembleErr)
 		os.Remove(finalPath)
 		jsonError(w, "failed to assemble chunks", http.StatusInternalServerError)
 		return
 	}
+	// The policy was checked against the declared type at init; check it
+	// again against what was actually uploaded.
+	declared, _ := declaredUploadType(session.MimeType, session.Filename)
+	mimeType, err := assembledUploadType(finalPath, declared)
+	if err == nil {
+		err = h.checkUploadType(accountID, mimeType)
+	}
+	if err != nil {
+		os.Remove(finalPath)
+		if errors.Is(err, errUploadType) {
+			jsonError(w, err.Error(), http.StatusUnsupportedMediaType)
+			return
+		}
+		slog.ErrorContext(r.Context(), "upload complete: type check", "error", err)
+		jsonError(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	ext := strings.ToLower(filepath.Ext(session.Filename))
+	if m, _ := declaredUploadType("", session.Filename); m != mimeType {
+		ext = watermark.MimeToExt[mimeType]
+	}
+
 	sha256Hex := hex.EncodeToString(hasher.Sum(nil))
+	dup := h.duplicateAsset(accountID, sha256Hex)
+	if dup != nil && r.URL.Query().Get("dedupe") == "1" {
+		os.Remove(finalPath)
+		db.CompleteUploadSession(h.DB, sessionID, filepath.Join(h.Cfg.DataDir, dup.OriginalPath))
+		cleanupUploadChunks(sessionDir, session.TotalChunks)
+		db.InsertAuditLog(h.DB, accountID, "asset_upload_deduplicated", "asset", dup.ID, session.Filename, r.RemoteAddr)
+		jsonOK(w, map[string]interface{}{
+			"asset_id":     dup.ID,
+			"filename":     dup.OriginalName,
+			"deduplicated": true,
+		})
+		return
+	}
+	if dup != nil {
+		slog.WarnContext(r.Context(), "upload complete: duplicate of existing asset", "account", accountID, "asset_id", dup.ID, "sha256", sha256Hex)
+	}
 	assetID := uuid.New().String()
 	assetDir := filepath.Join(h.Cfg.DataDir, "originals", assetID)
 	if err := os.MkdirAll(assetDir, 0755); err != nil {
@@ -274,14 +594,32 @@ func (h *Handler) UploadComplete(w http.ResponseWriter, r *http.Request) {
 	destPath := filepath.Join(assetDir, "source"+ext)
 	if err := os.Rename(finalPath, destPath); err != nil {
 		if cpErr := copyFileUpload(finalPath, destPath); cpErr != nil {
-			slog.Error("upload complete: move file", "error", cpErr)
+			slog.ErrorContext(r.Context(), "upload complete: move file", "error", cpErr)
 			os.RemoveAll(assetDir)
 			jsonError(w, "internal error", http.StatusInternalServerError)
 			return
 		}
 		os.Remove(finalPath)
 	}
-	assetType := watermark.MimeToAssetType[session.MimeType]
+	var sourceMime string
+	if watermark.NeedsTranscode(mimeType) || watermark.IsTranscodedExt(ext) {
+		pngPath, _, err := transcodeToPNG(r.Context(), destPath)
+		if err != nil {
+			os.RemoveAll(assetDir)
+			if errors.Is(err, errTranscodeFailed) {
+				jsonError(w, err.Error(), http.StatusUnprocessableEntity)
+			} else {
+				jsonError(w, "internal error", http.StatusInternalServerError)
+			}
+			return
+		}
+		sourceMime = mimeType
+		if !watermark.NeedsTranscode(sourceMime) {
+			sourceMime = "image/" + strings.TrimPrefix(ext, ".")
+		}
+		mimeType, ext, destPath = "image/png", ".png", pngPath
+	}
+	assetType := watermark.MimeToAssetType[mimeType]
 	if assetType == "" {
 		assetType = "video"
 	}
@@ -296,17 +634,6 @@ func (h *Handler) UploadComplete(w http.ResponseWriter, r *http.Request) {
 			duration = &probe.DurationSecs
 		}
 	}
-	thumbPath := filepath.Join(assetDir, "thumb.jpg")
-	ctx := context.Background()
-	if assetType == "video" {
-		seekSec := 1.0
-		if duration != nil && *duration > 10 {
-			seekSec = *duration * 0.1
-		}
-		watermark.ExtractVideoThumbnail(ctx, destPath, thumbPath, seekSec)
-	} else {
-		watermark.ExtractImageThumbnail(ctx, destPath, thumbPath)
-	}
 	var fileSize int64
 	if fi, statErr := os.Stat(destPath); statErr == nil {
 		fileSize = fi.Size()
@@ -319,24 +646,32 @@ func (h *Handler) UploadComplete(w http.ResponseWriter, r *http.Request) {
 		OriginalPath: filepath.Join("originals", assetID, "source"+ext),
 		FileSize:     fileSize,
 		SHA256:       sha256Hex,
-		MimeType:     session.MimeType,
+		MimeType:     mimeType,
 		Duration:     duration,
 		Width:        width,
 		Height:       height,
+
+		SourceMimeType: sourceMime,
 	}
 	if err := db.CreateAsset(h.DB, asset); err != nil {
-		slog.Error("upload complete: insert asset", "error", err)
+		slog.ErrorContext(r.Context(), "upload complete: insert asset", "error", err)
 		os.RemoveAll(assetDir)
 		jsonError(w, "internal error", http.StatusInternalServerError)
 		return
 	}
 	db.CompleteUploadSession(h.DB, sessionID, destPath)
+	h.generateThumbnail(r.Context(), asset)
+	h.dispatchAssetReady(r.Context(), asset)
 	cleanupUploadChunks(sessionDir, session.TotalChunks)
 	db.InsertAuditLog(h.DB, accountID, "asset_uploaded_chunked", "asset", assetID, session.Filename, r.RemoteAddr)
-	jsonOK(w, map[string]string{
+	resp := map[string]interface{}{
 		"asset_id": assetID,
 		"filename": session.Filename,
-	})
+	}
+	if dup != nil {
+		resp["duplicate_of"] = dup.ID
+	}
+	jsonOK(w, resp)
 }
 
 // UploadCancel handles DELETE /upload/chunks/{sessionID}
@@ -375,6 +710,26 @@ func copyFileUpload(src, dst string) error {
 	return err
 }
 
+// verifyChunkManifest hashes each chunk file in sessionDir and returns the
+// indices whose digest differs from hashes (a missing chunk counts as bad).
+func verifyChunkManifest(sessionDir string, hashes []string) ([]int, error) {
+	var bad []int
+	for i, want := range hashes {
+		got, err := watermark.SHA256File(filepath.Join(sessionDir, fmt.Sprintf("chunk_%d", i)))
+		if errors.Is(err, os.ErrNotExist) {
+			bad = append(bad, i)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if got != want {
+			bad = append(bad, i)
+		}
+	}
+	return bad, nil
+}
+
 func cleanupUploadChunks(sessionDir string, totalChunks int) {
 	for i := 0; i < totalChunks; i++ {
 		os.Remove(filepath.Join(sessionDir, fmt.Sprintf("chunk_%d", i)))
@@ -0,0 +1,445 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/YannKr/downloadonce/internal/db"
+	"github.com/go-chi/chi/v5"
+)
+
+func uploadInit(h *Handler, accountID string, size int64) *httptest.ResponseRecorder {
+	body := `{"filename":"a.png","mime_type":"image/png","chunk_size":1000,"size":` + strconv.FormatInt(size, 10) + `}`
+	rec := httptest.NewRecorder()
+	h.UploadInit(rec, asAccount(httptest.NewRequest("POST", "/upload/chunks/init", strings.NewReader(body)), accountID, "member"))
+	return rec
+}
+
+func TestUploadInitLimits(t *testing.T) {
+	h := newTestHandler(t)
+	h.Cfg.MaxUploadBytes = 5000
+	h.Cfg.MaxAccountUploadBytes = 3000
+	seedAccount(t, h.DB, "acc", "member")
+	seedCampaign(t, h.DB, "acc", "camp", "DRAFT") // one 1024-byte asset
+
+	if rec := uploadInit(h, "acc", 6000); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("over per-file cap: status = %d, want 413", rec.Code)
+	}
+	if rec := uploadInit(h, "acc", 2000); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("over account cap: status = %d, want 413", rec.Code)
+	}
+	if rec := uploadInit(h, "acc", 1500); rec.Code != http.StatusOK {
+		t.Fatalf("within caps: status = %d: %s", rec.Code, rec.Body.String())
+	}
+	// The pending 1500-byte upload counts against the account.
+	rec := uploadInit(h, "acc", 600)
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("with pending upload: status = %d, want 413", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), "storage limit") {
+		t.Errorf("error message = %s", rec.Body.String())
+	}
+}
+
+func TestUploadChunkRejectsExcessBytes(t *testing.T) {
+	h := newTestHandler(t)
+	h.Cfg.UploadSessionTTLHours = 1
+	seedAccount(t, h.DB, "acc", "member")
+
+	rec := uploadInit(h, "acc", 1500) // chunks: 1000 + 500
+	if rec.Code != http.StatusOK {
+		t.Fatalf("init: status = %d: %s", rec.Code, rec.Body.String())
+	}
+	var init struct {
+		SessionID string `json:"session_id"`
+	}
+	json.NewDecoder(rec.Body).Decode(&init)
+
+	r := chi.NewRouter()
+	r.Put("/upload/chunks/{sessionID}/{chunkIndex}", h.UploadChunk)
+	put := func(index string, n int) int {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("PUT", "/upload/chunks/"+init.SessionID+"/"+index, strings.NewReader(strings.Repeat("x", n)))
+		r.ServeHTTP(rec, asAccount(req, "acc", "member"))
+		return rec.Code
+	}
+
+	if code := put("0", 1000); code != http.StatusOK {
+		t.Fatalf("first chunk: status = %d", code)
+	}
+	if code := put("1", 501); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("chunk past declared size: status = %d, want 413", code)
+	}
+	if code := put("1", 500); code != http.StatusOK {
+		t.Errorf("chunk within declared size: status = %d", code)
+	}
+	// Re-sending a chunk replaces it rather than adding to the total.
+	if code := put("0", 1000); code != http.StatusOK {
+		t.Errorf("resent chunk: status = %d", code)
+	}
+}
+
+func TestUploadChunkChecksum(t *testing.T) {
+	h := newTestHandler(t)
+	h.Cfg.UploadSessionTTLHours = 1
+	seedAccount(t, h.DB, "acc", "member")
+
+	sumOf := func(s string) string {
+		d := sha256.Sum256([]byte(s))
+		return hex.EncodeToString(d[:])
+	}
+	chunk0, chunk1 := strings.Repeat("a", 1000), strings.Repeat("b", 500)
+
+	r := chi.NewRouter()
+	r.Put("/upload/chunks/{sessionID}/{chunkIndex}", h.UploadChunk)
+	r.Post("/upload/chunks/{sessionID}/complete", h.UploadComplete)
+	put := func(sessionID, index, body, sum string) int {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("PUT", "/upload/chunks/"+sessionID+"/"+index, strings.NewReader(body))
+		if sum != "" {
+			req.Header.Set("X-Chunk-SHA256", sum)
+		}
+		r.ServeHTTP(rec, asAccount(req, "acc", "member"))
+		return rec.Code
+	}
+	received := func(sessionID string) []int {
+		s, err := db.GetUploadSession(h.DB, sessionID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s.ReceivedChunks
+	}
+
+	// Header only: a mismatching chunk is rejected and not recorded.
+	rec := uploadInit(h, "acc", 1500)
+	var init struct {
+		SessionID string `json:"session_id"`
+	}
+	json.NewDecoder(rec.Body).Decode(&init)
+	if code := put(init.SessionID, "0", chunk0, sumOf("other")); code != http.StatusUnprocessableEntity {
+		t.Errorf("mismatched header: status = %d, want 422", code)
+	}
+	if got := received(init.SessionID); len(got) != 0 {
+		t.Errorf("mismatched chunk recorded: %v", got)
+	}
+	if code := put(init.SessionID, "0", chunk0, sumOf(chunk0)); code != http.StatusOK {
+		t.Errorf("matching header: status = %d", code)
+	}
+	// A bad retry keeps the good copy already on disk.
+	if code := put(init.SessionID, "0", chunk1, sumOf(chunk0)); code != http.StatusUnprocessableEntity {
+		t.Errorf("bad retry: status = %d, want 422", code)
+	}
+	if got, _ := os.ReadFile(filepath.Join(h.Cfg.DataDir, "uploads", init.SessionID, "chunk_0")); string(got) != chunk0 {
+		t.Error("bad retry replaced the stored chunk")
+	}
+
+	// Manifest: chunks are checked without a header, and again at completion.
+	body := `{"filename":"a.png","mime_type":"image/png","chunk_size":1000,"size":1500,"chunk_sha256":["` +
+		sumOf(chunk0) + `","` + strings.ToUpper(sumOf(chunk1)) + `"]}`
+	rec = httptest.NewRecorder()
+	h.UploadInit(rec, asAccount(httptest.NewRequest("POST", "/upload/chunks/init", strings.NewReader(body)), "acc", "member"))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("init with manifest: status = %d: %s", rec.Code, rec.Body.String())
+	}
+	json.NewDecoder(rec.Body).Decode(&init)
+	if code := put(init.SessionID, "1", chunk0[:500], ""); code != http.StatusUnprocessableEntity {
+		t.Errorf("chunk not matching manifest: status = %d, want 422", code)
+	}
+	if code := put(init.SessionID, "0", chunk0, ""); code != http.StatusOK {
+		t.Errorf("chunk 0: status = %d", code)
+	}
+	if code := put(init.SessionID, "1", chunk1, ""); code != http.StatusOK {
+		t.Errorf("chunk 1: status = %d", code)
+	}
+	// Corrupt chunk 1 on disk after it was accepted.
+	os.WriteFile(filepath.Join(h.Cfg.DataDir, "uploads", init.SessionID, "chunk_1"), []byte(chunk0[:500]), 0644)
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, asAccount(httptest.NewRequest("POST", "/upload/chunks/"+init.SessionID+"/complete", nil), "acc", "member"))
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("complete with corrupt chunk: status = %d: %s", rec.Code, rec.Body.String())
+	}
+	if got := received(init.SessionID); len(got) != 1 || got[0] != 0 {
+		t.Errorf("received after failed verification = %v, want [0]", got)
+	}
+
+	// Malformed manifests are rejected up front.
+	for _, m := range []string{`["` + sumOf(chunk0) + `"]`, `["zz","zz"]`} {
+		body := `{"filename":"a.png","mime_type":"image/png","chunk_size":1000,"size":1500,"chunk_sha256":` + m + `}`
+		rec := httptest.NewRecorder()
+		h.UploadInit(rec, asAccount(httptest.NewRequest("POST", "/upload/chunks/init", strings.NewReader(body)), "acc", "member"))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("manifest %s: status = %d, want 400", m, rec.Code)
+		}
+	}
+}
+
+func TestMissingChunks(t *testing.T) {
+	cases := []struct {
+		total    int
+		received []int
+		want     []int
+	}{
+		{3, nil, []int{0, 1, 2}},
+		{3, []int{2, 0}, []int{1}},
+		{3, []int{0, 1, 2}, []int{}},
+		{4, []int{3, 3, 7, -1}, []int{0, 1, 2}},
+		{0, nil, []int{}},
+	}
+	for _, c := range cases {
+		got := missingChunks(c.total, c.received)
+		if got == nil || !slices.Equal(got, c.want) {
+			t.Errorf("missingChunks(%d, %v) = %#v, want %v", c.total, c.received, got, c.want)
+		}
+	}
+}
+
+func TestUploadResume(t *testing.T) {
+	h := newTestHandler(t)
+	h.Cfg.UploadSessionTTLHours = 1
+	seedAccount(t, h.DB, "acc", "member")
+
+	rec := uploadInit(h, "acc", 2500) // chunks: 1000 + 1000 + 500
+	var init struct {
+		SessionID string `json:"session_id"`
+	}
+	json.NewDecoder(rec.Body).Decode(&init)
+
+	r := chi.NewRouter()
+	r.Put("/upload/chunks/{sessionID}/{chunkIndex}", h.UploadChunk)
+	r.Get("/upload/chunks/{sessionID}/status", h.UploadStatus)
+	put := func(index, body, sum string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("PUT", "/upload/chunks/"+init.SessionID+"/"+index, strings.NewReader(body))
+		if sum != "" {
+			req.Header.Set("X-Chunk-SHA256", sum)
+		}
+		r.ServeHTTP(rec, asAccount(req, "acc", "member"))
+		return rec
+	}
+	missing := func() []int {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, asAccount(httptest.NewRequest("GET", "/upload/chunks/"+init.SessionID+"/status", nil), "acc", "member"))
+		var st struct {
+			MissingChunks []int `json:"missing_chunks"`
+		}
+		if err := json.NewDecoder(rec.Body).Decode(&st); err != nil {
+			t.Fatal(err)
+		}
+		return st.MissingChunks
+	}
+
+	chunk0, chunk2 := strings.Repeat("a", 1000), strings.Repeat("c", 500)
+	put("0", chunk0, "")
+	put("2", chunk2, "")
+	if got := missing(); !slices.Equal(got, []int{1}) {
+		t.Fatalf("missing_chunks = %v, want [1]", got)
+	}
+
+	chunkPath := filepath.Join(h.Cfg.DataDir, "uploads", init.SessionID, "chunk_0")
+	before, _ := os.Stat(chunkPath)
+	rec = put("0", chunk0, "")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"duplicate":true`) {
+		t.Errorf("identical resend: status = %d: %s", rec.Code, rec.Body)
+	}
+	if after, _ := os.Stat(chunkPath); !after.ModTime().Equal(before.ModTime()) {
+		t.Error("identical resend rewrote the stored chunk")
+	}
+	if rec := put("0", strings.Repeat("z", 1000), ""); rec.Code != http.StatusConflict {
+		t.Errorf("unverified different resend: status = %d, want 409", rec.Code)
+	}
+	if got, _ := os.ReadFile(chunkPath); string(got) != chunk0 {
+		t.Error("unverified resend replaced the stored chunk")
+	}
+	replacement := strings.Repeat("z", 1000)
+	sum := sha256.Sum256([]byte(replacement))
+	if rec := put("0", replacement, hex.EncodeToString(sum[:])); rec.Code != http.StatusOK {
+		t.Errorf("verified replacement: status = %d: %s", rec.Code, rec.Body)
+	}
+	if got, _ := os.ReadFile(chunkPath); string(got) != replacement {
+		t.Error("verified replacement not stored")
+	}
+	if parts, _ := filepath.Glob(filepath.Join(h.Cfg.DataDir, "uploads", init.SessionID, "*.part")); len(parts) != 0 {
+		t.Errorf("left temp files %v", parts)
+	}
+
+	put("1", strings.Repeat("b", 1000), "")
+	if got := missing(); got == nil || len(got) != 0 {
+		t.Errorf("missing_chunks when complete = %#v, want []", got)
+	}
+}
+
+func TestUploadDedupeBySHA(t *testing.T) {
+	h := newTestHandler(t)
+	h.Cfg.UploadSessionTTLHours = 1
+	seedAccount(t, h.DB, "acc", "member")
+	seedAccount(t, h.DB, "other", "member")
+
+	content := "\x89PNG\r\n\x1a\n" + strings.Repeat("x", 792)
+	r := chi.NewRouter()
+	r.Put("/upload/chunks/{sessionID}/{chunkIndex}", h.UploadChunk)
+	r.Post("/upload/chunks/{sessionID}/complete", h.UploadComplete)
+	upload := func(account, query string) map[string]interface{} {
+		t.Helper()
+		rec := uploadInit(h, account, int64(len(content)))
+		var init struct {
+			SessionID string `json:"session_id"`
+		}
+		json.NewDecoder(rec.Body).Decode(&init)
+		rec = httptest.NewRecorder()
+		r.ServeHTTP(rec, asAccount(httptest.NewRequest("PUT", "/upload/chunks/"+init.SessionID+"/0", strings.NewReader(content)), account, "member"))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("chunk: status = %d: %s", rec.Code, rec.Body)
+		}
+		rec = httptest.NewRecorder()
+		r.ServeHTTP(rec, asAccount(httptest.NewRequest("POST", "/upload/chunks/"+init.SessionID+"/complete"+query, nil), account, "member"))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("complete: status = %d: %s", rec.Code, rec.Body)
+		}
+		var resp map[string]interface{}
+		json.NewDecoder(rec.Body).Decode(&resp)
+		return resp
+	}
+	assetCount := func() int {
+		var n int
+		h.DB.QueryRow(`SELECT COUNT(*) FROM assets`).Scan(&n)
+		return n
+	}
+
+	first := upload("acc", "")
+	if first["duplicate_of"] != nil {
+		t.Errorf("first upload flagged as duplicate: %v", first)
+	}
+	// Without dedupe the copy is kept but flagged.
+	second := upload("acc", "")
+	if second["duplicate_of"] != first["asset_id"] || second["asset_id"] == first["asset_id"] {
+		t.Errorf("repeat upload = %v, want a new asset flagged as duplicate of %v", second, first["asset_id"])
+	}
+	if n := assetCount(); n != 2 {
+		t.Fatalf("assets = %d, want 2", n)
+	}
+	// With dedupe the original asset is reused and nothing new is stored.
+	third := upload("acc", "?dedupe=1")
+	if third["asset_id"] != first["asset_id"] || third["deduplicated"] != true {
+		t.Errorf("dedupe upload = %v, want asset %v reused", third, first["asset_id"])
+	}
+	if n := assetCount(); n != 2 {
+		t.Errorf("assets after dedupe = %d, want 2", n)
+	}
+	// Other accounts' assets are never matched.
+	if resp := upload("other", "?dedupe=1"); resp["deduplicated"] != nil || resp["duplicate_of"] != nil {
+		t.Errorf("other account upload = %v, want a fresh asset", resp)
+	}
+
+	// The API path reuses the same lookup.
+	rec := httptest.NewRecorder()
+	req := multipartUpload("again.png", strings.NewReader(content))
+	req.URL.RawQuery = "dedupe=1"
+	h.APIAssetUpload(rec, asAccount(req, "acc", "member"))
+	if rec.Code != http.StatusOK || rec.Header().Get("X-Duplicate-Of") != first["asset_id"] {
+		t.Errorf("API dedupe: status = %d, X-Duplicate-Of = %q", rec.Code, rec.Header().Get("X-Duplicate-Of"))
+	}
+	if n := assetCount(); n != 3 {
+		t.Errorf("assets after API dedupe = %d, want 3", n)
+	}
+	if entries, _ := os.ReadDir(filepath.Join(h.Cfg.DataDir, "originals")); len(entries) != 3 {
+		t.Errorf("asset dirs = %d, want 3", len(entries))
+	}
+}
+
+func TestUploadTypePolicy(t *testing.T) {
+	h := newTestHandler(t)
+	h.Cfg.AllowedUploadTypes = "video"
+	h.Cfg.UploadSessionTTLHours = 1
+	seedAccount(t, h.DB, "admin", "admin")
+	seedAccount(t, h.DB, "videos", "member")
+	seedAccount(t, h.DB, "images", "member")
+
+	r := chi.NewRouter()
+	r.Post("/admin/users/{id}/upload-types", h.AdminSetUploadTypes)
+	setTypes := func(id, types string) {
+		t.Helper()
+		req := httptest.NewRequest("POST", "/admin/users/"+id+"/upload-types", strings.NewReader("upload_types="+types))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, asAccount(req, "admin", "admin"))
+		if rec.Code != http.StatusSeeOther {
+			t.Fatalf("set upload types: status = %d", rec.Code)
+		}
+	}
+	setTypes("images", "image/png,+IMAGE/JPEG")
+	if a, _ := db.GetAccountByID(h.DB, "images"); a.AllowedUploadTypes == nil || *a.AllowedUploadTypes != "image/jpeg,image/png" {
+		t.Fatalf("stored override = %v", a.AllowedUploadTypes)
+	}
+	setTypes("videos", "application/pdf") // rejected, stays on the default
+	if a, _ := db.GetAccountByID(h.DB, "videos"); a.AllowedUploadTypes != nil {
+		t.Fatalf("invalid override stored: %s", *a.AllowedUploadTypes)
+	}
+
+	rec := uploadInit(h, "videos", 100)
+	if rec.Code != http.StatusUnsupportedMediaType || !strings.Contains(rec.Body.String(), "allowed: video/mp4, video/quicktime, video/x-matroska") {
+		t.Errorf("init png on video policy: status = %d, body %s", rec.Code, rec.Body)
+	}
+	if rec := uploadInit(h, "images", 100); rec.Code != http.StatusOK {
+		t.Errorf("init png with image override: status = %d, body %s", rec.Code, rec.Body)
+	}
+
+	rec = httptest.NewRecorder()
+	h.APIAssetUpload(rec, asAccount(multipartUpload("a.png", pngStream(1024)), "videos", "member"))
+	if rec.Code != http.StatusUnsupportedMediaType || !strings.Contains(rec.Body.String(), "not allowed") {
+		t.Errorf("api png on video policy: status = %d, body %s", rec.Code, rec.Body)
+	}
+	if entries, _ := os.ReadDir(filepath.Join(h.Cfg.DataDir, "originals")); len(entries) != 0 {
+		t.Errorf("rejected upload left %d asset dirs", len(entries))
+	}
+	rec = httptest.NewRecorder()
+	h.APIAssetUpload(rec, asAccount(multipartUpload("a.png", pngStream(1024)), "images", "member"))
+	if rec.Code != http.StatusCreated {
+		t.Errorf("api png with image override: status = %d, body %s", rec.Code, rec.Body)
+	}
+
+	// A chunked upload is checked again against its content: video declared
+	// as image/png is refused on an image-only account.
+	cr := chi.NewRouter()
+	cr.Put("/upload/chunks/{sessionID}/{chunkIndex}", h.UploadChunk)
+	cr.Post("/upload/chunks/{sessionID}/complete", h.UploadComplete)
+	chunked := func(content string) *httptest.ResponseRecorder {
+		t.Helper()
+		rec := uploadInit(h, "images", int64(len(content)))
+		var init struct {
+			SessionID string `json:"session_id"`
+		}
+		json.NewDecoder(rec.Body).Decode(&init)
+		rec = httptest.NewRecorder()
+		cr.ServeHTTP(rec, asAccount(httptest.NewRequest("PUT", "/upload/chunks/"+init.SessionID+"/0", strings.NewReader(content)), "images", "member"))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("chunk: status = %d: %s", rec.Code, rec.Body)
+		}
+		rec = httptest.NewRecorder()
+		cr.ServeHTTP(rec, asAccount(httptest.NewRequest("POST", "/upload/chunks/"+init.SessionID+"/complete", nil), "images", "member"))
+		return rec
+	}
+	mp4 := "\x00\x00\x00\x18ftypmp42\x00\x00\x00\x00mp42isom" + strings.Repeat("\x00", 200)
+	if rec := chunked(mp4); rec.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("chunked mp4 declared as png: status = %d, body %s", rec.Code, rec.Body)
+	}
+	if rec := chunked("not an image at all"); rec.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("chunked unrecognised content declared as png: status = %d, body %s", rec.Code, rec.Body)
+	}
+	var videos int
+	h.DB.QueryRow(`SELECT COUNT(*) FROM assets WHERE account_id = 'images' AND asset_type = 'video'`).Scan(&videos)
+	if videos != 0 {
+		t.Errorf("image-only account stored %d video assets", videos)
+	}
+	if rec := chunked("\x89PNG\r\n\x1a\n" + strings.Repeat("x", 200)); rec.Code != http.StatusOK {
+		t.Errorf("chunked png: status = %d, body %s", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,52 @@
+// Package logging correlates log lines with the HTTP request that caused
+// them. The request ID travels in a context.Context; Handler adds it to every
+// record logged with one of the slog ...Context functions.
+package logging
+
+import (
+	"context"
+	"log/slog"
+)
+
+type requestIDKey struct{}
+
+// WithRequestID returns ctx carrying the request ID id. An empty id leaves
+// ctx unchanged.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	if id == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request ID carried by ctx, or "".
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// Handler wraps a slog.Handler, adding a request_id attribute to records
+// whose context carries a request ID.
+type Handler struct {
+	slog.Handler
+}
+
+// NewHandler returns next wrapped in a Handler.
+func NewHandler(next slog.Handler) *Handler {
+	return &Handler{next}
+}
+
+func (h *Handler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &Handler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h *Handler) WithGroup(name string) slog.Handler {
+	return &Handler{h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,35 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestHandlerAddsRequestID(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(NewHandler(slog.NewTextHandler(&buf, nil))).With("component", "test")
+
+	ctx := WithRequestID(context.Background(), "req-42")
+	logger.InfoContext(ctx, "with id")
+	logger.Info("without id")
+	logger.InfoContext(WithRequestID(context.Background(), ""), "empty id")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("got %d lines:\n%s", len(lines), buf.String())
+	}
+	if !strings.Contains(lines[0], "request_id=req-42") || !strings.Contains(lines[0], "component=test") {
+		t.Errorf("line with id = %s", lines[0])
+	}
+	for _, l := range lines[1:] {
+		if strings.Contains(l, "request_id") {
+			t.Errorf("unexpected request_id: %s", l)
+		}
+	}
+	if got := RequestID(ctx); got != "req-42" {
+		t.Errorf("RequestID = %q", got)
+	}
+}
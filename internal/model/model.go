@@ -10,12 +10,21 @@ type Account struct {
 	Role              string
 	Enabled           bool
 	NotifyOnDownload  bool
+	TOTPSecret        string // base32; empty when two-factor login is off
+	TOTPLastStep      int64  // time step of the last login code accepted; 0 for none
+	DefaultGroupID    string // recipient group pre-selected for new campaigns; empty for none
+	MaxCampaigns      *int   // overrides Config.MaxCampaignsPerAccount; nil uses it, 0 is unlimited
 	CreatedAt         time.Time
+
+	// AllowedUploadTypes overrides Config.AllowedUploadTypes; nil uses it.
+	AllowedUploadTypes *string
 }
 
 type Session struct {
 	ID        string
 	AccountID string
+	UserAgent string // captured at login
+	IP        string // last client IP seen for this session
 	CreatedAt time.Time
 	ExpiresAt time.Time
 }
@@ -33,6 +42,11 @@ type Asset struct {
 	Width        *int64
 	Height       *int64
 	CreatedAt    time.Time
+	DeletedAt    *time.Time // set while soft-deleted, before the cleanup purge
+
+	// SourceMimeType is the uploaded format when the original was converted
+	// on upload (HEIC/AVIF to PNG); empty when stored as uploaded.
+	SourceMimeType string
 }
 
 type Recipient struct {
@@ -45,17 +59,53 @@ type Recipient struct {
 }
 
 type Campaign struct {
-	ID           string
-	AccountID    string
-	AssetID      string
-	Name         string
-	MaxDownloads *int
-	ExpiresAt    *time.Time
-	VisibleWM    bool
-	InvisibleWM  bool
-	State        string
-	CreatedAt    time.Time
-	PublishedAt  *time.Time
+	ID              string
+	AccountID       string
+	AssetID         string
+	Name            string
+	MaxDownloads    *int
+	ExpiresAt       *time.Time
+	VisibleWM       bool
+	InvisibleWM     bool
+	WMChannels      string   // invisible watermark channels override, e.g. "U,V"; empty inherits WM_CHANNELS
+	WMScale         *float64 // invisible watermark scale override; nil inherits WM_SCALE
+	WMTextTemplate  string   // visible watermark text/template; empty uses watermark.DefaultTextTemplate
+	VisiblePosition string   // visible watermark placement (watermark.Position*); empty is the default layout
+	VisibleOpacity  *float64 // visible watermark opacity 0-1; nil keeps the default
+	VisibleFontSize *int     // visible watermark font size; nil keeps the default
+	DownloadMessage string   // shown on the download page; sanitized at render
+	ExpiryMessage   string   // shown once a link is used up or expired; sanitized at render
+	LinkRequests    bool     // expired-link page offers a "request a new link" form
+	State           string
+	CreatedAt       time.Time
+	PublishedAt     *time.Time
+	PublishAt       *time.Time // when a SCHEDULED campaign is due to publish
+	ApprovedBy      string     // approver account ID; empty when not approved
+	ApprovedAt      *time.Time // nil when not approved
+	DeletedAt       *time.Time // set while soft-deleted, before the cleanup purge
+
+	// Cap on the downloads of all tokens together; nil is unlimited.
+	// TotalDownloads counts the downloads made against it.
+	MaxTotalDownloads *int
+	TotalDownloads    int
+
+	// Name of recipients' downloads, a text/template over
+	// watermark.TextData; empty follows DOWNLOAD_FILENAME.
+	FilenameTemplate string
+
+	// SelfVerify overrides WM_SELF_VERIFY for the campaign's invisible
+	// image embeds; nil inherits it.
+	SelfVerify *bool
+}
+
+// RemainingDownloads is how many downloads the campaign-wide budget still
+// allows, or nil when there is no budget.
+func (c Campaign) RemainingDownloads() *int {
+	if c.MaxTotalDownloads == nil {
+		return nil
+	}
+	n := max(*c.MaxTotalDownloads-c.TotalDownloads, 0)
+	return &n
 }
 
 type CampaignSummary struct {
@@ -82,6 +132,7 @@ type DownloadToken struct {
 	SHA256Output     *string
 	OutputSizeBytes  *int64
 	ExpiresAt        *time.Time
+	LinkEmailedAt    *time.Time // when the link was emailed at publish; nil if never
 	CreatedAt        time.Time
 }
 
@@ -102,6 +153,8 @@ type DownloadEvent struct {
 	AssetID     string
 	IPAddress   string
 	UserAgent   string
+	Country     string // ISO code; empty when GeoIP is not configured
+	City        string
 	CreatedAt   time.Time
 }
 
@@ -109,6 +162,8 @@ type Job struct {
 	ID           string
 	JobType      string
 	CampaignID   string
+	AccountID    string // owning account; the submitter for detect jobs
+	AssetID      string // thumbnail jobs only
 	TokenID      string
 	State        string
 	Progress     int
@@ -117,6 +172,8 @@ type Job struct {
 	ResultData   string
 	RetryCount   int
 	MaxRetries   int
+	Priority     int    // higher is claimed first; see db.JobPriorityOnDemand
+	RequestID    string // request that queued the job, for log correlation
 	CreatedAt    time.Time
 	StartedAt    *time.Time
 	CompletedAt  *time.Time
@@ -128,8 +185,10 @@ type APIKey struct {
 	Name       string
 	KeyPrefix  string
 	KeyHash    string
+	Scopes     string // comma-separated: read, write, admin
 	CreatedAt  time.Time
 	LastUsedAt *time.Time
+	ExpiresAt  *time.Time // nil: never expires
 }
 
 type Webhook struct {
@@ -167,6 +226,7 @@ type UploadSession struct {
 	ChunkSize      int64
 	TotalChunks    int
 	ReceivedChunks []int
+	ChunkHashes    []string // expected SHA-256 per chunk; empty when no manifest was sent
 	Status         string
 	StoragePath    string
 	CreatedAt      time.Time
@@ -3,17 +3,154 @@ package watermark
 import (
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"hash"
 	"io"
 	"os"
+	"sort"
 	"strings"
+	"text/template"
+	"text/template/parse"
+	"time"
 )
 
-func WatermarkText(tokenID, recipientName string) string {
+// DefaultTextTemplate is the visible watermark text used when a campaign
+// sets no template of its own.
+const DefaultTextTemplate = "[{{.ShortID}} | {{.RecipientName}}]"
+
+// maxTextTemplateLen bounds both the template and the rendered text; longer
+// overlays do not fit on a frame anyway.
+const maxTextTemplateLen = 200
+
+// TextData is what a visible watermark text template can refer to.
+type TextData struct {
+	TokenID        string
+	ShortID        string // first 8 hex digits of SHA-256(TokenID)
+	RecipientName  string
+	RecipientEmail string
+	RecipientOrg   string
+	CampaignName   string
+	Date           string // YYYY-MM-DD, UTC
+}
+
+// NewTextData fills in TextData for a token, deriving ShortID and Date.
+func NewTextData(tokenID, recipientName, recipientEmail, recipientOrg, campaignName string, now time.Time) TextData {
 	h := sha256.Sum256([]byte(tokenID))
-	shortHash := hex.EncodeToString(h[:4])
-	return fmt.Sprintf("[%s | %s]", shortHash, recipientName)
+	return TextData{
+		TokenID:        tokenID,
+		ShortID:        hex.EncodeToString(h[:4]),
+		RecipientName:  recipientName,
+		RecipientEmail: recipientEmail,
+		RecipientOrg:   recipientOrg,
+		CampaignName:   campaignName,
+		Date:           now.UTC().Format("2006-01-02"),
+	}
+}
+
+// ValidateTextTemplate checks that tmpl parses and renders against sample
+// data, so a campaign cannot be created with a template that would fail
+// every job. An empty template is valid and means DefaultTextTemplate.
+func ValidateTextTemplate(tmpl string) error {
+	if tmpl == "" {
+		return nil
+	}
+	if len(tmpl) > maxTextTemplateLen {
+		return fmt.Errorf("watermark text template is longer than %d characters", maxTextTemplateLen)
+	}
+	sample := NewTextData("00000000-0000-0000-0000-000000000000", "Jane Doe", "jane@example.com", "Example Corp", "Campaign", time.Now())
+	text, err := WatermarkText(tmpl, sample)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(text) == "" {
+		return errors.New("watermark text template renders empty text")
+	}
+	return nil
+}
+
+// WatermarkText renders the visible watermark text for d from tmpl, a Go
+// text/template (DefaultTextTemplate when empty). Runs of whitespace,
+// including line breaks, collapse to one space and the result is cut to
+// maxTextTemplateLen characters.
+func WatermarkText(tmpl string, d TextData) (string, error) {
+	if tmpl == "" {
+		tmpl = DefaultTextTemplate
+	}
+	out, err := ExecuteTemplate("watermark text", tmpl, d)
+	if err != nil {
+		return "", err
+	}
+	text := strings.Join(strings.Fields(out), " ")
+	if r := []rune(text); len(r) > maxTextTemplateLen {
+		text = string(r[:maxTextTemplateLen])
+	}
+	return text, nil
+}
+
+// ExecuteTemplate renders tmpl, a text/template over TextData, for d. Only
+// field substitution ({{.Field}}), {{if .Field}}...{{else}}...{{end}} and
+// comments are allowed: range, with, functions and nested templates could
+// loop or build unbounded output, and templates are set by campaign owners
+// but rendered on public download requests. name prefixes errors.
+func ExecuteTemplate(name, tmpl string, d TextData) (string, error) {
+	t, err := template.New(name).Parse(tmpl)
+	if err != nil {
+		return "", fmt.Errorf("%s template: %w", name, err)
+	}
+	if len(t.Templates()) > 1 {
+		return "", fmt.Errorf("%s template: nested templates are not supported", name)
+	}
+	if t.Tree != nil && t.Tree.Root != nil {
+		if err := checkSubstitution(t.Tree.Root); err != nil {
+			return "", fmt.Errorf("%s template: %w", name, err)
+		}
+	}
+	var b strings.Builder
+	if err := t.Execute(&b, d); err != nil {
+		return "", fmt.Errorf("%s template: %w", name, err)
+	}
+	return b.String(), nil
+}
+
+// checkSubstitution rejects any node of a template tree that ExecuteTemplate
+// does not allow.
+func checkSubstitution(n parse.Node) error {
+	switch n := n.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return nil
+		}
+		for _, c := range n.Nodes {
+			if err := checkSubstitution(c); err != nil {
+				return err
+			}
+		}
+		return nil
+	case *parse.TextNode, *parse.CommentNode:
+		return nil
+	case *parse.ActionNode:
+		return checkFieldPipe(n.Pipe)
+	case *parse.IfNode:
+		if err := checkFieldPipe(n.Pipe); err != nil {
+			return err
+		}
+		if err := checkSubstitution(n.List); err != nil {
+			return err
+		}
+		return checkSubstitution(n.ElseList)
+	}
+	return fmt.Errorf("only {{.Field}} and {{if .Field}} are supported, not %s", n)
+}
+
+// checkFieldPipe accepts a pipeline that is a single field such as .Date.
+func checkFieldPipe(p *parse.PipeNode) error {
+	if p != nil && len(p.Decl) == 0 && len(p.Cmds) == 1 && len(p.Cmds[0].Args) == 1 {
+		if _, ok := p.Cmds[0].Args[0].(*parse.FieldNode); ok {
+			return nil
+		}
+	}
+	return fmt.Errorf("only {{.Field}} and {{if .Field}} are supported, not {{%s}}", p)
 }
 
 func EscapeFFmpegText(s string) string {
@@ -59,6 +196,9 @@ var MimeToExt = map[string]string{
 	"image/png":        ".png",
 	"image/tiff":       ".tiff",
 	"image/webp":       ".webp",
+	"image/heic":       ".heic",
+	"image/heif":       ".heif",
+	"image/avif":       ".avif",
 }
 
 var MimeToAssetType = map[string]string{
@@ -69,4 +209,49 @@ var MimeToAssetType = map[string]string{
 	"image/png":        "image",
 	"image/tiff":       "image",
 	"image/webp":       "image",
+	"image/heic":       "image",
+	"image/heif":       "image",
+	"image/avif":       "image",
+}
+
+// ParseUploadTypes reads an upload policy: a comma-separated list of MIME
+// types from MimeToExt and the shorthands "image" and "video" for every
+// type of that kind. An empty string or "*" allows every supported type.
+// The result is sorted; a type the application cannot process is an error,
+// so a policy can narrow MimeToExt but never extend it.
+func ParseUploadTypes(s string) ([]string, error) {
+	s = strings.TrimSpace(s)
+	set := map[string]bool{}
+	if s == "" || s == "*" {
+		for m := range MimeToExt {
+			set[m] = true
+		}
+	} else {
+		for _, part := range strings.Split(s, ",") {
+			part = strings.ToLower(strings.TrimSpace(part))
+			switch {
+			case part == "":
+				continue
+			case part == "image" || part == "video":
+				for m, kind := range MimeToAssetType {
+					if kind == part {
+						set[m] = true
+					}
+				}
+			case MimeToExt[part] != "":
+				set[part] = true
+			default:
+				return nil, fmt.Errorf("upload types: unsupported type %q", part)
+			}
+		}
+		if len(set) == 0 {
+			return nil, errors.New("upload types: no types listed")
+		}
+	}
+	types := make([]string, 0, len(set))
+	for m := range set {
+		types = append(types, m)
+	}
+	sort.Strings(types)
+	return types, nil
 }
@@ -0,0 +1,83 @@
+package watermark
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWatermarkText(t *testing.T) {
+	d := NewTextData("tok-1", "Jane Doe", "jane@example.com", "Acme", "Launch", time.Date(2026, 3, 4, 23, 0, 0, 0, time.UTC))
+	if len(d.ShortID) != 8 || d.Date != "2026-03-04" {
+		t.Fatalf("NewTextData = %+v", d)
+	}
+
+	got, err := WatermarkText("", d)
+	if err != nil || got != "["+d.ShortID+" | Jane Doe]" {
+		t.Errorf("default = %q, %v", got, err)
+	}
+	got, err = WatermarkText("CONFIDENTIAL — {{.RecipientName}} ({{.RecipientEmail}})\n{{.Date}}", d)
+	if err != nil || got != "CONFIDENTIAL — Jane Doe (jane@example.com) 2026-03-04" {
+		t.Errorf("custom = %q, %v", got, err)
+	}
+	got, _ = WatermarkText(strings.Repeat("{{.CampaignName}}", 100), d)
+	if len([]rune(got)) != maxTextTemplateLen {
+		t.Errorf("long output has %d runes, want %d", len([]rune(got)), maxTextTemplateLen)
+	}
+}
+
+func TestValidateTextTemplate(t *testing.T) {
+	for _, ok := range []string{"", "{{.RecipientOrg}} {{.ShortID}}", "static text", "{{if .RecipientOrg}}{{.RecipientOrg}}{{else}}{{.RecipientName}}{{end}}"} {
+		if err := ValidateTextTemplate(ok); err != nil {
+			t.Errorf("ValidateTextTemplate(%q) = %v", ok, err)
+		}
+	}
+	for _, bad := range []string{
+		"{{.RecipientName", "{{.Phone}}", "   {{/* nothing */}}", strings.Repeat("x", 201),
+		// anything that could loop or build large output
+		`{{range 100000000}}{{printf "%0100d" 1}}{{end}}`,
+		`{{printf "%0999999999d" 1}}`,
+		`{{.RecipientName | printf "%s"}}`,
+		`{{with .RecipientName}}{{.}}{{end}}`,
+		`{{define "x"}}{{template "x"}}{{end}}{{template "x"}}`,
+		`{{$x := .RecipientName}}{{$x}}`,
+	} {
+		if err := ValidateTextTemplate(bad); err == nil {
+			t.Errorf("ValidateTextTemplate(%q) accepted", bad)
+		}
+	}
+}
+
+func TestParseUploadTypes(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"", "*"},
+		{" * ", "*"},
+		{"video", "video/mp4,video/quicktime,video/x-matroska"},
+		{"image/PNG, image/jpeg,", "image/jpeg,image/png"},
+		{"video/mp4,video", "video/mp4,video/quicktime,video/x-matroska"},
+	}
+	for _, tc := range tests {
+		got, err := ParseUploadTypes(tc.in)
+		if err != nil {
+			t.Errorf("ParseUploadTypes(%q): %v", tc.in, err)
+			continue
+		}
+		if tc.want == "*" {
+			if len(got) != len(MimeToExt) {
+				t.Errorf("ParseUploadTypes(%q) = %v, want every supported type", tc.in, got)
+			}
+			continue
+		}
+		if s := strings.Join(got, ","); s != tc.want {
+			t.Errorf("ParseUploadTypes(%q) = %s, want %s", tc.in, s, tc.want)
+		}
+	}
+	for _, bad := range []string{"application/pdf", "images", ",", "video/*"} {
+		if _, err := ParseUploadTypes(bad); err == nil {
+			t.Errorf("ParseUploadTypes(%q) accepted", bad)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package watermark
+
+import (
+	"fmt"
+	"image"
+	"math"
+)
+
+// compareMaxEdge bounds the long edge of the luma planes CompareImages works
+// on, so comparing two 50-megapixel images stays cheap.
+const compareMaxEdge = 1024
+
+// LeakMetrics describes how a leaked image differs from the original asset:
+// whether it was resized or cropped and how much quality it lost.
+type LeakMetrics struct {
+	OriginalWidth  int `json:"original_width"`
+	OriginalHeight int `json:"original_height"`
+	LeakedWidth    int `json:"leaked_width"`
+	LeakedHeight   int `json:"leaked_height"`
+
+	// Scale is leaked width / original width.
+	Scale float64 `json:"scale"`
+	// AspectChanged is set when the aspect ratios differ by more than 1%,
+	// which usually means the leak was cropped or padded.
+	AspectChanged bool `json:"aspect_changed"`
+
+	// PSNR (dB) and SSIM of the luma channel, measured after resampling the
+	// leak to the original's geometry. PSNR is capped at 100 for identical
+	// pixels.
+	PSNR float64 `json:"psnr_db"`
+	SSIM float64 `json:"ssim"`
+}
+
+// CompareImageFiles decodes both images and compares them with CompareImages.
+func CompareImageFiles(originalPath, leakedPath string) (*LeakMetrics, error) {
+	orig, err := decodeImageFile(originalPath)
+	if err != nil {
+		return nil, fmt.Errorf("original: %w", err)
+	}
+	leaked, err := decodeImageFile(leakedPath)
+	if err != nil {
+		return nil, fmt.Errorf("leaked: %w", err)
+	}
+	m := CompareImages(orig, leaked)
+	return &m, nil
+}
+
+// CompareImages measures leaked against orig. Both are reduced to luma at a
+// common size (the original's, capped at compareMaxEdge) before PSNR and
+// SSIM are computed.
+func CompareImages(orig, leaked image.Image) LeakMetrics {
+	ob, lb := orig.Bounds(), leaked.Bounds()
+	m := LeakMetrics{
+		OriginalWidth:  ob.Dx(),
+		OriginalHeight: ob.Dy(),
+		LeakedWidth:    lb.Dx(),
+		LeakedHeight:   lb.Dy(),
+	}
+	if ob.Dx() == 0 || ob.Dy() == 0 || lb.Dx() == 0 || lb.Dy() == 0 {
+		return m
+	}
+	m.Scale = float64(lb.Dx()) / float64(ob.Dx())
+	origAspect := float64(ob.Dx()) / float64(ob.Dy())
+	leakAspect := float64(lb.Dx()) / float64(lb.Dy())
+	m.AspectChanged = math.Abs(leakAspect/origAspect-1) > 0.01
+
+	w, h := ob.Dx(), ob.Dy()
+	if long := max(w, h); long > compareMaxEdge {
+		w = max(1, w*compareMaxEdge/long)
+		h = max(1, h*compareMaxEdge/long)
+	}
+	a := resampleLuma(orig, w, h)
+	b := resampleLuma(leaked, w, h)
+	m.PSNR = psnr(a, b)
+	m.SSIM = ssim(a, b, w, h)
+	return m
+}
+
+// resampleLuma returns img's BT.601 luma resampled to w×h, row-major.
+// Pixels are box-averaged into the target grid as they are read, so a large
+// image never needs a full-size plane; a dimension smaller than the target is
+// then stretched with bilinear interpolation.
+func resampleLuma(img image.Image, w, h int) []float64 {
+	b := img.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+	gw, gh := min(sw, w), min(sh, h)
+	luma := lumaReader(img)
+	src := make([]float64, gw*gh)
+	count := make([]int, gw*gh)
+	for y := 0; y < sh; y++ {
+		row := y * gh / sh * gw
+		for x := 0; x < sw; x++ {
+			i := row + x*gw/sw
+			src[i] += luma(b.Min.X+x, b.Min.Y+y)
+			count[i]++
+		}
+	}
+	for i := range src {
+		src[i] /= float64(count[i])
+	}
+	if gw == w && gh == h {
+		return src
+	}
+
+	dst := make([]float64, w*h)
+	for y := 0; y < h; y++ {
+		fy := (float64(y)+0.5)*float64(gh)/float64(h) - 0.5
+		y0 := clampInt(int(math.Floor(fy)), 0, gh-1)
+		y1 := clampInt(y0+1, 0, gh-1)
+		ty := fy - math.Floor(fy)
+		for x := 0; x < w; x++ {
+			fx := (float64(x)+0.5)*float64(gw)/float64(w) - 0.5
+			x0 := clampInt(int(math.Floor(fx)), 0, gw-1)
+			x1 := clampInt(x0+1, 0, gw-1)
+			tx := fx - math.Floor(fx)
+			top := src[y0*gw+x0]*(1-tx) + src[y0*gw+x1]*tx
+			bot := src[y1*gw+x0]*(1-tx) + src[y1*gw+x1]*tx
+			dst[y*w+x] = top*(1-ty) + bot*ty
+		}
+	}
+	return dst
+}
+
+// lumaReader returns a function giving the BT.601 luma (0-255) of a pixel of
+// img. The decoders' own color models are read directly rather than through
+// At, which allocates for every pixel.
+func lumaReader(img image.Image) func(x, y int) float64 {
+	var rgb func(x, y int) (r, g, b, a uint32)
+	switch m := img.(type) {
+	case *image.YCbCr:
+		rgb = func(x, y int) (r, g, b, a uint32) { return m.YCbCrAt(x, y).RGBA() }
+	case *image.NRGBA:
+		rgb = func(x, y int) (r, g, b, a uint32) { return m.NRGBAAt(x, y).RGBA() }
+	case *image.RGBA:
+		rgb = func(x, y int) (r, g, b, a uint32) { return m.RGBAAt(x, y).RGBA() }
+	case *image.Gray:
+		rgb = func(x, y int) (r, g, b, a uint32) { return m.GrayAt(x, y).RGBA() }
+	default:
+		rgb = func(x, y int) (r, g, b, a uint32) { return img.At(x, y).RGBA() }
+	}
+	return func(x, y int) float64 {
+		r, g, b, _ := rgb(x, y)
+		return (0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)) / 257
+	}
+}
+
+func clampInt(v, lo, hi int) int {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
+
+func psnr(a, b []float64) float64 {
+	var mse float64
+	for i := range a {
+		d := a[i] - b[i]
+		mse += d * d
+	}
+	mse /= float64(len(a))
+	if mse < 1e-10 {
+		return 100
+	}
+	return math.Min(100, 10*math.Log10(255*255/mse))
+}
+
+// ssim is the mean structural similarity over non-overlapping 8×8 windows
+// (the whole image if it is smaller than one window).
+func ssim(a, b []float64, w, h int) float64 {
+	const (
+		c1 = (0.01 * 255) * (0.01 * 255)
+		c2 = (0.03 * 255) * (0.03 * 255)
+	)
+	win := 8
+	if w < win || h < win {
+		win = min(w, h)
+	}
+	var total float64
+	var windows int
+	for wy := 0; wy+win <= h; wy += win {
+		for wx := 0; wx+win <= w; wx += win {
+			var ma, mb float64
+			for y := wy; y < wy+win; y++ {
+				for x := wx; x < wx+win; x++ {
+					ma += a[y*w+x]
+					mb += b[y*w+x]
+				}
+			}
+			n := float64(win * win)
+			ma /= n
+			mb /= n
+			var va, vb, cov float64
+			for y := wy; y < wy+win; y++ {
+				for x := wx; x < wx+win; x++ {
+					da, db := a[y*w+x]-ma, b[y*w+x]-mb
+					va += da * da
+					vb += db * db
+					cov += da * db
+				}
+			}
+			va /= n - 1
+			vb /= n - 1
+			cov /= n - 1
+			total += ((2*ma*mb + c1) * (2*cov + c2)) / ((ma*ma + mb*mb + c1) * (va + vb + c2))
+			windows++
+		}
+	}
+	if windows == 0 {
+		return 0
+	}
+	return total / float64(windows)
+}
@@ -0,0 +1,100 @@
+package watermark
+
+import (
+	"image"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCompareImageFilesRecompressed(t *testing.T) {
+	dir := t.TempDir()
+	origPath := filepath.Join(dir, "orig.png")
+	writeTestImage(t, origPath, 256)
+
+	identical, err := CompareImageFiles(origPath, origPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identical.PSNR != 100 || identical.SSIM < 0.999 || identical.Scale != 1 || identical.AspectChanged {
+		t.Fatalf("identical files: got %+v", identical)
+	}
+
+	orig, err := loadImageNRGBA(origPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeJPEG := func(name string, img image.Image, quality int) string {
+		path := filepath.Join(dir, name)
+		f, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if err := jpeg.Encode(f, img, &jpeg.Options{Quality: quality}); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	high, err := CompareImageFiles(origPath, writeJPEG("q90.jpg", orig, 90))
+	if err != nil {
+		t.Fatal(err)
+	}
+	low, err := CompareImageFiles(origPath, writeJPEG("q20.jpg", orig, 20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, m := range map[string]*LeakMetrics{"q90": high, "q20": low} {
+		if m.Scale != 1 || m.AspectChanged {
+			t.Errorf("%s: geometry changed: %+v", name, m)
+		}
+		if m.PSNR >= 100 || m.PSNR < 20 {
+			t.Errorf("%s: PSNR = %.2f, want a finite value above 20 dB", name, m.PSNR)
+		}
+		if m.SSIM >= 1 || m.SSIM < 0.3 {
+			t.Errorf("%s: SSIM = %.3f, want within (0.3, 1)", name, m.SSIM)
+		}
+	}
+	if low.PSNR >= high.PSNR || low.SSIM >= high.SSIM {
+		t.Errorf("quality 20 should score below quality 90: q20 %+v, q90 %+v", low, high)
+	}
+
+	// A half-size copy is upscaled back for comparison and still resembles
+	// the original.
+	half := image.NewNRGBA(image.Rect(0, 0, 128, 128))
+	for y := 0; y < 128; y++ {
+		for x := 0; x < 128; x++ {
+			half.Set(x, y, orig.At(x*2, y*2))
+		}
+	}
+	resized, err := CompareImageFiles(origPath, writeJPEG("half.jpg", half, 90))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resized.Scale != 0.5 || resized.AspectChanged || resized.LeakedWidth != 128 {
+		t.Errorf("half-size copy: got %+v", resized)
+	}
+	if resized.SSIM < 0.3 {
+		t.Errorf("half-size copy: SSIM = %.3f, want > 0.3", resized.SSIM)
+	}
+}
+
+func TestResampleLumaBoxAveragesLargerImages(t *testing.T) {
+	// A pixel-doubled copy averages back to the original exactly.
+	small := image.NewGray(image.Rect(0, 0, 64, 32))
+	big := image.NewGray(image.Rect(0, 0, 128, 64))
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 64; x++ {
+			v := uint8((x*7 + y*13) % 256)
+			small.Pix[small.PixOffset(x, y)] = v
+			for d := 0; d < 4; d++ {
+				big.Pix[big.PixOffset(2*x+d%2, 2*y+d/2)] = v
+			}
+		}
+	}
+	if m := CompareImages(small, big); m.PSNR != 100 || m.Scale != 2 {
+		t.Errorf("pixel-doubled copy: got %+v", m)
+	}
+}
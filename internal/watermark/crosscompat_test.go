@@ -0,0 +1,126 @@
+//go:build pythonwm
+
+// Cross-compatibility between the Go DWT-DCT-SVD implementation and the
+// Python invisible-watermark scripts it replaces. Files embedded by either
+// must detect with the other, or detection of older files (or the Python
+// fallback in the worker) silently breaks. Needs a venv with
+// invisible-watermark and opencv; run with
+//
+//	VENV_PATH=/opt/venv go test -tags pythonwm ./internal/watermark/
+package watermark
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// pythonTools returns the venv's python and the embed and detect scripts,
+// skipping the test when the venv lacks the watermark libraries.
+func pythonTools(t *testing.T) (python, embedScript, detectScript string) {
+	t.Helper()
+	venv := os.Getenv("VENV_PATH")
+	if venv == "" {
+		venv = "/opt/venv"
+	}
+	python = filepath.Join(venv, "bin", "python3")
+	if out, err := exec.Command(python, "-c", "import cv2, imwatermark").CombinedOutput(); err != nil {
+		t.Skipf("python venv %s unusable: %v\n%s", venv, err, out)
+	}
+	_, file, _, _ := runtime.Caller(0)
+	scripts := filepath.Join(filepath.Dir(file), "..", "..", "scripts")
+	return python, filepath.Join(scripts, "embed_watermark.py"), filepath.Join(scripts, "detect_watermark.py")
+}
+
+// writeStripedJPEG writes a w x h JPEG of noisy diagonal stripes, a sample
+// that is neither square nor a multiple of the DWT block size.
+func writeStripedJPEG(t *testing.T, path string, w, h int) {
+	t.Helper()
+	rng := rand.New(rand.NewSource(11))
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			v := uint8(90 + ((x+y)/9%2)*70 + rng.Intn(30))
+			img.SetRGBA(x, y, color.RGBA{R: v, G: 200 - v/2, B: 255 - v, A: 255})
+		}
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := jpeg.Encode(f, img, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// crossCompatSamples writes the sample images and returns their paths.
+func crossCompatSamples(t *testing.T) []string {
+	dir := t.TempDir()
+	samples := []string{
+		filepath.Join(dir, "gradient-256.png"),
+		filepath.Join(dir, "gradient-512.png"),
+		filepath.Join(dir, "stripes-333x250.jpg"),
+	}
+	writeTestImage(t, samples[0], 256)
+	writeTestImage(t, samples[1], 512)
+	writeStripedJPEG(t, samples[2], 333, 250)
+	return samples
+}
+
+func TestCrossCompatGoEmbedPythonDetect(t *testing.T) {
+	python, _, detectScript := pythonTools(t)
+	ctx := context.Background()
+	payload := PayloadHex("go-embed", "camp")
+
+	for _, in := range crossCompatSamples(t) {
+		for _, ext := range []string{".png", ".jpg"} {
+			name := filepath.Base(in) + ext
+			t.Run(name, func(t *testing.T) {
+				out := filepath.Join(t.TempDir(), "out"+ext)
+				if err := GoInvisibleImageEmbed(ctx, in, out, payload, 92); err != nil {
+					t.Fatal(err)
+				}
+				got, err := InvisibleImageDetect(ctx, out, python, detectScript, PayloadLength)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got != payload {
+					t.Errorf("python detected %s, want %s", got, payload)
+				}
+			})
+		}
+	}
+}
+
+func TestCrossCompatPythonEmbedGoDetect(t *testing.T) {
+	python, embedScript, _ := pythonTools(t)
+	ctx := context.Background()
+	payload := PayloadHex("py-embed", "camp")
+
+	for _, in := range crossCompatSamples(t) {
+		for _, ext := range []string{".png", ".jpg"} {
+			name := filepath.Base(in) + ext
+			t.Run(name, func(t *testing.T) {
+				out := filepath.Join(t.TempDir(), "out"+ext)
+				if err := InvisibleImageEmbed(ctx, in, out, payload, python, embedScript, 92); err != nil {
+					t.Fatal(err)
+				}
+				got, err := GoInvisibleImageDetect(ctx, out, PayloadLength)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got != payload {
+					t.Errorf("go detected %s, want %s", got, payload)
+				}
+			})
+		}
+	}
+}
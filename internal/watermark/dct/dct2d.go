@@ -110,3 +110,99 @@ func Inverse2D(block [][]float64) [][]float64 {
 	}
 	return out
 }
+
+// Block transforms n x n blocks like Forward2D and Inverse2D, with the
+// cosines precomputed and scratch space reused, so that transforming a block
+// does not allocate. Results are bit-for-bit those of Forward2D/Inverse2D.
+// A Block is not safe for concurrent use.
+type Block struct {
+	n      int
+	cos    []float64 // cos[k*n+i] = cos(pi*k*(2i+1)/(2n))
+	scale0 float64
+	scaleK float64
+	tmp    [][]float64
+	in     []float64
+	out    []float64
+}
+
+// NewBlock returns a Block for n x n blocks.
+func NewBlock(n int) *Block {
+	b := &Block{
+		n:      n,
+		cos:    make([]float64, n*n),
+		scale0: math.Sqrt(1.0 / float64(n)),
+		scaleK: math.Sqrt(2.0 / float64(n)),
+		tmp:    make([][]float64, n),
+		in:     make([]float64, n),
+		out:    make([]float64, n),
+	}
+	for k := 0; k < n; k++ {
+		for i := 0; i < n; i++ {
+			b.cos[k*n+i] = math.Cos(math.Pi * float64(k) * float64(2*i+1) / (2.0 * float64(n)))
+		}
+	}
+	for y := range b.tmp {
+		b.tmp[y] = make([]float64, n)
+	}
+	return b
+}
+
+func (b *Block) forward1D(x, out []float64) {
+	n := b.n
+	for k := 0; k < n; k++ {
+		scale := b.scaleK
+		if k == 0 {
+			scale = b.scale0
+		}
+		sum := 0.0
+		for i := 0; i < n; i++ {
+			sum += x[i] * b.cos[k*n+i]
+		}
+		out[k] = scale * sum
+	}
+}
+
+func (b *Block) inverse1D(X, out []float64) {
+	n := b.n
+	for i := 0; i < n; i++ {
+		sum := b.scale0 * X[0]
+		for k := 1; k < n; k++ {
+			sum += b.scaleK * X[k] * b.cos[k*n+i]
+		}
+		out[i] = sum
+	}
+}
+
+// Forward writes the 2D Type-II DCT of src into dst. Both must be n x n;
+// they may be the same block.
+func (b *Block) Forward(dst, src [][]float64) {
+	for y := 0; y < b.n; y++ {
+		b.forward1D(src[y], b.tmp[y])
+	}
+	for x := 0; x < b.n; x++ {
+		for y := 0; y < b.n; y++ {
+			b.in[y] = b.tmp[y][x]
+		}
+		b.forward1D(b.in, b.out)
+		for y := 0; y < b.n; y++ {
+			dst[y][x] = b.out[y]
+		}
+	}
+}
+
+// Inverse writes the 2D Type-III DCT of src into dst. Both must be n x n;
+// they may be the same block.
+func (b *Block) Inverse(dst, src [][]float64) {
+	for x := 0; x < b.n; x++ {
+		for y := 0; y < b.n; y++ {
+			b.in[y] = src[y][x]
+		}
+		b.inverse1D(b.in, b.out)
+		for y := 0; y < b.n; y++ {
+			b.tmp[y][x] = b.out[y]
+		}
+	}
+	for y := 0; y < b.n; y++ {
+		b.inverse1D(b.tmp[y], dst[y])
+	}
+}
@@ -126,3 +126,35 @@ func TestKnown4x4Reference(t *testing.T) {
 		t.Errorf("4x4 reference round-trip max diff = %e, want < %e", d, roundTripEpsilon)
 	}
 }
+
+// TestBlockMatchesForward2D checks that the allocation-free Block gives
+// exactly the results of Forward2D and Inverse2D, in place.
+func TestBlockMatchesForward2D(t *testing.T) {
+	rng := rand.New(rand.NewSource(3))
+	b := dct.NewBlock(4)
+	for i := 0; i < 100; i++ {
+		src := makeBlock(4, 4, rng)
+		want := dct.Forward2D(src)
+		wantInv := dct.Inverse2D(want)
+
+		got := makeBlock(4, 4, rng)
+		for y := range src {
+			copy(got[y], src[y])
+		}
+		b.Forward(got, got)
+		if d := maxAbsDiff(got, want); d != 0 {
+			t.Fatalf("Forward differs by %g", d)
+		}
+		b.Inverse(got, got)
+		if d := maxAbsDiff(got, wantInv); d != 0 {
+			t.Fatalf("Inverse differs by %g", d)
+		}
+	}
+	blk := makeBlock(4, 4, rng)
+	if n := testing.AllocsPerRun(100, func() {
+		b.Forward(blk, blk)
+		b.Inverse(blk, blk)
+	}); n != 0 {
+		t.Errorf("Block allocates %v times per transform", n)
+	}
+}
@@ -0,0 +1,99 @@
+package watermark
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"os"
+	"strings"
+)
+
+const (
+	// maxEmbeddablePalette is the largest palette treated as too coarse to
+	// carry the payload: with this few colours the U channel is a handful of
+	// flat levels and the embed is lost when the output is requantised.
+	maxEmbeddablePalette = 64
+	// minChromaStdDev is the smallest U-channel standard deviation (in 0-255
+	// units) at which the DWT-DCT-SVD embed reliably survives.
+	minChromaStdDev = 2.0
+	// chromaSampleStep subsamples large images when measuring chroma.
+	chromaSampleStep = 4
+)
+
+// WM_LOW_CHROMA policies for images IsEmbeddable rejects.
+const (
+	LowChromaWarn    = "warn"    // log and embed anyway
+	LowChromaVisible = "visible" // visible overlay only
+	LowChromaConvert = "convert" // expand indexed images to full colour first
+)
+
+// ParseLowChroma normalizes a WM_LOW_CHROMA value. An empty string is
+// LowChromaWarn; anything other than the three policies is an error.
+func ParseLowChroma(s string) (string, error) {
+	switch v := strings.ToLower(strings.TrimSpace(s)); v {
+	case "":
+		return LowChromaWarn, nil
+	case LowChromaWarn, LowChromaVisible, LowChromaConvert:
+		return v, nil
+	}
+	return "", fmt.Errorf("low chroma policy: unknown value %q (want warn, visible or convert)", s)
+}
+
+// IsEmbeddable reports whether img has enough chroma detail for the
+// invisible watermark. Indexed images with a small palette and images whose
+// U channel is nearly flat (greyscale, line art, flat graphics) embed
+// without error but usually produce marks that cannot be detected.
+func IsEmbeddable(img image.Image) bool {
+	if p, ok := img.(*image.Paletted); ok && len(p.Palette) <= maxEmbeddablePalette {
+		return false
+	}
+	return chromaStdDev(img) >= minChromaStdDev
+}
+
+// chromaStdDev returns the standard deviation of the U channel, sampled on a
+// chromaSampleStep grid, using the same YUV conversion as the embedder.
+func chromaStdDev(img image.Image) float64 {
+	b := img.Bounds()
+	var n, sum, sumSq float64
+	for y := b.Min.Y; y < b.Max.Y; y += chromaSampleStep {
+		for x := b.Min.X; x < b.Max.X; x += chromaSampleStep {
+			r, g, bl, _ := img.At(x, y).RGBA()
+			u := -0.14713*float64(r>>8) - 0.28886*float64(g>>8) + 0.436*float64(bl>>8)
+			sum += u
+			sumSq += u * u
+			n++
+		}
+	}
+	if n == 0 {
+		return 0
+	}
+	mean := sum / n
+	return math.Sqrt(math.Max(sumSq/n-mean*mean, 0))
+}
+
+// CheckEmbeddable decodes the image at path and reports whether it is
+// embeddable (see IsEmbeddable) and whether it is an indexed image.
+func CheckEmbeddable(path string) (embeddable, paletted bool, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, false, err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return false, false, fmt.Errorf("decode %s: %w", path, err)
+	}
+	_, paletted = img.(*image.Paletted)
+	return IsEmbeddable(img), paletted, nil
+}
+
+// ConvertToTrueColor rewrites the image at inputPath as an 8-bit RGBA PNG at
+// outputPath (which must end in .png), expanding any palette so later steps
+// do not requantise it.
+func ConvertToTrueColor(inputPath, outputPath string) error {
+	img, err := loadImageNRGBA(inputPath)
+	if err != nil {
+		return err
+	}
+	return saveImage(img, outputPath, 0)
+}
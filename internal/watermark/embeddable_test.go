@@ -0,0 +1,102 @@
+package watermark
+
+import (
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writePNG(t *testing.T, path string, img image.Image) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := png.Encode(f, img); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// palettedImage draws coloured stripes using the first n entries of the
+// web-safe palette.
+func palettedImage(n int) *image.Paletted {
+	img := image.NewPaletted(image.Rect(0, 0, 64, 64), palette.WebSafe[:n])
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			img.SetColorIndex(x, y, uint8((x/4+y/4)%n))
+		}
+	}
+	return img
+}
+
+func TestIsEmbeddable(t *testing.T) {
+	dir := t.TempDir()
+	photo := filepath.Join(dir, "photo.png")
+	writeTestImage(t, photo, 64)
+	img, err := loadImageNRGBA(photo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	grey := image.NewGray(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			grey.SetGray(x, y, color.Gray{Y: uint8(x * 4)})
+		}
+	}
+
+	tests := []struct {
+		name string
+		img  image.Image
+		want bool
+	}{
+		{"colour photo", img, true},
+		{"greyscale", grey, false},
+		{"16-colour palette", palettedImage(16), false},
+		{"216-colour palette", palettedImage(216), true},
+	}
+	for _, tt := range tests {
+		if got := IsEmbeddable(tt.img); got != tt.want {
+			t.Errorf("%s: IsEmbeddable = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCheckEmbeddablePalettedPNG(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "indexed.png")
+	writePNG(t, in, palettedImage(8))
+
+	embeddable, paletted, err := CheckEmbeddable(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if embeddable || !paletted {
+		t.Fatalf("CheckEmbeddable = %v, %v; want false, true", embeddable, paletted)
+	}
+
+	out := filepath.Join(dir, "truecolor.png")
+	if err := ConvertToTrueColor(in, out); err != nil {
+		t.Fatal(err)
+	}
+	if _, paletted, err = CheckEmbeddable(out); err != nil || paletted {
+		t.Fatalf("converted image: paletted = %v, err = %v", paletted, err)
+	}
+}
+
+func TestParseLowChroma(t *testing.T) {
+	for in, want := range map[string]string{"": "warn", "warn": "warn", " Visible ": "visible", "CONVERT": "convert"} {
+		if got, err := ParseLowChroma(in); err != nil || got != want {
+			t.Errorf("ParseLowChroma(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	for _, bad := range []string{"skip", "off", "warn,visible"} {
+		if _, err := ParseLowChroma(bad); err == nil {
+			t.Errorf("ParseLowChroma(%q) accepted", bad)
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"os/exec"
+	"strings"
 )
 
 type VideoParams struct {
@@ -11,27 +12,50 @@ type VideoParams struct {
 	OutputPath string
 	Text       string
 	FontPath   string
+
+	// Placement and look of the text; zero values keep the default layout
+	// (see PositionDefault), 0.15/0.08 opacity and 11/14 px text.
+	Position string
+	Opacity  float64
+	FontSize int
 }
 
-func VideoWatermark(ctx context.Context, p VideoParams) error {
+// videoWatermarkFilter builds the drawtext chain for p. The default layout's
+// corner mark alternates between bottom-right and top-left every 30 seconds.
+func videoWatermarkFilter(p VideoParams) string {
 	escaped := EscapeFFmpegText(p.Text)
+	marks := visibleLayout(p.Position, p.Opacity, p.FontSize, 0.15, 11, 0.08, 14)
+	if p.Position == PositionDefault {
+		corner, centre := marks[0], marks[2]
+		cornerFilter := fmt.Sprintf(
+			"drawtext=text='%s':fontcolor=white@%g:fontsize=%d:"+
+				"x='if(lt(mod(t\\,60)\\,30)\\,w-text_w-20\\,20)':"+
+				"y='if(lt(mod(t\\,60)\\,30)\\,h-text_h-20\\,20)':"+
+				"fontfile='%s'",
+			escaped, corner.opacity, corner.fontSize, p.FontPath,
+		)
+		centerFilter := fmt.Sprintf(
+			"drawtext=text='%s':fontcolor=white@%g:fontsize=%d:"+
+				"x=(w-text_w)/2:y=(h-text_h)/2:"+
+				"fontfile='%s'",
+			escaped, centre.opacity, centre.fontSize, p.FontPath,
+		)
+		return cornerFilter + "," + centerFilter
+	}
 
-	cornerFilter := fmt.Sprintf(
-		"drawtext=text='%s':fontcolor=white@0.15:fontsize=11:"+
-			"x='if(lt(mod(t\\,60)\\,30)\\,w-text_w-20\\,20)':"+
-			"y='if(lt(mod(t\\,60)\\,30)\\,h-text_h-20\\,20)':"+
-			"fontfile='%s'",
-		escaped, p.FontPath,
-	)
-
-	centerFilter := fmt.Sprintf(
-		"drawtext=text='%s':fontcolor=white@0.08:fontsize=14:"+
-			"x=(w-text_w)/2:y=(h-text_h)/2:"+
-			"fontfile='%s'",
-		escaped, p.FontPath,
-	)
+	filters := make([]string, len(marks))
+	for i, m := range marks {
+		x, y := drawtextPosition(m.fx, m.fy, 20)
+		filters[i] = fmt.Sprintf(
+			"drawtext=text='%s':fontcolor=white@%g:fontsize=%d:x=%s:y=%s:fontfile='%s'",
+			escaped, m.opacity, m.fontSize, x, y, p.FontPath,
+		)
+	}
+	return strings.Join(filters, ",")
+}
 
-	vf := cornerFilter + "," + centerFilter
+func VideoWatermark(ctx context.Context, p VideoParams) error {
+	vf := videoWatermarkFilter(p)
 
 	cmd := exec.CommandContext(ctx, "ffmpeg",
 		"-i", p.InputPath,
@@ -51,3 +75,22 @@ func VideoWatermark(ctx context.Context, p VideoParams) error {
 	}
 	return nil
 }
+
+// VideoWatermarkFrame writes one JPEG frame of p.InputPath, seekSecs in, with
+// the visible watermark VideoWatermark would draw, so a campaign's settings
+// can be previewed without encoding the whole video.
+func VideoWatermarkFrame(ctx context.Context, p VideoParams, seekSecs float64) error {
+	cmd := exec.CommandContext(ctx, "ffmpeg",
+		"-ss", fmt.Sprintf("%.2f", seekSecs),
+		"-i", p.InputPath,
+		"-vframes", "1",
+		"-vf", videoWatermarkFilter(p),
+		"-q:v", "3",
+		"-y",
+		p.OutputPath,
+	)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("ffmpeg watermark frame: %w\noutput: %s", err, string(out))
+	}
+	return nil
+}
@@ -94,3 +94,21 @@ func ExtractImageThumbnail(ctx context.Context, inputPath, outputPath string) er
 	}
 	return nil
 }
+
+// ExtractAssetThumbnail writes the UI thumbnail for an uploaded asset. Images
+// go through ExtractImagePreview; video frames are taken at PosterSeek.
+func ExtractAssetThumbnail(ctx context.Context, assetType, inputPath, outputPath string, durationSecs float64) error {
+	if assetType != "video" {
+		return ExtractImagePreview(ctx, inputPath, outputPath)
+	}
+	return ExtractVideoThumbnail(ctx, inputPath, outputPath, PosterSeek(durationSecs))
+}
+
+// PosterSeek is where a video's representative frame is taken: 10% into
+// clips longer than 10s and at 1s otherwise.
+func PosterSeek(durationSecs float64) float64 {
+	if durationSecs > 10 {
+		return durationSecs * 0.1
+	}
+	return 1
+}
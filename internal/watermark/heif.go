@@ -0,0 +1,69 @@
+package watermark
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// transcodedExts are the HEIF-family still formats accepted on upload. Phone
+// exports are mostly HEIC, increasingly AVIF; neither the Go decoders nor the
+// Python scripts read them, so they are converted to PNG first.
+var transcodedExts = map[string]bool{".heic": true, ".heif": true, ".avif": true}
+
+// NeedsTranscode reports whether an upload of mimeType is converted to PNG
+// before it is stored (see TranscodeToPNG).
+func NeedsTranscode(mimeType string) bool {
+	return transcodedExts[MimeToExt[mimeType]]
+}
+
+// IsTranscodedExt reports whether ext (e.g. ".heic") names a format that is
+// converted to PNG before use.
+func IsTranscodedExt(ext string) bool {
+	return transcodedExts[strings.ToLower(ext)]
+}
+
+// SniffHEIF returns "image/avif", "image/heic" or "image/heif" when head
+// starts with an ISO-BMFF ftyp box branding a HEIF-family still image, and ""
+// otherwise. http.DetectContentType reports these as application/octet-stream.
+func SniffHEIF(head []byte) string {
+	if len(head) < 12 || !bytes.Equal(head[4:8], []byte("ftyp")) {
+		return ""
+	}
+	size := int(binary.BigEndian.Uint32(head[:4]))
+	if size < 16 || size > len(head) {
+		size = len(head)
+	}
+	// Major brand at 8, minor version at 12, compatible brands from 16.
+	brands := []string{string(head[8:12])}
+	for off := 16; off+4 <= size; off += 4 {
+		brands = append(brands, string(head[off:off+4]))
+	}
+	mimeType := ""
+	for _, b := range brands {
+		switch b {
+		case "avif", "avis":
+			return "image/avif"
+		case "heic", "heix", "heim", "heis":
+			mimeType = "image/heic"
+		case "mif1", "msf1":
+			if mimeType == "" {
+				mimeType = "image/heif"
+			}
+		}
+	}
+	return mimeType
+}
+
+// TranscodeToPNG converts the first image of a HEIC or AVIF file to a PNG at
+// outputPath with ImageMagick, which reads them through libheif.
+func TranscodeToPNG(ctx context.Context, inputPath, outputPath string) error {
+	cmd := exec.CommandContext(ctx, "magick", inputPath+"[0]", "-auto-orient", outputPath)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("imagemagick transcode: %w\noutput: %s", err, string(output))
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package watermark
+
+import "testing"
+
+func TestSniffHEIF(t *testing.T) {
+	ftyp := func(brands string) []byte {
+		b := []byte{0, 0, 0, byte(16 + len(brands) - 4)}
+		b = append(b, "ftyp"+brands[:4]+"\x00\x00\x00\x00"+brands[4:]...)
+		return append(b, make([]byte, 32)...)
+	}
+	cases := []struct {
+		head []byte
+		want string
+	}{
+		{ftyp("heicmif1heic"), "image/heic"},
+		{ftyp("mif1mif1heic"), "image/heic"},
+		{ftyp("mif1mif1miaf"), "image/heif"},
+		{ftyp("avifavifmif1miaf"), "image/avif"},
+		{ftyp("mif1mif1avif"), "image/avif"},
+		{ftyp("isomisomiso2mp41"), ""},
+		{[]byte("\x89PNG\r\n\x1a\n0000"), ""},
+		{[]byte("ftyp"), ""},
+	}
+	for _, c := range cases {
+		if got := SniffHEIF(c.head); got != c.want {
+			t.Errorf("SniffHEIF(%q) = %q, want %q", c.head[:min(len(c.head), 24)], got, c.want)
+		}
+	}
+
+	if !NeedsTranscode("image/heic") || !NeedsTranscode("image/avif") || NeedsTranscode("image/png") {
+		t.Error("NeedsTranscode: wrong set of formats")
+	}
+	if !IsTranscodedExt(".HEIC") || IsTranscodedExt(".webp") {
+		t.Error("IsTranscodedExt: wrong set of extensions")
+	}
+}
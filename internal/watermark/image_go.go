@@ -7,6 +7,7 @@ package watermark
 // Algorithm summary (matching imwatermark EmbedDwtDctSvd):
 //  1. Convert BGR image to YUV (OpenCV convention, uint8 range).
 //  2. Process channel 1 (U) with scale=36 (channels 0 and 2 are skipped).
+//     InvisibleParams can select other channels and scales.
 //  3. Trim image to dimensions divisible by 4 (row//4*4, col//4*4).
 //  4. Apply single-level 2D Haar DWT to the (trimmed) channel → LL subband.
 //  5. For each 4x4 block in the LL subband (row-major):
@@ -38,12 +39,16 @@ import (
 	"math"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
+	"sync"
+	"sync/atomic"
 
 	"gonum.org/v1/gonum/mat"
 
 	"github.com/YannKr/downloadonce/internal/watermark/dct"
 	"github.com/YannKr/downloadonce/internal/watermark/dwt"
+	"github.com/YannKr/downloadonce/internal/watermark/jpegenc"
 )
 
 const (
@@ -54,6 +59,36 @@ const (
 	wmBlockSize = 4
 )
 
+// MinInvisibleSide is the smallest square image, in pixels, that holds the
+// PayloadLength-byte invisible payload: (96/8)^2 = 144 blocks >= 128 bits.
+const MinInvisibleSide = 96
+
+// invisibleBlocks returns the number of 4x4 blocks in the LL subband of a
+// width x height image. The image is trimmed to a multiple of 4 and LL is
+// half that size, so each block covers an 8x8 pixel region.
+func invisibleBlocks(width, height int) int {
+	h := (height / 4) * 4
+	w := (width / 4) * 4
+	return (h / 2 / wmBlockSize) * (w / 2 / wmBlockSize)
+}
+
+// CheckInvisibleSize reports whether a width x height image is large enough
+// to carry the invisible payload. The error names the minimum size so it can
+// be shown to the user before any job runs.
+func CheckInvisibleSize(width, height int) error {
+	if invisibleBlocks(width, height) >= PayloadLength*8 {
+		return nil
+	}
+	return fmt.Errorf("image is %dx%d, too small for the invisible watermark: it needs at least %d 8x8-pixel blocks (e.g. %dx%d)",
+		width, height, PayloadLength*8, MinInvisibleSide, MinInvisibleSide)
+}
+
+// JPEGSubsampling is the chroma subsampling of JPEG output, both from the Go
+// embedder and from ImageMagick. 4:4:4 keeps the U channel that carries the
+// invisible watermark at full resolution; 4:2:0 gives smaller files. It is
+// set once at startup (WM_JPEG_SUBSAMPLING).
+var JPEGSubsampling = jpegenc.Subsampling444
+
 // GoInvisibleImageEmbed embeds a DWT-DCT-SVD invisible watermark into an image
 // file, matching the Python imwatermark library's dwtDctSvd encoding.
 //
@@ -62,6 +97,17 @@ const (
 // payloadHex is the 32-character hex string (16 bytes = 128 bits).
 // jpegQuality is the JPEG quality for the output file (e.g., 92).
 func GoInvisibleImageEmbed(ctx context.Context, inputPath, outputPath, payloadHex string, jpegQuality int) error {
+	return GoInvisibleImageEmbedParams(ctx, inputPath, outputPath, payloadHex, jpegQuality, DefaultInvisibleParams())
+}
+
+// GoInvisibleImageEmbedParams is GoInvisibleImageEmbed with explicit channel
+// and scale parameters. Files embedded with non-default params must be
+// detected with the same params.
+func GoInvisibleImageEmbedParams(ctx context.Context, inputPath, outputPath, payloadHex string, jpegQuality int, params InvisibleParams) error {
+	if params.empty() {
+		return fmt.Errorf("go invisible embed: no watermark channel selected")
+	}
+
 	// Convert payloadHex to bit array (MSB first within each byte).
 	bits, err := hexToBits(payloadHex)
 	if err != nil {
@@ -87,37 +133,63 @@ func GoInvisibleImageEmbed(ctx context.Context, inputPath, outputPath, payloadHe
 	}
 
 	// Minimum size: need at least wmLen blocks of 4x4 in the LL subband.
-	// LL is [h/2][w/2]. Number of 4x4 blocks = (h/2/4)*(w/2/4) = (h*w/64).
-	// We need >= wmLen blocks.
-	numBlocks := (h / 2 / wmBlockSize) * (w / 2 / wmBlockSize)
+	numBlocks := invisibleBlocks(fullW, fullH)
 	if numBlocks < wmLen {
 		return fmt.Errorf("go invisible embed: image too small (%dx%d trimmed to %dx%d), only %d blocks available for %d bits",
 			fullH, fullW, h, w, numBlocks, wmLen)
 	}
+	// Fully transparent blocks are left alone, so the opaque ones must
+	// still carry every bit.
+	skip := transparentBlocks(img, h, w)
+	if skip != nil {
+		carried := make([]bool, wmLen)
+		for _, k := range blockBits(len(skip), wmLen, skip) {
+			if k >= 0 {
+				carried[k] = true
+			}
+		}
+		for k, ok := range carried {
+			if !ok {
+				return fmt.Errorf("go invisible embed: bit %d of %d falls only in fully transparent blocks", k, wmLen)
+			}
+		}
+	}
 
-	// Extract pixels as YUV float64 planes for the trimmed region.
-	yPlane, uPlane, vPlane := extractYUVPlanes(img, h, w)
-
-	// Process U channel (channel index 1 in YUV) with scale 36.
-	modifiedU, err := embedChannelDwtDctSvd(uPlane, bits, wmLen, wmScale)
-	if err != nil {
-		return fmt.Errorf("go invisible embed: %w", err)
+	// Process each selected channel with its scale (default: U only, scale
+	// 36). Only selected channels get a float64 plane; all are extracted
+	// from the unmodified pixels before any is written back.
+	var planes [3][][]float64
+	for ch, scale := range params.Scales {
+		if scale <= 0 {
+			continue
+		}
+		planes[ch], err = embedChannelDwtDctSvd(extractChannelPlane(img, ch, h, w), bits, wmLen, scale, skip)
+		if err != nil {
+			return fmt.Errorf("go invisible embed: %w", err)
+		}
 	}
 
-	// Reconstruct image with modified U channel.
-	out := image.NewNRGBA(bounds)
-	// Copy original pixels first.
-	draw.Draw(out, bounds, img, bounds.Min, draw.Src)
-	// Overwrite the trimmed region with modified YUV.
-	putYUVPlanes(out, yPlane, modifiedU, vPlane, h, w)
+	// img was decoded for this call, so the trimmed region is overwritten in
+	// place; pixels outside it keep their original values.
+	putChannelPlanes(img, planes, h, w)
 
-	return saveImage(out, outputPath, jpegQuality)
+	return saveImage(img, outputPath, jpegQuality)
 }
 
 // GoInvisibleImageDetect extracts the DWT-DCT-SVD watermark from an image file.
 // payloadLengthBytes is the number of payload bytes to extract (e.g., PayloadLength = 16).
 // Returns the hex-encoded payload.
 func GoInvisibleImageDetect(ctx context.Context, inputPath string, payloadLengthBytes int) (string, error) {
+	return GoInvisibleImageDetectParams(ctx, inputPath, payloadLengthBytes, DefaultInvisibleParams())
+}
+
+// GoInvisibleImageDetectParams is GoInvisibleImageDetect with explicit channel
+// and scale parameters. Scores from all selected channels are averaged per bit,
+// as imwatermark does.
+func GoInvisibleImageDetectParams(ctx context.Context, inputPath string, payloadLengthBytes int, params InvisibleParams) (string, error) {
+	if params.empty() {
+		return "", fmt.Errorf("go invisible detect: no watermark channel selected")
+	}
 	wmLen := payloadLengthBytes * 8
 
 	img, err := loadImageNRGBA(inputPath)
@@ -134,70 +206,118 @@ func GoInvisibleImageDetect(ctx context.Context, inputPath string, payloadLength
 		return "", fmt.Errorf("go invisible detect: image too small")
 	}
 
-	_, uPlane, _ := extractYUVPlanes(img, h, w)
-
-	bits, err := detectChannelDwtDctSvd(uPlane, wmLen, wmScale)
-	if err != nil {
-		return "", fmt.Errorf("go invisible detect: %w", err)
+	skip := transparentBlocks(img, h, w)
+	scores := make([][]float64, wmLen)
+	for ch, scale := range params.Scales {
+		if scale > 0 {
+			scoreChannelDwtDctSvd(extractChannelPlane(img, ch, h, w), scale, scores, skip)
+		}
 	}
 
-	payload := bitsToBytes(bits)
+	payload := bitsToBytes(thresholdScores(scores))
 	return hex.EncodeToString(payload), nil
 }
 
 // embedChannelDwtDctSvd applies the full DWT-DCT-SVD embed pipeline to a single
-// float64 channel plane (h x w).
-func embedChannelDwtDctSvd(plane [][]float64, bits []int, wmLen int, scale float64) ([][]float64, error) {
+// float64 channel plane (h x w). Blocks marked in skip (see
+// transparentBlocks; nil skips none) are left unchanged; the others carry
+// the bit of their position (see blockBits).
+func embedChannelDwtDctSvd(plane [][]float64, bits []int, wmLen int, scale float64, skip []bool) ([][]float64, error) {
 	// Apply 2D Haar DWT.
 	ll, lh, hl, hh := dwt.Forward2D(plane)
 
-	// Embed bits into 4x4 blocks of LL via per-block DCT + SVD.
-	llH := len(ll)
-	llW := len(ll[0])
-	num := 0
-	for i := 0; i < llH/wmBlockSize; i++ {
-		for j := 0; j < llW/wmBlockSize; j++ {
-			block := extractBlock(ll, i*wmBlockSize, j*wmBlockSize, wmBlockSize)
-			wmBit := bits[num%wmLen]
-
-			embedded := embedBlockDctSvd(block, wmBit, scale)
-			putBlock(ll, embedded, i*wmBlockSize, j*wmBlockSize, wmBlockSize)
-			num++
+	// Embed bits into 4x4 blocks of LL via per-block DCT + SVD. Bits cycle
+	// across blocks in row-major order.
+	rows := len(ll) / wmBlockSize
+	cols := len(ll[0]) / wmBlockSize
+	bitOf := blockBits(rows*cols, wmLen, skip)
+	forEachBlockRow(rows, cols, func(b *blockSvd, i int) {
+		for j := 0; j < cols; j++ {
+			k := bitOf[i*cols+j]
+			if k < 0 {
+				continue
+			}
+			b.load(ll, i*wmBlockSize, j*wmBlockSize)
+			b.embed(bits[k], scale)
+			b.store(ll, i*wmBlockSize, j*wmBlockSize)
 		}
-	}
+	})
 
 	// Apply inverse DWT.
 	return dwt.Inverse2D(ll, lh, hl, hh), nil
 }
 
-// detectChannelDwtDctSvd applies the full DWT-DCT-SVD detect pipeline to a single
-// float64 channel plane. Returns a bit slice of length wmLen.
-func detectChannelDwtDctSvd(plane [][]float64, wmLen int, scale float64) ([]int, error) {
+// scoreChannelDwtDctSvd appends each block's score in plane to scores, indexed
+// by bit position (bits cycle across blocks, len(scores) is the payload length).
+// Blocks marked in skip carry no watermark and do not vote.
+func scoreChannelDwtDctSvd(plane [][]float64, scale float64, scores [][]float64, skip []bool) {
 	ll, _, _, _ := dwt.Forward2D(plane)
 
-	llH := len(ll)
-	llW := len(ll[0])
+	rows := len(ll) / wmBlockSize
+	cols := len(ll[0]) / wmBlockSize
+	wmLen := len(scores)
+
+	// Score blocks (possibly in parallel), then merge them into scores in
+	// block order so the result does not depend on the split.
+	blockScores := make([]float64, rows*cols)
+	forEachBlockRow(rows, cols, func(b *blockSvd, i int) {
+		for j := 0; j < cols; j++ {
+			b.load(ll, i*wmBlockSize, j*wmBlockSize)
+			blockScores[i*cols+j] = b.infer(scale)
+		}
+	})
+	bitOf := blockBits(rows*cols, wmLen, skip)
+	for num, score := range blockScores {
+		if k := bitOf[num]; k >= 0 {
+			scores[k] = append(scores[k], score)
+		}
+	}
+}
 
-	// Accumulate scores for each bit position.
-	scores := make([][]float64, wmLen)
-	for i := range scores {
-		scores[i] = make([]float64, 0)
-	}
-
-	num := 0
-	for i := 0; i < llH/wmBlockSize; i++ {
-		for j := 0; j < llW/wmBlockSize; j++ {
-			block := extractBlock(ll, i*wmBlockSize, j*wmBlockSize, wmBlockSize)
-			score := inferBlockDctSvd(block, scale)
-			wmBit := num % wmLen
-			scores[wmBit] = append(scores[wmBit], score)
-			num++
+// parallelMinBlocks is the number of LL blocks in a channel from which its
+// blocks are spread over all cores; smaller images stay serial, where the
+// goroutine overhead would outweigh the gain.
+var parallelMinBlocks = 4096
+
+// forEachBlockRow calls fn for each row of blocks 0..rows-1. Channels with at
+// least parallelMinBlocks blocks are fanned out over GOMAXPROCS goroutines,
+// each with its own blockSvd. Rows cover disjoint parts of the plane and
+// every block is computed the same way wherever it runs, so the output is
+// byte-identical regardless of the number of cores.
+func forEachBlockRow(rows, cols int, fn func(b *blockSvd, i int)) {
+	workers := min(runtime.GOMAXPROCS(0), rows)
+	if rows*cols < parallelMinBlocks || workers < 2 {
+		b := newBlockSvd()
+		for i := 0; i < rows; i++ {
+			fn(b, i)
 		}
+		return
+	}
+
+	var next atomic.Int64
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b := newBlockSvd()
+			for {
+				i := int(next.Add(1)) - 1
+				if i >= rows {
+					return
+				}
+				fn(b, i)
+			}
+		}()
 	}
+	wg.Wait()
+}
 
-	// Average scores and threshold at 0.5.
-	bits := make([]int, wmLen)
-	for k := 0; k < wmLen; k++ {
+// thresholdScores averages the scores of each bit position and thresholds
+// at 0.5.
+func thresholdScores(scores [][]float64) []int {
+	bits := make([]int, len(scores))
+	for k := range scores {
 		if len(scores[k]) == 0 {
 			bits[k] = 0
 			continue
@@ -214,77 +334,105 @@ func detectChannelDwtDctSvd(plane [][]float64, wmLen int, scale float64) ([]int,
 			bits[k] = 0
 		}
 	}
-	return bits, nil
+	return bits
+}
+
+// blockSvd holds the buffers of the per-block DCT + SVD step, reused from
+// block to block so that a channel is processed without allocating per
+// block. It is not safe for concurrent use.
+type blockSvd struct {
+	dct   *dct.Block
+	block [][]float64 // the current wmBlockSize x wmBlockSize block
+	data  []float64   // row-major backing of m
+	m     *mat.Dense
+	svd   mat.SVD
+	s     []float64 // singular values, backing diag
+	diag  *mat.DiagDense
+	u, v  mat.Dense
+	tmp   mat.Dense
+	out   mat.Dense
+}
+
+func newBlockSvd() *blockSvd {
+	n := wmBlockSize
+	b := &blockSvd{
+		dct:   dct.NewBlock(n),
+		block: make([][]float64, n),
+		data:  make([]float64, n*n),
+		s:     make([]float64, n),
+	}
+	for i := range b.block {
+		b.block[i] = make([]float64, n)
+	}
+	b.m = mat.NewDense(n, n, b.data)
+	b.diag = mat.NewDiagDense(n, b.s)
+	return b
+}
+
+// load copies the block at (row, col) of plane into b.
+func (b *blockSvd) load(plane [][]float64, row, col int) {
+	for i, r := range b.block {
+		copy(r, plane[row+i][col:col+wmBlockSize])
+	}
+}
+
+// store writes b's block back into plane at (row, col).
+func (b *blockSvd) store(plane [][]float64, row, col int) {
+	for i, r := range b.block {
+		copy(plane[row+i][col:col+wmBlockSize], r)
+	}
+}
+
+// factorize takes the DCT of the block and its SVD, leaving the singular
+// values in b.s.
+func (b *blockSvd) factorize() {
+	b.dct.Forward(b.block, b.block)
+	for i, r := range b.block {
+		copy(b.data[i*wmBlockSize:], r)
+	}
+	b.svd.Factorize(b.m, mat.SVDThin)
+	b.svd.Values(b.s)
 }
 
-// embedBlockDctSvd applies DCT, embeds one bit via SVD modification, then
-// applies inverse DCT. Matches Python's diffuse_dct_svd method.
+// embed applies DCT, embeds one bit via SVD modification, then applies
+// inverse DCT, in place. Matches Python's diffuse_dct_svd method.
 //
 // Python: u,s,v = np.linalg.svd(cv2.dct(block))
 //
 //	s[0] = (s[0] // scale + 0.25 + 0.5 * wmBit) * scale
 //	return cv2.idct(np.dot(u, np.dot(np.diag(s), v)))
-func embedBlockDctSvd(block [][]float64, wmBit int, scale float64) [][]float64 {
-	n := wmBlockSize
-	dctBlock := dct.Forward2D(block)
-
-	// Flatten for gonum mat.
-	data := make([]float64, n*n)
-	for i, row := range dctBlock {
-		copy(data[i*n:], row)
-	}
-	m := mat.NewDense(n, n, data)
-
-	var svdDecomp mat.SVD
-	svdDecomp.Factorize(m, mat.SVDThin)
-	s := svdDecomp.Values(nil)
+func (b *blockSvd) embed(wmBit int, scale float64) {
+	b.factorize()
 
 	// Quantization embedding matching Python:
 	// s[0] = (s[0] // scale + 0.25 + 0.5 * wmBit) * scale
-	s[0] = (math.Floor(s[0]/scale) + 0.25 + 0.5*float64(wmBit)) * scale
+	b.s[0] = (math.Floor(b.s[0]/scale) + 0.25 + 0.5*float64(wmBit)) * scale
 
 	// Reconstruct: U * diag(s) * V^T.
-	var u, v mat.Dense
-	svdDecomp.UTo(&u)
-	svdDecomp.VTo(&v) // gonum returns V; we need V^T, so use v.T()
-
-	diagS := mat.NewDiagDense(n, s)
-	var tmp, result mat.Dense
-	tmp.Mul(&u, diagS)
-	result.Mul(&tmp, v.T())
+	b.svd.UTo(&b.u)
+	b.svd.VTo(&b.v) // gonum returns V; we need V^T, so use v.T()
+	b.tmp.Mul(&b.u, b.diag)
+	b.out.Mul(&b.tmp, b.v.T())
 
 	// Apply inverse DCT.
-	modified := make([][]float64, n)
-	for i := range modified {
-		modified[i] = make([]float64, n)
-		for j := 0; j < n; j++ {
-			modified[i][j] = result.At(i, j)
+	for i, r := range b.block {
+		for j := range r {
+			r[j] = b.out.At(i, j)
 		}
 	}
-	return dct.Inverse2D(modified)
+	b.dct.Inverse(b.block, b.block)
 }
 
-// inferBlockDctSvd reads the watermark score from a block. Matches Python's
+// infer reads the watermark score from the block. Matches Python's
 // infer_dct_svd method.
 //
 // Python: u,s,v = np.linalg.svd(cv2.dct(block))
 //
 //	score = int((s[0] % scale) > scale * 0.5)
-func inferBlockDctSvd(block [][]float64, scale float64) float64 {
-	n := wmBlockSize
-	dctBlock := dct.Forward2D(block)
-
-	data := make([]float64, n*n)
-	for i, row := range dctBlock {
-		copy(data[i*n:], row)
-	}
-	m := mat.NewDense(n, n, data)
-
-	var svdDecomp mat.SVD
-	svdDecomp.Factorize(m, mat.SVDThin)
-	s := svdDecomp.Values(nil)
+func (b *blockSvd) infer(scale float64) float64 {
+	b.factorize()
 
-	mod := math.Mod(s[0], scale)
+	mod := math.Mod(b.s[0], scale)
 	// Handle negative modulo (Go's math.Mod can return negative for negative s[0]).
 	if mod < 0 {
 		mod += scale
@@ -295,74 +443,135 @@ func inferBlockDctSvd(block [][]float64, scale float64) float64 {
 	return 0.0
 }
 
-// extractBlock extracts a wmBlockSize x wmBlockSize block from a 2D slice.
-func extractBlock(plane [][]float64, row, col, size int) [][]float64 {
-	block := make([][]float64, size)
-	for i := 0; i < size; i++ {
-		block[i] = make([]float64, size)
-		copy(block[i], plane[row+i][col:col+size])
+// blockBits returns the payload bit carried by each of n blocks: block num
+// carries bit num%wmLen, cycling in row-major order as in imwatermark, and
+// the blocks marked in skip get -1. The index is positional, so a copy whose
+// alpha was flattened still reads every block at the bit it was embedded
+// with; its formerly transparent blocks just add noisy votes.
+func blockBits(n, wmLen int, skip []bool) []int {
+	bitOf := make([]int, n)
+	for num := range bitOf {
+		if skip != nil && skip[num] {
+			bitOf[num] = -1
+			continue
+		}
+		bitOf[num] = num % wmLen
 	}
-	return block
+	return bitOf
 }
 
-// putBlock writes a wmBlockSize x wmBlockSize block back into a 2D slice.
-func putBlock(plane [][]float64, block [][]float64, row, col, size int) {
-	for i := 0; i < size; i++ {
-		copy(plane[row+i][col:col+size], block[i])
+// transparentBlocks marks the LL blocks of the h x w region whose pixels
+// are all fully transparent, indexed like the embed loop (row-major over
+// blocks). Embedding there would only show up as noise once the image is
+// composited, and the hidden colour under alpha 0 often does not survive
+// editors, so those blocks neither carry nor vote for bits. Detection finds
+// the same blocks from the alpha channel when it is still there. It returns
+// nil when no block is fully transparent, which includes every opaque
+// image, so those embed exactly as imwatermark does.
+func transparentBlocks(img *image.NRGBA, h, w int) []bool {
+	const px = 2 * wmBlockSize // one LL block covers px x px pixels
+	rows, cols := h/px, w/px
+	var skip []bool
+	for i := 0; i < rows; i++ {
+		for j := 0; j < cols; j++ {
+			if !regionTransparent(img, j*px, i*px, px) {
+				continue
+			}
+			if skip == nil {
+				skip = make([]bool, rows*cols)
+			}
+			skip[i*cols+j] = true
+		}
 	}
+	return skip
 }
 
-// extractYUVPlanes extracts Y, U, V float64 planes from an NRGBA image.
-// Conversion matches OpenCV's COLOR_BGR2YUV formula (applied to RGB):
+// regionTransparent reports whether the n x n pixels at (x0, y0), relative
+// to the image origin, all have alpha 0.
+func regionTransparent(img *image.NRGBA, x0, y0, n int) bool {
+	for y := y0; y < y0+n; y++ {
+		off := img.PixOffset(img.Rect.Min.X+x0, img.Rect.Min.Y+y)
+		for x := 0; x < n; x++ {
+			if img.Pix[off+4*x+3] != 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// HasTransparency reports whether the image at path has any pixel that is not
+// fully opaque. Such images must be written as PNG to keep their alpha.
+func HasTransparency(path string) (bool, error) {
+	img, err := loadImageNRGBA(path)
+	if err != nil {
+		return false, err
+	}
+	return !img.Opaque(), nil
+}
+
+// yuvOf converts one RGB pixel to YUV. Conversion matches OpenCV's
+// COLOR_BGR2YUV formula (applied to RGB):
 //
 //	Y =  0.299*R + 0.587*G + 0.114*B
 //	U = -0.14713*R - 0.28886*G + 0.436*B + 128
 //	V =  0.615*R - 0.51499*G - 0.10001*B + 128
-//
-// Only the first h rows and w columns are extracted (measured from the image
-// origin, i.e., bounds.Min).
-func extractYUVPlanes(img *image.NRGBA, h, w int) (yPlane, uPlane, vPlane [][]float64) {
+func yuvOf(pix []uint8) (y, u, v float64) {
+	r := float64(pix[0])
+	g := float64(pix[1])
+	b := float64(pix[2])
+	y = 0.299*r + 0.587*g + 0.114*b
+	u = -0.14713*r - 0.28886*g + 0.436*b + 128.0
+	v = 0.615*r - 0.51499*g - 0.10001*b + 128.0
+	return
+}
+
+// extractChannelPlane extracts one YUV channel (0=Y, 1=U, 2=V) of an NRGBA
+// image as a float64 plane; see yuvOf for the conversion. Only the first h
+// rows and w columns are extracted (measured from the image origin, i.e.,
+// bounds.Min).
+func extractChannelPlane(img *image.NRGBA, ch, h, w int) [][]float64 {
 	minX := img.Rect.Min.X
 	minY := img.Rect.Min.Y
-	yPlane = make([][]float64, h)
-	uPlane = make([][]float64, h)
-	vPlane = make([][]float64, h)
+	plane := make([][]float64, h)
 	for y := 0; y < h; y++ {
-		yPlane[y] = make([]float64, w)
-		uPlane[y] = make([]float64, w)
-		vPlane[y] = make([]float64, w)
+		plane[y] = make([]float64, w)
 		for x := 0; x < w; x++ {
 			off := img.PixOffset(minX+x, minY+y)
-			r := float64(img.Pix[off])
-			g := float64(img.Pix[off+1])
-			b := float64(img.Pix[off+2])
-
-			yPlane[y][x] = 0.299*r + 0.587*g + 0.114*b
-			uPlane[y][x] = -0.14713*r - 0.28886*g + 0.436*b + 128.0
-			vPlane[y][x] = 0.615*r - 0.51499*g - 0.10001*b + 128.0
+			yv, uv, vv := yuvOf(img.Pix[off : off+3])
+			plane[y][x] = [3]float64{yv, uv, vv}[ch]
 		}
 	}
-	return
+	return plane
 }
 
-// putYUVPlanes writes modified YUV planes back to an NRGBA image.
-// Only writes the first h rows and w columns (measured from bounds.Min);
-// the rest of the image is untouched (already copied from source).
-func putYUVPlanes(img *image.NRGBA, yPlane, uPlane, vPlane [][]float64, h, w int) {
+// putChannelPlanes writes modified YUV channel planes back to an NRGBA image.
+// A nil plane leaves that channel as it is in the image. Only writes the
+// first h rows and w columns (measured from bounds.Min); the rest of the
+// image, fully transparent pixels and alpha are untouched.
+func putChannelPlanes(img *image.NRGBA, planes [3][][]float64, h, w int) {
 	minX := img.Rect.Min.X
 	minY := img.Rect.Min.Y
 	for y := 0; y < h; y++ {
 		for x := 0; x < w; x++ {
-			yv := yPlane[y][x]
-			uv := uPlane[y][x]
-			vv := vPlane[y][x]
+			off := img.PixOffset(minX+x, minY+y)
+			if img.Pix[off+3] == 0 {
+				continue // invisible; keep the original colour
+			}
+			yuv := [3]float64{}
+			yuv[0], yuv[1], yuv[2] = yuvOf(img.Pix[off : off+3])
+			for ch, plane := range planes {
+				if plane != nil {
+					yuv[ch] = plane[y][x]
+				}
+			}
+			yv, uv, vv := yuv[0], yuv[1], yuv[2]
 
 			// Inverse YUV to RGB (OpenCV COLOR_YUV2BGR inverse).
 			r := yv + 1.13983*(vv-128.0)
 			g := yv - 0.39465*(uv-128.0) - 0.58060*(vv-128.0)
 			b := yv + 2.03211*(uv-128.0)
 
-			off := img.PixOffset(minX+x, minY+y)
 			img.Pix[off] = clampU8(r)
 			img.Pix[off+1] = clampU8(g)
 			img.Pix[off+2] = clampU8(b)
@@ -387,6 +596,19 @@ func clampU8(v float64) uint8 {
 // WebP images must first be converted to JPEG or PNG by the caller
 // (the existing ImageMagick visible-watermark step handles this).
 func loadImageNRGBA(path string) (*image.NRGBA, error) {
+	decoded, err := decodeImageFile(path)
+	if err != nil {
+		return nil, err
+	}
+	bounds := decoded.Bounds()
+	nrgba := image.NewNRGBA(bounds)
+	draw.Draw(nrgba, bounds, decoded, bounds.Min, draw.Src)
+	return nrgba, nil
+}
+
+// decodeImageFile decodes an image file in whatever color model its decoder
+// produces.
+func decodeImageFile(path string) (image.Image, error) {
 	f, err := os.Open(path)
 	if err != nil {
 		return nil, err
@@ -407,11 +629,7 @@ func loadImageNRGBA(path string) (*image.NRGBA, error) {
 	if err != nil {
 		return nil, fmt.Errorf("decode %s: %w", path, err)
 	}
-
-	bounds := decoded.Bounds()
-	nrgba := image.NewNRGBA(bounds)
-	draw.Draw(nrgba, bounds, decoded, bounds.Min, draw.Src)
-	return nrgba, nil
+	return decoded, nil
 }
 
 // saveImage saves an NRGBA image to disk. Format is determined by outputPath extension.
@@ -423,13 +641,14 @@ func saveImage(img *image.NRGBA, outputPath string, jpegQuality int) error {
 	defer f.Close()
 
 	ext := strings.ToLower(filepath.Ext(outputPath))
+	opts := &jpegenc.Options{Quality: jpegQuality, Subsampling: JPEGSubsampling}
 	switch ext {
 	case ".jpg", ".jpeg":
-		return jpeg.Encode(f, img, &jpeg.Options{Quality: jpegQuality})
+		return jpegenc.Encode(f, img, opts)
 	case ".png":
 		return png.Encode(f, img)
 	default:
-		return jpeg.Encode(f, img, &jpeg.Options{Quality: jpegQuality})
+		return jpegenc.Encode(f, img, opts)
 	}
 }
 
@@ -0,0 +1,330 @@
+package watermark
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/YannKr/downloadonce/internal/watermark/jpegenc"
+)
+
+// BenchmarkGoInvisibleImageEmbed reports time and allocations for a default
+// (U channel) embed of a 1024x1024 image. Only the U channel gets a float64
+// plane and the 4x4 block buffers are reused, so allocations are dominated
+// by the DWT and image decode/encode rather than by per-block work.
+func BenchmarkGoInvisibleImageEmbed(b *testing.B) {
+	dir := b.TempDir()
+	in := filepath.Join(dir, "in.png")
+	writeTestImage(b, in, 1024)
+	out := filepath.Join(dir, "out.png")
+	payload := PayloadHex("tok", "camp")
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := GoInvisibleImageEmbed(context.Background(), in, out, payload, 92); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkBlockSvd measures the per-block DCT+SVD step on its own.
+func BenchmarkBlockSvd(b *testing.B) {
+	plane := make([][]float64, wmBlockSize)
+	for i := range plane {
+		plane[i] = []float64{10, 20, 30, 40}
+	}
+	blk := newBlockSvd()
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		blk.load(plane, 0, 0)
+		blk.embed(i&1, wmScale)
+	}
+}
+
+func TestEmbedOnlyAllocatesSelectedPlanes(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.png")
+	writeTestImage(t, in, 256)
+	img, err := loadImageNRGBA(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := extractChannelPlane(img, 1, 256, 256)
+	before := append([]uint8(nil), img.Pix...)
+
+	// Writing back an unmodified U plane with Y and V absent must leave
+	// every pixel as it was (to within the YUV round trip).
+	putChannelPlanes(img, [3][][]float64{nil, u, nil}, 256, 256)
+	for i := range before {
+		d := int(before[i]) - int(img.Pix[i])
+		if d < -1 || d > 1 {
+			t.Fatalf("pixel byte %d changed from %d to %d", i, before[i], img.Pix[i])
+		}
+	}
+}
+
+// setJPEGSubsampling sets JPEGSubsampling for the duration of the test.
+func setJPEGSubsampling(t *testing.T, s jpegenc.Subsampling) {
+	t.Helper()
+	prev := JPEGSubsampling
+	JPEGSubsampling = s
+	t.Cleanup(func() { JPEGSubsampling = prev })
+}
+
+// TestJPEGSubsamplingSurvival embeds the same payload into JPEGs at a middling
+// quality with and without chroma subsampling. Halving the U channel's
+// resolution (4:2:0) loses bits that full-resolution chroma (4:4:4) keeps.
+func TestJPEGSubsamplingSurvival(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.png")
+	writeTestImage(t, in, 256)
+	payload := PayloadHex("tok", "camp")
+	const quality = 40
+
+	bitErrors := func(s jpegenc.Subsampling) int {
+		t.Helper()
+		setJPEGSubsampling(t, s)
+		out := filepath.Join(dir, "out-"+strings.ReplaceAll(s.String(), ":", "")+".jpg")
+		if err := GoInvisibleImageEmbed(context.Background(), in, out, payload, quality); err != nil {
+			t.Fatal(err)
+		}
+		got, err := GoInvisibleImageDetect(context.Background(), out, PayloadLength)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, _ := hexToBits(payload)
+		have, _ := hexToBits(got)
+		n := 0
+		for i := range want {
+			if want[i] != have[i] {
+				n++
+			}
+		}
+		return n
+	}
+
+	sub420 := bitErrors(jpegenc.Subsampling420)
+	sub444 := bitErrors(jpegenc.Subsampling444)
+	if sub444 != 0 {
+		t.Errorf("4:4:4 at quality %d: %d bit errors, want 0", quality, sub444)
+	}
+	if sub420 == 0 {
+		t.Errorf("4:2:0 at quality %d recovered the payload; the test no longer shows a difference", quality)
+	}
+}
+
+// setParallelMinBlocks sets parallelMinBlocks for the duration of the test.
+func setParallelMinBlocks(t testing.TB, n int) {
+	prev := parallelMinBlocks
+	parallelMinBlocks = n
+	t.Cleanup(func() { parallelMinBlocks = prev })
+}
+
+func TestParallelEmbedMatchesSerial(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.png")
+	writeTestImage(t, in, 256)
+	payload := PayloadHex("tok", "camp")
+	params := InvisibleParams{Scales: [3]float64{0, 36, 30}}
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+
+	embed := func(minBlocks int, name string) ([]byte, string) {
+		t.Helper()
+		setParallelMinBlocks(t, minBlocks)
+		out := filepath.Join(dir, name)
+		if err := GoInvisibleImageEmbedParams(context.Background(), in, out, payload, 0, params); err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := GoInvisibleImageDetectParams(context.Background(), out, PayloadLength, params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data, got
+	}
+
+	serial, serialDetect := embed(math.MaxInt, "serial.png")
+	parallel, parallelDetect := embed(0, "parallel.png")
+	if !bytes.Equal(serial, parallel) {
+		t.Error("parallel embed output differs from serial")
+	}
+	if serialDetect != payload || parallelDetect != payload {
+		t.Errorf("detected serial %s, parallel %s, want %s", serialDetect, parallelDetect, payload)
+	}
+}
+
+func TestTransparentPNGKeepsAlpha(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.png")
+	out := filepath.Join(dir, "out.png")
+	const size = 256
+
+	// The top-left of the image fully transparent, a semi-transparent band
+	// beside it, the rest opaque noise over a gradient.
+	rng := rand.New(rand.NewSource(3))
+	src := image.NewNRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			n := rng.Intn(40)
+			c := color.NRGBA{R: uint8(60 + x*120/size + n), G: uint8(80 + y*100/size + n), B: uint8(140 + n), A: 255}
+			switch {
+			case y >= size/4:
+			case x < size/2-8:
+				c.A = 0
+			case x < size/2+8:
+				c.A = 128
+			}
+			src.SetNRGBA(x, y, c)
+		}
+	}
+	f, err := os.Create(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(f, src); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	if alpha, err := HasTransparency(in); err != nil || !alpha {
+		t.Fatalf("HasTransparency = %v, %v", alpha, err)
+	}
+	payload := PayloadHex("tok", "camp")
+	if err := GoInvisibleImageEmbed(context.Background(), in, out, payload, 92); err != nil {
+		t.Fatal(err)
+	}
+	got, err := loadImageNRGBA(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			off := src.PixOffset(x, y)
+			if got.Pix[off+3] != src.Pix[off+3] {
+				t.Fatalf("alpha at (%d,%d) = %d, want %d", x, y, got.Pix[off+3], src.Pix[off+3])
+			}
+			if src.Pix[off+3] == 0 && !bytes.Equal(got.Pix[off:off+3], src.Pix[off:off+3]) {
+				t.Fatalf("transparent pixel (%d,%d) changed", x, y)
+			}
+		}
+	}
+	if detected, err := GoInvisibleImageDetect(context.Background(), out, PayloadLength); err != nil || detected != payload {
+		t.Errorf("detected %s (%v), want %s", detected, err, payload)
+	}
+
+	// A leaked copy flattened onto white, as a screenshot would be, is
+	// still read.
+	flat := image.NewNRGBA(got.Bounds())
+	draw.Draw(flat, flat.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(flat, flat.Bounds(), got, got.Bounds().Min, draw.Over)
+	flatPath := filepath.Join(dir, "flat.png")
+	f, err = os.Create(flatPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(f, flat); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if detected, err := GoInvisibleImageDetect(context.Background(), flatPath, PayloadLength); err != nil || detected != payload {
+		t.Errorf("flattened copy: detected %s (%v), want %s", detected, err, payload)
+	}
+
+	// A transparent area aligned with the block grid that takes some bits
+	// out of every row is refused rather than embedded unreadably.
+	for y := size / 4; y < size; y++ {
+		for x := 0; x < size/2; x++ {
+			src.Pix[src.PixOffset(x, y)+3] = 0
+		}
+	}
+	f, err = os.Create(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(f, src); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if err := GoInvisibleImageEmbed(context.Background(), in, out, payload, 92); err == nil {
+		t.Error("embedded an image that leaves some bits in transparent blocks only")
+	}
+
+	opaque := filepath.Join(dir, "opaque.png")
+	writeTestImage(t, opaque, 64)
+	if alpha, err := HasTransparency(opaque); err != nil || alpha {
+		t.Errorf("HasTransparency(opaque) = %v, %v", alpha, err)
+	}
+}
+
+func TestCheckInvisibleSize(t *testing.T) {
+	cases := []struct {
+		w, h int
+		ok   bool
+	}{
+		{MinInvisibleSide, MinInvisibleSide, true},
+		{MinInvisibleSide - 1, MinInvisibleSide - 1, false},
+		{200, 48, true}, // 25x6 blocks
+		{1000, 15, false},
+		{64, 128, true},
+	}
+	for _, c := range cases {
+		if err := CheckInvisibleSize(c.w, c.h); (err == nil) != c.ok {
+			t.Errorf("CheckInvisibleSize(%d, %d) = %v, want ok=%v", c.w, c.h, err, c.ok)
+		}
+	}
+
+	// The check agrees with the embedder on both sides of the limit.
+	dir := t.TempDir()
+	payload := PayloadHex("tok", "camp")
+	for _, size := range []int{MinInvisibleSide - 8, MinInvisibleSide} {
+		in := filepath.Join(dir, "in.png")
+		writeTestImage(t, in, size)
+		err := GoInvisibleImageEmbed(context.Background(), in, filepath.Join(dir, "out.png"), payload, 92)
+		if want := CheckInvisibleSize(size, size) == nil; (err == nil) != want {
+			t.Errorf("%dx%d embed error %v, want ok=%v", size, size, err, want)
+		}
+	}
+}
+
+// BenchmarkEmbedChannel compares the serial and parallel block loops on the
+// U channel of a 2048x2048 image.
+func BenchmarkEmbedChannel(b *testing.B) {
+	const size = 2048
+	plane := make([][]float64, size)
+	for y := range plane {
+		plane[y] = make([]float64, size)
+		for x := range plane[y] {
+			plane[y][x] = 128 + float64((x*7+y*13)%40)
+		}
+	}
+	bits, _ := hexToBits(PayloadHex("tok", "camp"))
+
+	for _, bc := range []struct {
+		name      string
+		minBlocks int
+	}{{"serial", math.MaxInt}, {"parallel", 0}} {
+		b.Run(bc.name, func(b *testing.B) {
+			setParallelMinBlocks(b, bc.minBlocks)
+			for i := 0; i < b.N; i++ {
+				if _, err := embedChannelDwtDctSvd(plane, bits, len(bits), wmScale, nil); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
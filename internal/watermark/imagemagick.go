@@ -11,25 +11,16 @@ type ImageParams struct {
 	OutputPath string
 	Text       string
 	FontPath   string
+
+	// Placement and look of the text; zero values keep the default layout
+	// (see PositionDefault), 0.15/0.08 opacity and 24/32 pt text.
+	Position string
+	Opacity  float64
+	FontSize int
 }
 
 func ImageWatermark(ctx context.Context, p ImageParams) error {
-	cmd := exec.CommandContext(ctx, "magick",
-		p.InputPath,
-		"-font", p.FontPath,
-		"-pointsize", "24",
-		"-fill", "rgba(255,255,255,0.15)",
-		"-gravity", "SouthEast",
-		"-annotate", "+20+20", p.Text,
-		"-gravity", "NorthWest",
-		"-annotate", "+20+20", p.Text,
-		"-fill", "rgba(255,255,255,0.08)",
-		"-gravity", "Center",
-		"-pointsize", "32",
-		"-annotate", "+0+0", p.Text,
-		"-quality", "92",
-		p.OutputPath,
-	)
+	cmd := exec.CommandContext(ctx, "magick", imageWatermarkArgs(p)...)
 
 	output, err := cmd.CombinedOutput()
 	if err != nil {
@@ -37,3 +28,21 @@ func ImageWatermark(ctx context.Context, p ImageParams) error {
 	}
 	return nil
 }
+
+func imageWatermarkArgs(p ImageParams) []string {
+	text := escapeMagickText(p.Text)
+	args := []string{p.InputPath, "-font", p.FontPath}
+	for _, m := range visibleLayout(p.Position, p.Opacity, p.FontSize, 0.15, 24, 0.08, 32) {
+		offset := "+20+20"
+		if m.anchor == "Center" {
+			offset = "+0+0"
+		}
+		args = append(args,
+			"-pointsize", fmt.Sprint(m.fontSize),
+			"-fill", fmt.Sprintf("rgba(255,255,255,%g)", m.opacity),
+			"-gravity", m.anchor,
+			"-annotate", offset, text,
+		)
+	}
+	return append(args, "-sampling-factor", JPEGSubsampling.String(), "-quality", "92", p.OutputPath)
+}
@@ -2,11 +2,13 @@ package watermark
 
 import (
 	"context"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 )
 
@@ -67,32 +69,93 @@ func InvisibleImageDetect(ctx context.Context, inputPath, pythonPath, scriptPath
 	return result.PayloadHex, nil
 }
 
-// InvisibleVideoEmbed embeds invisible watermarks into evenly-spaced key frames
-// of a video file. Steps:
-//  1. Extract N evenly-spaced I-frames from the video
+// Keyframe sampling strategies (WM_VIDEO_FRAME_SAMPLING).
+const (
+	FrameSamplingFirst  = "first"  // the first N I-frames of the video
+	FrameSamplingSpread = "spread" // N I-frames spread evenly over its duration
+)
+
+// DefaultVideoFrames is how many I-frames are sampled when no count is set.
+const DefaultVideoFrames = 10
+
+// FrameSampling selects the video I-frames that carry the invisible
+// watermark and that detection decodes. More frames give majority voting
+// more votes, so detection survives heavier edits and cuts, at the cost of
+// one embed or detect pass per frame.
+type FrameSampling struct {
+	Frames   int    // I-frames to sample; 0 means DefaultVideoFrames
+	Strategy string // FrameSampling*; empty means FrameSamplingFirst
+}
+
+// NewFrameSampling validates a frame count and strategy name.
+func NewFrameSampling(frames int, strategy string) (FrameSampling, error) {
+	s := FrameSampling{Frames: frames, Strategy: strings.ToLower(strings.TrimSpace(strategy))}
+	if frames < 1 || frames > 1000 {
+		return s, fmt.Errorf("video frame count must be between 1 and 1000, got %d", frames)
+	}
+	if s.Strategy != FrameSamplingFirst && s.Strategy != FrameSamplingSpread {
+		return s, fmt.Errorf("unknown video frame sampling %q (want first or spread)", strategy)
+	}
+	return s, nil
+}
+
+// keyframeArgs returns the ffmpeg arguments extracting the I-frames chosen by
+// s from videoPath into outPattern. Spread sampling keeps an I-frame only
+// once durationSecs/Frames seconds have passed since the last one kept, and
+// falls back to the first I-frames when the duration is unknown.
+func keyframeArgs(videoPath, outPattern string, s FrameSampling, durationSecs float64) []string {
+	frames := s.Frames
+	if frames <= 0 {
+		frames = DefaultVideoFrames
+	}
+	filter := "select=eq(pict_type\\,I)"
+	if s.Strategy == FrameSamplingSpread && durationSecs > 0 {
+		interval := durationSecs / float64(frames)
+		filter = fmt.Sprintf("select=eq(pict_type\\,I)*(isnan(prev_selected_t)+gte(t-prev_selected_t\\,%.3f))", interval)
+	}
+	return []string{
+		"-i", videoPath,
+		"-vf", filter,
+		"-vsync", "vfr",
+		"-frames:v", strconv.Itoa(frames),
+		"-q:v", "2",
+		"-y",
+		outPattern,
+	}
+}
+
+// extractKeyframes writes the I-frames chosen by s from videoPath into dir as
+// frame_NNN.png.
+func extractKeyframes(ctx context.Context, videoPath, dir string, s FrameSampling) error {
+	var duration float64
+	if s.Strategy == FrameSamplingSpread {
+		if probe, err := Probe(videoPath); err == nil {
+			duration = probe.DurationSecs
+		}
+	}
+	cmd := exec.CommandContext(ctx, "ffmpeg", keyframeArgs(videoPath, filepath.Join(dir, "frame_%03d.png"), s, duration)...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("extract keyframes: %w\n%s", err, string(out))
+	}
+	return nil
+}
+
+// InvisibleVideoEmbed embeds invisible watermarks into the key frames of a
+// video file chosen by sampling. Steps:
+//  1. Extract the sampled I-frames from the video
 //  2. Embed invisible watermark into each frame
 //  3. The watermarked frames are stored alongside the video for detection reference
 //
 // Note: Full frame re-injection into the video stream is not performed in this version.
 // The visible overlay from FFmpeg is the primary protection for video. Invisible watermarks
 // on extracted frames provide a detection mechanism for clean digital copies.
-func InvisibleVideoEmbed(ctx context.Context, videoPath, payloadHex, pythonPath, embedScript string, framesDir string) error {
+func InvisibleVideoEmbed(ctx context.Context, videoPath, payloadHex, pythonPath, embedScript string, framesDir string, sampling FrameSampling) error {
 	if err := os.MkdirAll(framesDir, 0755); err != nil {
 		return fmt.Errorf("create frames dir: %w", err)
 	}
 
-	// Extract I-frames (1 per minute, max 10)
-	cmd := exec.CommandContext(ctx, "ffmpeg",
-		"-i", videoPath,
-		"-vf", "select=eq(pict_type\\,I),showinfo",
-		"-vsync", "vfr",
-		"-frames:v", "10",
-		"-q:v", "2",
-		"-y",
-		filepath.Join(framesDir, "frame_%03d.png"),
-	)
-	if out, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("extract keyframes: %w\n%s", err, string(out))
+	if err := extractKeyframes(ctx, videoPath, framesDir, sampling); err != nil {
+		return err
 	}
 
 	// Watermark each extracted frame
@@ -116,28 +179,19 @@ func InvisibleVideoEmbed(ctx context.Context, videoPath, payloadHex, pythonPath,
 	return nil
 }
 
-// InvisibleVideoDetect extracts key frames from a video and attempts to decode
-// the invisible watermark from each. Returns all detected payload hex strings.
-// The caller should perform majority voting to determine the most likely payload.
-func InvisibleVideoDetect(ctx context.Context, videoPath, pythonPath, detectScript string, payloadLength int) ([]string, error) {
+// InvisibleVideoDetect extracts the key frames chosen by sampling from a
+// video and attempts to decode the invisible watermark from each. Returns all
+// detected payload hex strings. The caller should perform majority voting to
+// determine the most likely payload.
+func InvisibleVideoDetect(ctx context.Context, videoPath, pythonPath, detectScript string, payloadLength int, sampling FrameSampling) ([]string, error) {
 	tmpDir, err := os.MkdirTemp("", "detect-frames-*")
 	if err != nil {
 		return nil, err
 	}
 	defer os.RemoveAll(tmpDir)
 
-	// Extract key frames
-	cmd := exec.CommandContext(ctx, "ffmpeg",
-		"-i", videoPath,
-		"-vf", "select=eq(pict_type\\,I)",
-		"-vsync", "vfr",
-		"-frames:v", "10",
-		"-q:v", "2",
-		"-y",
-		filepath.Join(tmpDir, "frame_%03d.png"),
-	)
-	if out, err := cmd.CombinedOutput(); err != nil {
-		return nil, fmt.Errorf("extract keyframes: %w\n%s", err, string(out))
+	if err := extractKeyframes(ctx, videoPath, tmpDir, sampling); err != nil {
+		return nil, err
 	}
 
 	entries, err := os.ReadDir(tmpDir)
@@ -182,3 +236,51 @@ func MajorityVote(payloads []string) string {
 	}
 	return best
 }
+
+// MajorityVotePerBit combines hex payloads of equal length by a majority vote
+// on each bit. Frames of a recompressed video usually each lose a few
+// different bits, so no whole payload repeats and MajorityVote picks noise,
+// while the per-bit majority still recovers the embedded payload. Callers
+// should check the result's CRC with ParsePayload. Invalid payloads are
+// skipped as in WeightedBitVote; ties resolve to 0.
+func MajorityVotePerBit(payloads []string) string {
+	return WeightedBitVote(payloads, nil)
+}
+
+// WeightedBitVote combines hex payloads of equal length bit by bit: each bit
+// of the result is the side carrying more total weight across the payloads.
+// Variants of one leaked file tend to lose different bits to recompression,
+// so the vote can recover a payload none of them carries intact. Payloads
+// of a different length than the first, or that are not valid hex, are
+// ignored; ties resolve to 0.
+func WeightedBitVote(payloads []string, weights []float64) string {
+	if len(payloads) == 0 {
+		return ""
+	}
+	n := len(payloads[0]) / 2
+	votes := make([]float64, n*8)
+	for i, p := range payloads {
+		b, err := hex.DecodeString(p)
+		if err != nil || len(b) != n {
+			continue
+		}
+		w := 1.0
+		if i < len(weights) {
+			w = weights[i]
+		}
+		for k := range votes {
+			if b[k/8]&(1<<uint(7-k%8)) != 0 {
+				votes[k] += w
+			} else {
+				votes[k] -= w
+			}
+		}
+	}
+	bits := make([]int, len(votes))
+	for k, v := range votes {
+		if v > 0 {
+			bits[k] = 1
+		}
+	}
+	return hex.EncodeToString(bitsToBytes(bits))
+}
@@ -0,0 +1,125 @@
+package watermark
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestWeightedBitVote(t *testing.T) {
+	want := PayloadHex("tok", "camp")
+	flip := func(hexStr string, bits ...int) string {
+		p, _ := hexToBits(hexStr)
+		for _, k := range bits {
+			p[k] ^= 1
+		}
+		return hex.EncodeToString(bitsToBytes(p))
+	}
+	// Each variant loses different bits; none is intact.
+	a := flip(want, 3, 40, 77, 101)
+	b := flip(want, 9, 41, 80, 120)
+	c := flip(want, 17, 60, 90, 127)
+
+	if got := WeightedBitVote([]string{a, b, c}, []float64{1, 1, 1}); got != want {
+		t.Errorf("equal weights = %s, want %s", got, want)
+	}
+	if got := WeightedBitVote([]string{a, b, c}, nil); got != want {
+		t.Errorf("default weights = %s, want %s", got, want)
+	}
+
+	// A confident variant outvotes two weak ones that agree on a wrong bit.
+	d := flip(want, 5)
+	if got := WeightedBitVote([]string{want, d, d}, []float64{1, 0.3, 0.3}); got != want {
+		t.Errorf("weighted = %s, want %s", got, want)
+	}
+	if got := WeightedBitVote([]string{want, d, d}, []float64{1, 1, 1}); got != d {
+		t.Errorf("unweighted majority = %s, want %s", got, d)
+	}
+
+	// Malformed payloads are ignored.
+	if got := WeightedBitVote([]string{a, "zz", b[:8], b, c}, nil); got != want {
+		t.Errorf("with malformed inputs = %s, want %s", got, want)
+	}
+	if got := WeightedBitVote(nil, nil); got != "" {
+		t.Errorf("no payloads = %q", got)
+	}
+}
+
+func TestMajorityVotePerBit(t *testing.T) {
+	want := PayloadHex("tok", "camp")
+	flip := func(bits ...int) string {
+		p, _ := hexToBits(want)
+		for _, k := range bits {
+			p[k] ^= 1
+		}
+		return hex.EncodeToString(bitsToBytes(p))
+	}
+	// Five frames, each with its own bit errors: no payload repeats.
+	frames := []string{flip(1, 50), flip(7, 99), flip(20, 64, 127), flip(33), flip(1, 88)}
+	if got := MajorityVote(frames); got == want {
+		t.Fatal("whole-payload vote unexpectedly recovered the payload")
+	}
+	got := MajorityVotePerBit(frames)
+	if got != want {
+		t.Errorf("per-bit vote = %s, want %s", got, want)
+	}
+	b, _ := hex.DecodeString(got)
+	if _, _, valid := ParsePayload(b); !valid {
+		t.Error("per-bit result fails the CRC check")
+	}
+
+	// Undecodable and short payloads are skipped.
+	if got := MajorityVotePerBit(append(frames, "zz", want[:8])); got != want {
+		t.Errorf("with invalid payloads = %s, want %s", got, want)
+	}
+	if got := MajorityVotePerBit(nil); got != "" {
+		t.Errorf("no payloads = %q", got)
+	}
+}
+
+func TestKeyframeArgs(t *testing.T) {
+	arg := func(args []string, flag string) string {
+		for i, a := range args {
+			if a == flag && i+1 < len(args) {
+				return args[i+1]
+			}
+		}
+		t.Fatalf("%s missing from %q", flag, args)
+		return ""
+	}
+
+	args := keyframeArgs("in.mp4", "out/frame_%03d.png", FrameSampling{}, 0)
+	if got := arg(args, "-frames:v"); got != "10" {
+		t.Errorf("default -frames:v = %s, want 10", got)
+	}
+	if got := arg(args, "-vf"); got != `select=eq(pict_type\,I)` {
+		t.Errorf("default filter = %s", got)
+	}
+
+	args = keyframeArgs("in.mp4", "out/frame_%03d.png", FrameSampling{Frames: 40, Strategy: FrameSamplingFirst}, 7200)
+	if got := arg(args, "-frames:v"); got != "40" {
+		t.Errorf("-frames:v = %s, want 40", got)
+	}
+
+	args = keyframeArgs("in.mp4", "out/frame_%03d.png", FrameSampling{Frames: 40, Strategy: FrameSamplingSpread}, 7200)
+	if got := arg(args, "-frames:v"); got != "40" {
+		t.Errorf("spread -frames:v = %s, want 40", got)
+	}
+	if got := arg(args, "-vf"); !strings.Contains(got, `gte(t-prev_selected_t\,180.000)`) {
+		t.Errorf("spread filter = %s, want one I-frame every 180s", got)
+	}
+	// Without a duration, spread sampling takes the first I-frames.
+	args = keyframeArgs("in.mp4", "out/frame_%03d.png", FrameSampling{Frames: 5, Strategy: FrameSamplingSpread}, 0)
+	if got := arg(args, "-vf"); strings.Contains(got, "prev_selected_t") {
+		t.Errorf("spread filter without duration = %s", got)
+	}
+
+	for _, bad := range []FrameSampling{{0, "first"}, {1001, "first"}, {10, "random"}} {
+		if _, err := NewFrameSampling(bad.Frames, bad.Strategy); err == nil {
+			t.Errorf("NewFrameSampling(%d, %q) accepted", bad.Frames, bad.Strategy)
+		}
+	}
+	if s, err := NewFrameSampling(20, " Spread "); err != nil || s.Strategy != FrameSamplingSpread {
+		t.Errorf("NewFrameSampling spread = %+v, %v", s, err)
+	}
+}
@@ -0,0 +1,66 @@
+package jpegenc
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+)
+
+func testImage() *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, 37, 21))
+	for y := 0; y < 21; y++ {
+		for x := 0; x < 37; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{uint8(x * 7), uint8(y * 12), uint8(x * y), 255})
+		}
+	}
+	return img
+}
+
+func TestEncode420MatchesStdlib(t *testing.T) {
+	img := testImage()
+	var got, want bytes.Buffer
+	if err := Encode(&got, img, &Options{Quality: 85}); err != nil {
+		t.Fatal(err)
+	}
+	if err := jpeg.Encode(&want, img, &jpeg.Options{Quality: 85}); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.Bytes(), want.Bytes()) {
+		t.Error("4:2:0 output differs from image/jpeg")
+	}
+}
+
+func TestEncode444(t *testing.T) {
+	img := testImage()
+	var buf bytes.Buffer
+	if err := Encode(&buf, img, &Options{Quality: 85, Subsampling: Subsampling444}); err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := jpeg.Decode(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ycc, ok := decoded.(*image.YCbCr)
+	if !ok {
+		t.Fatalf("decoded %T, want *image.YCbCr", decoded)
+	}
+	if ycc.SubsampleRatio != image.YCbCrSubsampleRatio444 {
+		t.Errorf("subsample ratio = %v, want 4:4:4", ycc.SubsampleRatio)
+	}
+	if ycc.Bounds() != img.Bounds() {
+		t.Errorf("bounds = %v, want %v", ycc.Bounds(), img.Bounds())
+	}
+}
+
+func TestParseSubsampling(t *testing.T) {
+	for in, want := range map[string]Subsampling{"4:2:0": Subsampling420, "444": Subsampling444, "4:4:4": Subsampling444} {
+		if got, err := ParseSubsampling(in); err != nil || got != want {
+			t.Errorf("ParseSubsampling(%q) = %v, %v", in, got, err)
+		}
+	}
+	if _, err := ParseSubsampling("4:2:2"); err == nil {
+		t.Error("4:2:2 accepted")
+	}
+}
@@ -0,0 +1,65 @@
+package watermark
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// InvisibleParams selects which YUV channels carry the DWT-DCT-SVD payload and
+// how strongly. Scales is indexed by channel (0=Y, 1=U, 2=V); a zero scale
+// leaves the channel untouched, as in imwatermark's scales list.
+type InvisibleParams struct {
+	Scales [3]float64
+}
+
+// DefaultChannels and DefaultScale are imwatermark's defaults.
+const (
+	DefaultChannels = "U"
+	DefaultScale    = wmScale
+)
+
+var channelNames = [3]string{"Y", "U", "V"}
+
+// DefaultInvisibleParams returns imwatermark's defaults (U channel, scale 36).
+func DefaultInvisibleParams() InvisibleParams {
+	return InvisibleParams{Scales: [3]float64{0, wmScale, 0}}
+}
+
+// NewInvisibleParams builds params from a comma-separated channel list such
+// as "U" or "U,V" and a scale applied to each listed channel.
+func NewInvisibleParams(channels string, scale float64) (InvisibleParams, error) {
+	var p InvisibleParams
+	if scale <= 0 {
+		return p, fmt.Errorf("watermark scale must be positive, got %g", scale)
+	}
+	for _, name := range strings.Split(channels, ",") {
+		name = strings.ToUpper(strings.TrimSpace(name))
+		idx := -1
+		for i, n := range channelNames {
+			if n == name {
+				idx = i
+			}
+		}
+		if idx < 0 {
+			return p, fmt.Errorf("unknown watermark channel %q (want Y, U or V)", name)
+		}
+		p.Scales[idx] = scale
+	}
+	return p, nil
+}
+
+// String renders the params as "U:36" or "U:36,V:36".
+func (p InvisibleParams) String() string {
+	var parts []string
+	for i, s := range p.Scales {
+		if s > 0 {
+			parts = append(parts, channelNames[i]+":"+strconv.FormatFloat(s, 'g', -1, 64))
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+func (p InvisibleParams) empty() bool {
+	return p.Scales == [3]float64{}
+}
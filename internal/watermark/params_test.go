@@ -0,0 +1,51 @@
+package watermark
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewInvisibleParams(t *testing.T) {
+	p, err := NewInvisibleParams(" u , V", 48)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Scales != [3]float64{0, 48, 48} || p.String() != "U:48,V:48" {
+		t.Errorf("got %v (%s)", p.Scales, p)
+	}
+	if d, _ := NewInvisibleParams(DefaultChannels, DefaultScale); d != DefaultInvisibleParams() {
+		t.Errorf("defaults mismatch: %s", d)
+	}
+	for _, bad := range []struct {
+		channels string
+		scale    float64
+	}{{"W", 36}, {"", 36}, {"U", 0}} {
+		if _, err := NewInvisibleParams(bad.channels, bad.scale); err == nil {
+			t.Errorf("NewInvisibleParams(%q, %g) accepted", bad.channels, bad.scale)
+		}
+	}
+}
+
+func TestEmbedDetectWithParams(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.png")
+	writeTestImage(t, in, 256)
+	out := filepath.Join(dir, "out.png")
+	payload := PayloadHex("tok", "camp")
+
+	params, err := NewInvisibleParams("U,V", 48)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := GoInvisibleImageEmbedParams(context.Background(), in, out, payload, 0, params); err != nil {
+		t.Fatal(err)
+	}
+	got, err := GoInvisibleImageDetectParams(context.Background(), out, PayloadLength, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != payload {
+		t.Errorf("detected %s, want %s", got, payload)
+	}
+}
@@ -0,0 +1,56 @@
+package watermark
+
+import (
+	"context"
+	"image"
+)
+
+// PreviewWidth is the width of generated previews, matching the thumbnails
+// ExtractImageThumbnail and ExtractVideoThumbnail produce.
+const PreviewWidth = 400
+
+// ExtractImagePreview writes a JPEG of inputPath scaled down to PreviewWidth
+// wide. JPEG and PNG are scaled in-process; other formats go through
+// ImageMagick.
+func ExtractImagePreview(ctx context.Context, inputPath, outputPath string) error {
+	img, err := loadImageNRGBA(inputPath)
+	if err != nil {
+		return ExtractImageThumbnail(ctx, inputPath, outputPath)
+	}
+	return saveImage(shrinkNRGBA(img, PreviewWidth), outputPath, 80)
+}
+
+// shrinkNRGBA box-filters img down to width w, keeping the aspect ratio.
+// Images already narrower than w are returned unchanged.
+func shrinkNRGBA(img *image.NRGBA, w int) *image.NRGBA {
+	b := img.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+	if sw <= w {
+		return img
+	}
+	h := max(1, sh*w/sw)
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		y0, y1 := y*sh/h, max((y+1)*sh/h, y*sh/h+1)
+		for x := 0; x < w; x++ {
+			x0, x1 := x*sw/w, max((x+1)*sw/w, x*sw/w+1)
+			var sum [4]int
+			for sy := y0; sy < y1; sy++ {
+				row := img.Pix[img.PixOffset(b.Min.X, b.Min.Y+sy):]
+				for sx := x0; sx < x1; sx++ {
+					p := row[sx*4 : sx*4+4]
+					sum[0] += int(p[0])
+					sum[1] += int(p[1])
+					sum[2] += int(p[2])
+					sum[3] += int(p[3])
+				}
+			}
+			n := (y1 - y0) * (x1 - x0)
+			o := dst.PixOffset(x, y)
+			for c := 0; c < 4; c++ {
+				dst.Pix[o+c] = uint8(sum[c] / n)
+			}
+		}
+	}
+	return dst
+}
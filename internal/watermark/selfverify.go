@@ -0,0 +1,92 @@
+package watermark
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// VerifiedEmbed describes the outcome of GoInvisibleImageEmbedVerified.
+type VerifiedEmbed struct {
+	// OutputPath is the file that was finally written. It differs from the
+	// requested path when the embed fell back to lossless PNG output.
+	OutputPath string
+	// Verified is true when the payload was recovered intact from OutputPath.
+	Verified bool
+	// Attempts is the number of embeds performed (1 when the first passed).
+	Attempts int
+}
+
+// selfVerifyJPEGQuality is the quality used for the first retry of a JPEG
+// output whose payload did not survive compression.
+const selfVerifyJPEGQuality = 100
+
+// GoInvisibleImageEmbedVerified embeds like GoInvisibleImageEmbedParams, then
+// re-detects the payload from the written file with the same params. If it
+// cannot be recovered it retries with maximum JPEG quality and finally with
+// PNG output (replacing the extension of outputPath) before accepting the
+// result.
+//
+// The embedding scale is not raised on retry: the detector only tries the
+// configured params, so a stronger embed would not be readable.
+//
+// A failed self-verify is not an error; the last output is kept and the
+// result reports Verified=false.
+func GoInvisibleImageEmbedVerified(ctx context.Context, inputPath, outputPath, payloadHex string, jpegQuality int, params InvisibleParams) (*VerifiedEmbed, error) {
+	type attempt struct {
+		path    string
+		quality int
+	}
+	attempts := []attempt{{outputPath, jpegQuality}}
+	if isJPEGPath(outputPath) && jpegQuality < selfVerifyJPEGQuality {
+		attempts = append(attempts, attempt{outputPath, selfVerifyJPEGQuality})
+	}
+	if strings.ToLower(filepath.Ext(outputPath)) != ".png" {
+		pngPath := strings.TrimSuffix(outputPath, filepath.Ext(outputPath)) + ".png"
+		attempts = append(attempts, attempt{pngPath, 0})
+	}
+
+	res := &VerifiedEmbed{}
+	for _, a := range attempts {
+		if res.OutputPath != "" && res.OutputPath != a.path {
+			os.Remove(res.OutputPath)
+		}
+		res.OutputPath = a.path
+		res.Attempts++
+
+		if err := GoInvisibleImageEmbedParams(ctx, inputPath, a.path, payloadHex, a.quality, params); err != nil {
+			os.Remove(a.path)
+			return nil, err
+		}
+
+		ok, err := verifyImagePayload(ctx, a.path, payloadHex, params)
+		if err != nil {
+			return nil, fmt.Errorf("self-verify: %w", err)
+		}
+		if ok {
+			res.Verified = true
+			return res, nil
+		}
+	}
+	return res, nil
+}
+
+// verifyImagePayload reports whether payloadHex can be recovered intact from
+// the image at path.
+func verifyImagePayload(ctx context.Context, path, payloadHex string, params InvisibleParams) (bool, error) {
+	detected, err := GoInvisibleImageDetectParams(ctx, path, len(payloadHex)/2, params)
+	if err != nil {
+		return false, err
+	}
+	return strings.EqualFold(detected, payloadHex), nil
+}
+
+func isJPEGPath(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".jpg", ".jpeg":
+		return true
+	}
+	return false
+}
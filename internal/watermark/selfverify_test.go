@@ -0,0 +1,87 @@
+package watermark
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/YannKr/downloadonce/internal/watermark/jpegenc"
+)
+
+// writeTestImage writes a size x size PNG with mild noise over a gradient.
+func writeTestImage(t testing.TB, path string, size int) {
+	t.Helper()
+	rng := rand.New(rand.NewSource(7))
+	img := image.NewNRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			n := rng.Intn(40)
+			img.SetNRGBA(x, y, color.NRGBA{
+				R: uint8(60 + x*120/size + n),
+				G: uint8(80 + y*100/size + n),
+				B: uint8(140 + n),
+				A: 255,
+			})
+		}
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := png.Encode(f, img); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEmbedVerifiedPassesFirstTime(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.png")
+	writeTestImage(t, in, 256)
+	out := filepath.Join(dir, "out.jpg")
+
+	res, err := GoInvisibleImageEmbedVerified(context.Background(), in, out, PayloadHex("tok", "camp"), 92, DefaultInvisibleParams())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Verified || res.Attempts != 1 || res.OutputPath != out {
+		t.Errorf("got %+v, want verified on first attempt at %s", res, out)
+	}
+}
+
+func TestEmbedVerifiedRetriesBorderlineEmbed(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.png")
+	writeTestImage(t, in, 256)
+	out := filepath.Join(dir, "out.jpg")
+	payload := PayloadHex("tok", "camp")
+	setJPEGSubsampling(t, jpegenc.Subsampling420)
+
+	// At this quality the payload does not survive 4:2:0 JPEG compression.
+	const borderlineQuality = 20
+	if err := GoInvisibleImageEmbed(context.Background(), in, out, payload, borderlineQuality); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := verifyImagePayload(context.Background(), out, payload, DefaultInvisibleParams()); ok {
+		t.Fatal("expected the borderline embed to be unrecoverable")
+	}
+
+	res, err := GoInvisibleImageEmbedVerified(context.Background(), in, out, payload, borderlineQuality, DefaultInvisibleParams())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Verified {
+		t.Fatalf("self-verify did not recover: %+v", res)
+	}
+	if res.Attempts < 2 {
+		t.Errorf("attempts = %d, want a retry", res.Attempts)
+	}
+	if ok, err := verifyImagePayload(context.Background(), res.OutputPath, payload, DefaultInvisibleParams()); err != nil || !ok {
+		t.Errorf("payload not recoverable from %s: %v", res.OutputPath, err)
+	}
+}
@@ -0,0 +1,137 @@
+package watermark
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Visible watermark placements. PositionDefault keeps the original layout:
+// the text in two corners plus a fainter, larger copy in the centre.
+const (
+	PositionDefault     = ""
+	PositionCenter      = "center"
+	PositionTiled       = "tiled"
+	PositionTopLeft     = "top-left"
+	PositionTopRight    = "top-right"
+	PositionBottomLeft  = "bottom-left"
+	PositionBottomRight = "bottom-right"
+)
+
+// VisiblePositions lists the accepted placement values, default first.
+var VisiblePositions = []string{
+	PositionDefault, PositionCenter, PositionTiled,
+	PositionTopLeft, PositionTopRight, PositionBottomLeft, PositionBottomRight,
+}
+
+// Bounds for per-campaign visible watermark font sizes (points for images,
+// pixels for video).
+const (
+	MinVisibleFontSize = 6
+	MaxVisibleFontSize = 200
+)
+
+// ValidateVisibleStyle checks a campaign's visible watermark settings.
+// Zero opacity and font size mean "use the default".
+func ValidateVisibleStyle(position string, opacity float64, fontSize int) error {
+	valid := false
+	for _, p := range VisiblePositions {
+		if position == p {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		return fmt.Errorf("visible watermark position must be one of %s", strings.Join(VisiblePositions[1:], ", "))
+	}
+	if math.IsNaN(opacity) || opacity < 0 || opacity > 1 {
+		return fmt.Errorf("visible watermark opacity must be between 0 and 1")
+	}
+	if fontSize != 0 && (fontSize < MinVisibleFontSize || fontSize > MaxVisibleFontSize) {
+		return fmt.Errorf("visible watermark font size must be between %d and %d", MinVisibleFontSize, MaxVisibleFontSize)
+	}
+	return nil
+}
+
+// visibleMark is one placement of the text. anchor is an ImageMagick
+// gravity; fx/fy locate the anchor as a fraction of the frame for drawtext.
+type visibleMark struct {
+	anchor   string
+	fx, fy   float64
+	opacity  float64
+	fontSize int
+}
+
+// visibleLayout expands a placement into marks. defOpacity/defSize apply to
+// the corner marks of the default layout and to single placements;
+// centreOpacity/centreSize to the default layout's centre mark. A non-zero
+// opacity or fontSize overrides both.
+func visibleLayout(position string, opacity float64, fontSize int, defOpacity float64, defSize int, centreOpacity float64, centreSize int) []visibleMark {
+	if opacity > 0 {
+		defOpacity, centreOpacity = opacity, opacity
+	}
+	if fontSize > 0 {
+		defSize, centreSize = fontSize, fontSize
+	}
+	mark := func(anchor string, fx, fy float64) visibleMark {
+		return visibleMark{anchor: anchor, fx: fx, fy: fy, opacity: defOpacity, fontSize: defSize}
+	}
+	switch position {
+	case PositionCenter:
+		return []visibleMark{mark("Center", 0.5, 0.5)}
+	case PositionTopLeft:
+		return []visibleMark{mark("NorthWest", 0, 0)}
+	case PositionTopRight:
+		return []visibleMark{mark("NorthEast", 1, 0)}
+	case PositionBottomLeft:
+		return []visibleMark{mark("SouthWest", 0, 1)}
+	case PositionBottomRight:
+		return []visibleMark{mark("SouthEast", 1, 1)}
+	case PositionTiled:
+		// A 3×3 grid covering the frame.
+		anchors := [3][3]string{
+			{"NorthWest", "North", "NorthEast"},
+			{"West", "Center", "East"},
+			{"SouthWest", "South", "SouthEast"},
+		}
+		var marks []visibleMark
+		for row := 0; row < 3; row++ {
+			for col := 0; col < 3; col++ {
+				marks = append(marks, mark(anchors[row][col], float64(col)/2, float64(row)/2))
+			}
+		}
+		return marks
+	default:
+		return []visibleMark{
+			mark("SouthEast", 1, 1),
+			mark("NorthWest", 0, 0),
+			{anchor: "Center", fx: 0.5, fy: 0.5, opacity: centreOpacity, fontSize: centreSize},
+		}
+	}
+}
+
+// drawtextPosition returns drawtext x/y expressions that put the text at
+// fraction (fx, fy) of the frame, inset by margin pixels at the edges.
+func drawtextPosition(fx, fy float64, margin int) (x, y string) {
+	axis := func(f float64, size, text string) string {
+		switch f {
+		case 0:
+			return fmt.Sprint(margin)
+		case 1:
+			return fmt.Sprintf("%s-%s-%d", size, text, margin)
+		default:
+			return fmt.Sprintf("(%s-%s)*%g", size, text, f)
+		}
+	}
+	return axis(fx, "w", "text_w"), axis(fy, "h", "text_h")
+}
+
+// escapeMagickText stops ImageMagick from treating the text as a file
+// reference (leading @) or expanding % escapes.
+func escapeMagickText(s string) string {
+	s = strings.ReplaceAll(s, "%", "%%")
+	if strings.HasPrefix(s, "@") {
+		s = `\` + s
+	}
+	return s
+}
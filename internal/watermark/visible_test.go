@@ -0,0 +1,132 @@
+package watermark
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestImageWatermarkArgsDefaultLayout(t *testing.T) {
+	got := imageWatermarkArgs(ImageParams{InputPath: "in.png", OutputPath: "out.jpg", Text: "[ab | Jane]", FontPath: "f.ttf"})
+	want := []string{"in.png", "-font", "f.ttf",
+		"-pointsize", "24", "-fill", "rgba(255,255,255,0.15)", "-gravity", "SouthEast", "-annotate", "+20+20", "[ab | Jane]",
+		"-pointsize", "24", "-fill", "rgba(255,255,255,0.15)", "-gravity", "NorthWest", "-annotate", "+20+20", "[ab | Jane]",
+		"-pointsize", "32", "-fill", "rgba(255,255,255,0.08)", "-gravity", "Center", "-annotate", "+0+0", "[ab | Jane]",
+		"-sampling-factor", "4:4:4", "-quality", "92", "out.jpg"}
+	if !slices.Equal(got, want) {
+		t.Errorf("args =\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestImageWatermarkArgsStyle(t *testing.T) {
+	got := strings.Join(imageWatermarkArgs(ImageParams{Text: "@secret 100%", Position: PositionTiled, Opacity: 0.5, FontSize: 40}), " ")
+	if n := strings.Count(got, "-annotate"); n != 9 {
+		t.Errorf("tiled: %d marks, want 9", n)
+	}
+	if strings.Count(got, "rgba(255,255,255,0.5)") != 9 || strings.Count(got, "-pointsize 40") != 9 {
+		t.Errorf("opacity/size not applied to every mark: %s", got)
+	}
+	if !strings.Contains(got, `\@secret 100%%`) {
+		t.Errorf("text not escaped for ImageMagick: %s", got)
+	}
+
+	got = strings.Join(imageWatermarkArgs(ImageParams{Position: PositionTopRight}), " ")
+	if strings.Count(got, "-annotate") != 1 || !strings.Contains(got, "-gravity NorthEast") || !strings.Contains(got, "0.15") {
+		t.Errorf("top-right: %s", got)
+	}
+}
+
+func TestVideoWatermarkFilter(t *testing.T) {
+	def := videoWatermarkFilter(VideoParams{Text: "x", FontPath: "f.ttf"})
+	if !strings.Contains(def, "fontcolor=white@0.15:fontsize=11:x='if(lt(mod(t\\,60)") ||
+		!strings.Contains(def, "fontcolor=white@0.08:fontsize=14:x=(w-text_w)/2") {
+		t.Errorf("default filter changed: %s", def)
+	}
+
+	br := videoWatermarkFilter(VideoParams{Text: "x", FontPath: "f.ttf", Position: PositionBottomRight, Opacity: 0.6, FontSize: 30})
+	if br != "drawtext=text='x':fontcolor=white@0.6:fontsize=30:x=w-text_w-20:y=h-text_h-20:fontfile='f.ttf'" {
+		t.Errorf("bottom-right filter = %s", br)
+	}
+	if tiled := videoWatermarkFilter(VideoParams{Text: "x", Position: PositionTiled}); strings.Count(tiled, "drawtext=") != 9 {
+		t.Errorf("tiled filter has %d drawtexts, want 9", strings.Count(tiled, "drawtext="))
+	}
+}
+
+func TestValidateVisibleStyle(t *testing.T) {
+	for _, p := range VisiblePositions {
+		if err := ValidateVisibleStyle(p, 0, 0); err != nil {
+			t.Errorf("position %q rejected: %v", p, err)
+		}
+	}
+	if err := ValidateVisibleStyle("diagonal", 0, 0); err == nil {
+		t.Error("unknown position accepted")
+	}
+	for _, o := range []float64{1.5, math.NaN(), math.Inf(1)} {
+		if err := ValidateVisibleStyle("", o, 0); err == nil {
+			t.Errorf("opacity %g accepted", o)
+		}
+	}
+	if err := ValidateVisibleStyle("", 0.5, 2); err == nil {
+		t.Error("font size 2 accepted")
+	}
+}
+
+// TestImageWatermarkOpacityPixels renders white text on black at two
+// opacities and checks the stronger one is brighter. It needs ImageMagick
+// and the configured font.
+func TestImageWatermarkOpacityPixels(t *testing.T) {
+	if _, err := exec.LookPath("magick"); err != nil {
+		t.Skip("magick not installed")
+	}
+	font := "/usr/share/fonts/truetype/dejavu/DejaVuSans.ttf"
+	if _, err := os.Stat(font); err != nil {
+		t.Skip("font not installed")
+	}
+
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.png")
+	src := image.NewGray(image.Rect(0, 0, 400, 200)) // black
+	f, err := os.Create(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	png.Encode(f, src)
+	f.Close()
+
+	brightness := func(opacity float64) float64 {
+		out := filepath.Join(dir, "out.png")
+		err := ImageWatermark(context.Background(), ImageParams{
+			InputPath: in, OutputPath: out, Text: "WATERMARK", FontPath: font,
+			Position: PositionCenter, Opacity: opacity, FontSize: 48,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		img, err := loadImageNRGBA(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var sum float64
+		b := img.Bounds()
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				c := color.GrayModel.Convert(img.At(x, y)).(color.Gray)
+				sum += float64(c.Y)
+			}
+		}
+		return sum / float64(b.Dx()*b.Dy())
+	}
+
+	faint, strong := brightness(0.1), brightness(0.9)
+	if !(strong > faint*3) {
+		t.Errorf("mean brightness at opacity 0.9 = %.2f, at 0.1 = %.2f; want the stronger mark clearly brighter", strong, faint)
+	}
+}
@@ -9,9 +9,16 @@ import (
 	"github.com/YannKr/downloadonce/internal/db"
 )
 
+// Retrier re-sends deliveries whose next_retry_at has passed: failed ones on
+// the backoff schedule, replayed ones, and new ones whose first attempt was
+// lost (see attemptLease).
 type Retrier struct {
 	DB       *sql.DB
 	Interval time.Duration
+	// SignatureVersion and Backoff apply to each retried delivery (see
+	// Dispatcher).
+	SignatureVersion string
+	Backoff          []time.Duration
 }
 
 func (r *Retrier) Start(ctx context.Context) {
@@ -23,6 +30,9 @@ func (r *Retrier) Start(ctx context.Context) {
 }
 
 func (r *Retrier) loop(ctx context.Context) {
+	// Deliveries that came due while the server was down go out right away.
+	r.runOnce(ctx)
+
 	ticker := time.NewTicker(r.Interval)
 	defer ticker.Stop()
 	for {
@@ -30,24 +40,28 @@ func (r *Retrier) loop(ctx context.Context) {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			r.runOnce()
+			r.runOnce(ctx)
 		}
 	}
 }
 
-func (r *Retrier) runOnce() {
+// runOnce attempts every due delivery once, counting it as the next attempt.
+func (r *Retrier) runOnce(ctx context.Context) {
 	deliveries, err := db.ListDueWebhookDeliveries(r.DB, time.Now())
 	if err != nil {
 		slog.Error("webhook retrier: list due deliveries", "error", err)
 		return
 	}
 	for i := range deliveries {
+		if ctx.Err() != nil {
+			return
+		}
 		d := &deliveries[i]
 		wh, err := db.GetWebhookByID(r.DB, d.WebhookID)
 		if err != nil || wh == nil {
 			continue
 		}
 		d.AttemptNumber++
-		attemptAndRecord(r.DB, wh, d)
+		attemptAndRecord(ctx, r.DB, wh, d, r.SignatureVersion, backoffOr(r.Backoff))
 	}
 }
@@ -0,0 +1,121 @@
+package webhook
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/YannKr/downloadonce/internal/db"
+	"github.com/YannKr/downloadonce/internal/model"
+)
+
+func TestRetrierRetriesUntilDelivered(t *testing.T) {
+	database := newTestDB(t)
+
+	var hits, healthy atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		if healthy.Load() == 0 {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	if err := db.CreateWebhook(database, &model.Webhook{ID: "wh", AccountID: "acc", URL: srv.URL, Secret: "s", Events: "download", Enabled: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	d := &Dispatcher{DB: database}
+	d.Dispatch(context.Background(), "acc", "download", map[string]string{"k": "v"})
+
+	delivery := func() *model.WebhookDelivery {
+		t.Helper()
+		ds, err := db.ListWebhookDeliveries(database, "wh", "", 10, 0)
+		if err != nil || len(ds) != 1 {
+			t.Fatalf("deliveries = %v, %v", ds, err)
+		}
+		return &ds[0]
+	}
+	// Dispatch makes the first attempt in a goroutine.
+	deadline := time.Now().Add(5 * time.Second)
+	for delivery().State == "pending" {
+		if time.Now().After(deadline) {
+			t.Fatal("first attempt was never recorded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	first := delivery()
+	if first.State != "failed" || first.AttemptNumber != 1 || first.NextRetryAt == nil || !first.NextRetryAt.After(time.Now()) {
+		t.Fatalf("after first attempt: state %s, attempt %d, next %v", first.State, first.AttemptNumber, first.NextRetryAt)
+	}
+
+	r := &Retrier{DB: database}
+	r.runOnce(context.Background())
+	if n := hits.Load(); n != 1 {
+		t.Fatalf("retrier sent a delivery that was not due yet (%d requests)", n)
+	}
+
+	makeDue := func() {
+		t.Helper()
+		past := time.Now().Add(-time.Second).UTC().Format(time.RFC3339)
+		if _, err := database.Exec(`UPDATE webhook_deliveries SET next_retry_at = ?`, past); err != nil {
+			t.Fatal(err)
+		}
+	}
+	makeDue()
+	r.runOnce(context.Background())
+	if got := delivery(); got.State != "failed" || got.AttemptNumber != 2 {
+		t.Fatalf("after second attempt: state %s, attempt %d", got.State, got.AttemptNumber)
+	}
+
+	healthy.Store(1)
+	makeDue()
+	r.runOnce(context.Background())
+	got := delivery()
+	if got.State != "delivered" || got.AttemptNumber != 3 || got.NextRetryAt != nil || got.DeliveredAt == nil {
+		t.Fatalf("after recovery: state %s, attempt %d, next %v", got.State, got.AttemptNumber, got.NextRetryAt)
+	}
+	if n := hits.Load(); n != 3 {
+		t.Errorf("endpoint got %d requests, want 3", n)
+	}
+
+	// Delivered deliveries are not sent again.
+	r.runOnce(context.Background())
+	if n := hits.Load(); n != 3 {
+		t.Errorf("delivered delivery was resent (%d requests)", n)
+	}
+}
+
+func TestRetrierLeavesFirstAttemptToDispatch(t *testing.T) {
+	database := newTestDB(t)
+
+	release := make(chan struct{})
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	if err := db.CreateWebhook(database, &model.Webhook{ID: "wh", AccountID: "acc", URL: srv.URL, Secret: "s", Events: "download", Enabled: true}); err != nil {
+		t.Fatal(err)
+	}
+	(&Dispatcher{DB: database}).Dispatch(context.Background(), "acc", "download", nil)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for hits.Load() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("first attempt never reached the endpoint")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// The first attempt is still in flight; the retrier must not send it too.
+	(&Retrier{DB: database}).runOnce(context.Background())
+	if n := hits.Load(); n != 1 {
+		t.Errorf("endpoint got %d requests while the first attempt was in flight, want 1", n)
+	}
+}
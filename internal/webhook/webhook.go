@@ -2,6 +2,7 @@ package webhook
 
 import (
 	"bytes"
+	"context"
 	"crypto/hmac"
 	"crypto/sha256"
 	"database/sql"
@@ -11,6 +12,8 @@ import (
 	"io"
 	"log/slog"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -18,24 +21,117 @@ import (
 	"github.com/YannKr/downloadonce/internal/model"
 )
 
-var backoffSchedule = []time.Duration{
+// defaultBackoff is the retry schedule of a Dispatcher or Retrier whose
+// Backoff is nil, matching the WEBHOOK_RETRY_SCHEDULE default.
+var defaultBackoff = []time.Duration{
 	30 * time.Second,
 	5 * time.Minute,
 	30 * time.Minute,
 	2 * time.Hour,
 }
 
-func nextRetryAt(attemptNumber int) *time.Time {
+// ParseBackoff parses a comma-separated list of increasing durations such as
+// "30s,5m,30m,2h"; "none" gives an empty schedule.
+func ParseBackoff(s string) ([]time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if strings.EqualFold(s, "none") {
+		return []time.Duration{}, nil
+	}
+	var schedule []time.Duration
+	for _, part := range strings.Split(s, ",") {
+		d, err := time.ParseDuration(strings.TrimSpace(part))
+		if err != nil {
+			return nil, fmt.Errorf("webhook retry schedule: %w", err)
+		}
+		if d <= 0 {
+			return nil, fmt.Errorf("webhook retry schedule: interval %s must be positive", d)
+		}
+		if n := len(schedule); n > 0 && d <= schedule[n-1] {
+			return nil, fmt.Errorf("webhook retry schedule: intervals must increase (%s after %s)", d, schedule[n-1])
+		}
+		schedule = append(schedule, d)
+	}
+	return schedule, nil
+}
+
+// FormatBackoff renders a schedule as ParseBackoff reads it, with whole
+// minutes and hours shortened ("30s, 5m, 2h").
+func FormatBackoff(schedule []time.Duration) string {
+	parts := make([]string, len(schedule))
+	for i, d := range schedule {
+		s := d.String()
+		if strings.HasSuffix(s, "m0s") {
+			s = strings.TrimSuffix(s, "0s")
+		}
+		if strings.HasSuffix(s, "h0m") {
+			s = strings.TrimSuffix(s, "0m")
+		}
+		parts[i] = s
+	}
+	return strings.Join(parts, ", ")
+}
+
+// attemptLease is how long the Retrier leaves a new delivery to the first
+// attempt Dispatch starts itself; it is longer than the client timeout, so the
+// two never send the same delivery at once, and a delivery whose first attempt
+// never recorded a result (the process stopped) is sent by the Retrier after it.
+const attemptLease = time.Minute
+
+// backoffOr returns schedule, or defaultBackoff when it is nil.
+func backoffOr(schedule []time.Duration) []time.Duration {
+	if schedule == nil {
+		return defaultBackoff
+	}
+	return schedule
+}
+
+// nextRetryAt returns when the delivery that just made attempt attemptNumber
+// should be tried again, or nil once schedule is used up.
+func nextRetryAt(schedule []time.Duration, attemptNumber int) *time.Time {
 	idx := attemptNumber - 1
-	if idx >= len(backoffSchedule) {
+	if idx < 0 || idx >= len(schedule) {
 		return nil
 	}
-	t := time.Now().Add(backoffSchedule[idx])
+	t := time.Now().Add(schedule[idx])
 	return &t
 }
 
+// Signature schemes for X-DownloadOnce-Signature. v1 signs
+// "<timestamp>.<body>" so receivers can reject replays of old deliveries; v0
+// signs the body alone and is kept for receivers not yet checking timestamps.
+const (
+	SignatureV0 = "v0"
+	SignatureV1 = "v1"
+)
+
+// ParseSignatureVersion normalizes a WEBHOOK_SIGNATURE_VERSION value. An
+// empty string is SignatureV1; anything other than v0 or v1 is an error.
+func ParseSignatureVersion(s string) (string, error) {
+	switch v := strings.ToLower(strings.TrimSpace(s)); v {
+	case "":
+		return SignatureV1, nil
+	case SignatureV0, SignatureV1:
+		return v, nil
+	}
+	return "", fmt.Errorf("webhook signature version: unknown value %q (want v0 or v1)", s)
+}
+
 type Dispatcher struct {
 	DB *sql.DB
+	// SignatureVersion is SignatureV0 or SignatureV1 (the default when empty).
+	SignatureVersion string
+	// Backoff holds the delays before each retry of a failed delivery (see
+	// ParseBackoff); a delivery is exhausted once they are used up, so an
+	// empty schedule means a single attempt. Nil uses the default schedule.
+	Backoff []time.Duration
+}
+
+// RetrySchedule returns the retry delays the dispatcher uses.
+func (d *Dispatcher) RetrySchedule() []time.Duration {
+	if d == nil {
+		return defaultBackoff
+	}
+	return backoffOr(d.Backoff)
 }
 
 type Event struct {
@@ -45,14 +141,17 @@ type Event struct {
 	Data      interface{} `json:"data"`
 }
 
-func (d *Dispatcher) Dispatch(accountID, eventType string, data interface{}) {
+// Dispatch records and sends an event to the account's enabled webhooks for
+// eventType. ctx only tags log lines with its request ID; deliveries outlive
+// it.
+func (d *Dispatcher) Dispatch(ctx context.Context, accountID, eventType string, data interface{}) {
 	if d == nil || d.DB == nil {
 		return
 	}
 
 	webhooks, err := db.ListEnabledWebhooks(d.DB, accountID, eventType)
 	if err != nil {
-		slog.Error("webhook lookup", "error", err)
+		slog.ErrorContext(ctx, "webhook lookup", "error", err)
 		return
 	}
 	if len(webhooks) == 0 {
@@ -68,11 +167,11 @@ func (d *Dispatcher) Dispatch(accountID, eventType string, data interface{}) {
 	}
 	payload, err := json.Marshal(event)
 	if err != nil {
-		slog.Error("webhook marshal", "error", err)
+		slog.ErrorContext(ctx, "webhook marshal", "error", err)
 		return
 	}
 
-	now := time.Now()
+	leaseEnd := time.Now().Add(attemptLease)
 	for _, wh := range webhooks {
 		delivery := &model.WebhookDelivery{
 			ID:            uuid.New().String(),
@@ -82,19 +181,19 @@ func (d *Dispatcher) Dispatch(accountID, eventType string, data interface{}) {
 			PayloadJSON:   string(payload),
 			AttemptNumber: 1,
 			State:         "pending",
-			NextRetryAt:   &now,
+			NextRetryAt:   &leaseEnd,
 		}
 		if err := db.CreateWebhookDelivery(d.DB, delivery); err != nil {
-			slog.Error("webhook: create delivery record", "error", err)
+			slog.ErrorContext(ctx, "webhook: create delivery record", "error", err)
 			continue
 		}
-		go attemptAndRecord(d.DB, &wh, delivery)
+		go attemptAndRecord(context.WithoutCancel(ctx), d.DB, &wh, delivery, d.SignatureVersion, d.RetrySchedule())
 	}
 }
 
-func attemptAndRecord(database *sql.DB, wh *model.Webhook, delivery *model.WebhookDelivery) {
+func attemptAndRecord(ctx context.Context, database *sql.DB, wh *model.Webhook, delivery *model.WebhookDelivery, version string, schedule []time.Duration) {
 	payload := []byte(delivery.PayloadJSON)
-	status, preview, err := postWebhook(wh.URL, wh.Secret, payload)
+	status, preview, err := postWebhook(wh.URL, wh.Secret, version, payload)
 
 	delivery.ResponseStatus = status
 	delivery.ResponseBodyPreview = preview
@@ -105,37 +204,53 @@ func attemptAndRecord(database *sql.DB, wh *model.Webhook, delivery *model.Webho
 		delivery.NextRetryAt = nil
 		delivery.DeliveredAt = &now
 		delivery.ErrorMessage = ""
-		slog.Info("webhook delivered", "url", wh.URL, "event", delivery.EventType)
+		slog.InfoContext(ctx, "webhook delivered", "url", wh.URL, "event", delivery.EventType)
 	} else {
 		delivery.ErrorMessage = err.Error()
-		nextAt := nextRetryAt(delivery.AttemptNumber)
+		nextAt := nextRetryAt(schedule, delivery.AttemptNumber)
 		if nextAt == nil {
 			delivery.State = "exhausted"
 			delivery.NextRetryAt = nil
-			slog.Warn("webhook exhausted", "url", wh.URL, "event", delivery.EventType, "attempts", delivery.AttemptNumber)
+			slog.WarnContext(ctx, "webhook exhausted", "url", wh.URL, "event", delivery.EventType, "attempts", delivery.AttemptNumber)
 		} else {
 			delivery.State = "failed"
 			delivery.NextRetryAt = nextAt
-			slog.Warn("webhook failed, will retry", "url", wh.URL, "event", delivery.EventType,
+			slog.WarnContext(ctx, "webhook failed, will retry", "url", wh.URL, "event", delivery.EventType,
 				"attempt", delivery.AttemptNumber, "next_retry", nextAt)
 		}
 	}
 
 	if uerr := db.UpdateWebhookDelivery(database, delivery); uerr != nil {
-		slog.Error("webhook: update delivery record", "error", uerr)
+		slog.ErrorContext(ctx, "webhook: update delivery record", "error", uerr)
 	}
 }
 
-func postWebhook(url, secret string, payload []byte) (statusCode *int, preview string, err error) {
+// sign returns the hex HMAC-SHA256 of payload under secret: of the body alone
+// for SignatureV0, of timestamp + "." + body otherwise.
+func sign(secret, version, timestamp string, payload []byte) string {
 	mac := hmac.New(sha256.New, []byte(secret))
+	if version != SignatureV0 {
+		mac.Write([]byte(timestamp + "."))
+	}
 	mac.Write(payload)
-	signature := hex.EncodeToString(mac.Sum(nil))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func postWebhook(url, secret, version string, payload []byte) (statusCode *int, preview string, err error) {
+	if version != SignatureV0 {
+		version = SignatureV1
+	}
+	// Stamped per attempt, so a retry carries a fresh timestamp.
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	signature := sign(secret, version, timestamp, payload)
 
 	req, reqErr := http.NewRequest("POST", url, bytes.NewReader(payload))
 	if reqErr != nil {
 		return nil, "", fmt.Errorf("create request: %w", reqErr)
 	}
 	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-DownloadOnce-Timestamp", timestamp)
+	req.Header.Set("X-DownloadOnce-Signature-Version", version)
 	req.Header.Set("X-DownloadOnce-Signature", "sha256="+signature)
 
 	client := &http.Client{Timeout: 10 * time.Second}
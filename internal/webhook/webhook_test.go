@@ -0,0 +1,162 @@
+package webhook
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	downloadonce "github.com/YannKr/downloadonce"
+	"github.com/YannKr/downloadonce/internal/db"
+	"github.com/YannKr/downloadonce/internal/model"
+)
+
+// newTestDB returns a migrated database holding the account "acc".
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	database, err := db.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { database.Close() })
+	if err := db.Migrate(database, downloadonce.MigrationFS); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreateAccount(database, &model.Account{ID: "acc", Email: "a@example.com", Name: "a", PasswordHash: "x", Role: "admin", Enabled: true}); err != nil {
+		t.Fatal(err)
+	}
+	return database
+}
+
+func TestAttemptAndRecordSignature(t *testing.T) {
+	database := newTestDB(t)
+
+	const secret = "s3cret"
+	payload := `{"event_type":"download","event_id":"e1"}`
+	hexMAC := func(msg string) string {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(msg))
+		return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	}
+
+	for _, tc := range []struct {
+		version, wantVersion string
+	}{
+		{"", SignatureV1},
+		{SignatureV1, SignatureV1},
+		{SignatureV0, SignatureV0},
+	} {
+		t.Run(tc.wantVersion+"/"+tc.version, func(t *testing.T) {
+			var got http.Header
+			var body string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = r.Header.Clone()
+				b, _ := io.ReadAll(r.Body)
+				body = string(b)
+			}))
+			defer srv.Close()
+
+			wh := &model.Webhook{ID: "wh-" + tc.wantVersion + tc.version, AccountID: "acc", URL: srv.URL, Secret: secret, Events: "download", Enabled: true}
+			if err := db.CreateWebhook(database, wh); err != nil {
+				t.Fatal(err)
+			}
+			now := time.Now()
+			delivery := &model.WebhookDelivery{ID: "d-" + wh.ID, WebhookID: wh.ID, EventType: "download", EventID: "e1",
+				PayloadJSON: payload, AttemptNumber: 1, State: "pending", NextRetryAt: &now}
+			if err := db.CreateWebhookDelivery(database, delivery); err != nil {
+				t.Fatal(err)
+			}
+
+			attemptAndRecord(context.Background(), database, wh, delivery, tc.version, nil)
+
+			if body != payload {
+				t.Fatalf("body = %q", body)
+			}
+			if v := got.Get("X-DownloadOnce-Signature-Version"); v != tc.wantVersion {
+				t.Errorf("signature version = %q, want %q", v, tc.wantVersion)
+			}
+			ts := got.Get("X-DownloadOnce-Timestamp")
+			sec, err := strconv.ParseInt(ts, 10, 64)
+			if err != nil || time.Since(time.Unix(sec, 0)).Abs() > time.Minute {
+				t.Errorf("timestamp = %q", ts)
+			}
+			want := hexMAC(ts + "." + payload)
+			if tc.wantVersion == SignatureV0 {
+				want = hexMAC(payload)
+			}
+			if sig := got.Get("X-DownloadOnce-Signature"); sig != want {
+				t.Errorf("signature = %q, want %q", sig, want)
+			}
+
+			stored, err := db.GetWebhookDelivery(database, delivery.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stored.State != "delivered" {
+				t.Errorf("state = %q, want delivered", stored.State)
+			}
+		})
+	}
+}
+
+func TestParseSignatureVersion(t *testing.T) {
+	for in, want := range map[string]string{"": SignatureV1, "v1": SignatureV1, " V0 ": SignatureV0} {
+		if got, err := ParseSignatureVersion(in); err != nil || got != want {
+			t.Errorf("ParseSignatureVersion(%q) = %q, %v, want %q", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"v2", "1", "sha256"} {
+		if _, err := ParseSignatureVersion(in); err == nil {
+			t.Errorf("ParseSignatureVersion(%q) accepted", in)
+		}
+	}
+}
+
+func TestParseBackoff(t *testing.T) {
+	for _, tc := range []struct {
+		in, want string
+		wantErr  bool
+	}{
+		{in: "30s,5m,30m,2h", want: "30s, 5m, 30m, 2h"},
+		{in: " 1m , 90m, 12h, 48h ", want: "1m, 1h30m, 12h, 48h"},
+		{in: "none", want: ""},
+		{in: "5m,1m", wantErr: true},
+		{in: "1m,1m", wantErr: true},
+		{in: "0s,1m", wantErr: true},
+		{in: "1m,,2m", wantErr: true},
+		{in: "soon", wantErr: true},
+	} {
+		got, err := ParseBackoff(tc.in)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("ParseBackoff(%q) error = %v, want error %v", tc.in, err, tc.wantErr)
+			continue
+		}
+		if err == nil && FormatBackoff(got) != tc.want {
+			t.Errorf("ParseBackoff(%q) = %q, want %q", tc.in, FormatBackoff(got), tc.want)
+		}
+	}
+}
+
+func TestNextRetryAt(t *testing.T) {
+	schedule := []time.Duration{time.Minute, time.Hour}
+	if at := nextRetryAt(schedule, 2); at == nil || at.Sub(time.Now()) < 59*time.Minute {
+		t.Errorf("nextRetryAt(2) = %v, want about an hour from now", at)
+	}
+	if at := nextRetryAt(schedule, 3); at != nil {
+		t.Errorf("nextRetryAt(3) = %v, want nil once the schedule is used up", at)
+	}
+
+	if at := nextRetryAt([]time.Duration{}, 1); at != nil {
+		t.Errorf("empty schedule: nextRetryAt(1) = %v, want nil", at)
+	}
+	if got := (&Dispatcher{}).RetrySchedule(); len(got) != len(defaultBackoff) {
+		t.Errorf("nil Backoff: schedule = %v, want the default", got)
+	}
+}
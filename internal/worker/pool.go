@@ -5,17 +5,21 @@ import (
 	"database/sql"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"os"
 	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/YannKr/downloadonce/internal/config"
 	"github.com/YannKr/downloadonce/internal/db"
+	"github.com/YannKr/downloadonce/internal/diskstat"
 	"github.com/YannKr/downloadonce/internal/email"
+	"github.com/YannKr/downloadonce/internal/logging"
 	"github.com/YannKr/downloadonce/internal/model"
 	"github.com/YannKr/downloadonce/internal/sse"
 	"github.com/YannKr/downloadonce/internal/watermark"
@@ -36,12 +40,29 @@ func nextRetryDelay(retryCount int) time.Duration {
 	return backoffDelays[len(backoffDelays)-1]
 }
 
+// errJobCancelled is returned by processJob when the job's campaign publish
+// was cancelled; the job is dropped rather than failed.
+var errJobCancelled = errors.New("campaign publish cancelled")
+
+// permanentError marks a job failure that retrying cannot fix, such as a
+// missing input file or a deleted campaign.
+type permanentError struct{ err error }
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+func permanent(err error) error { return &permanentError{err: err} }
+
 // isPermanentFailure returns true if the error indicates a condition that will
 // never succeed on retry (e.g., corrupt input file, unknown format).
 func isPermanentFailure(err error) bool {
 	if err == nil {
 		return false
 	}
+	var pe *permanentError
+	if errors.As(err, &pe) {
+		return true
+	}
 	msg := strings.ToLower(err.Error())
 	// FFmpeg permanent errors
 	if strings.Contains(msg, "invalid data found when processing input") ||
@@ -71,16 +92,169 @@ type Pool struct {
 	sseHub   *sse.Hub
 	cancel   context.CancelFunc
 	wg       sync.WaitGroup
+	// running counts worker goroutines that have not returned.
+	running atomic.Int32
+
+	// embeddable caches watermark.CheckEmbeddable per asset ID, so a
+	// campaign decodes its source once rather than once per token. Entries
+	// record the SHA-256 they were computed for; a replaced asset overwrites
+	// its entry and a purged one is dropped by ForgetAsset.
+	embeddable sync.Map
+
+	// detectSlots is a semaphore bounding running detect jobs to
+	// MaxConcurrentDetect; nil when there is no cap.
+	detectSlots chan struct{}
+
+	// DiskCache, when set, lets the pool pause watermark jobs while the
+	// disk is at the block threshold (DISK_PAUSE_WORKERS).
+	DiskCache DiskStats
+	// diskPaused remembers the last pause decision so changes are logged once.
+	diskPaused atomic.Bool
+
+	// freeBytes reports free space for a path; replaceable in tests.
+	freeBytes func(path string) (uint64, error)
+	// runJob processes a claimed job; replaceable in tests.
+	runJob func(ctx context.Context, job *model.Job) error
+}
+
+// DiskStats supplies cached disk usage; *diskstat.Cache implements it.
+type DiskStats interface {
+	Get() diskstat.Stats
 }
 
+var (
+	nonDetectJobTypes = []string{"watermark_video", "watermark_image", "thumbnail"}
+	allJobTypes       = []string{"watermark_video", "watermark_image", "thumbnail", "detect"}
+	// Under disk pressure only jobs that write little or nothing are claimed.
+	pausedJobTypes      = []string{"thumbnail", "detect"}
+	pausedNoDetectTypes = []string{"thumbnail"}
+)
+
 func NewPool(database *sql.DB, cfg *config.Config, mailer *email.Mailer, webhookDispatcher *webhook.Dispatcher, sseHub *sse.Hub) *Pool {
-	return &Pool{database: database, cfg: cfg, mailer: mailer, webhook: webhookDispatcher, sseHub: sseHub}
+	p := &Pool{database: database, cfg: cfg, mailer: mailer, webhook: webhookDispatcher, sseHub: sseHub, freeBytes: diskstat.FreeBytes}
+	if cfg.MaxConcurrentDetect > 0 {
+		p.detectSlots = make(chan struct{}, cfg.MaxConcurrentDetect)
+	}
+	p.runJob = p.dispatchJob
+	return p
+}
+
+// claimJob claims the next job for a worker. Detect jobs are only eligible
+// while a detect slot is free, so once MaxConcurrentDetect detections are
+// running the remaining workers keep taking watermark jobs instead of
+// queueing behind them. While the disk is under block-level pressure no new
+// watermark jobs are claimed (see diskPressure). The returned release frees
+// the slot held for a detect job and must be called when the job finishes.
+func (p *Pool) claimJob() (*model.Job, func(), error) {
+	paused := p.diskPressure()
+	withDetect, withoutDetect := allJobTypes, nonDetectJobTypes
+	if paused {
+		withDetect, withoutDetect = pausedJobTypes, pausedNoDetectTypes
+		if p.cfg.DiskPauseDetect {
+			withDetect = pausedNoDetectTypes
+		}
+	}
+	if p.detectSlots == nil {
+		job, err := db.ClaimNextJobFair(p.database, withDetect, p.cfg.MaxJobsPerAccount)
+		return job, func() {}, err
+	}
+	acquired := false
+	select {
+	case p.detectSlots <- struct{}{}:
+		acquired = true
+	default:
+	}
+	jobTypes := withoutDetect
+	if acquired {
+		jobTypes = withDetect
+	}
+	job, err := db.ClaimNextJobFair(p.database, jobTypes, p.cfg.MaxJobsPerAccount)
+	if !acquired {
+		return job, func() {}, err
+	}
+	if job == nil || job.JobType != "detect" {
+		<-p.detectSlots
+		return job, func() {}, err
+	}
+	return job, func() { <-p.detectSlots }, err
+}
+
+// diskPressure reports whether watermark jobs should be held back because
+// free space is at DISK_WARN_BLOCK_PCT. Jobs already running finish; the
+// pool resumes on its own once a later disk sample is above the threshold.
+// Pause and resume are logged once each.
+func (p *Pool) diskPressure() bool {
+	if p.DiskCache == nil || !p.cfg.DiskPauseWorkers {
+		return false
+	}
+	stats := p.DiskCache.Get()
+	paused := !stats.CapturedAt.IsZero() &&
+		stats.WarningLevel(p.cfg.DiskWarnYellowPct, p.cfg.DiskWarnRedPct, p.cfg.DiskWarnBlockPct) == diskstat.WarnBlock
+	if p.diskPaused.Swap(paused) != paused {
+		if paused {
+			slog.Warn("disk nearly full, pausing watermark jobs", "pct_free", stats.PctFree(),
+				"block_pct", p.cfg.DiskWarnBlockPct, "detect_paused", p.cfg.DiskPauseDetect)
+		} else {
+			slog.Info("disk space recovered, resuming watermark jobs", "pct_free", stats.PctFree())
+		}
+	}
+	return paused
+}
+
+func (p *Pool) dispatchJob(ctx context.Context, job *model.Job) error {
+	switch job.JobType {
+	case "detect":
+		return p.processDetectJob(ctx, job)
+	case "thumbnail":
+		return p.processThumbnailJob(ctx, job)
+	}
+	return p.processJob(ctx, job)
+}
+
+// processThumbnailJob generates the thumbnail of an upload whose thumbnail
+// was deferred (DEFER_THUMBNAILS).
+func (p *Pool) processThumbnailJob(ctx context.Context, job *model.Job) error {
+	asset, err := db.GetAsset(p.database, job.AssetID)
+	if err != nil {
+		return fmt.Errorf("load asset %s: %w", job.AssetID, err)
+	}
+	if asset == nil {
+		return permanent(fmt.Errorf("asset %s not found", job.AssetID))
+	}
+	var duration float64
+	if asset.Duration != nil {
+		duration = *asset.Duration
+	}
+	srcPath := filepath.Join(p.cfg.DataDir, asset.OriginalPath)
+	thumbPath := filepath.Join(p.cfg.DataDir, "originals", asset.ID, "thumb.jpg")
+	return watermark.ExtractAssetThumbnail(ctx, asset.AssetType, srcPath, thumbPath, duration)
+}
+
+// checkDiskSpace verifies that writing an output of roughly estimate bytes
+// into dir would still leave WorkerMinFreeBytes free. Failing before the write
+// avoids leaving a truncated file behind when the disk fills mid-encode.
+func (p *Pool) checkDiskSpace(dir string, estimate int64) error {
+	free, err := p.freeBytes(dir)
+	if err != nil {
+		// Can't tell; let the write proceed rather than block all jobs.
+		slog.Warn("disk preflight: statfs failed", "dir", dir, "error", err)
+		return nil
+	}
+	need := uint64(estimate)
+	if p.cfg.WorkerMinFreeBytes > 0 {
+		need += uint64(p.cfg.WorkerMinFreeBytes)
+	}
+	if free < need {
+		return fmt.Errorf("disk full: need %d bytes free in %s, have %d", need, dir, free)
+	}
+	return nil
 }
 
 func (p *Pool) Start(ctx context.Context) {
 	ctx, p.cancel = context.WithCancel(ctx)
 	for i := 0; i < p.cfg.WorkerCount; i++ {
 		p.wg.Add(1)
+		p.running.Add(1)
 		go p.run(ctx, i)
 	}
 	slog.Info("worker pool started", "workers", p.cfg.WorkerCount)
@@ -94,10 +268,20 @@ func (p *Pool) Stop() {
 	slog.Info("worker pool stopped")
 }
 
+// Running reports how many workers are running, for the readiness probe.
+func (p *Pool) Running() int {
+	return int(p.running.Load())
+}
+
+// DiskPaused reports whether workers are holding back watermark jobs because
+// of disk pressure.
+func (p *Pool) DiskPaused() bool {
+	return p.diskPaused.Load()
+}
+
 func (p *Pool) run(ctx context.Context, id int) {
 	defer p.wg.Done()
-
-	jobTypes := []string{"watermark_video", "watermark_image", "detect"}
+	defer p.running.Add(-1)
 
 	for {
 		select {
@@ -106,7 +290,7 @@ func (p *Pool) run(ctx context.Context, id int) {
 		default:
 		}
 
-		job, err := db.ClaimNextJob(p.database, jobTypes)
+		job, release, err := p.claimJob()
 		if err != nil {
 			slog.Error("claim job", "worker", id, "error", err)
 			sleep(ctx, 2*time.Second)
@@ -117,46 +301,54 @@ func (p *Pool) run(ctx context.Context, id int) {
 			continue
 		}
 
-		slog.Info("processing job", "worker", id, "job", job.ID, "type", job.JobType)
-
-		var processErr error
-		switch job.JobType {
-		case "detect":
-			processErr = p.processDetectJob(ctx, job)
-		default:
-			processErr = p.processJob(ctx, job)
-		}
-
-		if processErr != nil {
-			slog.Error("job failed", "job", job.ID, "type", job.JobType, "error", processErr)
+		// Log lines of the job carry the ID of the request that queued it.
+		jobCtx := logging.WithRequestID(ctx, job.RequestID)
+		slog.InfoContext(jobCtx, "processing job", "worker", id, "job", job.ID, "type", job.JobType)
 
-			isPermanent := isPermanentFailure(processErr)
-			var retried bool
+		processErr := p.runJob(jobCtx, job)
+		release()
 
-			if isPermanent {
-				db.FailJob(p.database, job.ID, processErr.Error())
-			} else {
-				delay := nextRetryDelay(job.RetryCount)
-				retried, _ = db.RetryOrFailJob(p.database, job.ID, processErr.Error(), delay)
-			}
-
-			if !retried {
-				p.publishJobFailed(job, processErr.Error())
-				p.notifyJobFailed(job, processErr.Error())
-			} else {
-				slog.Info("job scheduled for retry", "job", job.ID, "retry", job.RetryCount+1, "delay", nextRetryDelay(job.RetryCount))
-			}
+		if errors.Is(processErr, errJobCancelled) {
+			slog.InfoContext(jobCtx, "job cancelled", "job", job.ID, "campaign", job.CampaignID)
+			db.DeleteJob(p.database, job.ID)
+		} else if processErr != nil {
+			p.handleJobFailure(jobCtx, job, processErr)
 		} else {
 			db.CompleteJob(p.database, job.ID)
-			slog.Info("job completed", "job", job.ID)
+			slog.InfoContext(jobCtx, "job completed", "job", job.ID)
 		}
 
-		if job.JobType != "detect" {
-			p.checkCampaignCompletion(job.CampaignID)
+		if job.JobType != "detect" && job.JobType != "thumbnail" {
+			p.checkCampaignCompletion(jobCtx, job.CampaignID)
 		}
 	}
 }
 
+// handleJobFailure re-queues a failed job with backoff, or marks it FAILED
+// when the error is permanent or its retries are exhausted.
+func (p *Pool) handleJobFailure(ctx context.Context, job *model.Job, processErr error) {
+	slog.ErrorContext(ctx, "job failed", "job", job.ID, "type", job.JobType, "error", processErr)
+
+	var retried bool
+	if isPermanentFailure(processErr) {
+		db.FailJob(p.database, job.ID, processErr.Error())
+	} else {
+		delay := nextRetryDelay(job.RetryCount)
+		var err error
+		retried, err = db.RetryOrFailJob(p.database, job.ID, processErr.Error(), delay)
+		if err != nil {
+			slog.ErrorContext(ctx, "requeue failed job", "job", job.ID, "error", err)
+		}
+	}
+
+	if !retried {
+		p.publishJobFailed(job, processErr.Error())
+		p.notifyJobFailed(job, processErr.Error())
+	} else {
+		slog.InfoContext(ctx, "job scheduled for retry", "job", job.ID, "retry", job.RetryCount+1, "delay", nextRetryDelay(job.RetryCount))
+	}
+}
+
 func (p *Pool) pythonPath() string {
 	return filepath.Join(p.cfg.VenvPath, "bin", "python3")
 }
@@ -169,35 +361,181 @@ func (p *Pool) detectScriptPath() string {
 	return filepath.Join(p.cfg.ScriptsDir, "detect_watermark.py")
 }
 
+// frameSampling returns the WM_VIDEO_FRAMES / WM_VIDEO_FRAME_SAMPLING
+// settings, validated at startup. Embedding and detection must use the same.
+func (p *Pool) frameSampling() watermark.FrameSampling {
+	return watermark.FrameSampling{
+		Frames:   p.cfg.WMVideoFrames,
+		Strategy: strings.ToLower(strings.TrimSpace(p.cfg.WMVideoFrameSampling)),
+	}
+}
+
+// invisibleParams returns the invisible watermark params for a campaign: its
+// own channels and scale where set, the WM_CHANNELS / WM_SCALE defaults
+// otherwise.
+func (p *Pool) invisibleParams(c *model.Campaign) (watermark.InvisibleParams, error) {
+	return resolveInvisibleParams(p.cfg, c.WMChannels, c.WMScale)
+}
+
+func resolveInvisibleParams(cfg *config.Config, channels string, scale *float64) (watermark.InvisibleParams, error) {
+	return watermark.NewInvisibleParams(invisibleSettings(cfg, channels, scale))
+}
+
+// invisibleSettings fills in the channels and scale a campaign leaves unset,
+// from WM_CHANNELS / WM_SCALE and then imwatermark's defaults. These are what
+// the watermark index records for each embed.
+func invisibleSettings(cfg *config.Config, channels string, scale *float64) (string, float64) {
+	if channels == "" {
+		channels = cfg.WMChannels
+	}
+	s := cfg.WMScale
+	if scale != nil {
+		s = *scale
+	}
+	if channels == "" {
+		channels = watermark.DefaultChannels
+	}
+	if s == 0 {
+		s = watermark.DefaultScale
+	}
+	return channels, s
+}
+
+// selfVerifyEnabled reports whether invisible image embeds for campaign are
+// re-detected after embedding: the campaign's override, else WM_SELF_VERIFY.
+func selfVerifyEnabled(cfg *config.Config, campaign *model.Campaign) bool {
+	if campaign.SelfVerify != nil {
+		return *campaign.SelfVerify
+	}
+	return cfg.WMSelfVerify
+}
+
+// detectParamCandidates lists the invisible params a detect job tries, most
+// likely first: the global defaults, the params recorded in the watermark
+// index for past embeds (so files keep being found after WM_CHANNELS or
+// WM_SCALE change), imwatermark's defaults (the Python fallback, and embeds
+// from before params were recorded), then every distinct campaign override.
+func (p *Pool) detectParamCandidates() []watermark.InvisibleParams {
+	var out []watermark.InvisibleParams
+	add := func(params watermark.InvisibleParams) {
+		for _, q := range out {
+			if q == params {
+				return
+			}
+		}
+		out = append(out, params)
+	}
+
+	if params, err := resolveInvisibleParams(p.cfg, "", nil); err == nil {
+		add(params)
+	}
+
+	embedded, err := db.ListEmbeddedInvisibleParams(p.database)
+	if err != nil {
+		slog.Warn("list embedded watermark params", "error", err)
+	}
+	for _, e := range embedded {
+		if params, err := watermark.NewInvisibleParams(e.Channels, *e.Scale); err == nil {
+			add(params)
+		}
+	}
+
+	add(watermark.DefaultInvisibleParams())
+
+	overrides, err := db.ListInvisibleParamOverrides(p.database)
+	if err != nil {
+		slog.Warn("list campaign watermark overrides", "error", err)
+	}
+	for _, o := range overrides {
+		if params, err := resolveInvisibleParams(p.cfg, o.Channels, o.Scale); err == nil {
+			add(params)
+		}
+	}
+	return out
+}
+
+// goDetectImage runs Go-native detection with each candidate param set and
+// returns the first payload whose CRC validates. When none validates it
+// returns the result of the first candidate so fuzzy matching can still run.
+func (p *Pool) goDetectImage(ctx context.Context, inputPath string) (string, error) {
+	var first string
+	var firstErr error
+	for i, params := range p.detectParamCandidates() {
+		payloadHex, err := watermark.GoInvisibleImageDetectParams(ctx, inputPath, watermark.PayloadLength, params)
+		if i == 0 {
+			first, firstErr = payloadHex, err
+		}
+		if err != nil {
+			continue
+		}
+		if b, err := hex.DecodeString(payloadHex); err == nil {
+			if _, _, valid := watermark.ParsePayload(b); valid {
+				return payloadHex, nil
+			}
+		}
+	}
+	return first, firstErr
+}
+
 func (p *Pool) processJob(ctx context.Context, job *model.Job) error {
+	// Database errors are retried; rows that no longer exist are not.
 	token, err := db.GetToken(p.database, job.TokenID)
-	if err != nil || token == nil {
+	if err != nil {
 		return fmt.Errorf("load token %s: %w", job.TokenID, err)
 	}
+	if token == nil {
+		return permanent(fmt.Errorf("token %s not found", job.TokenID))
+	}
 
 	campaign, err := db.GetCampaign(p.database, job.CampaignID)
-	if err != nil || campaign == nil {
+	if err != nil {
 		return fmt.Errorf("load campaign %s: %w", job.CampaignID, err)
 	}
+	if campaign == nil {
+		return permanent(fmt.Errorf("campaign %s not found", job.CampaignID))
+	}
+	if campaign.State == "DRAFT" {
+		// The publish was cancelled after this job was claimed.
+		return errJobCancelled
+	}
 
 	asset, err := db.GetAsset(p.database, campaign.AssetID)
-	if err != nil || asset == nil {
+	if err != nil {
 		return fmt.Errorf("load asset %s: %w", campaign.AssetID, err)
 	}
+	if asset == nil {
+		return permanent(fmt.Errorf("asset %s not found", campaign.AssetID))
+	}
 
 	recipient, err := db.GetRecipient(p.database, token.RecipientID)
-	if err != nil || recipient == nil {
+	if err != nil {
 		return fmt.Errorf("load recipient %s: %w", token.RecipientID, err)
 	}
+	if recipient == nil {
+		return permanent(fmt.Errorf("recipient %s not found", token.RecipientID))
+	}
 
 	db.UpdateJobProgress(p.database, job.ID, 10) // started
 	p.publishProgress(job, 10)
 
 	inputPath := filepath.Join(p.cfg.DataDir, asset.OriginalPath)
+	if _, err := os.Stat(inputPath); err != nil {
+		if os.IsNotExist(err) {
+			return permanent(fmt.Errorf("asset file missing: %w", err))
+		}
+		return fmt.Errorf("stat asset file: %w", err)
+	}
 	ext := filepath.Ext(asset.OriginalPath)
 	if job.JobType == "watermark_video" {
 		ext = ".mp4"
 	}
+	// The invisible embed writes JPEG for anything but .png, which would
+	// drop the alpha channel of a transparent WebP or GIF.
+	if job.JobType == "watermark_image" && campaign.InvisibleWM && !strings.EqualFold(ext, ".png") {
+		if alpha, err := watermark.HasTransparency(inputPath); err == nil && alpha {
+			ext = ".png"
+		}
+	}
 
 	outDir := filepath.Join(p.cfg.DataDir, "watermarked", job.CampaignID)
 	if err := os.MkdirAll(outDir, 0755); err != nil {
@@ -205,7 +543,31 @@ func (p *Pool) processJob(ctx context.Context, job *model.Job) error {
 	}
 	outputPath := filepath.Join(outDir, job.TokenID+ext)
 
-	wmText := watermark.WatermarkText(job.TokenID, recipient.Name)
+	estimate := diskstat.PublishEstimate(asset.FileSize, 1, p.cfg.WMCompressionFactor)
+	if campaign.InvisibleWM && job.JobType == "watermark_image" {
+		estimate *= 2 // lossless intermediate plus final output
+	}
+	if err := p.checkDiskSpace(outDir, estimate); err != nil {
+		return err
+	}
+
+	textData := watermark.NewTextData(job.TokenID, recipient.Name, recipient.Email, recipient.Org, campaign.Name, time.Now())
+	wmText, err := watermark.WatermarkText(campaign.WMTextTemplate, textData)
+	if err != nil {
+		// Templates are validated when the campaign is created, so this is
+		// unexpected; keep the job going with the default text.
+		slog.WarnContext(ctx, "visible watermark template failed, using default", "campaign", campaign.ID, "error", err)
+		wmText, _ = watermark.WatermarkText("", textData)
+	}
+
+	var visibleOpacity float64
+	var visibleFontSize int
+	if campaign.VisibleOpacity != nil {
+		visibleOpacity = *campaign.VisibleOpacity
+	}
+	if campaign.VisibleFontSize != nil {
+		visibleFontSize = *campaign.VisibleFontSize
+	}
 
 	// Build the proper 16-byte payload
 	payloadHex := watermark.PayloadHex(job.TokenID, job.CampaignID)
@@ -213,6 +575,28 @@ func (p *Pool) processJob(ctx context.Context, job *model.Job) error {
 	// needsInvisible is true if the campaign has invisible watermarking enabled.
 	// The Go-native path is always available; Python is a fallback when configured.
 	needsInvisible := campaign.InvisibleWM
+	var wmParams watermark.InvisibleParams
+	wmChannels, wmScale := invisibleSettings(p.cfg, campaign.WMChannels, campaign.WMScale)
+	if needsInvisible {
+		wmParams, err = watermark.NewInvisibleParams(wmChannels, wmScale)
+		if err != nil {
+			return fmt.Errorf("invisible watermark params: %w", err)
+		}
+	}
+
+	// wmAlgorithm records which algorithm was used for this token (written to watermark_index).
+	wmAlgorithm := "dwtDctSvd-go"
+
+	if needsInvisible && job.JobType == "watermark_image" {
+		var converted bool
+		inputPath, needsInvisible, converted = p.lowChromaInput(job, asset.ID, asset.SHA256, inputPath, outDir)
+		if converted {
+			defer os.Remove(inputPath)
+		}
+		if !needsInvisible {
+			wmAlgorithm = "visible-only"
+		}
+	}
 
 	// For images with invisible watermark: visible -> temp PNG (lossless), then invisible -> final JPEG.
 	// Using PNG for the intermediate avoids double JPEG compression which degrades the invisible watermark.
@@ -222,8 +606,8 @@ func (p *Pool) processJob(ctx context.Context, job *model.Job) error {
 		visibleOutput = outputPath + ".visible.png"
 	}
 
-	// wmAlgorithm records which algorithm was used for this token (written to watermark_index).
-	wmAlgorithm := "dwtDctSvd-go"
+	// selfVerified records the post-embed self-verify result; nil when it did not run.
+	var selfVerified *bool
 
 	switch job.JobType {
 	case "watermark_video":
@@ -232,6 +616,9 @@ func (p *Pool) processJob(ctx context.Context, job *model.Job) error {
 			OutputPath: outputPath,
 			Text:       wmText,
 			FontPath:   p.cfg.FontPath,
+			Position:   campaign.VisiblePosition,
+			Opacity:    visibleOpacity,
+			FontSize:   visibleFontSize,
 		})
 		if err != nil {
 			os.Remove(outputPath)
@@ -247,8 +634,8 @@ func (p *Pool) processJob(ctx context.Context, job *model.Job) error {
 			db.UpdateJobProgress(p.database, job.ID, 60) // invisible started
 			p.publishProgress(job, 60)
 			framesDir := filepath.Join(outDir, job.TokenID+"_frames")
-			if embedErr := watermark.InvisibleVideoEmbed(ctx, outputPath, payloadHex, p.pythonPath(), p.embedScriptPath(), framesDir); embedErr != nil {
-				slog.Warn("invisible video embed failed, continuing with visible only", "error", embedErr)
+			if embedErr := watermark.InvisibleVideoEmbed(ctx, outputPath, payloadHex, p.pythonPath(), p.embedScriptPath(), framesDir, p.frameSampling()); embedErr != nil {
+				slog.WarnContext(ctx, "invisible video embed failed, continuing with visible only", "error", embedErr)
 			}
 			db.UpdateJobProgress(p.database, job.ID, 90) // invisible done
 			p.publishProgress(job, 90)
@@ -263,6 +650,9 @@ func (p *Pool) processJob(ctx context.Context, job *model.Job) error {
 			OutputPath: visibleOutput,
 			Text:       wmText,
 			FontPath:   p.cfg.FontPath,
+			Position:   campaign.VisiblePosition,
+			Opacity:    visibleOpacity,
+			FontSize:   visibleFontSize,
 		})
 		if err != nil {
 			os.Remove(visibleOutput)
@@ -279,13 +669,31 @@ func (p *Pool) processJob(ctx context.Context, job *model.Job) error {
 			jpegQuality := 92
 
 			// Try Go-native embed first.
-			goErr := watermark.GoInvisibleImageEmbed(ctx, visibleOutput, outputPath, payloadHex, jpegQuality)
+			var goErr error
+			if selfVerifyEnabled(p.cfg, campaign) {
+				var res *watermark.VerifiedEmbed
+				res, goErr = watermark.GoInvisibleImageEmbedVerified(ctx, visibleOutput, outputPath, payloadHex, jpegQuality, wmParams)
+				if goErr == nil {
+					selfVerified = &res.Verified
+					if res.OutputPath != outputPath {
+						outputPath = res.OutputPath
+						ext = filepath.Ext(outputPath)
+					}
+					if !res.Verified {
+						slog.WarnContext(ctx, "invisible watermark self-verify failed, keeping output", "token", job.TokenID, "attempts", res.Attempts)
+					} else if res.Attempts > 1 {
+						slog.InfoContext(ctx, "invisible watermark self-verify passed after retry", "token", job.TokenID, "attempts", res.Attempts, "output", filepath.Base(outputPath))
+					}
+				}
+			} else {
+				goErr = watermark.GoInvisibleImageEmbedParams(ctx, visibleOutput, outputPath, payloadHex, jpegQuality, wmParams)
+			}
 			if goErr != nil {
-				slog.Warn("go invisible embed failed, falling back to python", "error", goErr)
+				slog.WarnContext(ctx, "go invisible embed failed, falling back to python", "error", goErr)
 				// Fall back to Python if configured.
 				if p.cfg.ScriptsDir != "" {
 					if pyErr := watermark.InvisibleImageEmbed(ctx, visibleOutput, outputPath, payloadHex, p.pythonPath(), p.embedScriptPath(), jpegQuality); pyErr != nil {
-						slog.Warn("python invisible image embed also failed, using visible-only output", "error", pyErr)
+						slog.WarnContext(ctx, "python invisible image embed also failed, using visible-only output", "error", pyErr)
 						os.Rename(visibleOutput, outputPath)
 						wmAlgorithm = "visible-only"
 					} else {
@@ -293,7 +701,7 @@ func (p *Pool) processJob(ctx context.Context, job *model.Job) error {
 						wmAlgorithm = "dwtDctSvd-python"
 					}
 				} else {
-					slog.Warn("go invisible embed failed and python not configured, using visible-only output", "error", goErr)
+					slog.WarnContext(ctx, "go invisible embed failed and python not configured, using visible-only output", "error", goErr)
 					os.Rename(visibleOutput, outputPath)
 					wmAlgorithm = "visible-only"
 				}
@@ -328,24 +736,110 @@ func (p *Pool) processJob(ctx context.Context, job *model.Job) error {
 		return fmt.Errorf("activate token: %w", err)
 	}
 
-	db.InsertWatermarkIndex(p.database, payloadHex, job.TokenID, job.CampaignID, recipient.ID, wmAlgorithm)
+	entry := db.WatermarkIndexEntry{
+		PayloadHex: payloadHex, TokenID: job.TokenID, CampaignID: job.CampaignID, RecipientID: recipient.ID,
+		Algorithm: wmAlgorithm, SelfVerified: selfVerified,
+	}
+	// Only the Go image embed uses wmParams; Python embeds with imwatermark's
+	// defaults, which detection always tries.
+	if job.JobType == "watermark_image" && wmAlgorithm == "dwtDctSvd-go" {
+		entry.Channels, entry.Scale = wmChannels, &wmScale
+	}
+	db.InsertWatermarkIndex(p.database, entry)
 
 	p.publishTokenReady(job)
 
 	return nil
 }
 
+// lowChromaInput applies WM_LOW_CHROMA to an image input that
+// watermark.IsEmbeddable rejects. It returns the path the visible step should
+// read, whether the invisible embed should still run, and whether that path
+// is a temporary full-colour copy the caller must remove. The check is
+// cached for the asset version (assetID, sha) at inputPath.
+func (p *Pool) lowChromaInput(job *model.Job, assetID, sha, inputPath, outDir string) (string, bool, bool) {
+	embeddable, paletted, err := p.checkEmbeddable(assetID, sha, inputPath)
+	if err != nil {
+		// Formats the Go decoders do not handle are left to the embed step.
+		return inputPath, true, false
+	}
+	if embeddable {
+		return inputPath, true, false
+	}
+	switch p.cfg.WMLowChroma {
+	case watermark.LowChromaVisible:
+		slog.Warn("image too flat in colour for invisible watermark, using visible only", "token", job.TokenID, "indexed", paletted)
+		return inputPath, false, false
+	case watermark.LowChromaConvert:
+		if paletted {
+			converted := filepath.Join(outDir, job.TokenID+".truecolor.png")
+			if err := watermark.ConvertToTrueColor(inputPath, converted); err != nil {
+				os.Remove(converted)
+				slog.Warn("convert indexed image to full colour failed, embedding as is", "token", job.TokenID, "error", err)
+				return inputPath, true, false
+			}
+			return converted, true, true
+		}
+	}
+	slog.Warn("image too flat in colour, invisible watermark may not be detectable", "token", job.TokenID, "indexed", paletted)
+	return inputPath, true, false
+}
+
+// embeddability is a cached watermark.CheckEmbeddable result for one
+// version of an asset.
+type embeddability struct {
+	sha                  string
+	embeddable, paletted bool
+}
+
+// checkEmbeddable returns the embeddability of version sha of assetID,
+// decoding inputPath only when it is not cached. Errors are not cached, so a
+// transient read failure is retried by the next job.
+func (p *Pool) checkEmbeddable(assetID, sha, inputPath string) (bool, bool, error) {
+	if v, ok := p.embeddable.Load(assetID); ok {
+		if e := v.(embeddability); e.sha == sha {
+			return e.embeddable, e.paletted, nil
+		}
+	}
+	embeddable, paletted, err := watermark.CheckEmbeddable(inputPath)
+	if err != nil {
+		return false, false, err
+	}
+	p.embeddable.Store(assetID, embeddability{sha: sha, embeddable: embeddable, paletted: paletted})
+	return embeddable, paletted, nil
+}
+
+// ForgetAsset drops cached state for an asset that has been purged.
+func (p *Pool) ForgetAsset(assetID string) {
+	p.embeddable.Delete(assetID)
+}
+
 // detectResult is the JSON structure stored in result_data for detect jobs.
 type detectResult struct {
-	Found          bool   `json:"found"`
-	PayloadHex     string `json:"payload_hex"`
-	TokenID        string `json:"token_id,omitempty"`
-	CampaignID     string `json:"campaign_id,omitempty"`
-	CampaignName   string `json:"campaign_name,omitempty"`
-	RecipientName  string `json:"recipient_name,omitempty"`
-	RecipientEmail string `json:"recipient_email,omitempty"`
-	RecipientOrg   string `json:"recipient_org,omitempty"`
-	Message        string `json:"message,omitempty"`
+	Found          bool    `json:"found"`
+	PayloadHex     string  `json:"payload_hex"`
+	TokenID        string  `json:"token_id,omitempty"`
+	CampaignID     string  `json:"campaign_id,omitempty"`
+	CampaignName   string  `json:"campaign_name,omitempty"`
+	RecipientName  string  `json:"recipient_name,omitempty"`
+	RecipientEmail string  `json:"recipient_email,omitempty"`
+	RecipientOrg   string  `json:"recipient_org,omitempty"`
+	RecipientID    string  `json:"recipient_id,omitempty"`
+	MatchType      string  `json:"match_type,omitempty"` // "exact" (CRC valid) or "fuzzy"
+	Confidence     float64 `json:"confidence,omitempty"` // 1 for exact; share of matching token hex digits for fuzzy
+	Message        string  `json:"message,omitempty"`
+	// Variants lists the per-file detections a combined result was voted
+	// from; empty for single-file detect jobs.
+	Variants []detectVariant `json:"variants,omitempty"`
+}
+
+// detectVariant is one file of a combined detect job.
+type detectVariant struct {
+	File       string  `json:"file"`
+	PayloadHex string  `json:"payload_hex,omitempty"` // empty when nothing was detected
+	TokenID    string  `json:"token_id,omitempty"`
+	MatchType  string  `json:"match_type,omitempty"`
+	Confidence float64 `json:"confidence,omitempty"`
 }
 
 func (p *Pool) processDetectJob(ctx context.Context, job *model.Job) error {
@@ -353,56 +847,110 @@ func (p *Pool) processDetectJob(ctx context.Context, job *model.Job) error {
 	if inputPath == "" {
 		return fmt.Errorf("detect job has no input_path")
 	}
+	if fi, err := os.Stat(inputPath); err == nil && fi.IsDir() {
+		return p.processCombinedDetectJob(ctx, job)
+	}
 
-	// Determine file type
-	ext := strings.ToLower(filepath.Ext(inputPath))
-	isVideo := ext == ".mp4" || ext == ".mkv" || ext == ".avi" || ext == ".mov" || ext == ".webm"
+	payloadHex, err := p.detectPayload(ctx, inputPath)
+	if err != nil {
+		result := detectResult{
+			Found:   false,
+			Message: "No watermark detected in file",
+		}
+		return p.saveDetectResult(job.ID, result)
+	}
 
+	result := p.matchPayload(job.ID, payloadHex)
+	if err := p.saveDetectResult(job.ID, result); err != nil {
+		return err
+	}
+	p.dispatchDetectionMatch(ctx, job, result)
+	return nil
+}
+
+// isVideoInput reports whether a detect input is a video, by extension.
+func isVideoInput(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".mp4", ".mkv", ".avi", ".mov", ".webm":
+		return true
+	}
+	return false
+}
+
+// detectPayload extracts the raw invisible watermark payload (hex) from one
+// image or video file.
+func (p *Pool) detectPayload(ctx context.Context, inputPath string) (string, error) {
 	var payloadHex string
 	var err error
 
-	if isVideo {
+	// HEIC/AVIF leaks are read from a PNG copy, like uploaded originals.
+	if watermark.IsTranscodedExt(filepath.Ext(inputPath)) {
+		pngPath := inputPath + ".png"
+		if err := watermark.TranscodeToPNG(ctx, inputPath, pngPath); err != nil {
+			return "", err
+		}
+		defer os.Remove(pngPath)
+		inputPath = pngPath
+	}
+
+	if isVideoInput(inputPath) {
 		// Video detection still uses Python (video frame detect not yet ported to Go).
 		var payloads []string
-		payloads, err = watermark.InvisibleVideoDetect(ctx, inputPath, p.pythonPath(), p.detectScriptPath(), watermark.PayloadLength)
+		payloads, err = watermark.InvisibleVideoDetect(ctx, inputPath, p.pythonPath(), p.detectScriptPath(), watermark.PayloadLength, p.frameSampling())
 		if err == nil && len(payloads) > 0 {
-			payloadHex = watermark.MajorityVote(payloads)
+			// Vote per bit: frames rarely decode identically after
+			// recompression. Keep the whole-payload winner only when it
+			// validates and the per-bit result does not.
+			payloadHex = watermark.MajorityVotePerBit(payloads)
+			if !validPayloadHex(payloadHex) {
+				if whole := watermark.MajorityVote(payloads); validPayloadHex(whole) {
+					payloadHex = whole
+				}
+			}
 		}
 	} else {
 		// Try Go-native detection first (handles both Go-embedded and Python-embedded files
 		// once cross-compatibility testing confirms parameter alignment).
-		payloadHex, err = watermark.GoInvisibleImageDetect(ctx, inputPath, watermark.PayloadLength)
+		payloadHex, err = p.goDetectImage(ctx, inputPath)
 		if err != nil || payloadHex == "" {
-			slog.Debug("go invisible detect failed or empty, falling back to python", "error", err)
+			slog.DebugContext(ctx, "go invisible detect failed or empty, falling back to python", "error", err)
 			// Fall back to Python detection for legacy files while Python is available.
 			if p.cfg.ScriptsDir != "" {
 				payloadHex, err = watermark.InvisibleImageDetect(ctx, inputPath, p.pythonPath(), p.detectScriptPath(), watermark.PayloadLength)
 			}
 		}
 	}
+	return payloadHex, err
+}
 
+// validPayloadHex reports whether payloadHex decodes to a payload whose CRC
+// validates.
+func validPayloadHex(payloadHex string) bool {
+	b, err := hex.DecodeString(payloadHex)
 	if err != nil {
-		result := detectResult{
-			Found:   false,
-			Message: "No watermark detected in file",
-		}
-		return p.saveDetectResult(job.ID, result)
+		return false
 	}
+	_, _, valid := watermark.ParsePayload(b)
+	return valid
+}
 
+// matchPayload resolves a detected payload to a recipient: an exact lookup
+// when the CRC validates, otherwise a fuzzy lookup on the token hash.
+func (p *Pool) matchPayload(jobID, payloadHex string) detectResult {
 	// Parse the payload
 	payloadBytes, decErr := hex.DecodeString(payloadHex)
 	if decErr != nil || len(payloadBytes) == 0 {
-		result := detectResult{
+		return detectResult{
 			Found:      false,
 			PayloadHex: payloadHex,
 			Message:    "No valid watermark detected in file",
 		}
-		return p.saveDetectResult(job.ID, result)
 	}
 
 	// Try exact payload match first (CRC validates)
 	tokenIDHex, _, valid := watermark.ParsePayload(payloadBytes)
 	var tokenID, campaignID, recipientID string
+	matchType, confidence := "exact", 1.0
 
 	if valid {
 		// Exact CRC match -- look up by exact token_id_hex
@@ -420,7 +968,9 @@ func (p *Pool) processDetectJob(ctx context.Context, job *model.Job) error {
 			var diffCount int
 			tokenID, campaignID, recipientID, diffCount, _ = db.LookupWatermarkIndexFuzzy(p.database, fuzzyTokenHex, 8)
 			if tokenID != "" {
-				slog.Info("fuzzy watermark match", "job", job.ID, "diff_chars", diffCount)
+				slog.Info("fuzzy watermark match", "job", jobID, "diff_chars", diffCount)
+				matchType = "fuzzy"
+				confidence = 1 - float64(diffCount)/float64(len(fuzzyTokenHex))
 			}
 		}
 	}
@@ -430,20 +980,22 @@ func (p *Pool) processDetectJob(ctx context.Context, job *model.Job) error {
 		if !valid {
 			msg = "Watermark found but payload CRC check failed; fuzzy match also failed"
 		}
-		result := detectResult{
+		return detectResult{
 			Found:      false,
 			PayloadHex: payloadHex,
 			Message:    msg,
 		}
-		return p.saveDetectResult(job.ID, result)
 	}
 
 	// Load details
 	result := detectResult{
-		Found:      true,
-		PayloadHex: payloadHex,
-		TokenID:    tokenID,
-		CampaignID: campaignID,
+		Found:       true,
+		PayloadHex:  payloadHex,
+		TokenID:     tokenID,
+		CampaignID:  campaignID,
+		RecipientID: recipientID,
+		MatchType:   matchType,
+		Confidence:  confidence,
 	}
 
 	if campaign, err := db.GetCampaign(p.database, campaignID); err == nil && campaign != nil {
@@ -454,8 +1006,87 @@ func (p *Pool) processDetectJob(ctx context.Context, job *model.Job) error {
 		result.RecipientEmail = recipient.Email
 		result.RecipientOrg = recipient.Org
 	}
+	return result
+}
 
-	return p.saveDetectResult(job.ID, result)
+// unmatchedVoteWeight is the vote weight of a variant whose own payload
+// matched no recipient: it still carries mostly-correct bits, but counts for
+// less than the weakest fuzzy match.
+const unmatchedVoteWeight = 0.25
+
+// processCombinedDetectJob handles a detect job whose input_path is a
+// directory of variants of one leaked file (POST /api/v1/detect/combine).
+// Each variant is detected on its own, then the payloads are combined with
+// a per-bit vote weighted by each variant's match confidence, and the
+// combined payload is matched like a single detection.
+func (p *Pool) processCombinedDetectJob(ctx context.Context, job *model.Job) error {
+	entries, err := os.ReadDir(job.InputPath)
+	if err != nil {
+		return fmt.Errorf("read detect inputs: %w", err)
+	}
+
+	var payloads []string
+	var weights []float64
+	var variants []detectVariant
+	for i, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		v := detectVariant{File: e.Name()}
+		payloadHex, err := p.detectPayload(ctx, filepath.Join(job.InputPath, e.Name()))
+		if err == nil && len(payloadHex) == watermark.PayloadLength*2 {
+			single := p.matchPayload(job.ID, payloadHex)
+			weight := unmatchedVoteWeight
+			if single.Found {
+				weight = single.Confidence
+			}
+			v.PayloadHex, v.TokenID, v.MatchType, v.Confidence = payloadHex, single.TokenID, single.MatchType, single.Confidence
+			payloads = append(payloads, payloadHex)
+			weights = append(weights, weight)
+		}
+		variants = append(variants, v)
+		db.UpdateJobProgress(p.database, job.ID, (i+1)*100/(len(entries)+1))
+	}
+
+	if len(payloads) == 0 {
+		return p.saveDetectResult(job.ID, detectResult{
+			Found:    false,
+			Message:  "No watermark detected in any file",
+			Variants: variants,
+		})
+	}
+
+	result := p.matchPayload(job.ID, watermark.WeightedBitVote(payloads, weights))
+	result.Variants = variants
+	if err := p.saveDetectResult(job.ID, result); err != nil {
+		return err
+	}
+	p.dispatchDetectionMatch(ctx, job, result)
+	return nil
+}
+
+// dispatchDetectionMatch sends the detection_complete webhook to the
+// submitter's account for a detect job that identified a recipient.
+func (p *Pool) dispatchDetectionMatch(ctx context.Context, job *model.Job, result detectResult) {
+	if p.webhook == nil || !result.Found {
+		return
+	}
+	p.webhook.Dispatch(ctx, job.AccountID, "detection_complete", map[string]interface{}{
+		"job_id":          job.ID,
+		"token_id":        result.TokenID,
+		"campaign_id":     result.CampaignID,
+		"campaign_name":   result.CampaignName,
+		"recipient_id":    result.RecipientID,
+		"recipient_name":  result.RecipientName,
+		"recipient_email": result.RecipientEmail,
+		"recipient_org":   result.RecipientOrg,
+		"match_type":      result.MatchType,
+		"confidence":      result.Confidence,
+		"payload_hex":     result.PayloadHex,
+	})
 }
 
 func (p *Pool) saveDetectResult(jobID string, result detectResult) error {
@@ -466,10 +1097,10 @@ func (p *Pool) saveDetectResult(jobID string, result detectResult) error {
 	return db.SetJobResult(p.database, jobID, string(data))
 }
 
-func (p *Pool) checkCampaignCompletion(campaignID string) {
+func (p *Pool) checkCampaignCompletion(ctx context.Context, campaignID string) {
 	total, completed, failed, pending, running, err := db.CountJobsByCampaignDetailed(p.database, campaignID)
 	if err != nil {
-		slog.Error("count jobs", "campaign", campaignID, "error", err)
+		slog.ErrorContext(ctx, "count jobs", "campaign", campaignID, "error", err)
 		return
 	}
 
@@ -481,6 +1112,12 @@ func (p *Pool) checkCampaignCompletion(campaignID string) {
 		return
 	}
 
+	// A cancelled publish leaves the campaign in DRAFT; jobs that were already
+	// running when it was cancelled must not move it to READY.
+	if c, err := db.GetCampaign(p.database, campaignID); err == nil && c != nil && c.State == "DRAFT" {
+		return
+	}
+
 	var newState string
 	switch {
 	case failed == 0:
@@ -491,10 +1128,10 @@ func (p *Pool) checkCampaignCompletion(campaignID string) {
 		newState = "PARTIAL"
 	}
 
-	slog.Info("campaign completion", "campaign", campaignID, "state", newState, "completed", completed, "failed", failed)
+	slog.InfoContext(ctx, "campaign completion", "campaign", campaignID, "state", newState, "completed", completed, "failed", failed)
 
 	if err := db.UpdateCampaignState(p.database, campaignID, newState); err != nil {
-		slog.Error("update campaign state", "campaign", campaignID, "error", err)
+		slog.ErrorContext(ctx, "update campaign state", "campaign", campaignID, "error", err)
 	}
 
 	campaign, err := db.GetCampaign(p.database, campaignID)
@@ -506,7 +1143,7 @@ func (p *Pool) checkCampaignCompletion(campaignID string) {
 
 	// Dispatch webhook with state info
 	if p.webhook != nil {
-		p.webhook.Dispatch(campaign.AccountID, "campaign_ready", map[string]interface{}{
+		p.webhook.Dispatch(ctx, campaign.AccountID, "campaign_ready", map[string]interface{}{
 			"campaign_id":      campaignID,
 			"campaign_name":    campaign.Name,
 			"state":            newState,
@@ -529,7 +1166,7 @@ func (p *Pool) checkCampaignCompletion(campaignID string) {
 				emailErr = p.mailer.SendCampaignFailed(account.Email, account.Email, campaign.Name, failed)
 			}
 			if emailErr != nil {
-				slog.Error("send campaign completion email", "error", emailErr, "state", newState)
+				slog.ErrorContext(ctx, "send campaign completion email", "error", emailErr, "state", newState)
 			}
 		}()
 	}
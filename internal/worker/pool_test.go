@@ -0,0 +1,687 @@
+package worker
+
+import (
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color/palette"
+	"image/png"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	downloadonce "github.com/YannKr/downloadonce"
+	"github.com/YannKr/downloadonce/internal/config"
+	"github.com/YannKr/downloadonce/internal/db"
+	"github.com/YannKr/downloadonce/internal/diskstat"
+	"github.com/YannKr/downloadonce/internal/model"
+	"github.com/YannKr/downloadonce/internal/watermark"
+	"github.com/YannKr/downloadonce/internal/webhook"
+)
+
+// testPool returns a Pool backed by a fresh migrated database in a temp dir.
+func testPool(t *testing.T) (*Pool, *sql.DB) {
+	t.Helper()
+	dataDir := t.TempDir()
+	database, err := db.Open(dataDir)
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	t.Cleanup(func() { database.Close() })
+	if err := db.Migrate(database, downloadonce.MigrationFS); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	cfg := &config.Config{DataDir: dataDir, WorkerCount: 1, WMCompressionFactor: 0.9}
+	return NewPool(database, cfg, nil, nil, nil), database
+}
+
+// seedCampaign inserts an account, asset, recipient, campaign and one PENDING
+// token, writes a placeholder original, and returns a watermark job for that
+// token (not enqueued).
+func seedCampaign(t *testing.T, database *sql.DB, dataDir string, assetSize int64) *model.Job {
+	t.Helper()
+	must := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	must(db.CreateAccount(database, &model.Account{ID: "acc", Email: "a@example.com", Name: "A", PasswordHash: "x", Role: "admin", Enabled: true}))
+	must(db.CreateAsset(database, &model.Asset{ID: "asset", AccountID: "acc", OriginalName: "in.png", AssetType: "image",
+		OriginalPath: "originals/asset.png", FileSize: assetSize, SHA256: "00", MimeType: "image/png"}))
+	must(db.CreateRecipient(database, &model.Recipient{ID: "rec", AccountID: "acc", Name: "Bob", Email: "bob@example.com"}))
+	must(db.CreateCampaign(database, &model.Campaign{ID: "camp", AccountID: "acc", AssetID: "asset", Name: "C", InvisibleWM: true, State: "PROCESSING"}))
+	must(db.CreateToken(database, &model.DownloadToken{ID: "tok", CampaignID: "camp", RecipientID: "rec", State: "PENDING"}))
+	must(os.MkdirAll(filepath.Join(dataDir, "originals"), 0o755))
+	must(os.WriteFile(filepath.Join(dataDir, "originals", "asset.png"), []byte("png"), 0o644))
+	return &model.Job{ID: "job", JobType: "watermark_image", CampaignID: "camp", TokenID: "tok"}
+}
+
+func TestCheckDiskSpace(t *testing.T) {
+	p, _ := testPool(t)
+	p.cfg.WorkerMinFreeBytes = 100
+	p.freeBytes = func(string) (uint64, error) { return 1000, nil }
+
+	if err := p.checkDiskSpace("/x", 900); err != nil {
+		t.Errorf("900+100 of 1000 free: unexpected error %v", err)
+	}
+	if err := p.checkDiskSpace("/x", 901); err == nil || !strings.Contains(err.Error(), "disk full") {
+		t.Errorf("901+100 of 1000 free: got %v, want disk full error", err)
+	}
+}
+
+func TestProcessJobDiskFull(t *testing.T) {
+	p, database := testPool(t)
+	job := seedCampaign(t, database, p.cfg.DataDir, 10<<20)
+	p.freeBytes = func(string) (uint64, error) { return 1 << 20, nil } // 1 MiB left
+
+	err := p.processJob(context.Background(), job)
+	if err == nil || !strings.Contains(err.Error(), "disk full") {
+		t.Fatalf("processJob: got %v, want disk full error", err)
+	}
+	if isPermanentFailure(err) {
+		t.Errorf("disk full should be retriable, got permanent")
+	}
+
+	outDir := filepath.Join(p.cfg.DataDir, "watermarked", job.CampaignID)
+	entries, _ := os.ReadDir(outDir)
+	if len(entries) != 0 {
+		t.Errorf("expected no output files, found %d", len(entries))
+	}
+	tok, _ := db.GetToken(database, job.TokenID)
+	if tok.State != "PENDING" {
+		t.Errorf("token state = %s, want PENDING", tok.State)
+	}
+}
+
+func TestJobFailureRetriesTransientErrors(t *testing.T) {
+	p, database := testPool(t)
+	job := seedCampaign(t, database, p.cfg.DataDir, 1)
+	if err := db.EnqueueJob(database, job); err != nil {
+		t.Fatal(err)
+	}
+
+	for attempt := 1; attempt <= 3; attempt++ {
+		p.handleJobFailure(context.Background(), job, errors.New("python exited with status 1"))
+		got, _ := db.GetJob(database, job.ID)
+		if got.State != "PENDING" || got.RetryCount != attempt {
+			t.Fatalf("attempt %d: state=%s retry_count=%d, want PENDING/%d", attempt, got.State, got.RetryCount, attempt)
+		}
+		var nextRetry sql.NullString
+		database.QueryRow(`SELECT next_retry_at FROM jobs WHERE id = ?`, job.ID).Scan(&nextRetry)
+		if !nextRetry.Valid {
+			t.Fatalf("attempt %d: next_retry_at not set", attempt)
+		}
+		job = got
+	}
+
+	// A job waiting out its backoff is not claimable.
+	if claimed, _ := db.ClaimNextJob(database, []string{job.JobType}); claimed != nil {
+		t.Errorf("claimed job %s before next_retry_at", claimed.ID)
+	}
+
+	// Retries exhausted: the next failure is final.
+	p.handleJobFailure(context.Background(), job, errors.New("python exited with status 1"))
+	if got, _ := db.GetJob(database, job.ID); got.State != "FAILED" {
+		t.Errorf("after max retries: state=%s, want FAILED", got.State)
+	}
+}
+
+func TestJobFailureMissingInputIsPermanent(t *testing.T) {
+	p, database := testPool(t)
+	job := seedCampaign(t, database, p.cfg.DataDir, 1)
+	if err := db.EnqueueJob(database, job); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(filepath.Join(p.cfg.DataDir, "originals", "asset.png"))
+
+	err := p.processJob(context.Background(), job)
+	if err == nil || !isPermanentFailure(err) {
+		t.Fatalf("processJob: got %v, want permanent error", err)
+	}
+
+	p.handleJobFailure(context.Background(), job, err)
+	got, _ := db.GetJob(database, job.ID)
+	if got.State != "FAILED" || got.RetryCount != 0 {
+		t.Errorf("state=%s retry_count=%d, want FAILED/0", got.State, got.RetryCount)
+	}
+}
+
+func TestInvisibleParamsInheritGlobalDefaults(t *testing.T) {
+	p, _ := testPool(t)
+	p.cfg.WMChannels = "U,V"
+	p.cfg.WMScale = 48
+
+	mustParams := func(channels string, scale float64) watermark.InvisibleParams {
+		t.Helper()
+		params, err := watermark.NewInvisibleParams(channels, scale)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return params
+	}
+	scale20 := 20.0
+
+	tests := []struct {
+		name     string
+		campaign model.Campaign
+		want     watermark.InvisibleParams
+	}{
+		{"unset", model.Campaign{}, mustParams("U,V", 48)},
+		{"channels override", model.Campaign{WMChannels: "Y"}, mustParams("Y", 48)},
+		{"scale override", model.Campaign{WMScale: &scale20}, mustParams("U,V", 20)},
+	}
+	for _, tt := range tests {
+		got, err := p.invisibleParams(&tt.campaign)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, got, tt.want)
+		}
+	}
+
+	// With no deployment defaults configured, imwatermark's defaults apply.
+	p.cfg.WMChannels, p.cfg.WMScale = "", 0
+	if got, _ := p.invisibleParams(&model.Campaign{}); got != watermark.DefaultInvisibleParams() {
+		t.Errorf("zero config: got %s, want %s", got, watermark.DefaultInvisibleParams())
+	}
+}
+
+func TestSelfVerifyCampaignOverride(t *testing.T) {
+	p, _ := testPool(t)
+	on, off := true, false
+	for _, global := range []bool{true, false} {
+		p.cfg.WMSelfVerify = global
+		if got := selfVerifyEnabled(p.cfg, &model.Campaign{}); got != global {
+			t.Errorf("global %v, no override: got %v", global, got)
+		}
+		if !selfVerifyEnabled(p.cfg, &model.Campaign{SelfVerify: &on}) || selfVerifyEnabled(p.cfg, &model.Campaign{SelfVerify: &off}) {
+			t.Errorf("global %v: campaign override ignored", global)
+		}
+	}
+}
+
+func TestCancelledCampaignDropsRunningJob(t *testing.T) {
+	p, database := testPool(t)
+	job := seedCampaign(t, database, p.cfg.DataDir, 1)
+	if err := db.EnqueueJob(database, job); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.UpdateCampaignState(database, "camp", "DRAFT"); err != nil {
+		t.Fatal(err)
+	}
+
+	err := p.processJob(context.Background(), job)
+	if !errors.Is(err, errJobCancelled) {
+		t.Fatalf("processJob: got %v, want errJobCancelled", err)
+	}
+
+	// A campaign settling after the cancel must stay in DRAFT.
+	if _, err := database.Exec(`UPDATE jobs SET state = 'COMPLETED' WHERE id = ?`, job.ID); err != nil {
+		t.Fatal(err)
+	}
+	p.checkCampaignCompletion(context.Background(), "camp")
+	if c, _ := db.GetCampaign(database, "camp"); c.State != "DRAFT" {
+		t.Errorf("campaign state = %s, want DRAFT", c.State)
+	}
+}
+
+func TestDetectConcurrencyBounded(t *testing.T) {
+	p, database := testPool(t)
+	p.cfg.WorkerCount = 3
+	p.detectSlots = make(chan struct{}, 1)
+	seedCampaign(t, database, p.cfg.DataDir, 1)
+
+	// Detect jobs are queued first, so without the cap they would occupy
+	// every worker.
+	for _, id := range []string{"d1", "d2", "d3"} {
+		if err := db.EnqueueDetectJob(database, id, "acc", "/leak.png", "detect", ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, id := range []string{"w1", "w2", "w3"} {
+		if err := db.EnqueueJob(database, &model.Job{ID: id, JobType: "watermark_image", CampaignID: "camp", TokenID: "tok"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var running, maxRunning atomic.Int32
+	unblock := make(chan struct{})
+	watermarked := make(chan string, 3)
+	p.runJob = func(ctx context.Context, job *model.Job) error {
+		if job.JobType != "detect" {
+			watermarked <- job.ID
+			return nil
+		}
+		n := running.Add(1)
+		defer running.Add(-1)
+		for {
+			m := maxRunning.Load()
+			if n <= m || maxRunning.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		<-unblock
+		return nil
+	}
+
+	p.Start(context.Background())
+	defer p.Stop()
+	released := false
+	defer func() {
+		if !released {
+			close(unblock)
+		}
+	}()
+
+	// All watermark jobs finish while a detection is still blocked.
+	for i := 0; i < 3; i++ {
+		select {
+		case <-watermarked:
+		case <-time.After(10 * time.Second):
+			t.Fatalf("only %d of 3 watermark jobs ran while detection was busy", i)
+		}
+	}
+	if got := maxRunning.Load(); got != 1 {
+		t.Errorf("max concurrent detect jobs = %d, want 1", got)
+	}
+	close(unblock)
+	released = true
+
+	deadline := time.Now().Add(10 * time.Second)
+	for _, id := range []string{"d1", "d2", "d3"} {
+		for {
+			job, _ := db.GetJob(database, id)
+			if job.State == "COMPLETED" {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("detect job %s stuck in %s", id, job.State)
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+	if got := maxRunning.Load(); got != 1 {
+		t.Errorf("max concurrent detect jobs = %d, want 1", got)
+	}
+}
+
+func TestDetectionMatchWebhook(t *testing.T) {
+	p, database := testPool(t)
+	seedCampaign(t, database, p.cfg.DataDir, 1)
+
+	got := make(chan []byte, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		got <- body
+	}))
+	defer srv.Close()
+	if err := db.CreateWebhook(database, &model.Webhook{ID: "wh", AccountID: "acc", URL: srv.URL, Secret: "s",
+		Events: "detection_complete", Enabled: true}); err != nil {
+		t.Fatal(err)
+	}
+	p.webhook = &webhook.Dispatcher{DB: database}
+	job := &model.Job{ID: "det", JobType: "detect", AccountID: "acc"}
+
+	p.dispatchDetectionMatch(context.Background(), job, detectResult{Found: false})
+	var n int
+	database.QueryRow(`SELECT COUNT(*) FROM webhook_deliveries`).Scan(&n)
+	if n != 0 {
+		t.Fatalf("deliveries after a miss = %d, want 0", n)
+	}
+
+	p.dispatchDetectionMatch(context.Background(), job, detectResult{Found: true, TokenID: "tok", CampaignID: "camp", RecipientID: "rec",
+		RecipientName: "Bob", MatchType: "fuzzy", Confidence: 0.9})
+	var ev struct {
+		EventType string `json:"event_type"`
+		Data      struct {
+			JobID       string  `json:"job_id"`
+			RecipientID string  `json:"recipient_id"`
+			MatchType   string  `json:"match_type"`
+			Confidence  float64 `json:"confidence"`
+		} `json:"data"`
+	}
+	select {
+	case body := <-got:
+		if err := json.Unmarshal(body, &ev); err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook not delivered")
+	}
+	if ev.EventType != "detection_complete" || ev.Data.JobID != "det" || ev.Data.RecipientID != "rec" ||
+		ev.Data.MatchType != "fuzzy" || ev.Data.Confidence != 0.9 {
+		t.Errorf("event = %+v", ev)
+	}
+}
+
+func TestLowChromaInput(t *testing.T) {
+	p, _ := testPool(t)
+	dir := t.TempDir()
+	in := filepath.Join(dir, "indexed.png")
+	f, err := os.Create(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(f, image.NewPaletted(image.Rect(0, 0, 32, 32), palette.Plan9[:4])); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	job := &model.Job{ID: "j", TokenID: "tok"}
+
+	for _, tt := range []struct {
+		mode      string
+		invisible bool
+		converted bool
+	}{
+		{"warn", true, false},
+		{"visible", false, false},
+		{"convert", true, true},
+	} {
+		p.cfg.WMLowChroma = tt.mode
+		path, invisible, converted := p.lowChromaInput(job, "asset", "sha", in, dir)
+		if invisible != tt.invisible || converted != tt.converted {
+			t.Errorf("%s: invisible=%v converted=%v, want %v %v", tt.mode, invisible, converted, tt.invisible, tt.converted)
+		}
+		if converted {
+			if path == in {
+				t.Errorf("%s: converted path is the input", tt.mode)
+			}
+			if _, err := os.Stat(path); err != nil {
+				t.Errorf("%s: converted copy: %v", tt.mode, err)
+			}
+		}
+	}
+
+	// The check is cached per asset version: a later token reuses it even
+	// though the source is gone, while a new version decodes again.
+	os.Remove(in)
+	p.cfg.WMLowChroma = "visible"
+	if _, invisible, _ := p.lowChromaInput(job, "asset", "sha", in, dir); invisible {
+		t.Error("cached check not reused")
+	}
+	if _, invisible, _ := p.lowChromaInput(job, "asset", "sha2", in, dir); !invisible {
+		t.Error("new asset version used the cached check")
+	}
+
+	// That failed read is not cached: once the file is back it is decoded,
+	// and ForgetAsset drops the entry again.
+	f, err = os.Create(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	png.Encode(f, image.NewPaletted(image.Rect(0, 0, 32, 32), palette.Plan9[:4]))
+	f.Close()
+	if _, invisible, _ := p.lowChromaInput(job, "asset", "sha2", in, dir); invisible {
+		t.Error("failed check was cached")
+	}
+	os.Remove(in)
+	p.ForgetAsset("asset")
+	if _, invisible, _ := p.lowChromaInput(job, "asset", "sha2", in, dir); !invisible {
+		t.Error("forgotten asset used the cached check")
+	}
+}
+
+func TestThumbnailJob(t *testing.T) {
+	p, database := testPool(t)
+	seedCampaign(t, database, p.cfg.DataDir, 0)
+
+	src := filepath.Join(p.cfg.DataDir, "originals", "asset.png")
+	f, err := os.Create(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	png.Encode(f, image.NewRGBA(image.Rect(0, 0, 800, 600)))
+	f.Close()
+	// Thumbnails live in the asset's own directory under originals/.
+	if err := os.MkdirAll(filepath.Join(p.cfg.DataDir, "originals", "asset"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.EnqueueThumbnailJob(database, "thumb-job", "acc", "asset", ""); err != nil {
+		t.Fatal(err)
+	}
+	if pending, _ := db.HasPendingThumbnailJob(database, "asset"); !pending {
+		t.Fatal("thumbnail job not pending after enqueue")
+	}
+	job, release, err := p.claimJob()
+	if err != nil || job == nil {
+		t.Fatalf("claim: %v, %v", job, err)
+	}
+	release()
+	if job.JobType != "thumbnail" || job.AssetID != "asset" {
+		t.Fatalf("claimed %s for asset %q", job.JobType, job.AssetID)
+	}
+	if err := p.dispatchJob(context.Background(), job); err != nil {
+		t.Fatal(err)
+	}
+	thumb, err := os.Open(filepath.Join(p.cfg.DataDir, "originals", "asset", "thumb.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer thumb.Close()
+	cfg, _, err := image.DecodeConfig(thumb)
+	if err != nil || cfg.Width != watermark.PreviewWidth || cfg.Height != 300 {
+		t.Errorf("thumbnail = %dx%d, %v; want %dx300", cfg.Width, cfg.Height, err, watermark.PreviewWidth)
+	}
+
+	// A job whose asset was deleted is not retried.
+	err = p.dispatchJob(context.Background(), &model.Job{ID: "gone", JobType: "thumbnail", AssetID: "missing"})
+	if !isPermanentFailure(err) {
+		t.Errorf("missing asset: err = %v, want permanent", err)
+	}
+}
+
+func TestCombinedDetectVotesVariants(t *testing.T) {
+	p, database := testPool(t)
+	seedCampaign(t, database, p.cfg.DataDir, 0)
+	payload := watermark.BuildPayload("tok", "camp")
+	if err := db.InsertWatermarkIndex(database, db.WatermarkIndexEntry{
+		PayloadHex: hex.EncodeToString(payload), TokenID: "tok", CampaignID: "camp", RecipientID: "rec", Algorithm: "go",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	rng := rand.New(rand.NewSource(3))
+	src := image.NewNRGBA(image.Rect(0, 0, 256, 256))
+	for i := range src.Pix {
+		src.Pix[i] = uint8(rng.Intn(256))
+	}
+	dir := t.TempDir()
+	srcPath := filepath.Join(dir, "src.png")
+	f, err := os.Create(srcPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	png.Encode(f, src)
+	f.Close()
+
+	// Each variant carries the payload with a different bit of nine of the
+	// sixteen token-hash hex digits flipped: too many for the exact or fuzzy
+	// lookup on its own, but no bit is wrong in more than one variant.
+	params, _ := resolveInvisibleParams(p.cfg, "", nil)
+	inputs := filepath.Join(p.cfg.DataDir, "detect", "combo")
+	if err := os.MkdirAll(inputs, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for v, first := range []int{0, 4, 7} {
+		variant := append([]byte(nil), payload...)
+		for k := first; k < first+9; k++ {
+			shift := v
+			if k%2 == 0 {
+				shift += 4
+			}
+			variant[2+k/2] ^= 1 << shift
+		}
+		out := filepath.Join(inputs, fmt.Sprintf("%02d-leak.png", v))
+		if err := watermark.GoInvisibleImageEmbedParams(context.Background(), srcPath, out, hex.EncodeToString(variant), 0, params); err != nil {
+			t.Fatal(err)
+		}
+		if single := p.matchPayload("combo", hex.EncodeToString(variant)); single.Found {
+			t.Fatalf("variant %d matches on its own", v)
+		}
+	}
+
+	if err := db.EnqueueDetectJob(database, "combo", "acc", inputs, "detect", ""); err != nil {
+		t.Fatal(err)
+	}
+	job, _ := db.GetJob(database, "combo")
+	if err := p.processDetectJob(context.Background(), job); err != nil {
+		t.Fatal(err)
+	}
+	job, _ = db.GetJob(database, "combo")
+	var result detectResult
+	if err := json.Unmarshal([]byte(job.ResultData), &result); err != nil {
+		t.Fatal(err)
+	}
+	if !result.Found || result.TokenID != "tok" || result.RecipientID != "rec" || result.MatchType != "exact" {
+		t.Errorf("combined result = %+v, want an exact match for tok", result)
+	}
+	if len(result.Variants) != 3 {
+		t.Fatalf("variants = %+v, want 3", result.Variants)
+	}
+	for _, v := range result.Variants {
+		if v.PayloadHex == "" || v.TokenID != "" {
+			t.Errorf("variant %+v: want a detected payload that matched nothing", v)
+		}
+	}
+}
+
+func TestDetectAfterDefaultParamsChange(t *testing.T) {
+	p, database := testPool(t)
+	seedCampaign(t, database, p.cfg.DataDir, 0)
+
+	// Embed with the deployment defaults of the time...
+	p.cfg.WMChannels, p.cfg.WMScale = "V", 30
+	channels, scale := invisibleSettings(p.cfg, "", nil)
+	params, err := watermark.NewInvisibleParams(channels, scale)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := hex.EncodeToString(watermark.BuildPayload("tok", "camp"))
+	if err := db.InsertWatermarkIndex(database, db.WatermarkIndexEntry{
+		PayloadHex: payload, TokenID: "tok", CampaignID: "camp", RecipientID: "rec",
+		Algorithm: "dwtDctSvd-go", Channels: channels, Scale: &scale,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	rng := rand.New(rand.NewSource(5))
+	src := image.NewNRGBA(image.Rect(0, 0, 256, 256))
+	for i := range src.Pix {
+		src.Pix[i] = uint8(rng.Intn(256))
+	}
+	dir := t.TempDir()
+	srcPath := filepath.Join(dir, "src.png")
+	f, err := os.Create(srcPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	png.Encode(f, src)
+	f.Close()
+	inputs := filepath.Join(p.cfg.DataDir, "detect", "later")
+	if err := os.MkdirAll(inputs, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := watermark.GoInvisibleImageEmbedParams(context.Background(), srcPath, filepath.Join(inputs, "leak.png"), payload, 0, params); err != nil {
+		t.Fatal(err)
+	}
+
+	// ...then change them before the leak turns up.
+	p.cfg.WMChannels, p.cfg.WMScale = "Y", 50
+	if err := db.EnqueueDetectJob(database, "later", "acc", inputs, "detect", ""); err != nil {
+		t.Fatal(err)
+	}
+	job, _ := db.GetJob(database, "later")
+	if err := p.processDetectJob(context.Background(), job); err != nil {
+		t.Fatal(err)
+	}
+	job, _ = db.GetJob(database, "later")
+	var result detectResult
+	if err := json.Unmarshal([]byte(job.ResultData), &result); err != nil {
+		t.Fatal(err)
+	}
+	if !result.Found || result.TokenID != "tok" || result.MatchType != "exact" {
+		t.Errorf("result = %+v, want an exact match for tok with the recorded params", result)
+	}
+}
+
+type fakeDisk struct{ stats atomic.Pointer[diskstat.Stats] }
+
+func (d *fakeDisk) Get() diskstat.Stats { return *d.stats.Load() }
+
+func (d *fakeDisk) setPctFree(pct uint64) {
+	d.stats.Store(&diskstat.Stats{TotalBytes: 100, FreeBytes: pct, CapturedAt: time.Now()})
+}
+
+func TestDiskPressurePausesWatermarkJobs(t *testing.T) {
+	p, database := testPool(t)
+	p.cfg.DiskPauseWorkers = true
+	p.cfg.DiskWarnYellowPct, p.cfg.DiskWarnRedPct, p.cfg.DiskWarnBlockPct = 20, 10, 5
+	disk := &fakeDisk{}
+	disk.setPctFree(3)
+	p.DiskCache = disk
+	seedCampaign(t, database, p.cfg.DataDir, 1)
+
+	if err := db.EnqueueJob(database, &model.Job{ID: "w1", JobType: "watermark_image", CampaignID: "camp", TokenID: "tok"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.EnqueueDetectJob(database, "d1", "acc", "/leak.png", "detect", ""); err != nil {
+		t.Fatal(err)
+	}
+	claim := func() *model.Job {
+		t.Helper()
+		job, release, err := p.claimJob()
+		if err != nil {
+			t.Fatal(err)
+		}
+		release()
+		return job
+	}
+
+	// Detections keep running under pressure unless DISK_PAUSE_DETECT is set.
+	p.cfg.DiskPauseDetect = true
+	if job := claim(); job != nil {
+		t.Fatalf("claimed %s while paused with detect paused", job.ID)
+	}
+	p.cfg.DiskPauseDetect = false
+	if job := claim(); job == nil || job.ID != "d1" {
+		t.Fatalf("claimed %v under pressure, want the detect job", job)
+	}
+	if job := claim(); job != nil {
+		t.Fatalf("claimed %s under pressure, want nothing", job.ID)
+	}
+	if !p.diskPaused.Load() {
+		t.Error("pause not recorded")
+	}
+
+	disk.setPctFree(40)
+	if job := claim(); job == nil || job.ID != "w1" {
+		t.Fatalf("claimed %v after space recovered, want w1", job)
+	}
+	if p.diskPaused.Load() {
+		t.Error("pool still paused after space recovered")
+	}
+
+	// DISK_PAUSE_WORKERS=false ignores disk pressure.
+	disk.setPctFree(1)
+	p.cfg.DiskPauseWorkers = false
+	if err := db.EnqueueJob(database, &model.Job{ID: "w2", JobType: "watermark_image", CampaignID: "camp", TokenID: "tok"}); err != nil {
+		t.Fatal(err)
+	}
+	if job := claim(); job == nil || job.ID != "w2" {
+		t.Fatalf("claimed %v with pausing disabled, want w2", job)
+	}
+}
@@ -0,0 +1,28 @@
+-- Recreate download_tokens so a recipient can hold more than one token per
+-- campaign (reissued links). Only one non-expired token per recipient is
+-- allowed; expired tokens are kept for history and watermark tracing.
+CREATE TABLE download_tokens_new (
+    id               TEXT PRIMARY KEY,
+    campaign_id      TEXT NOT NULL REFERENCES campaigns(id) ON DELETE CASCADE,
+    recipient_id     TEXT NOT NULL REFERENCES recipients(id),
+    max_downloads    INTEGER,
+    download_count   INTEGER NOT NULL DEFAULT 0,
+    state            TEXT NOT NULL DEFAULT 'PENDING'
+                       CHECK (state IN ('PENDING','ACTIVE','CONSUMED','EXPIRED')),
+    watermarked_path TEXT,
+    watermark_payload BLOB,
+    sha256_output    TEXT,
+    output_size_bytes INTEGER,
+    expires_at       TEXT,
+    created_at       TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ', 'now')),
+    wm_algorithm     TEXT
+);
+
+INSERT INTO download_tokens_new SELECT * FROM download_tokens;
+
+DROP TABLE download_tokens;
+ALTER TABLE download_tokens_new RENAME TO download_tokens;
+
+CREATE INDEX idx_tokens_campaign ON download_tokens(campaign_id);
+CREATE UNIQUE INDEX idx_tokens_campaign_recipient_live
+    ON download_tokens(campaign_id, recipient_id) WHERE state != 'EXPIRED';
@@ -0,0 +1,18 @@
+-- Recreate watermark_index without the foreign key on token_id. Imported
+-- entries may belong to tokens from another deployment (or a lost database),
+-- and attribution data should outlive the tokens it describes.
+CREATE TABLE watermark_index_new (
+    payload_hex  TEXT PRIMARY KEY,
+    token_id     TEXT NOT NULL,
+    campaign_id  TEXT NOT NULL,
+    recipient_id TEXT NOT NULL,
+    created_at   TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ', 'now')),
+    wm_algorithm TEXT NOT NULL DEFAULT 'dwtDctSvd-python'
+);
+
+INSERT INTO watermark_index_new SELECT * FROM watermark_index;
+
+DROP TABLE watermark_index;
+ALTER TABLE watermark_index_new RENAME TO watermark_index;
+
+CREATE INDEX idx_watermark_index_token ON watermark_index(token_id);
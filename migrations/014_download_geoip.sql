@@ -0,0 +1,3 @@
+-- Approximate location of the downloader, filled from GEOIP_DB_PATH when set.
+ALTER TABLE download_events ADD COLUMN country TEXT;
+ALTER TABLE download_events ADD COLUMN city TEXT;
@@ -0,0 +1,2 @@
+-- Result of the post-embed self-verify: 1 passed, 0 failed, NULL not checked.
+ALTER TABLE watermark_index ADD COLUMN self_verified INTEGER;
@@ -0,0 +1,3 @@
+-- Higher-priority jobs are claimed first (on-demand downloads before bulk publish).
+ALTER TABLE jobs ADD COLUMN priority INTEGER NOT NULL DEFAULT 0;
+CREATE INDEX IF NOT EXISTS idx_jobs_claim ON jobs(state, priority DESC, created_at);
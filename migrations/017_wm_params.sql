@@ -0,0 +1,4 @@
+-- Per-campaign overrides of the invisible watermark channels and scale.
+-- NULL inherits the deployment defaults (WM_CHANNELS / WM_SCALE).
+ALTER TABLE campaigns ADD COLUMN wm_channels TEXT;
+ALTER TABLE campaigns ADD COLUMN wm_scale REAL;
@@ -0,0 +1,11 @@
+-- Optional TOTP second factor. totp_secret is NULL until the account enrolls.
+ALTER TABLE accounts ADD COLUMN totp_secret TEXT;
+
+-- One-time recovery codes, stored as SHA-256 hex of the code.
+CREATE TABLE IF NOT EXISTS totp_recovery_codes (
+    id          TEXT PRIMARY KEY,
+    account_id  TEXT NOT NULL REFERENCES accounts(id) ON DELETE CASCADE,
+    code_hash   TEXT NOT NULL,
+    used_at     TEXT
+);
+CREATE INDEX IF NOT EXISTS idx_totp_recovery_codes_account ON totp_recovery_codes(account_id);
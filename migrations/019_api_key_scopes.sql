@@ -0,0 +1,4 @@
+-- API key scopes: read < write < admin. Existing keys keep the access they had.
+ALTER TABLE api_keys ADD COLUMN scopes TEXT NOT NULL DEFAULT 'write';
+UPDATE api_keys SET scopes = 'admin'
+ WHERE account_id IN (SELECT id FROM accounts WHERE role = 'admin');
@@ -0,0 +1,3 @@
+-- Recipient group pre-selected for new campaigns (and used by API campaign
+-- creation when no recipients are given). Cleared if the group is deleted.
+ALTER TABLE accounts ADD COLUMN default_group_id TEXT REFERENCES recipient_groups(id) ON DELETE SET NULL;
@@ -0,0 +1,4 @@
+-- Session list on the settings page: browser captured at login and the most
+-- recent client IP seen for the session.
+ALTER TABLE sessions ADD COLUMN user_agent TEXT NOT NULL DEFAULT '';
+ALTER TABLE sessions ADD COLUMN ip TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,3 @@
+-- Per-account override of MAX_CAMPAIGNS_PER_ACCOUNT. NULL uses the global
+-- setting, 0 means unlimited.
+ALTER TABLE accounts ADD COLUMN max_campaigns INTEGER;
@@ -0,0 +1,3 @@
+-- Optional per-chunk SHA-256 manifest supplied at upload init, as a JSON array
+-- of lowercase hex digests indexed by chunk number ('[]' when not supplied).
+ALTER TABLE upload_sessions ADD COLUMN chunk_hashes TEXT NOT NULL DEFAULT '[]';
@@ -0,0 +1,3 @@
+-- Optional API key expiry chosen at creation. NULL means the key never
+-- expires; expired keys are rejected and later pruned by the cleanup job.
+ALTER TABLE api_keys ADD COLUMN expires_at TEXT;
@@ -0,0 +1,3 @@
+-- Per-campaign Go text/template for the visible watermark overlay.
+-- NULL uses the default "[<short id> | <recipient name>]".
+ALTER TABLE campaigns ADD COLUMN wm_text_template TEXT;
@@ -0,0 +1,5 @@
+-- Per-campaign visible watermark placement, opacity (0-1) and font size.
+-- NULL keeps the default layout (corners plus a faint centre mark).
+ALTER TABLE campaigns ADD COLUMN visible_wm_position TEXT;
+ALTER TABLE campaigns ADD COLUMN visible_wm_opacity REAL;
+ALTER TABLE campaigns ADD COLUMN visible_wm_font_size INTEGER;
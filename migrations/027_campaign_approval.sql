@@ -0,0 +1,37 @@
+-- Approval workflow (REQUIRE_APPROVAL): campaigns wait in PENDING_APPROVAL
+-- until an admin signs off; approved_by/approved_at record who and when.
+-- Recreate campaigns to add the new state to the CHECK constraint.
+CREATE TABLE campaigns_new (
+    id                   TEXT PRIMARY KEY,
+    account_id           TEXT NOT NULL REFERENCES accounts(id) ON DELETE CASCADE,
+    asset_id             TEXT NOT NULL REFERENCES assets(id),
+    name                 TEXT NOT NULL,
+    max_downloads        INTEGER,
+    expires_at           TEXT,
+    visible_wm           INTEGER NOT NULL DEFAULT 1,
+    invisible_wm         INTEGER NOT NULL DEFAULT 1,
+    state                TEXT NOT NULL DEFAULT 'DRAFT'
+                           CHECK (state IN ('DRAFT','PENDING_APPROVAL','PROCESSING','READY','PARTIAL','FAILED','EXPIRED','ARCHIVED')),
+    created_at           TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ', 'now')),
+    published_at         TEXT,
+    wm_channels          TEXT,
+    wm_scale             REAL,
+    wm_text_template     TEXT,
+    visible_wm_position  TEXT,
+    visible_wm_opacity   REAL,
+    visible_wm_font_size INTEGER,
+    approved_by          TEXT,
+    approved_at          TEXT
+);
+
+INSERT INTO campaigns_new (id, account_id, asset_id, name, max_downloads, expires_at, visible_wm, invisible_wm,
+    state, created_at, published_at, wm_channels, wm_scale, wm_text_template,
+    visible_wm_position, visible_wm_opacity, visible_wm_font_size)
+SELECT id, account_id, asset_id, name, max_downloads, expires_at, visible_wm, invisible_wm,
+    state, created_at, published_at, wm_channels, wm_scale, wm_text_template,
+    visible_wm_position, visible_wm_opacity, visible_wm_font_size
+FROM campaigns;
+DROP TABLE campaigns;
+ALTER TABLE campaigns_new RENAME TO campaigns;
+
+CREATE INDEX idx_campaigns_account ON campaigns(account_id);
@@ -0,0 +1,9 @@
+-- Jobs record their owning account. Detect jobs used to keep the submitting
+-- account in campaign_id; move it to account_id and clear campaign_id.
+ALTER TABLE jobs ADD COLUMN account_id TEXT;
+
+UPDATE jobs SET account_id = campaign_id, campaign_id = '' WHERE job_type = 'detect';
+UPDATE jobs SET account_id = (SELECT c.account_id FROM campaigns c WHERE c.id = jobs.campaign_id)
+ WHERE account_id IS NULL;
+
+CREATE INDEX IF NOT EXISTS idx_jobs_account ON jobs(account_id, state);
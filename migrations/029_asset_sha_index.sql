@@ -0,0 +1,2 @@
+-- Uploads look up an account's existing asset by content hash to deduplicate.
+CREATE INDEX IF NOT EXISTS idx_assets_account_sha ON assets(account_id, sha256_original);
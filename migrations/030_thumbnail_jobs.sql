@@ -0,0 +1,4 @@
+-- Thumbnail jobs (DEFER_THUMBNAILS) refer to the asset they render.
+ALTER TABLE jobs ADD COLUMN asset_id TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_jobs_asset ON jobs(asset_id) WHERE asset_id IS NOT NULL;
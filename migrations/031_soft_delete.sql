@@ -0,0 +1,4 @@
+-- Deleted assets and campaigns are kept for a grace period (DELETE_GRACE_DAYS)
+-- so they can be restored; the cleanup scheduler purges them afterwards.
+ALTER TABLE assets ADD COLUMN deleted_at TEXT;
+ALTER TABLE campaigns ADD COLUMN deleted_at TEXT;
@@ -0,0 +1,3 @@
+-- Optional message shown to recipients on the public download page. Stored as
+-- entered; it is sanitized when rendered.
+ALTER TABLE campaigns ADD COLUMN download_message TEXT NOT NULL DEFAULT '';
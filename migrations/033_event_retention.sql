@@ -0,0 +1,2 @@
+-- Retention pruning scans download events by time.
+CREATE INDEX IF NOT EXISTS idx_events_downloaded_at ON download_events(downloaded_at);
@@ -0,0 +1,3 @@
+-- Token pages look up the latest job of each listed token
+-- (download_tokens(campaign_id) is already indexed by idx_tokens_campaign).
+CREATE INDEX IF NOT EXISTS idx_jobs_token ON jobs(token_id);
@@ -0,0 +1,3 @@
+-- ID of the HTTP request that queued each job, so worker logs can be traced
+-- back to it ('' for jobs queued outside a request).
+ALTER TABLE jobs ADD COLUMN request_id TEXT NOT NULL DEFAULT '';
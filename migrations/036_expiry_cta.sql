@@ -0,0 +1,5 @@
+-- What recipients see once their link is used up or expired: an optional
+-- message (sanitized when rendered, like download_message) and whether they
+-- may ask the owner for a new link.
+ALTER TABLE campaigns ADD COLUMN expiry_message TEXT NOT NULL DEFAULT '';
+ALTER TABLE campaigns ADD COLUMN allow_link_requests INTEGER NOT NULL DEFAULT 0;
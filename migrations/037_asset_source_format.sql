@@ -0,0 +1,4 @@
+-- HEIC and AVIF uploads are stored as PNG so the watermark pipeline can read
+-- them; source_mime_type keeps the format the file was uploaded in ('' when
+-- the original is stored as uploaded).
+ALTER TABLE assets ADD COLUMN source_mime_type TEXT NOT NULL DEFAULT '';
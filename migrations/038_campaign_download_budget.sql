@@ -0,0 +1,10 @@
+-- Campaign-wide download budget: max_total_downloads caps the downloads of
+-- all of a campaign's tokens together (NULL = no cap), and total_downloads is
+-- the counter the download handler increments against it, in the same
+-- transaction as the token's own count.
+ALTER TABLE campaigns ADD COLUMN max_total_downloads INTEGER;
+ALTER TABLE campaigns ADD COLUMN total_downloads INTEGER NOT NULL DEFAULT 0;
+
+UPDATE campaigns SET total_downloads = (
+    SELECT COALESCE(SUM(download_count), 0) FROM download_tokens WHERE campaign_id = campaigns.id
+);
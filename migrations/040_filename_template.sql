@@ -0,0 +1,4 @@
+-- Per-campaign template for the name recipients' downloads are saved under
+-- (text/template over the same fields as the visible watermark text). Empty
+-- keeps the DOWNLOAD_FILENAME behaviour.
+ALTER TABLE campaigns ADD COLUMN filename_template TEXT NOT NULL DEFAULT '';
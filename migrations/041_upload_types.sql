@@ -0,0 +1,3 @@
+-- Per-account override of ALLOWED_UPLOAD_TYPES (comma-separated MIME types,
+-- "image" or "video"). NULL uses the global setting.
+ALTER TABLE accounts ADD COLUMN allowed_upload_types TEXT;
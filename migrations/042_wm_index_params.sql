@@ -0,0 +1,5 @@
+-- Invisible watermark channels and scale used for each embed, so detection
+-- still tries them after WM_CHANNELS / WM_SCALE change. NULL when unknown
+-- (rows from before this migration, the Python fallback or visible-only).
+ALTER TABLE watermark_index ADD COLUMN wm_channels TEXT;
+ALTER TABLE watermark_index ADD COLUMN wm_scale REAL;
@@ -0,0 +1,3 @@
+-- Time step of the last TOTP code accepted at login, so a code can't be
+-- replayed within the skew window. NULL until the first two-factor login.
+ALTER TABLE accounts ADD COLUMN totp_last_step INTEGER;
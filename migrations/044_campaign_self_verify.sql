@@ -0,0 +1,3 @@
+-- Per-campaign override of WM_SELF_VERIFY for invisible image embeds.
+-- NULL inherits the server setting.
+ALTER TABLE campaigns ADD COLUMN wm_self_verify INTEGER;
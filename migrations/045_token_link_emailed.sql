@@ -0,0 +1,3 @@
+-- When the token's download link was emailed at publish, so publishing again
+-- after a cancel doesn't email the recipient a second time. NULL until sent.
+ALTER TABLE download_tokens ADD COLUMN link_emailed_at TEXT;
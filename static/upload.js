@@ -24,12 +24,35 @@
     });
   }
 
-  function uploadChunk(sessionId, index, blob) {
-    return fetch("/upload/chunks/" + sessionId + "/" + index, {
-      method: "PUT",
-      headers: { "X-CSRF-Token": getCsrfToken() },
-      body: blob
+  // chunkDigest resolves to the hex SHA-256 of blob, or "" where WebCrypto is
+  // unavailable (plain-HTTP origins other than localhost).
+  function chunkDigest(blob) {
+    if (!global.crypto || !global.crypto.subtle || !blob.arrayBuffer) return Promise.resolve("");
+    return blob.arrayBuffer().then(function(buf) {
+      return global.crypto.subtle.digest("SHA-256", buf);
+    }).then(function(digest) {
+      return Array.prototype.map.call(new Uint8Array(digest), function(b) {
+        return ("0" + b.toString(16)).slice(-2);
+      }).join("");
+    });
+  }
+
+  // uploadChunk sends one chunk, retrying a couple of times if the server
+  // reports a checksum mismatch (422).
+  function uploadChunk(sessionId, index, blob, attempt) {
+    attempt = attempt || 0;
+    return chunkDigest(blob).then(function(sum) {
+      var headers = { "X-CSRF-Token": getCsrfToken() };
+      if (sum) headers["X-Chunk-SHA256"] = sum;
+      return fetch("/upload/chunks/" + sessionId + "/" + index, {
+        method: "PUT",
+        headers: headers,
+        body: blob
+      });
     }).then(function(res) {
+      if (res.status === 422 && attempt < 2) {
+        return uploadChunk(sessionId, index, blob, attempt + 1);
+      }
       return res.json().then(function(data) {
         if (!res.ok) throw new Error(data.error || "HTTP " + res.status);
         return data;
@@ -76,11 +99,12 @@
         if (cancelled) return;
         if (idx >= chunkCount) {
           if (opts.onProgress) opts.onProgress(99, "Finalising...");
-          return jsonFetch("POST", "/upload/chunks/" + sessionId + "/complete", {}, {
+          var completeURL = "/upload/chunks/" + sessionId + "/complete" + (opts.dedupe ? "?dedupe=1" : "");
+          return jsonFetch("POST", completeURL, {}, {
             "X-CSRF-Token": getCsrfToken()
           }).then(function(result) {
             if (opts.onProgress) opts.onProgress(100, "Done");
-            if (opts.onComplete) opts.onComplete(result.asset_id);
+            if (opts.onComplete) opts.onComplete(result.asset_id, result);
           });
         }
         var start = idx * CHUNK_SIZE;